@@ -2,7 +2,9 @@ package auth
 
 import (
 	"context"
-	"crypto/hmac"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
@@ -12,16 +14,29 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+	"wonk/app/config"
 	"wonk/app/cuserr"
+	"wonk/app/notify"
+	"wonk/app/oidc"
+	"wonk/app/ratelimit"
 	"wonk/app/secret"
 	"wonk/app/templates/views"
+	"wonk/app/webauthn"
 	"wonk/business/user"
+	"wonk/storage"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 const (
-	COOKIE_NAME = "WonkAuth"
+	COOKIE_NAME         = "WonkAuth"
+	REFRESH_COOKIE_NAME = "WonkRefresh"
+	// Lifetime of the jwt and the cookie holding it, capped by the idle timeout
+	ACCESS_TOKEN_DURATION = 15 * time.Minute
+	// AuthMiddleware swaps the refresh token for a new jwt once the jwt is this close to expiring
+	REFRESH_WINDOW = 5 * time.Minute
+
+	LOGIN_FAILED_MSG = "invalid username or password"
 )
 
 var userCtxKey = &contextKey{"user"}
@@ -31,42 +46,123 @@ type contextKey struct {
 }
 
 type UserInfo struct {
-	UserName string
-	UserId   int
+	UserName  string
+	UserId    int
+	SessionId string
 }
 
 type AuthService interface {
 	HandleLogin() http.Handler
 	HandleSignUp() http.Handler
+	HandleLogout() http.Handler
+	HandleLogoutAll() http.Handler
+	HandleLoginTotp() http.Handler
+	HandleTwoFactor() http.Handler
+	HandleTwoFactorConfirm() http.Handler
+	HandleTwoFactorDisable() http.Handler
+	HandlePasskeyLoginOptions() http.Handler
+	HandlePasskeyLogin() http.Handler
+	HandlePasskeys() http.Handler
+	HandlePasskeyOptions() http.Handler
+	HandlePasskeyById() http.Handler
+	HandleChangePassword() http.Handler
+	HandlePasswordReset() http.Handler
+	HandlePasswordResetConfirm() http.Handler
+	HandleOidcLogin() http.Handler
+	HandleOidcCallback() http.Handler
+	HandleOidcIdentities() http.Handler
+	HandleOidcLink() http.Handler
+	HandleOidcIdentityById() http.Handler
 	AuthMiddleware(http.Handler) http.Handler
+	CSRFMiddleware(http.Handler) http.Handler
 }
 
 type Auth struct {
-	Logger          *slog.Logger
-	JwtSecretKey    string
-	CookieSecretKey string
-	User            user.User
+	Logger             *slog.Logger
+	JwtKeys            *secret.Keyring
+	CookieSecretKey    string
+	CookiePreviousKeys []string
+	User               user.User
+	IdleTimeout        time.Duration
+	AbsoluteTimeout    time.Duration
+	RelyingParty       *webauthn.RelyingParty
+	Sender             notify.Sender  // Delivers password reset links
+	BaseURL            string         // Where the links in emails point
+	Oidc               *oidc.Provider // nil when logging in through a provider isn't set up
+	OidcProviderName   string
+	OidcAutoProvision  bool
+	sessions           *sessionCache
+	loginLimits        *loginLimiter
 }
 
-func InitAuthService(s *secret.Secret, l *slog.Logger, u user.User) AuthService {
-	return &Auth{
-		Logger:          l,
-		JwtSecretKey:    s.JwtKey,
-		CookieSecretKey: s.CookieKey,
-		User:            u,
+func InitAuthService(s *secret.Secret, sc *config.Session, wc *config.WebAuthn, oc *config.OIDC, mc *config.Mail, ms notify.Sender, l *slog.Logger, u user.User) AuthService {
+	a := &Auth{
+		Logger:             l,
+		JwtKeys:            s.JwtKeys,
+		CookieSecretKey:    s.CookieKey,
+		CookiePreviousKeys: s.CookiePreviousKeys,
+		User:               u,
+		IdleTimeout:        sc.IdleTimeout,
+		AbsoluteTimeout:    sc.AbsoluteTimeout,
+		RelyingParty:       &webauthn.RelyingParty{ID: wc.RPID, Name: wc.RPName, Origins: wc.Origins},
+		Sender:             ms,
+		BaseURL:            mc.BaseURL,
+		sessions:           newSessionCache(SESSION_CACHE_TTL, SESSION_CACHE_SIZE),
+		loginLimits: newLoginLimiter(
+			ratelimit.NewMemoryStore(LOGIN_LIMIT_STORE_SIZE, LOGIN_LIMIT_STORE_TTL),
+			ratelimit.NewMemoryStore(LOGIN_LIMIT_STORE_SIZE, LOGIN_LIMIT_STORE_TTL),
+		),
 	}
+	if oc != nil {
+		a.Oidc = &oidc.Provider{
+			Issuer:       oc.Issuer,
+			ClientId:     oc.ClientId,
+			ClientSecret: oc.ClientSecret,
+			RedirectURL:  oc.RedirectURL,
+			Scopes:       oc.Scopes,
+		}
+		a.OidcProviderName = oc.ProviderName
+		a.OidcAutoProvision = oc.AutoProvision
+	}
+	return a
+}
+
+func (a *Auth) idleTimeout() time.Duration {
+	if a.IdleTimeout <= 0 {
+		return config.DEFAULT_SESSION_IDLE_TIMEOUT
+	}
+	return a.IdleTimeout
+}
+
+func (a *Auth) absoluteTimeout() time.Duration {
+	if a.AbsoluteTimeout <= 0 {
+		return config.DEFAULT_SESSION_ABSOLUTE_TIMEOUT
+	}
+	return a.AbsoluteTimeout
+}
+
+// A jwt can't outlive the idle timeout, otherwise an idle user would stay logged in until it expired
+func (a *Auth) accessDuration() time.Duration {
+	return min(ACCESS_TOKEN_DURATION, a.idleTimeout())
 }
 
-// IDEA: Look into kid for keys
-func (a *Auth) CreateToken(username string, userId int) (string, error) {
-	secretKey := []byte(a.JwtSecretKey)
+func (a *Auth) refreshWindow() time.Duration {
+	return min(REFRESH_WINDOW, a.accessDuration()/3)
+}
+
+// Signed with the active key, its id goes in the kid header so the key can be rotated.
+// The session id is the jti, AuthMiddleware rejects the token once its session is revoked
+func (a *Auth) CreateToken(username string, userId int, sessionId string) (string, error) {
+	keyId, secretKey := a.JwtKeys.Active()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256,
 		jwt.MapClaims{
 			"username": username,
 			"userId":   strconv.Itoa(userId),
-			"exp":      time.Now().Add(time.Hour * 24).Unix(),
+			"jti":      sessionId,
+			"exp":      time.Now().Add(a.accessDuration()).Unix(),
 		},
 	)
+	token.Header["kid"] = keyId
 	tokenString, err := token.SignedString(secretKey)
 	if err != nil {
 		return "", fmt.Errorf("CreateToken: %w", err)
@@ -75,11 +171,28 @@ func (a *Auth) CreateToken(username string, userId int) (string, error) {
 	return tokenString, nil
 }
 
-func (a *Auth) VerifyToken(tokenString string) error {
-	secretKey := []byte(a.JwtSecretKey)
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
+// Only HS256 is accepted so a token can't pick its own algorithm, and the key is looked up by
+// kid so tokens signed before a rotation still verify until their key is removed
+func (a *Auth) parseToken(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		keyId, ok := t.Header["kid"].(string)
+		if !ok || keyId == "" {
+			return nil, errors.New("kid not found in jwt header")
+		}
+		secretKey, ok := a.JwtKeys.Key(keyId)
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", keyId)
+		}
 		return secretKey, nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, fmt.Errorf("parseToken: %w", err)
+	}
+	return token, nil
+}
+
+func (a *Auth) VerifyToken(tokenString string) error {
+	token, err := a.parseToken(tokenString, jwt.MapClaims{})
 
 	if err != nil {
 		return fmt.Errorf("VerifyToken: %w", err)
@@ -93,11 +206,8 @@ func (a *Auth) VerifyToken(tokenString string) error {
 }
 
 func (a *Auth) ReadTokenUserName(tokenString string) (string, int, error) {
-	secretKey := []byte(a.JwtSecretKey)
 	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
-		return secretKey, nil
-	})
+	token, err := a.parseToken(tokenString, claims)
 	if err != nil {
 		return "", -1, fmt.Errorf("ReadTokenUserName: %w", err)
 	}
@@ -128,75 +238,116 @@ func (a *Auth) ReadTokenUserName(tokenString string) (string, int, error) {
 	return "", -1, errors.New("ReadTokenUserName: claims or vaild token error")
 }
 
-// IDEA: Encrypt cookie
-func (a *Auth) CreateSignedCookie(token string) (*http.Cookie, error) {
-	cookie := http.Cookie{
+func (a *Auth) ReadTokenSessionId(tokenString string) (string, error) {
+	claims := jwt.RegisteredClaims{}
+	_, err := a.parseToken(tokenString, &claims)
+	if err != nil {
+		return "", fmt.Errorf("ReadTokenSessionId: %w", err)
+	}
+	if claims.ID == "" {
+		return "", errors.New("ReadTokenSessionId: jti not found in jwt")
+	}
+	return claims.ID, nil
+}
+
+func (a *Auth) readTokenExpiry(tokenString string) (time.Time, error) {
+	claims := jwt.RegisteredClaims{}
+	_, err := a.parseToken(tokenString, &claims)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("readTokenExpiry: %w", err)
+	}
+	if claims.ExpiresAt == nil {
+		return time.Time{}, errors.New("readTokenExpiry: exp not found in jwt")
+	}
+	return claims.ExpiresAt.Time, nil
+}
+
+// Encrypts the jwt with AES-GCM so the browser can't read the username or id inside it.
+// The cookie name is authenticated with it so the value only works as the auth cookie.
+func (a *Auth) CreateEncryptedCookie(token string) (*http.Cookie, error) {
+	value, err := a.encryptCookieValue(COOKIE_NAME, token)
+	if err != nil {
+		return nil, fmt.Errorf("CreateEncryptedCookie: %w", err)
+	}
+	return &http.Cookie{
 		Name:     COOKIE_NAME,
-		Value:    token,
+		Value:    value,
 		Path:     "/",
-		MaxAge:   60 * 60,
+		MaxAge:   int(a.accessDuration().Seconds()),
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteLaxMode,
-	}
-	cookieSecretKey, err := hex.DecodeString(a.CookieSecretKey)
+	}, nil
+}
+
+func (a *Auth) ReadEncryptedCookie(cookie *http.Cookie) (string, error) {
+	value, err := a.decryptCookieValue(COOKIE_NAME, cookie.Value)
 	if err != nil {
-		return nil, fmt.Errorf("CreateSignedCookie: hex: %w", err)
+		return "", fmt.Errorf("ReadEncryptedCookie: %w", err)
 	}
-	mac := hmac.New(sha256.New, cookieSecretKey)
-	mac.Write([]byte(cookie.Name))
-	mac.Write([]byte(cookie.Value))
-	signature := mac.Sum(nil)
-
-	// Prepend the cookie value with the HMAC signature.
-	cookie.Value = string(signature) + cookie.Value
-
-	// Encode the cookie
-	cookie.Value = base64.URLEncoding.EncodeToString([]byte(cookie.Value))
-
-	return &cookie, nil
+	return value, nil
 }
 
-func (a *Auth) ReadSignedCookie(cookie *http.Cookie) (string, error) {
-	// Decode the cookie
-	decodedValue, err := base64.URLEncoding.DecodeString(cookie.Value)
+// The nonce is prepended so it can be read back in the format "{nonce}{ciphertext}".
+// name is the additional data, a value made for one cookie won't decrypt as another.
+func (a *Auth) encryptCookieValue(name, value string) (string, error) {
+	aead, err := cookieCipher(a.CookieSecretKey)
 	if err != nil {
-		return "", fmt.Errorf("Read: encoding: %w", err)
+		return "", fmt.Errorf("encryptCookieValue: %w", err)
 	}
-
-	// Read in the signed value from the cookie. This should be in the format "{signature}{original value}"
-	signedValue := string(decodedValue)
-
-	// A SHA256 HMAC signature has a fixed length of 32 bytes
-	if len(signedValue) < sha256.Size {
-		return "", errors.New("readSigned: sha256: invalid value")
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("encryptCookieValue: rand: %w", err)
 	}
+	encrypted := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return base64.URLEncoding.EncodeToString(encrypted), nil
+}
 
-	// Split apart the signature and original cookie value.
-	signature := signedValue[:sha256.Size]
-	value := signedValue[sha256.Size:]
-
-	// Recalculate the HMAC signature of the cookie name and original value.
-	cookieSecretKey, err := hex.DecodeString(a.CookieSecretKey)
+// Tries the current key first, then the previous keys so cookies from before a rotation still work
+func (a *Auth) decryptCookieValue(name, value string) (string, error) {
+	encrypted, err := base64.URLEncoding.DecodeString(value)
 	if err != nil {
-		return "", fmt.Errorf("CreateSignedCookie: hex: %w", err)
+		return "", fmt.Errorf("decryptCookieValue: encoding: %w", err)
 	}
-	mac := hmac.New(sha256.New, cookieSecretKey)
-	mac.Write([]byte(COOKIE_NAME))
-	mac.Write([]byte(value))
-	expectedSignature := mac.Sum(nil)
-
-	// Check that the recalculated signature matches the signature we received
-	// in the cookie. If they match, we can be confident that the cookie name
-	// and value haven't been edited by the client.
-	if !hmac.Equal([]byte(signature), expectedSignature) {
-		return "", errors.New("readSigned: hmac: invalid value")
+	for _, key := range append([]string{a.CookieSecretKey}, a.CookiePreviousKeys...) {
+		aead, err := cookieCipher(key)
+		if err != nil {
+			return "", fmt.Errorf("decryptCookieValue: %w", err)
+		}
+		if len(encrypted) < aead.NonceSize()+aead.Overhead() {
+			return "", errors.New("decryptCookieValue: gcm: value too short")
+		}
+		nonce, ciphertext := encrypted[:aead.NonceSize()], encrypted[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+		if err == nil {
+			return string(plain), nil
+		}
 	}
+	// Either the cookie was edited by the client or its key is no longer kept
+	return "", errors.New("decryptCookieValue: gcm: invalid value")
+}
 
-	// Return the original cookie value.
-	return value, nil
+// COOKIE_SECRET_KEY can be any length of hex, it's hashed into an AES-256 key
+func cookieCipher(hexKey string) (cipher.AEAD, error) {
+	cookieSecretKey, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("cookieCipher: hex: %w", err)
+	}
+	key := sha256.Sum256(cookieSecretKey)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("cookieCipher: aes: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cookieCipher: gcm: %w", err)
+	}
+	return aead, nil
 }
 
+// Reads the user from the jwt cookie. A jwt that is missing, expired or close to expiring is
+// replaced using the refresh cookie, which rotates the refresh token and keeps the session alive.
 func (a *Auth) AuthMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		handleRedirect := func() {
@@ -210,41 +361,136 @@ func (a *Auth) AuthMiddleware(h http.Handler) http.Handler {
 				w.WriteHeader(302)
 			}
 		}
-		c, err := r.Cookie(COOKIE_NAME)
-		if err != nil {
-			// Missing cookie so redirect to login
-			a.Logger.Error("AuthMiddleware: cookie", slog.Any("error", err), slog.String("devMsg", "no auth cookie found"))
+		logout := func() {
+			http.SetCookie(w, expiredCookie(COOKIE_NAME))
+			http.SetCookie(w, expiredCookie(REFRESH_COOKIE_NAME))
 			handleRedirect()
-			return
 		}
-		value, err := a.ReadSignedCookie(c)
-		if err != nil {
-			// cookie is invalid so remove cookie & redirect to login
-			a.Logger.Error("AuthMiddleware: signed cookie", slog.Any("error", err), slog.String("devMsg", "auth cookie currupted"))
-			handleRedirect()
+		serve := func(userInfo *UserInfo) {
+			ctx := context.WithValue(r.Context(), userCtxKey, *userInfo)
+			h.ServeHTTP(w, r.WithContext(ctx))
+		}
+		serveIfActive := func(userInfo *UserInfo) {
+			active, err := a.sessionActive(userInfo.UserId, userInfo.SessionId)
+			if err != nil {
+				a.Logger.Error("AuthMiddleware: session", slog.Any("error", err), slog.String("devMsg", "session lookup err"))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			if !active {
+				// session was logged out
+				a.Logger.Info("AuthMiddleware: session", slog.String("devMsg", "session revoked or expired"))
+				logout()
+				return
+			}
+			serve(userInfo)
+		}
+
+		now := time.Now()
+		userInfo, expiresAt, accessErr := a.readAccessCookie(r)
+		if accessErr == nil && expiresAt.Sub(now) > a.refreshWindow() {
+			serveIfActive(userInfo)
 			return
 		}
-		err = a.VerifyToken(value)
+		refreshCookie, err := r.Cookie(REFRESH_COOKIE_NAME)
 		if err != nil {
-			// token is invalid so remove cookie & redirect to login
-			a.Logger.Error("AuthMiddleware: cookie token", slog.Any("error", err), slog.String("devMsg", "auth cookie invalid"))
-			handleRedirect()
+			if accessErr != nil {
+				a.Logger.Error("AuthMiddleware: access cookie", slog.Any("error", accessErr), slog.String("devMsg", "no valid auth or refresh cookie"))
+				handleRedirect()
+				return
+			}
+			// still valid for a few minutes, the next login gets a refresh cookie
+			serveIfActive(userInfo)
 			return
 		}
 
-		username, userId, err := a.ReadTokenUserName(value)
+		refreshed, err := a.User.RefreshSession(refreshCookie.Value, now, a.idleTimeout())
 		if err != nil {
-			// getting username error from jwt token
-			a.Logger.Error("AuthMiddleware: jwt read token", slog.Any("error", err), slog.String("devMsg", "read username err"))
-			handleRedirect()
+			if errors.As(err, &cuserr.Reused{}) {
+				a.Logger.Warn("AuthMiddleware: refresh", slog.Any("error", err), slog.String("devMsg", "refresh token reused, session revoked"))
+				if accessErr == nil {
+					a.sessions.set(userInfo.SessionId, userInfo.UserId, false, now)
+				}
+				logout()
+				return
+			}
+			if errors.As(err, &cuserr.Expired{}) || errors.As(err, &cuserr.InvalidCred{}) {
+				a.Logger.Info("AuthMiddleware: refresh", slog.Any("error", err), slog.String("devMsg", "session ended"))
+				logout()
+				return
+			}
+			a.Logger.Error("AuthMiddleware: refresh", slog.Any("error", err), slog.String("devMsg", "refresh session err"))
+			http.Error(w, "Internal Error", 500)
 			return
 		}
-		userInfo := UserInfo{UserName: username, UserId: userId}
-		ctx := context.WithValue(r.Context(), userCtxKey, userInfo)
-		h.ServeHTTP(w, r.WithContext(ctx))
+		userInfo = &UserInfo{UserName: refreshed.UserName, UserId: refreshed.UserId, SessionId: refreshed.SessionId}
+		// An empty token means a request sent at the same time already rotated it and got the new cookies
+		if refreshed.RefreshToken != "" {
+			err = a.setSessionCookies(w, userInfo, refreshed.RefreshToken, refreshed.ExpiresAt, now)
+			if err != nil {
+				a.Logger.Error("AuthMiddleware: refresh", slog.Any("error", err), slog.String("devMsg", "set cookies err"))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+		}
+		a.sessions.set(refreshed.SessionId, refreshed.UserId, true, now)
+		serve(userInfo)
 	})
 }
 
+// Returns the user in the jwt cookie and when the jwt expires
+func (a *Auth) readAccessCookie(r *http.Request) (*UserInfo, time.Time, error) {
+	c, err := r.Cookie(COOKIE_NAME)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("readAccessCookie: cookie: %w", err)
+	}
+	value, err := a.ReadEncryptedCookie(c)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("readAccessCookie: cookie decrypt: %w", err)
+	}
+	err = a.VerifyToken(value)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("readAccessCookie: %w", err)
+	}
+	username, userId, err := a.ReadTokenUserName(value)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("readAccessCookie: %w", err)
+	}
+	// tokens made before sessions existed have no jti
+	sessionId, err := a.ReadTokenSessionId(value)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("readAccessCookie: %w", err)
+	}
+	expiresAt, err := a.readTokenExpiry(value)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("readAccessCookie: %w", err)
+	}
+	return &UserInfo{UserName: username, UserId: userId, SessionId: sessionId}, expiresAt, nil
+}
+
+// Sets a new jwt cookie and the refresh cookie, which lasts until the session's absolute timeout
+func (a *Auth) setSessionCookies(w http.ResponseWriter, userInfo *UserInfo, refreshToken string, sessionExpiresAt time.Time, now time.Time) error {
+	token, err := a.CreateToken(userInfo.UserName, userInfo.UserId, userInfo.SessionId)
+	if err != nil {
+		return fmt.Errorf("setSessionCookies: %w", err)
+	}
+	cookie, err := a.CreateEncryptedCookie(token)
+	if err != nil {
+		return fmt.Errorf("setSessionCookies: %w", err)
+	}
+	http.SetCookie(w, cookie)
+	http.SetCookie(w, &http.Cookie{
+		Name:     REFRESH_COOKIE_NAME,
+		Value:    refreshToken,
+		Path:     "/",
+		MaxAge:   int(sessionExpiresAt.Sub(now).Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
 func (a *Auth) HandleLogin() http.Handler {
 	funcName := "HandleLogin"
 	return http.HandlerFunc(
@@ -256,14 +502,14 @@ func (a *Auth) HandleLogin() http.Handler {
 				htmxReqHeader := r.Header.Get("hx-request")
 				isHtmxRequest := htmxReqHeader == "true"
 				if isHtmxRequest {
-					signUpDiv := views.Login(views.LoginFormData{})
+					signUpDiv := views.Login(views.LoginFormData{OidcProvider: a.oidcProviderName()})
 					err := signUpDiv.Render(ctx, w)
 					if err != nil {
 						a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err), slog.String("DevNote", "div render"))
 					}
 					return
 				}
-				loginPage := views.LoginPage(views.LoginFormData{})
+				loginPage := views.LoginPage(views.LoginFormData{OidcProvider: a.oidcProviderName()})
 				err := loginPage.Render(ctx, w)
 				if err != nil {
 					a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
@@ -279,9 +525,32 @@ func (a *Auth) HandleLogin() http.Handler {
 
 				userName := r.FormValue("username")
 				password := r.FormValue("password")
+				ip := clientIp(r)
+				now := time.Now()
+				wait, err := a.loginLimits.allow(ip, userName, now)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					w.WriteHeader(500)
+					return
+				}
+				if wait > 0 {
+					a.Logger.Warn(funcName, slog.String("HttpMethod", "POST"), slog.String("ip", ip), slog.String("username", userName), slog.Duration("wait", wait), slog.String("DevNote", "login rate limited"))
+					w.Header().Set("Retry-After", retryAfterSeconds(wait))
+					w.WriteHeader(429)
+					errMsg := "ERROR: " + tooManyAttemptsMsg(wait)
+					loginForm := views.LoginForm(views.LoginFormData{FormErr: &errMsg})
+					err := loginForm.Render(ctx, w)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("Method", "POST"), slog.Any("error", err))
+					}
+					return
+				}
 				userId, err := a.User.Login(userName, password)
 				if err != nil {
 					a.Logger.Info(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					if !errors.As(err, &cuserr.InvalidInput{}) {
+						a.recordLoginFailure(ctx, ip, userName, "wrong password", now)
+					}
 					w.WriteHeader(422)
 					errMsg := "ERROR: " + loginConvertErrorMsg(err)
 					formData := views.LoginFormData{
@@ -294,21 +563,29 @@ func (a *Auth) HandleLogin() http.Handler {
 					}
 					return
 				}
-				token, err := a.CreateToken(userName, userId)
+				totpEnabled, err := a.User.TotpEnabled(userId)
 				if err != nil {
 					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
 					w.WriteHeader(500)
 					return
 				}
-				cookie, err := a.CreateSignedCookie(token)
-				if err != nil {
-					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
-					w.WriteHeader(500)
+				if totpEnabled {
+					// The limits aren't reset until the code is right too, otherwise every
+					// correct password would buy more guesses at the code
+					err = a.setPendingLogin(w, userId, userName, now)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						w.WriteHeader(500)
+						return
+					}
+					totpForm := views.TotpLoginForm(views.TotpLoginFormData{})
+					err = totpForm.Render(ctx, w)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("Method", "POST"), slog.Any("error", err))
+					}
 					return
 				}
-				http.SetCookie(w, cookie)
-				w.Header().Set("HX-Redirect", "/home")
-				w.WriteHeader(200)
+				a.finishLogin(w, funcName, userId, userName, now)
 			default:
 				w.WriteHeader(404)
 			}
@@ -376,6 +653,149 @@ func (a *Auth) HandleSignUp() http.Handler {
 	)
 }
 
+// Resets the login limits, starts the session and sends the browser home
+func (a *Auth) finishLogin(w http.ResponseWriter, funcName string, userId int, userName string, now time.Time) {
+	err := a.startSession(w, userId, userName, now)
+	if err != nil {
+		a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("HX-Redirect", "/home")
+	w.WriteHeader(200)
+}
+
+// Resets the login limits and sets the new session's cookies
+func (a *Auth) startSession(w http.ResponseWriter, userId int, userName string, now time.Time) error {
+	err := a.loginLimits.success(userName, now)
+	if err != nil {
+		// The login still goes ahead
+		a.Logger.Error("startSession", slog.Any("error", err))
+	}
+	sessionId, refreshToken, err := a.User.StartSession(userId, now, a.absoluteTimeout())
+	if err != nil {
+		return fmt.Errorf("startSession: %w", err)
+	}
+	userInfo := UserInfo{UserName: userName, UserId: userId, SessionId: sessionId}
+	err = a.setSessionCookies(w, &userInfo, refreshToken, now.Add(a.absoluteTimeout()), now)
+	if err != nil {
+		return fmt.Errorf("startSession: %w", err)
+	}
+	return nil
+}
+
+// Revokes the current session and clears the cookies
+func (a *Auth) HandleLogout() http.Handler {
+	funcName := "HandleLogout"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case "POST":
+				curUser, err := UserCtx(r.Context())
+				if err != nil {
+					a.Logger.Error(funcName, slog.Any("error", err), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+					w.WriteHeader(500)
+					return
+				}
+				now := time.Now()
+				err = a.User.EndSession(curUser.UserId, curUser.SessionId, now)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					w.WriteHeader(500)
+					return
+				}
+				a.sessions.set(curUser.SessionId, curUser.UserId, false, now)
+				http.SetCookie(w, expiredCookie(COOKIE_NAME))
+				http.SetCookie(w, expiredCookie(REFRESH_COOKIE_NAME))
+				w.Header().Set("HX-Redirect", "/login")
+				w.WriteHeader(200)
+			default:
+				w.WriteHeader(404)
+			}
+		},
+	)
+}
+
+// Revokes every session of the user, logging out all their devices
+func (a *Auth) HandleLogoutAll() http.Handler {
+	funcName := "HandleLogoutAll"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case "POST":
+				curUser, err := UserCtx(r.Context())
+				if err != nil {
+					a.Logger.Error(funcName, slog.Any("error", err), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+					w.WriteHeader(500)
+					return
+				}
+				now := time.Now()
+				err = a.User.EndAllSessions(curUser.UserId, now)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					w.WriteHeader(500)
+					return
+				}
+				a.sessions.revokeUser(curUser.UserId, now)
+				a.sessions.set(curUser.SessionId, curUser.UserId, false, now)
+				http.SetCookie(w, expiredCookie(COOKIE_NAME))
+				http.SetCookie(w, expiredCookie(REFRESH_COOKIE_NAME))
+				w.Header().Set("HX-Redirect", "/login")
+				w.WriteHeader(200)
+			default:
+				w.WriteHeader(404)
+			}
+		},
+	)
+}
+
+// Counts the failure towards the limits and saves it to the account's audit log.
+// Errors are only logged so the user still gets the login form back.
+func (a *Auth) recordLoginFailure(ctx context.Context, ip, userName, reason string, now time.Time) {
+	funcName := "recordLoginFailure"
+	action := database.AUDIT_ACTION_LOGIN_FAILED
+	locked, err := a.loginLimits.failure(ip, userName, now)
+	if err != nil {
+		a.Logger.Error(funcName, slog.Any("error", err))
+	}
+	if locked {
+		action = database.AUDIT_ACTION_LOGIN_LOCKED
+		reason = "too many failed logins"
+		a.Logger.Warn(funcName, slog.String("ip", ip), slog.String("username", userName), slog.String("DevNote", "login locked out"))
+	}
+	err = a.User.RecordLoginFailure(ctx, userName, ip, action, reason, now)
+	if err != nil {
+		a.Logger.Error(funcName, slog.Any("error", err))
+	}
+}
+
+// Checks the cache before the db
+func (a *Auth) sessionActive(userId int, sessionId string) (bool, error) {
+	now := time.Now()
+	if active, ok := a.sessions.get(sessionId, now); ok {
+		return active, nil
+	}
+	active, err := a.User.SessionActive(userId, sessionId, now)
+	if err != nil {
+		return false, fmt.Errorf("sessionActive: %w", err)
+	}
+	a.sessions.set(sessionId, userId, active, now)
+	return active, nil
+}
+
+// Tells the browser to drop the named cookie
+func expiredCookie(name string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
 func UserCtx(ctx context.Context) (*UserInfo, error) {
 	user, ok := ctx.Value(userCtxKey).(UserInfo)
 	if !ok {
@@ -404,14 +824,9 @@ func loginConvertErrorMsg(err error) string {
 		return invalidInputErr.Error()
 	}
 
-	notFoundErr := &cuserr.NotFound{}
-	if errors.As(err, notFoundErr) {
-		return notFoundErr.Error()
-	}
-
-	invalidCred := &cuserr.InvalidCred{}
-	if errors.As(err, invalidCred) {
-		return invalidCred.Error()
+	// The same message for both so it doesn't tell whether the username exists
+	if errors.As(err, &cuserr.NotFound{}) || errors.As(err, &cuserr.InvalidCred{}) {
+		return LOGIN_FAILED_MSG
 	}
 
 	return "internal error"
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"wonk/app/csrf"
+	"wonk/app/templates/views"
+)
+
+const CSRF_ERROR_MSG = "This page is out of date, reload it and try again."
+
+// Puts the session's csrf token in the request context for the templates and rejects
+// POST, PUT, PATCH and DELETE requests that don't send it back. Must run inside AuthMiddleware.
+func (a *Auth) CSRFMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		curUser, err := UserCtx(r.Context())
+		if err != nil {
+			a.Logger.Error("CSRFMiddleware", slog.Any("error", err), slog.String("devMsg", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error", 500)
+			return
+		}
+		token, err := csrfToken(a.CookieSecretKey, curUser.SessionId)
+		if err != nil {
+			a.Logger.Error("CSRFMiddleware", slog.Any("error", err))
+			http.Error(w, "Internal Error", 500)
+			return
+		}
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+		default:
+			sent := r.Header.Get(csrf.HEADER_NAME)
+			if sent == "" {
+				sent = r.PostFormValue(csrf.FORM_FIELD)
+			}
+			valid, err := a.validCSRFToken(curUser.SessionId, sent)
+			if err != nil {
+				a.Logger.Error("CSRFMiddleware", slog.Any("error", err))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			if !valid {
+				a.Logger.Warn("CSRFMiddleware", slog.String("method", r.Method), slog.String("path", r.URL.Path), slog.Bool("missing", sent == ""), slog.String("devMsg", "csrf token mismatch"))
+				a.renderForbidden(r.Context(), w, r.Header.Get("hx-request") == "true")
+				return
+			}
+		}
+		ctx := csrf.WithToken(r.Context(), token)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// The token is tied to the session so it changes on every login and can't be reused by
+// another user, and it's derived rather than stored so there's nothing to look up
+func csrfToken(key, sessionId string) (string, error) {
+	cookieSecretKey, err := hex.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("csrfToken: hex: %w", err)
+	}
+	mac := hmac.New(sha256.New, cookieSecretKey)
+	mac.Write([]byte("csrf"))
+	mac.Write([]byte(sessionId))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Tries the current key first, then the previous keys so pages loaded before a rotation still work
+func (a *Auth) validCSRFToken(sessionId, sent string) (bool, error) {
+	if sent == "" {
+		return false, nil
+	}
+	for _, key := range append([]string{a.CookieSecretKey}, a.CookiePreviousKeys...) {
+		token, err := csrfToken(key, sessionId)
+		if err != nil {
+			return false, fmt.Errorf("validCSRFToken: %w", err)
+		}
+		if hmac.Equal([]byte(sent), []byte(token)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// htmx requests get the message alone for the page to show, others get a full page
+func (a *Auth) renderForbidden(ctx context.Context, w http.ResponseWriter, isHtmxRequest bool) {
+	if isHtmxRequest {
+		w.Header().Set("HX-Reswap", "none")
+		http.Error(w, CSRF_ERROR_MSG, 403)
+		return
+	}
+	w.WriteHeader(403)
+	err := views.ForbiddenPage(CSRF_ERROR_MSG).Render(ctx, w)
+	if err != nil {
+		a.Logger.Error("renderForbidden", slog.Any("error", err))
+	}
+}
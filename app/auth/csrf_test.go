@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestValidCSRFTokenRotation(t *testing.T) {
+	oldSecret := hex.EncodeToString([]byte("OLD_SECRET"))
+	newSecret := hex.EncodeToString([]byte("NEW_SECRET"))
+	before := Auth{CookieSecretKey: oldSecret}
+	during := Auth{CookieSecretKey: newSecret, CookiePreviousKeys: []string{oldSecret}}
+	after := Auth{CookieSecretKey: newSecret}
+
+	oldToken, err := csrfToken(oldSecret, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := csrfToken(newSecret, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSessionToken, err := csrfToken(newSecret, "other session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		auth     Auth
+		token    string
+		expected bool
+	}{
+		{name: "before rotation", auth: before, token: oldToken, expected: true},
+		{name: "previous key during rotation", auth: during, token: oldToken, expected: true},
+		{name: "current key during rotation", auth: during, token: newToken, expected: true},
+		{name: "previous key after it's dropped", auth: after, token: oldToken, expected: false},
+		{name: "another session", auth: during, token: otherSessionToken, expected: false},
+		{name: "missing", auth: during, token: "", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, err := tt.auth.validCSRFToken("session", tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wonk/app/ratelimit"
+)
+
+const (
+	LOGIN_LIMIT_STORE_SIZE = 10000
+	LOGIN_LIMIT_STORE_TTL  = time.Hour
+)
+
+var (
+	// Slows down guessing one account's password, locking it for a while after 5 misses
+	LOGIN_USER_POLICY = ratelimit.Policy{
+		Interval:    10 * time.Second,
+		Burst:       10,
+		MaxFailures: 5,
+		BaseDelay:   time.Second,
+		Lockout:     15 * time.Minute,
+	}
+	// Looser since people share ips, catches one ip trying many accounts
+	LOGIN_IP_POLICY = ratelimit.Policy{
+		Interval:    2 * time.Second,
+		Burst:       30,
+		MaxFailures: 50,
+		Lockout:     15 * time.Minute,
+	}
+)
+
+// Login attempts are limited by both the ip they come from and the username they're for
+type loginLimiter struct {
+	ip   *ratelimit.Limiter
+	user *ratelimit.Limiter
+}
+
+func newLoginLimiter(ipStore, userStore ratelimit.Store) *loginLimiter {
+	return &loginLimiter{
+		ip:   ratelimit.NewLimiter(LOGIN_IP_POLICY, ipStore),
+		user: ratelimit.NewLimiter(LOGIN_USER_POLICY, userStore),
+	}
+}
+
+// Returns how long to wait before trying again, 0 when the attempt can go ahead
+func (l *loginLimiter) allow(ip, userName string, now time.Time) (time.Duration, error) {
+	ipWait, err := l.ip.Allow(ip, now)
+	if err != nil {
+		return 0, fmt.Errorf("allow: ip: %w", err)
+	}
+	userWait, err := l.user.Allow(userKey(userName), now)
+	if err != nil {
+		return 0, fmt.Errorf("allow: user: %w", err)
+	}
+	return max(ipWait, userWait), nil
+}
+
+// Returns true when the failure locked out the ip or the username
+func (l *loginLimiter) failure(ip, userName string, now time.Time) (bool, error) {
+	ipLocked, err := l.ip.Failure(ip, now)
+	if err != nil {
+		return false, fmt.Errorf("failure: ip: %w", err)
+	}
+	userLocked, err := l.user.Failure(userKey(userName), now)
+	if err != nil {
+		return false, fmt.Errorf("failure: user: %w", err)
+	}
+	return ipLocked || userLocked, nil
+}
+
+// Passkey logins don't name an account up front, so only the ip is limited
+func (l *loginLimiter) allowIp(ip string, now time.Time) (time.Duration, error) {
+	wait, err := l.ip.Allow(ip, now)
+	if err != nil {
+		return 0, fmt.Errorf("allowIp: %w", err)
+	}
+	return wait, nil
+}
+
+func (l *loginLimiter) failureIp(ip string, now time.Time) (bool, error) {
+	locked, err := l.ip.Failure(ip, now)
+	if err != nil {
+		return false, fmt.Errorf("failureIp: %w", err)
+	}
+	return locked, nil
+}
+
+// Only the username's failures are forgotten, otherwise logging into your own
+// account would reset the count while guessing others
+func (l *loginLimiter) success(userName string, now time.Time) error {
+	err := l.user.Success(userKey(userName), now)
+	if err != nil {
+		return fmt.Errorf("success: %w", err)
+	}
+	return nil
+}
+
+func userKey(userName string) string {
+	return strings.ToLower(strings.TrimSpace(userName))
+}
+
+// IDEA: Read X-Forwarded-For when running behind a trusted proxy
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+func tooManyAttemptsMsg(wait time.Duration) string {
+	if wait < time.Minute {
+		return "too many attempts, try again in " + retryAfterSeconds(wait) + " seconds"
+	}
+	return "too many attempts, try again in " + strconv.Itoa(int(math.Ceil(wait.Minutes()))) + " minutes"
+}
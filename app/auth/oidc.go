@@ -0,0 +1,448 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/app/oidc"
+	"wonk/app/templates/views"
+)
+
+const (
+	OIDC_COOKIE_NAME   = "WonkOidc"
+	OIDC_CALLBACK_PATH = "/login/oidc/callback"
+	// How long the user has at the provider before the login has to be started again
+	OIDC_REQUEST_DURATION = 10 * time.Minute
+
+	OIDC_EXPIRED_MSG     = "login expired, try again"
+	OIDC_FAILED_MSG      = "could not log in through the provider, try again or log in with your password"
+	OIDC_UNAVAILABLE_MSG = "the login provider can't be reached, try again later"
+	OIDC_NOT_LINKED_MSG  = "no account is linked to that login, log in with your password and link it under Linked Accounts"
+	OIDC_TAKEN_MSG       = "that account is already linked to a user"
+)
+
+// What the callback checks the provider's answer against, kept in an encrypted cookie while
+// the browser is away at the provider
+type oidcRequest struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// Set when a logged in user is linking an account, 0 for logins
+	UserId    int    `json:"userId"`
+	SessionId string `json:"sessionId"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (a *Auth) oidcProviderName() string {
+	if a.Oidc == nil {
+		return ""
+	}
+	return a.OidcProviderName
+}
+
+// Lax, not Strict, since the browser comes back from the provider's site. The cookie is only
+// sent to the callback.
+func (a *Auth) setOidcRequest(w http.ResponseWriter, req oidcRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("setOidcRequest: json: %w", err)
+	}
+	value, err := a.encryptCookieValue(OIDC_COOKIE_NAME, string(b))
+	if err != nil {
+		return fmt.Errorf("setOidcRequest: %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_COOKIE_NAME,
+		Value:    value,
+		Path:     OIDC_CALLBACK_PATH,
+		MaxAge:   int(OIDC_REQUEST_DURATION.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// The state in the callback has to match the cookie, so a code sent to someone else's browser
+// can't be used in this one
+func (a *Auth) readOidcRequest(r *http.Request, now time.Time) (*oidcRequest, error) {
+	cookie, err := r.Cookie(OIDC_COOKIE_NAME)
+	if err != nil {
+		return nil, fmt.Errorf("readOidcRequest: cookie: %w", err)
+	}
+	value, err := a.decryptCookieValue(OIDC_COOKIE_NAME, cookie.Value)
+	if err != nil {
+		return nil, fmt.Errorf("readOidcRequest: %w", err)
+	}
+	req := oidcRequest{}
+	err = json.Unmarshal([]byte(value), &req)
+	if err != nil {
+		return nil, fmt.Errorf("readOidcRequest: json: %w", err)
+	}
+	if now.Unix() >= req.ExpiresAt {
+		return nil, fmt.Errorf("readOidcRequest: %w", cuserr.Expired{Item: "oidc login"})
+	}
+	state := r.URL.Query().Get("state")
+	if req.State == "" || subtle.ConstantTimeCompare([]byte(state), []byte(req.State)) != 1 {
+		return nil, fmt.Errorf("readOidcRequest: %w", cuserr.InvalidCred{Item: "state", Reason: "it does not match"})
+	}
+	return &req, nil
+}
+
+// Makes the state, nonce and verifier, saves them in the cookie and returns where to send the browser
+func (a *Auth) startOidc(ctx context.Context, w http.ResponseWriter, userId int, sessionId string, now time.Time) (string, error) {
+	req := oidcRequest{UserId: userId, SessionId: sessionId, ExpiresAt: now.Add(OIDC_REQUEST_DURATION).Unix()}
+	for _, v := range []*string{&req.State, &req.Nonce, &req.Verifier} {
+		token, err := oidc.RandomToken()
+		if err != nil {
+			return "", fmt.Errorf("startOidc: %w", err)
+		}
+		*v = token
+	}
+	authURL, err := a.Oidc.AuthCodeURL(ctx, req.State, req.Nonce, req.Verifier)
+	if err != nil {
+		return "", fmt.Errorf("startOidc: %w", err)
+	}
+	err = a.setOidcRequest(w, req)
+	if err != nil {
+		return "", fmt.Errorf("startOidc: %w", err)
+	}
+	return authURL, nil
+}
+
+// Sends the browser to the provider to log in
+func (a *Auth) HandleOidcLogin() http.Handler {
+	funcName := "HandleOidcLogin"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), time.Second*20)
+			defer cancel()
+			if a.Oidc == nil {
+				w.WriteHeader(404)
+				return
+			}
+			switch r.Method {
+			case "GET":
+				authURL, err := a.startOidc(ctx, w, 0, "", time.Now())
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+					a.renderOidcLoginError(ctx, w, funcName, 502, OIDC_UNAVAILABLE_MSG)
+					return
+				}
+				http.Redirect(w, r, authURL, 302)
+			default:
+				w.WriteHeader(404)
+			}
+		},
+	)
+}
+
+// Where the provider sends the browser back to, for logins and for linking. The code is traded
+// for an ID token, which says who logged in at the provider.
+func (a *Auth) HandleOidcCallback() http.Handler {
+	funcName := "HandleOidcCallback"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), time.Second*20)
+			defer cancel()
+			if a.Oidc == nil {
+				w.WriteHeader(404)
+				return
+			}
+			switch r.Method {
+			case "GET":
+				now := time.Now()
+				req, err := a.readOidcRequest(r, now)
+				if err != nil {
+					a.Logger.Info(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+					a.renderOidcLoginError(ctx, w, funcName, 400, OIDC_EXPIRED_MSG)
+					return
+				}
+				// Each request is good for one answer
+				expired := expiredCookie(OIDC_COOKIE_NAME)
+				expired.Path = OIDC_CALLBACK_PATH
+				http.SetCookie(w, expired)
+
+				query := r.URL.Query()
+				// The user cancelled or the provider turned them away
+				if providerErr := query.Get("error"); providerErr != "" {
+					a.Logger.Info(funcName, slog.String("HttpMethod", "GET"), slog.String("providerError", providerErr), slog.String("description", query.Get("error_description")))
+					a.renderOidcLoginError(ctx, w, funcName, 400, OIDC_FAILED_MSG)
+					return
+				}
+				ip := clientIp(r)
+				wait, err := a.loginLimits.allowIp(ip, now)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+					w.WriteHeader(500)
+					return
+				}
+				if wait > 0 {
+					a.Logger.Warn(funcName, slog.String("HttpMethod", "GET"), slog.String("ip", ip), slog.Duration("wait", wait), slog.String("DevNote", "login rate limited"))
+					w.Header().Set("Retry-After", retryAfterSeconds(wait))
+					a.renderOidcLoginError(ctx, w, funcName, 429, tooManyAttemptsMsg(wait))
+					return
+				}
+				idToken, err := a.Oidc.Exchange(ctx, query.Get("code"), req.Verifier)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+					errMsg := OIDC_FAILED_MSG
+					if errors.Is(err, oidc.ErrDiscovery) {
+						errMsg = OIDC_UNAVAILABLE_MSG
+					}
+					a.renderOidcLoginError(ctx, w, funcName, 502, errMsg)
+					return
+				}
+				claims, err := a.Oidc.VerifyIdToken(ctx, idToken, req.Nonce, now)
+				if err != nil {
+					a.Logger.Warn(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err), slog.String("DevNote", "id token rejected"))
+					_, err := a.loginLimits.failureIp(ip, now)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+					}
+					a.renderOidcLoginError(ctx, w, funcName, 400, OIDC_FAILED_MSG)
+					return
+				}
+				if req.UserId != 0 {
+					a.finishOidcLink(w, r, funcName, req, claims, now)
+					return
+				}
+
+				userId, userName, err := a.User.OidcLogin(a.Oidc.Issuer, claims, a.OidcAutoProvision, now)
+				if err != nil {
+					errMsg := oidcConvertErrorMsg(err)
+					if errMsg == "internal error" {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+						w.WriteHeader(500)
+						return
+					}
+					a.Logger.Info(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+					a.renderOidcLoginError(ctx, w, funcName, 403, errMsg)
+					return
+				}
+				// The provider vouches for who it is, two-factor is still asked for like after a password
+				totpEnabled, err := a.User.TotpEnabled(userId)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+					w.WriteHeader(500)
+					return
+				}
+				if totpEnabled {
+					err = a.setPendingLogin(w, userId, userName, now)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+						w.WriteHeader(500)
+						return
+					}
+					totpPage := views.OidcTotpPage()
+					err = totpPage.Render(ctx, w)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+					}
+					return
+				}
+				err = a.startSession(w, userId, userName, now)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+					w.WriteHeader(500)
+					return
+				}
+				http.Redirect(w, r, "/home", 302)
+			default:
+				w.WriteHeader(404)
+			}
+		},
+	)
+}
+
+// Links the provider account to the user who started linking, as long as they're still logged in
+func (a *Auth) finishOidcLink(w http.ResponseWriter, r *http.Request, funcName string, req *oidcRequest, claims *oidc.Claims, now time.Time) {
+	active, err := a.sessionActive(req.UserId, req.SessionId)
+	if err != nil {
+		a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+		w.WriteHeader(500)
+		return
+	}
+	if !active {
+		a.Logger.Info(funcName, slog.String("HttpMethod", "GET"), slog.String("DevNote", "session ended while linking"))
+		http.Redirect(w, r, "/login", 302)
+		return
+	}
+	err = a.User.LinkOidcIdentity(req.UserId, a.Oidc.Issuer, claims, now)
+	if err != nil {
+		if errors.As(err, &cuserr.ItemAlreadyExists{}) {
+			a.Logger.Info(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+			http.Redirect(w, r, "/finance/oidc?error=taken", 302)
+			return
+		}
+		a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+		w.WriteHeader(500)
+		return
+	}
+	http.Redirect(w, r, "/finance/oidc?linked=true", 302)
+}
+
+func (a *Auth) renderOidcLoginError(ctx context.Context, w http.ResponseWriter, funcName string, status int, msg string) {
+	w.WriteHeader(status)
+	errMsg := "ERROR: " + msg
+	loginPage := views.LoginPage(views.LoginFormData{FormErr: &errMsg, OidcProvider: a.oidcProviderName()})
+	err := loginPage.Render(ctx, w)
+	if err != nil {
+		a.Logger.Error(funcName, slog.Any("error", err))
+	}
+}
+
+// Lists the user's linked provider accounts. The callback redirects here after linking, so
+// without hx-request the whole page is sent.
+func (a *Auth) HandleOidcIdentities() http.Handler {
+	funcName := "HandleOidcIdentities"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			reqCtx := r.Context()
+			ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+			defer cancel()
+			curUser, err := UserCtx(reqCtx)
+			if err != nil {
+				a.Logger.Error(funcName, slog.Any("error", err), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+				http.Error(w, "Internal Error, try logging in again", 500)
+				return
+			}
+			switch r.Method {
+			case "GET":
+				data := views.OidcIdentitiesPageData{Linked: r.URL.Query().Get("linked") == "true"}
+				if r.URL.Query().Get("error") == "taken" {
+					errMsg := OIDC_TAKEN_MSG
+					data.FormErr = &errMsg
+				}
+				isHtmxRequest := r.Header.Get("hx-request") == "true"
+				a.renderOidcIdentities(ctx, w, funcName, curUser.UserId, data, !isHtmxRequest)
+				return
+			default:
+				http.Error(w, "Not valid method", 404)
+			}
+		},
+	)
+}
+
+// Sends the logged in user to the provider, the account they log in with there gets linked
+func (a *Auth) HandleOidcLink() http.Handler {
+	funcName := "HandleOidcLink"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			htmxReqHeader := r.Header.Get("hx-request")
+			isHtmxRequest := htmxReqHeader == "true"
+			if !isHtmxRequest {
+				http.Error(w, "misssing header 'hx-request'", 400)
+				return
+			}
+			reqCtx := r.Context()
+			ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+			defer cancel()
+			curUser, err := UserCtx(reqCtx)
+			if err != nil {
+				a.Logger.Error(funcName, slog.Any("error", err), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+				http.Error(w, "Internal Error, try logging in again", 500)
+				return
+			}
+			if a.Oidc == nil {
+				http.Error(w, "Not valid method", 404)
+				return
+			}
+			switch r.Method {
+			case "POST":
+				authURL, err := a.startOidc(ctx, w, curUser.UserId, curUser.SessionId, time.Now())
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					http.Error(w, "Login provider unavailable", 502)
+					return
+				}
+				w.Header().Set("HX-Redirect", authURL)
+				w.WriteHeader(200)
+			default:
+				http.Error(w, "Not valid method", 404)
+			}
+		},
+	)
+}
+
+func (a *Auth) HandleOidcIdentityById() http.Handler {
+	funcName := "HandleOidcIdentityById"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			htmxReqHeader := r.Header.Get("hx-request")
+			isHtmxRequest := htmxReqHeader == "true"
+			if !isHtmxRequest {
+				http.Error(w, "misssing header 'hx-request'", 400)
+				return
+			}
+			reqCtx := r.Context()
+			ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+			defer cancel()
+			curUser, err := UserCtx(reqCtx)
+			if err != nil {
+				a.Logger.Error(funcName, slog.Any("error", err), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+				http.Error(w, "Internal Error, try logging in again", 500)
+				return
+			}
+			identityId, err := strconv.Atoi(r.PathValue("id"))
+			if err != nil {
+				http.Error(w, "Bad Request: Id Isn't a int", 400)
+				return
+			}
+			switch r.Method {
+			case "DELETE":
+				err := a.User.UnlinkOidcIdentity(curUser.UserId, identityId)
+				if err != nil {
+					if errors.As(err, &cuserr.NotFound{}) {
+						http.Error(w, "Linked account not found", 404)
+						return
+					}
+					a.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.Any("error", err))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+				a.renderOidcIdentities(ctx, w, funcName, curUser.UserId, views.OidcIdentitiesPageData{}, false)
+				return
+			default:
+				http.Error(w, "Not valid method", 404)
+			}
+		},
+	)
+}
+
+func (a *Auth) renderOidcIdentities(ctx context.Context, w http.ResponseWriter, funcName string, userId int, data views.OidcIdentitiesPageData, fullPage bool) {
+	identities, err := a.User.OidcIdentities(userId)
+	if err != nil {
+		a.Logger.Error(funcName, slog.Any("error", err))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	data.Identities = identities
+	data.ProviderName = a.oidcProviderName()
+	tmplIdentities := views.OidcIdentitiesView(data)
+	if fullPage {
+		tmplIdentities = views.OidcIdentitiesPage(data)
+	}
+	err = tmplIdentities.Render(ctx, w)
+	if err != nil {
+		a.Logger.Error(funcName, slog.Any("error", err))
+	}
+}
+
+func oidcConvertErrorMsg(err error) string {
+	if errors.As(err, &cuserr.NotFound{}) {
+		return OIDC_NOT_LINKED_MSG
+	}
+	// Every username tried for a new account was taken
+	if errors.As(err, &cuserr.ItemAlreadyExists{}) {
+		return "could not pick a username for a new account, sign up and link it instead"
+	}
+	return "internal error"
+}
@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/app/notify"
+	"wonk/app/templates/emails"
+	"wonk/app/templates/views"
+	"wonk/business/user"
+)
+
+const (
+	PASSWORD_RESET_PATH   = "/login/reset/confirm"
+	PASSWORD_MISMATCH_MSG = "the new passwords don't match"
+)
+
+// Checks the current password, rate limited like a login so a session alone can't guess it
+func (a *Auth) HandleChangePassword() http.Handler {
+	funcName := "HandleChangePassword"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			htmxReqHeader := r.Header.Get("hx-request")
+			isHtmxRequest := htmxReqHeader == "true"
+			if !isHtmxRequest {
+				http.Error(w, "misssing header 'hx-request'", 400)
+				return
+			}
+			reqCtx := r.Context()
+			ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+			defer cancel()
+			curUser, err := UserCtx(reqCtx)
+			if err != nil {
+				a.Logger.Error(funcName, slog.Any("error", err), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+				http.Error(w, "Internal Error, try logging in again", 500)
+				return
+			}
+			switch r.Method {
+			case "GET":
+				a.renderPasswordView(ctx, w, funcName, views.PasswordPageData{})
+				return
+			case "POST":
+				now := time.Now()
+				newPassword := r.FormValue("password")
+				if newPassword != r.FormValue("confirm_password") {
+					w.WriteHeader(422)
+					errMsg := PASSWORD_MISMATCH_MSG
+					a.renderPasswordView(ctx, w, funcName, views.PasswordPageData{FormErr: &errMsg})
+					return
+				}
+				ip := clientIp(r)
+				wait, err := a.loginLimits.allow(ip, curUser.UserName, now)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+				if wait > 0 {
+					w.Header().Set("Retry-After", retryAfterSeconds(wait))
+					w.WriteHeader(422)
+					errMsg := tooManyAttemptsMsg(wait)
+					a.renderPasswordView(ctx, w, funcName, views.PasswordPageData{FormErr: &errMsg})
+					return
+				}
+				err = a.User.ChangePassword(curUser.UserId, curUser.SessionId, r.FormValue("current_password"), newPassword, now)
+				if err != nil {
+					errMsg := passwordConvertErrorMsg(err)
+					if errMsg == "internal error" {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						http.Error(w, "Internal Error", 500)
+						return
+					}
+					if errors.As(err, &cuserr.InvalidCred{}) {
+						_, err := a.loginLimits.failure(ip, curUser.UserName, now)
+						if err != nil {
+							a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						}
+					}
+					w.WriteHeader(422)
+					a.renderPasswordView(ctx, w, funcName, views.PasswordPageData{FormErr: &errMsg})
+					return
+				}
+				// The other sessions were revoked, don't let the cache keep them alive
+				a.sessions.revokeUser(curUser.UserId, now)
+				a.sessions.set(curUser.SessionId, curUser.UserId, true, now)
+				a.renderPasswordView(ctx, w, funcName, views.PasswordPageData{Changed: true})
+				return
+			default:
+				http.Error(w, "Not valid method", 404)
+			}
+		},
+	)
+}
+
+func (a *Auth) renderPasswordView(ctx context.Context, w http.ResponseWriter, funcName string, data views.PasswordPageData) {
+	tmplPassword := views.PasswordView(data)
+	err := tmplPassword.Render(ctx, w)
+	if err != nil {
+		a.Logger.Error(funcName, slog.Any("error", err))
+	}
+}
+
+// Emails a reset link. The response is the same whether or not the account exists or has
+// an email address, so it can't be used to find out either.
+func (a *Auth) HandlePasswordReset() http.Handler {
+	funcName := "HandlePasswordReset"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), time.Second*20)
+			defer cancel()
+			switch r.Method {
+			case "GET":
+				resetPage := views.PasswordResetPage(views.PasswordResetFormData{})
+				err := resetPage.Render(ctx, w)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+				}
+				return
+			case "POST":
+				now := time.Now()
+				userName := r.FormValue("username")
+				formData := views.PasswordResetFormData{Username: userName}
+				renderForm := func() {
+					resetForm := views.PasswordResetForm(formData)
+					err := resetForm.Render(ctx, w)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					}
+				}
+				if userName == "" {
+					w.WriteHeader(422)
+					errMsg := "ERROR: username is empty"
+					formData.FormErr = &errMsg
+					renderForm()
+					return
+				}
+				ip := clientIp(r)
+				wait, err := a.loginLimits.allow(ip, userName, now)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					w.WriteHeader(500)
+					return
+				}
+				if wait > 0 {
+					a.Logger.Warn(funcName, slog.String("HttpMethod", "POST"), slog.String("ip", ip), slog.String("username", userName), slog.Duration("wait", wait), slog.String("DevNote", "reset rate limited"))
+					w.Header().Set("Retry-After", retryAfterSeconds(wait))
+					w.WriteHeader(429)
+					errMsg := "ERROR: " + tooManyAttemptsMsg(wait)
+					formData.FormErr = &errMsg
+					renderForm()
+					return
+				}
+				// Looked up and sent in the background so neither the status nor the time
+				// it takes depends on the account
+				go a.passwordReset(userName, now)
+				formData.Sent = true
+				renderForm()
+			default:
+				w.WriteHeader(404)
+			}
+		},
+	)
+}
+
+// Failures are only logged, the response has already gone out
+func (a *Auth) passwordReset(userName string, now time.Time) {
+	funcName := "passwordReset"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
+	defer cancel()
+	reset, err := a.User.RequestPasswordReset(userName, now)
+	if err != nil {
+		if errors.As(err, &cuserr.NotFound{}) {
+			a.Logger.Info(funcName, slog.Any("error", err))
+			return
+		}
+		a.Logger.Error(funcName, slog.Any("error", err))
+		return
+	}
+	err = a.sendPasswordReset(ctx, a.resetLink(reset.Token), reset)
+	if err != nil {
+		a.Logger.Error(funcName, slog.Any("error", err))
+	}
+}
+
+func (a *Auth) sendPasswordReset(ctx context.Context, link string, reset *user.PasswordResetRequest) error {
+	if a.Sender == nil {
+		return errors.New("sendPasswordReset: no email sender")
+	}
+	var html bytes.Buffer
+	err := emails.PasswordReset(reset.UserName, link, reset.ExpiresAt).Render(ctx, &html)
+	if err != nil {
+		return fmt.Errorf("sendPasswordReset: %w", err)
+	}
+	err = a.Sender.Send(ctx, notify.Message{
+		To:      reset.Email,
+		Subject: emails.PASSWORD_RESET_SUBJECT,
+		Text:    emails.PasswordResetText(reset.UserName, link, reset.ExpiresAt),
+		Html:    html.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("sendPasswordReset: %w", err)
+	}
+	return nil
+}
+
+// Absolute url for the email. It comes from the config since the Host header is up to
+// whoever asks for the reset.
+func (a *Auth) resetLink(token string) string {
+	return a.BaseURL + PASSWORD_RESET_PATH + "?" + url.Values{"token": []string{token}}.Encode()
+}
+
+// Where the emailed link goes, sets the new password and logs out every session
+func (a *Auth) HandlePasswordResetConfirm() http.Handler {
+	funcName := "HandlePasswordResetConfirm"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), time.Second*20)
+			defer cancel()
+			// The token is in the url, keep it out of any Referer header
+			w.Header().Set("Referrer-Policy", "no-referrer")
+			switch r.Method {
+			case "GET":
+				now := time.Now()
+				token := r.URL.Query().Get("token")
+				formData := views.PasswordResetConfirmData{Token: token}
+				err := a.User.PasswordResetValid(token, now)
+				if err != nil {
+					if !isPasswordResetGone(err) {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+						w.WriteHeader(500)
+						return
+					}
+					a.Logger.Info(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+					formData = views.PasswordResetConfirmData{Invalid: true}
+				}
+				confirmPage := views.PasswordResetConfirmPage(formData)
+				err = confirmPage.Render(ctx, w)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.Any("error", err))
+				}
+				return
+			case "POST":
+				now := time.Now()
+				token := r.FormValue("token")
+				formData := views.PasswordResetConfirmData{Token: token}
+				renderForm := func(status int) {
+					w.WriteHeader(status)
+					confirmForm := views.PasswordResetConfirmForm(formData)
+					err := confirmForm.Render(ctx, w)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					}
+				}
+				newPassword := r.FormValue("password")
+				if newPassword != r.FormValue("confirm_password") {
+					errMsg := "ERROR: " + PASSWORD_MISMATCH_MSG
+					formData.FormErr = &errMsg
+					renderForm(422)
+					return
+				}
+				userId, err := a.User.ResetPassword(token, newPassword, now)
+				if err != nil {
+					if isPasswordResetGone(err) {
+						a.Logger.Info(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						formData = views.PasswordResetConfirmData{Invalid: true}
+						renderForm(422)
+						return
+					}
+					errMsg := passwordConvertErrorMsg(err)
+					if errMsg == "internal error" {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						w.WriteHeader(500)
+						return
+					}
+					errMsg = "ERROR: " + errMsg
+					formData.FormErr = &errMsg
+					renderForm(422)
+					return
+				}
+				a.sessions.revokeUser(userId, now)
+				formData = views.PasswordResetConfirmData{Done: true}
+				renderForm(200)
+			default:
+				w.WriteHeader(404)
+			}
+		},
+	)
+}
+
+func isPasswordResetGone(err error) bool {
+	return errors.As(err, &cuserr.NotFound{}) || errors.As(err, &cuserr.Expired{}) || errors.As(err, &cuserr.Reused{})
+}
+
+func passwordConvertErrorMsg(err error) string {
+	invalidInputErr := &cuserr.InvalidInput{}
+	if errors.As(err, invalidInputErr) {
+		return invalidInputErr.Error()
+	}
+	invalidCredErr := &cuserr.InvalidCred{}
+	if errors.As(err, invalidCredErr) {
+		return invalidCredErr.Error()
+	}
+	return "internal error"
+}
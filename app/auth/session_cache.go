@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// How long a session lookup is trusted before asking the db again. Revoking in
+	// this process updates the cache right away, this only delays other processes.
+	SESSION_CACHE_TTL = 30 * time.Second
+	// Past this many entries stale ones are dropped, and everything if none are stale
+	SESSION_CACHE_SIZE = 10000
+)
+
+type sessionCacheEntry struct {
+	userId    int
+	active    bool
+	checkedAt time.Time
+}
+
+// Remembers recent session lookups so AuthMiddleware doesn't hit the db on every request
+type sessionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]sessionCacheEntry
+}
+
+func newSessionCache(ttl time.Duration, size int) *sessionCache {
+	return &sessionCache{
+		ttl:     ttl,
+		size:    size,
+		entries: map[string]sessionCacheEntry{},
+	}
+}
+
+// ok is false when the session isn't cached or the entry is stale
+func (c *sessionCache) get(sessionId string, now time.Time) (active bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, found := c.entries[sessionId]
+	if !found || now.Sub(entry.checkedAt) >= c.ttl {
+		return false, false
+	}
+	return entry.active, true
+}
+
+func (c *sessionCache) set(sessionId string, userId int, active bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, found := c.entries[sessionId]; !found && len(c.entries) >= c.size {
+		for id, entry := range c.entries {
+			if now.Sub(entry.checkedAt) >= c.ttl {
+				delete(c.entries, id)
+			}
+		}
+		if len(c.entries) >= c.size {
+			clear(c.entries)
+		}
+	}
+	c.entries[sessionId] = sessionCacheEntry{userId: userId, active: active, checkedAt: now}
+}
+
+// Marks every cached session of the user revoked
+func (c *sessionCache) revokeUser(userId int, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, entry := range c.entries {
+		if entry.userId == userId {
+			c.entries[id] = sessionCacheEntry{userId: userId, active: false, checkedAt: now}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionCache(t *testing.T) {
+	now := time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)
+	c := newSessionCache(30*time.Second, 3)
+
+	if _, ok := c.get("a", now); ok {
+		t.Fatal("expected a miss on an empty cache")
+	}
+	c.set("a", 1, true, now)
+	c.set("b", 1, true, now)
+	c.set("c", 2, true, now.Add(20*time.Second))
+	if active, ok := c.get("a", now.Add(10*time.Second)); !ok || !active {
+		t.Errorf("expected a fresh active hit, got active %v ok %v", active, ok)
+	}
+	if _, ok := c.get("a", now.Add(30*time.Second)); ok {
+		t.Error("expected the entry to be stale after the ttl")
+	}
+
+	// Only user 1's sessions are revoked
+	c.revokeUser(1, now.Add(25*time.Second))
+	if active, ok := c.get("b", now.Add(26*time.Second)); !ok || active {
+		t.Errorf("expected b revoked, got active %v ok %v", active, ok)
+	}
+	if active, ok := c.get("c", now.Add(26*time.Second)); !ok || !active {
+		t.Errorf("expected c still active, got active %v ok %v", active, ok)
+	}
+
+	// Full, with nothing stale everything is dropped to make room
+	c.set("d", 3, true, now.Add(26*time.Second))
+	if len(c.entries) != 1 {
+		t.Errorf("expected only the new entry, got %+v", c.entries)
+	}
+	c.set("e", 3, true, now.Add(26*time.Second))
+	c.set("f", 3, true, now.Add(26*time.Second))
+	// Full, the stale entries are dropped first
+	c.set("g", 3, true, now.Add(60*time.Second))
+	if len(c.entries) != 1 {
+		t.Errorf("expected the stale entries dropped, got %+v", c.entries)
+	}
+}
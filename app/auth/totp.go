@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/app/qr"
+	"wonk/app/templates/views"
+)
+
+const (
+	TOTP_PENDING_COOKIE_NAME = "WonkTotp"
+	// Time between a correct password and the code before the password has to be entered again
+	TOTP_PENDING_DURATION = 5 * time.Minute
+	TOTP_QR_PIXELS        = 200
+
+	TOTP_FAILED_MSG  = "invalid code"
+	TOTP_EXPIRED_MSG = "login expired, enter your password again"
+)
+
+// Who got the password right, kept in an encrypted cookie until they send the code
+type pendingLogin struct {
+	UserId    int    `json:"userId"`
+	UserName  string `json:"userName"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// The cookie is only sent to /login so it can't be used anywhere else
+func (a *Auth) setPendingLogin(w http.ResponseWriter, userId int, userName string, now time.Time) error {
+	b, err := json.Marshal(pendingLogin{UserId: userId, UserName: userName, ExpiresAt: now.Add(TOTP_PENDING_DURATION).Unix()})
+	if err != nil {
+		return fmt.Errorf("setPendingLogin: json: %w", err)
+	}
+	value, err := a.encryptCookieValue(TOTP_PENDING_COOKIE_NAME, string(b))
+	if err != nil {
+		return fmt.Errorf("setPendingLogin: %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     TOTP_PENDING_COOKIE_NAME,
+		Value:    value,
+		Path:     "/login",
+		MaxAge:   int(TOTP_PENDING_DURATION.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (a *Auth) readPendingLogin(r *http.Request, now time.Time) (*pendingLogin, error) {
+	c, err := r.Cookie(TOTP_PENDING_COOKIE_NAME)
+	if err != nil {
+		return nil, fmt.Errorf("readPendingLogin: cookie: %w", err)
+	}
+	value, err := a.decryptCookieValue(TOTP_PENDING_COOKIE_NAME, c.Value)
+	if err != nil {
+		return nil, fmt.Errorf("readPendingLogin: %w", err)
+	}
+	pending := pendingLogin{}
+	err = json.Unmarshal([]byte(value), &pending)
+	if err != nil {
+		return nil, fmt.Errorf("readPendingLogin: json: %w", err)
+	}
+	if now.Unix() >= pending.ExpiresAt {
+		return nil, fmt.Errorf("readPendingLogin: %w", cuserr.Expired{Item: "pending login"})
+	}
+	return &pending, nil
+}
+
+// The second login step, takes the code after HandleLogin accepted the password
+func (a *Auth) HandleLoginTotp() http.Handler {
+	funcName := "HandleLoginTotp"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), time.Second*20)
+			defer cancel()
+			switch r.Method {
+			case "POST":
+				now := time.Now()
+				// Back to the password form when the pending login is gone or 2FA was turned off since
+				restartLogin := func(err error) {
+					a.Logger.Info(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					http.SetCookie(w, expiredCookie(TOTP_PENDING_COOKIE_NAME))
+					w.WriteHeader(422)
+					errMsg := "ERROR: " + TOTP_EXPIRED_MSG
+					loginForm := views.LoginForm(views.LoginFormData{FormErr: &errMsg})
+					err = loginForm.Render(ctx, w)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("Method", "POST"), slog.Any("error", err))
+					}
+				}
+				pending, err := a.readPendingLogin(r, now)
+				if err != nil {
+					restartLogin(err)
+					return
+				}
+				err = r.ParseForm()
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					w.WriteHeader(502)
+					return
+				}
+
+				code := r.FormValue("code")
+				ip := clientIp(r)
+				wait, err := a.loginLimits.allow(ip, pending.UserName, now)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					w.WriteHeader(500)
+					return
+				}
+				if wait > 0 {
+					a.Logger.Warn(funcName, slog.String("HttpMethod", "POST"), slog.String("ip", ip), slog.String("username", pending.UserName), slog.Duration("wait", wait), slog.String("DevNote", "login rate limited"))
+					w.Header().Set("Retry-After", retryAfterSeconds(wait))
+					w.WriteHeader(429)
+					errMsg := "ERROR: " + tooManyAttemptsMsg(wait)
+					totpForm := views.TotpLoginForm(views.TotpLoginFormData{FormErr: &errMsg})
+					err := totpForm.Render(ctx, w)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("Method", "POST"), slog.Any("error", err))
+					}
+					return
+				}
+				err = a.User.VerifyTotp(pending.UserId, code, now)
+				if err != nil {
+					if errors.As(err, &cuserr.NotFound{}) {
+						restartLogin(err)
+						return
+					}
+					errMsg := totpConvertErrorMsg(err)
+					if errMsg == "internal error" {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						w.WriteHeader(500)
+						return
+					}
+					a.Logger.Info(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					if !errors.As(err, &cuserr.InvalidInput{}) {
+						a.recordLoginFailure(ctx, ip, pending.UserName, "wrong two-factor code", now)
+					}
+					w.WriteHeader(422)
+					errMsg = "ERROR: " + errMsg
+					totpForm := views.TotpLoginForm(views.TotpLoginFormData{FormErr: &errMsg})
+					err := totpForm.Render(ctx, w)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("Method", "POST"), slog.Any("error", err))
+					}
+					return
+				}
+				http.SetCookie(w, expiredCookie(TOTP_PENDING_COOKIE_NAME))
+				a.finishLogin(w, funcName, pending.UserId, pending.UserName, now)
+			default:
+				w.WriteHeader(404)
+			}
+		},
+	)
+}
+
+// Shows whether 2FA is on, POST starts or restarts enrollment with a new secret
+func (a *Auth) HandleTwoFactor() http.Handler {
+	funcName := "HandleTwoFactor"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			htmxReqHeader := r.Header.Get("hx-request")
+			isHtmxRequest := htmxReqHeader == "true"
+			if !isHtmxRequest {
+				http.Error(w, "misssing header 'hx-request'", 400)
+				return
+			}
+			reqCtx := r.Context()
+			ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+			defer cancel()
+			curUser, err := UserCtx(reqCtx)
+			if err != nil {
+				a.Logger.Error(funcName, slog.Any("error", err), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+				http.Error(w, "Internal Error, try logging in again", 500)
+				return
+			}
+			switch r.Method {
+			case "GET":
+				a.renderTwoFactorView(ctx, w, funcName, curUser.UserId, views.TwoFactorPageData{})
+				return
+			case "POST":
+				_, err := a.User.BeginTotpEnrollment(curUser.UserId, time.Now())
+				if err != nil {
+					if errors.As(err, &cuserr.ItemAlreadyExists{}) {
+						w.WriteHeader(422)
+						errMsg := "two-factor authentication is already on"
+						a.renderTwoFactorView(ctx, w, funcName, curUser.UserId, views.TwoFactorPageData{FormErr: &errMsg})
+						return
+					}
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+				a.renderTwoFactorView(ctx, w, funcName, curUser.UserId, views.TwoFactorPageData{})
+				return
+			default:
+				http.Error(w, "Not valid method", 404)
+			}
+		},
+	)
+}
+
+// Turns 2FA on with a code from the new secret and shows the recovery codes once
+func (a *Auth) HandleTwoFactorConfirm() http.Handler {
+	funcName := "HandleTwoFactorConfirm"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			htmxReqHeader := r.Header.Get("hx-request")
+			isHtmxRequest := htmxReqHeader == "true"
+			if !isHtmxRequest {
+				http.Error(w, "misssing header 'hx-request'", 400)
+				return
+			}
+			reqCtx := r.Context()
+			ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+			defer cancel()
+			curUser, err := UserCtx(reqCtx)
+			if err != nil {
+				a.Logger.Error(funcName, slog.Any("error", err), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+				http.Error(w, "Internal Error, try logging in again", 500)
+				return
+			}
+			switch r.Method {
+			case "POST":
+				codes, err := a.User.ConfirmTotpEnrollment(curUser.UserId, r.FormValue("code"), time.Now())
+				if err != nil {
+					// Turned on or cancelled from another tab, show where things are now
+					if errors.As(err, &cuserr.NotFound{}) || errors.As(err, &cuserr.ItemAlreadyExists{}) {
+						a.renderTwoFactorView(ctx, w, funcName, curUser.UserId, views.TwoFactorPageData{})
+						return
+					}
+					errMsg := totpConvertErrorMsg(err)
+					if errMsg == "internal error" {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						http.Error(w, "Internal Error", 500)
+						return
+					}
+					w.WriteHeader(422)
+					a.renderTwoFactorView(ctx, w, funcName, curUser.UserId, views.TwoFactorPageData{FormErr: &errMsg})
+					return
+				}
+				a.renderTwoFactorView(ctx, w, funcName, curUser.UserId, views.TwoFactorPageData{RecoveryCodes: codes})
+				return
+			default:
+				http.Error(w, "Not valid method", 404)
+			}
+		},
+	)
+}
+
+// Turns 2FA off, the code is rate limited like a login so a session alone can't guess it
+func (a *Auth) HandleTwoFactorDisable() http.Handler {
+	funcName := "HandleTwoFactorDisable"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			htmxReqHeader := r.Header.Get("hx-request")
+			isHtmxRequest := htmxReqHeader == "true"
+			if !isHtmxRequest {
+				http.Error(w, "misssing header 'hx-request'", 400)
+				return
+			}
+			reqCtx := r.Context()
+			ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+			defer cancel()
+			curUser, err := UserCtx(reqCtx)
+			if err != nil {
+				a.Logger.Error(funcName, slog.Any("error", err), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+				http.Error(w, "Internal Error, try logging in again", 500)
+				return
+			}
+			switch r.Method {
+			case "POST":
+				now := time.Now()
+				ip := clientIp(r)
+				wait, err := a.loginLimits.allow(ip, curUser.UserName, now)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+				if wait > 0 {
+					w.Header().Set("Retry-After", retryAfterSeconds(wait))
+					w.WriteHeader(422)
+					errMsg := tooManyAttemptsMsg(wait)
+					a.renderTwoFactorView(ctx, w, funcName, curUser.UserId, views.TwoFactorPageData{FormErr: &errMsg})
+					return
+				}
+				err = a.User.DisableTotp(curUser.UserId, r.FormValue("code"), now)
+				if err != nil {
+					if errors.As(err, &cuserr.NotFound{}) {
+						a.renderTwoFactorView(ctx, w, funcName, curUser.UserId, views.TwoFactorPageData{})
+						return
+					}
+					errMsg := totpConvertErrorMsg(err)
+					if errMsg == "internal error" {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						http.Error(w, "Internal Error", 500)
+						return
+					}
+					if !errors.As(err, &cuserr.InvalidInput{}) {
+						_, err := a.loginLimits.failure(ip, curUser.UserName, now)
+						if err != nil {
+							a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						}
+					}
+					w.WriteHeader(422)
+					a.renderTwoFactorView(ctx, w, funcName, curUser.UserId, views.TwoFactorPageData{FormErr: &errMsg})
+					return
+				}
+				a.renderTwoFactorView(ctx, w, funcName, curUser.UserId, views.TwoFactorPageData{})
+				return
+			default:
+				http.Error(w, "Not valid method", 404)
+			}
+		},
+	)
+}
+
+// Draws the QR code here since the secret in it never goes through a url or js
+func (a *Auth) renderTwoFactorView(ctx context.Context, w http.ResponseWriter, funcName string, userId int, data views.TwoFactorPageData) {
+	status, err := a.User.TotpStatus(userId)
+	if err != nil {
+		a.Logger.Error(funcName, slog.Any("error", err))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	data.Status = status
+	if status.Enrollment != nil {
+		code, err := qr.Encode(status.Enrollment.URI)
+		if err != nil {
+			a.Logger.Error(funcName, slog.Any("error", err))
+			http.Error(w, "Internal Error", 500)
+			return
+		}
+		data.QRCode = code.SVG(TOTP_QR_PIXELS)
+	}
+	tmplTwoFactor := views.TwoFactorView(data)
+	err = tmplTwoFactor.Render(ctx, w)
+	if err != nil {
+		a.Logger.Error(funcName, slog.Any("error", err))
+	}
+}
+
+func totpConvertErrorMsg(err error) string {
+	invalidInputErr := &cuserr.InvalidInput{}
+	if errors.As(err, invalidInputErr) {
+		return invalidInputErr.Error()
+	}
+
+	// A reused code gets the same message, it was right but can't be accepted again
+	if errors.As(err, &cuserr.InvalidCred{}) || errors.As(err, &cuserr.Reused{}) {
+		return TOTP_FAILED_MSG
+	}
+
+	return "internal error"
+}
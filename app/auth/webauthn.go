@@ -0,0 +1,385 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/app/templates/views"
+	"wonk/app/webauthn"
+)
+
+const (
+	PASSKEY_CHALLENGE_COOKIE_NAME = "WonkWebauthn"
+	// How long the browser has between getting the options and sending back the response
+	PASSKEY_CHALLENGE_DURATION = 5 * time.Minute
+
+	PASSKEY_CEREMONY_LOGIN    = "login"
+	PASSKEY_CEREMONY_REGISTER = "register"
+
+	PASSKEY_FAILED_MSG  = "passkey could not be used, try again or log in with your password"
+	PASSKEY_EXPIRED_MSG = "passkey request expired, try again"
+)
+
+// The challenge itself is kept in the db, the cookie only holds the id it was saved under
+func (a *Auth) setPasskeyChallenge(w http.ResponseWriter, id string) error {
+	value, err := a.encryptCookieValue(PASSKEY_CHALLENGE_COOKIE_NAME, id)
+	if err != nil {
+		return fmt.Errorf("setPasskeyChallenge: %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     PASSKEY_CHALLENGE_COOKIE_NAME,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(PASSKEY_CHALLENGE_DURATION.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// Uses up the challenge the cookie points to, a missing or unreadable cookie is a missing challenge
+func (a *Auth) readPasskeyChallenge(r *http.Request, ceremony string, userId int, now time.Time) ([]byte, error) {
+	id := ""
+	cookie, err := r.Cookie(PASSKEY_CHALLENGE_COOKIE_NAME)
+	if err == nil {
+		id, err = a.decryptCookieValue(PASSKEY_CHALLENGE_COOKIE_NAME, cookie.Value)
+		if err != nil {
+			a.Logger.Info("readPasskeyChallenge", slog.Any("error", err))
+			id = ""
+		}
+	}
+	challenge, err := a.User.UsePasskeyChallenge(id, ceremony, userId, now)
+	if err != nil {
+		return nil, fmt.Errorf("readPasskeyChallenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// True when the challenge was missing, used or expired rather than failing to load
+func passkeyChallengeGone(err error) bool {
+	return errors.As(err, &cuserr.NotFound{}) || errors.As(err, &cuserr.Expired{})
+}
+
+func writeJson(w http.ResponseWriter, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		return fmt.Errorf("writeJson: %w", err)
+	}
+	return nil
+}
+
+// Starts a passkey login, any of the site's passkeys can answer so no username is needed
+func (a *Auth) HandlePasskeyLoginOptions() http.Handler {
+	funcName := "HandlePasskeyLoginOptions"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case "POST":
+				now := time.Now()
+				ip := clientIp(r)
+				wait, err := a.loginLimits.allowIp(ip, now)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					w.WriteHeader(500)
+					return
+				}
+				if wait > 0 {
+					a.Logger.Warn(funcName, slog.String("HttpMethod", "POST"), slog.String("ip", ip), slog.Duration("wait", wait), slog.String("DevNote", "login rate limited"))
+					w.Header().Set("Retry-After", retryAfterSeconds(wait))
+					w.WriteHeader(429)
+					return
+				}
+				id, challenge, err := a.User.StartPasskeyChallenge(PASSKEY_CEREMONY_LOGIN, 0, now, PASSKEY_CHALLENGE_DURATION)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					w.WriteHeader(500)
+					return
+				}
+				err = a.setPasskeyChallenge(w, id)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					w.WriteHeader(500)
+					return
+				}
+				err = writeJson(w, a.RelyingParty.RequestOptions(challenge))
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+				}
+			default:
+				w.WriteHeader(404)
+			}
+		},
+	)
+}
+
+// Finishes a passkey login. A passkey already proves possession and user verification,
+// so two-factor isn't asked for on top of it.
+func (a *Auth) HandlePasskeyLogin() http.Handler {
+	funcName := "HandlePasskeyLogin"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), time.Second*20)
+			defer cancel()
+			switch r.Method {
+			case "POST":
+				now := time.Now()
+				renderForm := func(status int, msg string) {
+					w.WriteHeader(status)
+					errMsg := "ERROR: " + msg
+					passkeyForm := views.PasskeyLoginForm(views.PasskeyLoginFormData{FormErr: &errMsg})
+					err := passkeyForm.Render(ctx, w)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("Method", "POST"), slog.Any("error", err))
+					}
+				}
+				challenge, err := a.readPasskeyChallenge(r, PASSKEY_CEREMONY_LOGIN, 0, now)
+				if err != nil {
+					if !passkeyChallengeGone(err) {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						w.WriteHeader(500)
+						return
+					}
+					a.Logger.Info(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					renderForm(422, PASSKEY_EXPIRED_MSG)
+					return
+				}
+				// The challenge is already used up, the cookie is of no more use
+				http.SetCookie(w, expiredCookie(PASSKEY_CHALLENGE_COOKIE_NAME))
+
+				ip := clientIp(r)
+				wait, err := a.loginLimits.allowIp(ip, now)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					w.WriteHeader(500)
+					return
+				}
+				if wait > 0 {
+					a.Logger.Warn(funcName, slog.String("HttpMethod", "POST"), slog.String("ip", ip), slog.Duration("wait", wait), slog.String("DevNote", "login rate limited"))
+					w.Header().Set("Retry-After", retryAfterSeconds(wait))
+					renderForm(429, tooManyAttemptsMsg(wait))
+					return
+				}
+				cred := webauthn.AssertionCredential{}
+				err = json.Unmarshal([]byte(r.FormValue("credential")), &cred)
+				if err != nil {
+					a.Logger.Info(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					renderForm(422, PASSKEY_FAILED_MSG)
+					return
+				}
+				userId, userName, err := a.User.PasskeyLogin(a.RelyingParty, challenge, &cred, now)
+				if err != nil {
+					if !errors.As(err, &cuserr.InvalidCred{}) && !errors.As(err, &cuserr.Reused{}) {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						w.WriteHeader(500)
+						return
+					}
+					a.Logger.Info(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					_, err := a.loginLimits.failureIp(ip, now)
+					if err != nil {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					}
+					renderForm(422, PASSKEY_FAILED_MSG)
+					return
+				}
+				a.finishLogin(w, funcName, userId, userName, now)
+			default:
+				w.WriteHeader(404)
+			}
+		},
+	)
+}
+
+// Lists the user's passkeys, POST saves a new one from the registration response
+func (a *Auth) HandlePasskeys() http.Handler {
+	funcName := "HandlePasskeys"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			htmxReqHeader := r.Header.Get("hx-request")
+			isHtmxRequest := htmxReqHeader == "true"
+			if !isHtmxRequest {
+				http.Error(w, "misssing header 'hx-request'", 400)
+				return
+			}
+			reqCtx := r.Context()
+			ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+			defer cancel()
+			curUser, err := UserCtx(reqCtx)
+			if err != nil {
+				a.Logger.Error(funcName, slog.Any("error", err), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+				http.Error(w, "Internal Error, try logging in again", 500)
+				return
+			}
+			switch r.Method {
+			case "GET":
+				a.renderPasskeysView(ctx, w, funcName, curUser.UserId, views.PasskeysPageData{})
+				return
+			case "POST":
+				now := time.Now()
+				challenge, err := a.readPasskeyChallenge(r, PASSKEY_CEREMONY_REGISTER, curUser.UserId, now)
+				if err != nil {
+					if !passkeyChallengeGone(err) {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						http.Error(w, "Internal Error", 500)
+						return
+					}
+					a.Logger.Info(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					w.WriteHeader(422)
+					errMsg := PASSKEY_EXPIRED_MSG
+					a.renderPasskeysView(ctx, w, funcName, curUser.UserId, views.PasskeysPageData{FormErr: &errMsg})
+					return
+				}
+				http.SetCookie(w, expiredCookie(PASSKEY_CHALLENGE_COOKIE_NAME))
+				cred := webauthn.RegistrationCredential{}
+				err = json.Unmarshal([]byte(r.FormValue("credential")), &cred)
+				if err != nil {
+					err = fmt.Errorf("%w: %w", cuserr.InvalidCred{Item: "passkey", Reason: "the response isn't valid"}, err)
+				} else {
+					err = a.User.RegisterPasskey(a.RelyingParty, curUser.UserId, challenge, &cred, r.FormValue("name"), now)
+				}
+				if err != nil {
+					errMsg := passkeyConvertErrorMsg(err)
+					if errMsg == "internal error" {
+						a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+						http.Error(w, "Internal Error", 500)
+						return
+					}
+					a.Logger.Info(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					w.WriteHeader(422)
+					a.renderPasskeysView(ctx, w, funcName, curUser.UserId, views.PasskeysPageData{FormErr: &errMsg})
+					return
+				}
+				a.renderPasskeysView(ctx, w, funcName, curUser.UserId, views.PasskeysPageData{})
+				return
+			default:
+				http.Error(w, "Not valid method", 404)
+			}
+		},
+	)
+}
+
+// Starts registering a passkey for the logged in user
+func (a *Auth) HandlePasskeyOptions() http.Handler {
+	funcName := "HandlePasskeyOptions"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			curUser, err := UserCtx(r.Context())
+			if err != nil {
+				a.Logger.Error(funcName, slog.Any("error", err), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+				http.Error(w, "Internal Error, try logging in again", 500)
+				return
+			}
+			switch r.Method {
+			case "POST":
+				now := time.Now()
+				passkeyUser, err := a.User.PasskeyUser(curUser.UserId)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+				id, challenge, err := a.User.StartPasskeyChallenge(PASSKEY_CEREMONY_REGISTER, curUser.UserId, now, PASSKEY_CHALLENGE_DURATION)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+				err = a.setPasskeyChallenge(w, id)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+				opts := a.RelyingParty.CreationOptions(challenge, passkeyUser.Handle, passkeyUser.UserName, passkeyUser.CredentialIds)
+				err = writeJson(w, opts)
+				if err != nil {
+					a.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.Any("error", err))
+				}
+			default:
+				http.Error(w, "Not valid method", 404)
+			}
+		},
+	)
+}
+
+func (a *Auth) HandlePasskeyById() http.Handler {
+	funcName := "HandlePasskeyById"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			htmxReqHeader := r.Header.Get("hx-request")
+			isHtmxRequest := htmxReqHeader == "true"
+			if !isHtmxRequest {
+				http.Error(w, "misssing header 'hx-request'", 400)
+				return
+			}
+			reqCtx := r.Context()
+			ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+			defer cancel()
+			curUser, err := UserCtx(reqCtx)
+			if err != nil {
+				a.Logger.Error(funcName, slog.Any("error", err), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+				http.Error(w, "Internal Error, try logging in again", 500)
+				return
+			}
+			passkeyId, err := strconv.Atoi(r.PathValue("id"))
+			if err != nil {
+				http.Error(w, "Bad Request: Id Isn't a int", 400)
+				return
+			}
+			switch r.Method {
+			case "DELETE":
+				err := a.User.DeletePasskey(curUser.UserId, passkeyId)
+				if err != nil {
+					if errors.As(err, &cuserr.NotFound{}) {
+						http.Error(w, "Passkey not found", 404)
+						return
+					}
+					a.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.Any("error", err))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+				a.renderPasskeysView(ctx, w, funcName, curUser.UserId, views.PasskeysPageData{})
+				return
+			default:
+				http.Error(w, "Not valid method", 404)
+			}
+		},
+	)
+}
+
+func (a *Auth) renderPasskeysView(ctx context.Context, w http.ResponseWriter, funcName string, userId int, data views.PasskeysPageData) {
+	passkeys, err := a.User.Passkeys(userId)
+	if err != nil {
+		a.Logger.Error(funcName, slog.Any("error", err))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	data.Passkeys = passkeys
+	tmplPasskeys := views.PasskeysView(data)
+	err = tmplPasskeys.Render(ctx, w)
+	if err != nil {
+		a.Logger.Error(funcName, slog.Any("error", err))
+	}
+}
+
+func passkeyConvertErrorMsg(err error) string {
+	invalidInputErr := &cuserr.InvalidInput{}
+	if errors.As(err, invalidInputErr) {
+		return invalidInputErr.Error()
+	}
+	if errors.As(err, &cuserr.ItemAlreadyExists{}) {
+		return "that passkey is already added"
+	}
+	if errors.As(err, &cuserr.InvalidCred{}) {
+		return "passkey could not be verified, try again"
+	}
+	return "internal error"
+}
@@ -0,0 +1,295 @@
+// Package chart renders simple charts as standalone SVG so pages don't need a js charting library.
+// Output only depends on the input data so it can be compared against golden files.
+package chart
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	DEFAULT_WIDTH  = 640
+	DEFAULT_HEIGHT = 320
+	DEFAULT_SIZE   = 240
+	marginTop      = 30
+	marginBottom   = 30
+	marginLeft     = 60
+	marginRight    = 10
+	legendWidth    = 140
+	legendRow      = 16
+	fontSize       = 11
+)
+
+// Colors are assigned to series in order and repeat when there are more series than colors
+var Palette = []string{"#2563eb", "#dc2626", "#16a34a", "#d97706", "#7c3aed", "#0891b2", "#db2777", "#65a30d", "#4b5563", "#ea580c"}
+
+func color(i int) string {
+	return Palette[i%len(Palette)]
+}
+
+type Series struct {
+	Label  string
+	Values []float64 // One value per chart label
+}
+
+// Bars per label with each series stacked on top of the previous one.
+// Negative values are drawn as 0.
+type StackedBar struct {
+	Title  string
+	Labels []string
+	Series []Series
+	Width  int
+	Height int
+}
+
+type Slice struct {
+	Label string
+	Value float64
+}
+
+// Share of each slice out of the total, slices at or below 0 are skipped
+type Donut struct {
+	Title  string
+	Slices []Slice
+	Size   int
+}
+
+// One value per label joined by a line, a dashed line marks 0 when the values cross it
+type Line struct {
+	Title  string
+	Labels []string
+	Values []float64
+	Width  int
+	Height int
+}
+
+func num(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+func esc(s string) string {
+	return html.EscapeString(s)
+}
+
+func orDefault(v, d int) int {
+	if v <= 0 {
+		return d
+	}
+	return v
+}
+
+func openSvg(b *strings.Builder, width, height int, title string) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" role="img" aria-label="%s" font-family="sans-serif" font-size="%d">`+"\n",
+		width, height, width, height, esc(title), fontSize)
+	fmt.Fprintf(b, `<title>%s</title>`+"\n", esc(title))
+	fmt.Fprintf(b, `<text x="%d" y="18" text-anchor="middle" font-size="%d" font-weight="bold">%s</text>`+"\n", width/2, fontSize+3, esc(title))
+}
+
+func closeSvg(b *strings.Builder) {
+	b.WriteString("</svg>\n")
+}
+
+// Horizontal grid lines with their values along the left axis
+func yAxis(b *strings.Builder, minV, maxV float64, top, bottom, left, right int) {
+	steps := 4
+	for i := 0; i <= steps; i++ {
+		v := minV + (maxV-minV)*float64(i)/float64(steps)
+		y := scaleY(v, minV, maxV, top, bottom)
+		fmt.Fprintf(b, `<line x1="%d" y1="%s" x2="%d" y2="%s" stroke="#e5e7eb"/>`+"\n", left, num(y), right, num(y))
+		fmt.Fprintf(b, `<text x="%d" y="%s" text-anchor="end" dominant-baseline="middle">%s</text>`+"\n", left-4, num(y), num(v))
+	}
+}
+
+func scaleY(v, minV, maxV float64, top, bottom int) float64 {
+	if maxV == minV {
+		return float64(bottom)
+	}
+	return float64(bottom) - (v-minV)/(maxV-minV)*float64(bottom-top)
+}
+
+func xLabels(b *strings.Builder, labels []string, left, right, y int) {
+	if len(labels) == 0 {
+		return
+	}
+	step := float64(right-left) / float64(len(labels))
+	for i, l := range labels {
+		x := float64(left) + step*(float64(i)+0.5)
+		fmt.Fprintf(b, `<text x="%s" y="%d" text-anchor="middle">%s</text>`+"\n", num(x), y, esc(l))
+	}
+}
+
+func legend(b *strings.Builder, x, y int, labels []string) {
+	for i, l := range labels {
+		rowY := y + i*legendRow
+		fmt.Fprintf(b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`+"\n", x, rowY, color(i))
+		fmt.Fprintf(b, `<text x="%d" y="%d" dominant-baseline="hanging">%s</text>`+"\n", x+14, rowY, esc(l))
+	}
+}
+
+func noData(b *strings.Builder, width, height int) {
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="middle" fill="#6b7280">No data</text>`+"\n", width/2, height/2)
+}
+
+func (c StackedBar) SVG() string {
+	width := orDefault(c.Width, DEFAULT_WIDTH)
+	height := orDefault(c.Height, DEFAULT_HEIGHT)
+	b := &strings.Builder{}
+	openSvg(b, width, height, c.Title)
+
+	left, top := marginLeft, marginTop
+	right := width - marginRight - legendWidth
+	bottom := height - marginBottom
+	maxV := 0.0
+	for i := range c.Labels {
+		maxV = math.Max(maxV, c.columnTotal(i))
+	}
+	if len(c.Labels) == 0 || maxV == 0 {
+		noData(b, width, height)
+		closeSvg(b)
+		return b.String()
+	}
+
+	yAxis(b, 0, maxV, top, bottom, left, right)
+	step := float64(right-left) / float64(len(c.Labels))
+	barWidth := step * 0.7
+	for i, label := range c.Labels {
+		x := float64(left) + step*float64(i) + (step-barWidth)/2
+		stacked := 0.0
+		for si, s := range c.Series {
+			v := valueAt(s.Values, i)
+			if v <= 0 {
+				continue
+			}
+			y := scaleY(stacked+v, 0, maxV, top, bottom)
+			h := scaleY(stacked, 0, maxV, top, bottom) - y
+			fmt.Fprintf(b, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"><title>%s %s: %s</title></rect>`+"\n",
+				num(x), num(y), num(barWidth), num(h), color(si), esc(s.Label), esc(label), num(v))
+			stacked += v
+		}
+	}
+	xLabels(b, c.Labels, left, right, bottom+16)
+	labels := []string{}
+	for _, s := range c.Series {
+		labels = append(labels, s.Label)
+	}
+	legend(b, right+10, top, labels)
+	closeSvg(b)
+	return b.String()
+}
+
+func (c StackedBar) columnTotal(i int) float64 {
+	total := 0.0
+	for _, s := range c.Series {
+		total += math.Max(0, valueAt(s.Values, i))
+	}
+	return total
+}
+
+func valueAt(values []float64, i int) float64 {
+	if i < len(values) {
+		return values[i]
+	}
+	return 0
+}
+
+func (c Donut) SVG() string {
+	size := orDefault(c.Size, DEFAULT_SIZE)
+	width := size + legendWidth
+	height := size + marginTop
+	b := &strings.Builder{}
+	openSvg(b, width, height, c.Title)
+
+	total := 0.0
+	for _, s := range c.Slices {
+		total += math.Max(0, s.Value)
+	}
+	cx := float64(size) / 2
+	cy := float64(marginTop) + float64(size)/2
+	strokeWidth := float64(size) / 6
+	r := float64(size)/2 - strokeWidth/2 - 4
+	circumference := 2 * math.Pi * r
+	fmt.Fprintf(b, `<circle cx="%s" cy="%s" r="%s" fill="none" stroke="#e5e7eb" stroke-width="%s"/>`+"\n", num(cx), num(cy), num(r), num(strokeWidth))
+	if total == 0 {
+		noData(b, size, height+marginTop)
+		closeSvg(b)
+		return b.String()
+	}
+
+	// Slices are dashes along one circle, rotated so the first starts at 12 o'clock
+	offset := 0.0
+	legendIdx := 0
+	for i, s := range c.Slices {
+		if s.Value <= 0 {
+			continue
+		}
+		length := s.Value / total * circumference
+		share := s.Value / total * 100
+		fmt.Fprintf(b, `<circle cx="%s" cy="%s" r="%s" fill="none" stroke="%s" stroke-width="%s" stroke-dasharray="%s %s" stroke-dashoffset="%s" transform="rotate(-90 %s %s)"><title>%s: %s (%s%%)</title></circle>`+"\n",
+			num(cx), num(cy), num(r), color(i), num(strokeWidth), num(length), num(circumference-length), num(-offset), num(cx), num(cy), esc(s.Label), num(s.Value), num(share))
+		offset += length
+		// Legend rows keep the slice's color even when earlier slices were skipped
+		rowY := marginTop + legendIdx*legendRow
+		fmt.Fprintf(b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`+"\n", size+10, rowY, color(i))
+		fmt.Fprintf(b, `<text x="%d" y="%d" dominant-baseline="hanging">%s %s%%</text>`+"\n", size+24, rowY, esc(s.Label), num(math.Round(share)))
+		legendIdx++
+	}
+	closeSvg(b)
+	return b.String()
+}
+
+func (c Line) SVG() string {
+	width := orDefault(c.Width, DEFAULT_WIDTH)
+	height := orDefault(c.Height, DEFAULT_HEIGHT)
+	b := &strings.Builder{}
+	openSvg(b, width, height, c.Title)
+
+	left, top := marginLeft, marginTop
+	right := width - marginRight
+	bottom := height - marginBottom
+	if len(c.Values) == 0 {
+		noData(b, width, height)
+		closeSvg(b)
+		return b.String()
+	}
+	// 0 is always in range so the axis shows whether values are positive or negative
+	minV, maxV := 0.0, 0.0
+	for _, v := range c.Values {
+		minV = math.Min(minV, v)
+		maxV = math.Max(maxV, v)
+	}
+	if minV == maxV {
+		maxV = 1
+	}
+
+	yAxis(b, minV, maxV, top, bottom, left, right)
+	if minV < 0 {
+		zeroY := scaleY(0, minV, maxV, top, bottom)
+		fmt.Fprintf(b, `<line x1="%d" y1="%s" x2="%d" y2="%s" stroke="#6b7280" stroke-dasharray="4 4"/>`+"\n", left, num(zeroY), right, num(zeroY))
+	}
+	step := float64(right-left) / float64(len(c.Values))
+	points := []string{}
+	for i, v := range c.Values {
+		x := float64(left) + step*(float64(i)+0.5)
+		points = append(points, num(x)+","+num(scaleY(v, minV, maxV, top, bottom)))
+	}
+	fmt.Fprintf(b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`+"\n", strings.Join(points, " "), color(0))
+	for i, v := range c.Values {
+		xy := strings.Split(points[i], ",")
+		label := ""
+		if i < len(c.Labels) {
+			label = c.Labels[i]
+		}
+		fmt.Fprintf(b, `<circle cx="%s" cy="%s" r="3" fill="%s"><title>%s: %s</title></circle>`+"\n", xy[0], xy[1], color(0), esc(label), num(v))
+	}
+	xLabels(b, c.Labels, left, right, bottom+16)
+	closeSvg(b)
+	return b.String()
+}
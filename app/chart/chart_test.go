@@ -0,0 +1,100 @@
+package chart_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"wonk/app/chart"
+)
+
+// Run `go test ./app/chart -update` to rewrite the golden files after an intended change
+var update = flag.Bool("update", false, "update golden files")
+
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden.svg")
+	if *update {
+		err := os.WriteFile(path, []byte(got), 0644)
+		if err != nil {
+			t.Fatalf("writing golden file: %v", err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	if string(want) != got {
+		t.Errorf("%s doesn't match %s\ngot:\n%s", name, path, got)
+	}
+}
+
+func TestStackedBarSVG(t *testing.T) {
+	tests := []struct {
+		name  string
+		chart chart.StackedBar
+	}{
+		{name: "stacked_bar", chart: chart.StackedBar{
+			Title:  "Spending by Month",
+			Labels: []string{"Jan 2025", "Feb 2025", "Mar 2025"},
+			Series: []chart.Series{
+				{Label: "Food", Values: []float64{120.5, 80, 95.25}},
+				{Label: "Rent & Bills", Values: []float64{900, 900, 900}},
+				{Label: "Fun", Values: []float64{0, 40, -10}},
+			},
+		}},
+		{name: "stacked_bar_empty", chart: chart.StackedBar{Title: "Spending by Month"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, tt.name, tt.chart.SVG())
+		})
+	}
+}
+
+func TestDonutSVG(t *testing.T) {
+	tests := []struct {
+		name  string
+		chart chart.Donut
+	}{
+		{name: "donut", chart: chart.Donut{
+			Title: "Spending Share",
+			Slices: []chart.Slice{
+				{Label: "Food", Value: 300},
+				{Label: "Income", Value: 0},
+				{Label: "Rent", Value: 600},
+				{Label: "<script>", Value: 100},
+			},
+		}},
+		{name: "donut_empty", chart: chart.Donut{Title: "Spending Share"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, tt.name, tt.chart.SVG())
+		})
+	}
+}
+
+func TestLineSVG(t *testing.T) {
+	tests := []struct {
+		name  string
+		chart chart.Line
+	}{
+		{name: "line", chart: chart.Line{
+			Title:  "Net Income",
+			Labels: []string{"Jan 2025", "Feb 2025", "Mar 2025", "Apr 2025"},
+			Values: []float64{250, -120.75, 0, 410},
+		}},
+		{name: "line_positive", chart: chart.Line{
+			Title:  "Net Income",
+			Labels: []string{"Jan 2025", "Feb 2025"},
+			Values: []float64{100, 200},
+		}},
+		{name: "line_empty", chart: chart.Line{Title: "Net Income"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, tt.name, tt.chart.SVG())
+		})
+	}
+}
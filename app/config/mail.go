@@ -0,0 +1,95 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	MAIL_SENDER_SMTP = "smtp"
+	MAIL_SENDER_FILE = "file"
+	MAIL_SENDER_LOG  = "log"
+
+	DEFAULT_SMTP_PORT = 587
+	DEFAULT_MAIL_DIR  = "mail"
+	DEFAULT_MAIL_FROM = "wonk@localhost"
+	DEFAULT_BASE_URL  = "http://localhost:8070"
+)
+
+type Mail struct {
+	Sender       string // smtp, file or log
+	SmtpHost     string
+	SmtpPort     int
+	SmtpUsername string // Empty to skip auth
+	SmtpPassword string
+	From         string
+	Dir          string // Where the file sender writes emails
+	BaseURL      string // Links in emails and the calendar feed url start with this, never with the request's host
+}
+
+func (m *Mail) Valid() error {
+	if m == nil {
+		return errors.New("Mail is nil")
+	}
+	switch m.Sender {
+	case MAIL_SENDER_SMTP:
+		if m.SmtpHost == "" {
+			return errors.New("Mail: smtp host is empty")
+		}
+		if m.SmtpPort <= 0 || m.SmtpPort > 65535 {
+			return errors.New("Mail: smtp port is invalid")
+		}
+	case MAIL_SENDER_FILE:
+		if m.Dir == "" {
+			return errors.New("Mail: dir is empty")
+		}
+	case MAIL_SENDER_LOG:
+	default:
+		return fmt.Errorf("Mail: unknown sender %q", m.Sender)
+	}
+	err := secureURL(m.BaseURL)
+	if err != nil {
+		return fmt.Errorf("Mail: base url: %w", err)
+	}
+	return nil
+}
+
+// Defaults to the log sender so development doesn't need a mail server
+func InitMail(getEnv func(string) string) (*Mail, error) {
+	m := Mail{
+		Sender:       getEnv("MAIL_SENDER"),
+		SmtpHost:     getEnv("SMTP_HOST"),
+		SmtpPort:     DEFAULT_SMTP_PORT,
+		SmtpUsername: getEnv("SMTP_USERNAME"),
+		SmtpPassword: getEnv("SMTP_PASSWORD"),
+		From:         getEnv("MAIL_FROM"),
+		Dir:          getEnv("MAIL_DIR"),
+		BaseURL:      strings.TrimSuffix(getEnv("APP_BASE_URL"), "/"),
+	}
+	if m.Sender == "" {
+		m.Sender = MAIL_SENDER_LOG
+	}
+	if m.From == "" {
+		m.From = DEFAULT_MAIL_FROM
+	}
+	if m.Dir == "" {
+		m.Dir = DEFAULT_MAIL_DIR
+	}
+	if m.BaseURL == "" {
+		m.BaseURL = DEFAULT_BASE_URL
+	}
+	if port := getEnv("SMTP_PORT"); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("InitMail: smtp port: %w", err)
+		}
+		m.SmtpPort = p
+	}
+	err := m.Valid()
+	if err != nil {
+		return nil, fmt.Errorf("InitMail: %w", err)
+	}
+	return &m, nil
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	DEFAULT_OIDC_REDIRECT_URL  = "http://localhost:8070/login/oidc/callback"
+	DEFAULT_OIDC_SCOPES        = "openid email profile"
+	DEFAULT_OIDC_PROVIDER_NAME = "Single Sign-On"
+)
+
+type OIDC struct {
+	Issuer       string // Exactly as in the provider's discovery document
+	ClientId     string
+	ClientSecret string   // Empty for a public client
+	RedirectURL  string   // Registered with the provider, ends in /login/oidc/callback
+	Scopes       []string // Always includes openid
+	ProviderName string   // Shown on the login button
+	// Creates an account the first time someone logs in through the provider, otherwise
+	// they have to link it from an account that already exists
+	AutoProvision bool
+}
+
+func (o *OIDC) Valid() error {
+	if o == nil {
+		return errors.New("OIDC is nil")
+	}
+	if o.ClientId == "" {
+		return errors.New("OIDC: client id is empty")
+	}
+	err := secureURL(o.Issuer)
+	if err != nil {
+		return fmt.Errorf("OIDC: issuer: %w", err)
+	}
+	err = secureURL(o.RedirectURL)
+	if err != nil {
+		return fmt.Errorf("OIDC: redirect url: %w", err)
+	}
+	if !slices.Contains(o.Scopes, "openid") {
+		return errors.New("OIDC: scopes must include openid")
+	}
+	return nil
+}
+
+// Plain http is only allowed on the local machine, for development and tests
+func secureURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("%q is not an absolute url without a query", s)
+	}
+	if u.Scheme == "https" {
+		return nil
+	}
+	host := u.Hostname()
+	ip := net.ParseIP(host)
+	if u.Scheme == "http" && (host == "localhost" || (ip != nil && ip.IsLoopback())) {
+		return nil
+	}
+	return fmt.Errorf("%q is not https", s)
+}
+
+// Returns nil when OIDC_ISSUER isn't set, logging in through a provider is turned off.
+// Scopes are space separated like in the authorization request.
+func InitOIDC(getEnv func(string) string) (*OIDC, error) {
+	issuer := getEnv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	o := OIDC{
+		Issuer:       issuer,
+		ClientId:     getEnv("OIDC_CLIENT_ID"),
+		ClientSecret: getEnv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  getEnv("OIDC_REDIRECT_URL"),
+		ProviderName: getEnv("OIDC_PROVIDER_NAME"),
+	}
+	if o.RedirectURL == "" {
+		o.RedirectURL = DEFAULT_OIDC_REDIRECT_URL
+	}
+	if o.ProviderName == "" {
+		o.ProviderName = DEFAULT_OIDC_PROVIDER_NAME
+	}
+	scopes := getEnv("OIDC_SCOPES")
+	if scopes == "" {
+		scopes = DEFAULT_OIDC_SCOPES
+	}
+	o.Scopes = strings.Fields(scopes)
+	if autoProvision := getEnv("OIDC_AUTO_PROVISION"); autoProvision != "" {
+		b, err := strconv.ParseBool(autoProvision)
+		if err != nil {
+			return nil, fmt.Errorf("InitOIDC: auto provision: %w", err)
+		}
+		o.AutoProvision = b
+	}
+	err := o.Valid()
+	if err != nil {
+		return nil, fmt.Errorf("InitOIDC: %w", err)
+	}
+	return &o, nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DEFAULT_SESSION_IDLE_TIMEOUT     = time.Hour
+	DEFAULT_SESSION_ABSOLUTE_TIMEOUT = 24 * time.Hour
+)
+
+type Session struct {
+	IdleTimeout     time.Duration // Logged out after this long without a request
+	AbsoluteTimeout time.Duration // Logged out this long after login however active
+}
+
+func (s *Session) Valid() error {
+	if s == nil {
+		return errors.New("Session is nil")
+	}
+	if s.IdleTimeout <= 0 {
+		return errors.New("Session: idle timeout must be positive")
+	}
+	if s.AbsoluteTimeout < s.IdleTimeout {
+		return errors.New("Session: absolute timeout is shorter than the idle timeout")
+	}
+	return nil
+}
+
+// Timeouts use time.ParseDuration's format, e.g. 30m or 12h
+func InitSession(getEnv func(string) string) (*Session, error) {
+	s := Session{
+		IdleTimeout:     DEFAULT_SESSION_IDLE_TIMEOUT,
+		AbsoluteTimeout: DEFAULT_SESSION_ABSOLUTE_TIMEOUT,
+	}
+	if idle := getEnv("SESSION_IDLE_TIMEOUT"); idle != "" {
+		d, err := time.ParseDuration(idle)
+		if err != nil {
+			return nil, fmt.Errorf("InitSession: idle timeout: %w", err)
+		}
+		s.IdleTimeout = d
+	}
+	if absolute := getEnv("SESSION_ABSOLUTE_TIMEOUT"); absolute != "" {
+		d, err := time.ParseDuration(absolute)
+		if err != nil {
+			return nil, fmt.Errorf("InitSession: absolute timeout: %w", err)
+		}
+		s.AbsoluteTimeout = d
+	}
+	err := s.Valid()
+	if err != nil {
+		return nil, fmt.Errorf("InitSession: %w", err)
+	}
+	return &s, nil
+}
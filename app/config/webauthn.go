@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	DEFAULT_WEBAUTHN_RP_ID   = "localhost"
+	DEFAULT_WEBAUTHN_ORIGINS = "http://localhost:8070"
+	WEBAUTHN_RP_NAME         = "Wonk"
+)
+
+type WebAuthn struct {
+	RPID    string   // Domain passkeys are scoped to
+	RPName  string   // Shown by the browser when creating a passkey
+	Origins []string // Where the login page is served from, e.g. https://wonk.example.com
+}
+
+func (w *WebAuthn) Valid() error {
+	if w == nil {
+		return errors.New("WebAuthn is nil")
+	}
+	if w.RPID == "" {
+		return errors.New("WebAuthn: rp id is empty")
+	}
+	if len(w.Origins) == 0 {
+		return errors.New("WebAuthn: no origins")
+	}
+	for _, origin := range w.Origins {
+		u, err := url.Parse(origin)
+		if err != nil {
+			return fmt.Errorf("WebAuthn: origin %q: %w", origin, err)
+		}
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return fmt.Errorf("WebAuthn: origin %q is not http or https", origin)
+		}
+		if u.Path != "" || u.RawQuery != "" {
+			return fmt.Errorf("WebAuthn: origin %q has a path", origin)
+		}
+		// Browsers only allow an rp id that is the origin's host or a parent of it
+		host := u.Hostname()
+		if host != w.RPID && !strings.HasSuffix(host, "."+w.RPID) {
+			return fmt.Errorf("WebAuthn: origin %q is not on rp id %q", origin, w.RPID)
+		}
+	}
+	return nil
+}
+
+// Origins are comma separated
+func InitWebAuthn(getEnv func(string) string) (*WebAuthn, error) {
+	w := WebAuthn{
+		RPID:   getEnv("WEBAUTHN_RP_ID"),
+		RPName: WEBAUTHN_RP_NAME,
+	}
+	if w.RPID == "" {
+		w.RPID = DEFAULT_WEBAUTHN_RP_ID
+	}
+	origins := getEnv("WEBAUTHN_ORIGINS")
+	if origins == "" {
+		origins = DEFAULT_WEBAUTHN_ORIGINS
+	}
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			w.Origins = append(w.Origins, origin)
+		}
+	}
+	err := w.Valid()
+	if err != nil {
+		return nil, fmt.Errorf("InitWebAuthn: %w", err)
+	}
+	return &w, nil
+}
@@ -0,0 +1,43 @@
+package csrf
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	HEADER_NAME = "X-CSRF-Token"
+	FORM_FIELD  = "csrf_token"
+)
+
+var csrfCtxKey = &contextKey{"csrf"}
+
+type contextKey struct {
+	name string
+}
+
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfCtxKey, token)
+}
+
+// FromCtx returns the session's csrf token or an empty string when there is none
+func FromCtx(ctx context.Context) string {
+	token, ok := ctx.Value(csrfCtxKey).(string)
+	if !ok {
+		return ""
+	}
+	return token
+}
+
+// HxHeaders is the hx-headers value that makes htmx send the token with every request
+func HxHeaders(ctx context.Context) string {
+	token := FromCtx(ctx)
+	if token == "" {
+		return ""
+	}
+	b, err := json.Marshal(map[string]string{HEADER_NAME: token})
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
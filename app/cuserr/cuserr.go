@@ -44,3 +44,25 @@ func (i InvalidInput) Error() string {
 	}
 	return i.FieldName + " invalid because " + i.Reason
 }
+
+type Expired struct {
+	Item string
+}
+
+func (e Expired) Error() string {
+	if e.Item == "" {
+		return "expired"
+	}
+	return e.Item + " expired"
+}
+
+type Reused struct {
+	Item string
+}
+
+func (e Reused) Error() string {
+	if e.Item == "" {
+		return "already used"
+	}
+	return e.Item + " already used"
+}
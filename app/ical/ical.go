@@ -0,0 +1,128 @@
+// Package ical writes RFC 5545 calendars of all day events
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Lines longer than this many octets, not counting the CRLF, are folded
+	MAX_LINE_OCTETS = 75
+
+	dateFormat  = "20060102"
+	stampFormat = "20060102T150405Z"
+)
+
+type Calendar struct {
+	ProdId string // ex: -//Wonk//Bills//EN
+	Name   string // Shown by most clients as the calendar name, empty to leave out
+	Events []Event
+}
+
+// An all day event, only the date of Start is used so it's the same day in every time zone
+type Event struct {
+	Uid         string // Globally unique and stable between fetches so clients update instead of duplicating
+	Summary     string
+	Description string
+	Start       time.Time
+	RRule       string // Recurrence rule without the RRULE: prefix, empty for a single event
+	Stamp       time.Time
+}
+
+// Repeats every month on day, days past the end of a short month fall on its last day.
+// BYMONTHDAY alone skips months that don't have the day, so for days after the 28th
+// the last of the days from the 28th up to day in each month is picked.
+func MonthlyOnDay(day int) string {
+	if day <= 28 {
+		return "FREQ=MONTHLY;BYMONTHDAY=" + strconv.Itoa(day)
+	}
+	days := []string{}
+	for d := 28; d <= day; d++ {
+		days = append(days, strconv.Itoa(d))
+	}
+	return "FREQ=MONTHLY;BYMONTHDAY=" + strings.Join(days, ",") + ";BYSETPOS=-1"
+}
+
+// Escapes a TEXT property value. Control characters other than tab aren't allowed
+// in TEXT so they are dropped, line breaks become \n.
+func EscapeText(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == ';':
+			b.WriteString(`\;`)
+		case r == ',':
+			b.WriteString(`\,`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Splits a content line into lines of at most MAX_LINE_OCTETS octets, continuation
+// lines start with a space. Lines are only split between UTF-8 sequences.
+func FoldLine(line string) string {
+	var b strings.Builder
+	limit := MAX_LINE_OCTETS
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// The leading space counts towards the limit
+		limit = MAX_LINE_OCTETS - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+func Write(w io.Writer, c Calendar) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		bw.WriteString(FoldLine(name + ":" + value))
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", EscapeText(c.ProdId))
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME", EscapeText(c.Name))
+	}
+	for _, e := range c.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", EscapeText(e.Uid))
+		line("DTSTAMP", e.Stamp.UTC().Format(stampFormat))
+		line("DTSTART;VALUE=DATE", e.Start.Format(dateFormat))
+		line("DTEND;VALUE=DATE", e.Start.AddDate(0, 0, 1).Format(dateFormat))
+		if e.RRule != "" {
+			line("RRULE", e.RRule)
+		}
+		line("SUMMARY", EscapeText(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", EscapeText(e.Description))
+		}
+		line("TRANSP", "TRANSPARENT")
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
@@ -0,0 +1,138 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Rent", "Rent"},
+		{`a,b;c\d`, `a\,b\;c\\d`},
+		{"line one\r\nline two\nthree", `line one\nline two\nthree`},
+		{"bell\x07 and\ttab", "bell and\ttab"},
+	}
+	for _, tt := range tests {
+		if got := EscapeText(tt.in); got != tt.want {
+			t.Errorf("EscapeText(%q): expected %q, got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestFoldLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"short", "SUMMARY:Rent"},
+		{"exactly the limit", "SUMMARY:" + strings.Repeat("a", MAX_LINE_OCTETS-len("SUMMARY:"))},
+		{"long ascii", "DESCRIPTION:" + strings.Repeat("abcdefghij", 20)},
+		// 3 octet runes would straddle the limit if split by octet count alone
+		{"multi byte", "SUMMARY:" + strings.Repeat("€", 60)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folded := FoldLine(tt.line)
+			if !strings.HasSuffix(folded, "\r\n") {
+				t.Fatalf("expected a CRLF ending, got %q", folded)
+			}
+			lines := strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n")
+			for i, l := range lines {
+				if len(l) > MAX_LINE_OCTETS {
+					t.Errorf("line %d is %d octets", i, len(l))
+				}
+				if !utf8.ValidString(l) {
+					t.Errorf("line %d splits a UTF-8 sequence: %q", i, l)
+				}
+				if i > 0 && !strings.HasPrefix(l, " ") {
+					t.Errorf("continuation line %d doesn't start with a space: %q", i, l)
+				}
+			}
+			// Unfolding is removing every CRLF followed by a single space
+			unfolded := strings.ReplaceAll(strings.TrimSuffix(folded, "\r\n"), "\r\n ", "")
+			if unfolded != tt.line {
+				t.Errorf("expected unfolding to give back %q, got %q", tt.line, unfolded)
+			}
+			if len(tt.line) <= MAX_LINE_OCTETS && len(lines) != 1 {
+				t.Errorf("expected no folding, got %q", folded)
+			}
+		})
+	}
+}
+
+func TestMonthlyOnDay(t *testing.T) {
+	tests := []struct {
+		day  int
+		want string
+	}{
+		{1, "FREQ=MONTHLY;BYMONTHDAY=1"},
+		{28, "FREQ=MONTHLY;BYMONTHDAY=28"},
+		{30, "FREQ=MONTHLY;BYMONTHDAY=28,29,30;BYSETPOS=-1"},
+		{31, "FREQ=MONTHLY;BYMONTHDAY=28,29,30,31;BYSETPOS=-1"},
+	}
+	for _, tt := range tests {
+		if got := MonthlyOnDay(tt.day); got != tt.want {
+			t.Errorf("MonthlyOnDay(%d): expected %q, got %q", tt.day, tt.want, got)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	stamp := time.Date(2025, 3, 3, 9, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+	c := Calendar{
+		ProdId: "-//Wonk//Bills//EN",
+		Name:   "Wonk",
+		Events: []Event{
+			{
+				Uid:         "bill-1@wonk",
+				Summary:     "Bill: Rent, flat",
+				Description: "Bucket: Housing",
+				Start:       time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC),
+				RRule:       MonthlyOnDay(31),
+				Stamp:       stamp,
+			},
+			{Uid: "once@wonk", Summary: "Once", Start: time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC), Stamp: stamp},
+		},
+	}
+	var buf bytes.Buffer
+	err := Write(&buf, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Wonk//Bills//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Wonk",
+		"BEGIN:VEVENT",
+		"UID:bill-1@wonk",
+		"DTSTAMP:20250303T143000Z",
+		"DTSTART;VALUE=DATE:20250131",
+		"DTEND;VALUE=DATE:20250201",
+		"RRULE:FREQ=MONTHLY;BYMONTHDAY=28,29,30,31;BYSETPOS=-1",
+		`SUMMARY:Bill: Rent\, flat`,
+		"DESCRIPTION:Bucket: Housing",
+		"TRANSP:TRANSPARENT",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:once@wonk",
+		"DTSTAMP:20250303T143000Z",
+		"DTSTART;VALUE=DATE:20251231",
+		"DTEND;VALUE=DATE:20260101",
+		"SUMMARY:Once",
+		"TRANSP:TRANSPARENT",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n") + "\r\n"
+	if buf.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, buf.String())
+	}
+}
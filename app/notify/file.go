@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Writes each email to an .eml file in Dir, for development
+type FileSender struct {
+	Logger *slog.Logger
+	Dir    string
+	From   string
+}
+
+func (s *FileSender) Send(ctx context.Context, m Message) error {
+	now := time.Now()
+	msg, err := buildMessage(s.From, m, now)
+	if err != nil {
+		return fmt.Errorf("FileSender: %w", err)
+	}
+	err = os.MkdirAll(s.Dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("FileSender: %w", err)
+	}
+	name := strconv.FormatInt(now.UnixNano(), 10) + "-" + strings.NewReplacer("@", "_at_", "/", "_").Replace(m.To) + ".eml"
+	path := filepath.Join(s.Dir, name)
+	// Renamed into place so anything watching Dir never reads half an email
+	err = os.WriteFile(path+".tmp", msg, 0o644)
+	if err != nil {
+		return fmt.Errorf("FileSender: %w", err)
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return fmt.Errorf("FileSender: %w", err)
+	}
+	s.Logger.InfoContext(ctx, "Email written", slog.String("to", m.To), slog.String("subject", m.Subject), slog.String("path", path))
+	return nil
+}
+
+// Only logs emails, the default when no sender is configured
+type LogSender struct {
+	Logger *slog.Logger
+}
+
+func (s *LogSender) Send(ctx context.Context, m Message) error {
+	err := m.valid()
+	if err != nil {
+		return fmt.Errorf("LogSender: %w", err)
+	}
+	s.Logger.InfoContext(ctx, "Email", slog.String("to", m.To), slog.String("subject", m.Subject), slog.String("text", m.Text))
+	return nil
+}
@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+	"wonk/app/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string // Plain text part
+	Html    string // Html part
+}
+
+type Sender interface {
+	Send(context.Context, Message) error
+}
+
+// Sender picked by MAIL_SENDER
+func NewSender(cfg *config.Mail, l *slog.Logger) (Sender, error) {
+	switch cfg.Sender {
+	case config.MAIL_SENDER_SMTP:
+		return &SMTPSender{
+			Host:     cfg.SmtpHost,
+			Port:     cfg.SmtpPort,
+			Username: cfg.SmtpUsername,
+			Password: cfg.SmtpPassword,
+			From:     cfg.From,
+		}, nil
+	case config.MAIL_SENDER_FILE:
+		return &FileSender{Logger: l, Dir: cfg.Dir, From: cfg.From}, nil
+	case config.MAIL_SENDER_LOG:
+		return &LogSender{Logger: l}, nil
+	}
+	return nil, fmt.Errorf("NewSender: unknown sender %q", cfg.Sender)
+}
+
+func (m Message) valid() error {
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return errors.New("Message: header contains a line break")
+	}
+	addr, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return fmt.Errorf("Message: to: %w", err)
+	}
+	if addr.Address != m.To {
+		return errors.New("Message: to must be a bare address")
+	}
+	if m.Subject == "" {
+		return errors.New("Message: subject is empty")
+	}
+	return nil
+}
+
+// RFC 5322 message with a multipart/alternative body, text first so clients prefer the html
+func buildMessage(from string, m Message, now time.Time) ([]byte, error) {
+	err := m.valid()
+	if err != nil {
+		return nil, fmt.Errorf("buildMessage: %w", err)
+	}
+	if strings.ContainsAny(from, "\r\n") {
+		return nil, errors.New("buildMessage: from contains a line break")
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", m.Text},
+		{"text/html; charset=UTF-8", m.Html},
+	}
+	for _, p := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", p.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, fmt.Errorf("buildMessage: part: %w", err)
+		}
+		qw := quotedprintable.NewWriter(pw)
+		_, err = qw.Write([]byte(p.content))
+		if err != nil {
+			return nil, fmt.Errorf("buildMessage: part: %w", err)
+		}
+		err = qw.Close()
+		if err != nil {
+			return nil, fmt.Errorf("buildMessage: part: %w", err)
+		}
+	}
+	err = mw.Close()
+	if err != nil {
+		return nil, fmt.Errorf("buildMessage: %w", err)
+	}
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return nil, fmt.Errorf("buildMessage: message id: %w", err)
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at != -1 {
+		domain = strings.TrimSuffix(from[at+1:], ">")
+	}
+
+	var msg bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", m.To},
+		{"Subject", mime.QEncoding.Encode("UTF-8", m.Subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", "<" + hex.EncodeToString(id) + "@" + domain + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	}
+	for _, h := range headers {
+		msg.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,327 @@
+package notify
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"wonk/business/finance"
+	"wonk/storage"
+)
+
+type capturedMail struct {
+	Auth string // Decoded AUTH PLAIN response
+	From string
+	To   []string
+	Data string
+}
+
+// Just enough of an SMTP server on localhost to capture what a client sends
+type smtpStandIn struct {
+	listener net.Listener
+	mu       sync.Mutex
+	mails    []capturedMail
+	wg       sync.WaitGroup
+}
+
+func startSMTPStandIn(t *testing.T) *smtpStandIn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{listener: l}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		l.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+func (s *smtpStandIn) sender() *SMTPSender {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return &SMTPSender{
+		Host: "localhost",
+		Port: addr.Port,
+		From: "wonk@example.com",
+	}
+}
+
+func (s *smtpStandIn) captured() []capturedMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]capturedMail{}, s.mails...)
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	cur := capturedMail{}
+	tp.PrintfLine("220 localhost ESMTP stand-in")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			_, resp, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(resp)
+			cur.Auth = string(decoded)
+			tp.PrintfLine("235 ok")
+		case "MAIL":
+			cur.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			cur.To = append(cur.To, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			cur.Data = string(data)
+			s.mu.Lock()
+			s.mails = append(s.mails, cur)
+			s.mu.Unlock()
+			cur = capturedMail{}
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// Decoded text and html parts of a captured multipart/alternative message
+func readParts(t *testing.T, data string) (*mail.Message, map[string]string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("expected multipart/alternative, got %q %v", mediaType, err)
+	}
+	parts := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[partType] = string(body)
+	}
+	return msg, parts
+}
+
+func TestSMTPSender(t *testing.T) {
+	server := startSMTPStandIn(t)
+	sender := server.sender()
+	sender.Username = "user"
+	sender.Password = "pass"
+	longLine := strings.Repeat("spending ", 20)
+
+	err := sender.Send(context.Background(), Message{
+		To:      "someone@example.com",
+		Subject: "Résumé of the week",
+		Text:    "Hello\n.\n" + longLine,
+		Html:    "<p>Hello</p>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mails := server.captured()
+	if len(mails) != 1 {
+		t.Fatalf("expected 1 email, got %d", len(mails))
+	}
+	got := mails[0]
+	if got.Auth != "\x00user\x00pass" {
+		t.Errorf("expected plain auth for user, got %q", got.Auth)
+	}
+	if got.From != "wonk@example.com" || len(got.To) != 1 || got.To[0] != "someone@example.com" {
+		t.Errorf("unexpected envelope %q -> %q", got.From, got.To)
+	}
+	msg, parts := readParts(t, got.Data)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Résumé of the week" {
+		t.Errorf("expected the subject to round trip, got %q %v", subject, err)
+	}
+	// The lone dot must survive SMTP dot stuffing
+	if parts["text/plain"] != "Hello\n.\n"+longLine {
+		t.Errorf("unexpected text part %q", parts["text/plain"])
+	}
+	if parts["text/html"] != "<p>Hello</p>" {
+		t.Errorf("unexpected html part %q", parts["text/html"])
+	}
+}
+
+func TestSMTPSenderRejectsHeaderInjection(t *testing.T) {
+	server := startSMTPStandIn(t)
+	sender := server.sender()
+	tests := []Message{
+		{To: "someone@example.com\r\nBcc: other@example.com", Subject: "Hi"},
+		{To: "someone@example.com", Subject: "Hi\r\nBcc: other@example.com"},
+		{To: "Someone <someone@example.com>", Subject: "Hi"},
+	}
+	for i, m := range tests {
+		err := sender.Send(context.Background(), m)
+		if err == nil {
+			t.Errorf("%d: expected an error", i)
+		}
+	}
+	if mails := server.captured(); len(mails) > 0 {
+		t.Errorf("expected nothing sent, got %+v", mails)
+	}
+}
+
+type failingSender struct{}
+
+func (failingSender) Send(context.Context, Message) error {
+	return errors.New("mail server down")
+}
+
+func TestSchedulerRunOnce(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := finance.InitFinance(db)
+	ctx := context.Background()
+	now := time.Now()
+	userId, err := db.CreateUser("scheduler", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucketId, err := db.CreateBucket(userId, "Dining", database.AuditInfo{UserId: userId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The digest is due today so both emails go out
+	problems, err := f.SetNotificationPreferences(userId, database.NotificationPreferenceInput{
+		Email:         "scheduler@example.com",
+		WeeklyDigest:  true,
+		DigestWeekday: int(now.Weekday()),
+		BudgetAlerts:  true,
+	})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	problems, err = f.SetBudget(userId, database.BudgetInput{BucketId: bucketId, Amount: 50})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	problems, err = f.SubmitNewTransaction(ctx, database.TransactionItemInput{
+		Name: "Dinner", Month: int(now.Month()), Year: now.Year(), Price: 80, IsExpense: true, UserId: userId, BucketId: bucketId, Currency: "USD",
+	})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// Nothing is marked sent when sending fails, so the next run retries
+	err = InitScheduler(l, f, failingSender{}).RunOnce(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := f.PendingNotifications(userId, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Digest == nil || len(pending.Alerts) != 1 {
+		t.Fatalf("expected the digest and alert to still be pending, got %+v", pending)
+	}
+
+	server := startSMTPStandIn(t)
+	scheduler := InitScheduler(l, f, server.sender())
+	for range 2 {
+		err = scheduler.RunOnce(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mails := server.captured()
+	if len(mails) != 2 {
+		t.Fatalf("expected the digest and one alert email, got %d", len(mails))
+	}
+	subjects := []string{}
+	for _, m := range mails {
+		if len(m.To) != 1 || m.To[0] != "scheduler@example.com" {
+			t.Errorf("expected mail to the user, got %q", m.To)
+		}
+		msg, parts := readParts(t, m.Data)
+		subjects = append(subjects, msg.Header.Get("Subject"))
+		if !strings.Contains(parts["text/plain"], "Dining") || !strings.Contains(parts["text/html"], "Dining") {
+			t.Errorf("expected both parts to mention the bucket, got %+v", parts)
+		}
+	}
+	wantSubjects := []string{"Your weekly digest for " + finance.YearMonth{Month: int(now.Month()), Year: now.Year()}.String(), "Over budget: Dining"}
+	if strings.Join(subjects, "|") != strings.Join(wantSubjects, "|") {
+		t.Errorf("expected subjects %q, got %q", wantSubjects, subjects)
+	}
+}
+
+func TestBuildMessageHeaders(t *testing.T) {
+	now := time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)
+	msg, err := buildMessage("wonk@example.com", Message{To: "a@example.com", Subject: "Hi", Text: "t", Html: "h"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	date, err := parsed.Header.Date()
+	if err != nil || !date.Equal(now) {
+		t.Errorf("expected date %s, got %s %v", now, date, err)
+	}
+	id := parsed.Header.Get("Message-ID")
+	if !strings.HasSuffix(id, "@example.com>") || len(id) != len("<@example.com>")+32 {
+		t.Errorf("unexpected message id %q", id)
+	}
+	if parsed.Header.Get("MIME-Version") != "1.0" {
+		t.Errorf("expected MIME-Version 1.0, got %q", parsed.Header.Get("MIME-Version"))
+	}
+}
@@ -0,0 +1,148 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+	"wonk/app/templates/emails"
+	"wonk/business/finance"
+)
+
+const (
+	DEFAULT_SCHEDULER_INTERVAL = 15 * time.Minute
+	SEND_TIMEOUT               = 30 * time.Second
+)
+
+// Periodically emails every user the digest and alerts their preferences ask for
+type Scheduler struct {
+	Logger   *slog.Logger
+	Finance  finance.Finance
+	Sender   Sender
+	Interval time.Duration
+}
+
+func InitScheduler(l *slog.Logger, f finance.Finance, s Sender) *Scheduler {
+	return &Scheduler{
+		Logger:   l,
+		Finance:  f,
+		Sender:   s,
+		Interval: DEFAULT_SCHEDULER_INTERVAL,
+	}
+}
+
+// Blocks until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	funcName := "Scheduler.Run"
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		err := s.RunOnce(ctx, time.Now())
+		if err != nil {
+			s.Logger.ErrorContext(ctx, err.Error(), slog.String("func", funcName))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sends whatever is pending for each recipient. A failed send is logged and
+// retried on the next run, it doesn't stop the other recipients.
+func (s *Scheduler) RunOnce(ctx context.Context, now time.Time) error {
+	funcName := "Scheduler.RunOnce"
+	recipients, err := s.Finance.NotificationRecipients()
+	if err != nil {
+		return fmt.Errorf("RunOnce: %w", err)
+	}
+	for _, r := range recipients {
+		if ctx.Err() != nil {
+			return fmt.Errorf("RunOnce: %w", ctx.Err())
+		}
+		err := s.notifyUser(ctx, r.UserId, now)
+		if err != nil {
+			s.Logger.ErrorContext(ctx, err.Error(), slog.String("func", funcName), slog.Int("userId", r.UserId))
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) notifyUser(ctx context.Context, userId int, now time.Time) error {
+	pending, err := s.Finance.PendingNotifications(userId, now)
+	if err != nil {
+		return fmt.Errorf("notifyUser: %w", err)
+	}
+	to := pending.Preference.Email
+
+	if pending.Digest != nil {
+		msg, err := digestMessage(ctx, to, *pending.Digest)
+		if err != nil {
+			return fmt.Errorf("notifyUser: %w", err)
+		}
+		err = s.send(ctx, msg)
+		if err != nil {
+			return fmt.Errorf("notifyUser: digest: %w", err)
+		}
+		err = s.Finance.MarkNotificationsSent(userId, []finance.NotificationKey{pending.Digest.NotificationKey}, now)
+		if err != nil {
+			return fmt.Errorf("notifyUser: %w", err)
+		}
+	}
+
+	if len(pending.Alerts) > 0 {
+		msg, err := alertsMessage(ctx, to, pending.Alerts)
+		if err != nil {
+			return fmt.Errorf("notifyUser: %w", err)
+		}
+		err = s.send(ctx, msg)
+		if err != nil {
+			return fmt.Errorf("notifyUser: alerts: %w", err)
+		}
+		keys := []finance.NotificationKey{}
+		for _, a := range pending.Alerts {
+			keys = append(keys, a.NotificationKey)
+		}
+		err = s.Finance.MarkNotificationsSent(userId, keys, now)
+		if err != nil {
+			return fmt.Errorf("notifyUser: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) send(ctx context.Context, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, SEND_TIMEOUT)
+	defer cancel()
+	return s.Sender.Send(ctx, msg)
+}
+
+func digestMessage(ctx context.Context, to string, d finance.Digest) (Message, error) {
+	var html bytes.Buffer
+	err := emails.Digest(d).Render(ctx, &html)
+	if err != nil {
+		return Message{}, fmt.Errorf("digestMessage: %w", err)
+	}
+	return Message{
+		To:      to,
+		Subject: emails.DigestSubject(d),
+		Text:    emails.DigestText(d),
+		Html:    html.String(),
+	}, nil
+}
+
+func alertsMessage(ctx context.Context, to string, alerts []finance.Alert) (Message, error) {
+	var html bytes.Buffer
+	err := emails.Alerts(alerts).Render(ctx, &html)
+	if err != nil {
+		return Message{}, fmt.Errorf("alertsMessage: %w", err)
+	}
+	return Message{
+		To:      to,
+		Subject: emails.AlertSubject(alerts),
+		Text:    emails.AlertsText(alerts),
+		Html:    html.String(),
+	}, nil
+}
@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string // Empty to skip auth
+	Password string
+	From     string
+	// Used for STARTTLS, nil verifies against Host
+	TLSConfig *tls.Config
+}
+
+// Upgrades to TLS when the server offers STARTTLS. Plain auth is refused
+// over an unencrypted connection unless the server is on localhost.
+func (s *SMTPSender) Send(ctx context.Context, m Message) error {
+	msg, err := buildMessage(s.From, m, time.Now())
+	if err != nil {
+		return fmt.Errorf("SMTPSender: %w", err)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+	if err != nil {
+		return fmt.Errorf("SMTPSender: dial: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTPSender: client: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		cfg := s.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{ServerName: s.Host}
+		}
+		err = c.StartTLS(cfg)
+		if err != nil {
+			return fmt.Errorf("SMTPSender: starttls: %w", err)
+		}
+	}
+	if s.Username != "" {
+		err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host))
+		if err != nil {
+			return fmt.Errorf("SMTPSender: auth: %w", err)
+		}
+	}
+	err = c.Mail(s.From)
+	if err != nil {
+		return fmt.Errorf("SMTPSender: mail: %w", err)
+	}
+	err = c.Rcpt(m.To)
+	if err != nil {
+		return fmt.Errorf("SMTPSender: rcpt: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("SMTPSender: data: %w", err)
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return fmt.Errorf("SMTPSender: data: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("SMTPSender: data: %w", err)
+	}
+	err = c.Quit()
+	if err != nil {
+		return fmt.Errorf("SMTPSender: quit: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+// Package oidc logs users in through an OpenID Connect provider with the authorization code
+// flow and PKCE. Only what a login needs is here: discovery, the code exchange and checking
+// the ID token against the provider's published keys.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DISCOVERY_PATH = "/.well-known/openid-configuration"
+	// Random bytes in the state, nonce and PKCE verifier, 32 bytes makes a 43 character verifier
+	RANDOM_BYTES = 32
+	HTTP_TIMEOUT = 10 * time.Second
+	// Allowed difference between our clock and the provider's
+	CLOCK_SKEW = time.Minute
+	// An unknown kid only refetches the keys this often, so bad tokens can't hammer the provider
+	KEYS_REFRESH_INTERVAL = time.Minute
+	MAX_RESPONSE_BYTES    = 1 << 20
+)
+
+var (
+	ErrDiscovery = errors.New("provider discovery failed")
+	ErrExchange  = errors.New("code exchange failed")
+	ErrIdToken   = errors.New("invalid id token")
+	ErrNonce     = errors.New("nonce does not match")
+)
+
+var signingMethods = []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}
+
+// The client registered with the provider. Metadata and keys are fetched on first use and kept.
+type Provider struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string // Empty for a public client, PKCE still ties the code to this browser
+	RedirectURL  string
+	Scopes       []string
+	Client       *http.Client // nil uses a client with HTTP_TIMEOUT
+
+	mu            sync.Mutex
+	metadata      *Metadata
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// The parts of the discovery document that are used
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JwksURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Who the provider says logged in, from a verified ID token
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string    `json:"nonce"`
+	AuthorizedParty   string    `json:"azp"`
+	Email             string    `json:"email"`
+	EmailVerified     boolClaim `json:"email_verified"`
+	PreferredUsername string    `json:"preferred_username"`
+	Name              string    `json:"name"`
+}
+
+// Some providers send email_verified as the string "true"
+type boolClaim bool
+
+func (b *boolClaim) UnmarshalJSON(data []byte) error {
+	var v any
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case bool:
+		*b = boolClaim(v)
+	case string:
+		*b = boolClaim(v == "true")
+	}
+	return nil
+}
+
+// For the state, nonce and PKCE verifier
+func RandomToken() (string, error) {
+	b := make([]byte, RANDOM_BYTES)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("RandomToken: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// The S256 code_challenge sent in the authorization request for the verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (p *Provider) client() *http.Client {
+	if p.Client == nil {
+		return &http.Client{Timeout: HTTP_TIMEOUT}
+	}
+	return p.Client
+}
+
+// Fetches the discovery document the first time, a failed fetch is tried again on the next call
+func (p *Provider) Metadata(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	m := Metadata{}
+	err := p.getJson(ctx, strings.TrimSuffix(p.Issuer, "/")+DISCOVERY_PATH, &m)
+	if err != nil {
+		return nil, fmt.Errorf("Metadata: %w: %w", ErrDiscovery, err)
+	}
+	// The issuer has to match exactly or tokens from it would never verify
+	if m.Issuer != p.Issuer {
+		return nil, fmt.Errorf("Metadata: %w: issuer %q does not match %q", ErrDiscovery, m.Issuer, p.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JwksURI == "" {
+		return nil, fmt.Errorf("Metadata: %w: missing endpoints", ErrDiscovery)
+	}
+	// Providers that don't list their methods are assumed to take S256
+	if len(m.CodeChallengeMethods) > 0 && !slices.Contains(m.CodeChallengeMethods, "S256") {
+		return nil, fmt.Errorf("Metadata: %w: S256 code challenges not supported", ErrDiscovery)
+	}
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+// Where to send the browser to log in. state, nonce and verifier have to be kept until the callback.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("AuthCodeURL: %w", err)
+	}
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("AuthCodeURL: %w: %w", ErrDiscovery, err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientId)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+type tokenResponse struct {
+	IdToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Trades the code from the callback for tokens and returns the raw ID token
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Exchange: %w", err)
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientId)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("Exchange: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		// client_secret_basic, both parts are form encoded first
+		req.SetBasicAuth(url.QueryEscape(p.ClientId), url.QueryEscape(p.ClientSecret))
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Exchange: %w: %w", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+	t := tokenResponse{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, MAX_RESPONSE_BYTES)).Decode(&t)
+	if err != nil {
+		return "", fmt.Errorf("Exchange: %w: status %d: %w", ErrExchange, resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || t.Error != "" {
+		return "", fmt.Errorf("Exchange: %w: status %d: %s %s", ErrExchange, resp.StatusCode, t.Error, t.ErrorDescription)
+	}
+	if t.IdToken == "" {
+		return "", fmt.Errorf("Exchange: %w: no id token, is the openid scope requested?", ErrExchange)
+	}
+	return t.IdToken, nil
+}
+
+// Checks the signature, issuer, audience, times and nonce of the ID token
+func (p *Provider) VerifyIdToken(ctx context.Context, rawIdToken, nonce string, now time.Time) (*Claims, error) {
+	claims := idTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawIdToken, &claims, func(t *jwt.Token) (any, error) {
+		keyId, _ := t.Header["kid"].(string)
+		return p.key(ctx, keyId, t.Method.Alg(), now)
+	},
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(CLOCK_SKEW),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("VerifyIdToken: %w: %w", ErrIdToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("VerifyIdToken: %w: no subject", ErrIdToken)
+	}
+	// With other audiences the token has to say it was issued to us
+	if (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.ClientId {
+		return nil, fmt.Errorf("VerifyIdToken: %w: authorized party %q", ErrIdToken, claims.AuthorizedParty)
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("VerifyIdToken: %w", ErrNonce)
+	}
+	return &Claims{
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     bool(claims.EmailVerified),
+		PreferredUsername: claims.PreferredUsername,
+		Name:              claims.Name,
+	}, nil
+}
+
+// Looks the signing key up by kid, refetching the key set when it's not known so the
+// provider can rotate keys. A token without a kid works when only one key fits its algorithm.
+func (p *Provider) key(ctx context.Context, keyId, alg string, now time.Time) (crypto.PublicKey, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := findKey(p.keys, keyId, alg)
+	if ok {
+		return key, nil
+	}
+	if p.keys != nil && now.Sub(p.keysFetchedAt) < KEYS_REFRESH_INTERVAL {
+		return nil, fmt.Errorf("key: unknown kid %q", keyId)
+	}
+	set := JsonWebKeySet{}
+	err = p.getJson(ctx, m.JwksURI, &set)
+	if err != nil {
+		return nil, fmt.Errorf("key: jwks: %w", err)
+	}
+	p.keys = set.publicKeys()
+	p.keysFetchedAt = now
+	key, ok = findKey(p.keys, keyId, alg)
+	if !ok {
+		return nil, fmt.Errorf("key: unknown kid %q", keyId)
+	}
+	return key, nil
+}
+
+func findKey(keys map[string]crypto.PublicKey, keyId, alg string) (crypto.PublicKey, bool) {
+	if keyId != "" {
+		key, ok := keys[keyId]
+		return key, ok && keyFitsAlg(key, alg)
+	}
+	var found crypto.PublicKey
+	for _, key := range keys {
+		if !keyFitsAlg(key, alg) {
+			continue
+		}
+		if found != nil {
+			return nil, false
+		}
+		found = key
+	}
+	return found, found != nil
+}
+
+func (p *Provider) getJson(ctx context.Context, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("getJson: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("getJson: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getJson: %s: status %d", endpoint, resp.StatusCode)
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, MAX_RESPONSE_BYTES)).Decode(v)
+	if err != nil {
+		return fmt.Errorf("getJson: %s: %w", endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+	"wonk/app/oidc"
+	"wonk/app/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientId     = "wonk"
+	testClientSecret = "client secret"
+	testRedirectURL  = "https://wonk.example/login/oidc/callback"
+)
+
+func newTestProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+	mock, err := oidctest.NewProvider(t, testClientId, testClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mock, &oidc.Provider{
+		Issuer:       mock.URL(),
+		ClientId:     testClientId,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// Runs the browser's part of the flow and returns the code from the callback
+func authorize(t *testing.T, mock *oidctest.Provider, p *oidc.Provider, state, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := mock.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback.Query().Get("state") != state {
+		t.Fatalf("expected the state back, got %q", callback.Query().Get("state"))
+	}
+	code := callback.Query().Get("code")
+	if code == "" {
+		t.Fatalf("no code in the callback: %s", callback)
+	}
+	return code
+}
+
+func TestLogin(t *testing.T) {
+	mock, p := newTestProvider(t)
+	mock.User = oidctest.User{Subject: "abc123", Email: "ana@example.com", EmailVerified: true, PreferredUsername: "ana", Name: "Ana"}
+	ctx := context.Background()
+	verifier, err := oidc.RandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := authorize(t, mock, p, "state", "nonce", verifier)
+	idToken, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.VerifyIdToken(ctx, idToken, "nonce", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := oidc.Claims{Subject: "abc123", Email: "ana@example.com", EmailVerified: true, PreferredUsername: "ana", Name: "Ana"}
+	if *claims != want {
+		t.Errorf("expected %+v, got %+v", want, *claims)
+	}
+
+	// The code was used up
+	_, err = p.Exchange(ctx, code, verifier)
+	if !errors.Is(err, oidc.ErrExchange) {
+		t.Errorf("reused code: expected ErrExchange, got %v", err)
+	}
+	// Someone who only has the code can't use it without the verifier
+	code = authorize(t, mock, p, "state", "nonce", verifier)
+	_, err = p.Exchange(ctx, code, "another verifier")
+	if !errors.Is(err, oidc.ErrExchange) {
+		t.Errorf("wrong verifier: expected ErrExchange, got %v", err)
+	}
+	// Nor does another client
+	code = authorize(t, mock, p, "state", "nonce", verifier)
+	other := &oidc.Provider{Issuer: p.Issuer, ClientId: testClientId, ClientSecret: "wrong", RedirectURL: testRedirectURL}
+	_, err = other.Exchange(ctx, code, verifier)
+	if !errors.Is(err, oidc.ErrExchange) {
+		t.Errorf("wrong secret: expected ErrExchange, got %v", err)
+	}
+}
+
+func TestVerifyIdToken(t *testing.T) {
+	mock, p := newTestProvider(t)
+	ctx := context.Background()
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	otherKey, err := oidctest.NewProvider(t, testClientId, testClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(claims jwt.MapClaims)
+		sign   func(claims jwt.MapClaims) (string, error)
+		nonce  string
+		err    error
+	}{
+		{name: "valid"},
+		{name: "string email_verified", modify: func(c jwt.MapClaims) { c["email_verified"] = "true" }},
+		{
+			name:   "other audiences with azp",
+			modify: func(c jwt.MapClaims) { c["aud"] = []string{testClientId, "api"}; c["azp"] = testClientId },
+		},
+		{name: "wrong nonce", nonce: "other", err: oidc.ErrNonce},
+		{name: "no nonce", modify: func(c jwt.MapClaims) { delete(c, "nonce") }, err: oidc.ErrNonce},
+		{name: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, err: oidc.ErrIdToken},
+		{name: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = "someone-else" }, err: oidc.ErrIdToken},
+		{
+			name:   "other audiences without azp",
+			modify: func(c jwt.MapClaims) { c["aud"] = []string{testClientId, "api"} },
+			err:    oidc.ErrIdToken,
+		},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = now.Add(-2 * oidc.CLOCK_SKEW).Unix() }, err: oidc.ErrIdToken},
+		{name: "no expiry", modify: func(c jwt.MapClaims) { delete(c, "exp") }, err: oidc.ErrIdToken},
+		{name: "issued in the future", modify: func(c jwt.MapClaims) { c["iat"] = now.Add(time.Hour).Unix() }, err: oidc.ErrIdToken},
+		{name: "no subject", modify: func(c jwt.MapClaims) { c["sub"] = "" }, err: oidc.ErrIdToken},
+		{name: "unknown key", sign: otherKey.Sign, err: oidc.ErrIdToken},
+		{
+			name: "hmac with the client secret",
+			sign: func(c jwt.MapClaims) (string, error) {
+				return jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte(testClientSecret))
+			},
+			err: oidc.ErrIdToken,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := mock.IdTokenClaims(mock.User, "nonce", now)
+			if test.modify != nil {
+				test.modify(claims)
+			}
+			sign := mock.Sign
+			if test.sign != nil {
+				sign = test.sign
+			}
+			idToken, err := sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nonce := "nonce"
+			if test.nonce != "" {
+				nonce = test.nonce
+			}
+			_, err = p.VerifyIdToken(ctx, idToken, nonce, now)
+			if test.err == nil && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	mock, p := newTestProvider(t)
+	ctx := context.Background()
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	verify := func(now time.Time) error {
+		idToken, err := mock.Sign(mock.IdTokenClaims(mock.User, "nonce", now))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = p.VerifyIdToken(ctx, idToken, "nonce", now)
+		return err
+	}
+
+	err := verify(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mock.RotateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The keys were just fetched, an unknown kid doesn't fetch them again straight away
+	err = verify(now.Add(time.Second))
+	if !errors.Is(err, oidc.ErrIdToken) {
+		t.Errorf("expected the new key to be unknown for now, got %v", err)
+	}
+	err = verify(now.Add(oidc.KEYS_REFRESH_INTERVAL))
+	if err != nil {
+		t.Errorf("expected the new key to be fetched, got %v", err)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	mock, p := newTestProvider(t)
+	p.Issuer = mock.URL() + "/"
+	_, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if !errors.Is(err, oidc.ErrDiscovery) {
+		t.Errorf("expected ErrDiscovery, got %v", err)
+	}
+}
+
+func TestJsonWebKeyEC(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := oidc.NewJsonWebKey("ec", &key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := jwk.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.PublicKey.Equal(parsed) {
+		t.Error("expected the parsed key to equal the original")
+	}
+	jwk.Crv = "P-384"
+	_, err = jwk.PublicKey()
+	if err == nil {
+		t.Error("expected other curves to be rejected")
+	}
+}
@@ -0,0 +1,290 @@
+// Package oidctest is an in-process OpenID Connect provider for tests. Its authorize endpoint
+// logs in as User straight away, like a browser that already has a session with the provider,
+// and its token endpoint checks the client, redirect uri and PKCE verifier like a real one.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"wonk/app/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	CODE_DURATION     = time.Minute
+	ID_TOKEN_DURATION = 5 * time.Minute
+)
+
+// The account at the provider
+type User struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+type Provider struct {
+	Server       *httptest.Server
+	ClientId     string
+	ClientSecret string // Empty accepts the client as public
+	User         User
+	// Changes the ID token claims before signing, to test what a client rejects
+	Modify func(claims jwt.MapClaims)
+
+	mu    sync.Mutex
+	keyId string
+	key   *rsa.PrivateKey
+	codes map[string]authRequest
+}
+
+type authRequest struct {
+	redirectURL   string
+	nonce         string
+	codeChallenge string
+	user          User
+	expiresAt     time.Time
+}
+
+// Starts the provider, it's closed when the test ends
+func NewProvider(t testing.TB, clientId, clientSecret string) (*Provider, error) {
+	p := &Provider{
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		User:         User{Subject: "test-subject", Email: "test@example.com", EmailVerified: true, PreferredUsername: "test"},
+		codes:        map[string]authRequest{},
+	}
+	err := p.RotateKey()
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidc.DISCOVERY_PATH, p.handleDiscovery)
+	mux.HandleFunc("/jwks", p.handleJwks)
+	mux.HandleFunc("/authorize", p.handleAuthorize)
+	mux.HandleFunc("/token", p.handleToken)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Server.Close)
+	return p, nil
+}
+
+// The issuer, also the base url of the endpoints
+func (p *Provider) URL() string {
+	return p.Server.URL
+}
+
+// Opens the authorization url like a browser would and returns the callback url the provider
+// sent it back to, with either a code or an error
+func (p *Provider) Authorize(authURL string) (*url.URL, error) {
+	client := http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return nil, fmt.Errorf("Authorize: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("Authorize: status %d", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return nil, fmt.Errorf("Authorize: %w", err)
+	}
+	return callback, nil
+}
+
+// Signs new tokens with a new key and stops publishing the old one
+func (p *Provider) RotateKey() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("RotateKey: %w", err)
+	}
+	b := make([]byte, 8)
+	_, err = rand.Read(b)
+	if err != nil {
+		return fmt.Errorf("RotateKey: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = key
+	p.keyId = base64.RawURLEncoding.EncodeToString(b)
+	return nil
+}
+
+// Signs claims with the provider's current key
+func (p *Provider) Sign(claims jwt.MapClaims) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.keyId
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		return "", fmt.Errorf("Sign: %w", err)
+	}
+	return signed, nil
+}
+
+// The claims of an ID token for user, as the token endpoint issues it
+func (p *Provider) IdTokenClaims(user User, nonce string, now time.Time) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"iss":            p.URL(),
+		"sub":            user.Subject,
+		"aud":            p.ClientId,
+		"iat":            now.Unix(),
+		"exp":            now.Add(ID_TOKEN_DURATION).Unix(),
+		"nonce":          nonce,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+	}
+	if user.PreferredUsername != "" {
+		claims["preferred_username"] = user.PreferredUsername
+	}
+	if user.Name != "" {
+		claims["name"] = user.Name
+	}
+	return claims
+}
+
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]any{
+		"issuer":                                p.URL(),
+		"authorization_endpoint":                p.URL() + "/authorize",
+		"token_endpoint":                        p.URL() + "/token",
+		"jwks_uri":                              p.URL() + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) handleJwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	key, err := oidc.NewJsonWebKey(p.keyId, &p.key.PublicKey)
+	p.mu.Unlock()
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJson(w, http.StatusOK, oidc.JsonWebKeySet{Keys: []oidc.JsonWebKey{key}})
+}
+
+// Errors in the request itself are shown to the user, the rest go back to the client
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURL := q.Get("redirect_uri")
+	if q.Get("client_id") != p.ClientId || redirectURL == "" {
+		http.Error(w, "unknown client or redirect uri", http.StatusBadRequest)
+		return
+	}
+	redirect := func(params url.Values) {
+		params.Set("state", q.Get("state"))
+		http.Redirect(w, r, redirectURL+"?"+params.Encode(), http.StatusFound)
+	}
+	if q.Get("response_type") != "code" || !strings.Contains(" "+q.Get("scope")+" ", " openid ") {
+		redirect(url.Values{"error": {"invalid_request"}})
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		redirect(url.Values{"error": {"invalid_request"}, "error_description": {"pkce required"}})
+		return
+	}
+	code, err := oidc.RandomToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.mu.Lock()
+	p.codes[code] = authRequest{
+		redirectURL:   redirectURL,
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		user:          p.User,
+		expiresAt:     time.Now().Add(CODE_DURATION),
+	}
+	p.mu.Unlock()
+	redirect(url.Values{"code": {code}})
+}
+
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "invalid_request"})
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientId, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientId, _ = url.QueryUnescape(clientId)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientId = r.PostForm.Get("client_id")
+	}
+	if clientId != p.ClientId || clientSecret != p.ClientSecret {
+		writeJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	// Codes work once, a second try fails like an expired one
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	req, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	now := time.Now()
+	if !ok || now.After(req.expiresAt) || r.PostForm.Get("redirect_uri") != req.redirectURL {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	if oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != req.codeChallenge {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "pkce verifier does not match"})
+		return
+	}
+	claims := p.IdTokenClaims(req.user, req.nonce, now)
+	if p.Modify != nil {
+		p.Modify(claims)
+	}
+	idToken, err := p.Sign(claims)
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	accessToken, err := oidc.RandomToken()
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(ID_TOKEN_DURATION.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
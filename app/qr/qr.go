@@ -0,0 +1,458 @@
+// Package qr encodes text as a QR code (ISO/IEC 18004) and renders it as SVG so pages can show
+// otpauth urls without a js library. Only byte mode at error correction level M is supported,
+// in versions 1 to 10, which holds up to 213 bytes.
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	MAX_VERSION = 10
+	// Light modules around the code that scanners need to find it
+	QUIET_ZONE = 4
+)
+
+// Error correction blocks of a version at level M, the second group is one data codeword longer
+type versionInfo struct {
+	ecPerBlock int
+	group1     int // Blocks in the first group
+	data1      int // Data codewords per block in the first group
+	group2     int
+	alignments []int // Row & column centers of the alignment patterns
+}
+
+var versions = [MAX_VERSION + 1]versionInfo{
+	1:  {ecPerBlock: 10, group1: 1, data1: 16},
+	2:  {ecPerBlock: 16, group1: 1, data1: 28, alignments: []int{6, 18}},
+	3:  {ecPerBlock: 26, group1: 1, data1: 44, alignments: []int{6, 22}},
+	4:  {ecPerBlock: 18, group1: 2, data1: 32, alignments: []int{6, 26}},
+	5:  {ecPerBlock: 24, group1: 2, data1: 43, alignments: []int{6, 30}},
+	6:  {ecPerBlock: 16, group1: 4, data1: 27, alignments: []int{6, 34}},
+	7:  {ecPerBlock: 18, group1: 4, data1: 31, alignments: []int{6, 22, 38}},
+	8:  {ecPerBlock: 22, group1: 2, data1: 38, group2: 2, alignments: []int{6, 24, 42}},
+	9:  {ecPerBlock: 22, group1: 3, data1: 36, group2: 2, alignments: []int{6, 26, 46}},
+	10: {ecPerBlock: 26, group1: 4, data1: 43, group2: 1, alignments: []int{6, 28, 50}},
+}
+
+func (v versionInfo) dataCodewords() int {
+	return v.group1*v.data1 + v.group2*(v.data1+1)
+}
+
+// Bits used by the byte mode character count
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+type Code struct {
+	Size    int // Modules per side, without the quiet zone
+	Version int
+	Mask    int
+	modules [][]bool // true is dark, indexed [y][x]
+	isFunc  [][]bool // Finder, timing, alignment & format modules that masks skip
+}
+
+// Encodes text in the smallest version that fits, with the mask the spec's penalty rules pick
+func Encode(text string) (*Code, error) {
+	version := 0
+	for v := 1; v <= MAX_VERSION; v++ {
+		if 4+countBits(v)+8*len(text) <= versions[v].dataCodewords()*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("Encode: %d bytes is too long", len(text))
+	}
+	best := -1
+	var bestCode *Code
+	for mask := 0; mask < 8; mask++ {
+		c, err := EncodeWith(text, version, mask)
+		if err != nil {
+			return nil, fmt.Errorf("Encode: %w", err)
+		}
+		if p := c.penalty(); best < 0 || p < best {
+			best = p
+			bestCode = c
+		}
+	}
+	return bestCode, nil
+}
+
+// Encodes text in the given version with the given mask
+func EncodeWith(text string, version, mask int) (*Code, error) {
+	if version < 1 || version > MAX_VERSION {
+		return nil, fmt.Errorf("EncodeWith: version %d isn't supported", version)
+	}
+	if mask < 0 || mask > 7 {
+		return nil, fmt.Errorf("EncodeWith: mask %d isn't valid", mask)
+	}
+	data, err := dataCodewords(text, version)
+	if err != nil {
+		return nil, fmt.Errorf("EncodeWith: %w", err)
+	}
+	size := 17 + 4*version
+	c := Code{Size: size, Version: version, Mask: mask}
+	c.modules = make([][]bool, size)
+	c.isFunc = make([][]bool, size)
+	for i := range size {
+		c.modules[i] = make([]bool, size)
+		c.isFunc[i] = make([]bool, size)
+	}
+	c.drawFunctionPatterns()
+	c.drawCodewords(interleave(data, versions[version]))
+	c.applyMask()
+	c.drawFormatBits()
+	return &c, nil
+}
+
+// true when the module is dark. x is the column and y the row, from the top left.
+func (c *Code) Black(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// Draws each dark module as a 1x1 square in a viewBox that includes the quiet zone
+func (c *Code) SVG(pixels int) string {
+	full := c.Size + 2*QUIET_ZONE
+	var b strings.Builder
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 `)
+	b.WriteString(strconv.Itoa(full) + " " + strconv.Itoa(full))
+	b.WriteString(`" width="` + strconv.Itoa(pixels) + `" height="` + strconv.Itoa(pixels))
+	b.WriteString(`" shape-rendering="crispEdges" role="img">`)
+	b.WriteString(`<rect width="100%" height="100%" fill="#ffffff"/><path fill="#000000" d="`)
+	// One rectangle per run of dark modules in a row keeps the path short
+	for y := range c.Size {
+		for x := 0; x < c.Size; {
+			if !c.modules[y][x] {
+				x++
+				continue
+			}
+			run := 0
+			for x+run < c.Size && c.modules[y][x+run] {
+				run++
+			}
+			fmt.Fprintf(&b, "M%d,%dh%dv1h-%dz", x+QUIET_ZONE, y+QUIET_ZONE, run, run)
+			x += run
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
+
+// Mode, length, the bytes, a terminator and then padding up to the version's capacity
+func dataCodewords(text string, version int) ([]byte, error) {
+	capacity := versions[version].dataCodewords() * 8
+	if 4+countBits(version)+8*len(text) > capacity {
+		return nil, errors.New("dataCodewords: text doesn't fit the version")
+	}
+	bits := bitBuffer{}
+	bits.append(0b0100, 4)
+	bits.append(len(text), countBits(version))
+	for i := 0; i < len(text); i++ {
+		bits.append(int(text[i]), 8)
+	}
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	data := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			data[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return data, nil
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(val, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (val>>i)&1 == 1)
+	}
+}
+
+// Splits the data into blocks, adds each block's error correction and interleaves them
+func interleave(data []byte, v versionInfo) []byte {
+	blocks := [][]byte{}
+	i := 0
+	for b := range v.group1 + v.group2 {
+		n := v.data1
+		if b >= v.group1 {
+			n++
+		}
+		blocks = append(blocks, data[i:i+n])
+		i += n
+	}
+	divisor := rsGenerator(v.ecPerBlock)
+	ecBlocks := make([][]byte, len(blocks))
+	for b, block := range blocks {
+		ecBlocks[b] = rsRemainder(block, divisor)
+	}
+	result := []byte{}
+	for i := range v.data1 + 1 {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := range v.ecPerBlock {
+		for _, ec := range ecBlocks {
+			result = append(result, ec[i])
+		}
+	}
+	return result
+}
+
+func (c *Code) setFunc(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunc[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := range c.Size {
+		c.setFunc(6, i, i%2 == 0)
+		c.setFunc(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	align := versions[c.Version].alignments
+	last := len(align) - 1
+	for i := range align {
+		for j := range align {
+			// The corners with finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			c.drawAlignment(align[i], align[j])
+		}
+	}
+
+	// Reserved now so data isn't placed there, the real bits are drawn after masking
+	c.drawFormatBits()
+	c.drawVersionBits()
+}
+
+// 7x7 finder with its light separator, clipped at the edges
+func (c *Code) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || x >= c.Size || y < 0 || y >= c.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.setFunc(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunc(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// Level M is 00 so only the mask goes in the top 5 bits
+func formatBits(mask int) int {
+	data := mask
+	rem := data
+	for range 10 {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+func (c *Code) drawFormatBits() {
+	bits := formatBits(c.Mask)
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+	// Around the top left finder
+	for i := 0; i <= 5; i++ {
+		c.setFunc(8, i, bit(i))
+	}
+	c.setFunc(8, 7, bit(6))
+	c.setFunc(8, 8, bit(7))
+	c.setFunc(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunc(14-i, 8, bit(i))
+	}
+	// Split between the other two finders
+	for i := 0; i < 8; i++ {
+		c.setFunc(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunc(8, c.Size-15+i, bit(i))
+	}
+	c.setFunc(8, c.Size-8, true)
+}
+
+func versionBits(version int) int {
+	rem := version
+	for range 12 {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return version<<12 | rem
+}
+
+// Versions 7 and up repeat their number next to the top right and bottom left finders
+func (c *Code) drawVersionBits() {
+	if c.Version < 7 {
+		return
+	}
+	bits := versionBits(c.Version)
+	for i := range 18 {
+		dark := (bits>>i)&1 == 1
+		a := c.Size - 11 + i%3
+		b := i / 3
+		c.setFunc(a, b, dark)
+		c.setFunc(b, a, dark)
+	}
+}
+
+// Fills two module wide columns from the bottom right in a zigzag, skipping the vertical timing pattern
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := range c.Size {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := range 2 {
+				x := right - j
+				if c.isFunc[y][x] || i >= len(data)*8 {
+					continue
+				}
+				c.modules[y][x] = (data[i/8]>>(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func maskInverts(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+func (c *Code) applyMask() {
+	for y := range c.Size {
+		for x := range c.Size {
+			if !c.isFunc[y][x] && maskInverts(c.Mask, x, y) {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// Scores how hard the code is to scan, lower is better
+func (c *Code) penalty() int {
+	score := 0
+	line := func(get func(i int) bool) {
+		run := 1
+		for i := 1; i < c.Size; i++ {
+			if get(i) == get(i-1) {
+				run++
+				continue
+			}
+			if run >= 5 {
+				score += run - 2
+			}
+			run = 1
+		}
+		if run >= 5 {
+			score += run - 2
+		}
+		// Looks like a finder pattern: dark light dark dark dark light dark with 4 light on a side
+		finder := []bool{true, false, true, true, true, false, true}
+		for i := 0; i+7 <= c.Size; i++ {
+			match := true
+			for j, dark := range finder {
+				if get(i+j) != dark {
+					match = false
+					break
+				}
+			}
+			if !match {
+				continue
+			}
+			lightBefore, lightAfter := true, true
+			for j := 1; j <= 4; j++ {
+				if i-j >= 0 && get(i-j) {
+					lightBefore = false
+				}
+				if i+6+j < c.Size && get(i+6+j) {
+					lightAfter = false
+				}
+			}
+			if lightBefore {
+				score += 40
+			}
+			if lightAfter {
+				score += 40
+			}
+		}
+	}
+	for y := range c.Size {
+		line(func(x int) bool { return c.modules[y][x] })
+	}
+	for x := range c.Size {
+		line(func(y int) bool { return c.modules[y][x] })
+	}
+	dark := 0
+	for y := range c.Size {
+		for x := range c.Size {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size {
+				m := c.modules[y][x]
+				if m == c.modules[y][x+1] && m == c.modules[y+1][x] && m == c.modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+	total := c.Size * c.Size
+	score += abs(dark*100/total-50) / 5 * 10
+	return score
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,122 @@
+package qr
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run `go test ./app/qr -update` to rewrite the golden files after an intended change
+var update = flag.Bool("update", false, "update golden files")
+
+// "HELLO WORLD" at 1-M from the thonky.com QR code tutorial
+func TestRsRemainder(t *testing.T) {
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	got := rsRemainder(data, rsGenerator(10))
+	if !bytes.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+// Level M rows of the format information table in the spec
+func TestFormatBits(t *testing.T) {
+	want := []int{
+		0b101010000010010,
+		0b101000100100101,
+		0b101111001111100,
+		0b101101101001011,
+		0b100010111111001,
+		0b100000011001110,
+		0b100111110010111,
+		0b100101010100000,
+	}
+	for mask, bits := range want {
+		if got := formatBits(mask); got != bits {
+			t.Errorf("mask %d: expected %015b, got %015b", mask, bits, got)
+		}
+	}
+}
+
+func TestVersionBits(t *testing.T) {
+	want := map[int]int{
+		7:  0b000111110010010100,
+		8:  0b001000010110111100,
+		10: 0b001010010011010011,
+	}
+	for version, bits := range want {
+		if got := versionBits(version); got != bits {
+			t.Errorf("version %d: expected %018b, got %018b", version, bits, got)
+		}
+	}
+}
+
+func TestEncodeVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		length      int
+		version     int
+		expectedErr bool
+	}{
+		{name: "empty", length: 0, version: 1},
+		{name: "fills version 1", length: 14, version: 1},
+		{name: "just over version 1", length: 15, version: 2},
+		{name: "otpauth url", length: 100, version: 6},
+		{name: "fills version 9", length: 180, version: 9},
+		{name: "16 bit length in version 10", length: 181, version: 10},
+		{name: "fills version 10", length: 213, version: 10},
+		{name: "too long", length: 214, expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Encode(strings.Repeat("a", tt.length))
+			if tt.expectedErr {
+				if err == nil {
+					t.Errorf("expected an error but didnt get one")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("didn't expected an error but did get one, err: %v", err)
+			}
+			if c.Version != tt.version || c.Size != 17+4*tt.version {
+				t.Errorf("expected version %d, got %d with size %d", tt.version, c.Version, c.Size)
+			}
+		})
+	}
+}
+
+func TestEncodeSVG(t *testing.T) {
+	c, err := Encode("otpauth://totp/Wonk:someone?secret=JBSWY3DPEHPK3PXP&issuer=Wonk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Finder pattern corners are always dark and the module inside the separator light
+	for _, p := range [][2]int{{0, 0}, {c.Size - 1, 0}, {0, c.Size - 1}} {
+		if !c.Black(p[0], p[1]) {
+			t.Errorf("expected the finder corner at %v to be dark", p)
+		}
+	}
+	if c.Black(7, 7) {
+		t.Error("expected the separator to be light")
+	}
+
+	got := c.SVG(200)
+	path := filepath.Join("testdata", "otpauth.golden.svg")
+	if *update {
+		err := os.WriteFile(path, []byte(got), 0644)
+		if err != nil {
+			t.Fatalf("writing golden file: %v", err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	if string(want) != got {
+		t.Errorf("svg doesn't match %s\ngot:\n%s", path, got)
+	}
+}
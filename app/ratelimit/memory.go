@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Keeps states in a map. Once it holds size keys, keys that haven't been touched for ttl and
+// aren't blocked are dropped, they'd be back to a full bucket anyway. When that isn't enough,
+// e.g. under a spray of distinct ips, the oldest keys are evicted, blocked keys last.
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]State
+	size   int
+	ttl    time.Duration
+}
+
+func NewMemoryStore(size int, ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		states: make(map[string]State),
+		size:   size,
+		ttl:    ttl,
+	}
+}
+
+func (m *MemoryStore) Load(key string) (State, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.states[key]
+	return s, ok, nil
+}
+
+func (m *MemoryStore) Save(key string, s State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.states[key]; !ok && len(m.states) >= m.size {
+		m.prune(s.UpdatedAt)
+		m.evict(s.UpdatedAt)
+	}
+	m.states[key] = s
+	return nil
+}
+
+func (m *MemoryStore) prune(now time.Time) {
+	for key, s := range m.states {
+		if now.Sub(s.UpdatedAt) > m.ttl && !now.Before(s.BlockedUntil) {
+			delete(m.states, key)
+		}
+	}
+}
+
+// Drops the oldest keys until a tenth of the store is free, so a full store isn't sorted on
+// every save. Keys that aren't blocked go first.
+func (m *MemoryStore) evict(now time.Time) {
+	if len(m.states) < m.size {
+		return
+	}
+	keys := make([]string, 0, len(m.states))
+	for key := range m.states {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := m.states[keys[i]], m.states[keys[j]]
+		aBlocked, bBlocked := now.Before(a.BlockedUntil), now.Before(b.BlockedUntil)
+		if aBlocked != bBlocked {
+			return bBlocked
+		}
+		return a.UpdatedAt.Before(b.UpdatedAt)
+	})
+	keep := max(0, m.size-max(1, m.size/10))
+	for _, key := range keys[:len(keys)-keep] {
+		delete(m.states, key)
+	}
+}
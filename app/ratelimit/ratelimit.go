@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// What the limiter remembers about one key, e.g. an ip or a username
+type State struct {
+	Tokens        float64
+	UpdatedAt     time.Time
+	Failures      int // In a row, reset by a success, a lockout or a gap of Lockout between failures
+	LastFailureAt time.Time
+	BlockedUntil  time.Time
+}
+
+// Where states are kept. MemoryStore is enough for one server, a store shared by several
+// servers has to be safe to use from all of them.
+type Store interface {
+	Load(key string) (State, bool, error)
+	Save(key string, s State) error
+}
+
+type Policy struct {
+	Interval    time.Duration // One token is added every Interval
+	Burst       int           // Most tokens a key can hold
+	MaxFailures int           // Failures in a row before a lockout, 0 turns lockouts off
+	BaseDelay   time.Duration // Block after the first failure, doubled for each one after
+	Lockout     time.Duration
+}
+
+// A token bucket per key that also slows down and then locks out keys that keep failing
+type Limiter struct {
+	Policy Policy
+	store  Store
+	mu     sync.Mutex
+}
+
+func NewLimiter(p Policy, s Store) *Limiter {
+	return &Limiter{Policy: p, store: s}
+}
+
+// Takes a token for the key. Returns how long to wait when the key is out of tokens or blocked.
+func (l *Limiter) Allow(key string, now time.Time) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, err := l.load(key, now)
+	if err != nil {
+		return 0, fmt.Errorf("Allow: %w", err)
+	}
+	if now.Before(s.BlockedUntil) {
+		return s.BlockedUntil.Sub(now), nil
+	}
+	if s.Tokens < 1 {
+		return time.Duration((1 - s.Tokens) * float64(l.Policy.Interval)), nil
+	}
+	s.Tokens--
+	err = l.store.Save(key, s)
+	if err != nil {
+		return 0, fmt.Errorf("Allow: store: %w", err)
+	}
+	return 0, nil
+}
+
+// Blocks the key for BaseDelay, 2*BaseDelay, 4*BaseDelay... and for Lockout once it
+// reaches MaxFailures. Returns true when this failure locked the key out.
+func (l *Limiter) Failure(key string, now time.Time) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, err := l.load(key, now)
+	if err != nil {
+		return false, fmt.Errorf("Failure: %w", err)
+	}
+	if now.Sub(s.LastFailureAt) > l.Policy.Lockout {
+		s.Failures = 0
+	}
+	s.Failures++
+	s.LastFailureAt = now
+	locked := l.Policy.MaxFailures > 0 && s.Failures >= l.Policy.MaxFailures
+	if locked {
+		s.BlockedUntil = now.Add(l.Policy.Lockout)
+		s.Failures = 0
+	} else if l.Policy.BaseDelay > 0 {
+		delay := min(l.Policy.BaseDelay<<(s.Failures-1), l.Policy.Lockout)
+		s.BlockedUntil = now.Add(delay)
+	}
+	err = l.store.Save(key, s)
+	if err != nil {
+		return false, fmt.Errorf("Failure: store: %w", err)
+	}
+	return locked, nil
+}
+
+// Forgets the key's failures, its tokens are left alone
+func (l *Limiter) Success(key string, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, err := l.load(key, now)
+	if err != nil {
+		return fmt.Errorf("Success: %w", err)
+	}
+	s.Failures = 0
+	s.BlockedUntil = time.Time{}
+	err = l.store.Save(key, s)
+	if err != nil {
+		return fmt.Errorf("Success: store: %w", err)
+	}
+	return nil
+}
+
+// Returns the key's state with the tokens earned since it was last saved
+func (l *Limiter) load(key string, now time.Time) (State, error) {
+	s, ok, err := l.store.Load(key)
+	if err != nil {
+		return State{}, fmt.Errorf("load: store: %w", err)
+	}
+	if !ok {
+		return State{Tokens: float64(l.Policy.Burst), UpdatedAt: now}, nil
+	}
+	if elapsed := now.Sub(s.UpdatedAt); elapsed > 0 {
+		s.Tokens = min(float64(l.Policy.Burst), s.Tokens+float64(elapsed)/float64(l.Policy.Interval))
+		s.UpdatedAt = now
+	}
+	return s, nil
+}
@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAllowTokenBucket(t *testing.T) {
+	l := NewLimiter(Policy{Interval: 10 * time.Second, Burst: 3}, NewMemoryStore(10, time.Hour))
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		wait, err := l.Allow("k", now)
+		if err != nil || wait != 0 {
+			t.Fatalf("attempt %d: expected to be allowed, got wait %v err %v", i, wait, err)
+		}
+	}
+	wait, err := l.Allow("k", now)
+	if err != nil || wait != 10*time.Second {
+		t.Errorf("expected to wait 10s once the burst is used, got %v %v", wait, err)
+	}
+	wait, _ = l.Allow("other", now)
+	if wait != 0 {
+		t.Errorf("expected keys to have their own buckets, got wait %v", wait)
+	}
+	wait, _ = l.Allow("k", now.Add(4*time.Second))
+	if wait != 6*time.Second {
+		t.Errorf("expected to wait the rest of the interval, got %v", wait)
+	}
+	wait, _ = l.Allow("k", now.Add(10*time.Second))
+	if wait != 0 {
+		t.Errorf("expected a token after an interval, got wait %v", wait)
+	}
+	// Refills stop at the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		l.Allow("k", now)
+	}
+	wait, _ = l.Allow("k", now)
+	if wait == 0 {
+		t.Error("expected the bucket to hold no more than the burst")
+	}
+}
+
+func TestFailureLockout(t *testing.T) {
+	p := Policy{Interval: time.Second, Burst: 100, MaxFailures: 4, BaseDelay: time.Second, Lockout: 15 * time.Minute}
+	l := NewLimiter(p, NewMemoryStore(10, time.Hour))
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	// Each failure doubles the wait until the lockout
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		locked, err := l.Failure("k", now)
+		if err != nil || locked {
+			t.Fatalf("failure %d: expected no lockout, got %v %v", i+1, locked, err)
+		}
+		wait, _ := l.Allow("k", now)
+		if wait != expected {
+			t.Errorf("failure %d: expected to wait %v, got %v", i+1, expected, wait)
+		}
+		now = now.Add(expected)
+	}
+	locked, err := l.Failure("k", now)
+	if err != nil || !locked {
+		t.Fatalf("expected a lockout on the fourth failure, got %v %v", locked, err)
+	}
+	wait, _ := l.Allow("k", now.Add(14*time.Minute))
+	if wait != time.Minute {
+		t.Errorf("expected to stay locked for the lockout, got wait %v", wait)
+	}
+
+	// A lockout starts the count over
+	now = now.Add(p.Lockout)
+	l.Failure("k", now)
+	wait, _ = l.Allow("k", now)
+	if wait != time.Second {
+		t.Errorf("expected the count to restart after a lockout, got wait %v", wait)
+	}
+
+	// So does a success
+	l.Failure("k", now.Add(time.Second))
+	l.Success("k", now.Add(2*time.Second))
+	wait, _ = l.Allow("k", now.Add(2*time.Second))
+	if wait != 0 {
+		t.Errorf("expected a success to lift the block, got wait %v", wait)
+	}
+	l.Failure("k", now.Add(3*time.Second))
+	wait, _ = l.Allow("k", now.Add(3*time.Second))
+	if wait != time.Second {
+		t.Errorf("expected the count to restart after a success, got wait %v", wait)
+	}
+
+	// And a quiet stretch as long as the lockout
+	l.Failure("k", now.Add(5*time.Second))
+	l.Failure("k", now.Add(5*time.Second+p.Lockout+time.Second))
+	wait, _ = l.Allow("k", now.Add(5*time.Second+p.Lockout+time.Second))
+	if wait != time.Second {
+		t.Errorf("expected old failures to be forgotten, got wait %v", wait)
+	}
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	m := NewMemoryStore(2, time.Minute)
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	m.Save("old", State{UpdatedAt: now})
+	m.Save("blocked", State{UpdatedAt: now, BlockedUntil: now.Add(time.Hour)})
+	m.Save("new", State{UpdatedAt: now.Add(2 * time.Minute)})
+
+	if _, ok, _ := m.Load("old"); ok {
+		t.Error("expected the stale key to be pruned")
+	}
+	if _, ok, _ := m.Load("blocked"); !ok {
+		t.Error("expected the blocked key to be kept")
+	}
+	if _, ok, _ := m.Load("new"); !ok {
+		t.Error("expected the new key to be saved")
+	}
+}
+
+func TestMemoryStoreEvict(t *testing.T) {
+	m := NewMemoryStore(10, time.Hour)
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	m.Save("blocked", State{UpdatedAt: now, BlockedUntil: now.Add(time.Hour)})
+	// Nothing is older than ttl, so pruning alone can't make room
+	for i := 0; i < 100; i++ {
+		m.Save(fmt.Sprintf("ip-%d", i), State{UpdatedAt: now.Add(time.Duration(i) * time.Second)})
+		if len(m.states) > 10 {
+			t.Fatalf("save %d: expected at most 10 keys, got %d", i, len(m.states))
+		}
+	}
+
+	if _, ok, _ := m.Load("blocked"); !ok {
+		t.Error("expected the blocked key to be kept over older keys")
+	}
+	if _, ok, _ := m.Load("ip-0"); ok {
+		t.Error("expected the oldest key to be evicted")
+	}
+	if _, ok, _ := m.Load("ip-99"); !ok {
+		t.Error("expected the newest key to be saved")
+	}
+}
@@ -0,0 +1,50 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	HEADER_NAME = "X-Request-Id"
+)
+
+var requestIdCtxKey = &contextKey{"requestId"}
+
+type contextKey struct {
+	name string
+}
+
+// Middleware gives every request a random id, the id is stored in the request
+// context and echoed back in the response headers so logs and audit entries can
+// be matched to a request.
+func Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newId()
+		w.Header().Set(HEADER_NAME, id)
+		ctx := WithRequestId(r.Context(), id)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdCtxKey, id)
+}
+
+// FromCtx returns the request id or an empty string when there is none
+func FromCtx(ctx context.Context) string {
+	id, ok := ctx.Value(requestIdCtxKey).(string)
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+func newId() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never returns an error
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
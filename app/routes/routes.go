@@ -1,13 +1,10 @@
 package routes
 
 import (
-	"context"
 	"io"
 	"log/slog"
 	"net/http"
-	"time"
 	"wonk/app/service"
-	"wonk/app/templates/views"
 	"wonk/storage"
 )
 
@@ -18,23 +15,93 @@ func AddRoutes(
 	a *application.Service,
 ) {
 	fs := http.FileServer(http.Dir("static"))
+	// Pages behind a login, unsafe methods also need the session's csrf token
+	protected := func(h http.Handler) http.Handler {
+		return a.Auth.AuthMiddleware(a.Auth.CSRFMiddleware(h))
+	}
 	mux.Handle("/", http.NotFoundHandler())
 	mux.Handle("/health", handleHealth(l))
 	mux.Handle("/login", a.Auth.HandleLogin())
+	mux.Handle("/login/2fa", a.Auth.HandleLoginTotp())
+	mux.Handle("/login/passkey/options", a.Auth.HandlePasskeyLoginOptions())
+	mux.Handle("/login/passkey", a.Auth.HandlePasskeyLogin())
+	mux.Handle("/login/reset", a.Auth.HandlePasswordReset())
+	mux.Handle("/login/reset/confirm", a.Auth.HandlePasswordResetConfirm())
+	mux.Handle("/login/oidc", a.Auth.HandleOidcLogin())
+	mux.Handle("/login/oidc/callback", a.Auth.HandleOidcCallback())
 	mux.Handle("/signup", a.Auth.HandleSignUp())
+	mux.Handle("/logout", protected(a.Auth.HandleLogout()))
+	mux.Handle("/logout/all", protected(a.Auth.HandleLogoutAll()))
 	mux.Handle("/static/", http.StripPrefix("/static/", fs))
-	mux.Handle("/home", a.Auth.AuthMiddleware(handleHome(l)))
-	mux.Handle("/finance", a.Auth.AuthMiddleware(a.Finance.Home.Home()))
-	mux.Handle("/finance/transaction", a.Auth.AuthMiddleware(a.Finance.Transaction.Transaction()))
-	mux.Handle("/finance/bucket/form", a.Auth.AuthMiddleware(a.Finance.Bucket.BucketForm()))
-	mux.Handle("/finance/transactions/month", a.Auth.AuthMiddleware(a.Finance.Transaction.TransactionMonth()))
-	mux.Handle("/finance/transactions/month/form", a.Auth.AuthMiddleware(a.Finance.Transaction.TransactionMonthForm()))
-	mux.Handle("/finance/buckets", a.Auth.AuthMiddleware(a.Finance.Bucket.Buckets()))
-	mux.Handle("/finance/buckets/{id}/edit", a.Auth.AuthMiddleware(a.Finance.Bucket.BucketEdit()))
-	mux.Handle("/finance/buckets/{id}", a.Auth.AuthMiddleware(a.Finance.Bucket.BucketById()))
-	mux.Handle("/finance/transactions", a.Auth.AuthMiddleware(a.Finance.Transaction.Transactions()))
-	mux.Handle("/finance/transactions/{id}/edit", a.Auth.AuthMiddleware(a.Finance.Transaction.TransactionsEdit()))
-	mux.Handle("/finance/transactions/{id}", a.Auth.AuthMiddleware(a.Finance.Transaction.TransactionsById()))
+	mux.Handle("/calendar/{file}", a.Finance.Calendar.CalendarFeed())
+	mux.Handle("/home", protected(a.Dashboard.Home()))
+	mux.Handle("/home/panels/{panel}", protected(a.Dashboard.Panel()))
+	mux.Handle("/finance", protected(a.Finance.Home.Home()))
+	mux.Handle("/finance/transaction", protected(a.Finance.Transaction.Transaction()))
+	mux.Handle("/finance/bucket/form", protected(a.Finance.Bucket.BucketForm()))
+	mux.Handle("/finance/transactions/month", protected(a.Finance.Transaction.TransactionMonth()))
+	mux.Handle("/finance/transactions/month/form", protected(a.Finance.Transaction.TransactionMonthForm()))
+	mux.Handle("/finance/transactions/range", protected(a.Finance.Transaction.TransactionRange()))
+	mux.Handle("/finance/forecast", protected(a.Finance.Transaction.Forecast()))
+	mux.Handle("/finance/buckets", protected(a.Finance.Bucket.Buckets()))
+	mux.Handle("/finance/buckets/{id}/edit", protected(a.Finance.Bucket.BucketEdit()))
+	mux.Handle("/finance/buckets/{id}", protected(a.Finance.Bucket.BucketById()))
+	mux.Handle("/finance/buckets/{id}/undo", protected(a.Finance.Bucket.BucketUndo()))
+	mux.Handle("/finance/transactions", protected(a.Finance.Transaction.Transactions()))
+	mux.Handle("/finance/transactions/{id}/edit", protected(a.Finance.Transaction.TransactionsEdit()))
+	mux.Handle("/finance/transactions/{id}", protected(a.Finance.Transaction.TransactionsById()))
+	mux.Handle("/finance/transactions/{id}/undo", protected(a.Finance.Transaction.TransactionUndo()))
+	mux.Handle("/finance/transactions/{id}/history", protected(a.Finance.Transaction.TransactionHistory()))
+	mux.Handle("/finance/trash", protected(a.Finance.Trash.Trash()))
+	mux.Handle("/finance/trash/transactions/{id}", protected(a.Finance.Trash.TrashTransactionById()))
+	mux.Handle("/finance/trash/buckets/{id}", protected(a.Finance.Trash.TrashBucketById()))
+	mux.Handle("/finance/budgets", protected(a.Finance.Budget.Budgets()))
+	mux.Handle("/finance/budgets/{id}", protected(a.Finance.Budget.BudgetById()))
+	mux.Handle("/finance/recurring", protected(a.Finance.Budget.RecurringItems()))
+	mux.Handle("/finance/recurring/{id}", protected(a.Finance.Budget.RecurringItemById()))
+	mux.Handle("/finance/goals", protected(a.Finance.Goal.Goals()))
+	mux.Handle("/finance/goals/{id}", protected(a.Finance.Goal.GoalById()))
+	mux.Handle("/finance/goals/{id}/contributions", protected(a.Finance.Goal.GoalContributions()))
+	mux.Handle("/finance/debts", protected(a.Finance.Debt.Debts()))
+	mux.Handle("/finance/debts/{id}", protected(a.Finance.Debt.DebtById()))
+	mux.Handle("/finance/debts/{id}/payments", protected(a.Finance.Debt.DebtPayments()))
+	mux.Handle("/finance/debt-payments/{id}", protected(a.Finance.Debt.DebtPaymentById()))
+	mux.Handle("/finance/net-worth", protected(a.Finance.NetWorth.NetWorth()))
+	mux.Handle("/finance/net-worth/accounts", protected(a.Finance.NetWorth.Accounts()))
+	mux.Handle("/finance/net-worth/accounts/{id}", protected(a.Finance.NetWorth.AccountById()))
+	mux.Handle("/finance/net-worth/snapshots", protected(a.Finance.NetWorth.Snapshots()))
+	mux.Handle("/finance/net-worth/snapshots/import", protected(a.Finance.NetWorth.SnapshotImport()))
+	mux.Handle("/finance/net-worth/snapshots/{id}", protected(a.Finance.NetWorth.SnapshotById()))
+	mux.Handle("/finance/investments", protected(a.Finance.Investment.Investments()))
+	mux.Handle("/finance/investments/accounts", protected(a.Finance.Investment.Accounts()))
+	mux.Handle("/finance/investments/accounts/{id}", protected(a.Finance.Investment.AccountById()))
+	mux.Handle("/finance/investments/accounts/{id}/trades", protected(a.Finance.Investment.Trades()))
+	mux.Handle("/finance/investments/trades/{id}", protected(a.Finance.Investment.TradeById()))
+	mux.Handle("/finance/investments/securities", protected(a.Finance.Investment.Securities()))
+	mux.Handle("/finance/investments/prices", protected(a.Finance.Investment.Prices()))
+	mux.Handle("/finance/investments/prices/import", protected(a.Finance.Investment.PriceImport()))
+	mux.Handle("/finance/investments/prices/{id}", protected(a.Finance.Investment.PriceById()))
+	mux.Handle("/finance/bills", protected(a.Finance.Bill.Bills()))
+	mux.Handle("/finance/bills/{id}", protected(a.Finance.Bill.BillById()))
+	mux.Handle("/finance/bills/{id}/pay", protected(a.Finance.Bill.BillPay()))
+	mux.Handle("/finance/notifications", protected(a.Finance.Bill.Notifications()))
+	mux.Handle("/finance/notification-settings", protected(a.Finance.NotificationSettings.NotificationSettings()))
+	mux.Handle("/finance/calendar", protected(a.Finance.Calendar.CalendarSettings()))
+	mux.Handle("/finance/two-factor", protected(a.Auth.HandleTwoFactor()))
+	mux.Handle("/finance/two-factor/confirm", protected(a.Auth.HandleTwoFactorConfirm()))
+	mux.Handle("/finance/two-factor/disable", protected(a.Auth.HandleTwoFactorDisable()))
+	mux.Handle("/finance/passkeys", protected(a.Auth.HandlePasskeys()))
+	mux.Handle("/finance/passkeys/options", protected(a.Auth.HandlePasskeyOptions()))
+	mux.Handle("/finance/passkeys/{id}", protected(a.Auth.HandlePasskeyById()))
+	mux.Handle("/finance/password", protected(a.Auth.HandleChangePassword()))
+	mux.Handle("/finance/oidc", protected(a.Auth.HandleOidcIdentities()))
+	mux.Handle("/finance/oidc/link", protected(a.Auth.HandleOidcLink()))
+	mux.Handle("/finance/oidc/{id}", protected(a.Auth.HandleOidcIdentityById()))
+	mux.Handle("/finance/currency", protected(a.Finance.Currency.Currencies()))
+	mux.Handle("/finance/currency/base", protected(a.Finance.Currency.BaseCurrency()))
+	mux.Handle("/finance/currency/rates", protected(a.Finance.Currency.ExchangeRates()))
+	mux.Handle("/finance/currency/rates/import", protected(a.Finance.Currency.ExchangeRateImport()))
+	mux.Handle("/finance/currency/rates/{id}", protected(a.Finance.Currency.ExchangeRateById()))
 }
 
 func handleHealth(l *slog.Logger) http.Handler {
@@ -49,18 +116,3 @@ func handleHealth(l *slog.Logger) http.Handler {
 		},
 	)
 }
-
-func handleHome(l *slog.Logger) http.Handler {
-	return http.HandlerFunc(
-		func(w http.ResponseWriter, r *http.Request) {
-			ctx, cancel := context.WithTimeout(r.Context(), time.Second*20)
-			defer cancel()
-			tmplHomePage := views.Page()
-			err := tmplHomePage.Render(ctx, w)
-			if err != nil {
-				l.Error("handleHome", slog.String("path", "/home"), slog.String("Error", err.Error()))
-				return
-			}
-		},
-	)
-}
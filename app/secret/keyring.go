@@ -0,0 +1,79 @@
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Id given to JWT_SECRET_KEY when JWT_SECRET_KEYS isn't set
+const DEFAULT_KEY_ID = "default"
+
+// Signing keys by id. New tokens are signed with the active key and carry its id as the
+// kid header, older keys stay around so tokens signed before a rotation still verify.
+type Keyring struct {
+	activeId string
+	keys     map[string][]byte
+}
+
+func NewKeyring(activeId string, keys map[string]string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("NewKeyring: no keys")
+	}
+	k := Keyring{activeId: activeId, keys: make(map[string][]byte, len(keys))}
+	for id, key := range keys {
+		if id == "" {
+			return nil, errors.New("NewKeyring: key id is empty")
+		}
+		if key == "" {
+			return nil, fmt.Errorf("NewKeyring: key %q is empty", id)
+		}
+		k.keys[id] = []byte(key)
+	}
+	if _, ok := k.keys[activeId]; !ok {
+		return nil, fmt.Errorf("NewKeyring: active key %q not found", activeId)
+	}
+	return &k, nil
+}
+
+// Parses "id:key,id:key". The active id can be left empty when there's only one key.
+func ParseKeyring(s string, activeId string) (*Keyring, error) {
+	keys := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, key, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("ParseKeyring: %q isn't in id:key format", pair)
+		}
+		if _, ok := keys[id]; ok {
+			return nil, fmt.Errorf("ParseKeyring: duplicate key id %q", id)
+		}
+		keys[id] = key
+	}
+	if activeId == "" && len(keys) == 1 {
+		for id := range keys {
+			activeId = id
+		}
+	}
+	if activeId == "" {
+		return nil, errors.New("ParseKeyring: active key id is needed with more than one key")
+	}
+	k, err := NewKeyring(activeId, keys)
+	if err != nil {
+		return nil, fmt.Errorf("ParseKeyring: %w", err)
+	}
+	return k, nil
+}
+
+// The key new tokens are signed with
+func (k *Keyring) Active() (string, []byte) {
+	return k.activeId, k.keys[k.activeId]
+}
+
+func (k *Keyring) Key(id string) ([]byte, bool) {
+	key, ok := k.keys[id]
+	return key, ok
+}
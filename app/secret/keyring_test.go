@@ -0,0 +1,103 @@
+package secret_test
+
+import (
+	"testing"
+	"wonk/app/secret"
+)
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name           string
+		keys           string
+		activeId       string
+		expectedActive string
+		expectedErr    bool
+	}{
+		{name: "single key is active", keys: "v1:secret", expectedActive: "v1"},
+		{name: "active picked from several", keys: "v1:old, v2:new", activeId: "v2", expectedActive: "v2"},
+		{name: "key can contain colons", keys: "v1:a:b", expectedActive: "v1"},
+		{name: "several keys need an active id", keys: "v1:old,v2:new", expectedErr: true},
+		{name: "active id must exist", keys: "v1:old", activeId: "v2", expectedErr: true},
+		{name: "missing separator", keys: "secret", expectedErr: true},
+		{name: "empty key", keys: "v1:", expectedErr: true},
+		{name: "duplicate id", keys: "v1:a,v1:b", activeId: "v1", expectedErr: true},
+		{name: "no keys", keys: " , ", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := secret.ParseKeyring(tt.keys, tt.activeId)
+			if tt.expectedErr {
+				if err == nil {
+					t.Errorf("expected an error but didnt get one")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("didn't expected an error but did get one, err: %v", err)
+			}
+			activeId, _ := keyring.Active()
+			if activeId != tt.expectedActive {
+				t.Errorf("active: expected %s, got %s", tt.expectedActive, activeId)
+			}
+		})
+	}
+}
+
+func TestInitSecretJwtKeys(t *testing.T) {
+	env := map[string]string{
+		"COOKIE_SECRET_KEY": "abcd",
+		"JWT_SECRET_KEY":    "single",
+	}
+	getEnv := func(k string) string { return env[k] }
+
+	s, err := secret.InitSecret(getEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, ok := s.JwtKeys.Key(secret.DEFAULT_KEY_ID); !ok || string(key) != "single" {
+		t.Errorf("expected JWT_SECRET_KEY under the default id, got %q", key)
+	}
+
+	env["JWT_SECRET_KEYS"] = "v1:old,v2:new"
+	env["JWT_ACTIVE_KEY_ID"] = "v2"
+	s, err = secret.InitSecret(getEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activeId, key := s.JwtKeys.Active(); activeId != "v2" || string(key) != "new" {
+		t.Errorf("expected v2 to be active, got %s", activeId)
+	}
+	if _, ok := s.JwtKeys.Key(secret.DEFAULT_KEY_ID); ok {
+		t.Error("expected JWT_SECRET_KEYS to replace JWT_SECRET_KEY")
+	}
+
+	delete(env, "JWT_SECRET_KEYS")
+	delete(env, "JWT_SECRET_KEY")
+	_, err = secret.InitSecret(getEnv)
+	if err == nil {
+		t.Error("expected an error without any jwt keys")
+	}
+}
+
+func TestInitSecretCookieKeys(t *testing.T) {
+	env := map[string]string{
+		"COOKIE_SECRET_KEY":           "abcd",
+		"COOKIE_PREVIOUS_SECRET_KEYS": "0102, 0304",
+		"JWT_SECRET_KEY":              "single",
+	}
+	getEnv := func(k string) string { return env[k] }
+
+	s, err := secret.InitSecret(getEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.CookiePreviousKeys) != 2 || s.CookiePreviousKeys[1] != "0304" {
+		t.Errorf("unexpected previous keys %v", s.CookiePreviousKeys)
+	}
+
+	env["COOKIE_PREVIOUS_SECRET_KEYS"] = "not hex"
+	_, err = secret.InitSecret(getEnv)
+	if err == nil {
+		t.Error("expected an error for a previous key that isn't hex")
+	}
+}
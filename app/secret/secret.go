@@ -1,13 +1,16 @@
 package secret
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 type Secret struct {
-	CookieKey string // Hex string
-	JwtKey    string // Random string
+	CookieKey          string   // Hex string
+	CookiePreviousKeys []string // Hex strings still accepted when reading cookies
+	JwtKeys            *Keyring // Random strings by key id
 }
 
 func (s *Secret) Valid() error {
@@ -17,16 +20,41 @@ func (s *Secret) Valid() error {
 	if s.CookieKey == "" {
 		return errors.New("Secret: cookie key is empty")
 	}
-	if s.JwtKey == "" {
-		return errors.New("Secret: jwt key is empty")
+	for _, key := range append([]string{s.CookieKey}, s.CookiePreviousKeys...) {
+		_, err := hex.DecodeString(key)
+		if err != nil {
+			return fmt.Errorf("Secret: cookie key: %w", err)
+		}
+	}
+	if s.JwtKeys == nil {
+		return errors.New("Secret: jwt keys are empty")
 	}
 	return nil
 }
 
+// JWT_SECRET_KEYS takes precedence over the single JWT_SECRET_KEY
 func InitSecret(getEnv func(string) string) (*Secret, error) {
 	s := Secret{
 		CookieKey: getEnv("COOKIE_SECRET_KEY"),
-		JwtKey:    getEnv("JWT_SECRET_KEY"),
+	}
+	for _, key := range strings.Split(getEnv("COOKIE_PREVIOUS_SECRET_KEYS"), ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			s.CookiePreviousKeys = append(s.CookiePreviousKeys, key)
+		}
+	}
+	if keys := getEnv("JWT_SECRET_KEYS"); keys != "" {
+		keyring, err := ParseKeyring(keys, getEnv("JWT_ACTIVE_KEY_ID"))
+		if err != nil {
+			return nil, fmt.Errorf("InitSecret: %w", err)
+		}
+		s.JwtKeys = keyring
+	} else if key := getEnv("JWT_SECRET_KEY"); key != "" {
+		keyring, err := NewKeyring(DEFAULT_KEY_ID, map[string]string{DEFAULT_KEY_ID: key})
+		if err != nil {
+			return nil, fmt.Errorf("InitSecret: %w", err)
+		}
+		s.JwtKeys = keyring
 	}
 	err := s.Valid()
 	if err != nil {
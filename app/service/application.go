@@ -3,23 +3,29 @@ package application
 import (
 	"log/slog"
 	"wonk/app/auth"
+	"wonk/app/config"
+	"wonk/app/notify"
 	"wonk/app/secret"
+	"wonk/app/service/dashboard"
 	"wonk/app/service/finance"
 	"wonk/business"
 )
 
 type Service struct {
-	Auth    auth.AuthService
-	Finance *finance.FinanceService
+	Auth      auth.AuthService
+	Finance   *finance.FinanceService
+	Dashboard dashboard.Dashboard
 }
 
-func InitServices(secrets *secret.Secret, l *slog.Logger, b *business.Services) (*Service, error) {
-	a := auth.InitAuthService(secrets, l, b.User)
-	f := finance.InitFinanceService(l, b.Finance)
+func InitServices(secrets *secret.Secret, sessionConfig *config.Session, webauthnConfig *config.WebAuthn, oidcConfig *config.OIDC, mailConfig *config.Mail, sender notify.Sender, l *slog.Logger, b *business.Services) (*Service, error) {
+	a := auth.InitAuthService(secrets, sessionConfig, webauthnConfig, oidcConfig, mailConfig, sender, l, b.User)
+	f := finance.InitFinanceService(l, b.Finance, mailConfig.BaseURL)
+	d := dashboard.InitDashboardService(l, b.Finance)
 
 	s := Service{
-		Auth:    a,
-		Finance: f,
+		Auth:      a,
+		Finance:   f,
+		Dashboard: d,
 	}
 
 	return &s, nil
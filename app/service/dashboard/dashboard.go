@@ -0,0 +1,143 @@
+package dashboard
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+	"wonk/app/auth"
+	"wonk/app/templates/views"
+	"wonk/business/finance"
+
+	"github.com/a-h/templ"
+)
+
+const (
+	TOP_BUCKETS_LIMIT         = 5
+	RECENT_TRANSACTIONS_LIMIT = 5
+)
+
+type Dashboard interface {
+	Home() http.HandlerFunc
+	Panel() http.HandlerFunc
+}
+
+type DashboardHandler struct {
+	Logger       *slog.Logger
+	FinanceLogic finance.Finance
+}
+
+func InitDashboardService(l *slog.Logger, f finance.Finance) Dashboard {
+	return &DashboardHandler{
+		Logger:       l,
+		FinanceLogic: f,
+	}
+}
+
+// Renders the dashboard shell, every panel loads itself after the page is shown
+func (d *DashboardHandler) Home() http.HandlerFunc {
+	funcName := "Home"
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			ctx, cancel := context.WithTimeout(r.Context(), time.Second*20)
+			defer cancel()
+			tmplHome := views.DashboardPage()
+			if r.Header.Get("hx-request") == "true" {
+				tmplHome = views.Dashboard()
+			}
+			err := tmplHome.Render(ctx, w)
+			if err != nil {
+				d.Logger.Error(funcName, slog.String("Error", err.Error()))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Renders a single dashboard panel by name. A panel that fails shows an error card
+// instead of a 500 so the rest of the dashboard still loads.
+func (d *DashboardHandler) Panel() http.HandlerFunc {
+	funcName := "Panel"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			d.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		if r.Method != "GET" {
+			http.Error(w, "Not valid method", 404)
+			return
+		}
+		panel := r.PathValue("panel")
+		tmplPanel, err := d.renderPanel(panel, curUser.UserId, time.Now())
+		if err != nil {
+			d.Logger.Error(funcName, slog.String("Panel", panel), slog.String("Error", err.Error()))
+			tmplPanel = views.PanelError(panel)
+		}
+		if tmplPanel == nil {
+			http.Error(w, "Unknown panel", 404)
+			return
+		}
+		err = tmplPanel.Render(ctx, w)
+		if err != nil {
+			d.Logger.Error(funcName, slog.String("Panel", panel), slog.String("Error", err.Error()))
+		}
+	}
+}
+
+// Returns a nil component when there isn't a panel with the name
+func (d *DashboardHandler) renderPanel(panel string, userId int, now time.Time) (templ.Component, error) {
+	switch panel {
+	case views.PANEL_NET:
+		summary, err := d.FinanceLogic.MonthlySummary(userId, int(now.Month()), now.Year())
+		if err != nil {
+			return nil, err
+		}
+		return views.NetPanel(*summary, now), nil
+	case views.PANEL_BUDGETS:
+		progress, err := d.FinanceLogic.BudgetBurnDown(userId, now)
+		if err != nil {
+			return nil, err
+		}
+		return views.BudgetPanel(progress), nil
+	case views.PANEL_TOP_BUCKETS:
+		top, err := d.FinanceLogic.TopBuckets(userId, now, TOP_BUCKETS_LIMIT)
+		if err != nil {
+			return nil, err
+		}
+		return views.TopBucketsPanel(top), nil
+	case views.PANEL_RECENT:
+		recent, err := d.FinanceLogic.GetTransactions(1, RECENT_TRANSACTIONS_LIMIT, userId, "id", false, finance.TransactionFilters{})
+		if err != nil {
+			return nil, err
+		}
+		return views.RecentPanel(recent), nil
+	case views.PANEL_UPCOMING:
+		upcoming, err := d.FinanceLogic.UpcomingRecurring(userId, now)
+		if err != nil {
+			return nil, err
+		}
+		return views.UpcomingPanel(upcoming), nil
+	case views.PANEL_CHANGE:
+		change, err := d.FinanceLogic.MonthOverMonth(userId, now)
+		if err != nil {
+			return nil, err
+		}
+		return views.ChangePanel(*change), nil
+	default:
+		return nil, nil
+	}
+}
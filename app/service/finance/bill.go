@@ -0,0 +1,299 @@
+package finance
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"wonk/app/auth"
+	"wonk/app/cuserr"
+	"wonk/app/templates/views"
+	"wonk/business/finance"
+)
+
+const (
+	// Sent with every change to bills so the notification bell reloads
+	BILLS_CHANGED_EVENT = "bills-changed"
+	// Id of the bell in the header, htmx sends it as the target when paying from there
+	NOTIFICATION_BELL_ID = "notification-bell"
+)
+
+type Bill interface {
+	Bills() http.HandlerFunc
+	BillById() http.HandlerFunc
+	BillPay() http.HandlerFunc
+	Notifications() http.HandlerFunc
+}
+
+type BillHandler struct {
+	Logger       *slog.Logger
+	FinanceLogic finance.Finance
+}
+
+func initBillHandler(l *slog.Logger, f finance.Finance) Bill {
+	return &BillHandler{
+		Logger:       l,
+		FinanceLogic: f,
+	}
+}
+
+func (b *BillHandler) Bills() http.HandlerFunc {
+	funcName := "Bills"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			b.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			b.renderBillView(ctx, w, funcName, curUser.UserId, views.BillPageData{})
+			return
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				b.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := BillInput{
+				Name:     r.FormValue("name"),
+				Amount:   r.FormValue("amount"),
+				Currency: r.FormValue("currency"),
+				BucketId: r.FormValue("bucket"),
+				Day:      r.FormValue("day"),
+			}
+			bill, problems := parseBill(formData)
+			if len(problems) == 0 {
+				problems, err = b.FinanceLogic.AddBill(curUser.UserId, bill)
+				if err != nil {
+					b.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.BillPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				pageData.Form = views.BillFormData{
+					NameValue:     formData.Name,
+					AmountValue:   formData.Amount,
+					CurrencyValue: formData.Currency,
+					BucketValue:   formData.BucketId,
+					DayValue:      formData.Day,
+				}
+				if val, ok := problems["Name"]; ok {
+					pageData.Form.NameErr = &val
+				}
+				if val, ok := problems["Amount"]; ok {
+					pageData.Form.AmountErr = &val
+				}
+				if val, ok := problems["Currency"]; ok {
+					pageData.Form.CurrencyErr = &val
+				}
+				if val, ok := problems["BucketId"]; ok {
+					pageData.Form.BucketErr = &val
+				}
+				if val, ok := problems["DueDay"]; ok {
+					pageData.Form.DayErr = &val
+				}
+			} else {
+				w.Header().Set("HX-Trigger", BILLS_CHANGED_EVENT)
+			}
+			b.renderBillView(ctx, w, funcName, curUser.UserId, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (b *BillHandler) BillById() http.HandlerFunc {
+	funcName := "BillById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			b.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		billId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "DELETE":
+			err := b.FinanceLogic.DeleteBill(curUser.UserId, billId)
+			if err != nil {
+				var notFoundErr cuserr.NotFound
+				if errors.As(err, &notFoundErr) {
+					http.Error(w, "Bill not found", 404)
+					return
+				}
+				b.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			w.Header().Set("HX-Trigger", BILLS_CHANGED_EVENT)
+			b.renderBillView(ctx, w, funcName, curUser.UserId, views.BillPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Marks a due date paid, creating the expense for it. Paying from the bell re-renders the bell, otherwise the bills page.
+func (b *BillHandler) BillPay() http.HandlerFunc {
+	funcName := "BillPay"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			b.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		billId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				b.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			problems, err := b.FinanceLogic.PayBill(ctx, curUser.UserId, billId, r.FormValue("due"), time.Now())
+			if err != nil {
+				var notFoundErr cuserr.NotFound
+				if errors.As(err, &notFoundErr) {
+					http.Error(w, "Bill not found", 404)
+					return
+				}
+				b.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			var payErr *string
+			for _, msg := range problems {
+				payErr = &msg
+			}
+			if r.Header.Get("HX-Target") == NOTIFICATION_BELL_ID {
+				if payErr != nil {
+					w.WriteHeader(422)
+				}
+				b.renderNotificationBell(ctx, w, funcName, curUser.UserId, payErr, true)
+				return
+			}
+			if payErr != nil {
+				w.WriteHeader(422)
+			} else {
+				w.Header().Set("HX-Trigger", BILLS_CHANGED_EVENT)
+			}
+			b.renderBillView(ctx, w, funcName, curUser.UserId, views.BillPageData{PayErr: payErr})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// The bell in the header, loaded after the page and whenever bills change
+func (b *BillHandler) Notifications() http.HandlerFunc {
+	funcName := "Notifications"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			b.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			b.renderNotificationBell(ctx, w, funcName, curUser.UserId, nil, false)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (b *BillHandler) renderBillView(ctx context.Context, w http.ResponseWriter, funcName string, userId int, data views.BillPageData) {
+	buckets, err := b.FinanceLogic.UserBuckets(userId)
+	if err != nil {
+		b.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	overview, err := b.FinanceLogic.Bills(userId, time.Now())
+	if err != nil {
+		b.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	data.Buckets = buckets
+	data.Overview = overview
+	tmplBill := views.BillView(data)
+	err = tmplBill.Render(ctx, w)
+	if err != nil {
+		b.Logger.Error(funcName, slog.String("Error", err.Error()))
+	}
+}
+
+// open keeps the list showing after paying from it
+func (b *BillHandler) renderNotificationBell(ctx context.Context, w http.ResponseWriter, funcName string, userId int, payErr *string, open bool) {
+	reminders, err := b.FinanceLogic.BillReminders(userId, time.Now())
+	if err != nil {
+		b.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	tmplBell := views.NotificationBell(views.NotificationData{Reminders: reminders, PayErr: payErr, Open: open})
+	err = tmplBell.Render(ctx, w)
+	if err != nil {
+		b.Logger.Error(funcName, slog.String("Error", err.Error()))
+	}
+}
@@ -2,11 +2,13 @@ package finance
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 	"wonk/app/auth"
+	"wonk/app/cuserr"
 	"wonk/app/templates/views"
 	"wonk/business/finance"
 )
@@ -16,6 +18,7 @@ type Bucket interface {
 	Buckets() http.HandlerFunc
 	BucketEdit() http.HandlerFunc
 	BucketById() http.HandlerFunc
+	BucketUndo() http.HandlerFunc
 }
 
 type BucketHandler struct {
@@ -65,7 +68,7 @@ func (b *BucketHandler) BucketForm() http.HandlerFunc {
 				return
 			}
 			newName := r.FormValue("name")
-			problems, err := b.FinanceLogic.CreateBucket(curUser.UserId, newName)
+			problems, err := b.FinanceLogic.CreateBucket(ctx, curUser.UserId, newName)
 			if err != nil {
 				b.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("error", err.Error()))
 				http.Error(w, "Internal Error", 500)
@@ -232,7 +235,7 @@ func (b *BucketHandler) BucketById() http.HandlerFunc {
 				return
 			}
 			newName := r.FormValue("name")
-			err = b.FinanceLogic.UpdateBucket(bucket.Id, newName)
+			err = b.FinanceLogic.UpdateBucket(ctx, bucket.Id, newName)
 			if err != nil {
 				b.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
 				http.Error(w, "Internal Error", 500)
@@ -245,6 +248,78 @@ func (b *BucketHandler) BucketById() http.HandlerFunc {
 				b.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
 			}
 			return
+		case "DELETE":
+			err := b.FinanceLogic.DeleteBucket(ctx, bucket.Id)
+			if err != nil {
+				b.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			row := views.BucketRow{BucketId: bucketId, BucketName: bucket.Name}
+			rowTmpl := views.GetBucketDeletedRow(row)
+			err = rowTmpl.Render(ctx, w)
+			if err != nil {
+				b.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (b *BucketHandler) BucketUndo() http.HandlerFunc {
+	funcName := "BucketUndo"
+	return func(w http.ResponseWriter, r *http.Request) {
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			b.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		switch r.Method {
+		case "PUT":
+			bucketId := r.PathValue("id")
+			bucket, err := b.FinanceLogic.GetDeletedBucket(bucketId)
+			if err != nil {
+				b.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				w.WriteHeader(500)
+				return
+			}
+			if curUser.UserId != bucket.UserId {
+				w.WriteHeader(403)
+				return
+			}
+			err = b.FinanceLogic.UndoDeleteBucket(ctx, bucket.Id)
+			if err != nil {
+				if errors.As(err, &cuserr.Expired{}) {
+					rowTmpl := views.UndoExpiredRow()
+					err = rowTmpl.Render(ctx, w)
+					if err != nil {
+						b.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+					}
+					return
+				}
+				b.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			row := views.BucketRow{BucketId: bucketId, BucketName: bucket.Name}
+			rowTmpl := views.GetBucketRow(row)
+			err = rowTmpl.Render(ctx, w)
+			if err != nil {
+				b.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+			}
+			return
 		default:
 			http.Error(w, "Not valid method", 404)
 		}
@@ -0,0 +1,314 @@
+package finance
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"wonk/app/auth"
+	"wonk/app/templates/views"
+	"wonk/business/finance"
+)
+
+type Budget interface {
+	Budgets() http.HandlerFunc
+	BudgetById() http.HandlerFunc
+	RecurringItems() http.HandlerFunc
+	RecurringItemById() http.HandlerFunc
+}
+
+type BudgetHandler struct {
+	Logger       *slog.Logger
+	FinanceLogic finance.Finance
+}
+
+func initBudgetHandler(l *slog.Logger, f finance.Finance) Budget {
+	return &BudgetHandler{
+		Logger:       l,
+		FinanceLogic: f,
+	}
+}
+
+// Lists the user's budgets (GET) or sets a bucket's budget (POST)
+func (b *BudgetHandler) Budgets() http.HandlerFunc {
+	funcName := "Budgets"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			b.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			b.renderBudgetView(ctx, w, funcName, curUser.UserId, views.BudgetPageData{})
+			return
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				b.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := BudgetInput{
+				BucketId: r.FormValue("bucket"),
+				Amount:   r.FormValue("amount"),
+			}
+			budget, problems := parseBudget(formData)
+			if len(problems) == 0 {
+				problems, err = b.FinanceLogic.SetBudget(curUser.UserId, budget)
+				if err != nil {
+					b.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.BudgetPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				pageData.Form = views.BudgetFormData{
+					BucketValue: formData.BucketId,
+					AmountValue: formData.Amount,
+				}
+				if val, ok := problems["BucketId"]; ok {
+					pageData.Form.BucketErr = &val
+				}
+				if val, ok := problems["Amount"]; ok {
+					pageData.Form.AmountErr = &val
+				}
+			}
+			b.renderBudgetView(ctx, w, funcName, curUser.UserId, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (b *BudgetHandler) BudgetById() http.HandlerFunc {
+	funcName := "BudgetById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			b.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		budgetId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "DELETE":
+			err := b.FinanceLogic.DeleteBudget(curUser.UserId, budgetId)
+			if err != nil {
+				b.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			rowTmpl := views.TrashClearedRow("Removed")
+			err = rowTmpl.Render(ctx, w)
+			if err != nil {
+				b.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Lists the user's recurring items (GET) or adds one (POST)
+func (b *BudgetHandler) RecurringItems() http.HandlerFunc {
+	funcName := "RecurringItems"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			b.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			b.renderRecurringView(ctx, w, funcName, curUser.UserId, views.RecurringPageData{})
+			return
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				b.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := RecurringItemInput{
+				Name:      r.FormValue("name"),
+				Price:     r.FormValue("price"),
+				IsExpense: r.FormValue("isExpense"),
+				Currency:  r.FormValue("currency"),
+				BucketId:  r.FormValue("bucket"),
+				Day:       r.FormValue("day"),
+			}
+			item, problems := parseRecurringItem(formData)
+			if len(problems) == 0 {
+				problems, err = b.FinanceLogic.AddRecurringItem(curUser.UserId, item)
+				if err != nil {
+					b.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.RecurringPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				pageData.Form = views.RecurringFormData{
+					NameValue:     formData.Name,
+					PriceValue:    formData.Price,
+					BucketValue:   formData.BucketId,
+					CurrencyValue: formData.Currency,
+					DayValue:      formData.Day,
+				}
+				if val, ok := problems["Name"]; ok {
+					pageData.Form.NameErr = &val
+				}
+				if val, ok := problems["Price"]; ok {
+					pageData.Form.PriceErr = &val
+				}
+				if val, ok := problems["BucketId"]; ok {
+					pageData.Form.BucketErr = &val
+				}
+				if val, ok := problems["Currency"]; ok {
+					pageData.Form.CurrencyErr = &val
+				}
+				if val, ok := problems["DayOfMonth"]; ok {
+					pageData.Form.DayErr = &val
+				}
+			}
+			b.renderRecurringView(ctx, w, funcName, curUser.UserId, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (b *BudgetHandler) RecurringItemById() http.HandlerFunc {
+	funcName := "RecurringItemById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			b.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		itemId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "DELETE":
+			err := b.FinanceLogic.DeleteRecurringItem(curUser.UserId, itemId)
+			if err != nil {
+				b.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			rowTmpl := views.TrashClearedRow("Removed")
+			err = rowTmpl.Render(ctx, w)
+			if err != nil {
+				b.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (b *BudgetHandler) renderBudgetView(ctx context.Context, w http.ResponseWriter, funcName string, userId int, data views.BudgetPageData) {
+	base, err := b.FinanceLogic.BaseCurrency(userId)
+	if err != nil {
+		b.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	buckets, err := b.FinanceLogic.UserBuckets(userId)
+	if err != nil {
+		b.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	budgets, err := b.FinanceLogic.Budgets(userId)
+	if err != nil {
+		b.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	data.BaseCurrency = base
+	data.Buckets = buckets
+	data.Budgets = budgets
+	tmplBudget := views.BudgetView(data)
+	err = tmplBudget.Render(ctx, w)
+	if err != nil {
+		b.Logger.Error(funcName, slog.String("Error", err.Error()))
+	}
+}
+
+func (b *BudgetHandler) renderRecurringView(ctx context.Context, w http.ResponseWriter, funcName string, userId int, data views.RecurringPageData) {
+	buckets, err := b.FinanceLogic.UserBuckets(userId)
+	if err != nil {
+		b.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	items, err := b.FinanceLogic.RecurringItems(userId)
+	if err != nil {
+		b.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	data.Buckets = buckets
+	data.Items = items
+	tmplRecurring := views.RecurringView(data)
+	err = tmplRecurring.Render(ctx, w)
+	if err != nil {
+		b.Logger.Error(funcName, slog.String("Error", err.Error()))
+	}
+}
@@ -0,0 +1,164 @@
+package finance
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"wonk/app/auth"
+	"wonk/app/cuserr"
+	"wonk/app/ical"
+	"wonk/app/templates/views"
+	"wonk/business/finance"
+)
+
+const (
+	CALENDAR_FEED_PATH = "/calendar/"
+	// How long calendar apps may cache the feed
+	CALENDAR_FEED_MAX_AGE = "900"
+)
+
+type Calendar interface {
+	CalendarFeed() http.HandlerFunc
+	CalendarSettings() http.HandlerFunc
+}
+
+type CalendarHandler struct {
+	Logger       *slog.Logger
+	FinanceLogic finance.Finance
+	BaseURL      string // Where the feed is served from, never taken from the request
+}
+
+func initCalendarHandler(l *slog.Logger, f finance.Finance, baseURL string) Calendar {
+	return &CalendarHandler{
+		Logger:       l,
+		FinanceLogic: f,
+		BaseURL:      baseURL,
+	}
+}
+
+// Served without a session since calendar apps fetch it, the token in the path is the only auth
+func (c *CalendarHandler) CalendarFeed() http.HandlerFunc {
+	funcName := "CalendarFeed"
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD":
+			token, ok := strings.CutSuffix(r.PathValue("file"), ".ics")
+			if !ok {
+				http.Error(w, "Not Found", 404)
+				return
+			}
+			now := time.Now()
+			events, err := c.FinanceLogic.CalendarEvents(token, now)
+			if err != nil {
+				var notFoundErr cuserr.NotFound
+				if errors.As(err, &notFoundErr) {
+					http.Error(w, "Not Found", 404)
+					return
+				}
+				c.Logger.Error(funcName, slog.String("HttpMethod", r.Method), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			cal := ical.Calendar{
+				ProdId: "-//Wonk//Bills and Recurring//EN",
+				Name:   "Wonk Bills",
+			}
+			for _, e := range events {
+				cal.Events = append(cal.Events, ical.Event{
+					Uid:         e.Uid,
+					Summary:     e.Summary,
+					Description: e.Description,
+					Start:       e.Start,
+					RRule:       ical.MonthlyOnDay(e.DayOfMonth),
+					Stamp:       now,
+				})
+			}
+			w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+			w.Header().Set("Cache-Control", "private, max-age="+CALENDAR_FEED_MAX_AGE)
+			w.Header().Set("Referrer-Policy", "no-referrer")
+			if r.Method == "HEAD" {
+				return
+			}
+			err = ical.Write(w, cal)
+			if err != nil {
+				c.Logger.Error(funcName, slog.String("HttpMethod", r.Method), slog.String("Error", err.Error()))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (c *CalendarHandler) CalendarSettings() http.HandlerFunc {
+	funcName := "CalendarSettings"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			c.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			c.renderCalendarView(ctx, w, funcName, curUser.UserId, views.CalendarPageData{})
+			return
+		case "POST":
+			// Makes the feed or replaces its token, the url is only shown in this response
+			token, err := c.FinanceLogic.RotateCalendarToken(curUser.UserId)
+			if err != nil {
+				c.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			c.renderCalendarView(ctx, w, funcName, curUser.UserId, views.CalendarPageData{FeedUrl: feedUrl(c.BaseURL, token)})
+			return
+		case "DELETE":
+			err := c.FinanceLogic.DisableCalendarFeed(curUser.UserId)
+			if err != nil {
+				var notFoundErr cuserr.NotFound
+				if !errors.As(err, &notFoundErr) {
+					c.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			c.renderCalendarView(ctx, w, funcName, curUser.UserId, views.CalendarPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Absolute url calendar apps can subscribe to
+func feedUrl(baseURL, token string) string {
+	return baseURL + CALENDAR_FEED_PATH + token + ".ics"
+}
+
+func (c *CalendarHandler) renderCalendarView(ctx context.Context, w http.ResponseWriter, funcName string, userId int, data views.CalendarPageData) {
+	feed, err := c.FinanceLogic.CalendarFeedStatus(userId)
+	if err != nil {
+		c.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	data.Feed = feed
+	tmplCalendar := views.CalendarView(data)
+	err = tmplCalendar.Render(ctx, w)
+	if err != nil {
+		c.Logger.Error(funcName, slog.String("Error", err.Error()))
+	}
+}
@@ -1,7 +1,10 @@
 package finance
 
 import (
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 	"wonk/app/templates/views"
 	"wonk/business/finance"
 	database "wonk/storage"
@@ -48,6 +51,7 @@ func parseNewTransaction(t TransactionNewInput) (database.TransactionItemInput,
 		IsExpense: isExpense,
 		UserId:    t.UserId,
 		BucketId:  bucketId,
+		Currency:  parseCurrency(t.Currency),
 	}
 	return dbModel, nil
 }
@@ -81,6 +85,7 @@ func parseEditTransaction(input TransactionEditInput) (finance.TransactionEdit,
 		Year:          year,
 		Price:         price,
 		BucketId:      bucketId,
+		Currency:      parseCurrency(input.Currency),
 	}
 	return businessModel, nil
 }
@@ -159,3 +164,473 @@ func convertToFilters(f finance.TransactionFilters) []views.Filter {
 	}
 	return newFilters
 }
+
+// Currency codes are stored upper case, an empty value falls back to the default currency
+func parseCurrency(c string) string {
+	c = strings.ToUpper(strings.TrimSpace(c))
+	if c == "" {
+		return database.DEFAULT_CURRENCY
+	}
+	return c
+}
+
+func parseExchangeRate(input ExchangeRateInput) (database.ExchangeRateInput, map[string]string) {
+	dbModel := database.ExchangeRateInput{}
+	parseProblems := make(map[string]string)
+	rate, err := strconv.ParseFloat(input.Rate, 64)
+	if err != nil {
+		parseProblems["Rate"] = "Not a decimal"
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.ExchangeRateInput{
+		Currency: parseCurrency(input.Currency),
+		Rate:     rate,
+		RateDate: strings.TrimSpace(input.Date),
+	}
+	return dbModel, nil
+}
+
+const (
+	RANGE_YEAR_TO_DATE  = "ytd"
+	RANGE_TRAILING_YEAR = "ttm"
+	RANGE_CUSTOM        = "custom"
+)
+
+// Presets ignore start and end, a custom range reads them as YYYY-MM from month inputs
+func parsePeriod(input RangeInput, now time.Time) (finance.Period, map[string]string) {
+	parseProblems := make(map[string]string)
+	switch input.Preset {
+	case RANGE_YEAR_TO_DATE:
+		return finance.YearToDate(now), nil
+	case RANGE_TRAILING_YEAR, "":
+		return finance.TrailingTwelveMonths(now), nil
+	case RANGE_CUSTOM:
+	default:
+		parseProblems["Range"] = "Not valid"
+		return finance.Period{}, parseProblems
+	}
+	start, err := time.Parse("2006-01", strings.TrimSpace(input.Start))
+	if err != nil {
+		parseProblems["Start"] = "Must be YYYY-MM"
+	}
+	end, err := time.Parse("2006-01", strings.TrimSpace(input.End))
+	if err != nil {
+		parseProblems["End"] = "Must be YYYY-MM"
+	}
+	if len(parseProblems) > 0 {
+		return finance.Period{}, parseProblems
+	}
+	return finance.Period{
+		Start: finance.YearMonth{Month: int(start.Month()), Year: start.Year()},
+		End:   finance.YearMonth{Month: int(end.Month()), Year: end.Year()},
+	}, nil
+}
+
+func parseBudget(input BudgetInput) (database.BudgetInput, map[string]string) {
+	dbModel := database.BudgetInput{}
+	parseProblems := make(map[string]string)
+	bucketId, err := strconv.Atoi(input.BucketId)
+	if err != nil {
+		parseProblems["BucketId"] = "Invalid Id"
+	}
+	amount, err := strconv.ParseFloat(input.Amount, 64)
+	if err != nil {
+		parseProblems["Amount"] = "Not a decimal"
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.BudgetInput{
+		BucketId: bucketId,
+		Amount:   amount,
+	}
+	return dbModel, nil
+}
+
+func parseRecurringItem(input RecurringItemInput) (database.RecurringItemInput, map[string]string) {
+	dbModel := database.RecurringItemInput{}
+	parseProblems := make(map[string]string)
+	price, err := strconv.ParseFloat(input.Price, 64)
+	if err != nil {
+		parseProblems["Price"] = "Not a decimal"
+	}
+	bucketId, err := strconv.Atoi(input.BucketId)
+	if err != nil {
+		parseProblems["BucketId"] = "Invalid Id"
+	}
+	day, err := strconv.Atoi(input.Day)
+	if err != nil {
+		parseProblems["DayOfMonth"] = "Not a number"
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.RecurringItemInput{
+		Name:       input.Name,
+		Price:      price,
+		IsExpense:  input.IsExpense == "on",
+		Currency:   parseCurrency(input.Currency),
+		BucketId:   bucketId,
+		DayOfMonth: day,
+	}
+	return dbModel, nil
+}
+
+// An empty bucket means the goal tracks explicit contributions
+func parseSavingsGoal(input SavingsGoalInput) (database.SavingsGoalInput, map[string]string) {
+	dbModel := database.SavingsGoalInput{}
+	parseProblems := make(map[string]string)
+	target, err := strconv.ParseFloat(input.TargetAmount, 64)
+	if err != nil {
+		parseProblems["TargetAmount"] = "Not a decimal"
+	}
+	var bucketId *int
+	if input.BucketId != "" {
+		id, err := strconv.Atoi(input.BucketId)
+		if err != nil {
+			parseProblems["BucketId"] = "Invalid Id"
+		}
+		bucketId = &id
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.SavingsGoalInput{
+		Name:         strings.TrimSpace(input.Name),
+		TargetAmount: target,
+		TargetDate:   strings.TrimSpace(input.TargetDate),
+		BucketId:     bucketId,
+	}
+	return dbModel, nil
+}
+
+func parseGoalContribution(goalId int, input GoalContributionInput) (database.GoalContributionInput, map[string]string) {
+	dbModel := database.GoalContributionInput{}
+	parseProblems := make(map[string]string)
+	amount, err := strconv.ParseFloat(input.Amount, 64)
+	if err != nil {
+		parseProblems["Amount"] = "Not a decimal"
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.GoalContributionInput{
+		GoalId:        goalId,
+		Amount:        amount,
+		ContributedOn: strings.TrimSpace(input.Date),
+	}
+	return dbModel, nil
+}
+
+// Empty months and window use the defaults, an empty balance starts from the net of every transaction
+func parseForecast(input ForecastInput) (finance.ForecastInput, map[string]string) {
+	businessModel := finance.ForecastInput{Months: 12, Window: finance.DEFAULT_FORECAST_WINDOW}
+	parseProblems := make(map[string]string)
+	if input.Months != "" {
+		months, err := strconv.Atoi(input.Months)
+		if err != nil {
+			parseProblems["Months"] = "Not a number"
+		}
+		businessModel.Months = months
+	}
+	if input.Window != "" {
+		window, err := strconv.Atoi(input.Window)
+		if err != nil {
+			parseProblems["Window"] = "Not a number"
+		}
+		businessModel.Window = window
+	}
+	if strings.TrimSpace(input.StartingBalance) != "" {
+		balance, err := strconv.ParseFloat(strings.TrimSpace(input.StartingBalance), 64)
+		if err != nil {
+			parseProblems["StartingBalance"] = "Not a decimal"
+		}
+		businessModel.StartingBalance = &balance
+	}
+	if len(parseProblems) > 0 {
+		return businessModel, parseProblems
+	}
+	return businessModel, nil
+}
+
+func parseDebt(input DebtInput) (database.DebtInput, map[string]string) {
+	dbModel := database.DebtInput{}
+	parseProblems := make(map[string]string)
+	balance, err := strconv.ParseFloat(input.Balance, 64)
+	if err != nil {
+		parseProblems["Balance"] = "Not a decimal"
+	}
+	apr, err := strconv.ParseFloat(input.Apr, 64)
+	if err != nil {
+		parseProblems["Apr"] = "Not a decimal"
+	}
+	minPayment, err := strconv.ParseFloat(input.MinPayment, 64)
+	if err != nil {
+		parseProblems["MinPayment"] = "Not a decimal"
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.DebtInput{
+		Name:       strings.TrimSpace(input.Name),
+		Balance:    balance,
+		Apr:        apr,
+		MinPayment: minPayment,
+	}
+	return dbModel, nil
+}
+
+// An empty extra is 0 and an empty strategy is avalanche. The custom order comes from a rank per debt,
+// debts without a rank are left for the planner to put last.
+func parsePayoff(input PayoffInput) (finance.PayoffInput, map[string]string) {
+	businessModel := finance.PayoffInput{Strategy: input.Strategy}
+	parseProblems := make(map[string]string)
+	if businessModel.Strategy == "" {
+		businessModel.Strategy = finance.DEBT_STRATEGY_AVALANCHE
+	}
+	if strings.TrimSpace(input.Extra) != "" {
+		extra, err := strconv.ParseFloat(strings.TrimSpace(input.Extra), 64)
+		if err != nil {
+			parseProblems["Extra"] = "Not a decimal"
+		}
+		businessModel.Extra = extra
+	}
+	type rankedDebt struct {
+		id   int
+		rank int
+	}
+	ranked := []rankedDebt{}
+	for i, rawId := range input.RankIds {
+		if i >= len(input.Ranks) || strings.TrimSpace(input.Ranks[i]) == "" {
+			continue
+		}
+		id, err := strconv.Atoi(rawId)
+		if err != nil {
+			parseProblems["Order"] = "Invalid Id"
+			continue
+		}
+		rank, err := strconv.Atoi(strings.TrimSpace(input.Ranks[i]))
+		if err != nil {
+			parseProblems["Order"] = "Ranks must be whole numbers"
+			continue
+		}
+		ranked = append(ranked, rankedDebt{id: id, rank: rank})
+	}
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return ranked[a].rank < ranked[b].rank
+	})
+	for _, r := range ranked {
+		businessModel.Order = append(businessModel.Order, r.id)
+	}
+	if len(parseProblems) > 0 {
+		return businessModel, parseProblems
+	}
+	return businessModel, nil
+}
+
+func parseDebtPayment(debtId int, input DebtPaymentInput) (database.DebtPaymentInput, map[string]string) {
+	dbModel := database.DebtPaymentInput{}
+	parseProblems := make(map[string]string)
+	transactionId, err := strconv.Atoi(input.TransactionId)
+	if err != nil {
+		parseProblems["TransactionId"] = "Pick a transaction"
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.DebtPaymentInput{
+		DebtId:        debtId,
+		TransactionId: transactionId,
+	}
+	return dbModel, nil
+}
+
+// The account form picks the kind and category together as "kind|category"
+func parseNetWorthAccount(input NetWorthAccountInput) (database.NetWorthAccountInput, map[string]string) {
+	dbModel := database.NetWorthAccountInput{}
+	parseProblems := make(map[string]string)
+	kind, category, ok := strings.Cut(input.Type, "|")
+	if !ok {
+		parseProblems["Kind"] = "Not valid"
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.NetWorthAccountInput{
+		Name:     strings.TrimSpace(input.Name),
+		Kind:     kind,
+		Category: category,
+		Currency: parseCurrency(input.Currency),
+	}
+	return dbModel, nil
+}
+
+func parseSnapshot(input SnapshotInput) (database.SnapshotInput, map[string]string) {
+	dbModel := database.SnapshotInput{}
+	parseProblems := make(map[string]string)
+	accountId, err := strconv.Atoi(input.AccountId)
+	if err != nil {
+		parseProblems["AccountId"] = "Invalid Id"
+	}
+	balance, err := strconv.ParseFloat(input.Balance, 64)
+	if err != nil {
+		parseProblems["Balance"] = "Not a decimal"
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.SnapshotInput{
+		AccountId:    accountId,
+		Balance:      balance,
+		SnapshotDate: strings.TrimSpace(input.Date),
+	}
+	return dbModel, nil
+}
+
+func parseInvestmentAccount(input InvestmentAccountInput) database.InvestmentAccountInput {
+	return database.InvestmentAccountInput{
+		Name:     strings.TrimSpace(input.Name),
+		Currency: parseCurrency(input.Currency),
+	}
+}
+
+func parseSymbol(symbol string) string {
+	return strings.ToUpper(strings.TrimSpace(symbol))
+}
+
+func parseTrade(accountId int, input TradeInput) (database.InvestmentTradeInput, map[string]string) {
+	dbModel := database.InvestmentTradeInput{}
+	parseProblems := make(map[string]string)
+	quantity := 0.0
+	if strings.TrimSpace(input.Quantity) != "" || input.Kind != database.TRADE_KIND_DIVIDEND {
+		var err error
+		quantity, err = strconv.ParseFloat(strings.TrimSpace(input.Quantity), 64)
+		if err != nil {
+			parseProblems["Quantity"] = "Not a decimal"
+		}
+	}
+	price, err := strconv.ParseFloat(strings.TrimSpace(input.Price), 64)
+	if err != nil {
+		parseProblems["Price"] = "Not a decimal"
+	}
+	fees := 0.0
+	if strings.TrimSpace(input.Fees) != "" {
+		fees, err = strconv.ParseFloat(strings.TrimSpace(input.Fees), 64)
+		if err != nil {
+			parseProblems["Fees"] = "Not a decimal"
+		}
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.InvestmentTradeInput{
+		AccountId: accountId,
+		Symbol:    parseSymbol(input.Symbol),
+		Kind:      input.Kind,
+		Quantity:  quantity,
+		Price:     price,
+		Fees:      fees,
+		TradeDate: strings.TrimSpace(input.Date),
+	}
+	return dbModel, nil
+}
+
+func parseSecurity(input SecurityInput) database.SecurityInput {
+	return database.SecurityInput{
+		Symbol:     parseSymbol(input.Symbol),
+		AssetClass: input.AssetClass,
+	}
+}
+
+func parseSecurityPrice(input SecurityPriceInput) (database.SecurityPriceInput, map[string]string) {
+	dbModel := database.SecurityPriceInput{}
+	parseProblems := make(map[string]string)
+	price, err := strconv.ParseFloat(strings.TrimSpace(input.Price), 64)
+	if err != nil {
+		parseProblems["Price"] = "Not a decimal"
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.SecurityPriceInput{
+		Symbol:    parseSymbol(input.Symbol),
+		Price:     price,
+		PriceDate: strings.TrimSpace(input.Date),
+	}
+	return dbModel, nil
+}
+
+func parseBill(input BillInput) (database.BillInput, map[string]string) {
+	dbModel := database.BillInput{}
+	parseProblems := make(map[string]string)
+	amount, err := strconv.ParseFloat(input.Amount, 64)
+	if err != nil {
+		parseProblems["Amount"] = "Not a decimal"
+	}
+	bucketId, err := strconv.Atoi(input.BucketId)
+	if err != nil {
+		parseProblems["BucketId"] = "Invalid Id"
+	}
+	day, err := strconv.Atoi(input.Day)
+	if err != nil {
+		parseProblems["DueDay"] = "Not a number"
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.BillInput{
+		Name:     strings.TrimSpace(input.Name),
+		Amount:   amount,
+		Currency: parseCurrency(input.Currency),
+		BucketId: bucketId,
+		DueDay:   day,
+	}
+	return dbModel, nil
+}
+
+// Checkboxes are "on" when checked and missing otherwise
+func parseCheckbox(value string, key string, parseProblems map[string]string) bool {
+	switch value {
+	case "on":
+		return true
+	case "":
+		return false
+	default:
+		parseProblems[key] = "Not valid"
+		return false
+	}
+}
+
+func parseNotificationPreference(input NotificationPreferenceInput) (database.NotificationPreferenceInput, map[string]string) {
+	dbModel := database.NotificationPreferenceInput{}
+	parseProblems := make(map[string]string)
+	weeklyDigest := parseCheckbox(input.WeeklyDigest, "WeeklyDigest", parseProblems)
+	budgetAlerts := parseCheckbox(input.BudgetAlerts, "BudgetAlerts", parseProblems)
+	billAlerts := parseCheckbox(input.BillAlerts, "BillAlerts", parseProblems)
+	weekday, err := strconv.Atoi(input.DigestWeekday)
+	if err != nil {
+		parseProblems["DigestWeekday"] = "Not a number"
+	}
+	var largeTransaction *float64
+	if threshold := strings.TrimSpace(input.LargeTransaction); threshold != "" {
+		amount, err := strconv.ParseFloat(threshold, 64)
+		if err != nil {
+			parseProblems["LargeTransaction"] = "Not a decimal"
+		}
+		largeTransaction = &amount
+	}
+	if len(parseProblems) > 0 {
+		return dbModel, parseProblems
+	}
+	dbModel = database.NotificationPreferenceInput{
+		Email:            strings.TrimSpace(input.Email),
+		WeeklyDigest:     weeklyDigest,
+		DigestWeekday:    weekday,
+		BudgetAlerts:     budgetAlerts,
+		BillAlerts:       billAlerts,
+		LargeTransaction: largeTransaction,
+	}
+	return dbModel, nil
+}
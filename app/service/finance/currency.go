@@ -0,0 +1,290 @@
+package finance
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	"wonk/app/auth"
+	"wonk/app/templates/views"
+	"wonk/business/finance"
+)
+
+const (
+	MAX_RATE_IMPORT_BYTES = 1 << 20
+)
+
+type Currency interface {
+	Currencies() http.HandlerFunc
+	BaseCurrency() http.HandlerFunc
+	ExchangeRates() http.HandlerFunc
+	ExchangeRateById() http.HandlerFunc
+	ExchangeRateImport() http.HandlerFunc
+}
+
+type CurrencyHandler struct {
+	Logger       *slog.Logger
+	FinanceLogic finance.Finance
+}
+
+func initCurrencyHandler(l *slog.Logger, f finance.Finance) Currency {
+	return &CurrencyHandler{
+		Logger:       l,
+		FinanceLogic: f,
+	}
+}
+
+func (c *CurrencyHandler) Currencies() http.HandlerFunc {
+	funcName := "Currencies"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			c.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			c.renderCurrencyView(ctx, w, funcName, curUser.UserId, views.CurrencyPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (c *CurrencyHandler) BaseCurrency() http.HandlerFunc {
+	funcName := "BaseCurrency"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			c.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "PUT":
+			err := r.ParseForm()
+			if err != nil {
+				c.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			err = c.FinanceLogic.SetBaseCurrency(curUser.UserId, parseCurrency(r.FormValue("currency")))
+			if err != nil {
+				c.Logger.Info(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				http.Error(w, "Invalid currency", 400)
+				return
+			}
+			c.renderCurrencyView(ctx, w, funcName, curUser.UserId, views.CurrencyPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (c *CurrencyHandler) ExchangeRates() http.HandlerFunc {
+	funcName := "ExchangeRates"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			c.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				c.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := ExchangeRateInput{
+				Date:     r.FormValue("date"),
+				Currency: r.FormValue("currency"),
+				Rate:     r.FormValue("rate"),
+			}
+			rate, problems := parseExchangeRate(formData)
+			if len(problems) == 0 {
+				problems, err = c.FinanceLogic.AddExchangeRate(curUser.UserId, rate)
+				if err != nil {
+					c.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.CurrencyPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				pageData.RateForm = views.ExchangeRateFormData{
+					DateValue:     formData.Date,
+					CurrencyValue: formData.Currency,
+					RateValue:     formData.Rate,
+				}
+				if val, ok := problems["RateDate"]; ok {
+					pageData.RateForm.DateErr = &val
+				}
+				if val, ok := problems["Currency"]; ok {
+					pageData.RateForm.CurrencyErr = &val
+				}
+				if val, ok := problems["Rate"]; ok {
+					pageData.RateForm.RateErr = &val
+				}
+			}
+			c.renderCurrencyView(ctx, w, funcName, curUser.UserId, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (c *CurrencyHandler) ExchangeRateById() http.HandlerFunc {
+	funcName := "ExchangeRateById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			c.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		rateId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "DELETE":
+			// The delete is scoped to the user so other users rates can't be removed
+			err := c.FinanceLogic.DeleteExchangeRate(curUser.UserId, rateId)
+			if err != nil {
+				c.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			rowTmpl := views.TrashClearedRow("Removed")
+			err = rowTmpl.Render(ctx, w)
+			if err != nil {
+				c.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (c *CurrencyHandler) ExchangeRateImport() http.HandlerFunc {
+	funcName := "ExchangeRateImport"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			c.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			r.Body = http.MaxBytesReader(w, r.Body, MAX_RATE_IMPORT_BYTES)
+			file, _, err := r.FormFile("rates")
+			if err != nil {
+				c.Logger.Info(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				w.WriteHeader(422)
+				c.renderCurrencyView(ctx, w, funcName, curUser.UserId, views.CurrencyPageData{ImportErrs: []string{"A CSV file under 1MB is required"}})
+				return
+			}
+			defer file.Close()
+			numImported, problems, err := c.FinanceLogic.ImportExchangeRates(curUser.UserId, file)
+			if err != nil {
+				c.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			pageData := views.CurrencyPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				for key, msg := range problems {
+					pageData.ImportErrs = append(pageData.ImportErrs, key+": "+msg)
+				}
+				sort.Strings(pageData.ImportErrs)
+			} else {
+				pageData.ImportMsg = "Imported " + strconv.Itoa(numImported) + " rates"
+			}
+			c.renderCurrencyView(ctx, w, funcName, curUser.UserId, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (c *CurrencyHandler) renderCurrencyView(ctx context.Context, w http.ResponseWriter, funcName string, userId int, data views.CurrencyPageData) {
+	base, err := c.FinanceLogic.BaseCurrency(userId)
+	if err != nil {
+		c.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	rates, err := c.FinanceLogic.ExchangeRates(userId)
+	if err != nil {
+		c.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	data.BaseCurrency = base
+	data.Rates = rates
+	tmplCurrency := views.CurrencyView(data)
+	err = tmplCurrency.Render(ctx, w)
+	if err != nil {
+		c.Logger.Error(funcName, slog.String("Error", err.Error()))
+	}
+}
@@ -0,0 +1,314 @@
+package finance
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"wonk/app/auth"
+	"wonk/app/cuserr"
+	"wonk/app/templates/views"
+	"wonk/business/finance"
+)
+
+type Debt interface {
+	Debts() http.HandlerFunc
+	DebtById() http.HandlerFunc
+	DebtPayments() http.HandlerFunc
+	DebtPaymentById() http.HandlerFunc
+}
+
+type DebtHandler struct {
+	Logger       *slog.Logger
+	FinanceLogic finance.Finance
+}
+
+func initDebtHandler(l *slog.Logger, f finance.Finance) Debt {
+	return &DebtHandler{
+		Logger:       l,
+		FinanceLogic: f,
+	}
+}
+
+// Shows the debts with the payoff planner (GET) or adds a debt (POST).
+// The planner form sends extra, strategy and a rank for each debt to GET.
+func (d *DebtHandler) Debts() http.HandlerFunc {
+	funcName := "Debts"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			d.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			err := r.ParseForm()
+			if err != nil {
+				d.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			input := PayoffInput{
+				Extra:    r.FormValue("extra"),
+				Strategy: r.FormValue("strategy"),
+				RankIds:  r.Form["rank_id"],
+				Ranks:    r.Form["rank"],
+			}
+			d.renderDebtView(ctx, w, funcName, curUser.UserId, input, views.DebtPageData{})
+			return
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				d.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := DebtInput{
+				Name:       r.FormValue("name"),
+				Balance:    r.FormValue("balance"),
+				Apr:        r.FormValue("apr"),
+				MinPayment: r.FormValue("min"),
+			}
+			debt, problems := parseDebt(formData)
+			if len(problems) == 0 {
+				problems, err = d.FinanceLogic.AddDebt(curUser.UserId, debt)
+				if err != nil {
+					d.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.DebtPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				pageData.Form = views.DebtFormData{
+					NameValue:    formData.Name,
+					BalanceValue: formData.Balance,
+					AprValue:     formData.Apr,
+					MinValue:     formData.MinPayment,
+				}
+				if val, ok := problems["Name"]; ok {
+					pageData.Form.NameErr = &val
+				}
+				if val, ok := problems["Balance"]; ok {
+					pageData.Form.BalanceErr = &val
+				}
+				if val, ok := problems["Apr"]; ok {
+					pageData.Form.AprErr = &val
+				}
+				if val, ok := problems["MinPayment"]; ok {
+					pageData.Form.MinErr = &val
+				}
+			}
+			d.renderDebtView(ctx, w, funcName, curUser.UserId, PayoffInput{}, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (d *DebtHandler) DebtById() http.HandlerFunc {
+	funcName := "DebtById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			d.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		debtId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "DELETE":
+			err := d.FinanceLogic.DeleteDebt(curUser.UserId, debtId)
+			if err != nil {
+				var notFoundErr cuserr.NotFound
+				if errors.As(err, &notFoundErr) {
+					http.Error(w, "Debt not found", 404)
+					return
+				}
+				d.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			d.renderDebtView(ctx, w, funcName, curUser.UserId, PayoffInput{}, views.DebtPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Links an existing expense to the debt it paid
+func (d *DebtHandler) DebtPayments() http.HandlerFunc {
+	funcName := "DebtPayments"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			d.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		debtId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				d.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			payment, problems := parseDebtPayment(debtId, DebtPaymentInput{TransactionId: r.FormValue("transaction")})
+			if len(problems) == 0 {
+				problems, err = d.FinanceLogic.LinkDebtPayment(curUser.UserId, payment)
+				if err != nil {
+					var notFoundErr cuserr.NotFound
+					if errors.As(err, &notFoundErr) {
+						http.Error(w, "Debt not found", 404)
+						return
+					}
+					d.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.DebtPageData{}
+			if val, ok := problems["TransactionId"]; ok {
+				w.WriteHeader(422)
+				pageData.PaymentDebtId = debtId
+				pageData.PaymentErr = val
+			}
+			d.renderDebtView(ctx, w, funcName, curUser.UserId, PayoffInput{}, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Unlinks a payment, the transaction itself is kept
+func (d *DebtHandler) DebtPaymentById() http.HandlerFunc {
+	funcName := "DebtPaymentById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			d.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		paymentId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "DELETE":
+			err := d.FinanceLogic.UnlinkDebtPayment(curUser.UserId, paymentId)
+			if err != nil {
+				var notFoundErr cuserr.NotFound
+				if errors.As(err, &notFoundErr) {
+					http.Error(w, "Payment not found", 404)
+					return
+				}
+				d.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			d.renderDebtView(ctx, w, funcName, curUser.UserId, PayoffInput{}, views.DebtPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (d *DebtHandler) renderDebtView(ctx context.Context, w http.ResponseWriter, funcName string, userId int, input PayoffInput, data views.DebtPageData) {
+	payoff, problems := parsePayoff(input)
+	plan, planProblems, err := d.FinanceLogic.PlanDebts(userId, payoff, time.Now())
+	if err != nil {
+		d.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	// Plans built from input that didn't parse would be misleading
+	if len(problems) > 0 {
+		plan.Plans = nil
+	} else {
+		problems = planProblems
+	}
+	// Only the planner form on GET sends input that can have problems
+	if len(problems) > 0 {
+		w.WriteHeader(422)
+	}
+	data.Plan = *plan
+	data.Planner = views.DebtPlannerFormData{
+		ExtraValue: input.Extra,
+		Ranks:      map[string]string{},
+	}
+	for i, id := range input.RankIds {
+		if i < len(input.Ranks) {
+			data.Planner.Ranks[id] = input.Ranks[i]
+		}
+	}
+	if val, ok := problems["Extra"]; ok {
+		data.Planner.ExtraErr = &val
+	}
+	if val, ok := problems["Strategy"]; ok {
+		data.Planner.StrategyErr = &val
+	}
+	if val, ok := problems["Order"]; ok {
+		data.Planner.OrderErr = &val
+	}
+	tmplDebts := views.DebtView(data)
+	err = tmplDebts.Render(ctx, w)
+	if err != nil {
+		d.Logger.Error(funcName, slog.String("Error", err.Error()))
+	}
+}
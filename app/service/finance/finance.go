@@ -10,9 +10,19 @@ import (
 )
 
 type FinanceService struct {
-	Home        Finance
-	Transaction Transaction
-	Bucket      Bucket
+	Home                 Finance
+	Transaction          Transaction
+	Bucket               Bucket
+	Trash                Trash
+	Currency             Currency
+	Budget               Budget
+	Goal                 Goal
+	Debt                 Debt
+	NetWorth             NetWorth
+	Investment           Investment
+	Bill                 Bill
+	NotificationSettings NotificationSettings
+	Calendar             Calendar
 }
 
 type Finance interface {
@@ -23,11 +33,22 @@ type FinaceHandler struct {
 	Logger *slog.Logger
 }
 
-func InitFinanceService(l *slog.Logger, f finance.Finance) *FinanceService {
+// baseURL is where the app is served from, for urls used outside of its pages
+func InitFinanceService(l *slog.Logger, f finance.Finance, baseURL string) *FinanceService {
 	return &FinanceService{
-		Home:        initFinanceHandler(l),
-		Transaction: initTransactionHandler(l, f),
-		Bucket:      initBucketHandler(l, f),
+		Home:                 initFinanceHandler(l),
+		Transaction:          initTransactionHandler(l, f),
+		Bucket:               initBucketHandler(l, f),
+		Trash:                initTrashHandler(l, f),
+		Currency:             initCurrencyHandler(l, f),
+		Budget:               initBudgetHandler(l, f),
+		Goal:                 initGoalHandler(l, f),
+		Debt:                 initDebtHandler(l, f),
+		NetWorth:             initNetWorthHandler(l, f),
+		Investment:           initInvestmentHandler(l, f),
+		Bill:                 initBillHandler(l, f),
+		NotificationSettings: initNotificationSettingsHandler(l, f),
+		Calendar:             initCalendarHandler(l, f, baseURL),
 	}
 
 }
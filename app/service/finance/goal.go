@@ -0,0 +1,242 @@
+package finance
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"wonk/app/auth"
+	"wonk/app/cuserr"
+	"wonk/app/templates/views"
+	"wonk/business/finance"
+)
+
+type Goal interface {
+	Goals() http.HandlerFunc
+	GoalById() http.HandlerFunc
+	GoalContributions() http.HandlerFunc
+}
+
+type GoalHandler struct {
+	Logger       *slog.Logger
+	FinanceLogic finance.Finance
+}
+
+func initGoalHandler(l *slog.Logger, f finance.Finance) Goal {
+	return &GoalHandler{
+		Logger:       l,
+		FinanceLogic: f,
+	}
+}
+
+// Shows the goal cards (GET) or adds a goal (POST)
+func (g *GoalHandler) Goals() http.HandlerFunc {
+	funcName := "Goals"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			g.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			g.renderGoalView(ctx, w, funcName, curUser.UserId, views.GoalPageData{})
+			return
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				g.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := SavingsGoalInput{
+				Name:         r.FormValue("name"),
+				TargetAmount: r.FormValue("target"),
+				TargetDate:   r.FormValue("date"),
+				BucketId:     r.FormValue("bucket"),
+			}
+			goal, problems := parseSavingsGoal(formData)
+			if len(problems) == 0 {
+				problems, err = g.FinanceLogic.AddSavingsGoal(curUser.UserId, goal)
+				if err != nil {
+					g.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.GoalPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				pageData.Form = views.GoalFormData{
+					NameValue:   formData.Name,
+					TargetValue: formData.TargetAmount,
+					DateValue:   formData.TargetDate,
+					BucketValue: formData.BucketId,
+				}
+				if val, ok := problems["Name"]; ok {
+					pageData.Form.NameErr = &val
+				}
+				if val, ok := problems["TargetAmount"]; ok {
+					pageData.Form.TargetErr = &val
+				}
+				if val, ok := problems["TargetDate"]; ok {
+					pageData.Form.DateErr = &val
+				}
+				if val, ok := problems["BucketId"]; ok {
+					pageData.Form.BucketErr = &val
+				}
+			}
+			g.renderGoalView(ctx, w, funcName, curUser.UserId, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (g *GoalHandler) GoalById() http.HandlerFunc {
+	funcName := "GoalById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			g.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		goalId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "DELETE":
+			err := g.FinanceLogic.DeleteSavingsGoal(curUser.UserId, goalId)
+			if err != nil {
+				var notFoundErr cuserr.NotFound
+				if errors.As(err, &notFoundErr) {
+					http.Error(w, "Goal not found", 404)
+					return
+				}
+				g.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			g.renderGoalView(ctx, w, funcName, curUser.UserId, views.GoalPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Adds a contribution or, with a negative amount, a withdrawal to a goal
+func (g *GoalHandler) GoalContributions() http.HandlerFunc {
+	funcName := "GoalContributions"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			g.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		goalId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				g.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := GoalContributionInput{
+				Amount: r.FormValue("amount"),
+				Date:   r.FormValue("date"),
+			}
+			contribution, problems := parseGoalContribution(goalId, formData)
+			if len(problems) == 0 {
+				problems, err = g.FinanceLogic.AddGoalContribution(curUser.UserId, contribution)
+				if err != nil {
+					var notFoundErr cuserr.NotFound
+					if errors.As(err, &notFoundErr) {
+						http.Error(w, "Goal not found", 404)
+						return
+					}
+					g.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.GoalPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				pageData.ContributionGoalId = goalId
+				for _, key := range []string{"GoalId", "ContributedOn", "Amount"} {
+					if val, ok := problems[key]; ok {
+						pageData.ContributionErr = val
+					}
+				}
+			}
+			g.renderGoalView(ctx, w, funcName, curUser.UserId, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (g *GoalHandler) renderGoalView(ctx context.Context, w http.ResponseWriter, funcName string, userId int, data views.GoalPageData) {
+	goals, err := g.FinanceLogic.SavingsGoals(userId, time.Now())
+	if err != nil {
+		g.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	buckets, err := g.FinanceLogic.UserBuckets(userId)
+	if err != nil {
+		g.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	data.Goals = goals
+	data.Buckets = buckets
+	data.Today = time.Now().Format("2006-01-02")
+	tmplGoals := views.GoalView(data)
+	err = tmplGoals.Render(ctx, w)
+	if err != nil {
+		g.Logger.Error(funcName, slog.String("Error", err.Error()))
+	}
+}
@@ -0,0 +1,547 @@
+package finance
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	"wonk/app/auth"
+	"wonk/app/cuserr"
+	"wonk/app/templates/views"
+	"wonk/business/finance"
+)
+
+const (
+	MAX_PRICE_IMPORT_BYTES = 1 << 20
+)
+
+type Investment interface {
+	Investments() http.HandlerFunc
+	Accounts() http.HandlerFunc
+	AccountById() http.HandlerFunc
+	Trades() http.HandlerFunc
+	TradeById() http.HandlerFunc
+	Securities() http.HandlerFunc
+	Prices() http.HandlerFunc
+	PriceById() http.HandlerFunc
+	PriceImport() http.HandlerFunc
+}
+
+type InvestmentHandler struct {
+	Logger       *slog.Logger
+	FinanceLogic finance.Finance
+}
+
+func initInvestmentHandler(l *slog.Logger, f finance.Finance) Investment {
+	return &InvestmentHandler{
+		Logger:       l,
+		FinanceLogic: f,
+	}
+}
+
+func (i *InvestmentHandler) Investments() http.HandlerFunc {
+	funcName := "Investments"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			i.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			i.renderInvestmentView(ctx, w, funcName, curUser.UserId, views.InvestmentPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (i *InvestmentHandler) Accounts() http.HandlerFunc {
+	funcName := "InvestmentAccounts"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			i.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				i.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := InvestmentAccountInput{
+				Name:     r.FormValue("name"),
+				Currency: r.FormValue("currency"),
+			}
+			problems, err := i.FinanceLogic.AddInvestmentAccount(curUser.UserId, parseInvestmentAccount(formData))
+			if err != nil {
+				i.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			pageData := views.InvestmentPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				pageData.AccountForm = views.InvestmentAccountFormData{
+					NameValue:     formData.Name,
+					CurrencyValue: formData.Currency,
+				}
+				if val, ok := problems["Name"]; ok {
+					pageData.AccountForm.NameErr = &val
+				}
+				if val, ok := problems["Currency"]; ok {
+					pageData.AccountForm.CurrencyErr = &val
+				}
+			}
+			i.renderInvestmentView(ctx, w, funcName, curUser.UserId, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (i *InvestmentHandler) AccountById() http.HandlerFunc {
+	funcName := "InvestmentAccountById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			i.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		accountId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "DELETE":
+			err := i.FinanceLogic.DeleteInvestmentAccount(curUser.UserId, accountId)
+			if err != nil {
+				var notFoundErr cuserr.NotFound
+				if errors.As(err, &notFoundErr) {
+					http.Error(w, "Account not found", 404)
+					return
+				}
+				i.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			i.renderInvestmentView(ctx, w, funcName, curUser.UserId, views.InvestmentPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Records a buy, sell or dividend in the account from the path
+func (i *InvestmentHandler) Trades() http.HandlerFunc {
+	funcName := "Trades"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			i.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		accountId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				i.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := TradeInput{
+				Symbol:   r.FormValue("symbol"),
+				Kind:     r.FormValue("kind"),
+				Quantity: r.FormValue("quantity"),
+				Price:    r.FormValue("price"),
+				Fees:     r.FormValue("fees"),
+				Date:     r.FormValue("date"),
+			}
+			trade, problems := parseTrade(accountId, formData)
+			if len(problems) == 0 {
+				problems, err = i.FinanceLogic.AddInvestmentTrade(curUser.UserId, trade)
+				if err != nil {
+					var notFoundErr cuserr.NotFound
+					if errors.As(err, &notFoundErr) {
+						http.Error(w, "Account not found", 404)
+						return
+					}
+					i.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.InvestmentPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				pageData.TradeForm = views.TradeFormData{
+					AccountId:     accountId,
+					SymbolValue:   formData.Symbol,
+					KindValue:     formData.Kind,
+					QuantityValue: formData.Quantity,
+					PriceValue:    formData.Price,
+					FeesValue:     formData.Fees,
+					DateValue:     formData.Date,
+				}
+				if val, ok := problems["Symbol"]; ok {
+					pageData.TradeForm.SymbolErr = &val
+				}
+				if val, ok := problems["Kind"]; ok {
+					pageData.TradeForm.KindErr = &val
+				}
+				if val, ok := problems["Quantity"]; ok {
+					pageData.TradeForm.QuantityErr = &val
+				}
+				if val, ok := problems["Price"]; ok {
+					pageData.TradeForm.PriceErr = &val
+				}
+				if val, ok := problems["Fees"]; ok {
+					pageData.TradeForm.FeesErr = &val
+				}
+				if val, ok := problems["TradeDate"]; ok {
+					pageData.TradeForm.DateErr = &val
+				}
+			}
+			i.renderInvestmentView(ctx, w, funcName, curUser.UserId, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (i *InvestmentHandler) TradeById() http.HandlerFunc {
+	funcName := "TradeById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			i.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		tradeId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "DELETE":
+			problems, err := i.FinanceLogic.DeleteInvestmentTrade(curUser.UserId, tradeId)
+			if err != nil {
+				var notFoundErr cuserr.NotFound
+				if errors.As(err, &notFoundErr) {
+					http.Error(w, "Trade not found", 404)
+					return
+				}
+				i.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			pageData := views.InvestmentPageData{}
+			if val, ok := problems["Trade"]; ok {
+				w.WriteHeader(422)
+				pageData.TradeDeleteErr = &val
+			}
+			i.renderInvestmentView(ctx, w, funcName, curUser.UserId, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Sets the asset class a symbol counts towards in the allocation
+func (i *InvestmentHandler) Securities() http.HandlerFunc {
+	funcName := "Securities"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			i.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "PUT":
+			err := r.ParseForm()
+			if err != nil {
+				i.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := SecurityInput{
+				Symbol:     r.FormValue("symbol"),
+				AssetClass: r.FormValue("assetClass"),
+			}
+			problems, err := i.FinanceLogic.SetAssetClass(curUser.UserId, parseSecurity(formData))
+			if err != nil {
+				i.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			if len(problems) > 0 {
+				// The options come from a dropdown so this is only hit by a hand made request
+				i.Logger.Info(funcName, slog.String("HttpMethod", "PUT"), slog.Any("Problems", problems))
+				http.Error(w, "Invalid asset class", 400)
+				return
+			}
+			i.renderInvestmentView(ctx, w, funcName, curUser.UserId, views.InvestmentPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Records a symbol's price on a date, replacing any price of it on that date
+func (i *InvestmentHandler) Prices() http.HandlerFunc {
+	funcName := "SecurityPrices"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			i.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				i.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := SecurityPriceInput{
+				Symbol: r.FormValue("symbol"),
+				Price:  r.FormValue("price"),
+				Date:   r.FormValue("date"),
+			}
+			price, problems := parseSecurityPrice(formData)
+			if len(problems) == 0 {
+				problems, err = i.FinanceLogic.AddSecurityPrice(curUser.UserId, price)
+				if err != nil {
+					i.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.InvestmentPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				pageData.PriceForm = views.PriceFormData{
+					SymbolValue: formData.Symbol,
+					PriceValue:  formData.Price,
+					DateValue:   formData.Date,
+				}
+				if val, ok := problems["Symbol"]; ok {
+					pageData.PriceForm.SymbolErr = &val
+				}
+				if val, ok := problems["Price"]; ok {
+					pageData.PriceForm.PriceErr = &val
+				}
+				if val, ok := problems["PriceDate"]; ok {
+					pageData.PriceForm.DateErr = &val
+				}
+			}
+			i.renderInvestmentView(ctx, w, funcName, curUser.UserId, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (i *InvestmentHandler) PriceById() http.HandlerFunc {
+	funcName := "SecurityPriceById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			i.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		priceId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "DELETE":
+			err := i.FinanceLogic.DeleteSecurityPrice(curUser.UserId, priceId)
+			if err != nil {
+				var notFoundErr cuserr.NotFound
+				if errors.As(err, &notFoundErr) {
+					http.Error(w, "Price not found", 404)
+					return
+				}
+				i.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			i.renderInvestmentView(ctx, w, funcName, curUser.UserId, views.InvestmentPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (i *InvestmentHandler) PriceImport() http.HandlerFunc {
+	funcName := "SecurityPriceImport"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			i.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			r.Body = http.MaxBytesReader(w, r.Body, MAX_PRICE_IMPORT_BYTES)
+			file, _, err := r.FormFile("prices")
+			if err != nil {
+				i.Logger.Info(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				w.WriteHeader(422)
+				i.renderInvestmentView(ctx, w, funcName, curUser.UserId, views.InvestmentPageData{ImportErrs: []string{"A CSV file under 1MB is required"}})
+				return
+			}
+			defer file.Close()
+			numImported, problems, err := i.FinanceLogic.ImportSecurityPrices(curUser.UserId, file)
+			if err != nil {
+				i.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			pageData := views.InvestmentPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				for key, msg := range problems {
+					pageData.ImportErrs = append(pageData.ImportErrs, key+": "+msg)
+				}
+				sort.Strings(pageData.ImportErrs)
+			} else {
+				pageData.ImportMsg = "Imported " + strconv.Itoa(numImported) + " prices"
+			}
+			i.renderInvestmentView(ctx, w, funcName, curUser.UserId, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (i *InvestmentHandler) renderInvestmentView(ctx context.Context, w http.ResponseWriter, funcName string, userId int, data views.InvestmentPageData) {
+	now := time.Now()
+	portfolio, err := i.FinanceLogic.Portfolio(userId, now)
+	if err != nil {
+		i.Logger.Error(funcName, slog.String("Error", err.Error()))
+		http.Error(w, "Internal Error", 500)
+		return
+	}
+	data.Portfolio = portfolio
+	data.Today = now.Format("2006-01-02")
+	tmplInvestment := views.InvestmentView(data)
+	err = tmplInvestment.Render(ctx, w)
+	if err != nil {
+		i.Logger.Error(funcName, slog.String("Error", err.Error()))
+	}
+}
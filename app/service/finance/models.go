@@ -8,6 +8,7 @@ type TransactionNewInput struct {
 	IsExpense string
 	BucketId  string
 	UserId    int
+	Currency  string
 }
 
 type TransactionEditInput struct {
@@ -17,6 +18,7 @@ type TransactionEditInput struct {
 	Year          string
 	Price         string
 	BucketId      string
+	Currency      string
 }
 
 type TransactionFilter struct {
@@ -26,3 +28,119 @@ type TransactionFilter struct {
 	Year     string
 	BucketId string
 }
+
+type ExchangeRateInput struct {
+	Date     string
+	Currency string
+	Rate     string
+}
+
+type RangeInput struct {
+	Preset string
+	Start  string
+	End    string
+}
+
+type BudgetInput struct {
+	BucketId string
+	Amount   string
+}
+
+type RecurringItemInput struct {
+	Name      string
+	Price     string
+	IsExpense string
+	Currency  string
+	BucketId  string
+	Day       string
+}
+
+type SavingsGoalInput struct {
+	Name         string
+	TargetAmount string
+	TargetDate   string
+	BucketId     string
+}
+
+type GoalContributionInput struct {
+	Amount string
+	Date   string
+}
+
+type ForecastInput struct {
+	Months          string
+	Window          string
+	StartingBalance string
+}
+
+type DebtInput struct {
+	Name       string
+	Balance    string
+	Apr        string
+	MinPayment string
+}
+
+type PayoffInput struct {
+	Extra    string
+	Strategy string
+	RankIds  []string // Debt ids, paired with Ranks by position
+	Ranks    []string
+}
+
+type DebtPaymentInput struct {
+	TransactionId string
+}
+
+type NetWorthAccountInput struct {
+	Name     string
+	Type     string // "kind|category" from one dropdown
+	Currency string
+}
+
+type SnapshotInput struct {
+	AccountId string
+	Balance   string
+	Date      string
+}
+
+type InvestmentAccountInput struct {
+	Name     string
+	Currency string
+}
+
+type TradeInput struct {
+	Symbol   string
+	Kind     string
+	Quantity string // Blank for a dividend
+	Price    string // Total paid for a dividend
+	Fees     string // Blank means none
+	Date     string
+}
+
+type SecurityInput struct {
+	Symbol     string
+	AssetClass string
+}
+
+type SecurityPriceInput struct {
+	Symbol string
+	Price  string
+	Date   string
+}
+
+type BillInput struct {
+	Name     string
+	Amount   string
+	Currency string
+	BucketId string
+	Day      string
+}
+
+type NotificationPreferenceInput struct {
+	Email            string
+	WeeklyDigest     string
+	DigestWeekday    string
+	BudgetAlerts     string
+	BillAlerts       string
+	LargeTransaction string // Empty turns the alert off
+}
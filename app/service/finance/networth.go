@@ -0,0 +1,394 @@
+package finance
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	"wonk/app/auth"
+	"wonk/app/cuserr"
+	"wonk/app/templates/views"
+	"wonk/business/finance"
+)
+
+const (
+	MAX_SNAPSHOT_IMPORT_BYTES = 1 << 20
+)
+
+type NetWorth interface {
+	NetWorth() http.HandlerFunc
+	Accounts() http.HandlerFunc
+	AccountById() http.HandlerFunc
+	Snapshots() http.HandlerFunc
+	SnapshotById() http.HandlerFunc
+	SnapshotImport() http.HandlerFunc
+}
+
+type NetWorthHandler struct {
+	Logger       *slog.Logger
+	FinanceLogic finance.Finance
+}
+
+func initNetWorthHandler(l *slog.Logger, f finance.Finance) NetWorth {
+	return &NetWorthHandler{
+		Logger:       l,
+		FinanceLogic: f,
+	}
+}
+
+// Shows the net worth history for the range picked in the form, trailing 12 months by default
+func (n *NetWorthHandler) NetWorth() http.HandlerFunc {
+	funcName := "NetWorth"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			n.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			err := r.ParseForm()
+			if err != nil {
+				n.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			input := RangeInput{
+				Preset: r.FormValue("range"),
+				Start:  r.FormValue("start"),
+				End:    r.FormValue("end"),
+			}
+			n.renderNetWorthView(ctx, w, funcName, curUser.UserId, input, views.NetWorthPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (n *NetWorthHandler) Accounts() http.HandlerFunc {
+	funcName := "NetWorthAccounts"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			n.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				n.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := NetWorthAccountInput{
+				Name:     r.FormValue("name"),
+				Type:     r.FormValue("type"),
+				Currency: r.FormValue("currency"),
+			}
+			account, problems := parseNetWorthAccount(formData)
+			if len(problems) == 0 {
+				problems, err = n.FinanceLogic.AddNetWorthAccount(curUser.UserId, account)
+				if err != nil {
+					n.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.NetWorthPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				pageData.AccountForm = views.AccountFormData{
+					NameValue:     formData.Name,
+					TypeValue:     formData.Type,
+					CurrencyValue: formData.Currency,
+				}
+				if val, ok := problems["Name"]; ok {
+					pageData.AccountForm.NameErr = &val
+				}
+				for _, key := range []string{"Kind", "Category"} {
+					if val, ok := problems[key]; ok {
+						pageData.AccountForm.TypeErr = &val
+					}
+				}
+				if val, ok := problems["Currency"]; ok {
+					pageData.AccountForm.CurrencyErr = &val
+				}
+			}
+			n.renderNetWorthView(ctx, w, funcName, curUser.UserId, RangeInput{}, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (n *NetWorthHandler) AccountById() http.HandlerFunc {
+	funcName := "NetWorthAccountById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			n.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		accountId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "DELETE":
+			err := n.FinanceLogic.DeleteNetWorthAccount(curUser.UserId, accountId)
+			if err != nil {
+				var notFoundErr cuserr.NotFound
+				if errors.As(err, &notFoundErr) {
+					http.Error(w, "Account not found", 404)
+					return
+				}
+				n.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			n.renderNetWorthView(ctx, w, funcName, curUser.UserId, RangeInput{}, views.NetWorthPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Records an account's balance on a date, replacing any snapshot of it on that date
+func (n *NetWorthHandler) Snapshots() http.HandlerFunc {
+	funcName := "Snapshots"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			n.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			err := r.ParseForm()
+			if err != nil {
+				n.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := SnapshotInput{
+				AccountId: r.FormValue("account"),
+				Balance:   r.FormValue("balance"),
+				Date:      r.FormValue("date"),
+			}
+			snapshot, problems := parseSnapshot(formData)
+			if len(problems) == 0 {
+				problems, err = n.FinanceLogic.AddSnapshot(curUser.UserId, snapshot)
+				if err != nil {
+					n.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.NetWorthPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				pageData.SnapshotForm = views.SnapshotFormData{
+					AccountValue: formData.AccountId,
+					BalanceValue: formData.Balance,
+					DateValue:    formData.Date,
+				}
+				if val, ok := problems["AccountId"]; ok {
+					pageData.SnapshotForm.AccountErr = &val
+				}
+				if val, ok := problems["Balance"]; ok {
+					pageData.SnapshotForm.BalanceErr = &val
+				}
+				if val, ok := problems["SnapshotDate"]; ok {
+					pageData.SnapshotForm.DateErr = &val
+				}
+			}
+			n.renderNetWorthView(ctx, w, funcName, curUser.UserId, RangeInput{}, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (n *NetWorthHandler) SnapshotById() http.HandlerFunc {
+	funcName := "SnapshotById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			n.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		snapshotId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request: Id Isn't a int", 400)
+			return
+		}
+		switch r.Method {
+		case "DELETE":
+			err := n.FinanceLogic.DeleteSnapshot(curUser.UserId, snapshotId)
+			if err != nil {
+				var notFoundErr cuserr.NotFound
+				if errors.As(err, &notFoundErr) {
+					http.Error(w, "Snapshot not found", 404)
+					return
+				}
+				n.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			n.renderNetWorthView(ctx, w, funcName, curUser.UserId, RangeInput{}, views.NetWorthPageData{})
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (n *NetWorthHandler) SnapshotImport() http.HandlerFunc {
+	funcName := "SnapshotImport"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			n.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			r.Body = http.MaxBytesReader(w, r.Body, MAX_SNAPSHOT_IMPORT_BYTES)
+			file, _, err := r.FormFile("snapshots")
+			if err != nil {
+				n.Logger.Info(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				w.WriteHeader(422)
+				n.renderNetWorthView(ctx, w, funcName, curUser.UserId, RangeInput{}, views.NetWorthPageData{ImportErrs: []string{"A CSV file under 1MB is required"}})
+				return
+			}
+			defer file.Close()
+			numImported, problems, err := n.FinanceLogic.ImportSnapshots(curUser.UserId, file)
+			if err != nil {
+				n.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			pageData := views.NetWorthPageData{}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				for key, msg := range problems {
+					pageData.ImportErrs = append(pageData.ImportErrs, key+": "+msg)
+				}
+				sort.Strings(pageData.ImportErrs)
+			} else {
+				pageData.ImportMsg = "Imported " + strconv.Itoa(numImported) + " snapshots"
+			}
+			n.renderNetWorthView(ctx, w, funcName, curUser.UserId, RangeInput{}, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Range problems are shown above the history, the caller sets the status for its own form problems
+func (n *NetWorthHandler) renderNetWorthView(ctx context.Context, w http.ResponseWriter, funcName string, userId int, input RangeInput, data views.NetWorthPageData) {
+	data.Range = views.RangeFormData{
+		Preset:     input.Preset,
+		StartValue: input.Start,
+		EndValue:   input.End,
+	}
+	period, problems := parsePeriod(input, time.Now())
+	if len(problems) == 0 {
+		netWorth, periodProblems, err := n.FinanceLogic.NetWorthHistory(userId, period)
+		if err != nil {
+			n.Logger.Error(funcName, slog.String("Error", err.Error()))
+			http.Error(w, "Internal Error", 500)
+			return
+		}
+		data.NetWorth = netWorth
+		problems = periodProblems
+	}
+	if len(problems) > 0 {
+		w.WriteHeader(422)
+		if val, ok := problems["Range"]; ok {
+			data.Range.RangeErr = &val
+		}
+		if val, ok := problems["Start"]; ok {
+			data.Range.StartErr = &val
+		}
+		if val, ok := problems["End"]; ok {
+			data.Range.EndErr = &val
+		}
+	}
+	data.Today = time.Now().Format("2006-01-02")
+	tmplNetWorth := views.NetWorthView(data)
+	err := tmplNetWorth.Render(ctx, w)
+	if err != nil {
+		n.Logger.Error(funcName, slog.String("Error", err.Error()))
+	}
+}
@@ -0,0 +1,135 @@
+package finance
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"wonk/app/auth"
+	"wonk/app/templates/views"
+	"wonk/business/finance"
+	database "wonk/storage"
+)
+
+type NotificationSettings interface {
+	NotificationSettings() http.HandlerFunc
+}
+
+type NotificationSettingsHandler struct {
+	Logger       *slog.Logger
+	FinanceLogic finance.Finance
+}
+
+func initNotificationSettingsHandler(l *slog.Logger, f finance.Finance) NotificationSettings {
+	return &NotificationSettingsHandler{
+		Logger:       l,
+		FinanceLogic: f,
+	}
+}
+
+func (n *NotificationSettingsHandler) NotificationSettings() http.HandlerFunc {
+	funcName := "NotificationSettings"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			n.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			pref, err := n.FinanceLogic.NotificationPreferences(curUser.UserId)
+			if err != nil {
+				n.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			n.renderNotificationSettingsView(ctx, w, funcName, views.NotificationSettingsData{Form: notificationFormData(*pref)})
+			return
+		case "PUT":
+			err := r.ParseForm()
+			if err != nil {
+				n.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			formData := NotificationPreferenceInput{
+				Email:            r.FormValue("email"),
+				WeeklyDigest:     r.FormValue("weeklyDigest"),
+				DigestWeekday:    r.FormValue("digestWeekday"),
+				BudgetAlerts:     r.FormValue("budgetAlerts"),
+				BillAlerts:       r.FormValue("billAlerts"),
+				LargeTransaction: r.FormValue("largeTransaction"),
+			}
+			pref, problems := parseNotificationPreference(formData)
+			if len(problems) == 0 {
+				problems, err = n.FinanceLogic.SetNotificationPreferences(curUser.UserId, pref)
+				if err != nil {
+					n.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			pageData := views.NotificationSettingsData{
+				Form: views.NotificationFormData{
+					EmailValue:            formData.Email,
+					WeeklyDigest:          formData.WeeklyDigest == "on",
+					DigestWeekdayValue:    formData.DigestWeekday,
+					BudgetAlerts:          formData.BudgetAlerts == "on",
+					BillAlerts:            formData.BillAlerts == "on",
+					LargeTransactionValue: formData.LargeTransaction,
+				},
+			}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				if val, ok := problems["Email"]; ok {
+					pageData.Form.EmailErr = &val
+				}
+				if val, ok := problems["DigestWeekday"]; ok {
+					pageData.Form.DigestWeekdayErr = &val
+				}
+				if val, ok := problems["LargeTransaction"]; ok {
+					pageData.Form.LargeTransactionErr = &val
+				}
+			} else {
+				pageData.SavedMsg = "Saved"
+			}
+			n.renderNotificationSettingsView(ctx, w, funcName, pageData)
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func notificationFormData(pref database.NotificationPreference) views.NotificationFormData {
+	form := views.NotificationFormData{
+		EmailValue:         pref.Email,
+		WeeklyDigest:       pref.WeeklyDigest,
+		DigestWeekdayValue: strconv.Itoa(pref.DigestWeekday),
+		BudgetAlerts:       pref.BudgetAlerts,
+		BillAlerts:         pref.BillAlerts,
+	}
+	if pref.LargeTransaction != nil {
+		form.LargeTransactionValue = strconv.FormatFloat(*pref.LargeTransaction, 'f', -1, 64)
+	}
+	return form
+}
+
+func (n *NotificationSettingsHandler) renderNotificationSettingsView(ctx context.Context, w http.ResponseWriter, funcName string, data views.NotificationSettingsData) {
+	tmplSettings := views.NotificationSettingsView(data)
+	err := tmplSettings.Render(ctx, w)
+	if err != nil {
+		n.Logger.Error(funcName, slog.String("Error", err.Error()))
+	}
+}
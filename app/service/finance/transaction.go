@@ -2,11 +2,13 @@ package finance
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 	"wonk/app/auth"
+	"wonk/app/cuserr"
 	"wonk/app/templates/views"
 	"wonk/business/finance"
 )
@@ -15,9 +17,13 @@ type Transaction interface {
 	Transaction() http.HandlerFunc
 	TransactionMonth() http.HandlerFunc
 	TransactionMonthForm() http.HandlerFunc
+	TransactionRange() http.HandlerFunc
+	Forecast() http.HandlerFunc
 	Transactions() http.HandlerFunc
 	TransactionsEdit() http.HandlerFunc
 	TransactionsById() http.HandlerFunc
+	TransactionUndo() http.HandlerFunc
+	TransactionHistory() http.HandlerFunc
 }
 
 type TransactionHandler struct {
@@ -79,10 +85,11 @@ func (t *TransactionHandler) Transaction() http.HandlerFunc {
 				IsExpense: r.FormValue("isExpense"),
 				UserId:    curUser.UserId,
 				BucketId:  r.FormValue("bucket"),
+				Currency:  r.FormValue("currency"),
 			}
 			dbTranaction, problems := parseNewTransaction(formData)
 			if len(problems) == 0 {
-				problems, err = t.FinanceLogic.SubmitNewTransaction(dbTranaction)
+				problems, err = t.FinanceLogic.SubmitNewTransaction(ctx, dbTranaction)
 				if err != nil {
 					t.Logger.Error(funcName, slog.String("HttpMethod", "POST"), slog.String("error", err.Error()))
 					http.Error(w, "Internal Error", 500)
@@ -93,11 +100,12 @@ func (t *TransactionHandler) Transaction() http.HandlerFunc {
 				// If there is a problem return form with errs
 				w.WriteHeader(422)
 				formData := views.TransactionFormData{
-					NameValue:   formData.Name,
-					MonthValue:  formData.Month,
-					YearValue:   formData.Year,
-					PriceValue:  formData.Price,
-					BucketValue: formData.BucketId,
+					NameValue:     formData.Name,
+					MonthValue:    formData.Month,
+					YearValue:     formData.Year,
+					PriceValue:    formData.Price,
+					BucketValue:   formData.BucketId,
+					CurrencyValue: formData.Currency,
 				}
 				if val, ok := problems["Name"]; ok {
 					formData.NameErr = &val
@@ -114,6 +122,9 @@ func (t *TransactionHandler) Transaction() http.HandlerFunc {
 				if val, ok := problems["BucketId"]; ok {
 					formData.BucketErr = &val
 				}
+				if val, ok := problems["Currency"]; ok {
+					formData.CurrencyErr = &val
+				}
 				tmplFinanceDiv := views.TransactionForm(buckets, formData)
 				err = tmplFinanceDiv.Render(ctx, w)
 				if err != nil {
@@ -242,6 +253,152 @@ func (t *TransactionHandler) TransactionMonthForm() http.HandlerFunc {
 	}
 }
 
+// Renders the range summary page, an htmx request from the form only swaps the result
+func (t *TransactionHandler) TransactionRange() http.HandlerFunc {
+	funcName := "TransactionRange"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			t.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			err := r.ParseForm()
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			input := RangeInput{
+				Preset: r.FormValue("range"),
+				Start:  r.FormValue("start"),
+				End:    r.FormValue("end"),
+			}
+			formData := views.RangeFormData{
+				Preset:     input.Preset,
+				StartValue: input.Start,
+				EndValue:   input.End,
+			}
+			var summary *finance.RangeSummary
+			period, problems := parsePeriod(input, time.Now())
+			if len(problems) == 0 {
+				summary, problems, err = t.FinanceLogic.RangeSummary(curUser.UserId, period)
+				if err != nil {
+					t.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				if val, ok := problems["Range"]; ok {
+					formData.RangeErr = &val
+				}
+				if val, ok := problems["Start"]; ok {
+					formData.StartErr = &val
+				}
+				if val, ok := problems["End"]; ok {
+					formData.EndErr = &val
+				}
+			}
+			tmplFinanceDiv := views.RangeSummaryPage(formData, summary)
+			if r.FormValue("result") == "true" {
+				tmplFinanceDiv = views.RangeResult(formData, summary)
+			}
+			err = tmplFinanceDiv.Render(ctx, w)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.String("Error", err.Error()), slog.String("DevNote", "templ"))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (t *TransactionHandler) Forecast() http.HandlerFunc {
+	funcName := "Forecast"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			t.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			err := r.ParseForm()
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error: Parsing Form", 500)
+				return
+			}
+			input := ForecastInput{
+				Months:          r.FormValue("months"),
+				Window:          r.FormValue("window"),
+				StartingBalance: r.FormValue("balance"),
+			}
+			var forecast *finance.Forecast
+			forecastInput, problems := parseForecast(input)
+			if len(problems) == 0 {
+				forecast, problems, err = t.FinanceLogic.CashFlowForecast(curUser.UserId, forecastInput, time.Now())
+				if err != nil {
+					t.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.String("Error", err.Error()))
+					http.Error(w, "Internal Error", 500)
+					return
+				}
+			}
+			formData := views.ForecastFormData{
+				MonthsValue:  strconv.Itoa(forecastInput.Months),
+				WindowValue:  strconv.Itoa(forecastInput.Window),
+				BalanceValue: input.StartingBalance,
+			}
+			if len(problems) > 0 {
+				w.WriteHeader(422)
+				formData.MonthsValue = input.Months
+				formData.WindowValue = input.Window
+				if val, ok := problems["Months"]; ok {
+					formData.MonthsErr = &val
+				}
+				if val, ok := problems["Window"]; ok {
+					formData.WindowErr = &val
+				}
+				if val, ok := problems["StartingBalance"]; ok {
+					formData.BalanceErr = &val
+				}
+			}
+			tmplForecast := views.ForecastView(formData, forecast)
+			err = tmplForecast.Render(ctx, w)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.String("Error", err.Error()), slog.String("DevNote", "templ"))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
 func (t *TransactionHandler) Transactions() http.HandlerFunc {
 	funcName := "Transactions"
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -408,7 +565,13 @@ func (t *TransactionHandler) TransactionsById() http.HandlerFunc {
 		}
 		switch r.Method {
 		case "GET":
-			tmplFinanceDiv := views.GetTransactionRow(*transaction)
+			converted, err := t.FinanceLogic.ConvertTransaction(curUser.UserId, *transaction)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("Error", err.Error()))
+				w.WriteHeader(500)
+				return
+			}
+			tmplFinanceDiv := views.GetTransactionRow(*converted)
 			err = tmplFinanceDiv.Render(ctx, w)
 			if err != nil {
 				t.Logger.Error(funcName, slog.String("Error", err.Error()))
@@ -428,13 +591,14 @@ func (t *TransactionHandler) TransactionsById() http.HandlerFunc {
 				Year:          r.FormValue("year"),
 				Price:         r.FormValue("price"),
 				BucketId:      r.FormValue("bucketId"),
+				Currency:      r.FormValue("currency"),
 			}
 			validTransaction, problems := parseEditTransaction(formData)
 			if len(problems) > 0 {
 				http.Error(w, "Invalid inputs", 400)
 				return
 			}
-			err = t.FinanceLogic.UpdateTransaction(validTransaction)
+			err = t.FinanceLogic.UpdateTransaction(ctx, validTransaction)
 			if err != nil {
 				t.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
 				http.Error(w, "Internal Error", 500)
@@ -446,20 +610,26 @@ func (t *TransactionHandler) TransactionsById() http.HandlerFunc {
 				w.WriteHeader(500)
 				return
 			}
-			tmplFinanceDiv := views.GetTransactionRow(*transaction)
+			converted, err := t.FinanceLogic.ConvertTransaction(curUser.UserId, *transaction)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("Error", err.Error()))
+				w.WriteHeader(500)
+				return
+			}
+			tmplFinanceDiv := views.GetTransactionRow(*converted)
 			err = tmplFinanceDiv.Render(ctx, w)
 			if err != nil {
 				t.Logger.Error(funcName, slog.String("Error", err.Error()))
 			}
 			return
 		case "DELETE":
-			err := t.FinanceLogic.DeleteTransaction(transaction.Id)
+			err := t.FinanceLogic.DeleteTransaction(ctx, transaction.Id)
 			if err != nil {
 				t.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
 				http.Error(w, "Internal Error", 500)
 				return
 			}
-			rowtTmpl := views.GetTransactionDeletedRow()
+			rowtTmpl := views.GetTransactionDeletedRow(transactionId)
 			err = rowtTmpl.Render(ctx, w)
 			if err != nil {
 				t.Logger.Error(funcName, slog.String("Error", err.Error()))
@@ -470,3 +640,121 @@ func (t *TransactionHandler) TransactionsById() http.HandlerFunc {
 		}
 	}
 }
+
+func (t *TransactionHandler) TransactionUndo() http.HandlerFunc {
+	funcName := "TransactionUndo"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			t.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "PUT":
+			transactionId := r.PathValue("id")
+			transaction, err := t.FinanceLogic.GetDeletedTransaction(transactionId)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				w.WriteHeader(500)
+				return
+			}
+			if curUser.UserId != transaction.UserId {
+				w.WriteHeader(403)
+				return
+			}
+			err = t.FinanceLogic.UndoDeleteTransaction(ctx, transaction.Id)
+			if err != nil {
+				if errors.As(err, &cuserr.Expired{}) {
+					rowTmpl := views.UndoExpiredRow()
+					err = rowTmpl.Render(ctx, w)
+					if err != nil {
+						t.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+					}
+					return
+				}
+				t.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			restored, err := t.FinanceLogic.GetTransaction(transactionId)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				w.WriteHeader(500)
+				return
+			}
+			converted, err := t.FinanceLogic.ConvertTransaction(curUser.UserId, *restored)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				w.WriteHeader(500)
+				return
+			}
+			rowTmpl := views.GetTransactionRow(*converted)
+			err = rowTmpl.Render(ctx, w)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+func (t *TransactionHandler) TransactionHistory() http.HandlerFunc {
+	funcName := "TransactionHistory"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			t.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			transactionId := r.PathValue("id")
+			transaction, err := t.FinanceLogic.GetTransaction(transactionId)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("httpMethod", "GET"), slog.String("Error", err.Error()))
+				w.WriteHeader(500)
+				return
+			}
+			if curUser.UserId != transaction.UserId {
+				w.WriteHeader(403)
+				return
+			}
+			history, err := t.FinanceLogic.TransactionHistory(curUser.UserId, transaction.Id)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("httpMethod", "GET"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			tmplHistory := views.TransactionHistory(*transaction, history)
+			err = tmplHistory.Render(ctx, w)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("httpMethod", "GET"), slog.String("Error", err.Error()))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package finance
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+	"wonk/app/auth"
+	"wonk/app/templates/views"
+	"wonk/business/finance"
+)
+
+type Trash interface {
+	Trash() http.HandlerFunc
+	TrashTransactionById() http.HandlerFunc
+	TrashBucketById() http.HandlerFunc
+}
+
+type TrashHandler struct {
+	Logger       *slog.Logger
+	FinanceLogic finance.Finance
+}
+
+func initTrashHandler(l *slog.Logger, f finance.Finance) Trash {
+	return &TrashHandler{
+		Logger:       l,
+		FinanceLogic: f,
+	}
+}
+
+func (t *TrashHandler) Trash() http.HandlerFunc {
+	funcName := "Trash"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			t.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			trash, err := t.FinanceLogic.Trash(curUser.UserId)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			tmplTrash := views.TrashView(*trash)
+			err = tmplTrash.Render(ctx, w)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "GET"), slog.String("Error", err.Error()))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Restores (PUT) or permanently purges (DELETE) a deleted transaction
+func (t *TrashHandler) TrashTransactionById() http.HandlerFunc {
+	funcName := "TrashTransactionById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			t.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		transactionId := r.PathValue("id")
+		transaction, err := t.FinanceLogic.GetDeletedTransaction(transactionId)
+		if err != nil {
+			t.Logger.Error(funcName, slog.String("Error", err.Error()))
+			w.WriteHeader(500)
+			return
+		}
+		if curUser.UserId != transaction.UserId {
+			w.WriteHeader(403)
+			return
+		}
+		switch r.Method {
+		case "PUT":
+			err := t.FinanceLogic.RestoreTransaction(ctx, transaction.Id)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			rowTmpl := views.TrashClearedRow("Restored " + transaction.Name)
+			err = rowTmpl.Render(ctx, w)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+			}
+			return
+		case "DELETE":
+			err := t.FinanceLogic.PurgeTransaction(ctx, transaction.Id)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			rowTmpl := views.TrashClearedRow("Purged " + transaction.Name)
+			err = rowTmpl.Render(ctx, w)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
+
+// Restores (PUT) or permanently purges (DELETE) a deleted bucket
+func (t *TrashHandler) TrashBucketById() http.HandlerFunc {
+	funcName := "TrashBucketById"
+	return func(w http.ResponseWriter, r *http.Request) {
+		htmxReqHeader := r.Header.Get("hx-request")
+		isHtmxRequest := htmxReqHeader == "true"
+		if !isHtmxRequest {
+			http.Error(w, "misssing header 'hx-request'", 400)
+			return
+		}
+		reqCtx := r.Context()
+		ctx, cancel := context.WithTimeout(reqCtx, time.Second*20)
+		defer cancel()
+		curUser, err := auth.UserCtx(reqCtx)
+		if err != nil {
+			t.Logger.Error(funcName, slog.String("Error", err.Error()), slog.String("DevNote", "Issue getting user info from middleware ctx"))
+			http.Error(w, "Internal Error, try logging in again", 500)
+			return
+		}
+		bucketId := r.PathValue("id")
+		bucket, err := t.FinanceLogic.GetDeletedBucket(bucketId)
+		if err != nil {
+			t.Logger.Error(funcName, slog.String("Error", err.Error()))
+			w.WriteHeader(500)
+			return
+		}
+		if curUser.UserId != bucket.UserId {
+			w.WriteHeader(403)
+			return
+		}
+		switch r.Method {
+		case "PUT":
+			err := t.FinanceLogic.RestoreBucket(ctx, bucket.Id)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			rowTmpl := views.TrashClearedRow("Restored " + bucket.Name)
+			err = rowTmpl.Render(ctx, w)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "PUT"), slog.String("Error", err.Error()))
+			}
+			return
+		case "DELETE":
+			err := t.FinanceLogic.PurgeBucket(ctx, bucket.Id)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+				http.Error(w, "Internal Error", 500)
+				return
+			}
+			rowTmpl := views.TrashClearedRow("Purged " + bucket.Name)
+			err = rowTmpl.Render(ctx, w)
+			if err != nil {
+				t.Logger.Error(funcName, slog.String("HttpMethod", "DELETE"), slog.String("Error", err.Error()))
+			}
+			return
+		default:
+			http.Error(w, "Not valid method", 404)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package strutil
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+	"wonk/app/cuserr"
+)
+
+const (
+	PASSWORD_MIN_LENGTH = 8
+	// bcrypt only reads the first 72 bytes, anything after would be ignored without a word
+	PASSWORD_MAX_BYTES = 72
+)
+
+//go:embed breached_passwords.txt
+var breachedPasswordsFile []byte
+
+var breachedPasswords = sync.OnceValue(func() map[string]struct{} {
+	passwords := map[string]struct{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(breachedPasswordsFile))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+})
+
+// Reports whether the password is on the local list of common breached passwords
+func IsPasswordBreached(p string) bool {
+	_, ok := breachedPasswords()[strings.ToLower(p)]
+	return ok
+}
+
+// Policy for new passwords, long passphrases are fine up to what bcrypt can hash
+func IsPasswordValid(p string) error {
+	if p == "" {
+		return cuserr.InvalidInput{FieldName: "password", Reason: "value is empty"}
+	}
+	if utf8.RuneCountInString(p) < PASSWORD_MIN_LENGTH {
+		return cuserr.InvalidInput{FieldName: "password", Reason: "it is shorter than " + strconv.Itoa(PASSWORD_MIN_LENGTH) + " characters"}
+	}
+	if len(p) > PASSWORD_MAX_BYTES {
+		return cuserr.InvalidInput{FieldName: "password", Reason: "it is longer than " + strconv.Itoa(PASSWORD_MAX_BYTES) + " bytes, accented letters and emoji count as more than one"}
+	}
+	if IsPasswordBreached(p) {
+		return cuserr.InvalidInput{FieldName: "password", Reason: "it is a common password found in data breaches"}
+	}
+	return nil
+}
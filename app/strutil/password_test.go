@@ -0,0 +1,34 @@
+package strutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsPasswordValid(t *testing.T) {
+	tests := []struct {
+		name        string
+		password    string
+		expectedErr bool
+	}{
+		{name: "empty", password: "", expectedErr: true},
+		{name: "7 characters", password: "abcdef1", expectedErr: true},
+		{name: "8 characters", password: "abcdef12", expectedErr: false},
+		{name: "longer than the old 32 cap", password: "a sentence is easier to remember than symbols", expectedErr: false},
+		{name: "72 bytes", password: strings.Repeat("x", 72), expectedErr: false},
+		{name: "73 bytes", password: strings.Repeat("x", 73), expectedErr: true},
+		{name: "multi byte characters count as bytes", password: strings.Repeat("日本", 13), expectedErr: true},
+		{name: "breached", password: "iloveyou1", expectedErr: true},
+		{name: "breached in another case", password: "QwertyUiop", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := IsPasswordValid(tt.password)
+			if tt.expectedErr && err == nil {
+				t.Errorf("expected an error but didnt get one")
+			} else if !tt.expectedErr && err != nil {
+				t.Errorf("didn't expected an error but did get one, err: %v", err)
+			}
+		})
+	}
+}
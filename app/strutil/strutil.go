@@ -22,15 +22,6 @@ func IsStringValid(s, fieldName string) error {
 	return nil
 }
 
-func IsPasswordValid(p string) error {
-	err := IsStringValid(p, "password")
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func ConvertMonth(monthNum int) string {
 	switch monthNum {
 	case 1:
@@ -28,6 +28,7 @@ type HtmxOptions struct {
 	HxPushUrl *string
 	HxTrigger *string
 	HxInclude *string
+	HxConfirm *string
 }
 
 func (b *ButtonOptions) TemplAttributes() templ.Attributes {
@@ -106,6 +107,9 @@ func (h *HtmxOptions) TemplAttributes() templ.Attributes {
 	if h.HxInclude != nil {
 		tmplAttr["hx-include"] = h.HxInclude
 	}
+	if h.HxConfirm != nil {
+		tmplAttr["hx-confirm"] = h.HxConfirm
+	}
 	return tmplAttr
 }
 
@@ -190,7 +194,7 @@ func ButtonText(opts ButtonOptions) templ.Component {
 			var templ_7745c5c3_Var4 string
 			templ_7745c5c3_Var4, templ_7745c5c3_Err = templ.JoinStringErrs(opts.Text)
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `app/templates/components/inputs/button.templ`, Line: 112, Col: 13}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `app/templates/components/inputs/button.templ`, Line: 116, Col: 13}
 			}
 			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var4))
 			if templ_7745c5c3_Err != nil {
@@ -0,0 +1,66 @@
+package emails
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"wonk/business/finance"
+)
+
+// Plain text part of the digest email, mirrors Digest
+func DigestText(d finance.Digest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", DigestSubject(d))
+	fmt.Fprintf(&b, "Month to date\n")
+	fmt.Fprintf(&b, "  Income:   %s %s\n", formatAmount(d.Summary.TotalIncome), d.Summary.BaseCurrency)
+	fmt.Fprintf(&b, "  Expenses: %s %s\n", formatAmount(d.Summary.TotalExpense), d.Summary.BaseCurrency)
+	if len(d.Summary.MissingRates) > 0 {
+		fmt.Fprintf(&b, "  Left out for missing exchange rates: %s\n", strings.Join(d.Summary.MissingRates, ", "))
+	}
+	if len(d.TopBuckets) > 0 {
+		fmt.Fprintf(&b, "\nTop buckets\n")
+		for _, s := range d.TopBuckets {
+			fmt.Fprintf(&b, "  %s: %s %s\n", s.Reference.Name, formatAmount(s.Price), d.Summary.BaseCurrency)
+		}
+	}
+	if len(d.Budgets) > 0 {
+		fmt.Fprintf(&b, "\nBudgets\n")
+		for _, p := range d.Budgets {
+			status := fmt.Sprintf("%.0f%%", p.PercentUsed())
+			if p.Remaining < 0 {
+				status = "Over"
+			}
+			fmt.Fprintf(&b, "  %s: %s / %s %s (%s)\n", p.Bucket.Name, formatAmount(p.Spent), formatAmount(p.Budget.Amount), p.BaseCurrency, status)
+		}
+	}
+	if len(d.Bills) > 0 {
+		fmt.Fprintf(&b, "\nUnpaid bills\n")
+		for _, r := range d.Bills {
+			due := "Due " + r.DueDate.Format("Jan 2")
+			if r.Overdue {
+				due = "Overdue " + r.DueDate.Format("Jan 2")
+			}
+			fmt.Fprintf(&b, "  %s: %s %s (%s)\n", r.Bill.Name, formatAmount(r.Bill.Amount), r.Bill.Currency, due)
+		}
+	}
+	return b.String()
+}
+
+// Plain text part of the alerts email, mirrors Alerts
+func AlertsText(alerts []finance.Alert) string {
+	var b strings.Builder
+	for _, a := range alerts {
+		fmt.Fprintf(&b, "%s\n  %s\n\n", a.Title, a.Detail)
+	}
+	return b.String()
+}
+
+// Plain text part of the password reset email, mirrors PasswordReset
+func PasswordResetText(userName, link string, expiresAt time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s, open this link to pick a new password:\n\n", userName)
+	fmt.Fprintf(&b, "%s\n\n", link)
+	fmt.Fprintf(&b, "The link works once, until %s. Resetting logs you out everywhere.\n\n", expiresAt.UTC().Format("15:04 MST"))
+	fmt.Fprintf(&b, "%s\n", PASSWORD_RESET_FOOTER)
+	return b.String()
+}
@@ -0,0 +1,103 @@
+// Package totp makes and checks time-based one-time passwords (RFC 6238) the way authenticator
+// apps expect by default: HMAC-SHA1, 6 digits and a 30 second step.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DIGITS       = 6
+	PERIOD       = 30 * time.Second
+	SECRET_BYTES = 20
+	// Steps before and after now that are still accepted, for clocks that drift
+	SKEW = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func NewSecret() ([]byte, error) {
+	b := make([]byte, SECRET_BYTES)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, fmt.Errorf("NewSecret: rand: %w", err)
+	}
+	return b, nil
+}
+
+// Base32 without padding, as shown to users who type the secret in
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// Accepts lower case, spaces and padding since people copy secrets by hand
+func DecodeSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	s = strings.TrimRight(s, "=")
+	secret, err := encoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeSecret: %w", err)
+	}
+	return secret, nil
+}
+
+// The time step t is in, the counter of RFC 4226
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(PERIOD/time.Second)
+}
+
+func Code(secret []byte, t time.Time) string {
+	return hotp(secret, Counter(t), DIGITS, sha1.New)
+}
+
+// Checks code against the steps around t. Returns the matching counter so callers can refuse
+// a code that was already used.
+func Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	if len(code) != DIGITS {
+		return 0, false
+	}
+	now := Counter(t)
+	for counter := now - SKEW; counter <= now+SKEW; counter++ {
+		expected := hotp(secret, counter, DIGITS, sha1.New)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// The otpauth url authenticator apps read from the QR code
+func URI(issuer, account string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", EncodeSecret(secret))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(DIGITS))
+	v.Set("period", fmt.Sprint(int(PERIOD.Seconds())))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// RFC 4226 section 5.3, dynamic truncation of the HMAC of the counter
+func hotp(secret []byte, counter int64, digits int, h func() hash.Hash) string {
+	mac := hmac.New(h, secret)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for range digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,128 @@
+package totp
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 4226 appendix D
+func TestHotpVectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := hotp(secret, int64(counter), 6, sha1.New); got != code {
+			t.Errorf("counter %d: expected %s, got %s", counter, code, got)
+		}
+	}
+}
+
+// RFC 6238 appendix B, the seed is repeated to the length of each hash
+func TestTotpVectors(t *testing.T) {
+	seeds := map[string][]byte{
+		"SHA1":   []byte("12345678901234567890"),
+		"SHA256": []byte("12345678901234567890123456789012"),
+		"SHA512": []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	hashes := map[string]func() hash.Hash{
+		"SHA1":   sha1.New,
+		"SHA256": sha256.New,
+		"SHA512": sha512.New,
+	}
+	tests := []struct {
+		unix int64
+		algo string
+		code string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1111111111, "SHA256", "67062674"},
+		{1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{2000000000, "SHA256", "90698825"},
+		{2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+	for _, tt := range tests {
+		counter := Counter(time.Unix(tt.unix, 0))
+		if got := hotp(seeds[tt.algo], counter, 8, hashes[tt.algo]); got != tt.code {
+			t.Errorf("%d %s: expected %s, got %s", tt.unix, tt.algo, tt.code, got)
+		}
+	}
+	// Code is the SHA1 vector cut to 6 digits
+	if got := Code(seeds["SHA1"], time.Unix(1111111109, 0)); got != "081804" {
+		t.Errorf("Code: expected 081804, got %s", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+	code := Code(secret, now)
+
+	tests := []struct {
+		name    string
+		code    string
+		at      time.Time
+		counter int64
+		ok      bool
+	}{
+		{name: "same step", code: code, at: now, counter: Counter(now), ok: true},
+		{name: "one step late", code: code, at: now.Add(PERIOD), counter: Counter(now), ok: true},
+		{name: "one step early", code: code, at: now.Add(-PERIOD), counter: Counter(now), ok: true},
+		{name: "two steps late", code: code, at: now.Add(2 * PERIOD), ok: false},
+		{name: "wrong code", code: "000000", at: now, ok: false},
+		{name: "too short", code: code[:5], at: now, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := Validate(secret, tt.code, tt.at)
+			if ok != tt.ok || (ok && counter != tt.counter) {
+				t.Errorf("expected %v at %d, got %v at %d", tt.ok, tt.counter, ok, counter)
+			}
+		})
+	}
+}
+
+func TestSecretAndURI(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	encoded := EncodeSecret(secret)
+	if encoded != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Errorf("unexpected encoding %s", encoded)
+	}
+	decoded, err := DecodeSecret(strings.ToLower("GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ"))
+	if err != nil || string(decoded) != string(secret) {
+		t.Errorf("expected the secret back, got %q %v", decoded, err)
+	}
+	_, err = DecodeSecret("not base32!")
+	if err == nil {
+		t.Error("expected an error for an invalid secret")
+	}
+
+	uri, err := url.Parse(URI("Wonk", "some one", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Wonk:some one" {
+		t.Errorf("unexpected uri %s", uri)
+	}
+	q := uri.Query()
+	if q.Get("secret") != encoded || q.Get("issuer") != "Wonk" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("unexpected query %v", q)
+	}
+}
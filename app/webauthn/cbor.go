@@ -0,0 +1,140 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Authenticators nest a few levels at most, anything deeper is garbage
+const CBOR_MAX_DEPTH = 16
+
+var errCBOR = errors.New("invalid cbor")
+
+// Decodes one CBOR item (RFC 8949) and returns it with the bytes after it. Only what
+// authenticators send is supported: integers as int64, byte and text strings, arrays as []any,
+// maps as map[any]any with int64 or string keys, booleans and null. Tags, floats and
+// indefinite lengths are rejected.
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeItem(data, 0)
+}
+
+func decodeItem(data []byte, depth int) (any, []byte, error) {
+	if depth > CBOR_MAX_DEPTH {
+		return nil, nil, fmt.Errorf("decodeItem: %w: nested too deep", errCBOR)
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("decodeItem: %w: unexpected end", errCBOR)
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22:
+			return nil, data, nil
+		}
+		return nil, nil, fmt.Errorf("decodeItem: %w: unsupported simple value %d", errCBOR, info)
+	}
+
+	arg, data, err := readArgument(info, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decodeItem: %w", err)
+	}
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, nil, fmt.Errorf("decodeItem: %w: integer overflows int64", errCBOR)
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, nil, fmt.Errorf("decodeItem: %w: integer overflows int64", errCBOR)
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("decodeItem: %w: string longer than the data", errCBOR)
+		}
+		b := data[:arg]
+		if major == 3 {
+			if !utf8.Valid(b) {
+				return nil, nil, fmt.Errorf("decodeItem: %w: text is not utf-8", errCBOR)
+			}
+			return string(b), data[arg:], nil
+		}
+		return bytes.Clone(b), data[arg:], nil
+	case 4:
+		// Every item takes at least a byte, which bounds the allocation
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("decodeItem: %w: array longer than the data", errCBOR)
+		}
+		items := make([]any, arg)
+		for i := range items {
+			items[i], data, err = decodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, data, nil
+	case 5:
+		if arg > uint64(len(data))/2 {
+			return nil, nil, fmt.Errorf("decodeItem: %w: map longer than the data", errCBOR)
+		}
+		m := make(map[any]any, arg)
+		for range arg {
+			var key, value any
+			key, data, err = decodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("decodeItem: %w: map key must be an integer or text", errCBOR)
+			}
+			if _, ok := m[key]; ok {
+				return nil, nil, fmt.Errorf("decodeItem: %w: duplicate map key %v", errCBOR, key)
+			}
+			value, data, err = decodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, data, nil
+	}
+	return nil, nil, fmt.Errorf("decodeItem: %w: unsupported major type %d", errCBOR, major)
+}
+
+// The length or value that follows the initial byte
+func readArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(data) < n {
+			return 0, nil, fmt.Errorf("readArgument: %w: unexpected end", errCBOR)
+		}
+		var arg uint64
+		switch n {
+		case 1:
+			arg = uint64(data[0])
+		case 2:
+			arg = uint64(binary.BigEndian.Uint16(data))
+		case 4:
+			arg = uint64(binary.BigEndian.Uint32(data))
+		case 8:
+			arg = binary.BigEndian.Uint64(data)
+		}
+		return arg, data[n:], nil
+	}
+	return 0, nil, fmt.Errorf("readArgument: %w: unsupported additional info %d", errCBOR, info)
+}
@@ -0,0 +1,91 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// RFC 8949 appendix A
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected any
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1a000f4240", int64(1000000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"20", int64(-1)},
+		{"3863", int64(-100)},
+		{"3903e7", int64(-1000)},
+		{"40", []byte{}},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"60", ""},
+		{"6449455446", "IETF"},
+		{"62c3bc", "ü"},
+		{"80", []any{}},
+		{"83010203", []any{int64(1), int64(2), int64(3)}},
+		{"8301820203820405", []any{int64(1), []any{int64(2), int64(3)}, []any{int64(4), int64(5)}}},
+		{"a0", map[any]any{}},
+		{"a201020304", map[any]any{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[any]any{"a": int64(1), "b": []any{int64(2), int64(3)}}},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+	}
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.in)
+		got, rest, err := decodeCBOR(data)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.in, err)
+			continue
+		}
+		if len(rest) != 0 {
+			t.Errorf("%s: %d bytes left over", tt.in, len(rest))
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: expected %#v, got %#v", tt.in, tt.expected, got)
+		}
+	}
+
+	// The bytes after the first item are handed back
+	data, _ := hex.DecodeString("0102")
+	_, rest, err := decodeCBOR(data)
+	if err != nil || len(rest) != 1 || rest[0] != 2 {
+		t.Errorf("expected the second item back, got %x %v", rest, err)
+	}
+}
+
+func TestDecodeCBORRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{name: "empty", in: ""},
+		{name: "truncated string", in: "4401"},
+		{name: "truncated argument", in: "19"},
+		{name: "int64 overflow", in: "1bffffffffffffffff"},
+		{name: "float", in: "f93c00"},
+		{name: "undefined", in: "f7"},
+		{name: "tag", in: "c11a514b67b0"},
+		{name: "indefinite string", in: "5f42010243030405ff"},
+		{name: "invalid utf-8", in: "62c328"},
+		{name: "duplicate map key", in: "a201020103"},
+		{name: "byte string map key", in: "a14101 02"},
+		{name: "huge array length", in: "9bffffffff00000000"},
+		{name: "nested too deep", in: strings.Repeat("81", CBOR_MAX_DEPTH+2) + "00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(strings.ReplaceAll(tt.in, " ", ""))
+			_, _, err := decodeCBOR(data)
+			if err == nil {
+				t.Error("expected an error but didnt get one")
+			}
+		})
+	}
+}
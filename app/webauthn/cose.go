@@ -0,0 +1,105 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm ids (RFC 9053), the ones offered in pubKeyCredParams
+const (
+	ALG_ES256 = -7
+	ALG_EDDSA = -8
+	ALG_RS256 = -257
+)
+
+// COSE key types and curves
+const (
+	coseKtyOKP     = 1
+	coseKtyEC2     = 2
+	coseKtyRSA     = 3
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+	// Smaller RSA keys aren't accepted
+	MIN_RSA_BITS = 2048
+)
+
+var ErrUnsupportedKey = errors.New("unsupported public key")
+
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// Reads a COSE_Key (RFC 9052) and returns the bytes after it
+func parsePublicKey(data []byte) (*publicKey, []byte, error) {
+	item, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsePublicKey: %w", err)
+	}
+	m, ok := item.(map[any]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("parsePublicKey: %w: not a map", ErrUnsupportedKey)
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == ALG_ES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, nil, fmt.Errorf("parsePublicKey: %w: bad P-256 key", ErrUnsupportedKey)
+		}
+		// ecdh rejects points that aren't on the curve
+		point := append(append([]byte{4}, x...), y...)
+		_, err := ecdh.P256().NewPublicKey(point)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsePublicKey: %w: %w", ErrUnsupportedKey, err)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		return &publicKey{alg: alg, key: key}, rest, nil
+	case kty == coseKtyOKP && alg == ALG_EDDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, nil, fmt.Errorf("parsePublicKey: %w: bad Ed25519 key", ErrUnsupportedKey)
+		}
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, rest, nil
+	case kty == coseKtyRSA && alg == ALG_RS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(e) == 0 || len(e) > 4 {
+			return nil, nil, fmt.Errorf("parsePublicKey: %w: bad RSA exponent", ErrUnsupportedKey)
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < MIN_RSA_BITS || key.E < 3 || key.E%2 == 0 {
+			return nil, nil, fmt.Errorf("parsePublicKey: %w: weak RSA key", ErrUnsupportedKey)
+		}
+		return &publicKey{alg: alg, key: key}, rest, nil
+	}
+	return nil, nil, fmt.Errorf("parsePublicKey: %w: kty %d alg %d", ErrUnsupportedKey, kty, alg)
+}
+
+// Checks sig over data with the key's algorithm, ES256 signatures are ASN.1 DER
+func (p *publicKey) verify(data, sig []byte) bool {
+	switch key := p.key.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, sum[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	}
+	return false
+}
@@ -0,0 +1,424 @@
+// Package webauthn verifies WebAuthn (Level 2) registration and authentication ceremonies
+// for passkeys. Attestation isn't used to trust authenticators, "none" is requested and
+// "packed" statements are only checked for a valid signature.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	CHALLENGE_BYTES = 32
+	// How long the browser waits for the user, in milliseconds
+	TIMEOUT_MS = 120000
+	// Passkeys replace the password so the authenticator has to check it's the user, a PIN or biometric
+	USER_VERIFICATION = "required"
+	MAX_CREDENTIAL_ID = 1023
+
+	CEREMONY_CREATE = "webauthn.create"
+	CEREMONY_GET    = "webauthn.get"
+)
+
+// Authenticator data flags
+const (
+	FLAG_UP = 0x01 // User present
+	FLAG_UV = 0x04 // User verified
+	FLAG_BE = 0x08 // Backup eligible
+	FLAG_BS = 0x10 // Backed up
+	FLAG_AT = 0x40 // Attested credential data included
+	FLAG_ED = 0x80 // Extension data included
+)
+
+var (
+	ErrInvalidResponse     = errors.New("invalid webauthn response")
+	ErrChallenge           = errors.New("challenge does not match")
+	ErrOrigin              = errors.New("origin not allowed")
+	ErrRelyingParty        = errors.New("relying party id does not match")
+	ErrUserNotVerified     = errors.New("user not present or verified")
+	ErrSignature           = errors.New("invalid signature")
+	ErrClonedAuthenticator = errors.New("sign count did not increase")
+)
+
+// The site passkeys are made for. ID is the domain, Origins are the full origins pages are served from.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// Bytes that go over json as unpadded base64url, as in PublicKeyCredential.toJSON()
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return fmt.Errorf("Base64URL: %w", err)
+	}
+	*b = decoded
+	return nil
+}
+
+type CredentialDescriptor struct {
+	Type string    `json:"type"`
+	Id   Base64URL `json:"id"`
+}
+
+type RelyingPartyEntity struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	Id          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	RequireResident  bool   `json:"requireResidentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// PublicKeyCredentialCreationOptions, passed to navigator.credentials.create
+type CreationOptions struct {
+	Challenge              Base64URL              `json:"challenge"`
+	Rp                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// PublicKeyCredentialRequestOptions, passed to navigator.credentials.get
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RpId             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type AttestationResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AttestationObject Base64URL `json:"attestationObject"`
+}
+
+// What navigator.credentials.create resolves to
+type RegistrationCredential struct {
+	Id       string              `json:"id"`
+	RawId    Base64URL           `json:"rawId"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+type AssertionResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AuthenticatorData Base64URL `json:"authenticatorData"`
+	Signature         Base64URL `json:"signature"`
+	UserHandle        Base64URL `json:"userHandle"`
+}
+
+// What navigator.credentials.get resolves to
+type AssertionCredential struct {
+	Id       string            `json:"id"`
+	RawId    Base64URL         `json:"rawId"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
+
+// A verified new credential, PublicKey is the COSE_Key to save and pass to VerifyAssertion
+type Credential struct {
+	Id        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type authenticatorData struct {
+	rpIdHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialId []byte
+	publicKey    []byte // COSE_Key, only with FLAG_AT
+}
+
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, CHALLENGE_BYTES)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, fmt.Errorf("NewChallenge: rand: %w", err)
+	}
+	return b, nil
+}
+
+// Options for making a passkey. excludeIds are the user's existing credentials so the same
+// authenticator isn't registered twice.
+func (rp *RelyingParty) CreationOptions(challenge, userHandle []byte, userName string, excludeIds [][]byte) CreationOptions {
+	exclude := make([]CredentialDescriptor, len(excludeIds))
+	for i, id := range excludeIds {
+		exclude[i] = CredentialDescriptor{Type: "public-key", Id: id}
+	}
+	return CreationOptions{
+		Challenge: challenge,
+		Rp:        RelyingPartyEntity{Id: rp.ID, Name: rp.Name},
+		User:      UserEntity{Id: userHandle, Name: userName, DisplayName: userName},
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: ALG_ES256},
+			{Type: "public-key", Alg: ALG_EDDSA},
+			{Type: "public-key", Alg: ALG_RS256},
+		},
+		Timeout:            TIMEOUT_MS,
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			RequireResident:  true,
+			UserVerification: USER_VERIFICATION,
+		},
+		Attestation: "none",
+	}
+}
+
+// Options for logging in. No allowed credentials are listed so the browser offers every
+// passkey it has for the site and the username doesn't have to be typed.
+func (rp *RelyingParty) RequestOptions(challenge []byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          TIMEOUT_MS,
+		RpId:             rp.ID,
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: USER_VERIFICATION,
+	}
+}
+
+// Registration ceremony, WebAuthn Level 2 section 7.1
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, cred *RegistrationCredential) (*Credential, error) {
+	if cred.Type != "public-key" {
+		return nil, fmt.Errorf("VerifyRegistration: %w: type %q", ErrInvalidResponse, cred.Type)
+	}
+	err := rp.verifyClientData(cred.Response.ClientDataJSON, CEREMONY_CREATE, challenge)
+	if err != nil {
+		return nil, fmt.Errorf("VerifyRegistration: %w", err)
+	}
+
+	item, rest, err := decodeCBOR(cred.Response.AttestationObject)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("VerifyRegistration: %w: attestation object", ErrInvalidResponse)
+	}
+	attestation, ok := item.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("VerifyRegistration: %w: attestation object", ErrInvalidResponse)
+	}
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[any]any)
+	rawAuthData, _ := attestation["authData"].([]byte)
+	if statement == nil || rawAuthData == nil {
+		return nil, fmt.Errorf("VerifyRegistration: %w: attestation object", ErrInvalidResponse)
+	}
+
+	authData, err := rp.verifyAuthData(rawAuthData)
+	if err != nil {
+		return nil, fmt.Errorf("VerifyRegistration: %w", err)
+	}
+	if authData.credentialId == nil {
+		return nil, fmt.Errorf("VerifyRegistration: %w: no attested credential", ErrInvalidResponse)
+	}
+	if !bytes.Equal(authData.credentialId, cred.RawId) {
+		return nil, fmt.Errorf("VerifyRegistration: %w: credential id does not match", ErrInvalidResponse)
+	}
+	key, _, err := parsePublicKey(authData.publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("VerifyRegistration: %w", err)
+	}
+
+	clientDataHash := sha256.Sum256(cred.Response.ClientDataJSON)
+	signed := append(slices.Clip(rawAuthData), clientDataHash[:]...)
+	err = verifyStatement(format, statement, key, signed)
+	if err != nil {
+		return nil, fmt.Errorf("VerifyRegistration: %w", err)
+	}
+
+	return &Credential{
+		Id:        authData.credentialId,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// Authentication ceremony, WebAuthn Level 2 section 7.2. publicKey and signCount are what
+// was saved for the credential, returns the sign count to save next.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, publicKeyCose []byte, signCount uint32, cred *AssertionCredential) (uint32, error) {
+	if cred.Type != "public-key" {
+		return 0, fmt.Errorf("VerifyAssertion: %w: type %q", ErrInvalidResponse, cred.Type)
+	}
+	err := rp.verifyClientData(cred.Response.ClientDataJSON, CEREMONY_GET, challenge)
+	if err != nil {
+		return 0, fmt.Errorf("VerifyAssertion: %w", err)
+	}
+	authData, err := rp.verifyAuthData(cred.Response.AuthenticatorData)
+	if err != nil {
+		return 0, fmt.Errorf("VerifyAssertion: %w", err)
+	}
+	key, _, err := parsePublicKey(publicKeyCose)
+	if err != nil {
+		return 0, fmt.Errorf("VerifyAssertion: %w", err)
+	}
+	clientDataHash := sha256.Sum256(cred.Response.ClientDataJSON)
+	signed := append(slices.Clip([]byte(cred.Response.AuthenticatorData)), clientDataHash[:]...)
+	if !key.verify(signed, cred.Response.Signature) {
+		return 0, fmt.Errorf("VerifyAssertion: %w", ErrSignature)
+	}
+	// Authenticators that don't count always send 0, otherwise a count that didn't go up
+	// means two copies of the key exist
+	if (authData.signCount != 0 || signCount != 0) && authData.signCount <= signCount {
+		return 0, fmt.Errorf("VerifyAssertion: %w", ErrClonedAuthenticator)
+	}
+	return authData.signCount, nil
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	c := clientData{}
+	err := json.Unmarshal(raw, &c)
+	if err != nil {
+		return fmt.Errorf("verifyClientData: %w: %w", ErrInvalidResponse, err)
+	}
+	if c.Type != ceremony {
+		return fmt.Errorf("verifyClientData: %w: type %q", ErrInvalidResponse, c.Type)
+	}
+	sent, err := base64.RawURLEncoding.DecodeString(c.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(sent, challenge) != 1 {
+		return fmt.Errorf("verifyClientData: %w", ErrChallenge)
+	}
+	if !slices.Contains(rp.Origins, c.Origin) || c.CrossOrigin {
+		return fmt.Errorf("verifyClientData: %w: %q", ErrOrigin, c.Origin)
+	}
+	return nil
+}
+
+// Parses authenticator data and checks it's for this site with a verified user
+func (rp *RelyingParty) verifyAuthData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, fmt.Errorf("verifyAuthData: %w: too short", ErrInvalidResponse)
+	}
+	a := authenticatorData{
+		rpIdHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rest := raw[37:]
+
+	rpIdHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(a.rpIdHash, rpIdHash[:]) != 1 {
+		return nil, fmt.Errorf("verifyAuthData: %w", ErrRelyingParty)
+	}
+	if a.flags&FLAG_UP == 0 || a.flags&FLAG_UV == 0 {
+		return nil, fmt.Errorf("verifyAuthData: %w", ErrUserNotVerified)
+	}
+	if a.flags&FLAG_BS != 0 && a.flags&FLAG_BE == 0 {
+		return nil, fmt.Errorf("verifyAuthData: %w: backed up but not backup eligible", ErrInvalidResponse)
+	}
+
+	if a.flags&FLAG_AT != 0 {
+		// aaguid, then the credential id with its length
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("verifyAuthData: %w: attested data too short", ErrInvalidResponse)
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > MAX_CREDENTIAL_ID || len(rest) < idLen {
+			return nil, fmt.Errorf("verifyAuthData: %w: credential id length", ErrInvalidResponse)
+		}
+		a.credentialId = rest[:idLen]
+		rest = rest[idLen:]
+		_, after, err := parsePublicKey(rest)
+		if err != nil {
+			return nil, fmt.Errorf("verifyAuthData: %w", err)
+		}
+		a.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+	if a.flags&FLAG_ED != 0 {
+		item, after, err := decodeCBOR(rest)
+		if _, ok := item.(map[any]any); err != nil || !ok {
+			return nil, fmt.Errorf("verifyAuthData: %w: extensions", ErrInvalidResponse)
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("verifyAuthData: %w: trailing bytes", ErrInvalidResponse)
+	}
+	return &a, nil
+}
+
+// "none" has nothing to check. "packed" is signed by the credential itself or by an
+// attestation certificate, the certificate isn't checked against any roots.
+func verifyStatement(format string, statement map[any]any, key *publicKey, signed []byte) error {
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return fmt.Errorf("verifyStatement: %w: none with a statement", ErrInvalidResponse)
+		}
+		return nil
+	case "packed":
+		alg, _ := statement["alg"].(int64)
+		sig, _ := statement["sig"].([]byte)
+		x5c, hasCert := statement["x5c"].([]any)
+		if !hasCert {
+			if alg != key.alg || !key.verify(signed, sig) {
+				return fmt.Errorf("verifyStatement: %w: packed self attestation", ErrSignature)
+			}
+			return nil
+		}
+		if len(x5c) == 0 {
+			return fmt.Errorf("verifyStatement: %w: empty x5c", ErrInvalidResponse)
+		}
+		der, _ := x5c[0].([]byte)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("verifyStatement: %w: %w", ErrInvalidResponse, err)
+		}
+		sigAlg, ok := map[int64]x509.SignatureAlgorithm{
+			ALG_ES256: x509.ECDSAWithSHA256,
+			ALG_EDDSA: x509.PureEd25519,
+			ALG_RS256: x509.SHA256WithRSA,
+		}[alg]
+		if !ok || cert.CheckSignature(sigAlg, signed, sig) != nil {
+			return fmt.Errorf("verifyStatement: %w: packed attestation", ErrSignature)
+		}
+		return nil
+	}
+	return fmt.Errorf("verifyStatement: %w: attestation format %q", ErrInvalidResponse, format)
+}
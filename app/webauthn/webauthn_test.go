@@ -0,0 +1,251 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+	"wonk/app/webauthn"
+	"wonk/app/webauthn/webauthntest"
+)
+
+const testOrigin = "https://wonk.example"
+
+func testRelyingParty() *webauthn.RelyingParty {
+	return &webauthn.RelyingParty{ID: "wonk.example", Name: "Wonk", Origins: []string{testOrigin}}
+}
+
+// Registers a credential and returns it with the options it was made from
+func register(t *testing.T, rp *webauthn.RelyingParty, a *webauthntest.Authenticator) (*webauthn.Credential, webauthn.CreationOptions) {
+	t.Helper()
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := rp.CreationOptions(challenge, []byte{0, 0, 0, 0, 0, 0, 0, 1}, "someone", nil)
+	resp, err := a.Create(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := rp.VerifyRegistration(challenge, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cred, opts
+}
+
+func TestCeremonies(t *testing.T) {
+	tests := []struct {
+		name   string
+		alg    int
+		format string
+	}{
+		{name: "ES256", alg: webauthn.ALG_ES256, format: "none"},
+		{name: "EdDSA", alg: webauthn.ALG_EDDSA, format: "none"},
+		{name: "RS256", alg: webauthn.ALG_RS256, format: "none"},
+		{name: "ES256 packed", alg: webauthn.ALG_ES256, format: "packed"},
+		{name: "RS256 packed", alg: webauthn.ALG_RS256, format: "packed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := testRelyingParty()
+			a := webauthntest.New(testOrigin)
+			a.Alg = tt.alg
+			a.Format = tt.format
+			cred, _ := register(t, rp, a)
+
+			signCount := cred.SignCount
+			for range 2 {
+				challenge, _ := webauthn.NewChallenge()
+				resp, err := a.Get(rp.RequestOptions(challenge))
+				if err != nil {
+					t.Fatal(err)
+				}
+				signCount, err = rp.VerifyAssertion(challenge, cred.PublicKey, signCount, resp)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if signCount != 2 {
+				t.Errorf("expected sign count 2, got %d", signCount)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(a *webauthntest.Authenticator, opts *webauthn.CreationOptions)
+		tamper   func(resp *webauthn.RegistrationCredential)
+		expected error
+	}{
+		{
+			name:     "wrong origin",
+			setup:    func(a *webauthntest.Authenticator, opts *webauthn.CreationOptions) { a.Origin = "https://evil.example" },
+			expected: webauthn.ErrOrigin,
+		},
+		{
+			name:     "wrong relying party",
+			setup:    func(a *webauthntest.Authenticator, opts *webauthn.CreationOptions) { opts.Rp.Id = "evil.example" },
+			expected: webauthn.ErrRelyingParty,
+		},
+		{
+			name:     "other challenge",
+			setup:    func(a *webauthntest.Authenticator, opts *webauthn.CreationOptions) { opts.Challenge = []byte("other") },
+			expected: webauthn.ErrChallenge,
+		},
+		{
+			name:     "user not verified",
+			setup:    func(a *webauthntest.Authenticator, opts *webauthn.CreationOptions) { a.Flags = webauthn.FLAG_UP },
+			expected: webauthn.ErrUserNotVerified,
+		},
+		{
+			name: "raw id doesn't match",
+			tamper: func(resp *webauthn.RegistrationCredential) {
+				resp.RawId = []byte("someone else's")
+			},
+			expected: webauthn.ErrInvalidResponse,
+		},
+		{
+			name: "truncated attestation",
+			tamper: func(resp *webauthn.RegistrationCredential) {
+				resp.Response.AttestationObject = resp.Response.AttestationObject[:len(resp.Response.AttestationObject)-4]
+			},
+			expected: webauthn.ErrInvalidResponse,
+		},
+		{
+			name: "assertion client data",
+			tamper: func(resp *webauthn.RegistrationCredential) {
+				resp.Response.ClientDataJSON = []byte(`{"type":"webauthn.get"}`)
+			},
+			expected: webauthn.ErrInvalidResponse,
+		},
+		{
+			name:  "bad packed signature",
+			setup: func(a *webauthntest.Authenticator, opts *webauthn.CreationOptions) { a.Format = "packed" },
+			// Still valid json but no longer what was signed
+			tamper: func(resp *webauthn.RegistrationCredential) {
+				resp.Response.ClientDataJSON = append(resp.Response.ClientDataJSON, ' ')
+			},
+			expected: webauthn.ErrSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := testRelyingParty()
+			a := webauthntest.New(testOrigin)
+			challenge, _ := webauthn.NewChallenge()
+			opts := rp.CreationOptions(challenge, []byte{1}, "someone", nil)
+			if tt.setup != nil {
+				tt.setup(a, &opts)
+			}
+			resp, err := a.Create(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(resp)
+			}
+			_, err = rp.VerifyRegistration(challenge, resp)
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	rp := testRelyingParty()
+	a := webauthntest.New(testOrigin)
+	cred, _ := register(t, rp, a)
+	other, _ := register(t, rp, webauthntest.New(testOrigin))
+
+	tests := []struct {
+		name      string
+		setup     func(a *webauthntest.Authenticator, opts *webauthn.RequestOptions)
+		tamper    func(resp *webauthn.AssertionCredential)
+		publicKey []byte
+		signCount uint32
+		expected  error
+	}{
+		{
+			name:     "other challenge",
+			setup:    func(a *webauthntest.Authenticator, opts *webauthn.RequestOptions) { opts.Challenge = []byte("other") },
+			expected: webauthn.ErrChallenge,
+		},
+		{
+			name: "flipped signature bit",
+			tamper: func(resp *webauthn.AssertionCredential) {
+				resp.Response.Signature[len(resp.Response.Signature)-1] ^= 1
+			},
+			expected: webauthn.ErrSignature,
+		},
+		{
+			name:      "another credential's key",
+			publicKey: other.PublicKey,
+			expected:  webauthn.ErrSignature,
+		},
+		{
+			name:      "sign count went backwards",
+			signCount: 1000,
+			expected:  webauthn.ErrClonedAuthenticator,
+		},
+		{
+			name:     "user not verified",
+			setup:    func(a *webauthntest.Authenticator, opts *webauthn.RequestOptions) { a.Flags = webauthn.FLAG_UP },
+			expected: webauthn.ErrUserNotVerified,
+		},
+		{
+			name: "trailing authenticator data",
+			tamper: func(resp *webauthn.AssertionCredential) {
+				resp.Response.AuthenticatorData = append(resp.Response.AuthenticatorData, 0)
+			},
+			expected: webauthn.ErrInvalidResponse,
+		},
+		{
+			name: "registration client data",
+			tamper: func(resp *webauthn.AssertionCredential) {
+				resp.Response.ClientDataJSON = []byte(`{"type":"webauthn.create"}`)
+			},
+			expected: webauthn.ErrInvalidResponse,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.Flags = webauthn.FLAG_UP | webauthn.FLAG_UV
+			challenge, _ := webauthn.NewChallenge()
+			opts := rp.RequestOptions(challenge)
+			if tt.setup != nil {
+				tt.setup(a, &opts)
+			}
+			resp, err := a.Get(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(resp)
+			}
+			publicKey := cred.PublicKey
+			if tt.publicKey != nil {
+				publicKey = tt.publicKey
+			}
+			_, err = rp.VerifyAssertion(challenge, publicKey, tt.signCount, resp)
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	// Passkeys that don't count stay at 0 and are still accepted
+	a.NoSignCount = true
+	a.Flags = webauthn.FLAG_UP | webauthn.FLAG_UV
+	synced, _ := register(t, rp, a)
+	challenge, _ := webauthn.NewChallenge()
+	resp, err := a.Get(rp.RequestOptions(challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signCount, err := rp.VerifyAssertion(challenge, synced.PublicKey, 0, resp)
+	if err != nil || signCount != 0 {
+		t.Errorf("expected a sign count of 0 to be accepted, got %d %v", signCount, err)
+	}
+}
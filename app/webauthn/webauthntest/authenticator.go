@@ -0,0 +1,214 @@
+// Package webauthntest is a software authenticator that answers WebAuthn options the way a
+// browser and a passkey would, so ceremonies can be tested without a device.
+package webauthntest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"wonk/app/webauthn"
+)
+
+var (
+	ErrExcluded     = errors.New("authenticator already registered")
+	ErrNoCredential = errors.New("no credential for the relying party")
+	ErrAlgorithm    = errors.New("algorithm not offered")
+)
+
+type Authenticator struct {
+	Origin string
+	Alg    int    // Key type for new credentials, ES256 by default
+	Format string // Attestation format, "none" by default or "packed" for self attestation
+	Flags  byte   // Sent in authenticator data, user present and verified by default
+	// Leaves the sign count at 0 like most synced passkeys
+	NoSignCount bool
+
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpId       string
+	userHandle []byte
+	alg        int
+	signer     crypto.Signer
+	signCount  uint32
+}
+
+func New(origin string) *Authenticator {
+	return &Authenticator{
+		Origin: origin,
+		Alg:    webauthn.ALG_ES256,
+		Format: "none",
+		Flags:  webauthn.FLAG_UP | webauthn.FLAG_UV,
+	}
+}
+
+// Makes a credential like navigator.credentials.create
+func (a *Authenticator) Create(opts webauthn.CreationOptions) (*webauthn.RegistrationCredential, error) {
+	for _, c := range a.credentials {
+		for _, excluded := range opts.ExcludeCredentials {
+			if c.rpId == opts.Rp.Id && bytes.Equal(c.id, excluded.Id) {
+				return nil, ErrExcluded
+			}
+		}
+	}
+	offered := slices.ContainsFunc(opts.PubKeyCredParams, func(p webauthn.CredentialParameter) bool { return p.Alg == a.Alg })
+	if !offered {
+		return nil, ErrAlgorithm
+	}
+	signer, coseKey, err := newKey(a.Alg)
+	if err != nil {
+		return nil, fmt.Errorf("Create: %w", err)
+	}
+	c := &credential{
+		id:         make([]byte, 16),
+		rpId:       opts.Rp.Id,
+		userHandle: opts.User.Id,
+		alg:        a.Alg,
+		signer:     signer,
+	}
+	rand.Read(c.id)
+
+	// aaguid of zeros, then the credential id and key
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(c.id)))
+	attested = append(attested, c.id...)
+	attested = append(attested, coseKey...)
+	authData := a.authData(c, a.Flags|webauthn.FLAG_AT, attested)
+	clientDataJSON := a.clientData("webauthn.create", opts.Challenge)
+
+	statement := cborMap{}
+	if a.Format == "packed" {
+		sig, err := sign(c, authData, clientDataJSON)
+		if err != nil {
+			return nil, fmt.Errorf("Create: %w", err)
+		}
+		statement = cborMap{{"alg", c.alg}, {"sig", sig}}
+	}
+	attestation := encodeCBOR(cborMap{
+		{"fmt", a.Format},
+		{"attStmt", statement},
+		{"authData", authData},
+	})
+
+	a.credentials = append(a.credentials, c)
+	return &webauthn.RegistrationCredential{
+		Id:    base64.RawURLEncoding.EncodeToString(c.id),
+		RawId: c.id,
+		Type:  "public-key",
+		Response: webauthn.AttestationResponse{
+			ClientDataJSON:    clientDataJSON,
+			AttestationObject: attestation,
+		},
+	}, nil
+}
+
+// Signs in like navigator.credentials.get, with the newest credential for the relying party
+func (a *Authenticator) Get(opts webauthn.RequestOptions) (*webauthn.AssertionCredential, error) {
+	var c *credential
+	for _, candidate := range slices.Backward(a.credentials) {
+		if candidate.rpId != opts.RpId {
+			continue
+		}
+		allowed := len(opts.AllowCredentials) == 0 || slices.ContainsFunc(opts.AllowCredentials, func(d webauthn.CredentialDescriptor) bool {
+			return bytes.Equal(d.Id, candidate.id)
+		})
+		if allowed {
+			c = candidate
+			break
+		}
+	}
+	if c == nil {
+		return nil, ErrNoCredential
+	}
+	if !a.NoSignCount {
+		c.signCount++
+	}
+	authData := a.authData(c, a.Flags, nil)
+	clientDataJSON := a.clientData("webauthn.get", opts.Challenge)
+	sig, err := sign(c, authData, clientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("Get: %w", err)
+	}
+	return &webauthn.AssertionCredential{
+		Id:    base64.RawURLEncoding.EncodeToString(c.id),
+		RawId: c.id,
+		Type:  "public-key",
+		Response: webauthn.AssertionResponse{
+			ClientDataJSON:    clientDataJSON,
+			AuthenticatorData: authData,
+			Signature:         sig,
+			UserHandle:        c.userHandle,
+		},
+	}, nil
+}
+
+func (a *Authenticator) authData(c *credential, flags byte, attested []byte) []byte {
+	rpIdHash := sha256.Sum256([]byte(c.rpId))
+	data := append(rpIdHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, c.signCount)
+	return append(data, attested...)
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge []byte) []byte {
+	b, _ := json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return b
+}
+
+// Signs authData followed by the hash of the client data
+func sign(c *credential, authData, clientDataJSON []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(slices.Clip(authData), clientDataHash[:]...)
+	if c.alg == webauthn.ALG_EDDSA {
+		return c.signer.Sign(rand.Reader, signed, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(signed)
+	return c.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// Returns the private key and its public half as a COSE_Key
+func newKey(alg int) (crypto.Signer, []byte, error) {
+	switch alg {
+	case webauthn.ALG_ES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		x, y := make([]byte, 32), make([]byte, 32)
+		key.X.FillBytes(x)
+		key.Y.FillBytes(y)
+		return key, encodeCBOR(cborMap{{1, 2}, {3, alg}, {-1, 1}, {-2, x}, {-3, y}}), nil
+	case webauthn.ALG_EDDSA:
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, encodeCBOR(cborMap{{1, 1}, {3, alg}, {-1, 6}, {-2, []byte(pub)}}), nil
+	case webauthn.ALG_RS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, nil, err
+		}
+		e := binary.BigEndian.AppendUint32(nil, uint32(key.E))
+		e = bytes.TrimLeft(e, "\x00")
+		return key, encodeCBOR(cborMap{{1, 3}, {3, alg}, {-1, key.N.Bytes()}, {-2, e}}), nil
+	}
+	return nil, nil, ErrAlgorithm
+}
@@ -0,0 +1,105 @@
+package finance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"wonk/app/requestid"
+	"wonk/storage"
+)
+
+// Who is making a change, the storage method saves the audit entry with the change
+func auditInfo(ctx context.Context, userId int) database.AuditInfo {
+	return database.AuditInfo{
+		UserId:    userId,
+		RequestId: requestid.FromCtx(ctx),
+		CreatedAt: time.Now().Unix(),
+	}
+}
+
+// Returns the change timeline of a transaction, oldest change first
+func (f *FinanceLogic) TransactionHistory(userId, transactionId int) ([]HistoryEntry, error) {
+	entries, err := f.DB.AuditEntries(userId, database.AUDIT_ENTITY_TRANSACTION, transactionId)
+	if err != nil {
+		return nil, fmt.Errorf("TransactionHistory: db: %w", err)
+	}
+
+	history := []HistoryEntry{}
+	for _, e := range entries {
+		changes, err := diffAuditJson(e.BeforeJson, e.AfterJson)
+		if err != nil {
+			return nil, fmt.Errorf("TransactionHistory: entry %d: %w", e.Id, err)
+		}
+		history = append(history, HistoryEntry{
+			Action:    e.Action,
+			UserId:    e.UserId,
+			RequestId: e.RequestId,
+			CreatedAt: time.Unix(e.CreatedAt, 0),
+			Changes:   changes,
+		})
+	}
+	return history, nil
+}
+
+// Compares the before & after snapshots and returns the fields that changed
+func diffAuditJson(beforeJson, afterJson *string) ([]FieldChange, error) {
+	before := map[string]any{}
+	after := map[string]any{}
+	if beforeJson != nil {
+		err := decodeAuditJson(*beforeJson, &before)
+		if err != nil {
+			return nil, fmt.Errorf("diffAuditJson: before: %w", err)
+		}
+	}
+	if afterJson != nil {
+		err := decodeAuditJson(*afterJson, &after)
+		if err != nil {
+			return nil, fmt.Errorf("diffAuditJson: after: %w", err)
+		}
+	}
+
+	fields := map[string]bool{}
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+	names := []string{}
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	changes := []FieldChange{}
+	for _, name := range names {
+		beforeVal := auditValueStr(before[name])
+		afterVal := auditValueStr(after[name])
+		if beforeVal == afterVal {
+			continue
+		}
+		changes = append(changes, FieldChange{
+			Field:  name,
+			Before: beforeVal,
+			After:  afterVal,
+		})
+	}
+	return changes, nil
+}
+
+// Numbers are kept as json.Number so ids & timestamps aren't printed as floats
+func decodeAuditJson(s string, v *map[string]any) error {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func auditValueStr(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,192 @@
+package finance
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"wonk/app/requestid"
+	"wonk/storage"
+)
+
+func TestDiffAuditJson(t *testing.T) {
+	strPtr := func(s string) *string { return &s }
+	tests := []struct {
+		name    string
+		before  *string
+		after   *string
+		changes []FieldChange
+		wantErr bool
+	}{
+		{
+			name:    "create has every field",
+			after:   strPtr(`{"Id":3,"Name":"rent","Price":12.5}`),
+			changes: []FieldChange{{Field: "Id", After: "3"}, {Field: "Name", After: "rent"}, {Field: "Price", After: "12.5"}},
+		},
+		{
+			name:    "only changed fields, sorted",
+			before:  strPtr(`{"Name":"rent","Price":12.5,"Year":2025}`),
+			after:   strPtr(`{"Year":2025,"Price":13,"Name":"Rent"}`),
+			changes: []FieldChange{{Field: "Name", Before: "rent", After: "Rent"}, {Field: "Price", Before: "12.5", After: "13"}},
+		},
+		{
+			name:    "timestamps aren't printed as floats",
+			before:  strPtr(`{"DeletedAt":null}`),
+			after:   strPtr(`{"DeletedAt":1748736000}`),
+			changes: []FieldChange{{Field: "DeletedAt", Before: "", After: "1748736000"}},
+		},
+		{
+			name:    "purge has nothing after",
+			before:  strPtr(`{"Name":"rent"}`),
+			changes: []FieldChange{{Field: "Name", Before: "rent"}},
+		},
+		{
+			name:    "no change",
+			before:  strPtr(`{"Name":"rent"}`),
+			after:   strPtr(`{"Name":"rent"}`),
+			changes: []FieldChange{},
+		},
+		{
+			name:    "broken json",
+			before:  strPtr(`{"Name":`),
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes, err := diffAuditJson(test.before, test.after)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(changes, test.changes) {
+				t.Errorf("expected %+v, got %+v", test.changes, changes)
+			}
+		})
+	}
+}
+
+func TestTransactionHistory(t *testing.T) {
+	f, userId := newTestFinance(t, "history")
+	otherId, err := f.DB.CreateUser("other", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := requestid.WithRequestId(context.Background(), "req-1")
+	bucketId, err := f.DB.CreateBucket(userId, "Home", database.AuditInfo{UserId: userId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err := f.SubmitNewTransaction(ctx, database.TransactionItemInput{Name: "rent", Month: 5, Year: 2025, Price: 900, IsExpense: true, UserId: userId, BucketId: bucketId, Currency: database.DEFAULT_CURRENCY})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	transactionId := 1
+	err = f.UpdateTransaction(ctx, TransactionEdit{TransactionId: transactionId, Name: "Rent", Month: 5, Year: 2025, Price: 950, BucketId: bucketId, Currency: database.DEFAULT_CURRENCY})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.DeleteTransaction(ctx, transactionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.RestoreTransaction(ctx, transactionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := f.TransactionHistory(userId, transactionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := []string{}
+	for _, h := range history {
+		actions = append(actions, h.Action)
+		if h.UserId != userId || h.RequestId != "req-1" {
+			t.Errorf("%s: expected user %d and request req-1, got %d %q", h.Action, userId, h.UserId, h.RequestId)
+		}
+	}
+	wantActions := []string{database.AUDIT_ACTION_CREATE, database.AUDIT_ACTION_UPDATE, database.AUDIT_ACTION_DELETE, database.AUDIT_ACTION_RESTORE}
+	if !reflect.DeepEqual(actions, wantActions) {
+		t.Fatalf("expected %v, got %v", wantActions, actions)
+	}
+	wantUpdate := []FieldChange{{Field: "Name", Before: "rent", After: "Rent"}, {Field: "Price", Before: "900", After: "950"}}
+	if !reflect.DeepEqual(history[1].Changes, wantUpdate) {
+		t.Errorf("update: expected %+v, got %+v", wantUpdate, history[1].Changes)
+	}
+	if len(history[2].Changes) != 1 || history[2].Changes[0].Field != "DeletedAt" || history[2].Changes[0].Before != "" {
+		t.Errorf("delete: expected only DeletedAt to be set, got %+v", history[2].Changes)
+	}
+	if len(history[3].Changes) != 1 || history[3].Changes[0].Field != "DeletedAt" || history[3].Changes[0].After != "" {
+		t.Errorf("restore: expected only DeletedAt to be cleared, got %+v", history[3].Changes)
+	}
+
+	// A failed change leaves no entry
+	err = f.UpdateTransaction(ctx, TransactionEdit{TransactionId: 99, Name: "ghost", Month: 5, Year: 2025, Price: 1, BucketId: bucketId, Currency: database.DEFAULT_CURRENCY})
+	if err == nil {
+		t.Error("update of a missing transaction: expected an error")
+	}
+	history, err = f.TransactionHistory(userId, 99)
+	if err != nil || len(history) != 0 {
+		t.Errorf("missing transaction: expected no history, got %+v %v", history, err)
+	}
+
+	// Only the owner sees the history
+	history, err = f.TransactionHistory(otherId, transactionId)
+	if err != nil || len(history) != 0 {
+		t.Errorf("other user: expected no history, got %+v %v", history, err)
+	}
+}
+
+func TestPurgeBucketAudit(t *testing.T) {
+	f, userId := newTestFinance(t, "purge")
+	ctx := context.Background()
+	bucketId, err := f.DB.CreateBucket(userId, "Travel", database.AuditInfo{UserId: userId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transactionIds := []int{}
+	for _, name := range []string{"flight", "hotel"} {
+		id, err := f.DB.CreateItemTransaction(database.TransactionItemInput{Name: name, Month: 5, Year: 2025, Price: 300, IsExpense: true, UserId: userId, BucketId: bucketId, Currency: database.DEFAULT_CURRENCY}, database.AuditInfo{UserId: userId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		transactionIds = append(transactionIds, id)
+	}
+	// One was already in the trash on its own
+	err = f.DeleteTransaction(ctx, transactionIds[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.DeleteBucket(ctx, bucketId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.PurgeBucket(ctx, bucketId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range transactionIds {
+		history, err := f.TransactionHistory(userId, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) == 0 {
+			t.Fatalf("transaction %d: expected history", id)
+		}
+		last := history[len(history)-1]
+		if last.Action != database.AUDIT_ACTION_PURGE {
+			t.Errorf("transaction %d: expected the last entry to be a purge, got %s", id, last.Action)
+		}
+		for _, c := range last.Changes {
+			if c.After != "" {
+				t.Errorf("transaction %d: expected nothing after the purge, got %s=%s", id, c.Field, c.After)
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package finance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+const (
+	// Bills due within this many days are listed in the notification center
+	BILL_REMINDER_DAYS = 7
+	// Unpaid due dates older than this are dropped from the reminders, they were most likely paid outside of Wonk
+	BILL_OVERDUE_MONTHS = 3
+)
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Due dates of the bill from when it was added, or BILL_OVERDUE_MONTHS ago if later, up to BILL_REMINDER_DAYS from today
+func billDueDates(bill database.Bill, now time.Time) []time.Time {
+	today := startOfDay(now)
+	start := startOfDay(time.Unix(bill.CreatedAt, 0).In(now.Location()))
+	if earliest := today.AddDate(0, -BILL_OVERDUE_MONTHS, 0); start.Before(earliest) {
+		start = earliest
+	}
+	cutoff := today.AddDate(0, 0, BILL_REMINDER_DAYS)
+	dates := []time.Time{}
+	month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, now.Location())
+	for {
+		due := dayInMonth(bill.DueDay, month.Year(), month.Month(), now.Location())
+		if due.After(cutoff) {
+			break
+		}
+		if !due.Before(start) {
+			dates = append(dates, due)
+		}
+		month = month.AddDate(0, 1, 0)
+	}
+	return dates
+}
+
+func billPaymentKey(billId int, dueDate string) string {
+	return strconv.Itoa(billId) + "|" + dueDate
+}
+
+// Unpaid due dates of every bill, overdue ones first
+func billReminders(bills []database.Bill, payments []database.BillPayment, bucketNames map[int]string, now time.Time) []BillReminder {
+	paid := map[string]bool{}
+	for _, p := range payments {
+		paid[billPaymentKey(p.BillId, p.DueDate)] = true
+	}
+	today := startOfDay(now)
+	reminders := []BillReminder{}
+	for _, b := range bills {
+		for _, due := range billDueDates(b, now) {
+			if paid[billPaymentKey(b.Id, due.Format(database.RATE_DATE_FORMAT))] {
+				continue
+			}
+			reminders = append(reminders, BillReminder{
+				Bill:       b,
+				BucketName: bucketNames[b.BucketId],
+				DueDate:    due,
+				Overdue:    due.Before(today),
+			})
+		}
+	}
+	sort.SliceStable(reminders, func(i, j int) bool {
+		return reminders[i].DueDate.Before(reminders[j].DueDate)
+	})
+	return reminders
+}
+
+// Bills with their next due date and the unpaid due dates to remind about
+func (f *FinanceLogic) Bills(userId int, now time.Time) (*BillOverview, error) {
+	bills, err := f.DB.Bills(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Bills: db: %w", err)
+	}
+	payments, err := f.DB.BillPayments(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Bills: db: %w", err)
+	}
+	buckets, err := f.DB.UserBuckets(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Bills: db: %w", err)
+	}
+	bucketNames := map[int]string{}
+	for _, b := range buckets {
+		bucketNames[b.Id] = b.Name
+	}
+	lastPaid := map[int]string{}
+	for _, p := range payments {
+		// Payments are sorted by due date so the last one wins
+		lastPaid[p.BillId] = p.DueDate
+	}
+
+	overview := &BillOverview{Reminders: billReminders(bills, payments, bucketNames, now)}
+	for _, b := range bills {
+		overview.Bills = append(overview.Bills, BillStatus{
+			Bill:       b,
+			BucketName: bucketNames[b.BucketId],
+			NextDue:    nextDueDate(database.RecurringItem{DayOfMonth: b.DueDay}, now),
+			LastPaid:   lastPaid[b.Id],
+		})
+	}
+	return overview, nil
+}
+
+func (f *FinanceLogic) BillReminders(userId int, now time.Time) ([]BillReminder, error) {
+	overview, err := f.Bills(userId, now)
+	if err != nil {
+		return nil, fmt.Errorf("BillReminders: %w", err)
+	}
+	return overview.Reminders, nil
+}
+
+func (f *FinanceLogic) AddBill(userId int, input database.BillInput) (map[string]string, error) {
+	input.UserId = userId
+	input.CreatedAt = time.Now().Unix()
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	problems, err := f.userBucketProblem(userId, input.BucketId)
+	if err != nil {
+		return nil, fmt.Errorf("AddBill: db: %w", err)
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	_, err = f.DB.CreateBill(input)
+	if err != nil {
+		return nil, fmt.Errorf("AddBill: db: %w", err)
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) DeleteBill(userId, billId int) error {
+	rowsChanged, err := f.DB.BillDelete(billId, userId)
+	if err != nil {
+		return fmt.Errorf("DeleteBill: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return fmt.Errorf("DeleteBill: %w", cuserr.NotFound{Item: "bill"})
+	}
+	return nil
+}
+
+// Marks one due date of the bill paid by creating the matching expense in the bill's bucket for the due date's month.
+// Only the bill's own due dates up to BILL_REMINDER_DAYS ahead can be paid, and each only once.
+func (f *FinanceLogic) PayBill(ctx context.Context, userId, billId int, dueDate string, now time.Time) (map[string]string, error) {
+	bill, err := f.DB.BillById(billId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("PayBill: db: %w", err)
+	}
+	due, err := time.ParseInLocation(database.RATE_DATE_FORMAT, dueDate, now.Location())
+	if err != nil {
+		return map[string]string{"DueDate": "Date must be YYYY-MM-DD"}, nil
+	}
+	if !due.Equal(dayInMonth(bill.DueDay, due.Year(), due.Month(), now.Location())) {
+		return map[string]string{"DueDate": "Not a due date of this bill"}, nil
+	}
+	if due.After(startOfDay(now).AddDate(0, 0, BILL_REMINDER_DAYS)) {
+		return map[string]string{"DueDate": "Bills can be paid at most " + strconv.Itoa(BILL_REMINDER_DAYS) + " days ahead"}, nil
+	}
+	payments, err := f.DB.BillPayments(userId)
+	if err != nil {
+		return nil, fmt.Errorf("PayBill: db: %w", err)
+	}
+	for _, p := range payments {
+		if p.BillId == billId && p.DueDate == dueDate {
+			return map[string]string{"DueDate": "Already paid"}, nil
+		}
+	}
+
+	transaction := database.TransactionItemInput{
+		Name:      bill.Name,
+		Month:     int(due.Month()),
+		Year:      due.Year(),
+		Price:     bill.Amount,
+		IsExpense: true,
+		UserId:    userId,
+		BucketId:  bill.BucketId,
+		Currency:  bill.Currency,
+	}
+	problems := transaction.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	problems, err = f.userBucketProblem(userId, bill.BucketId)
+	if err != nil {
+		return nil, fmt.Errorf("PayBill: db: %w", err)
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	payment := database.BillPaymentInput{
+		BillId:  billId,
+		UserId:  userId,
+		DueDate: dueDate,
+	}
+	_, err = f.DB.PayBill(payment, transaction, auditInfo(ctx, userId))
+	if err != nil {
+		return nil, fmt.Errorf("PayBill: db: %w", err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,142 @@
+package finance
+
+import (
+	"context"
+	"testing"
+	"time"
+	"wonk/storage"
+)
+
+func TestBillReminders(t *testing.T) {
+	now := time.Date(2025, 3, 28, 15, 0, 0, 0, time.UTC)
+	bills := []database.Bill{
+		// Added long ago so only the last BILL_OVERDUE_MONTHS of due dates count
+		{Id: 1, Name: "rent", DueDay: 1, CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()},
+		// Added after February's due date so that one isn't owed
+		{Id: 2, Name: "phone", DueDay: 20, CreatedAt: time.Date(2025, 2, 25, 0, 0, 0, 0, time.UTC).Unix()},
+		// Falls on the last day of short months
+		{Id: 3, Name: "card", DueDay: 31, CreatedAt: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC).Unix()},
+	}
+	payments := []database.BillPayment{
+		{BillId: 1, DueDate: "2025-01-01"},
+		{BillId: 1, DueDate: "2025-03-01"},
+	}
+	got := billReminders(bills, payments, map[int]string{}, now)
+	want := []struct {
+		billId  int
+		due     string
+		overdue bool
+	}{
+		{1, "2025-02-01", true},
+		{2, "2025-03-20", true},
+		{3, "2025-03-31", false},
+		{1, "2025-04-01", false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d reminders, got %+v", len(want), got)
+	}
+	for i, w := range want {
+		r := got[i]
+		if r.Bill.Id != w.billId || r.DueDate.Format(database.RATE_DATE_FORMAT) != w.due || r.Overdue != w.overdue {
+			t.Errorf("%d: expected bill %d due %s overdue %v, got bill %d due %s overdue %v", i, w.billId, w.due, w.overdue, r.Bill.Id, r.DueDate.Format(database.RATE_DATE_FORMAT), r.Overdue)
+		}
+	}
+}
+
+func TestPayBill(t *testing.T) {
+	f, userId := newTestFinance(t, "bills")
+	db := f.DB
+	ctx := context.Background()
+	bucketId, err := db.CreateBucket(userId, "Housing", database.AuditInfo{UserId: userId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err := f.AddBill(userId, database.BillInput{BucketId: bucketId, Name: "Rent", Amount: 1200, Currency: "USD", DueDay: 1})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	bills, err := db.Bills(userId)
+	if err != nil || len(bills) != 1 {
+		t.Fatal(bills, err)
+	}
+	billId := bills[0].Id
+	now := time.Now()
+	tooFar := dayInMonth(1, now.Year(), now.Month(), now.Location()).AddDate(0, 2, 0).Format(database.RATE_DATE_FORMAT)
+
+	tests := []struct {
+		name        string
+		dueDate     string
+		wantProblem bool
+	}{
+		{name: "not the due day", dueDate: now.Format("2006-01") + "-02", wantProblem: true},
+		{name: "too far ahead", dueDate: tooFar, wantProblem: true},
+		{name: "this month", dueDate: now.Format("2006-01") + "-01"},
+		{name: "paid twice", dueDate: now.Format("2006-01") + "-01", wantProblem: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := f.PayBill(ctx, userId, billId, tt.dueDate, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantProblem != (len(problems) > 0) {
+				t.Errorf("expected problems %v, got %v", tt.wantProblem, problems)
+			}
+		})
+	}
+
+	transactions, err := db.TransactionsInBucket(bucketId, int(now.Month()), now.Year())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 1 || transactions[0].Name != "Rent" || transactions[0].Price != 1200 || !transactions[0].IsExpense {
+		t.Errorf("expected one rent expense of 1200, got %+v", transactions)
+	}
+	reminders, err := f.BillReminders(userId, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range reminders {
+		if r.DueDate.Format(database.RATE_DATE_FORMAT) == now.Format("2006-01")+"-01" {
+			t.Errorf("expected this month's rent to no longer be reminded about, got %+v", r)
+		}
+	}
+
+	// Deleting the payment's transaction marks the due date unpaid again, and it can be paid again
+	// after the transaction is purged too
+	due := now.Format("2006-01") + "-01"
+	for _, purge := range []bool{false, true} {
+		transactions, err = db.TransactionsInBucket(bucketId, int(now.Month()), now.Year())
+		if err != nil || len(transactions) != 1 {
+			t.Fatal(transactions, err)
+		}
+		err = f.DeleteTransaction(ctx, transactions[0].Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if purge {
+			err = f.PurgeTransaction(ctx, transactions[0].Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		payments, err := db.BillPayments(userId)
+		if err != nil || len(payments) != 0 {
+			t.Errorf("purge %v: expected the payment to be gone with its transaction, got %+v %v", purge, payments, err)
+		}
+		problems, err := f.PayBill(ctx, userId, billId, due, now)
+		if err != nil || len(problems) > 0 {
+			t.Fatalf("purge %v: expected to pay again, got %v %v", purge, problems, err)
+		}
+	}
+
+	// Bills of a deleted bucket can't be paid so they aren't shown
+	err = f.DeleteBucket(ctx, bucketId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bills, err = db.Bills(userId)
+	if err != nil || len(bills) != 0 {
+		t.Errorf("deleted bucket: expected no bills, got %+v %v", bills, err)
+	}
+}
@@ -0,0 +1,158 @@
+package finance
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+// Recurring items due within this many days show up as upcoming
+const UPCOMING_DAYS = 30
+
+// Checks the bucket exists and belongs to the user, problems are keyed by BucketId
+func (f *FinanceLogic) userBucketProblem(userId, bucketId int) (map[string]string, error) {
+	bucket, err := f.DB.BucketById(bucketId)
+	if err != nil {
+		var notFoundErr cuserr.NotFound
+		if errors.As(err, &notFoundErr) {
+			return map[string]string{"BucketId": "Bucket doesn't exist"}, nil
+		}
+		return nil, err
+	}
+	if bucket.UserId != userId {
+		return map[string]string{"BucketId": "Bucket doesn't exist"}, nil
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) Budgets(userId int) ([]database.Budget, error) {
+	budgets, err := f.DB.Budgets(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Budgets: db: %w", err)
+	}
+	return budgets, nil
+}
+
+func (f *FinanceLogic) SetBudget(userId int, input database.BudgetInput) (map[string]string, error) {
+	input.UserId = userId
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	problems, err := f.userBucketProblem(userId, input.BucketId)
+	if err != nil {
+		return nil, fmt.Errorf("SetBudget: db: %w", err)
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	err = f.DB.UpsertBudget(input)
+	if err != nil {
+		return nil, fmt.Errorf("SetBudget: db: %w", err)
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) DeleteBudget(userId, budgetId int) error {
+	rowsChanged, err := f.DB.BudgetDelete(budgetId, userId)
+	if err != nil {
+		return fmt.Errorf("DeleteBudget: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return fmt.Errorf("DeleteBudget: %w", cuserr.NotFound{Item: "budget"})
+	}
+	return nil
+}
+
+func (f *FinanceLogic) RecurringItems(userId int) ([]database.RecurringItem, error) {
+	items, err := f.DB.RecurringItems(userId)
+	if err != nil {
+		return nil, fmt.Errorf("RecurringItems: db: %w", err)
+	}
+	return items, nil
+}
+
+func (f *FinanceLogic) AddRecurringItem(userId int, input database.RecurringItemInput) (map[string]string, error) {
+	input.UserId = userId
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	problems, err := f.userBucketProblem(userId, input.BucketId)
+	if err != nil {
+		return nil, fmt.Errorf("AddRecurringItem: db: %w", err)
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	_, err = f.DB.CreateRecurringItem(input)
+	if err != nil {
+		return nil, fmt.Errorf("AddRecurringItem: db: %w", err)
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) DeleteRecurringItem(userId, itemId int) error {
+	rowsChanged, err := f.DB.RecurringItemDelete(itemId, userId)
+	if err != nil {
+		return fmt.Errorf("DeleteRecurringItem: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return fmt.Errorf("DeleteRecurringItem: %w", cuserr.NotFound{Item: "recurring item"})
+	}
+	return nil
+}
+
+// The date a monthly day falls on in the given month, days past the end of the month use the last day
+func dayInMonth(day, year int, month time.Month, loc *time.Location) time.Time {
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+	return time.Date(year, month, min(day, lastDay), 0, 0, 0, 0, loc)
+}
+
+func recurringDate(item database.RecurringItem, year int, month time.Month, loc *time.Location) time.Time {
+	return dayInMonth(item.DayOfMonth, year, month, loc)
+}
+
+// The next date on or after today that the item is due
+func nextDueDate(item database.RecurringItem, now time.Time) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	due := recurringDate(item, today.Year(), today.Month(), today.Location())
+	if due.Before(today) {
+		nextMonth := today.AddDate(0, 0, -today.Day()+1).AddDate(0, 1, 0)
+		due = recurringDate(item, nextMonth.Year(), nextMonth.Month(), today.Location())
+	}
+	return due
+}
+
+// Recurring items due in the next UPCOMING_DAYS days sorted by due date
+func (f *FinanceLogic) UpcomingRecurring(userId int, now time.Time) ([]UpcomingItem, error) {
+	items, err := f.DB.RecurringItems(userId)
+	if err != nil {
+		return nil, fmt.Errorf("UpcomingRecurring: db: %w", err)
+	}
+	buckets, err := f.DB.UserBuckets(userId)
+	if err != nil {
+		return nil, fmt.Errorf("UpcomingRecurring: db: %w", err)
+	}
+	bucketNames := map[int]string{}
+	for _, b := range buckets {
+		bucketNames[b.Id] = b.Name
+	}
+
+	cutoff := now.AddDate(0, 0, UPCOMING_DAYS)
+	upcoming := []UpcomingItem{}
+	for _, item := range items {
+		due := nextDueDate(item, now)
+		if due.After(cutoff) {
+			continue
+		}
+		upcoming = append(upcoming, UpcomingItem{Item: item, BucketName: bucketNames[item.BucketId], DueDate: due})
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		return upcoming[i].DueDate.Before(upcoming[j].DueDate)
+	})
+	return upcoming, nil
+}
@@ -0,0 +1,133 @@
+package finance
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+const (
+	CALENDAR_TOKEN_BYTES = 32
+)
+
+func hashCalendarToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// The user's feed, or nil when they haven't turned it on
+func (f *FinanceLogic) CalendarFeedStatus(userId int) (*database.CalendarFeed, error) {
+	feed, err := f.DB.CalendarFeed(userId)
+	if err != nil {
+		var notFoundErr cuserr.NotFound
+		if errors.As(err, &notFoundErr) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("CalendarFeedStatus: db: %w", err)
+	}
+	return feed, nil
+}
+
+// Makes a new feed token, the old one stops working. Only the hash is saved
+// so the returned token can't be shown again.
+func (f *FinanceLogic) RotateCalendarToken(userId int) (string, error) {
+	b := make([]byte, CALENDAR_TOKEN_BYTES)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("RotateCalendarToken: rand: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	err = f.DB.UpsertCalendarFeed(database.CalendarFeedInput{
+		UserId:    userId,
+		TokenHash: hashCalendarToken(token),
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("RotateCalendarToken: db: %w", err)
+	}
+	return token, nil
+}
+
+func (f *FinanceLogic) DisableCalendarFeed(userId int) error {
+	rowsChanged, err := f.DB.CalendarFeedDelete(userId)
+	if err != nil {
+		return fmt.Errorf("DisableCalendarFeed: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return fmt.Errorf("DisableCalendarFeed: %w", cuserr.NotFound{Item: "calendar feed"})
+	}
+	return nil
+}
+
+// Monthly events for the bills and recurring items of the user the token belongs to.
+// Returns NotFound for an unknown token.
+func (f *FinanceLogic) CalendarEvents(token string, now time.Time) ([]CalendarEvent, error) {
+	if len(token) == 0 {
+		return nil, fmt.Errorf("CalendarEvents: %w", cuserr.NotFound{Item: "calendar feed"})
+	}
+	feed, err := f.DB.CalendarFeedByTokenHash(hashCalendarToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("CalendarEvents: db: %w", err)
+	}
+	userId := feed.UserId
+	buckets, err := f.DB.UserBuckets(userId)
+	if err != nil {
+		return nil, fmt.Errorf("CalendarEvents: db: %w", err)
+	}
+	bucketNames := map[int]string{}
+	for _, b := range buckets {
+		bucketNames[b.Id] = b.Name
+	}
+	bills, err := f.DB.Bills(userId)
+	if err != nil {
+		return nil, fmt.Errorf("CalendarEvents: db: %w", err)
+	}
+	items, err := f.DB.RecurringItems(userId)
+	if err != nil {
+		return nil, fmt.Errorf("CalendarEvents: db: %w", err)
+	}
+
+	events := []CalendarEvent{}
+	for _, b := range bills {
+		events = append(events, CalendarEvent{
+			Uid:         "bill-" + strconv.Itoa(b.Id) + "@wonk",
+			Summary:     fmt.Sprintf("Bill: %s (%.2f %s)", b.Name, b.Amount, b.Currency),
+			Description: "Bucket: " + bucketNames[b.BucketId],
+			Start:       firstBillDueDate(b, now.Location()),
+			DayOfMonth:  b.DueDay,
+		})
+	}
+	// Recurring items don't keep when they were added so the series starts this month
+	for _, item := range items {
+		kind := "Income"
+		if item.IsExpense {
+			kind = "Expense"
+		}
+		events = append(events, CalendarEvent{
+			Uid:         "recurring-" + strconv.Itoa(item.Id) + "@wonk",
+			Summary:     fmt.Sprintf("%s: %s (%.2f %s)", kind, item.Name, item.Price, item.Currency),
+			Description: "Bucket: " + bucketNames[item.BucketId],
+			Start:       recurringDate(item, now.Year(), now.Month(), now.Location()),
+			DayOfMonth:  item.DayOfMonth,
+		})
+	}
+	return events, nil
+}
+
+// The first due date on or after the day the bill was added
+func firstBillDueDate(bill database.Bill, loc *time.Location) time.Time {
+	created := startOfDay(time.Unix(bill.CreatedAt, 0).In(loc))
+	due := dayInMonth(bill.DueDay, created.Year(), created.Month(), loc)
+	if due.Before(created) {
+		nextMonth := time.Date(created.Year(), created.Month()+1, 1, 0, 0, 0, 0, loc)
+		due = dayInMonth(bill.DueDay, nextMonth.Year(), nextMonth.Month(), loc)
+	}
+	return due
+}
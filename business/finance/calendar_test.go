@@ -0,0 +1,94 @@
+package finance
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+func TestFirstBillDueDate(t *testing.T) {
+	tests := []struct {
+		name    string
+		created time.Time
+		dueDay  int
+		want    string
+	}{
+		{"later this month", time.Date(2025, 3, 5, 18, 0, 0, 0, time.UTC), 10, "2025-03-10"},
+		{"added on the due day", time.Date(2025, 3, 10, 18, 0, 0, 0, time.UTC), 10, "2025-03-10"},
+		{"already passed", time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC), 10, "2025-04-10"},
+		{"short month", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), 31, "2025-02-28"},
+		{"into next year", time.Date(2025, 12, 31, 12, 0, 0, 0, time.UTC), 1, "2026-01-01"},
+	}
+	for _, tt := range tests {
+		bill := database.Bill{DueDay: tt.dueDay, CreatedAt: tt.created.Unix()}
+		got := firstBillDueDate(bill, time.UTC).Format(database.RATE_DATE_FORMAT)
+		if got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestCalendarEvents(t *testing.T) {
+	f, userId := newTestFinance(t, "calendar")
+	db := f.DB
+	now := time.Now()
+	bucketId, err := db.CreateBucket(userId, "Housing", database.AuditInfo{UserId: userId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err := f.AddBill(userId, database.BillInput{BucketId: bucketId, Name: "Rent", Amount: 1200, Currency: "USD", DueDay: 1})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	problems, err = f.AddRecurringItem(userId, database.RecurringItemInput{BucketId: bucketId, Name: "Sublet", Price: 300, Currency: "USD", DayOfMonth: 31})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+
+	feed, err := f.CalendarFeedStatus(userId)
+	if err != nil || feed != nil {
+		t.Fatalf("expected no feed before turning it on, got %+v %v", feed, err)
+	}
+	oldToken, err := f.RotateCalendarToken(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := f.RotateCalendarToken(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == oldToken {
+		t.Fatal("expected a new token")
+	}
+
+	for _, bad := range []string{oldToken, "", "not-a-token"} {
+		_, err = f.CalendarEvents(bad, now)
+		if !errors.As(err, &cuserr.NotFound{}) {
+			t.Errorf("expected not found for token %q, got %v", bad, err)
+		}
+	}
+	events, err := f.CalendarEvents(token, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected a bill and a recurring event, got %+v", events)
+	}
+	if events[0].Summary != "Bill: Rent (1200.00 USD)" || events[0].DayOfMonth != 1 || events[0].Description != "Bucket: Housing" {
+		t.Errorf("unexpected bill event %+v", events[0])
+	}
+	if events[1].Summary != "Income: Sublet (300.00 USD)" || events[1].DayOfMonth != 31 || events[1].Start.Month() != now.Month() {
+		t.Errorf("unexpected recurring event %+v", events[1])
+	}
+
+	err = f.DisableCalendarFeed(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.CalendarEvents(token, now)
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Errorf("expected not found after turning the feed off, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package finance
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// One row of an imported CSV, problems are keyed by field like an input's Valid
+type csvRow struct {
+	record   []string
+	columns  map[string]int
+	problems map[string]string
+}
+
+func (r csvRow) value(column string) string {
+	return strings.TrimSpace(r.record[r.columns[column]])
+}
+
+// Parses the column as a decimal, a problem is added for field when it isn't one
+func (r csvRow) decimal(column, field string) float64 {
+	v, err := strconv.ParseFloat(r.value(column), 64)
+	if err != nil {
+		r.problems[field] = field + " is not a decimal"
+	}
+	return v
+}
+
+// Adds an input's validation problems, a field that already has a problem keeps its own
+func (r csvRow) addProblems(problems map[string]string) {
+	for field, msg := range problems {
+		if _, ok := r.problems[field]; !ok {
+			r.problems[field] = msg
+		}
+	}
+}
+
+func (r csvRow) ok() bool {
+	return len(r.problems) == 0
+}
+
+// Every problem of the row sorted by field, e.g. "Rate: Rate is not a decimal; RateDate: Date must be YYYY-MM-DD"
+func (r csvRow) problemsText() string {
+	fields := []string{}
+	for field := range r.problems {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	msgs := []string{}
+	for _, field := range fields {
+		msgs = append(msgs, field+": "+r.problems[field])
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Reads a CSV whose header names at least the given columns, in any order and case, and passes
+// each row to parse. Problems are keyed by line number, or by File when the file as a whole
+// can't be used. Returns no problems when every row parsed.
+func readCsvImport(file io.Reader, columns []string, maxRows int, parse func(row csvRow)) map[string]string {
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return map[string]string{"File": "File is empty"}
+		}
+		return map[string]string{"File": "Invalid CSV: " + err.Error()}
+	}
+	index := map[string]int{}
+	for i, h := range header {
+		index[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, name := range columns {
+		if _, ok := index[name]; !ok {
+			return map[string]string{"File": "Missing column: " + name}
+		}
+	}
+
+	problems := make(map[string]string)
+	rows := 0
+	line := 1
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line++
+		lineKey := "Line " + strconv.Itoa(line)
+		if err != nil {
+			problems[lineKey] = "Invalid CSV: " + err.Error()
+			continue
+		}
+		if rows >= maxRows {
+			problems["File"] = "Too many rows, the limit is " + strconv.Itoa(maxRows)
+			break
+		}
+		rows++
+		row := csvRow{record: record, columns: index, problems: map[string]string{}}
+		parse(row)
+		if !row.ok() {
+			problems[lineKey] = row.problemsText()
+		}
+	}
+	return problems
+}
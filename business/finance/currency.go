@@ -0,0 +1,178 @@
+package finance
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+const (
+	MAX_RATE_IMPORT_ROWS = 5000
+)
+
+// Currencies offered in the dropdowns, any ISO 4217 code is accepted by the db
+var CURRENCIES = []string{"USD", "EUR", "MXN", "JPY", "GBP", "CAD"}
+
+// rateTable converts amounts into a base currency using stored exchange rates
+type rateTable struct {
+	base  string
+	rates map[string][]database.ExchangeRate // sorted by RateDate ascending
+}
+
+func newRateTable(base string, rates []database.ExchangeRate) rateTable {
+	r := rateTable{base: base, rates: map[string][]database.ExchangeRate{}}
+	for _, rate := range rates {
+		r.rates[rate.Currency] = append(r.rates[rate.Currency], rate)
+	}
+	for c := range r.rates {
+		sort.Slice(r.rates[c], func(i, j int) bool {
+			return r.rates[c][i].RateDate < r.rates[c][j].RateDate
+		})
+	}
+	return r
+}
+
+// Converts the amount using the latest rate dated on or before the end of the given month.
+// ok is false when there isn't a usable rate.
+func (r rateTable) convert(amount float64, currency string, month, year int) (float64, bool) {
+	if currency == r.base || currency == "" {
+		return amount, true
+	}
+	// Transactions only track the month, so any rate up to the last day of the month applies
+	monthEnd := time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Format(database.RATE_DATE_FORMAT)
+	rates := r.rates[currency]
+	idx := sort.Search(len(rates), func(i int) bool {
+		return rates[i].RateDate > monthEnd
+	})
+	if idx == 0 {
+		return 0, false
+	}
+	return amount * rates[idx-1].Rate, true
+}
+
+func (f *FinanceLogic) userRateTable(userId int) (*rateTable, error) {
+	base, err := f.DB.UserBaseCurrency(userId)
+	if err != nil {
+		return nil, fmt.Errorf("userRateTable: %w", err)
+	}
+	rates, err := f.DB.ExchangeRates(userId, base)
+	if err != nil {
+		return nil, fmt.Errorf("userRateTable: %w", err)
+	}
+	r := newRateTable(base, rates)
+	return &r, nil
+}
+
+func convertTransaction(r *rateTable, t database.TransactionItem) ConvertedTransaction {
+	c := ConvertedTransaction{
+		TransactionItem: t,
+		BaseCurrency:    r.base,
+	}
+	price, ok := r.convert(t.Price, t.Currency, t.Month, t.Year)
+	if ok {
+		c.ConvertedPrice = &price
+	}
+	return c
+}
+
+func (f *FinanceLogic) ConvertTransaction(userId int, t database.TransactionItem) (*ConvertedTransaction, error) {
+	r, err := f.userRateTable(userId)
+	if err != nil {
+		return nil, fmt.Errorf("ConvertTransaction: %w", err)
+	}
+	c := convertTransaction(r, t)
+	return &c, nil
+}
+
+func (f *FinanceLogic) BaseCurrency(userId int) (string, error) {
+	base, err := f.DB.UserBaseCurrency(userId)
+	if err != nil {
+		return "", fmt.Errorf("BaseCurrency: %w", err)
+	}
+	return base, nil
+}
+
+func (f *FinanceLogic) SetBaseCurrency(userId int, currency string) error {
+	if !database.IsCurrencyCode(currency) {
+		return fmt.Errorf("SetBaseCurrency: %w", cuserr.InvalidInput{FieldName: "currency", Reason: "it isn't a currency code"})
+	}
+	_, err := f.DB.UserUpdateBaseCurrency(userId, currency)
+	if err != nil {
+		return fmt.Errorf("SetBaseCurrency: db: %w", err)
+	}
+	return nil
+}
+
+// Returns the rates that convert into the user's current base currency
+func (f *FinanceLogic) ExchangeRates(userId int) ([]database.ExchangeRate, error) {
+	base, err := f.DB.UserBaseCurrency(userId)
+	if err != nil {
+		return nil, fmt.Errorf("ExchangeRates: %w", err)
+	}
+	rates, err := f.DB.ExchangeRates(userId, base)
+	if err != nil {
+		return nil, fmt.Errorf("ExchangeRates: %w", err)
+	}
+	return rates, nil
+}
+
+func (f *FinanceLogic) AddExchangeRate(userId int, input database.ExchangeRateInput) (map[string]string, error) {
+	base, err := f.DB.UserBaseCurrency(userId)
+	if err != nil {
+		return nil, fmt.Errorf("AddExchangeRate: %w", err)
+	}
+	input.UserId = userId
+	input.BaseCurrency = base
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	err = f.DB.UpsertExchangeRate(input)
+	if err != nil {
+		return nil, fmt.Errorf("AddExchangeRate: db: %w", err)
+	}
+	return nil, nil
+}
+
+// Imports a rates CSV with the header: date,currency,base,rate
+// Rows are only saved when the whole file is valid, problems are keyed by line number.
+func (f *FinanceLogic) ImportExchangeRates(userId int, file io.Reader) (int, map[string]string, error) {
+	inputs := []database.ExchangeRateInput{}
+	problems := readCsvImport(file, []string{"date", "currency", "base", "rate"}, MAX_RATE_IMPORT_ROWS, func(row csvRow) {
+		input := database.ExchangeRateInput{
+			UserId:       userId,
+			Currency:     strings.ToUpper(row.value("currency")),
+			BaseCurrency: strings.ToUpper(row.value("base")),
+			Rate:         row.decimal("rate", "Rate"),
+			RateDate:     row.value("date"),
+		}
+		row.addProblems(input.Valid())
+		if row.ok() {
+			inputs = append(inputs, input)
+		}
+	})
+	if len(problems) > 0 {
+		return 0, problems, nil
+	}
+
+	err := f.DB.UpsertExchangeRates(inputs)
+	if err != nil {
+		return 0, nil, fmt.Errorf("ImportExchangeRates: db: %w", err)
+	}
+	return len(inputs), nil, nil
+}
+
+func (f *FinanceLogic) DeleteExchangeRate(userId, rateId int) error {
+	rowsChanged, err := f.DB.ExchangeRateDelete(rateId, userId)
+	if err != nil {
+		return fmt.Errorf("DeleteExchangeRate: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return fmt.Errorf("DeleteExchangeRate: %w", cuserr.NotFound{Item: "exchange rate"})
+	}
+	return nil
+}
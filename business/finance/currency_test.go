@@ -0,0 +1,134 @@
+package finance
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"wonk/storage"
+)
+
+func TestRateTableConvert(t *testing.T) {
+	// Out of order on purpose, the table sorts them by date
+	rates := newRateTable("USD", []database.ExchangeRate{
+		{Currency: "EUR", BaseCurrency: "USD", Rate: 1.25, RateDate: "2025-03-31"},
+		{Currency: "EUR", BaseCurrency: "USD", Rate: 1.1, RateDate: "2025-01-15"},
+		{Currency: "EUR", BaseCurrency: "USD", Rate: 1.2, RateDate: "2025-03-01"},
+		{Currency: "MXN", BaseCurrency: "USD", Rate: 0.05, RateDate: "2025-06-01"},
+		{Currency: "CAD", BaseCurrency: "USD", Rate: 0.75, RateDate: "2024-02-29"},
+	})
+	tests := []struct {
+		name     string
+		currency string
+		month    int
+		year     int
+		want     float64
+		ok       bool
+	}{
+		{name: "same currency", currency: "USD", month: 1, year: 2000, want: 100, ok: true},
+		{name: "no currency is the base", currency: "", month: 1, year: 2000, want: 100, ok: true},
+		{name: "rate earlier in the month", currency: "EUR", month: 1, year: 2025, want: 110, ok: true},
+		{name: "latest rate before the month", currency: "EUR", month: 2, year: 2025, want: 110, ok: true},
+		{name: "rate on the last day of the month", currency: "EUR", month: 3, year: 2025, want: 125, ok: true},
+		{name: "last rate keeps applying", currency: "EUR", month: 12, year: 2026, want: 125, ok: true},
+		{name: "leap day is the month end", currency: "CAD", month: 2, year: 2024, want: 75, ok: true},
+		{name: "before the first rate", currency: "EUR", month: 12, year: 2024, ok: false},
+		{name: "first rate is after the month", currency: "MXN", month: 5, year: 2025, ok: false},
+		{name: "no rates for the currency", currency: "GBP", month: 5, year: 2025, ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := rates.convert(100, test.currency, test.month, test.year)
+			if ok != test.ok {
+				t.Fatalf("expected ok %v, got %v", test.ok, ok)
+			}
+			if ok && math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestImportExchangeRates(t *testing.T) {
+	tooMany := strings.Builder{}
+	tooMany.WriteString("date,currency,base,rate\n")
+	for i := 0; i <= MAX_RATE_IMPORT_ROWS; i++ {
+		tooMany.WriteString("2025-01-01,EUR,USD,1.1\n")
+	}
+	tests := []struct {
+		name     string
+		file     string
+		count    int
+		problems map[string]string
+		saved    int
+	}{
+		{
+			name:  "columns in any order and case",
+			file:  "Rate, Currency ,BASE,date\n1.1,eur,usd,2025-01-01\n0.05,MXN,USD,2025-01-01\n",
+			count: 2,
+			saved: 2,
+		},
+		{
+			name:     "one bad row saves nothing",
+			file:     "date,currency,base,rate\n2025-01-01,EUR,USD,1.1\n2025-01-01,MXN,USD,cheap\n",
+			problems: map[string]string{"Line 3": "Rate: Rate is not a decimal"},
+		},
+		{
+			name: "every problem of a line is reported",
+			file: "date,currency,base,rate\n2025-13-01,usd,usd,0\n2025-01-01,EURO,USD,cheap\n",
+			problems: map[string]string{
+				"Line 2": "Currency: Currency must be different from the base currency; Rate: Rate must be greater than 0; RateDate: Date must be YYYY-MM-DD",
+				"Line 3": "Currency: Invalid Currency; Rate: Rate is not a decimal",
+			},
+		},
+		{
+			name: "every bad line is reported",
+			file: "date,currency,base,rate\n01/02/2025,EUR,USD,1.1\n2025-01-01,USD,USD,1\n2025-01-01,GBP,USD,0\n2025-01-01,JPY,USD\n",
+			problems: map[string]string{
+				"Line 2": "RateDate: Date must be YYYY-MM-DD",
+				"Line 3": "Currency: Currency must be different from the base currency",
+				"Line 4": "Rate: Rate must be greater than 0",
+				"Line 5": "Invalid CSV: record on line 5: wrong number of fields",
+			},
+		},
+		{
+			name:     "missing column",
+			file:     "date,currency,rate\n2025-01-01,EUR,1.1\n",
+			problems: map[string]string{"File": "Missing column: base"},
+		},
+		{
+			name:     "empty file",
+			file:     "",
+			problems: map[string]string{"File": "File is empty"},
+		},
+		{
+			name:     "too many rows",
+			file:     tooMany.String(),
+			problems: map[string]string{"File": "Too many rows, the limit is 5000"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, userId := newTestFinance(t, "rates")
+			count, problems, err := f.ImportExchangeRates(userId, strings.NewReader(test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != test.count {
+				t.Errorf("expected %d imported, got %d", test.count, count)
+			}
+			if len(problems) > 0 || len(test.problems) > 0 {
+				if !reflect.DeepEqual(problems, test.problems) {
+					t.Errorf("expected problems %v, got %v", test.problems, problems)
+				}
+			}
+			saved, err := f.ExchangeRates(userId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != test.saved {
+				t.Errorf("expected %d saved, got %d", test.saved, len(saved))
+			}
+		})
+	}
+}
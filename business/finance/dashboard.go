@@ -0,0 +1,101 @@
+package finance
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// How much of each budget the current month has used compared to how much of the month has passed
+func (f *FinanceLogic) BudgetBurnDown(userId int, now time.Time) ([]BudgetProgress, error) {
+	budgets, err := f.DB.Budgets(userId)
+	if err != nil {
+		return nil, fmt.Errorf("BudgetBurnDown: db: %w", err)
+	}
+	summary, err := f.MonthlySummary(userId, int(now.Month()), now.Year())
+	if err != nil {
+		return nil, fmt.Errorf("BudgetBurnDown: %w", err)
+	}
+
+	daysInMonth := time.Date(now.Year(), now.Month()+1, 0, 0, 0, 0, 0, now.Location()).Day()
+	elapsed := float64(now.Day()) / float64(daysInMonth)
+	progress := []BudgetProgress{}
+	for _, budget := range budgets {
+		for _, b := range summary.BucketsSummary {
+			if b.Reference.Id != budget.BucketId {
+				continue
+			}
+			// Income in a bucket doesn't count towards its budget
+			spent := max(0, -b.Price)
+			progress = append(progress, BudgetProgress{
+				Bucket:       b.Reference,
+				Budget:       budget,
+				Spent:        spent,
+				Remaining:    budget.Amount - spent,
+				MonthElapsed: elapsed,
+				Projected:    spent / elapsed,
+				BaseCurrency: summary.BaseCurrency,
+			})
+		}
+	}
+	return progress, nil
+}
+
+// Buckets with the most spending this month, at most limit of them
+func (f *FinanceLogic) TopBuckets(userId int, now time.Time, limit int) ([]BucketSummary, error) {
+	summary, err := f.MonthlySummary(userId, int(now.Month()), now.Year())
+	if err != nil {
+		return nil, fmt.Errorf("TopBuckets: %w", err)
+	}
+	top := []BucketSummary{}
+	for _, b := range summary.BucketsSummary {
+		if b.Price < 0 {
+			top = append(top, b)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool {
+		return top[i].Price < top[j].Price
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	return top, nil
+}
+
+// Compares every bucket's total this month to last month using one range query
+func (f *FinanceLogic) MonthOverMonth(userId int, now time.Time) (*MonthChange, error) {
+	current := YearMonth{Month: int(now.Month()), Year: now.Year()}
+	previous := yearMonthFromIndex(current.index() - 1)
+	summary, _, err := f.RangeSummary(userId, Period{Start: previous, End: current})
+	if err != nil {
+		return nil, fmt.Errorf("MonthOverMonth: %w", err)
+	}
+	change := &MonthChange{
+		Current:      current,
+		Previous:     previous,
+		PreviousNet:  summary.MonthTotals[0],
+		CurrentNet:   summary.MonthTotals[1],
+		Buckets:      []BucketChange{},
+		BaseCurrency: summary.BaseCurrency,
+		MissingRates: summary.MissingRates,
+	}
+	for _, row := range summary.Rows {
+		change.Buckets = append(change.Buckets, BucketChange{
+			Reference: row.Reference,
+			Previous:  row.Months[0],
+			Current:   row.Months[1],
+		})
+	}
+	// Biggest movers first
+	sort.SliceStable(change.Buckets, func(i, j int) bool {
+		return abs(change.Buckets[i].Change()) > abs(change.Buckets[j].Change())
+	})
+	return change, nil
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,208 @@
+package finance
+
+import (
+	"math"
+	"testing"
+	"time"
+	"wonk/storage"
+)
+
+// Seeds Dec 2024 and Jan 2025 with budgets on Food, Fun, Pay and the empty bucket
+func seedDashboardDb(tb testing.TB) (*FinanceLogic, int, map[string]int) {
+	tb.Helper()
+	f, userId := newTestFinance(tb, "dashboard")
+	audit := database.AuditInfo{UserId: userId}
+	bucketIds := map[string]int{}
+	for _, name := range []string{"Rent", "Food", "Fun", "Pay", "Empty"} {
+		id, err := f.DB.CreateBucket(userId, name, audit)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		bucketIds[name] = id
+	}
+	for _, input := range []database.TransactionItemInput{
+		{Name: "rent", Month: 12, Year: 2024, Price: 900, IsExpense: true, BucketId: bucketIds["Rent"]},
+		{Name: "food", Month: 12, Year: 2024, Price: 300, IsExpense: true, BucketId: bucketIds["Food"]},
+		{Name: "pay", Month: 12, Year: 2024, Price: 2000, BucketId: bucketIds["Pay"]},
+		{Name: "rent", Month: 1, Year: 2025, Price: 900, IsExpense: true, BucketId: bucketIds["Rent"]},
+		{Name: "food", Month: 1, Year: 2025, Price: 150, IsExpense: true, BucketId: bucketIds["Food"]},
+		{Name: "refund", Month: 1, Year: 2025, Price: 20, BucketId: bucketIds["Food"]},
+		{Name: "movies", Month: 1, Year: 2025, Price: 60, IsExpense: true, BucketId: bucketIds["Fun"]},
+		{Name: "pay", Month: 1, Year: 2025, Price: 2000, BucketId: bucketIds["Pay"]},
+	} {
+		input.UserId = userId
+		input.Currency = database.DEFAULT_CURRENCY
+		_, err := f.DB.CreateItemTransaction(input, audit)
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	for name, amount := range map[string]float64{"Food": 200, "Fun": 50, "Pay": 100, "Empty": 100} {
+		problems, err := f.SetBudget(userId, database.BudgetInput{BucketId: bucketIds[name], Amount: amount})
+		if err != nil || len(problems) > 0 {
+			tb.Fatal(problems, err)
+		}
+	}
+	return f, userId, bucketIds
+}
+
+func TestBudgetBurnDown(t *testing.T) {
+	f, userId, _ := seedDashboardDb(t)
+
+	type want struct {
+		spent     float64
+		remaining float64
+		projected float64
+		overPace  bool
+	}
+	tests := []struct {
+		name    string
+		now     time.Time
+		elapsed float64
+		budgets map[string]want
+	}{
+		{
+			// Food's refund comes off its spending, income in Pay doesn't count
+			name:    "a third of the month",
+			now:     time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC),
+			elapsed: 10.0 / 31,
+			budgets: map[string]want{
+				"Food":  {spent: 130, remaining: 70, projected: 130 * 3.1, overPace: true},
+				"Fun":   {spent: 60, remaining: -10, projected: 60 * 3.1, overPace: true},
+				"Pay":   {spent: 0, remaining: 100, projected: 0},
+				"Empty": {spent: 0, remaining: 100, projected: 0},
+			},
+		},
+		{
+			name:    "month with no data",
+			now:     time.Date(2025, 2, 14, 12, 0, 0, 0, time.UTC),
+			elapsed: 14.0 / 28,
+			budgets: map[string]want{
+				"Food":  {spent: 0, remaining: 200, projected: 0},
+				"Fun":   {spent: 0, remaining: 50, projected: 0},
+				"Pay":   {spent: 0, remaining: 100, projected: 0},
+				"Empty": {spent: 0, remaining: 100, projected: 0},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			progress, err := f.BudgetBurnDown(userId, test.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(progress) != len(test.budgets) {
+				t.Fatalf("expected %d budgets, got %d", len(test.budgets), len(progress))
+			}
+			for _, p := range progress {
+				w, ok := test.budgets[p.Bucket.Name]
+				if !ok {
+					t.Errorf("unexpected budget for %s", p.Bucket.Name)
+					continue
+				}
+				if p.Budget.BucketId != p.Bucket.Id {
+					t.Errorf("%s: budget is for bucket %d", p.Bucket.Name, p.Budget.BucketId)
+				}
+				if math.Abs(p.MonthElapsed-test.elapsed) > 1e-9 {
+					t.Errorf("%s: expected %.4f of the month elapsed, got %.4f", p.Bucket.Name, test.elapsed, p.MonthElapsed)
+				}
+				if p.Spent != w.spent || p.Remaining != w.remaining || math.Abs(p.Projected-w.projected) > 1e-9 {
+					t.Errorf("%s: expected spent %v, remaining %v, projected %v, got %v, %v, %v", p.Bucket.Name, w.spent, w.remaining, w.projected, p.Spent, p.Remaining, p.Projected)
+				}
+				if p.OverPace() != w.overPace {
+					t.Errorf("%s: expected over pace %v, got %v", p.Bucket.Name, w.overPace, p.OverPace())
+				}
+			}
+		})
+	}
+}
+
+func TestTopBuckets(t *testing.T) {
+	f, userId, _ := seedDashboardDb(t)
+	tests := []struct {
+		name  string
+		now   time.Time
+		limit int
+		want  []string
+	}{
+		{name: "limited", now: time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC), limit: 2, want: []string{"Rent", "Food"}},
+		// Pay only has income and Empty has nothing
+		{name: "only spending", now: time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC), limit: 10, want: []string{"Rent", "Food", "Fun"}},
+		{name: "month with no data", now: time.Date(2025, 2, 10, 0, 0, 0, 0, time.UTC), limit: 10, want: []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			top, err := f.TopBuckets(userId, test.now, test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, b := range top {
+				got = append(got, b.Reference.Name)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("expected %v, got %v", test.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMonthOverMonth(t *testing.T) {
+	f, userId, _ := seedDashboardDb(t)
+
+	// January compares to December of the year before
+	change, err := f.MonthOverMonth(userId, time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Previous != (YearMonth{12, 2024}) || change.Current != (YearMonth{1, 2025}) {
+		t.Errorf("expected Dec 2024 to Jan 2025, got %v to %v", change.Previous, change.Current)
+	}
+	if change.PreviousNet != 800 || change.CurrentNet != 910 || change.NetChange() != 110 {
+		t.Errorf("expected 800 to 910, got %v to %v", change.PreviousNet, change.CurrentNet)
+	}
+	// Empty has no row, the biggest movers come first
+	if len(change.Buckets) != 4 {
+		t.Fatalf("expected 4 buckets, got %+v", change.Buckets)
+	}
+	food, fun := change.Buckets[0], change.Buckets[1]
+	if food.Reference.Name != "Food" || food.Previous != -300 || food.Current != -130 || food.Change() != 170 {
+		t.Errorf("expected Food -300 to -130 first, got %+v", food)
+	}
+	if fun.Reference.Name != "Fun" || fun.Previous != 0 || fun.Current != -60 || fun.Change() != -60 {
+		t.Errorf("expected Fun 0 to -60 second, got %+v", fun)
+	}
+	for _, b := range change.Buckets[2:] {
+		if b.Change() != 0 {
+			t.Errorf("%s: expected no change, got %v", b.Reference.Name, b.Change())
+		}
+	}
+
+	// February has no data of its own
+	change, err = f.MonthOverMonth(userId, time.Date(2025, 2, 10, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.PreviousNet != 910 || change.CurrentNet != 0 || len(change.Buckets) != 4 {
+		t.Errorf("expected 910 to 0 over 4 buckets, got %v to %v over %+v", change.PreviousNet, change.CurrentNet, change.Buckets)
+	}
+	for _, b := range change.Buckets {
+		if b.Current != 0 {
+			t.Errorf("%s: expected nothing in February, got %v", b.Reference.Name, b.Current)
+		}
+	}
+
+	// Neither month has data
+	change, err = f.MonthOverMonth(userId, time.Date(2025, 6, 10, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.PreviousNet != 0 || change.CurrentNet != 0 || len(change.Buckets) != 0 {
+		t.Errorf("expected an empty comparison, got %+v", change)
+	}
+}
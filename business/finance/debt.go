@@ -0,0 +1,307 @@
+package finance
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+const (
+	DEBT_STRATEGY_SNOWBALL  = "snowball"
+	DEBT_STRATEGY_AVALANCHE = "avalanche"
+	DEBT_STRATEGY_CUSTOM    = "custom"
+	// Plans stop after 50 years, a debt still owed by then isn't being paid down
+	MAX_PAYOFF_MONTHS = 600
+	// How far back expenses are offered for linking to a debt
+	DEBT_CANDIDATE_MONTHS = 3
+)
+
+var DebtStrategies = []string{DEBT_STRATEGY_AVALANCHE, DEBT_STRATEGY_SNOWBALL, DEBT_STRATEGY_CUSTOM}
+
+type PayoffInput struct {
+	Extra    float64 // Paid each month on top of the minimums
+	Strategy string  // Plan shown in full, every strategy is still compared
+	Order    []int   // Debt ids for the custom strategy, debts left out follow in the order they were added
+}
+
+func (p *PayoffInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if p.Extra < 0 {
+		problems["Extra"] = "Extra payment can't be negative"
+	}
+	switch p.Strategy {
+	case DEBT_STRATEGY_SNOWBALL, DEBT_STRATEGY_AVALANCHE, DEBT_STRATEGY_CUSTOM:
+	default:
+		problems["Strategy"] = "Not valid"
+	}
+	return problems
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// Indexes into debts in the order extra payments go to them.
+// Snowball pays the smallest balance first, avalanche the highest APR first.
+func payoffOrder(debts []DebtStatus, strategy string, custom []int) []int {
+	order := make([]int, len(debts))
+	for i := range debts {
+		order[i] = i
+	}
+	switch strategy {
+	case DEBT_STRATEGY_SNOWBALL:
+		sort.SliceStable(order, func(a, b int) bool {
+			da, db := debts[order[a]], debts[order[b]]
+			if da.Remaining != db.Remaining {
+				return da.Remaining < db.Remaining
+			}
+			return da.Debt.Apr > db.Debt.Apr
+		})
+	case DEBT_STRATEGY_AVALANCHE:
+		sort.SliceStable(order, func(a, b int) bool {
+			da, db := debts[order[a]], debts[order[b]]
+			if da.Debt.Apr != db.Debt.Apr {
+				return da.Debt.Apr > db.Debt.Apr
+			}
+			return da.Remaining < db.Remaining
+		})
+	case DEBT_STRATEGY_CUSTOM:
+		byId := map[int]int{}
+		for i, d := range debts {
+			byId[d.Debt.Id] = i
+		}
+		used := map[int]bool{}
+		order = []int{}
+		for _, id := range custom {
+			i, ok := byId[id]
+			if !ok || used[i] {
+				continue
+			}
+			used[i] = true
+			order = append(order, i)
+		}
+		for i := range debts {
+			if !used[i] {
+				order = append(order, i)
+			}
+		}
+	}
+	return order
+}
+
+// Simulates paying the debts from start with the same total every month, the minimums plus extra.
+// Interest is added first, then every debt gets its minimum and whatever is left goes to the debts
+// in order, so the minimum of a paid off debt rolls onto the next one.
+func simulatePayoff(strategy string, debts []DebtStatus, order []int, extra float64, start YearMonth) PayoffPlan {
+	plan := PayoffPlan{Strategy: strategy}
+	balances := make([]float64, len(order))
+	budget := extra
+	for pos, i := range order {
+		balances[pos] = debts[i].Remaining
+		// A debt that linked payments already cleared frees up its minimum
+		if balances[pos] > 0 {
+			budget += debts[i].Debt.MinPayment
+		}
+		plan.Debts = append(plan.Debts, DebtPayoff{Debt: debts[i].Debt, StartingBalance: debts[i].Remaining})
+	}
+	owed := func() bool {
+		for _, b := range balances {
+			if b > 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	for m := 0; m < MAX_PAYOFF_MONTHS && owed(); m++ {
+		month := PayoffMonth{Month: yearMonthFromIndex(start.index() + m), Payments: make([]ScheduledPayment, len(order))}
+		for pos := range order {
+			if balances[pos] <= 0 {
+				continue
+			}
+			interest := roundCents(balances[pos] * plan.Debts[pos].Debt.Apr / 100 / 12)
+			balances[pos] = roundCents(balances[pos] + interest)
+			month.Payments[pos].Interest = interest
+			plan.Debts[pos].Interest += interest
+		}
+		left := budget
+		pay := func(pos int, amount float64) {
+			amount = roundCents(min(amount, balances[pos], left))
+			balances[pos] = roundCents(balances[pos] - amount)
+			left = roundCents(left - amount)
+			month.Payments[pos].Payment += amount
+		}
+		for pos := range order {
+			pay(pos, plan.Debts[pos].Debt.MinPayment)
+		}
+		for pos := range order {
+			pay(pos, left)
+		}
+		for pos := range order {
+			month.Payments[pos].Balance = balances[pos]
+			plan.TotalPaid += month.Payments[pos].Payment
+			if balances[pos] <= 0 && month.Payments[pos].Payment > 0 && plan.Debts[pos].PayoffMonth == nil {
+				paidOff := month.Month
+				plan.Debts[pos].PayoffMonth = &paidOff
+			}
+		}
+		plan.Months = append(plan.Months, month)
+	}
+
+	plan.PaidOff = !owed()
+	for _, d := range plan.Debts {
+		plan.TotalInterest += d.Interest
+	}
+	plan.TotalInterest = roundCents(plan.TotalInterest)
+	plan.TotalPaid = roundCents(plan.TotalPaid)
+	return plan
+}
+
+// The user's debts with the linked payments taken off their balance
+func (f *FinanceLogic) debtStatuses(userId int, rates *rateTable) ([]DebtStatus, []string, error) {
+	debts, err := f.DB.Debts(userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	payments, err := f.DB.DebtPayments(userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	missingRates := map[string]bool{}
+	statuses := []DebtStatus{}
+	for _, d := range debts {
+		s := DebtStatus{Debt: d}
+		for _, p := range payments {
+			if p.DebtId != d.Id {
+				continue
+			}
+			c := convertTransaction(rates, p.Transaction)
+			s.Payments = append(s.Payments, LinkedPayment{Id: p.Id, Transaction: c})
+			if c.ConvertedPrice == nil {
+				missingRates[c.Currency] = true
+				continue
+			}
+			s.Paid += *c.ConvertedPrice
+		}
+		s.Remaining = max(0, roundCents(d.Balance-s.Paid))
+		statuses = append(statuses, s)
+	}
+	return statuses, sortedKeys(missingRates), nil
+}
+
+// Debts and the expenses that can be linked to them are always returned, the plans only when the input is valid.
+// Every strategy is simulated so they can be compared, payments start the month after now.
+func (f *FinanceLogic) PlanDebts(userId int, input PayoffInput, now time.Time) (*DebtPlan, map[string]string, error) {
+	rates, err := f.userRateTable(userId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("PlanDebts: %w", err)
+	}
+	debts, missing, err := f.debtStatuses(userId, rates)
+	if err != nil {
+		return nil, nil, fmt.Errorf("PlanDebts: db: %w", err)
+	}
+	current := YearMonth{Month: int(now.Month()), Year: now.Year()}
+	since := yearMonthFromIndex(current.index() - DEBT_CANDIDATE_MONTHS + 1)
+	candidates, err := f.DB.DebtPaymentCandidates(userId, since.Month, since.Year)
+	if err != nil {
+		return nil, nil, fmt.Errorf("PlanDebts: db: %w", err)
+	}
+	plan := &DebtPlan{
+		Debts:        debts,
+		Strategy:     input.Strategy,
+		Extra:        input.Extra,
+		BaseCurrency: rates.base,
+		MissingRates: missing,
+	}
+	for _, t := range candidates {
+		plan.Candidates = append(plan.Candidates, convertTransaction(rates, t))
+	}
+
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return plan, problems, nil
+	}
+	start := yearMonthFromIndex(current.index() + 1)
+	for _, strategy := range DebtStrategies {
+		order := payoffOrder(debts, strategy, input.Order)
+		plan.Plans = append(plan.Plans, simulatePayoff(strategy, debts, order, input.Extra, start))
+	}
+	return plan, nil, nil
+}
+
+func (f *FinanceLogic) AddDebt(userId int, input database.DebtInput) (map[string]string, error) {
+	input.UserId = userId
+	input.CreatedAt = time.Now().Unix()
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	_, err := f.DB.CreateDebt(input)
+	if err != nil {
+		return nil, fmt.Errorf("AddDebt: db: %w", err)
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) DeleteDebt(userId, debtId int) error {
+	rowsChanged, err := f.DB.DebtDelete(debtId, userId)
+	if err != nil {
+		return fmt.Errorf("DeleteDebt: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return fmt.Errorf("DeleteDebt: %w", cuserr.NotFound{Item: "debt"})
+	}
+	return nil
+}
+
+// Only the user's own expenses that aren't already linked can be linked to a debt
+func (f *FinanceLogic) LinkDebtPayment(userId int, input database.DebtPaymentInput) (map[string]string, error) {
+	input.UserId = userId
+	_, err := f.DB.DebtById(input.DebtId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("LinkDebtPayment: db: %w", err)
+	}
+	transaction, err := f.DB.TransactionById(input.TransactionId)
+	if err != nil {
+		var notFoundErr cuserr.NotFound
+		if errors.As(err, &notFoundErr) {
+			return map[string]string{"TransactionId": "Transaction not found"}, nil
+		}
+		return nil, fmt.Errorf("LinkDebtPayment: db: %w", err)
+	}
+	if transaction.UserId != userId {
+		return map[string]string{"TransactionId": "Transaction not found"}, nil
+	}
+	if !transaction.IsExpense {
+		return map[string]string{"TransactionId": "Only expenses can pay a debt"}, nil
+	}
+	payments, err := f.DB.DebtPayments(userId)
+	if err != nil {
+		return nil, fmt.Errorf("LinkDebtPayment: db: %w", err)
+	}
+	for _, p := range payments {
+		if p.Transaction.Id == input.TransactionId {
+			return map[string]string{"TransactionId": "Transaction is already linked to a debt"}, nil
+		}
+	}
+	_, err = f.DB.CreateDebtPayment(input)
+	if err != nil {
+		return nil, fmt.Errorf("LinkDebtPayment: db: %w", err)
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) UnlinkDebtPayment(userId, paymentId int) error {
+	rowsChanged, err := f.DB.DebtPaymentDelete(paymentId, userId)
+	if err != nil {
+		return fmt.Errorf("UnlinkDebtPayment: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return fmt.Errorf("UnlinkDebtPayment: %w", cuserr.NotFound{Item: "debt payment"})
+	}
+	return nil
+}
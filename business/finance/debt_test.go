@@ -0,0 +1,120 @@
+package finance
+
+import (
+	"math"
+	"testing"
+	"wonk/storage"
+)
+
+func debtStatus(id int, name string, balance, apr, minPayment float64) DebtStatus {
+	return DebtStatus{
+		Debt:      database.Debt{Id: id, Name: name, Balance: balance, Apr: apr, MinPayment: minPayment},
+		Remaining: balance,
+	}
+}
+
+func TestSimulatePayoff(t *testing.T) {
+	start := YearMonth{Month: 11, Year: 2025}
+
+	t.Run("no interest", func(t *testing.T) {
+		debts := []DebtStatus{debtStatus(1, "loan", 1200, 0, 100)}
+		plan := simulatePayoff(DEBT_STRATEGY_AVALANCHE, debts, []int{0}, 0, start)
+		if !plan.PaidOff || len(plan.Months) != 12 {
+			t.Fatalf("expected paid off in 12 months, got %v in %d", plan.PaidOff, len(plan.Months))
+		}
+		if plan.TotalInterest != 0 || plan.TotalPaid != 1200 {
+			t.Errorf("expected 0 interest and 1200 paid, got %v and %v", plan.TotalInterest, plan.TotalPaid)
+		}
+		if got := *plan.DebtFreeMonth(); got != (YearMonth{Month: 10, Year: 2026}) {
+			t.Errorf("expected debt free Oct 2026, got %v", got)
+		}
+	})
+
+	t.Run("interest", func(t *testing.T) {
+		// 1% a month on the balance, the last payment is the 11th
+		debts := []DebtStatus{debtStatus(1, "card", 1000, 12, 100)}
+		plan := simulatePayoff(DEBT_STRATEGY_AVALANCHE, debts, []int{0}, 0, start)
+		if len(plan.Months) != 11 {
+			t.Fatalf("expected 11 months, got %d", len(plan.Months))
+		}
+		if first := plan.Months[0].Payments[0]; first.Interest != 10 || first.Balance != 910 {
+			t.Errorf("expected 10 interest and 910 left after the first month, got %+v", first)
+		}
+		if math.Abs(plan.TotalInterest-58.98) > 0.011 {
+			t.Errorf("expected about 58.98 interest, got %v", plan.TotalInterest)
+		}
+		if math.Abs(plan.TotalPaid-(1000+plan.TotalInterest)) > 0.001 {
+			t.Errorf("expected paid to be the balance plus interest, got %v", plan.TotalPaid)
+		}
+	})
+
+	t.Run("never paid off", func(t *testing.T) {
+		// 250 of interest a month against a 100 minimum
+		debts := []DebtStatus{debtStatus(1, "card", 10000, 30, 100)}
+		plan := simulatePayoff(DEBT_STRATEGY_AVALANCHE, debts, []int{0}, 0, start)
+		if plan.PaidOff || len(plan.Months) != MAX_PAYOFF_MONTHS || plan.DebtFreeMonth() != nil {
+			t.Errorf("expected the plan to give up after %d months, got %v after %d", MAX_PAYOFF_MONTHS, plan.PaidOff, len(plan.Months))
+		}
+		if plan.Debts[0].PayoffMonth != nil {
+			t.Errorf("expected no payoff month, got %v", plan.Debts[0].PayoffMonth)
+		}
+	})
+
+	t.Run("minimum rolls over", func(t *testing.T) {
+		debts := []DebtStatus{debtStatus(1, "small", 100, 0, 50), debtStatus(2, "big", 1000, 0, 50)}
+		plan := simulatePayoff(DEBT_STRATEGY_SNOWBALL, debts, []int{0, 1}, 0, start)
+		// small is gone after 2 months, then big gets the full 100
+		if got := *plan.Debts[0].PayoffMonth; got != (YearMonth{Month: 12, Year: 2025}) {
+			t.Errorf("expected small paid off Dec 2025, got %v", got)
+		}
+		if got := plan.Months[2].Payments[1].Payment; got != 100 {
+			t.Errorf("expected big to get 100 once small is paid, got %v", got)
+		}
+		if len(plan.Months) != 11 {
+			t.Errorf("expected 11 months, got %d", len(plan.Months))
+		}
+	})
+}
+
+func TestPayoffStrategies(t *testing.T) {
+	debts := []DebtStatus{
+		debtStatus(1, "store card", 500, 5, 25),
+		debtStatus(2, "credit card", 3000, 25, 75),
+		debtStatus(3, "student loan", 8000, 6, 100),
+	}
+	tests := []struct {
+		strategy string
+		custom   []int
+		want     []string
+	}{
+		{strategy: DEBT_STRATEGY_SNOWBALL, want: []string{"store card", "credit card", "student loan"}},
+		{strategy: DEBT_STRATEGY_AVALANCHE, want: []string{"credit card", "student loan", "store card"}},
+		// Unknown and repeated ids are skipped, debts left out follow in the order they were added
+		{strategy: DEBT_STRATEGY_CUSTOM, custom: []int{3, 99, 3}, want: []string{"student loan", "store card", "credit card"}},
+	}
+	start := YearMonth{Month: 1, Year: 2026}
+	plans := map[string]PayoffPlan{}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			order := payoffOrder(debts, tt.strategy, tt.custom)
+			plan := simulatePayoff(tt.strategy, debts, order, 200, start)
+			for i, name := range tt.want {
+				if plan.Debts[i].Debt.Name != name {
+					t.Errorf("position %d: expected %s, got %s", i, name, plan.Debts[i].Debt.Name)
+				}
+			}
+			if !plan.PaidOff {
+				t.Errorf("expected the plan to finish")
+			}
+			plans[tt.strategy] = plan
+		})
+	}
+	if plans[DEBT_STRATEGY_AVALANCHE].TotalInterest >= plans[DEBT_STRATEGY_SNOWBALL].TotalInterest {
+		t.Errorf("expected avalanche to pay less interest than snowball, got %v and %v",
+			plans[DEBT_STRATEGY_AVALANCHE].TotalInterest, plans[DEBT_STRATEGY_SNOWBALL].TotalInterest)
+	}
+	snowballFirst := *plans[DEBT_STRATEGY_SNOWBALL].Debts[0].PayoffMonth
+	if snowballFirst.index() >= plans[DEBT_STRATEGY_AVALANCHE].Debts[0].PayoffMonth.index() {
+		t.Errorf("expected snowball to clear its first debt sooner")
+	}
+}
@@ -1,27 +1,102 @@
 package finance
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strconv"
+	"time"
+	"wonk/app/cuserr"
 	"wonk/storage"
 )
 
 const (
 	MAX_BUCKETS = 40
+	// How long after a delete the undo button is allowed to restore the item
+	UNDO_WINDOW = time.Minute * 5
 )
 
 type Finance interface {
 	UserBuckets(int) ([]database.Bucket, error)
-	SubmitNewTransaction(database.TransactionItemInput) (map[string]string, error)
-	CreateBucket(int, string) (map[string]string, error)
+	SubmitNewTransaction(context.Context, database.TransactionItemInput) (map[string]string, error)
+	CreateBucket(context.Context, int, string) (map[string]string, error)
 	MonthlySummary(int, int, int) (*MonthSummary, error)
+	RangeSummary(int, Period) (*RangeSummary, map[string]string, error)
 	GetBucket(string) (*database.Bucket, error)
-	UpdateBucket(int, string) error
-	GetTransactions(int, int, int, string, bool, TransactionFilters) ([]database.TransactionItem, error)
+	UpdateBucket(context.Context, int, string) error
+	GetTransactions(int, int, int, string, bool, TransactionFilters) ([]ConvertedTransaction, error)
 	GetTransaction(string) (*database.TransactionItem, error)
-	UpdateTransaction(TransactionEdit) error
-	DeleteTransaction(int) error
+	UpdateTransaction(context.Context, TransactionEdit) error
+	DeleteTransaction(context.Context, int) error
+	UndoDeleteTransaction(context.Context, int) error
+	GetDeletedTransaction(string) (*database.TransactionItem, error)
+	RestoreTransaction(context.Context, int) error
+	PurgeTransaction(context.Context, int) error
+	DeleteBucket(context.Context, int) error
+	UndoDeleteBucket(context.Context, int) error
+	GetDeletedBucket(string) (*database.Bucket, error)
+	RestoreBucket(context.Context, int) error
+	PurgeBucket(context.Context, int) error
+	Trash(int) (*Trash, error)
+	TransactionHistory(int, int) ([]HistoryEntry, error)
+	ConvertTransaction(int, database.TransactionItem) (*ConvertedTransaction, error)
+	BaseCurrency(int) (string, error)
+	SetBaseCurrency(int, string) error
+	ExchangeRates(int) ([]database.ExchangeRate, error)
+	AddExchangeRate(int, database.ExchangeRateInput) (map[string]string, error)
+	ImportExchangeRates(int, io.Reader) (int, map[string]string, error)
+	DeleteExchangeRate(int, int) error
+	Budgets(int) ([]database.Budget, error)
+	SetBudget(int, database.BudgetInput) (map[string]string, error)
+	DeleteBudget(int, int) error
+	RecurringItems(int) ([]database.RecurringItem, error)
+	AddRecurringItem(int, database.RecurringItemInput) (map[string]string, error)
+	DeleteRecurringItem(int, int) error
+	UpcomingRecurring(int, time.Time) ([]UpcomingItem, error)
+	BudgetBurnDown(int, time.Time) ([]BudgetProgress, error)
+	TopBuckets(int, time.Time, int) ([]BucketSummary, error)
+	MonthOverMonth(int, time.Time) (*MonthChange, error)
+	SavingsGoals(int, time.Time) ([]GoalProgress, error)
+	AddSavingsGoal(int, database.SavingsGoalInput) (map[string]string, error)
+	DeleteSavingsGoal(int, int) error
+	AddGoalContribution(int, database.GoalContributionInput) (map[string]string, error)
+	CashFlowForecast(int, ForecastInput, time.Time) (*Forecast, map[string]string, error)
+	PlanDebts(int, PayoffInput, time.Time) (*DebtPlan, map[string]string, error)
+	AddDebt(int, database.DebtInput) (map[string]string, error)
+	DeleteDebt(int, int) error
+	LinkDebtPayment(int, database.DebtPaymentInput) (map[string]string, error)
+	UnlinkDebtPayment(int, int) error
+	NetWorthHistory(int, Period) (*NetWorth, map[string]string, error)
+	AddNetWorthAccount(int, database.NetWorthAccountInput) (map[string]string, error)
+	DeleteNetWorthAccount(int, int) error
+	AddSnapshot(int, database.SnapshotInput) (map[string]string, error)
+	DeleteSnapshot(int, int) error
+	ImportSnapshots(int, io.Reader) (int, map[string]string, error)
+	Portfolio(int, time.Time) (*Portfolio, error)
+	AddInvestmentAccount(int, database.InvestmentAccountInput) (map[string]string, error)
+	DeleteInvestmentAccount(int, int) error
+	AddInvestmentTrade(int, database.InvestmentTradeInput) (map[string]string, error)
+	DeleteInvestmentTrade(int, int) (map[string]string, error)
+	SetAssetClass(int, database.SecurityInput) (map[string]string, error)
+	AddSecurityPrice(int, database.SecurityPriceInput) (map[string]string, error)
+	DeleteSecurityPrice(int, int) error
+	ImportSecurityPrices(int, io.Reader) (int, map[string]string, error)
+	Bills(int, time.Time) (*BillOverview, error)
+	BillReminders(int, time.Time) ([]BillReminder, error)
+	AddBill(int, database.BillInput) (map[string]string, error)
+	DeleteBill(int, int) error
+	PayBill(context.Context, int, int, string, time.Time) (map[string]string, error)
+	NotificationPreferences(int) (*database.NotificationPreference, error)
+	SetNotificationPreferences(int, database.NotificationPreferenceInput) (map[string]string, error)
+	NotificationRecipients() ([]database.NotificationPreference, error)
+	PendingNotifications(int, time.Time) (*PendingNotifications, error)
+	MarkNotificationsSent(int, []NotificationKey, time.Time) error
+	CalendarFeedStatus(int) (*database.CalendarFeed, error)
+	RotateCalendarToken(int) (string, error)
+	DisableCalendarFeed(int) error
+	CalendarEvents(string, time.Time) ([]CalendarEvent, error)
 }
 
 type FinanceLogic struct {
@@ -42,7 +117,7 @@ func (f *FinanceLogic) UserBuckets(userId int) ([]database.Bucket, error) {
 	return buckets, nil
 }
 
-func (f *FinanceLogic) SubmitNewTransaction(inputForm database.TransactionItemInput) (map[string]string, error) {
+func (f *FinanceLogic) SubmitNewTransaction(ctx context.Context, inputForm database.TransactionItemInput) (map[string]string, error) {
 	// Validate input values
 	problems := inputForm.Valid()
 	if len(problems) > 0 {
@@ -50,7 +125,7 @@ func (f *FinanceLogic) SubmitNewTransaction(inputForm database.TransactionItemIn
 	}
 
 	// Save to DB
-	_, err := f.DB.CreateItemTransaction(inputForm)
+	_, err := f.DB.CreateItemTransaction(inputForm, auditInfo(ctx, inputForm.UserId))
 	if err != nil {
 		return nil, fmt.Errorf("SubmitNewTransaction: db: %w", err)
 	}
@@ -58,7 +133,7 @@ func (f *FinanceLogic) SubmitNewTransaction(inputForm database.TransactionItemIn
 	return nil, nil
 }
 
-func (f *FinanceLogic) CreateBucket(userId int, newName string) (map[string]string, error) {
+func (f *FinanceLogic) CreateBucket(ctx context.Context, userId int, newName string) (map[string]string, error) {
 	numBuckets, err := f.DB.NumBuckets(userId)
 	if err != nil {
 		return nil, fmt.Errorf("CreateBucket: num: %w", err)
@@ -77,28 +152,45 @@ func (f *FinanceLogic) CreateBucket(userId int, newName string) (map[string]stri
 	if len(problems) > 0 {
 		return problems, nil
 	}
-	_, err = f.DB.CreateBucket(userId, newName)
+	_, err = f.DB.CreateBucket(userId, newName, auditInfo(ctx, userId))
 	if err != nil {
 		return nil, fmt.Errorf("CreateBucket: db: %w", err)
 	}
 	return nil, nil
 }
 
+// Totals every bucket for the month from one grouped query, buckets without transactions have a 0 price
 func (f *FinanceLogic) MonthlySummary(userId, month, year int) (*MonthSummary, error) {
 	buckets, err := f.DB.UserBuckets(userId)
 	if err != nil {
 		return nil, fmt.Errorf("BucketsMonthlySummary: %w", err)
 	}
+	rates, err := f.userRateTable(userId)
+	if err != nil {
+		return nil, fmt.Errorf("BucketsMonthlySummary: %w", err)
+	}
+	totals, err := f.DB.BucketMonthTotals(userId, month, year, month, year)
+	if err != nil {
+		return nil, fmt.Errorf("BucketsMonthlySummary: db: %w", err)
+	}
+
+	// Transactions without a rate are left out of the total
+	bucketPrices := map[int]float64{}
+	missingRates := map[string]bool{}
+	for _, t := range totals {
+		price, ok := rates.convert(t.Total, t.Currency, t.Month, t.Year)
+		if !ok {
+			missingRates[t.Currency] = true
+			continue
+		}
+		bucketPrices[t.BucketId] += price
+	}
 
 	totalIncome := 0.0
 	totalExpense := 0.0
-
 	newBuckets := []BucketSummary{}
 	for _, b := range buckets {
-		totalPrice, err := f.bucketMonthPrice(b.Id, month, year)
-		if err != nil {
-			return nil, fmt.Errorf("BucketsMonthlySummary: %w", err)
-		}
+		totalPrice := bucketPrices[b.Id]
 		newB := BucketSummary{
 			Reference: b,
 			Price:     totalPrice,
@@ -115,28 +207,20 @@ func (f *FinanceLogic) MonthlySummary(userId, month, year int) (*MonthSummary, e
 		BucketsSummary: newBuckets,
 		TotalIncome:    totalIncome,
 		TotalExpense:   totalExpense,
+		BaseCurrency:   rates.base,
+		MissingRates:   sortedKeys(missingRates),
 	}
 
 	return summary, nil
 }
 
-func (f *FinanceLogic) bucketMonthPrice(bucketId int, month int, year int) (float64, error) {
-	transactions, err := f.DB.TransactionsInBucket(bucketId, month, year)
-	if err != nil {
-		return 0, fmt.Errorf("bucketMonthPrice: db: %w", err)
-	}
-
-	// Get the price of all
-	totalPrice := 0.0
-	for _, t := range transactions {
-		factor := 1
-		if t.IsExpense {
-			factor = factor * -1
-		}
-		totalPrice += t.Price * float64(factor)
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
 	}
-
-	return totalPrice, nil
+	sort.Strings(keys)
+	return keys
 }
 
 func (f *FinanceLogic) GetBucket(bucketId string) (*database.Bucket, error) {
@@ -151,8 +235,12 @@ func (f *FinanceLogic) GetBucket(bucketId string) (*database.Bucket, error) {
 	return bucket, nil
 }
 
-func (f *FinanceLogic) UpdateBucket(bucketId int, newName string) error {
-	rowsChanged, err := f.DB.BucketUpdateName(bucketId, newName)
+func (f *FinanceLogic) UpdateBucket(ctx context.Context, bucketId int, newName string) error {
+	before, err := f.DB.BucketById(bucketId)
+	if err != nil {
+		return fmt.Errorf("UpdateBucket: %w", err)
+	}
+	rowsChanged, err := f.DB.BucketUpdateName(bucketId, newName, auditInfo(ctx, before.UserId))
 	if err != nil {
 		return fmt.Errorf("UpdateBucket: %w", err)
 	}
@@ -162,14 +250,22 @@ func (f *FinanceLogic) UpdateBucket(bucketId int, newName string) error {
 	return nil
 }
 
-func (f *FinanceLogic) GetTransactions(page, pagesize, userId int, sortBy string, isAscending bool, filters TransactionFilters) ([]database.TransactionItem, error) {
+func (f *FinanceLogic) GetTransactions(page, pagesize, userId int, sortBy string, isAscending bool, filters TransactionFilters) ([]ConvertedTransaction, error) {
 	dbFilters := convertTransactionFilters(filters)
 	dbFilters.Id = userId
 	transactions, err := f.DB.TransactionsPagination(page, pagesize, sortBy, isAscending, dbFilters)
 	if err != nil {
 		return nil, fmt.Errorf("GetTransactions: %w", err)
 	}
-	return transactions, nil
+	rates, err := f.userRateTable(userId)
+	if err != nil {
+		return nil, fmt.Errorf("GetTransactions: %w", err)
+	}
+	converted := []ConvertedTransaction{}
+	for _, t := range transactions {
+		converted = append(converted, convertTransaction(rates, t))
+	}
+	return converted, nil
 }
 func (f *FinanceLogic) GetTransaction(transactionId string) (*database.TransactionItem, error) {
 	id, err := strconv.Atoi(transactionId)
@@ -182,14 +278,18 @@ func (f *FinanceLogic) GetTransaction(transactionId string) (*database.Transacti
 	}
 	return transaction, nil
 }
-func (f *FinanceLogic) UpdateTransaction(input TransactionEdit) error {
+func (f *FinanceLogic) UpdateTransaction(ctx context.Context, input TransactionEdit) error {
 	// Validate fields
 	problems := input.Valid()
 	if len(problems) > 0 {
 		return fmt.Errorf("UpdateTransaction: input problems: %v", problems)
 	}
+	before, err := f.DB.TransactionById(input.TransactionId)
+	if err != nil {
+		return fmt.Errorf("UpdateTransaction: db: %w", err)
+	}
 	// Update transaction in db
-	rowsChanged, err := f.DB.TransactionUpdate(input.Name, input.TransactionId, input.BucketId, input.Month, input.Year, input.Price)
+	rowsChanged, err := f.DB.TransactionUpdate(input.Name, input.TransactionId, input.BucketId, input.Month, input.Year, input.Price, input.Currency, auditInfo(ctx, before.UserId))
 	if err != nil {
 		return fmt.Errorf("UpdateTransaction: db: %w", err)
 	}
@@ -199,8 +299,12 @@ func (f *FinanceLogic) UpdateTransaction(input TransactionEdit) error {
 	return nil
 }
 
-func (f *FinanceLogic) DeleteTransaction(transactionId int) error {
-	rowsChanged, err := f.DB.TransactionDelete(transactionId)
+func (f *FinanceLogic) DeleteTransaction(ctx context.Context, transactionId int) error {
+	before, err := f.DB.TransactionById(transactionId)
+	if err != nil {
+		return fmt.Errorf("DeleteTransaction: db: %w", err)
+	}
+	rowsChanged, err := f.DB.TransactionDelete(transactionId, time.Now().Unix(), auditInfo(ctx, before.UserId))
 	if err != nil {
 		return fmt.Errorf("DeleteTransaction: db: %w", err)
 	}
@@ -210,3 +314,163 @@ func (f *FinanceLogic) DeleteTransaction(transactionId int) error {
 
 	return nil
 }
+
+// Restores a deleted transaction only if it was deleted within the UNDO_WINDOW
+func (f *FinanceLogic) UndoDeleteTransaction(ctx context.Context, transactionId int) error {
+	transaction, err := f.DB.DeletedTransactionById(transactionId)
+	if err != nil {
+		return fmt.Errorf("UndoDeleteTransaction: db: %w", err)
+	}
+	if !withinUndoWindow(transaction.DeletedAt) {
+		return fmt.Errorf("UndoDeleteTransaction: %w", cuserr.Expired{Item: "undo window"})
+	}
+	err = f.RestoreTransaction(ctx, transactionId)
+	if err != nil {
+		return fmt.Errorf("UndoDeleteTransaction: %w", err)
+	}
+	return nil
+}
+
+func (f *FinanceLogic) GetDeletedTransaction(transactionId string) (*database.TransactionItem, error) {
+	id, err := strconv.Atoi(transactionId)
+	if err != nil {
+		return nil, fmt.Errorf("GetDeletedTransaction: invalid id: %w", err)
+	}
+	transaction, err := f.DB.DeletedTransactionById(id)
+	if err != nil {
+		return nil, fmt.Errorf("GetDeletedTransaction: %w", err)
+	}
+	return transaction, nil
+}
+
+func (f *FinanceLogic) RestoreTransaction(ctx context.Context, transactionId int) error {
+	before, err := f.DB.DeletedTransactionById(transactionId)
+	if err != nil {
+		return fmt.Errorf("RestoreTransaction: db: %w", err)
+	}
+	rowsChanged, err := f.DB.TransactionRestore(transactionId, auditInfo(ctx, before.UserId))
+	if err != nil {
+		return fmt.Errorf("RestoreTransaction: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return errors.New("RestoreTransaction: db: no data changed")
+	}
+	return nil
+}
+
+func (f *FinanceLogic) PurgeTransaction(ctx context.Context, transactionId int) error {
+	before, err := f.DB.DeletedTransactionById(transactionId)
+	if err != nil {
+		return fmt.Errorf("PurgeTransaction: db: %w", err)
+	}
+	rowsChanged, err := f.DB.TransactionPurge(transactionId, auditInfo(ctx, before.UserId))
+	if err != nil {
+		return fmt.Errorf("PurgeTransaction: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return errors.New("PurgeTransaction: db: no data changed")
+	}
+	return nil
+}
+
+func (f *FinanceLogic) DeleteBucket(ctx context.Context, bucketId int) error {
+	before, err := f.DB.BucketById(bucketId)
+	if err != nil {
+		return fmt.Errorf("DeleteBucket: db: %w", err)
+	}
+	rowsChanged, err := f.DB.BucketDelete(bucketId, time.Now().Unix(), auditInfo(ctx, before.UserId))
+	if err != nil {
+		return fmt.Errorf("DeleteBucket: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return errors.New("DeleteBucket: db: no data changed")
+	}
+	return nil
+}
+
+// Restores a deleted bucket only if it was deleted within the UNDO_WINDOW
+func (f *FinanceLogic) UndoDeleteBucket(ctx context.Context, bucketId int) error {
+	bucket, err := f.DB.DeletedBucketById(bucketId)
+	if err != nil {
+		return fmt.Errorf("UndoDeleteBucket: db: %w", err)
+	}
+	if !withinUndoWindow(bucket.DeletedAt) {
+		return fmt.Errorf("UndoDeleteBucket: %w", cuserr.Expired{Item: "undo window"})
+	}
+	err = f.RestoreBucket(ctx, bucketId)
+	if err != nil {
+		return fmt.Errorf("UndoDeleteBucket: %w", err)
+	}
+	return nil
+}
+
+func (f *FinanceLogic) GetDeletedBucket(bucketId string) (*database.Bucket, error) {
+	id, err := strconv.Atoi(bucketId)
+	if err != nil {
+		return nil, fmt.Errorf("GetDeletedBucket: invalid id: %w", err)
+	}
+	bucket, err := f.DB.DeletedBucketById(id)
+	if err != nil {
+		return nil, fmt.Errorf("GetDeletedBucket: %w", err)
+	}
+	return bucket, nil
+}
+
+func (f *FinanceLogic) RestoreBucket(ctx context.Context, bucketId int) error {
+	before, err := f.DB.DeletedBucketById(bucketId)
+	if err != nil {
+		return fmt.Errorf("RestoreBucket: db: %w", err)
+	}
+	numBuckets, err := f.DB.NumBuckets(before.UserId)
+	if err != nil {
+		return fmt.Errorf("RestoreBucket: num: %w", err)
+	}
+	if numBuckets >= MAX_BUCKETS {
+		return errors.New("RestoreBucket: user can't have more buckets")
+	}
+	rowsChanged, err := f.DB.BucketRestore(bucketId, auditInfo(ctx, before.UserId))
+	if err != nil {
+		return fmt.Errorf("RestoreBucket: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return errors.New("RestoreBucket: db: no data changed")
+	}
+	return nil
+}
+
+func (f *FinanceLogic) PurgeBucket(ctx context.Context, bucketId int) error {
+	before, err := f.DB.DeletedBucketById(bucketId)
+	if err != nil {
+		return fmt.Errorf("PurgeBucket: db: %w", err)
+	}
+	rowsChanged, err := f.DB.BucketPurge(bucketId, auditInfo(ctx, before.UserId))
+	if err != nil {
+		return fmt.Errorf("PurgeBucket: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return errors.New("PurgeBucket: db: no data changed")
+	}
+	return nil
+}
+
+func (f *FinanceLogic) Trash(userId int) (*Trash, error) {
+	transactions, err := f.DB.DeletedTransactions(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Trash: %w", err)
+	}
+	buckets, err := f.DB.DeletedBuckets(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Trash: %w", err)
+	}
+	return &Trash{
+		Transactions: transactions,
+		Buckets:      buckets,
+	}, nil
+}
+
+func withinUndoWindow(deletedAt *int64) bool {
+	if deletedAt == nil {
+		return false
+	}
+	return time.Since(time.Unix(*deletedAt, 0)) <= UNDO_WINDOW
+}
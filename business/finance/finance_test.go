@@ -0,0 +1,219 @@
+package finance
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+// Finance logic on a new in-memory db with one user
+func newTestFinance(tb testing.TB, userName string) (*FinanceLogic, int) {
+	tb.Helper()
+	db, err := database.InitDb("", true)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	userId, err := db.CreateUser(userName, "password")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &FinanceLogic{DB: db}, userId
+}
+
+// A bucket in May 2025 with one transaction per price, expenses are negative
+func seedSoftDelete(t *testing.T, f *FinanceLogic, userId int, name string, prices ...float64) (int, []int) {
+	t.Helper()
+	audit := database.AuditInfo{UserId: userId}
+	bucketId, err := f.DB.CreateBucket(userId, name, audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, price := range prices {
+		id, err := f.DB.CreateItemTransaction(database.TransactionItemInput{
+			Name:      name,
+			Month:     5,
+			Year:      2025,
+			Price:     max(price, -price),
+			IsExpense: price < 0,
+			UserId:    userId,
+			BucketId:  bucketId,
+			Currency:  database.DEFAULT_CURRENCY,
+		}, audit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return bucketId, ids
+}
+
+// Ids of the transactions in the list and the May 2025 income & expense totals
+func softDeleteState(t *testing.T, f *FinanceLogic, userId int) ([]int, float64, float64) {
+	t.Helper()
+	transactions, err := f.GetTransactions(1, 50, userId, "", true, TransactionFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, tr := range transactions {
+		ids = append(ids, tr.Id)
+	}
+	summary, err := f.MonthlySummary(userId, 5, 2025)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids, summary.TotalIncome, summary.TotalExpense
+}
+
+func assertSoftDeleteState(t *testing.T, f *FinanceLogic, userId int, step string, wantIds []int, wantIncome, wantExpense float64) {
+	t.Helper()
+	ids, income, expense := softDeleteState(t, f, userId)
+	if len(ids) != len(wantIds) {
+		t.Fatalf("%s: expected transactions %v, got %v", step, wantIds, ids)
+	}
+	for i := range ids {
+		if ids[i] != wantIds[i] {
+			t.Fatalf("%s: expected transactions %v, got %v", step, wantIds, ids)
+		}
+	}
+	if income != wantIncome || expense != wantExpense {
+		t.Errorf("%s: expected income %v and expense %v, got %v and %v", step, wantIncome, wantExpense, income, expense)
+	}
+}
+
+func TestSoftDeleteTransaction(t *testing.T) {
+	f, userId := newTestFinance(t, "softTransaction")
+	ctx := context.Background()
+	_, ids := seedSoftDelete(t, f, userId, "Food", -100, -50)
+	assertSoftDeleteState(t, f, userId, "start", ids, 0, -150)
+
+	err := f.DeleteTransaction(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSoftDeleteState(t, f, userId, "deleted", ids[:1], 0, -100)
+	_, err = f.GetTransaction(strconv.Itoa(ids[1]))
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Errorf("deleted: expected NotFound, got %v", err)
+	}
+	trash, err := f.Trash(userId)
+	if err != nil || len(trash.Transactions) != 1 || trash.Transactions[0].Id != ids[1] {
+		t.Fatalf("deleted: expected it in the trash, got %+v %v", trash, err)
+	}
+	if f.DeleteTransaction(ctx, ids[1]) == nil {
+		t.Error("deleted twice: expected an error")
+	}
+
+	err = f.UndoDeleteTransaction(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSoftDeleteState(t, f, userId, "undone", ids, 0, -150)
+
+	// Deleted longer ago than the undo window
+	_, err = f.DB.TransactionDelete(ids[1], time.Now().Add(-UNDO_WINDOW-time.Minute).Unix(), database.AuditInfo{UserId: userId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.UndoDeleteTransaction(ctx, ids[1])
+	if !errors.As(err, &cuserr.Expired{}) {
+		t.Fatalf("undo after the window: expected Expired, got %v", err)
+	}
+	assertSoftDeleteState(t, f, userId, "undo expired", ids[:1], 0, -100)
+	// The trash can still restore it
+	err = f.RestoreTransaction(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSoftDeleteState(t, f, userId, "restored", ids, 0, -150)
+
+	if f.PurgeTransaction(ctx, ids[0]) == nil {
+		t.Error("purge without deleting: expected an error")
+	}
+	err = f.DeleteTransaction(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.PurgeTransaction(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.GetDeletedTransaction(strconv.Itoa(ids[1]))
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Errorf("purged: expected NotFound, got %v", err)
+	}
+	if f.RestoreTransaction(ctx, ids[1]) == nil {
+		t.Error("restore after purge: expected an error")
+	}
+	trash, err = f.Trash(userId)
+	if err != nil || len(trash.Transactions) != 0 {
+		t.Errorf("purged: expected an empty trash, got %+v %v", trash, err)
+	}
+	assertSoftDeleteState(t, f, userId, "purged", ids[:1], 0, -100)
+}
+
+func TestSoftDeleteBucket(t *testing.T) {
+	f, userId := newTestFinance(t, "softBucket")
+	ctx := context.Background()
+	payId, payIds := seedSoftDelete(t, f, userId, "Pay", 1000)
+	_, foodIds := seedSoftDelete(t, f, userId, "Food", -100)
+	all := append(append([]int{}, payIds...), foodIds...)
+	assertSoftDeleteState(t, f, userId, "start", all, 1000, -100)
+
+	// Its transactions are hidden with it even though they aren't deleted themselves
+	err := f.DeleteBucket(ctx, payId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSoftDeleteState(t, f, userId, "deleted", foodIds, 0, -100)
+	buckets, err := f.UserBuckets(userId)
+	if err != nil || len(buckets) != 1 || buckets[0].Name != "Food" {
+		t.Fatalf("deleted: expected only Food, got %+v %v", buckets, err)
+	}
+
+	err = f.UndoDeleteBucket(ctx, payId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSoftDeleteState(t, f, userId, "undone", all, 1000, -100)
+
+	_, err = f.DB.BucketDelete(payId, time.Now().Add(-UNDO_WINDOW-time.Minute).Unix(), database.AuditInfo{UserId: userId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.UndoDeleteBucket(ctx, payId)
+	if !errors.As(err, &cuserr.Expired{}) {
+		t.Fatalf("undo after the window: expected Expired, got %v", err)
+	}
+	err = f.RestoreBucket(ctx, payId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSoftDeleteState(t, f, userId, "restored", all, 1000, -100)
+
+	if f.PurgeBucket(ctx, payId) == nil {
+		t.Error("purge without deleting: expected an error")
+	}
+	err = f.DeleteBucket(ctx, payId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.PurgeBucket(ctx, payId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.GetDeletedBucket(strconv.Itoa(payId))
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Errorf("purged: expected NotFound, got %v", err)
+	}
+	trash, err := f.Trash(userId)
+	if err != nil || len(trash.Buckets) != 0 || len(trash.Transactions) != 0 {
+		t.Errorf("purged: expected an empty trash, got %+v %v", trash, err)
+	}
+	assertSoftDeleteState(t, f, userId, "purged", foodIds, 0, -100)
+}
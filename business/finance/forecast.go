@@ -0,0 +1,187 @@
+package finance
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	"wonk/storage"
+)
+
+const (
+	MIN_FORECAST_MONTHS     = 3
+	MAX_FORECAST_MONTHS     = 24
+	DEFAULT_FORECAST_WINDOW = 6
+	MAX_FORECAST_WINDOW     = 24
+)
+
+type ForecastInput struct {
+	Months          int      // How many months after the current one to project
+	Window          int      // How many complete months of history the averages use
+	StartingBalance *float64 // Defaults to the net of every transaction so far
+}
+
+func (f *ForecastInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if f.Months < MIN_FORECAST_MONTHS || f.Months > MAX_FORECAST_MONTHS {
+		problems["Months"] = fmt.Sprintf("Months must be between %d-%d", MIN_FORECAST_MONTHS, MAX_FORECAST_MONTHS)
+	}
+	if f.Window < 1 || f.Window > MAX_FORECAST_WINDOW {
+		problems["Window"] = fmt.Sprintf("Window must be between 1-%d", MAX_FORECAST_WINDOW)
+	}
+	return problems
+}
+
+// Averages the values after dropping the ones outside 1.5 interquartile ranges of the middle half.
+// Fewer than 4 values aren't enough to tell what an outlier is so all of them are used.
+func trimmedAverage(values []float64) (float64, int) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	kept := values
+	if len(values) >= 4 {
+		sorted := append([]float64{}, values...)
+		sort.Float64s(sorted)
+		q1 := quantile(sorted, 0.25)
+		q3 := quantile(sorted, 0.75)
+		fence := (q3 - q1) * 1.5
+		kept = []float64{}
+		for _, v := range values {
+			if v >= q1-fence && v <= q3+fence {
+				kept = append(kept, v)
+			}
+		}
+	}
+	total := 0.0
+	for _, v := range kept {
+		total += v
+	}
+	return total / float64(len(kept)), len(values) - len(kept)
+}
+
+// Linear interpolation between the closest ranks of sorted values
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lower := int(pos)
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[lower+1]-sorted[lower])*(pos-float64(lower))
+}
+
+// Projects each month after now from the buckets' trimmed historical averages. A bucket with recurring
+// items is projected from those items instead, so a recurring bill logged every month isn't counted twice.
+func (f *FinanceLogic) CashFlowForecast(userId int, input ForecastInput, now time.Time) (*Forecast, map[string]string, error) {
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return nil, problems, nil
+	}
+	buckets, err := f.DB.UserBuckets(userId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CashFlowForecast: db: %w", err)
+	}
+	rates, err := f.userRateTable(userId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CashFlowForecast: %w", err)
+	}
+	recurring, err := f.DB.RecurringItems(userId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CashFlowForecast: db: %w", err)
+	}
+
+	current := YearMonth{Month: int(now.Month()), Year: now.Year()}
+	history := Period{
+		Start: yearMonthFromIndex(current.index() - input.Window),
+		End:   yearMonthFromIndex(current.index() - 1),
+	}
+	// Everything up to the current month feeds the default starting balance, the window is a subset of it
+	totals, err := f.DB.BucketMonthTotalsUpTo(userId, current.Month, current.Year)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CashFlowForecast: db: %w", err)
+	}
+
+	missingRates := map[string]bool{}
+	allTimeNet := 0.0
+	bucketMonths := map[int][]float64{}
+	for _, t := range totals {
+		price, ok := rates.convert(t.Total, t.Currency, t.Month, t.Year)
+		if !ok {
+			missingRates[t.Currency] = true
+			continue
+		}
+		allTimeNet += price
+		col := YearMonth{Month: t.Month, Year: t.Year}.index() - history.Start.index()
+		if col < 0 || col >= input.Window {
+			continue
+		}
+		if _, ok := bucketMonths[t.BucketId]; !ok {
+			bucketMonths[t.BucketId] = make([]float64, input.Window)
+		}
+		bucketMonths[t.BucketId][col] += price
+	}
+
+	recurringByBucket := map[int][]database.RecurringItem{}
+	for _, item := range recurring {
+		recurringByBucket[item.BucketId] = append(recurringByBucket[item.BucketId], item)
+	}
+
+	forecast := &Forecast{
+		History:      history,
+		Buckets:      []BucketForecast{},
+		Months:       []ForecastMonth{},
+		BaseCurrency: rates.base,
+	}
+	if input.StartingBalance != nil {
+		forecast.StartingBalance = *input.StartingBalance
+	} else {
+		forecast.StartingBalance = allTimeNet
+	}
+
+	for _, b := range buckets {
+		bf := BucketForecast{Reference: b}
+		if items, ok := recurringByBucket[b.Id]; ok {
+			bf.FromRecurring = true
+			bf.Recurring = items
+		} else if values, ok := bucketMonths[b.Id]; ok {
+			bf.Average, bf.ExcludedMonths = trimmedAverage(values)
+		} else {
+			continue
+		}
+		forecast.Buckets = append(forecast.Buckets, bf)
+	}
+
+	balance := forecast.StartingBalance
+	for i := 1; i <= input.Months; i++ {
+		ym := yearMonthFromIndex(current.index() + i)
+		month := ForecastMonth{Month: ym}
+		for _, bf := range forecast.Buckets {
+			amounts := []float64{bf.Average}
+			if bf.FromRecurring {
+				amounts = []float64{}
+				for _, item := range bf.Recurring {
+					price, ok := rates.convert(item.Price, item.Currency, ym.Month, ym.Year)
+					if !ok {
+						missingRates[item.Currency] = true
+						continue
+					}
+					if item.IsExpense {
+						price = -price
+					}
+					amounts = append(amounts, price)
+				}
+			}
+			for _, amount := range amounts {
+				if amount < 0 {
+					month.Expenses += amount
+				} else {
+					month.Income += amount
+				}
+			}
+		}
+		balance += month.Income + month.Expenses
+		month.EndingBalance = balance
+		forecast.Months = append(forecast.Months, month)
+	}
+	forecast.MissingRates = sortedKeys(missingRates)
+
+	return forecast, nil, nil
+}
@@ -0,0 +1,123 @@
+package finance
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+	"wonk/storage"
+)
+
+func TestTrimmedAverage(t *testing.T) {
+	tests := []struct {
+		name         string
+		values       []float64
+		wantAverage  float64
+		wantExcluded int
+	}{
+		{name: "empty", values: nil, wantAverage: 0, wantExcluded: 0},
+		{name: "too few to trim", values: []float64{-100, -100, -1000}, wantAverage: -400, wantExcluded: 0},
+		{name: "no outliers", values: []float64{-90, -100, -110, -100}, wantAverage: -100, wantExcluded: 0},
+		{name: "one big month", values: []float64{-100, -110, -90, -105, -95, -2000}, wantAverage: -100, wantExcluded: 1},
+		{name: "both sides", values: []float64{50, -100, -110, -90, -105, -95, -2000}, wantAverage: -100, wantExcluded: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, excluded := trimmedAverage(tt.values)
+			if math.Abs(got-tt.wantAverage) > 0.001 {
+				t.Errorf("expected average %.2f, got %.2f", tt.wantAverage, got)
+			}
+			if excluded != tt.wantExcluded {
+				t.Errorf("expected %d excluded, got %d", tt.wantExcluded, excluded)
+			}
+		})
+	}
+}
+
+func TestCashFlowForecast(t *testing.T) {
+	f, userId := newTestFinance(t, "forecast")
+	db := f.DB
+	ctx := context.Background()
+	for _, name := range []string{"salary", "food", "rent"} {
+		_, err := f.CreateBucket(ctx, userId, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Salary 1000 and food 600 every month Jan-Jun, rent is logged too but comes from the recurring item
+	for month := 1; month <= 6; month++ {
+		for _, input := range []database.TransactionItemInput{
+			{Name: "pay", Price: 1000, IsExpense: false, BucketId: 1},
+			{Name: "groceries", Price: 600, IsExpense: true, BucketId: 2},
+			{Name: "rent", Price: 500, IsExpense: true, BucketId: 3},
+		} {
+			input.Month, input.Year, input.UserId, input.Currency = month, 2025, userId, database.DEFAULT_CURRENCY
+			_, err := db.CreateItemTransaction(input, database.AuditInfo{UserId: userId})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	_, err := db.CreateRecurringItem(database.RecurringItemInput{UserId: userId, BucketId: 3, Name: "rent", Price: 700, IsExpense: true, Currency: database.DEFAULT_CURRENCY, DayOfMonth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2025, 7, 10, 0, 0, 0, 0, time.UTC)
+	forecast, problems, err := f.CashFlowForecast(userId, ForecastInput{Months: 3, Window: 6}, now)
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	// 6 months of 1000 - 600 - 500
+	if forecast.StartingBalance != -600 {
+		t.Errorf("expected starting balance -600, got %.2f", forecast.StartingBalance)
+	}
+	wantMonths := []ForecastMonth{
+		{Month: YearMonth{Month: 8, Year: 2025}, Income: 1000, Expenses: -1300, EndingBalance: -900},
+		{Month: YearMonth{Month: 9, Year: 2025}, Income: 1000, Expenses: -1300, EndingBalance: -1200},
+		{Month: YearMonth{Month: 10, Year: 2025}, Income: 1000, Expenses: -1300, EndingBalance: -1500},
+	}
+	if len(forecast.Months) != len(wantMonths) {
+		t.Fatalf("expected %d months, got %d", len(wantMonths), len(forecast.Months))
+	}
+	for i, want := range wantMonths {
+		if forecast.Months[i] != want {
+			t.Errorf("month %d: expected %+v, got %+v", i, want, forecast.Months[i])
+		}
+	}
+
+	balance := 2000.0
+	forecast, _, err = f.CashFlowForecast(userId, ForecastInput{Months: 12, Window: 6, StartingBalance: &balance}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := forecast.FirstNegative()
+	// 2000 drops 300 a month so it goes negative in the 7th month
+	if first == nil || first.Month != (YearMonth{Month: 2, Year: 2026}) {
+		t.Errorf("expected Feb 2026 to be the first negative month, got %+v", first)
+	}
+
+	_, problems, err = f.CashFlowForecast(userId, ForecastInput{Months: 30, Window: 0}, now)
+	if err != nil || len(problems) != 2 {
+		t.Errorf("expected months and window problems, got %v %v", problems, err)
+	}
+
+	// However old a transaction is it's in the starting balance, months after now aren't
+	for _, input := range []database.TransactionItemInput{
+		{Name: "old pay", Month: 12, Year: 1999, Price: 250, BucketId: 1},
+		{Name: "future pay", Month: 9, Year: 2025, Price: 5000, BucketId: 1},
+	} {
+		input.UserId, input.Currency = userId, database.DEFAULT_CURRENCY
+		_, err := db.CreateItemTransaction(input, database.AuditInfo{UserId: userId})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	forecast, _, err = f.CashFlowForecast(userId, ForecastInput{Months: 3, Window: 6}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forecast.StartingBalance != -350 {
+		t.Errorf("expected starting balance -350, got %.2f", forecast.StartingBalance)
+	}
+}
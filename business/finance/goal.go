@@ -0,0 +1,180 @@
+package finance
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+// Months counted inclusively from the month of from to the month of to, 0 when to is before from
+func monthsBetween(from, to time.Time) int {
+	months := (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month()) + 1
+	return max(0, months)
+}
+
+// Works out progress, the monthly contribution needed to finish by the target date and when the goal
+// finishes at the average pace since it was created
+func goalProgress(goal database.SavingsGoal, saved float64, now time.Time) GoalProgress {
+	p := GoalProgress{Goal: goal, Saved: saved}
+	p.Remaining = max(0, goal.TargetAmount-saved)
+	p.Percent = min(100, max(0, saved/goal.TargetAmount*100))
+	targetDate, err := time.ParseInLocation(database.RATE_DATE_FORMAT, goal.TargetDate, now.Location())
+	if err != nil {
+		return p
+	}
+	if p.Remaining == 0 {
+		p.Complete = true
+		return p
+	}
+
+	p.MonthsLeft = monthsBetween(now, targetDate)
+	if p.MonthsLeft > 0 {
+		required := p.Remaining / float64(p.MonthsLeft)
+		p.RequiredMonthly = &required
+	}
+
+	// The month the goal was created counts, so a goal always has at least one month of history
+	created := time.Unix(goal.CreatedAt, 0).In(now.Location())
+	monthsSaving := max(1, monthsBetween(created, now))
+	pace := saved / float64(monthsSaving)
+	if pace > 0 {
+		monthsToGo := int(math.Ceil(p.Remaining / pace))
+		projected := time.Date(now.Year(), now.Month()+time.Month(monthsToGo), 1, 0, 0, 0, 0, now.Location())
+		p.ProjectedCompletion = &projected
+		p.OnTrack = !projected.After(time.Date(targetDate.Year(), targetDate.Month(), 1, 0, 0, 0, 0, now.Location()))
+	}
+	return p
+}
+
+// The amount saved towards the goal in the base currency. Money moved into a linked bucket is logged as
+// an expense so the bucket's expenses count as savings and its income counts as withdrawals.
+// totals has to cover every month since the goal was created.
+func (f *FinanceLogic) goalSaved(userId int, goal database.SavingsGoal, totals []database.BucketMonthTotal, rates *rateTable, loc *time.Location) (float64, []string, error) {
+	if goal.BucketId == nil {
+		contributions, err := f.DB.GoalContributions(userId, goal.Id)
+		if err != nil {
+			return 0, nil, err
+		}
+		saved := 0.0
+		for _, c := range contributions {
+			saved += c.Amount
+		}
+		return saved, nil, nil
+	}
+
+	created := time.Unix(goal.CreatedAt, 0).In(loc)
+	saved := 0.0
+	missing := map[string]bool{}
+	for _, t := range totals {
+		if t.BucketId != *goal.BucketId {
+			continue
+		}
+		// Only months since the goal was created count towards it
+		if t.Year*12+t.Month < created.Year()*12+int(created.Month()) {
+			continue
+		}
+		price, ok := rates.convert(t.Total, t.Currency, t.Month, t.Year)
+		if !ok {
+			missing[t.Currency] = true
+			continue
+		}
+		saved -= price
+	}
+	return saved, sortedKeys(missing), nil
+}
+
+func (f *FinanceLogic) SavingsGoals(userId int, now time.Time) ([]GoalProgress, error) {
+	goals, err := f.DB.SavingsGoals(userId)
+	if err != nil {
+		return nil, fmt.Errorf("SavingsGoals: db: %w", err)
+	}
+	rates, err := f.userRateTable(userId)
+	if err != nil {
+		return nil, fmt.Errorf("SavingsGoals: %w", err)
+	}
+	// One query covers every linked goal, from the month the earliest of them was created
+	var earliest *time.Time
+	for _, goal := range goals {
+		created := time.Unix(goal.CreatedAt, 0).In(now.Location())
+		if goal.BucketId != nil && (earliest == nil || created.Before(*earliest)) {
+			earliest = &created
+		}
+	}
+	totals := []database.BucketMonthTotal{}
+	if earliest != nil {
+		totals, err = f.DB.BucketMonthTotals(userId, int(earliest.Month()), earliest.Year(), int(now.Month()), now.Year())
+		if err != nil {
+			return nil, fmt.Errorf("SavingsGoals: db: %w", err)
+		}
+	}
+
+	progress := []GoalProgress{}
+	for _, goal := range goals {
+		saved, missing, err := f.goalSaved(userId, goal, totals, rates, now.Location())
+		if err != nil {
+			return nil, fmt.Errorf("SavingsGoals: db: %w", err)
+		}
+		p := goalProgress(goal, saved, now)
+		p.BaseCurrency = rates.base
+		p.MissingRates = missing
+		progress = append(progress, p)
+	}
+	return progress, nil
+}
+
+func (f *FinanceLogic) AddSavingsGoal(userId int, input database.SavingsGoalInput) (map[string]string, error) {
+	input.UserId = userId
+	input.CreatedAt = time.Now().Unix()
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	if input.BucketId != nil {
+		problems, err := f.userBucketProblem(userId, *input.BucketId)
+		if err != nil {
+			return nil, fmt.Errorf("AddSavingsGoal: db: %w", err)
+		}
+		if len(problems) > 0 {
+			return problems, nil
+		}
+	}
+	_, err := f.DB.CreateSavingsGoal(input)
+	if err != nil {
+		return nil, fmt.Errorf("AddSavingsGoal: db: %w", err)
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) DeleteSavingsGoal(userId, goalId int) error {
+	rowsChanged, err := f.DB.SavingsGoalDelete(goalId, userId)
+	if err != nil {
+		return fmt.Errorf("DeleteSavingsGoal: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return fmt.Errorf("DeleteSavingsGoal: %w", cuserr.NotFound{Item: "savings goal"})
+	}
+	return nil
+}
+
+// Contributions can only be added to goals that aren't linked to a bucket
+func (f *FinanceLogic) AddGoalContribution(userId int, input database.GoalContributionInput) (map[string]string, error) {
+	input.UserId = userId
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	goal, err := f.DB.SavingsGoalById(input.GoalId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("AddGoalContribution: db: %w", err)
+	}
+	if goal.BucketId != nil {
+		return map[string]string{"GoalId": "Goal tracks a bucket, add a transaction to the bucket instead"}, nil
+	}
+	_, err = f.DB.CreateGoalContribution(input)
+	if err != nil {
+		return nil, fmt.Errorf("AddGoalContribution: db: %w", err)
+	}
+	return nil, nil
+}
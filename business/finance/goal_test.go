@@ -0,0 +1,151 @@
+package finance
+
+import (
+	"math"
+	"testing"
+	"time"
+	"wonk/storage"
+)
+
+func TestGoalProgress(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	created := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC).Unix()
+	goal := database.SavingsGoal{Name: "car", TargetAmount: 12000, TargetDate: "2025-12-31", CreatedAt: created}
+
+	tests := []struct {
+		name           string
+		saved          float64
+		targetDate     string
+		wantMonthsLeft int
+		wantRequired   *float64
+		wantProjected  *time.Time
+		wantOnTrack    bool
+		wantComplete   bool
+		wantPercent    float64
+	}{
+		{
+			// 6000 over Jan-Jun is 1000 a month, 6000 left takes 6 more months
+			name: "on pace", saved: 6000, targetDate: "2025-12-31",
+			wantMonthsLeft: 7, wantRequired: floatPtr(6000.0 / 7), wantProjected: datePtr(2025, 12), wantOnTrack: true, wantPercent: 50,
+		},
+		{
+			name: "ahead of pace", saved: 9000, targetDate: "2025-12-31",
+			wantMonthsLeft: 7, wantRequired: floatPtr(3000.0 / 7), wantProjected: datePtr(2025, 8), wantOnTrack: true, wantPercent: 75,
+		},
+		{
+			name: "won't make it", saved: 3000, targetDate: "2025-09-01",
+			wantMonthsLeft: 4, wantRequired: floatPtr(9000.0 / 4), wantProjected: datePtr(2026, 12), wantOnTrack: false, wantPercent: 25,
+		},
+		{
+			name: "nothing saved", saved: 0, targetDate: "2025-12-31",
+			wantMonthsLeft: 7, wantRequired: floatPtr(12000.0 / 7), wantProjected: nil, wantOnTrack: false, wantPercent: 0,
+		},
+		{
+			name: "target date passed", saved: 6000, targetDate: "2025-03-01",
+			wantMonthsLeft: 0, wantRequired: nil, wantProjected: datePtr(2025, 12), wantOnTrack: false, wantPercent: 50,
+		},
+		{
+			name: "complete", saved: 13000, targetDate: "2025-12-31",
+			wantComplete: true, wantPercent: 100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := goal
+			g.TargetDate = tt.targetDate
+			got := goalProgress(g, tt.saved, now)
+			if got.Complete != tt.wantComplete {
+				t.Errorf("complete: expected %v, got %v", tt.wantComplete, got.Complete)
+			}
+			if got.Percent != tt.wantPercent {
+				t.Errorf("percent: expected %v, got %v", tt.wantPercent, got.Percent)
+			}
+			if got.MonthsLeft != tt.wantMonthsLeft {
+				t.Errorf("months left: expected %d, got %d", tt.wantMonthsLeft, got.MonthsLeft)
+			}
+			if (got.RequiredMonthly == nil) != (tt.wantRequired == nil) ||
+				(got.RequiredMonthly != nil && math.Abs(*got.RequiredMonthly-*tt.wantRequired) > 0.001) {
+				t.Errorf("required monthly: expected %v, got %v", tt.wantRequired, got.RequiredMonthly)
+			}
+			if (got.ProjectedCompletion == nil) != (tt.wantProjected == nil) ||
+				(got.ProjectedCompletion != nil && !got.ProjectedCompletion.Equal(*tt.wantProjected)) {
+				t.Errorf("projected: expected %v, got %v", tt.wantProjected, got.ProjectedCompletion)
+			}
+			if got.OnTrack != tt.wantOnTrack {
+				t.Errorf("on track: expected %v, got %v", tt.wantOnTrack, got.OnTrack)
+			}
+		})
+	}
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func datePtr(year int, month time.Month) *time.Time {
+	d := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return &d
+}
+
+func TestSavingsGoals(t *testing.T) {
+	f, userId := newTestFinance(t, "goals")
+	audit := database.AuditInfo{UserId: userId}
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	bucketId, err := f.DB.CreateBucket(userId, "Savings", audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []database.TransactionItemInput{
+		// Before either goal was created
+		{Name: "moved", Month: 12, Year: 2024, Price: 1000, IsExpense: true, Currency: "USD"},
+		{Name: "moved", Month: 2, Year: 2025, Price: 300, IsExpense: true, Currency: "USD"},
+		{Name: "moved", Month: 4, Year: 2025, Price: 500, IsExpense: true, Currency: "USD"},
+		{Name: "withdrawn", Month: 5, Year: 2025, Price: 100, Currency: "USD"},
+		// No rate, in two months so it could be reported twice
+		{Name: "moved", Month: 4, Year: 2025, Price: 10, IsExpense: true, Currency: "GBP"},
+		{Name: "moved", Month: 5, Year: 2025, Price: 10, IsExpense: true, Currency: "GBP"},
+	} {
+		input.UserId = userId
+		input.BucketId = bucketId
+		_, err := f.DB.CreateItemTransaction(input, audit)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, input := range []database.SavingsGoalInput{
+		{Name: "since february", TargetAmount: 5000, TargetDate: "2025-12-31", BucketId: &bucketId, CreatedAt: time.Date(2025, 2, 10, 0, 0, 0, 0, time.UTC).Unix()},
+		{Name: "since april", TargetAmount: 5000, TargetDate: "2025-12-31", BucketId: &bucketId, CreatedAt: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC).Unix()},
+		{Name: "by hand", TargetAmount: 5000, TargetDate: "2025-12-31", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()},
+	} {
+		input.UserId = userId
+		_, err := f.DB.CreateSavingsGoal(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	progress, err := f.SavingsGoals(userId, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct {
+		saved   float64
+		missing int
+	}{
+		"since february": {saved: 700, missing: 1},
+		"since april":    {saved: 400, missing: 1},
+		"by hand":        {saved: 0, missing: 0},
+	}
+	if len(progress) != len(want) {
+		t.Fatalf("expected %d goals, got %d", len(want), len(progress))
+	}
+	for _, p := range progress {
+		w := want[p.Goal.Name]
+		if p.Saved != w.saved {
+			t.Errorf("%s: expected %v saved, got %v", p.Goal.Name, w.saved, p.Saved)
+		}
+		if len(p.MissingRates) != w.missing {
+			t.Errorf("%s: expected %d missing rates, got %v", p.Goal.Name, w.missing, p.MissingRates)
+		}
+	}
+}
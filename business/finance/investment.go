@@ -0,0 +1,357 @@
+package finance
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+const (
+	MAX_PRICE_IMPORT_ROWS = 5000
+	// Quantities closer than this are equal, so selling a whole lot doesn't leave float dust behind
+	QUANTITY_EPSILON = 1e-9
+)
+
+// Returned when a sell is for more units than the account held at the time
+type OversoldError struct {
+	Symbol    string
+	TradeDate string
+	Held      float64
+}
+
+func (e OversoldError) Error() string {
+	return fmt.Sprintf("sell of %s on %s is more than the %g held", e.Symbol, e.TradeDate, e.Held)
+}
+
+// Replays one account's trades in order. Buys open lots that carry their fees in the unit cost,
+// sells close the oldest lots first (FIFO) and realize the proceeds after fees less the cost of the closed units.
+func replayTrades(trades []database.InvestmentTrade) (map[string]*Holding, error) {
+	holdings := map[string]*Holding{}
+	for _, t := range trades {
+		h, ok := holdings[t.Symbol]
+		if !ok {
+			h = &Holding{Symbol: t.Symbol}
+			holdings[t.Symbol] = h
+		}
+		switch t.Kind {
+		case database.TRADE_KIND_BUY:
+			h.Lots = append(h.Lots, Lot{
+				Acquired: t.TradeDate,
+				Quantity: t.Quantity,
+				UnitCost: (t.Quantity*t.Price + t.Fees) / t.Quantity,
+			})
+		case database.TRADE_KIND_SELL:
+			held := h.Quantity()
+			if t.Quantity > held+QUANTITY_EPSILON {
+				return nil, OversoldError{Symbol: t.Symbol, TradeDate: t.TradeDate, Held: held}
+			}
+			remaining := t.Quantity
+			cost := 0.0
+			for remaining > QUANTITY_EPSILON && len(h.Lots) > 0 {
+				lot := &h.Lots[0]
+				closed := min(lot.Quantity, remaining)
+				cost += closed * lot.UnitCost
+				lot.Quantity -= closed
+				remaining -= closed
+				if lot.Quantity <= QUANTITY_EPSILON {
+					h.Lots = h.Lots[1:]
+				}
+			}
+			h.RealizedGain += t.Quantity*t.Price - t.Fees - cost
+		case database.TRADE_KIND_DIVIDEND:
+			h.Dividends += t.Price - t.Fees
+		}
+	}
+	return holdings, nil
+}
+
+// Latest price of every symbol dated on or before the given day
+func latestPrices(prices []database.SecurityPrice, day string) map[string]database.SecurityPrice {
+	latest := map[string]database.SecurityPrice{}
+	for _, p := range prices {
+		if p.PriceDate > day {
+			continue
+		}
+		if cur, ok := latest[p.Symbol]; !ok || p.PriceDate >= cur.PriceDate {
+			latest[p.Symbol] = p
+		}
+	}
+	return latest
+}
+
+// Holdings, gains and allocation of every investment account valued at the latest prices as of now.
+// Holdings without a price aren't valued, allocation is in the base currency at the current month's rates.
+func (f *FinanceLogic) Portfolio(userId int, now time.Time) (*Portfolio, error) {
+	accounts, err := f.DB.InvestmentAccounts(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Portfolio: db: %w", err)
+	}
+	trades, err := f.DB.InvestmentTrades(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Portfolio: db: %w", err)
+	}
+	securities, err := f.DB.Securities(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Portfolio: db: %w", err)
+	}
+	prices, err := f.DB.SecurityPrices(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Portfolio: db: %w", err)
+	}
+	rates, err := f.userRateTable(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Portfolio: %w", err)
+	}
+
+	assetClasses := map[string]string{}
+	for _, s := range securities {
+		assetClasses[s.Symbol] = s.AssetClass
+	}
+	latest := latestPrices(prices, now.Format(database.RATE_DATE_FORMAT))
+	portfolio := &Portfolio{BaseCurrency: rates.base}
+	for _, p := range latest {
+		portfolio.Prices = append(portfolio.Prices, p)
+	}
+	sort.Slice(portfolio.Prices, func(i, j int) bool {
+		return portfolio.Prices[i].Symbol < portfolio.Prices[j].Symbol
+	})
+
+	allocation := map[string]float64{}
+	missingRates := map[string]bool{}
+	for _, a := range accounts {
+		summary := InvestmentAccountSummary{Account: a}
+		accountTrades := []database.InvestmentTrade{}
+		for _, t := range trades {
+			if t.AccountId == a.Id {
+				accountTrades = append(accountTrades, t)
+			}
+		}
+		holdings, err := replayTrades(accountTrades)
+		if err != nil {
+			// Trades are checked when they are added or removed, so this only happens if the db was edited by hand
+			return nil, fmt.Errorf("Portfolio: account %d: %w", a.Id, err)
+		}
+		for _, h := range holdings {
+			h.AssetClass = assetClasses[h.Symbol]
+			if h.AssetClass == "" {
+				h.AssetClass = "Other"
+			}
+			if p, ok := latest[h.Symbol]; ok {
+				price := p.Price
+				h.Price = &price
+				h.PriceDate = p.PriceDate
+			}
+			summary.Holdings = append(summary.Holdings, *h)
+			if h.Price == nil || h.Quantity() <= QUANTITY_EPSILON {
+				continue
+			}
+			value, ok := rates.convert(h.MarketValue(), a.Currency, int(now.Month()), now.Year())
+			if !ok {
+				missingRates[a.Currency] = true
+				continue
+			}
+			allocation[h.AssetClass] += value
+			portfolio.TotalValue += value
+		}
+		sort.Slice(summary.Holdings, func(i, j int) bool {
+			return summary.Holdings[i].Symbol < summary.Holdings[j].Symbol
+		})
+		for i := len(accountTrades) - 1; i >= 0; i-- {
+			summary.Trades = append(summary.Trades, accountTrades[i])
+		}
+		portfolio.Accounts = append(portfolio.Accounts, summary)
+	}
+
+	for class, value := range allocation {
+		a := AssetAllocation{AssetClass: class, Value: value}
+		if portfolio.TotalValue > 0 {
+			a.Percent = value / portfolio.TotalValue * 100
+		}
+		portfolio.Allocation = append(portfolio.Allocation, a)
+	}
+	sort.Slice(portfolio.Allocation, func(i, j int) bool {
+		return portfolio.Allocation[i].Value > portfolio.Allocation[j].Value
+	})
+	portfolio.MissingRates = sortedKeys(missingRates)
+	return portfolio, nil
+}
+
+func (f *FinanceLogic) AddInvestmentAccount(userId int, input database.InvestmentAccountInput) (map[string]string, error) {
+	input.UserId = userId
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	_, err := f.DB.CreateInvestmentAccount(input)
+	if err != nil {
+		return nil, fmt.Errorf("AddInvestmentAccount: db: %w", err)
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) DeleteInvestmentAccount(userId, accountId int) error {
+	rowsChanged, err := f.DB.InvestmentAccountDelete(accountId, userId)
+	if err != nil {
+		return fmt.Errorf("DeleteInvestmentAccount: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return fmt.Errorf("DeleteInvestmentAccount: %w", cuserr.NotFound{Item: "investment account"})
+	}
+	return nil
+}
+
+// The account's trades with the change applied, in the order they are replayed
+func accountTradesWith(trades []database.InvestmentTrade, accountId int, add *database.InvestmentTrade, removeId int) []database.InvestmentTrade {
+	result := []database.InvestmentTrade{}
+	for _, t := range trades {
+		if t.AccountId == accountId && t.Id != removeId {
+			result = append(result, t)
+		}
+	}
+	if add != nil {
+		result = append(result, *add)
+	}
+	// Stable so a new trade goes after the existing ones on the same day, like its id would put it
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].TradeDate < result[j].TradeDate
+	})
+	return result
+}
+
+// Rejects sells of more than the account held on the trade date, including a backdated buy's effect on later sells
+func (f *FinanceLogic) AddInvestmentTrade(userId int, input database.InvestmentTradeInput) (map[string]string, error) {
+	input.UserId = userId
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	accounts, err := f.DB.InvestmentAccounts(userId)
+	if err != nil {
+		return nil, fmt.Errorf("AddInvestmentTrade: db: %w", err)
+	}
+	found := false
+	for _, a := range accounts {
+		found = found || a.Id == input.AccountId
+	}
+	if !found {
+		return nil, fmt.Errorf("AddInvestmentTrade: %w", cuserr.NotFound{Item: "investment account"})
+	}
+	trades, err := f.DB.InvestmentTrades(userId)
+	if err != nil {
+		return nil, fmt.Errorf("AddInvestmentTrade: db: %w", err)
+	}
+	newTrade := database.InvestmentTrade{
+		AccountId: input.AccountId,
+		Symbol:    input.Symbol,
+		Kind:      input.Kind,
+		Quantity:  input.Quantity,
+		Price:     input.Price,
+		Fees:      input.Fees,
+		TradeDate: input.TradeDate,
+	}
+	_, err = replayTrades(accountTradesWith(trades, input.AccountId, &newTrade, 0))
+	var oversold OversoldError
+	if errors.As(err, &oversold) {
+		return map[string]string{"Quantity": fmt.Sprintf("Only %g %s held on %s", oversold.Held, oversold.Symbol, oversold.TradeDate)}, nil
+	}
+	_, err = f.DB.CreateInvestmentTrade(input)
+	if err != nil {
+		return nil, fmt.Errorf("AddInvestmentTrade: db: %w", err)
+	}
+	return nil, nil
+}
+
+// A buy can't be removed while a later sell depends on it
+func (f *FinanceLogic) DeleteInvestmentTrade(userId, tradeId int) (map[string]string, error) {
+	trades, err := f.DB.InvestmentTrades(userId)
+	if err != nil {
+		return nil, fmt.Errorf("DeleteInvestmentTrade: db: %w", err)
+	}
+	accountId := 0
+	for _, t := range trades {
+		if t.Id == tradeId {
+			accountId = t.AccountId
+		}
+	}
+	if accountId == 0 {
+		return nil, fmt.Errorf("DeleteInvestmentTrade: %w", cuserr.NotFound{Item: "trade"})
+	}
+	_, err = replayTrades(accountTradesWith(trades, accountId, nil, tradeId))
+	var oversold OversoldError
+	if errors.As(err, &oversold) {
+		return map[string]string{"Trade": fmt.Sprintf("The sell of %s on %s needs this trade, remove it first", oversold.Symbol, oversold.TradeDate)}, nil
+	}
+	_, err = f.DB.InvestmentTradeDelete(tradeId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("DeleteInvestmentTrade: db: %w", err)
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) SetAssetClass(userId int, input database.SecurityInput) (map[string]string, error) {
+	input.UserId = userId
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	err := f.DB.UpsertSecurity(input)
+	if err != nil {
+		return nil, fmt.Errorf("SetAssetClass: db: %w", err)
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) AddSecurityPrice(userId int, input database.SecurityPriceInput) (map[string]string, error) {
+	input.UserId = userId
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	err := f.DB.UpsertSecurityPrice(input)
+	if err != nil {
+		return nil, fmt.Errorf("AddSecurityPrice: db: %w", err)
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) DeleteSecurityPrice(userId, priceId int) error {
+	rowsChanged, err := f.DB.SecurityPriceDelete(priceId, userId)
+	if err != nil {
+		return fmt.Errorf("DeleteSecurityPrice: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return fmt.Errorf("DeleteSecurityPrice: %w", cuserr.NotFound{Item: "price"})
+	}
+	return nil
+}
+
+// Reads a CSV with a header naming the date, symbol and price columns. Nothing is saved unless every row is valid.
+func (f *FinanceLogic) ImportSecurityPrices(userId int, file io.Reader) (int, map[string]string, error) {
+	inputs := []database.SecurityPriceInput{}
+	problems := readCsvImport(file, []string{"date", "symbol", "price"}, MAX_PRICE_IMPORT_ROWS, func(row csvRow) {
+		input := database.SecurityPriceInput{
+			UserId:    userId,
+			Symbol:    strings.ToUpper(row.value("symbol")),
+			Price:     row.decimal("price", "Price"),
+			PriceDate: row.value("date"),
+		}
+		row.addProblems(input.Valid())
+		if row.ok() {
+			inputs = append(inputs, input)
+		}
+	})
+	if len(problems) > 0 {
+		return 0, problems, nil
+	}
+
+	err := f.DB.UpsertSecurityPrices(inputs)
+	if err != nil {
+		return 0, nil, fmt.Errorf("ImportSecurityPrices: db: %w", err)
+	}
+	return len(inputs), nil, nil
+}
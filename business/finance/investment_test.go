@@ -0,0 +1,186 @@
+package finance
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"wonk/storage"
+)
+
+func TestReplayTrades(t *testing.T) {
+	trades := []database.InvestmentTrade{
+		{Symbol: "ABC", Kind: database.TRADE_KIND_BUY, Quantity: 10, Price: 100, Fees: 10, TradeDate: "2025-01-02"},
+		{Symbol: "ABC", Kind: database.TRADE_KIND_BUY, Quantity: 10, Price: 120, TradeDate: "2025-02-03"},
+		{Symbol: "XYZ", Kind: database.TRADE_KIND_BUY, Quantity: 3, Price: 50, TradeDate: "2025-02-03"},
+		// Closes the whole first lot and half of the second
+		{Symbol: "ABC", Kind: database.TRADE_KIND_SELL, Quantity: 15, Price: 130, Fees: 5, TradeDate: "2025-03-04"},
+		{Symbol: "ABC", Kind: database.TRADE_KIND_DIVIDEND, Price: 20, Fees: 1, TradeDate: "2025-03-31"},
+		{Symbol: "XYZ", Kind: database.TRADE_KIND_SELL, Quantity: 3, Price: 40, TradeDate: "2025-04-01"},
+	}
+	holdings, err := replayTrades(trades)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	abc := holdings["ABC"]
+	if len(abc.Lots) != 1 || abc.Lots[0] != (Lot{Acquired: "2025-02-03", Quantity: 5, UnitCost: 120}) {
+		t.Errorf("expected one lot of 5 at 120 left, got %+v", abc.Lots)
+	}
+	// 15*130 - 5 in proceeds less 10 units at 101 and 5 at 120
+	if math.Abs(abc.RealizedGain-335) > 1e-9 {
+		t.Errorf("expected ABC realized gain of 335, got %v", abc.RealizedGain)
+	}
+	if abc.Dividends != 19 {
+		t.Errorf("expected ABC dividends of 19, got %v", abc.Dividends)
+	}
+	price := 150.0
+	abc.Price = &price
+	if abc.MarketValue() != 750 || abc.UnrealizedGain() != 150 {
+		t.Errorf("expected a market value of 750 and unrealized gain of 150, got %v and %v", abc.MarketValue(), abc.UnrealizedGain())
+	}
+
+	xyz := holdings["XYZ"]
+	if xyz.Quantity() != 0 || xyz.CostBasis() != 0 || xyz.RealizedGain != -30 {
+		t.Errorf("expected XYZ sold off at a loss of 30, got %+v", xyz)
+	}
+
+	oversold := append(trades, database.InvestmentTrade{Symbol: "ABC", Kind: database.TRADE_KIND_SELL, Quantity: 6, Price: 1, TradeDate: "2025-05-01"})
+	_, err = replayTrades(oversold)
+	var oversoldErr OversoldError
+	if !errors.As(err, &oversoldErr) || oversoldErr.Held != 5 {
+		t.Errorf("expected an oversold error with 5 held, got %v", err)
+	}
+}
+
+func TestPortfolio(t *testing.T) {
+	f, userId := newTestFinance(t, "invest")
+	db := f.DB
+	problems, err := f.AddInvestmentAccount(userId, database.InvestmentAccountInput{Name: "Broker", Currency: "USD"})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	accounts, err := db.InvestmentAccounts(userId)
+	if err != nil || len(accounts) != 1 {
+		t.Fatal(accounts, err)
+	}
+	accountId := accounts[0].Id
+
+	add := func(kind, symbol string, quantity, price float64, date string) map[string]string {
+		t.Helper()
+		problems, err := f.AddInvestmentTrade(userId, database.InvestmentTradeInput{
+			AccountId: accountId, Symbol: symbol, Kind: kind, Quantity: quantity, Price: price, TradeDate: date,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return problems
+	}
+	if problems := add(database.TRADE_KIND_BUY, "ABC", 10, 10, "2025-01-10"); len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	if problems := add(database.TRADE_KIND_BUY, "BND", 5, 20, "2025-01-10"); len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	if problems := add(database.TRADE_KIND_SELL, "ABC", 4, 12, "2025-02-10"); len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	// A backdated sell before the buy is more than was held at the time
+	if problems := add(database.TRADE_KIND_SELL, "BND", 1, 20, "2025-01-01"); problems["Quantity"] == "" {
+		t.Errorf("expected a quantity problem for a sell before the buy, got %v", problems)
+	}
+
+	// The ABC buy can't go while the sell depends on it
+	trades, err := db.InvestmentTrades(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err = f.DeleteInvestmentTrade(userId, trades[0].Id)
+	if err != nil || problems["Trade"] == "" {
+		t.Errorf("expected a problem removing the ABC buy, got %v %v", problems, err)
+	}
+
+	problems, err = f.SetAssetClass(userId, database.SecurityInput{Symbol: "BND", AssetClass: "Bond"})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	for _, p := range []database.SecurityPriceInput{
+		{Symbol: "ABC", Price: 15, PriceDate: "2025-03-01"},
+		// After now so it's ignored
+		{Symbol: "ABC", Price: 99, PriceDate: "2025-05-01"},
+		{Symbol: "BND", Price: 18, PriceDate: "2025-02-01"},
+	} {
+		problems, err := f.AddSecurityPrice(userId, p)
+		if err != nil || len(problems) > 0 {
+			t.Fatal(problems, err)
+		}
+	}
+
+	portfolio, err := f.Portfolio(userId, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := portfolio.Accounts[0]
+	if summary.MarketValue() != 180 || summary.CostBasis() != 160 || summary.RealizedGain() != 8 {
+		t.Errorf("expected value 180, cost 160 and realized 8, got %v %v %v", summary.MarketValue(), summary.CostBasis(), summary.RealizedGain())
+	}
+	want := []AssetAllocation{
+		{AssetClass: "Bond", Value: 90, Percent: 50},
+		{AssetClass: "Other", Value: 90, Percent: 50},
+	}
+	if len(portfolio.Allocation) != 2 || portfolio.TotalValue != 180 {
+		t.Fatalf("expected 2 asset classes totaling 180, got %+v", portfolio.Allocation)
+	}
+	for _, w := range want {
+		found := false
+		for _, a := range portfolio.Allocation {
+			found = found || a == w
+		}
+		if !found {
+			t.Errorf("expected %+v in %+v", w, portfolio.Allocation)
+		}
+	}
+}
+
+func TestImportSecurityPrices(t *testing.T) {
+	tests := []struct {
+		name     string
+		csv      string
+		imported int
+		problems map[string]string
+	}{
+		{name: "valid", csv: "Symbol, Price, Date\nvti,250.5,2025-01-31\nBND,72,2025-01-31\n", imported: 2},
+		{
+			name:     "one bad row saves nothing",
+			csv:      "date,symbol,price\n2025-01-31,VTI,250\n31/01/2025,not a symbol!,cheap\n",
+			problems: map[string]string{"Line 3": "Price: Price is not a decimal; PriceDate: Date must be YYYY-MM-DD; Symbol: Invalid Symbol"},
+		},
+		{name: "missing column", csv: "date,price\n2025-01-31,250\n", problems: map[string]string{"File": "Missing column: symbol"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, userId := newTestFinance(t, "prices")
+			imported, problems, err := f.ImportSecurityPrices(userId, strings.NewReader(tt.csv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if imported != tt.imported {
+				t.Errorf("expected %d imported, got %d", tt.imported, imported)
+			}
+			if len(problems) > 0 || len(tt.problems) > 0 {
+				if !reflect.DeepEqual(problems, tt.problems) {
+					t.Errorf("expected problems %v, got %v", tt.problems, problems)
+				}
+			}
+			saved, err := f.DB.SecurityPrices(userId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != tt.imported {
+				t.Errorf("expected %d saved, got %d", tt.imported, len(saved))
+			}
+		})
+	}
+}
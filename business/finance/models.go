@@ -3,6 +3,7 @@ package finance
 import (
 	"strconv"
 	"strings"
+	"time"
 	database "wonk/storage"
 )
 
@@ -15,6 +16,8 @@ type MonthSummary struct {
 	BucketsSummary []BucketSummary
 	TotalIncome    float64
 	TotalExpense   float64
+	BaseCurrency   string
+	MissingRates   []string // Currencies left out of the totals because they have no exchange rate
 }
 
 type TransactionEdit struct {
@@ -24,6 +27,7 @@ type TransactionEdit struct {
 	Year          int
 	Price         float64
 	BucketId      int
+	Currency      string
 }
 
 func (t *TransactionEdit) Valid() map[string]string {
@@ -66,6 +70,10 @@ func (t *TransactionEdit) Valid() map[string]string {
 		problems["BucketId"] = "Invalid BucketId"
 	}
 
+	if !database.IsCurrencyCode(t.Currency) {
+		problems["Currency"] = "Invalid Currency"
+	}
+
 	return problems
 }
 
@@ -76,3 +84,450 @@ type TransactionFilters struct {
 	Year     *int
 	BucketId *int
 }
+
+type Trash struct {
+	Transactions []database.TransactionItem
+	Buckets      []database.Bucket
+}
+
+type HistoryEntry struct {
+	Action    string
+	UserId    int
+	RequestId string
+	CreatedAt time.Time
+	Changes   []FieldChange
+}
+
+type FieldChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// A transaction with its price converted into the user's base currency
+type ConvertedTransaction struct {
+	database.TransactionItem
+	BaseCurrency   string
+	ConvertedPrice *float64 // nil when there is no exchange rate for the transaction's month
+}
+
+// One bucket's totals for each month of a range summary
+type BucketRangeRow struct {
+	Reference database.Bucket
+	Months    []float64 // Same order as RangeSummary.Months
+	Total     float64
+}
+
+// Monthly average of the bucket over the whole range
+func (b BucketRangeRow) Average() float64 {
+	if len(b.Months) == 0 {
+		return 0
+	}
+	return b.Total / float64(len(b.Months))
+}
+
+type RangeSummary struct {
+	Period       Period
+	Months       []YearMonth
+	Rows         []BucketRangeRow
+	MonthTotals  []float64 // Net of every bucket per month
+	Total        float64
+	BaseCurrency string
+	MissingRates []string
+}
+
+// Average net per month over the range
+func (r RangeSummary) MonthlyAverage() float64 {
+	if len(r.Months) == 0 {
+		return 0
+	}
+	return r.Total / float64(len(r.Months))
+}
+
+// Average bucket total for the month at index i
+func (r RangeSummary) BucketAverage(i int) float64 {
+	if len(r.Rows) == 0 {
+		return 0
+	}
+	return r.MonthTotals[i] / float64(len(r.Rows))
+}
+
+type BudgetProgress struct {
+	Bucket       database.Bucket
+	Budget       database.Budget
+	Spent        float64 // Positive amount spent this month
+	Remaining    float64 // Negative once the budget is over
+	MonthElapsed float64 // Fraction of the month that has passed, 0-1
+	Projected    float64 // Spending at the end of the month at the current pace
+	BaseCurrency string
+}
+
+func (b BudgetProgress) PercentUsed() float64 {
+	return b.Spent / b.Budget.Amount * 100
+}
+
+// True when spending is ahead of how much of the month has passed
+func (b BudgetProgress) OverPace() bool {
+	return b.Spent/b.Budget.Amount > b.MonthElapsed
+}
+
+type UpcomingItem struct {
+	Item       database.RecurringItem
+	BucketName string
+	DueDate    time.Time
+}
+
+type BucketChange struct {
+	Reference database.Bucket
+	Previous  float64
+	Current   float64
+}
+
+func (b BucketChange) Change() float64 {
+	return b.Current - b.Previous
+}
+
+type MonthChange struct {
+	Current      YearMonth
+	Previous     YearMonth
+	CurrentNet   float64
+	PreviousNet  float64
+	Buckets      []BucketChange // Sorted by the size of the change
+	BaseCurrency string
+	MissingRates []string
+}
+
+func (m MonthChange) NetChange() float64 {
+	return m.CurrentNet - m.PreviousNet
+}
+
+type GoalProgress struct {
+	Goal                database.SavingsGoal
+	Saved               float64
+	Remaining           float64
+	Percent             float64 // 0-100
+	Complete            bool
+	MonthsLeft          int        // Months until the target date including the current one, 0 once it has passed
+	RequiredMonthly     *float64   // nil once the target date has passed
+	ProjectedCompletion *time.Time // First of the month the goal finishes at the current pace, nil without any savings
+	OnTrack             bool
+	BaseCurrency        string
+	MissingRates        []string
+}
+
+type ForecastMonth struct {
+	Month         YearMonth
+	Income        float64
+	Expenses      float64 // Negative
+	EndingBalance float64
+}
+
+func (f ForecastMonth) Net() float64 {
+	return f.Income + f.Expenses
+}
+
+func (f ForecastMonth) Negative() bool {
+	return f.EndingBalance < 0
+}
+
+// How a bucket is projected each month
+type BucketForecast struct {
+	Reference      database.Bucket
+	Average        float64 // Trimmed monthly average over the history window
+	ExcludedMonths int     // Months left out of the average as outliers
+	FromRecurring  bool    // Projected from recurring items instead of the average
+	Recurring      []database.RecurringItem
+}
+
+type Forecast struct {
+	History         Period // Months the averages come from
+	StartingBalance float64
+	Months          []ForecastMonth
+	Buckets         []BucketForecast
+	BaseCurrency    string
+	MissingRates    []string
+}
+
+// First projected month that ends below 0, nil when the balance stays positive
+func (f Forecast) FirstNegative() *ForecastMonth {
+	for _, m := range f.Months {
+		if m.Negative() {
+			return &m
+		}
+	}
+	return nil
+}
+
+type LinkedPayment struct {
+	Id          int // Id of the link, not the transaction
+	Transaction ConvertedTransaction
+}
+
+type DebtStatus struct {
+	Debt      database.Debt
+	Payments  []LinkedPayment
+	Paid      float64 // Linked payments in the base currency
+	Remaining float64 // Balance less what was paid, never below 0
+}
+
+type ScheduledPayment struct {
+	Payment  float64
+	Interest float64
+	Balance  float64 // After the payment
+}
+
+type PayoffMonth struct {
+	Month    YearMonth
+	Payments []ScheduledPayment // Same order as PayoffPlan.Debts
+}
+
+type DebtPayoff struct {
+	Debt            database.Debt
+	StartingBalance float64
+	Interest        float64
+	PayoffMonth     *YearMonth // nil when it was already paid or isn't paid off within the plan
+}
+
+type PayoffPlan struct {
+	Strategy      string
+	Debts         []DebtPayoff // In the order extra payments go to them
+	Months        []PayoffMonth
+	TotalInterest float64
+	TotalPaid     float64
+	PaidOff       bool // false when the payments don't clear the debts within MAX_PAYOFF_MONTHS
+}
+
+// Month the last debt is paid off, nil when the plan never finishes or there was nothing to pay
+func (p PayoffPlan) DebtFreeMonth() *YearMonth {
+	if !p.PaidOff || len(p.Months) == 0 {
+		return nil
+	}
+	return &p.Months[len(p.Months)-1].Month
+}
+
+type DebtPlan struct {
+	Debts        []DebtStatus
+	Strategy     string
+	Extra        float64
+	Plans        []PayoffPlan // One for each of DebtStrategies, empty when the input wasn't valid
+	Candidates   []ConvertedTransaction
+	BaseCurrency string
+	MissingRates []string
+}
+
+// The plan for the selected strategy, nil when there are no plans
+func (d DebtPlan) Selected() *PayoffPlan {
+	for i := range d.Plans {
+		if d.Plans[i].Strategy == d.Strategy {
+			return &d.Plans[i]
+		}
+	}
+	return nil
+}
+
+type NetWorthMonth struct {
+	Month        YearMonth
+	Assets       float64
+	Liabilities  float64 // Positive amount owed
+	Income       float64 // From MonthlySummary
+	Expenses     float64 // Negative, from MonthlySummary
+	HasSnapshots bool    // false before any account has a snapshot
+}
+
+func (n NetWorthMonth) NetWorth() float64 {
+	return n.Assets - n.Liabilities
+}
+
+type AccountBalance struct {
+	Account   database.NetWorthAccount
+	Snapshots []database.Snapshot // Oldest first
+}
+
+// Latest snapshot of the account, nil when it has none
+func (a AccountBalance) Latest() *database.Snapshot {
+	if len(a.Snapshots) == 0 {
+		return nil
+	}
+	return &a.Snapshots[len(a.Snapshots)-1]
+}
+
+type NetWorth struct {
+	Period       Period
+	Months       []NetWorthMonth
+	Accounts     []AccountBalance
+	BaseCurrency string
+	MissingRates []string
+}
+
+// Units bought together, UnitCost includes the buy's share of fees
+type Lot struct {
+	Acquired string // YYYY-MM-DD
+	Quantity float64
+	UnitCost float64
+}
+
+// A symbol held in one investment account, amounts are in the account's currency
+type Holding struct {
+	Symbol       string
+	AssetClass   string
+	Lots         []Lot // Open lots, oldest first
+	RealizedGain float64
+	Dividends    float64  // After fees
+	Price        *float64 // Latest price, nil without one
+	PriceDate    string
+}
+
+func (h Holding) Quantity() float64 {
+	total := 0.0
+	for _, l := range h.Lots {
+		total += l.Quantity
+	}
+	return total
+}
+
+func (h Holding) CostBasis() float64 {
+	total := 0.0
+	for _, l := range h.Lots {
+		total += l.Quantity * l.UnitCost
+	}
+	return total
+}
+
+// 0 without a price
+func (h Holding) MarketValue() float64 {
+	if h.Price == nil {
+		return 0
+	}
+	return h.Quantity() * *h.Price
+}
+
+// 0 without a price
+func (h Holding) UnrealizedGain() float64 {
+	if h.Price == nil {
+		return 0
+	}
+	return h.MarketValue() - h.CostBasis()
+}
+
+type InvestmentAccountSummary struct {
+	Account  database.InvestmentAccount
+	Holdings []Holding                  // By symbol, including ones that were sold off
+	Trades   []database.InvestmentTrade // Newest first
+}
+
+func (s InvestmentAccountSummary) totals() (value, cost, unrealized, realized, dividends float64) {
+	for _, h := range s.Holdings {
+		value += h.MarketValue()
+		cost += h.CostBasis()
+		unrealized += h.UnrealizedGain()
+		realized += h.RealizedGain
+		dividends += h.Dividends
+	}
+	return value, cost, unrealized, realized, dividends
+}
+
+func (s InvestmentAccountSummary) MarketValue() float64 {
+	value, _, _, _, _ := s.totals()
+	return value
+}
+
+func (s InvestmentAccountSummary) CostBasis() float64 {
+	_, cost, _, _, _ := s.totals()
+	return cost
+}
+
+func (s InvestmentAccountSummary) UnrealizedGain() float64 {
+	_, _, unrealized, _, _ := s.totals()
+	return unrealized
+}
+
+func (s InvestmentAccountSummary) RealizedGain() float64 {
+	_, _, _, realized, _ := s.totals()
+	return realized
+}
+
+func (s InvestmentAccountSummary) Dividends() float64 {
+	_, _, _, _, dividends := s.totals()
+	return dividends
+}
+
+// Symbols still held that don't have a price, they are left out of the market value
+func (s InvestmentAccountSummary) MissingPrices() []string {
+	missing := []string{}
+	for _, h := range s.Holdings {
+		if h.Price == nil && h.Quantity() > QUANTITY_EPSILON {
+			missing = append(missing, h.Symbol)
+		}
+	}
+	return missing
+}
+
+type AssetAllocation struct {
+	AssetClass string
+	Value      float64 // Base currency
+	Percent    float64 // 0-100
+}
+
+type Portfolio struct {
+	Accounts     []InvestmentAccountSummary
+	Allocation   []AssetAllocation        // Largest first
+	TotalValue   float64                  // Base currency
+	Prices       []database.SecurityPrice // Latest of each symbol
+	BaseCurrency string
+	MissingRates []string
+}
+
+type BillStatus struct {
+	Bill       database.Bill
+	BucketName string
+	NextDue    time.Time
+	LastPaid   string // Latest due date marked paid, empty if never
+}
+
+// An unpaid due date of a bill
+type BillReminder struct {
+	Bill       database.Bill
+	BucketName string
+	DueDate    time.Time
+	Overdue    bool
+}
+
+type BillOverview struct {
+	Bills     []BillStatus
+	Reminders []BillReminder // Overdue first
+}
+
+type NotificationKey struct {
+	Kind      string
+	DedupeKey string
+}
+
+type Alert struct {
+	NotificationKey
+	Title  string
+	Detail string
+}
+
+type Digest struct {
+	NotificationKey
+	Month      YearMonth
+	Summary    MonthSummary // Month to date
+	TopBuckets []BucketSummary
+	Budgets    []BudgetProgress
+	Bills      []BillReminder // Unpaid, overdue first
+}
+
+type PendingNotifications struct {
+	Preference database.NotificationPreference
+	Digest     *Digest // nil when not due
+	Alerts     []Alert
+}
+
+// Repeats monthly on DayOfMonth starting at Start
+type CalendarEvent struct {
+	Uid         string // Stable between fetches
+	Summary     string
+	Description string
+	Start       time.Time
+	DayOfMonth  int
+}
@@ -0,0 +1,222 @@
+package finance
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+const (
+	MAX_SNAPSHOT_IMPORT_ROWS = 5000
+)
+
+func monthEndDate(m YearMonth) string {
+	return time.Date(m.Year, time.Month(m.Month)+1, 0, 0, 0, 0, 0, time.UTC).Format(database.RATE_DATE_FORMAT)
+}
+
+// Values every account at the end of each month from its latest snapshot on or before that day.
+// Accounts without a snapshot yet are left out, snapshots are converted with the month's exchange rate.
+func netWorthMonths(accounts []database.NetWorthAccount, snapshots []database.Snapshot, months []YearMonth, rates *rateTable) ([]NetWorthMonth, []string) {
+	byAccount := map[int][]database.Snapshot{}
+	for _, s := range snapshots {
+		byAccount[s.AccountId] = append(byAccount[s.AccountId], s)
+	}
+	missingRates := map[string]bool{}
+	result := []NetWorthMonth{}
+	for _, m := range months {
+		end := monthEndDate(m)
+		row := NetWorthMonth{Month: m}
+		for _, a := range accounts {
+			var latest *database.Snapshot
+			// Snapshots are sorted by date so the last one before the end of the month wins
+			for i, s := range byAccount[a.Id] {
+				if s.SnapshotDate > end {
+					break
+				}
+				latest = &byAccount[a.Id][i]
+			}
+			if latest == nil {
+				continue
+			}
+			balance, ok := rates.convert(latest.Balance, a.Currency, m.Month, m.Year)
+			if !ok {
+				missingRates[a.Currency] = true
+				continue
+			}
+			row.HasSnapshots = true
+			if a.Kind == database.ACCOUNT_KIND_LIABILITY {
+				row.Liabilities += balance
+			} else {
+				row.Assets += balance
+			}
+		}
+		result = append(result, row)
+	}
+	return result, sortedKeys(missingRates)
+}
+
+// Net worth at the end of each month of the period next to that month's income and expenses
+func (f *FinanceLogic) NetWorthHistory(userId int, period Period) (*NetWorth, map[string]string, error) {
+	problems := period.Valid()
+	if len(problems) > 0 {
+		return nil, problems, nil
+	}
+	accounts, err := f.DB.NetWorthAccounts(userId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("NetWorthHistory: db: %w", err)
+	}
+	snapshots, err := f.DB.Snapshots(userId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("NetWorthHistory: db: %w", err)
+	}
+	rates, err := f.userRateTable(userId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("NetWorthHistory: %w", err)
+	}
+
+	months, missing := netWorthMonths(accounts, snapshots, period.Months(), rates)
+	missingRates := map[string]bool{}
+	for _, c := range missing {
+		missingRates[c] = true
+	}
+	for i, m := range months {
+		summary, err := f.MonthlySummary(userId, m.Month.Month, m.Month.Year)
+		if err != nil {
+			return nil, nil, fmt.Errorf("NetWorthHistory: %w", err)
+		}
+		months[i].Income = summary.TotalIncome
+		months[i].Expenses = summary.TotalExpense
+		for _, c := range summary.MissingRates {
+			missingRates[c] = true
+		}
+	}
+
+	netWorth := &NetWorth{
+		Period:       period,
+		Months:       months,
+		BaseCurrency: rates.base,
+		MissingRates: sortedKeys(missingRates),
+	}
+	for _, a := range accounts {
+		balance := AccountBalance{Account: a}
+		for _, s := range snapshots {
+			if s.AccountId == a.Id {
+				balance.Snapshots = append(balance.Snapshots, s)
+			}
+		}
+		netWorth.Accounts = append(netWorth.Accounts, balance)
+	}
+	return netWorth, nil, nil
+}
+
+func (f *FinanceLogic) AddNetWorthAccount(userId int, input database.NetWorthAccountInput) (map[string]string, error) {
+	input.UserId = userId
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	accounts, err := f.DB.NetWorthAccounts(userId)
+	if err != nil {
+		return nil, fmt.Errorf("AddNetWorthAccount: db: %w", err)
+	}
+	for _, a := range accounts {
+		if strings.EqualFold(a.Name, input.Name) {
+			return map[string]string{"Name": "An account with this name already exists"}, nil
+		}
+	}
+	_, err = f.DB.CreateNetWorthAccount(input)
+	if err != nil {
+		return nil, fmt.Errorf("AddNetWorthAccount: db: %w", err)
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) DeleteNetWorthAccount(userId, accountId int) error {
+	rowsChanged, err := f.DB.NetWorthAccountDelete(accountId, userId)
+	if err != nil {
+		return fmt.Errorf("DeleteNetWorthAccount: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return fmt.Errorf("DeleteNetWorthAccount: %w", cuserr.NotFound{Item: "account"})
+	}
+	return nil
+}
+
+func (f *FinanceLogic) AddSnapshot(userId int, input database.SnapshotInput) (map[string]string, error) {
+	input.UserId = userId
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	accounts, err := f.DB.NetWorthAccounts(userId)
+	if err != nil {
+		return nil, fmt.Errorf("AddSnapshot: db: %w", err)
+	}
+	found := false
+	for _, a := range accounts {
+		found = found || a.Id == input.AccountId
+	}
+	if !found {
+		return map[string]string{"AccountId": "Account not found"}, nil
+	}
+	err = f.DB.UpsertSnapshot(input)
+	if err != nil {
+		return nil, fmt.Errorf("AddSnapshot: db: %w", err)
+	}
+	return nil, nil
+}
+
+func (f *FinanceLogic) DeleteSnapshot(userId, snapshotId int) error {
+	rowsChanged, err := f.DB.SnapshotDelete(snapshotId, userId)
+	if err != nil {
+		return fmt.Errorf("DeleteSnapshot: db: %w", err)
+	}
+	if rowsChanged == 0 {
+		return fmt.Errorf("DeleteSnapshot: %w", cuserr.NotFound{Item: "snapshot"})
+	}
+	return nil
+}
+
+// Reads a CSV with a header naming the date, account and balance columns, accounts are matched by name
+// and have to exist already. Nothing is saved unless every row is valid.
+func (f *FinanceLogic) ImportSnapshots(userId int, file io.Reader) (int, map[string]string, error) {
+	accounts, err := f.DB.NetWorthAccounts(userId)
+	if err != nil {
+		return 0, nil, fmt.Errorf("ImportSnapshots: db: %w", err)
+	}
+	accountIds := map[string]int{}
+	for _, a := range accounts {
+		accountIds[strings.ToLower(a.Name)] = a.Id
+	}
+
+	inputs := []database.SnapshotInput{}
+	problems := readCsvImport(file, []string{"date", "account", "balance"}, MAX_SNAPSHOT_IMPORT_ROWS, func(row csvRow) {
+		accountName := row.value("account")
+		accountId, ok := accountIds[strings.ToLower(accountName)]
+		if !ok {
+			row.problems["AccountId"] = "No account named " + accountName
+		}
+		input := database.SnapshotInput{
+			UserId:       userId,
+			AccountId:    accountId,
+			Balance:      row.decimal("balance", "Balance"),
+			SnapshotDate: row.value("date"),
+		}
+		row.addProblems(input.Valid())
+		if row.ok() {
+			inputs = append(inputs, input)
+		}
+	})
+	if len(problems) > 0 {
+		return 0, problems, nil
+	}
+
+	err = f.DB.UpsertSnapshots(inputs)
+	if err != nil {
+		return 0, nil, fmt.Errorf("ImportSnapshots: db: %w", err)
+	}
+	return len(inputs), nil, nil
+}
@@ -0,0 +1,107 @@
+package finance
+
+import (
+	"strings"
+	"testing"
+	"wonk/storage"
+)
+
+func TestNetWorthMonths(t *testing.T) {
+	accounts := []database.NetWorthAccount{
+		{Id: 1, Name: "checking", Kind: database.ACCOUNT_KIND_ASSET, Currency: "USD"},
+		{Id: 2, Name: "house", Kind: database.ACCOUNT_KIND_ASSET, Currency: "EUR"},
+		{Id: 3, Name: "mortgage", Kind: database.ACCOUNT_KIND_LIABILITY, Currency: "USD"},
+		{Id: 4, Name: "yen savings", Kind: database.ACCOUNT_KIND_ASSET, Currency: "JPY"},
+	}
+	snapshots := []database.Snapshot{
+		{AccountId: 1, Balance: 1000, SnapshotDate: "2025-01-15"},
+		{AccountId: 3, Balance: 500, SnapshotDate: "2025-01-31"},
+		{AccountId: 2, Balance: 100, SnapshotDate: "2025-02-01"},
+		{AccountId: 4, Balance: 10000, SnapshotDate: "2025-02-01"},
+		{AccountId: 1, Balance: 1500, SnapshotDate: "2025-03-01"},
+		{AccountId: 1, Balance: 900, SnapshotDate: "2025-03-31"},
+	}
+	rates := newRateTable("USD", []database.ExchangeRate{
+		{Currency: "EUR", BaseCurrency: "USD", Rate: 2, RateDate: "2025-01-01"},
+		{Currency: "EUR", BaseCurrency: "USD", Rate: 3, RateDate: "2025-03-01"},
+	})
+	months := Period{Start: YearMonth{Month: 12, Year: 2024}, End: YearMonth{Month: 4, Year: 2025}}.Months()
+
+	got, missing := netWorthMonths(accounts, snapshots, months, &rates)
+	want := []NetWorthMonth{
+		{Month: months[0]},
+		{Month: months[1], Assets: 1000, Liabilities: 500, HasSnapshots: true},
+		// The house is 100 EUR at 2 USD
+		{Month: months[2], Assets: 1200, Liabilities: 500, HasSnapshots: true},
+		// The last snapshot in March wins and the house uses March's rate
+		{Month: months[3], Assets: 1200, Liabilities: 500, HasSnapshots: true},
+		// Nothing new in April so March carries forward
+		{Month: months[4], Assets: 1200, Liabilities: 500, HasSnapshots: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d months, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s: expected %+v, got %+v", want[i].Month, want[i], got[i])
+		}
+	}
+	if got[1].NetWorth() != 500 {
+		t.Errorf("expected net worth of 500 in Jan, got %v", got[1].NetWorth())
+	}
+	if len(missing) != 1 || missing[0] != "JPY" {
+		t.Errorf("expected JPY to be missing a rate, got %v", missing)
+	}
+}
+
+func TestImportSnapshots(t *testing.T) {
+	f, userId := newTestFinance(t, "snap")
+	db := f.DB
+	problems, err := f.AddNetWorthAccount(userId, database.NetWorthAccountInput{Name: "Checking", Kind: database.ACCOUNT_KIND_ASSET, Category: "Cash", Currency: "USD"})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+
+	tests := []struct {
+		name         string
+		csv          string
+		wantImported int
+		wantProblem  string
+	}{
+		{name: "missing column", csv: "date,balance\n2025-01-01,10\n", wantProblem: "Missing column: account"},
+		{name: "unknown account", csv: "date,account,balance\n2025-01-01,savings,10\n", wantProblem: "No account named savings"},
+		{name: "bad date", csv: "date,account,balance\n01/01/2025,checking,10\n", wantProblem: "SnapshotDate"},
+		{name: "every problem of a line", csv: "date,account,balance\n01/01/2025,savings,lots\n", wantProblem: "AccountId: No account named savings; Balance: Balance is not a decimal; SnapshotDate: Date must be YYYY-MM-DD"},
+		{name: "valid", csv: "Date, Account, Balance\n2025-01-31,checking,100.50\n2025-02-28,CHECKING,200\n", wantImported: 2},
+		{name: "same date replaces", csv: "date,account,balance\n2025-02-28,Checking,250\n", wantImported: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imported, problems, err := f.ImportSnapshots(userId, strings.NewReader(tt.csv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if imported != tt.wantImported {
+				t.Errorf("expected %d imported, got %d", tt.wantImported, imported)
+			}
+			if tt.wantProblem == "" && len(problems) > 0 {
+				t.Errorf("expected no problems, got %v", problems)
+			}
+			found := tt.wantProblem == ""
+			for _, msg := range problems {
+				found = found || strings.Contains(msg, tt.wantProblem)
+			}
+			if !found {
+				t.Errorf("expected a problem containing %q, got %v", tt.wantProblem, problems)
+			}
+		})
+	}
+
+	snapshots, err := db.Snapshots(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[1].Balance != 250 {
+		t.Errorf("expected 2 snapshots ending at 250, got %+v", snapshots)
+	}
+}
@@ -0,0 +1,246 @@
+package finance
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+const (
+	// Bills due within this many days are alerted about
+	BILL_ALERT_DAYS = 3
+	// Only notifications sent this recently are checked for repeats, dedupe keys only need to be unique within it
+	NOTIFICATION_MEMORY_DAYS = 120
+	// Transactions added longer ago than this aren't alerted about, so a restart doesn't send a backlog
+	LARGE_TRANSACTION_LOOKBACK = 7 * 24 * time.Hour
+	DIGEST_TOP_BUCKETS         = 5
+)
+
+// Saved preferences, or the defaults with everything off when the user hasn't saved any
+func (f *FinanceLogic) NotificationPreferences(userId int) (*database.NotificationPreference, error) {
+	pref, err := f.DB.NotificationPreference(userId)
+	if err != nil {
+		var notFoundErr cuserr.NotFound
+		if errors.As(err, &notFoundErr) {
+			return &database.NotificationPreference{UserId: userId, DigestWeekday: int(time.Monday)}, nil
+		}
+		return nil, fmt.Errorf("NotificationPreferences: db: %w", err)
+	}
+	return pref, nil
+}
+
+// Large transaction alerts only look at transactions added after this save
+func (f *FinanceLogic) SetNotificationPreferences(userId int, input database.NotificationPreferenceInput) (map[string]string, error) {
+	input.UserId = userId
+	input.UpdatedAt = time.Now().Unix()
+	problems := input.Valid()
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	err := f.DB.UpsertNotificationPreference(input)
+	if err != nil {
+		return nil, fmt.Errorf("SetNotificationPreferences: db: %w", err)
+	}
+	return nil, nil
+}
+
+// Every user that has an email address and at least one notification turned on
+func (f *FinanceLogic) NotificationRecipients() ([]database.NotificationPreference, error) {
+	prefs, err := f.DB.NotificationPreferences()
+	if err != nil {
+		return nil, fmt.Errorf("NotificationRecipients: db: %w", err)
+	}
+	return prefs, nil
+}
+
+// ISO week the time falls in, ex: 2025-W09
+func isoWeekKey(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// The digest and alerts that are due for the user and haven't been sent yet.
+// The digest is due on the user's digest weekday, once per week.
+func (f *FinanceLogic) PendingNotifications(userId int, now time.Time) (*PendingNotifications, error) {
+	pref, err := f.NotificationPreferences(userId)
+	if err != nil {
+		return nil, fmt.Errorf("PendingNotifications: %w", err)
+	}
+	pending := &PendingNotifications{Preference: *pref}
+	if pref.Email == "" {
+		return pending, nil
+	}
+	sentList, err := f.DB.NotificationsSent(userId, now.AddDate(0, 0, -NOTIFICATION_MEMORY_DAYS).Unix())
+	if err != nil {
+		return nil, fmt.Errorf("PendingNotifications: db: %w", err)
+	}
+	sent := map[NotificationKey]bool{}
+	for _, s := range sentList {
+		sent[NotificationKey{Kind: s.Kind, DedupeKey: s.DedupeKey}] = true
+	}
+	addAlert := func(a Alert) {
+		if !sent[a.NotificationKey] {
+			pending.Alerts = append(pending.Alerts, a)
+		}
+	}
+
+	digestKey := NotificationKey{Kind: database.NOTIFICATION_KIND_DIGEST, DedupeKey: isoWeekKey(now)}
+	if pref.WeeklyDigest && int(now.Weekday()) == pref.DigestWeekday && !sent[digestKey] {
+		digest, err := f.weeklyDigest(userId, now)
+		if err != nil {
+			return nil, fmt.Errorf("PendingNotifications: %w", err)
+		}
+		digest.NotificationKey = digestKey
+		pending.Digest = digest
+	}
+
+	if pref.BudgetAlerts {
+		progress, err := f.BudgetBurnDown(userId, now)
+		if err != nil {
+			return nil, fmt.Errorf("PendingNotifications: %w", err)
+		}
+		for _, p := range progress {
+			if p.Remaining >= 0 {
+				continue
+			}
+			addAlert(Alert{
+				NotificationKey: NotificationKey{
+					Kind:      database.NOTIFICATION_KIND_BUDGET,
+					DedupeKey: strconv.Itoa(p.Budget.BucketId) + "|" + now.Format("2006-01"),
+				},
+				Title:  "Over budget: " + p.Bucket.Name,
+				Detail: fmt.Sprintf("Spent %.2f of the %.2f %s budget this month", p.Spent, p.Budget.Amount, p.BaseCurrency),
+			})
+		}
+	}
+
+	if pref.BillAlerts {
+		reminders, err := f.BillReminders(userId, now)
+		if err != nil {
+			return nil, fmt.Errorf("PendingNotifications: %w", err)
+		}
+		alertCutoff := startOfDay(now).AddDate(0, 0, BILL_ALERT_DAYS)
+		for _, r := range reminders {
+			if r.DueDate.After(alertCutoff) {
+				continue
+			}
+			title := "Bill due: " + r.Bill.Name
+			if r.Overdue {
+				title = "Bill overdue: " + r.Bill.Name
+			}
+			addAlert(Alert{
+				NotificationKey: NotificationKey{
+					Kind:      database.NOTIFICATION_KIND_BILL,
+					DedupeKey: strconv.Itoa(r.Bill.Id) + "|" + r.DueDate.Format(database.RATE_DATE_FORMAT),
+				},
+				Title:  title,
+				Detail: fmt.Sprintf("%.2f %s due %s", r.Bill.Amount, r.Bill.Currency, r.DueDate.Format("Mon Jan 2")),
+			})
+		}
+	}
+
+	if pref.LargeTransaction != nil {
+		alerts, err := f.largeTransactionAlerts(userId, *pref, now)
+		if err != nil {
+			return nil, fmt.Errorf("PendingNotifications: %w", err)
+		}
+		for _, a := range alerts {
+			addAlert(a)
+		}
+	}
+	return pending, nil
+}
+
+// Transactions added since the preferences were saved, within LARGE_TRANSACTION_LOOKBACK,
+// that are at least the threshold in the base currency. Deleted ones are skipped.
+func (f *FinanceLogic) largeTransactionAlerts(userId int, pref database.NotificationPreference, now time.Time) ([]Alert, error) {
+	since := max(pref.UpdatedAt, now.Add(-LARGE_TRANSACTION_LOOKBACK).Unix())
+	creates, err := f.DB.AuditEntriesSince(userId, database.AUDIT_ENTITY_TRANSACTION, database.AUDIT_ACTION_CREATE, since)
+	if err != nil {
+		return nil, fmt.Errorf("largeTransactionAlerts: db: %w", err)
+	}
+	if len(creates) == 0 {
+		return nil, nil
+	}
+	rates, err := f.userRateTable(userId)
+	if err != nil {
+		return nil, fmt.Errorf("largeTransactionAlerts: %w", err)
+	}
+	alerts := []Alert{}
+	for _, c := range creates {
+		t, err := f.DB.TransactionById(c.EntityId)
+		if err != nil {
+			var notFoundErr cuserr.NotFound
+			if errors.As(err, &notFoundErr) {
+				continue
+			}
+			return nil, fmt.Errorf("largeTransactionAlerts: db: %w", err)
+		}
+		converted := convertTransaction(rates, *t)
+		if converted.ConvertedPrice == nil || math.Abs(*converted.ConvertedPrice) < *pref.LargeTransaction {
+			continue
+		}
+		kind := "Income"
+		if t.IsExpense {
+			kind = "Expense"
+		}
+		alerts = append(alerts, Alert{
+			NotificationKey: NotificationKey{
+				Kind:      database.NOTIFICATION_KIND_LARGE_TRANSACTION,
+				DedupeKey: strconv.Itoa(t.Id),
+			},
+			Title:  "Large transaction: " + t.Name,
+			Detail: fmt.Sprintf("%s of %.2f %s for %d/%d", kind, t.Price, t.Currency, t.Month, t.Year),
+		})
+	}
+	return alerts, nil
+}
+
+// Month to date spending, budgets and the bills coming up
+func (f *FinanceLogic) weeklyDigest(userId int, now time.Time) (*Digest, error) {
+	summary, err := f.MonthlySummary(userId, int(now.Month()), now.Year())
+	if err != nil {
+		return nil, fmt.Errorf("weeklyDigest: %w", err)
+	}
+	top, err := f.TopBuckets(userId, now, DIGEST_TOP_BUCKETS)
+	if err != nil {
+		return nil, fmt.Errorf("weeklyDigest: %w", err)
+	}
+	budgets, err := f.BudgetBurnDown(userId, now)
+	if err != nil {
+		return nil, fmt.Errorf("weeklyDigest: %w", err)
+	}
+	bills, err := f.BillReminders(userId, now)
+	if err != nil {
+		return nil, fmt.Errorf("weeklyDigest: %w", err)
+	}
+	return &Digest{
+		Month:      YearMonth{Month: int(now.Month()), Year: now.Year()},
+		Summary:    *summary,
+		TopBuckets: top,
+		Budgets:    budgets,
+		Bills:      bills,
+	}, nil
+}
+
+// Called once the notifications went out so they aren't sent again
+func (f *FinanceLogic) MarkNotificationsSent(userId int, keys []NotificationKey, now time.Time) error {
+	inputs := []database.NotificationSentInput{}
+	for _, k := range keys {
+		inputs = append(inputs, database.NotificationSentInput{
+			UserId:    userId,
+			Kind:      k.Kind,
+			DedupeKey: k.DedupeKey,
+			SentAt:    now.Unix(),
+		})
+	}
+	err := f.DB.CreateNotificationsSent(inputs)
+	if err != nil {
+		return fmt.Errorf("MarkNotificationsSent: db: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package finance
+
+import (
+	"context"
+	"testing"
+	"time"
+	"wonk/storage"
+)
+
+func TestPendingNotifications(t *testing.T) {
+	f, userId := newTestFinance(t, "notify")
+	db := f.DB
+	ctx := context.Background()
+	now := time.Now()
+	bucketId, err := db.CreateBucket(userId, "Travel", database.AuditInfo{UserId: userId})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := f.PendingNotifications(userId, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Digest != nil || len(pending.Alerts) > 0 {
+		t.Fatalf("expected nothing pending without preferences, got %+v", pending)
+	}
+
+	threshold := 500.0
+	problems, err := f.SetNotificationPreferences(userId, database.NotificationPreferenceInput{
+		Email:            "notify@example.com",
+		WeeklyDigest:     true,
+		DigestWeekday:    int(now.Weekday()),
+		BudgetAlerts:     true,
+		BillAlerts:       true,
+		LargeTransaction: &threshold,
+	})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	problems, err = f.SetBudget(userId, database.BudgetInput{BucketId: bucketId, Amount: 100})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	// Due tomorrow so it's within BILL_ALERT_DAYS
+	problems, err = f.AddBill(userId, database.BillInput{BucketId: bucketId, Name: "Insurance", Amount: 40, Currency: "USD", DueDay: now.AddDate(0, 0, 1).Day()})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	for _, price := range []float64{600, 20} {
+		problems, err = f.SubmitNewTransaction(ctx, database.TransactionItemInput{
+			Name: "Flight", Month: int(now.Month()), Year: now.Year(), Price: price, IsExpense: true, UserId: userId, BucketId: bucketId, Currency: "USD",
+		})
+		if err != nil || len(problems) > 0 {
+			t.Fatal(problems, err)
+		}
+	}
+
+	pending, err = f.PendingNotifications(userId, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Digest == nil || pending.Digest.DedupeKey != isoWeekKey(now) {
+		t.Errorf("expected this week's digest, got %+v", pending.Digest)
+	}
+	kinds := map[string]int{}
+	for _, a := range pending.Alerts {
+		kinds[a.Kind]++
+	}
+	want := map[string]int{
+		database.NOTIFICATION_KIND_BUDGET:            1,
+		database.NOTIFICATION_KIND_BILL:              1,
+		database.NOTIFICATION_KIND_LARGE_TRANSACTION: 1, // Only the 600 one
+	}
+	for kind, count := range want {
+		if kinds[kind] != count {
+			t.Errorf("expected %d %s alerts, got %+v", count, kind, pending.Alerts)
+		}
+	}
+
+	keys := []NotificationKey{pending.Digest.NotificationKey}
+	for _, a := range pending.Alerts {
+		keys = append(keys, a.NotificationKey)
+	}
+	err = f.MarkNotificationsSent(userId, keys, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err = f.PendingNotifications(userId, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Digest != nil || len(pending.Alerts) > 0 {
+		t.Errorf("expected nothing pending after sending, got %+v", pending)
+	}
+}
+
+func TestIsoWeekKey(t *testing.T) {
+	tests := []struct {
+		date time.Time
+		want string
+	}{
+		{time.Date(2025, 3, 3, 12, 0, 0, 0, time.UTC), "2025-W10"},
+		// Belongs to the last week of the previous year
+		{time.Date(2027, 1, 1, 12, 0, 0, 0, time.UTC), "2026-W53"},
+	}
+	for _, tt := range tests {
+		if got := isoWeekKey(tt.date); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.date.Format(time.DateOnly), tt.want, got)
+		}
+	}
+}
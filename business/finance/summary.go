@@ -0,0 +1,145 @@
+package finance
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Longest range a summary can cover, keeps the pivot table readable
+	MAX_RANGE_MONTHS = 60
+)
+
+type YearMonth struct {
+	Month int
+	Year  int
+}
+
+func (y YearMonth) index() int {
+	return y.Year*12 + y.Month - 1
+}
+
+func yearMonthFromIndex(i int) YearMonth {
+	return YearMonth{Month: i%12 + 1, Year: i / 12}
+}
+
+func (y YearMonth) String() string {
+	return time.Month(y.Month).String()[:3] + " " + fmt.Sprint(y.Year)
+}
+
+// An inclusive range of months
+type Period struct {
+	Start YearMonth
+	End   YearMonth
+}
+
+// January of the current year through the current month
+func YearToDate(now time.Time) Period {
+	return Period{
+		Start: YearMonth{Month: 1, Year: now.Year()},
+		End:   YearMonth{Month: int(now.Month()), Year: now.Year()},
+	}
+}
+
+// The current month and the 11 months before it
+func TrailingTwelveMonths(now time.Time) Period {
+	end := YearMonth{Month: int(now.Month()), Year: now.Year()}
+	return Period{
+		Start: yearMonthFromIndex(end.index() - 11),
+		End:   end,
+	}
+}
+
+func (p Period) Months() []YearMonth {
+	months := []YearMonth{}
+	for i := p.Start.index(); i <= p.End.index(); i++ {
+		months = append(months, yearMonthFromIndex(i))
+	}
+	return months
+}
+
+func (p Period) Valid() map[string]string {
+	problems := make(map[string]string)
+	for name, ym := range map[string]YearMonth{"Start": p.Start, "End": p.End} {
+		if ym.Month > 12 || ym.Month < 1 {
+			problems[name] = "Month value isn't between 1-12"
+		}
+		if ym.Year < 2000 || ym.Year > 3000 {
+			problems[name] = "Invalid Year"
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	if p.End.index() < p.Start.index() {
+		problems["End"] = "End can't be before start"
+	} else if p.End.index()-p.Start.index()+1 > MAX_RANGE_MONTHS {
+		problems["End"] = fmt.Sprintf("Range can't be longer than %d months", MAX_RANGE_MONTHS)
+	}
+	return problems
+}
+
+// Builds a buckets x months pivot of the period from one grouped query.
+// Buckets without transactions in the period are left out.
+func (f *FinanceLogic) RangeSummary(userId int, period Period) (*RangeSummary, map[string]string, error) {
+	problems := period.Valid()
+	if len(problems) > 0 {
+		return nil, problems, nil
+	}
+	buckets, err := f.DB.UserBuckets(userId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("RangeSummary: %w", err)
+	}
+	rates, err := f.userRateTable(userId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("RangeSummary: %w", err)
+	}
+	totals, err := f.DB.BucketMonthTotals(userId, period.Start.Month, period.Start.Year, period.End.Month, period.End.Year)
+	if err != nil {
+		return nil, nil, fmt.Errorf("RangeSummary: db: %w", err)
+	}
+
+	months := period.Months()
+	startIdx := period.Start.index()
+	rowByBucket := map[int]*BucketRangeRow{}
+	missingRates := map[string]bool{}
+	for _, t := range totals {
+		price, ok := rates.convert(t.Total, t.Currency, t.Month, t.Year)
+		if !ok {
+			missingRates[t.Currency] = true
+			continue
+		}
+		row, ok := rowByBucket[t.BucketId]
+		if !ok {
+			row = &BucketRangeRow{Months: make([]float64, len(months))}
+			rowByBucket[t.BucketId] = row
+		}
+		col := YearMonth{Month: t.Month, Year: t.Year}.index() - startIdx
+		row.Months[col] += price
+		row.Total += price
+	}
+
+	summary := &RangeSummary{
+		Period:       period,
+		Months:       months,
+		Rows:         []BucketRangeRow{},
+		MonthTotals:  make([]float64, len(months)),
+		BaseCurrency: rates.base,
+		MissingRates: sortedKeys(missingRates),
+	}
+	// Keep the user's bucket order
+	for _, b := range buckets {
+		row, ok := rowByBucket[b.Id]
+		if !ok {
+			continue
+		}
+		row.Reference = b
+		summary.Rows = append(summary.Rows, *row)
+		for i, v := range row.Months {
+			summary.MonthTotals[i] += v
+		}
+		summary.Total += row.Total
+	}
+
+	return summary, nil, nil
+}
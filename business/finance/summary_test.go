@@ -0,0 +1,254 @@
+package finance
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+	"wonk/storage"
+)
+
+// Seeds a user with MAX_BUCKETS buckets and transactions spread over a year
+func seedSummaryDb(tb testing.TB) (*FinanceLogic, int) {
+	tb.Helper()
+	f, userId := newTestFinance(tb, "bench")
+	db := f.DB
+	ctx := context.Background()
+	for i := 0; i < MAX_BUCKETS; i++ {
+		_, err := f.CreateBucket(ctx, userId, fmt.Sprintf("bucket%d", i))
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	buckets, err := db.UserBuckets(userId)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for month := 1; month <= 12; month++ {
+		for i, b := range buckets {
+			for n := 0; n < 5; n++ {
+				_, err := db.CreateItemTransaction(database.TransactionItemInput{
+					Name:      "item",
+					Month:     month,
+					Year:      2025,
+					Price:     float64(i+n) + 0.25,
+					IsExpense: n%2 == 0,
+					UserId:    userId,
+					BucketId:  b.Id,
+					Currency:  database.DEFAULT_CURRENCY,
+				}, database.AuditInfo{UserId: userId})
+				if err != nil {
+					tb.Fatal(err)
+				}
+			}
+		}
+	}
+	return f, userId
+}
+
+// The previous MonthlySummary path: one query per bucket summed in Go
+func perBucketMonthlyTotals(f *FinanceLogic, userId, month, year int) (map[int]float64, error) {
+	buckets, err := f.DB.UserBuckets(userId)
+	if err != nil {
+		return nil, err
+	}
+	rates, err := f.userRateTable(userId)
+	if err != nil {
+		return nil, err
+	}
+	totals := map[int]float64{}
+	for _, b := range buckets {
+		transactions, err := f.DB.TransactionsInBucket(b.Id, month, year)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range transactions {
+			price, ok := rates.convert(t.Price, t.Currency, t.Month, t.Year)
+			if !ok {
+				continue
+			}
+			if t.IsExpense {
+				price = -price
+			}
+			totals[b.Id] += price
+		}
+	}
+	return totals, nil
+}
+
+func TestMonthlySummaryMatchesPerBucketTotals(t *testing.T) {
+	f, userId := seedSummaryDb(t)
+	want, err := perBucketMonthlyTotals(f, userId, 6, 2025)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.MonthlySummary(userId, 6, 2025)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.BucketsSummary) != MAX_BUCKETS {
+		t.Fatalf("expected %d buckets, got %d", MAX_BUCKETS, len(got.BucketsSummary))
+	}
+	for _, b := range got.BucketsSummary {
+		if math.Abs(b.Price-want[b.Reference.Id]) > 0.001 {
+			t.Errorf("bucket %d: expected %.2f, got %.2f", b.Reference.Id, want[b.Reference.Id], b.Price)
+		}
+	}
+}
+
+func TestPeriodValid(t *testing.T) {
+	tests := []struct {
+		name     string
+		period   Period
+		problems map[string]string
+	}{
+		{name: "one month", period: Period{Start: YearMonth{6, 2025}, End: YearMonth{6, 2025}}, problems: map[string]string{}},
+		{name: "across a year", period: Period{Start: YearMonth{11, 2024}, End: YearMonth{2, 2025}}, problems: map[string]string{}},
+		{name: "longest range", period: Period{Start: YearMonth{1, 2020}, End: YearMonth{12, 2024}}, problems: map[string]string{}},
+		{
+			name:     "one month too long",
+			period:   Period{Start: YearMonth{1, 2020}, End: YearMonth{1, 2025}},
+			problems: map[string]string{"End": "Range can't be longer than 60 months"},
+		},
+		{
+			name:     "end before start",
+			period:   Period{Start: YearMonth{2, 2025}, End: YearMonth{12, 2024}},
+			problems: map[string]string{"End": "End can't be before start"},
+		},
+		{
+			name:     "bad months",
+			period:   Period{Start: YearMonth{0, 2025}, End: YearMonth{13, 2025}},
+			problems: map[string]string{"Start": "Month value isn't between 1-12", "End": "Month value isn't between 1-12"},
+		},
+		{
+			name:     "bad year",
+			period:   Period{Start: YearMonth{1, 1999}, End: YearMonth{1, 2025}},
+			problems: map[string]string{"Start": "Invalid Year"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems := test.period.Valid()
+			if !reflect.DeepEqual(problems, test.problems) {
+				t.Errorf("expected %v, got %v", test.problems, problems)
+			}
+		})
+	}
+}
+
+func TestTrailingTwelveMonths(t *testing.T) {
+	tests := []struct {
+		now   time.Time
+		start YearMonth
+		end   YearMonth
+	}{
+		{now: time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC), start: YearMonth{1, 2025}, end: YearMonth{12, 2025}},
+		{now: time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC), start: YearMonth{4, 2024}, end: YearMonth{3, 2025}},
+		{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), start: YearMonth{2, 2024}, end: YearMonth{1, 2025}},
+	}
+	for _, test := range tests {
+		t.Run(test.now.Format("2006-01"), func(t *testing.T) {
+			period := TrailingTwelveMonths(test.now)
+			if period.Start != test.start || period.End != test.end {
+				t.Fatalf("expected %v - %v, got %v - %v", test.start, test.end, period.Start, period.End)
+			}
+			months := period.Months()
+			if len(months) != 12 || months[0] != test.start || months[11] != test.end {
+				t.Errorf("expected 12 months from %v to %v, got %v", test.start, test.end, months)
+			}
+			if problems := period.Valid(); len(problems) > 0 {
+				t.Errorf("expected a valid period, got %v", problems)
+			}
+		})
+	}
+}
+
+func TestRangeSummary(t *testing.T) {
+	f, userId := newTestFinance(t, "range")
+	audit := database.AuditInfo{UserId: userId}
+	bucketIds := map[string]int{}
+	for _, name := range []string{"Pay", "Food", "Empty"} {
+		id, err := f.DB.CreateBucket(userId, name, audit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bucketIds[name] = id
+	}
+	problems, err := f.AddExchangeRate(userId, database.ExchangeRateInput{Currency: "EUR", Rate: 2, RateDate: "2024-01-01"})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	for _, input := range []database.TransactionItemInput{
+		{Name: "pay", Month: 11, Year: 2024, Price: 1000, BucketId: bucketIds["Pay"], Currency: "USD"},
+		{Name: "pay", Month: 1, Year: 2025, Price: 1000, BucketId: bucketIds["Pay"], Currency: "USD"},
+		{Name: "food", Month: 12, Year: 2024, Price: 100, IsExpense: true, BucketId: bucketIds["Food"], Currency: "USD"},
+		{Name: "food", Month: 1, Year: 2025, Price: 50, IsExpense: true, BucketId: bucketIds["Food"], Currency: "EUR"},
+		// No rate, left out and reported
+		{Name: "food", Month: 1, Year: 2025, Price: 20, IsExpense: true, BucketId: bucketIds["Food"], Currency: "GBP"},
+		// Just outside the range on both ends
+		{Name: "pay", Month: 10, Year: 2024, Price: 5, BucketId: bucketIds["Pay"], Currency: "USD"},
+		{Name: "pay", Month: 2, Year: 2025, Price: 5, BucketId: bucketIds["Pay"], Currency: "USD"},
+	} {
+		input.UserId = userId
+		_, err := f.DB.CreateItemTransaction(input, audit)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, problems, err = f.RangeSummary(userId, Period{Start: YearMonth{2, 2025}, End: YearMonth{1, 2025}})
+	if err != nil || len(problems) == 0 {
+		t.Errorf("invalid period: expected problems, got %v %v", problems, err)
+	}
+
+	summary, problems, err := f.RangeSummary(userId, Period{Start: YearMonth{11, 2024}, End: YearMonth{1, 2025}})
+	if err != nil || len(problems) > 0 {
+		t.Fatal(problems, err)
+	}
+	wantMonths := []YearMonth{{11, 2024}, {12, 2024}, {1, 2025}}
+	if !reflect.DeepEqual(summary.Months, wantMonths) {
+		t.Fatalf("expected months %v, got %v", wantMonths, summary.Months)
+	}
+	// Empty has no transactions in the range so it has no row
+	if len(summary.Rows) != 2 || summary.Rows[0].Reference.Name != "Pay" || summary.Rows[1].Reference.Name != "Food" {
+		t.Fatalf("expected rows for Pay and Food, got %+v", summary.Rows)
+	}
+	pay, food := summary.Rows[0], summary.Rows[1]
+	if !reflect.DeepEqual(pay.Months, []float64{1000, 0, 1000}) || pay.Total != 2000 {
+		t.Errorf("pay: expected [1000 0 1000] = 2000, got %v = %v", pay.Months, pay.Total)
+	}
+	if !reflect.DeepEqual(food.Months, []float64{0, -100, -100}) || food.Total != -200 {
+		t.Errorf("food: expected [0 -100 -100] = -200, got %v = %v", food.Months, food.Total)
+	}
+	if !reflect.DeepEqual(summary.MonthTotals, []float64{1000, -100, 900}) || summary.Total != 1800 {
+		t.Errorf("expected month totals [1000 -100 900] = 1800, got %v = %v", summary.MonthTotals, summary.Total)
+	}
+	if math.Abs(pay.Average()-2000.0/3) > 0.001 || summary.MonthlyAverage() != 600 || summary.BucketAverage(1) != -50 {
+		t.Errorf("averages: expected %.2f, 600 and -50, got %.2f, %v and %v", 2000.0/3, pay.Average(), summary.MonthlyAverage(), summary.BucketAverage(1))
+	}
+	if !reflect.DeepEqual(summary.MissingRates, []string{"GBP"}) {
+		t.Errorf("expected GBP to be missing a rate, got %v", summary.MissingRates)
+	}
+}
+
+func BenchmarkMonthlySummary(b *testing.B) {
+	f, userId := seedSummaryDb(b)
+	b.Run("PerBucketQueries", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := perBucketMonthlyTotals(f, userId, 6, 2025)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("GroupedQuery", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := f.MonthlySummary(userId, 6, 2025)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/app/requestid"
+	"wonk/storage"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type loginAttempt struct {
+	Ip     string `json:"ip"`
+	Reason string `json:"reason"`
+}
+
+// Compared against when the username doesn't exist so the response takes as long as a wrong password
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a real password"), PASSWORD_COST)
+	return hash
+})
+
+// Saves a failed or locked out login to the audit log of the account it was for.
+// Usernames that don't exist have no account to attach it to and are skipped.
+func (u *UserLogic) RecordLoginFailure(ctx context.Context, userName, ip, action, reason string, now time.Time) error {
+	curUser, err := u.DB.UserByUserName(userName)
+	if err != nil {
+		if errors.As(err, &cuserr.NotFound{}) {
+			return nil
+		}
+		return fmt.Errorf("RecordLoginFailure: db: %w", err)
+	}
+	b, err := json.Marshal(loginAttempt{Ip: ip, Reason: reason})
+	if err != nil {
+		return fmt.Errorf("RecordLoginFailure: json: %w", err)
+	}
+	attempt := string(b)
+	_, err = u.DB.CreateAuditEntry(database.AuditEntryInput{
+		UserId:     curUser.Id,
+		RequestId:  requestid.FromCtx(ctx),
+		EntityType: database.AUDIT_ENTITY_USER,
+		EntityId:   curUser.Id,
+		Action:     action,
+		AfterJson:  &attempt,
+		CreatedAt:  now.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("RecordLoginFailure: db: %w", err)
+	}
+	return nil
+}
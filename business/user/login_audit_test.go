@@ -0,0 +1,61 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/app/requestid"
+	"wonk/storage"
+)
+
+func TestRecordLoginFailure(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db}
+	userId, err := u.CreateUser("audited", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := requestid.WithRequestId(context.Background(), "req1")
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	_, err = u.Login("audited", "wrong")
+	if !errors.As(err, &cuserr.InvalidCred{}) {
+		t.Fatalf("expected InvalidCred, got %v", err)
+	}
+	err = u.RecordLoginFailure(ctx, "audited", "10.0.0.1", database.AUDIT_ACTION_LOGIN_FAILED, "wrong password", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.RecordLoginFailure(ctx, "audited", "10.0.0.1", database.AUDIT_ACTION_LOGIN_LOCKED, "too many failed logins", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.Login("nobody", "wrong")
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+	err = u.RecordLoginFailure(ctx, "nobody", "10.0.0.1", database.AUDIT_ACTION_LOGIN_FAILED, "wrong password", now)
+	if err != nil {
+		t.Fatalf("expected unknown usernames to be skipped, got %v", err)
+	}
+
+	entries, err := db.AuditEntries(userId, database.AUDIT_ENTITY_USER, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Action != database.AUDIT_ACTION_LOGIN_FAILED || entries[1].Action != database.AUDIT_ACTION_LOGIN_LOCKED {
+		t.Errorf("unexpected actions %s, %s", entries[0].Action, entries[1].Action)
+	}
+	if entries[0].RequestId != "req1" || entries[0].AfterJson == nil || !strings.Contains(*entries[0].AfterJson, `"ip":"10.0.0.1"`) {
+		t.Errorf("unexpected entry %+v", entries[0])
+	}
+}
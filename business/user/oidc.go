@@ -0,0 +1,165 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"wonk/app/cuserr"
+	"wonk/app/oidc"
+	"wonk/app/strutil"
+	"wonk/storage"
+)
+
+const (
+	// A number is added to the username of a provisioned account until one is free
+	OIDC_USERNAME_ATTEMPTS = 20
+	DEFAULT_OIDC_USERNAME  = "user"
+)
+
+// Logs in as the user the provider account is linked to. Accounts aren't matched by email,
+// an unlinked provider account gets a new user when autoProvision is on and NotFound otherwise.
+func (u *UserLogic) OidcLogin(issuer string, claims *oidc.Claims, autoProvision bool, now time.Time) (int, string, error) {
+	identity, err := u.DB.OidcIdentityBySubject(issuer, claims.Subject)
+	if err != nil {
+		if !errors.As(err, &cuserr.NotFound{}) {
+			return -1, "", fmt.Errorf("OidcLogin: db: %w", err)
+		}
+		if !autoProvision {
+			return -1, "", fmt.Errorf("OidcLogin: %w", err)
+		}
+		userId, userName, err := u.provisionOidcUser(issuer, claims, now)
+		if err != nil {
+			return -1, "", fmt.Errorf("OidcLogin: %w", err)
+		}
+		return userId, userName, nil
+	}
+	_, err = u.DB.OidcIdentityUse(identity.Id, claims.Email, now.Unix())
+	if err != nil {
+		return -1, "", fmt.Errorf("OidcLogin: db: %w", err)
+	}
+	curUser, err := u.DB.UserById(identity.UserId)
+	if err != nil {
+		return -1, "", fmt.Errorf("OidcLogin: db: %w", err)
+	}
+	return curUser.Id, curUser.UserName, nil
+}
+
+// The username comes from the provider and the password is random, nobody knows it until
+// it's reset from the emailed link
+func (u *UserLogic) provisionOidcUser(issuer string, claims *oidc.Claims, now time.Time) (int, string, error) {
+	userName, err := u.freeUserName(oidcUserName(claims))
+	if err != nil {
+		return -1, "", fmt.Errorf("provisionOidcUser: %w", err)
+	}
+	b := make([]byte, REFRESH_TOKEN_BYTES)
+	_, err = rand.Read(b)
+	if err != nil {
+		return -1, "", fmt.Errorf("provisionOidcUser: rand: %w", err)
+	}
+	hashedPassword, err := u.hashPassword(base64.RawURLEncoding.EncodeToString(b))
+	if err != nil {
+		return -1, "", fmt.Errorf("provisionOidcUser: %w", err)
+	}
+	lastUsedAt := now.Unix()
+	userId, err := u.DB.CreateOidcUser(userName, hashedPassword, database.OidcIdentityInput{
+		Issuer:     issuer,
+		Subject:    claims.Subject,
+		Email:      claims.Email,
+		CreatedAt:  now.Unix(),
+		LastUsedAt: &lastUsedAt,
+	})
+	if err != nil {
+		return -1, "", fmt.Errorf("provisionOidcUser: db: %w", err)
+	}
+	return userId, userName, nil
+}
+
+// preferred_username, else the start of the email
+func oidcUserName(claims *oidc.Claims) string {
+	name := strings.TrimSpace(claims.PreferredUsername)
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+		name = strings.TrimSpace(name)
+	}
+	if name == "" {
+		return DEFAULT_OIDC_USERNAME
+	}
+	return name
+}
+
+// Tries name, then name2, name3 and so on, each cut to fit the username length
+func (u *UserLogic) freeUserName(name string) (string, error) {
+	for i := 1; i <= OIDC_USERNAME_ATTEMPTS; i++ {
+		suffix := ""
+		if i > 1 {
+			suffix = strconv.Itoa(i)
+		}
+		candidate := truncate(name, strutil.MAX_STRING_LENGTH-len(suffix)) + suffix
+		_, err := u.DB.UserByUserName(candidate)
+		if errors.As(err, &cuserr.NotFound{}) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("freeUserName: db: %w", err)
+		}
+	}
+	return "", fmt.Errorf("freeUserName: %w", cuserr.ItemAlreadyExists{ItemName: "username"})
+}
+
+// Cuts s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// Links the provider account to the logged in user so it can log in as them
+func (u *UserLogic) LinkOidcIdentity(userId int, issuer string, claims *oidc.Claims, now time.Time) error {
+	_, err := u.DB.OidcIdentityBySubject(issuer, claims.Subject)
+	if err == nil {
+		return fmt.Errorf("LinkOidcIdentity: %w", cuserr.ItemAlreadyExists{ItemName: "linked account"})
+	}
+	if !errors.As(err, &cuserr.NotFound{}) {
+		return fmt.Errorf("LinkOidcIdentity: db: %w", err)
+	}
+	_, err = u.DB.CreateOidcIdentity(database.OidcIdentityInput{
+		UserId:    userId,
+		Issuer:    issuer,
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+		CreatedAt: now.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("LinkOidcIdentity: db: %w", err)
+	}
+	return nil
+}
+
+func (u *UserLogic) OidcIdentities(userId int) ([]database.OidcIdentity, error) {
+	identities, err := u.DB.OidcIdentities(userId)
+	if err != nil {
+		return nil, fmt.Errorf("OidcIdentities: db: %w", err)
+	}
+	return identities, nil
+}
+
+func (u *UserLogic) UnlinkOidcIdentity(userId, id int) error {
+	rows, err := u.DB.OidcIdentityDelete(id, userId)
+	if err != nil {
+		return fmt.Errorf("UnlinkOidcIdentity: db: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("UnlinkOidcIdentity: %w", cuserr.NotFound{Item: "linked account"})
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package user
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/app/oidc"
+	"wonk/storage"
+)
+
+const oidcTestIssuer = "https://id.example"
+
+func TestOidcLink(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db, PasswordCost: 4}
+	userId, err := u.CreateUser("linked", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherId, err := u.CreateUser("other", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	claims := &oidc.Claims{Subject: "abc123", Email: "old@example.com", PreferredUsername: "linked"}
+
+	// Matching usernames or emails don't log in as an account, only a link does
+	_, _, err = u.OidcLogin(oidcTestIssuer, claims, false, now)
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Fatalf("unlinked: expected NotFound, got %v", err)
+	}
+
+	err = u.LinkOidcIdentity(userId, oidcTestIssuer, claims, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.LinkOidcIdentity(otherId, oidcTestIssuer, claims, now)
+	if !errors.As(err, &cuserr.ItemAlreadyExists{}) {
+		t.Errorf("linked twice: expected ItemAlreadyExists, got %v", err)
+	}
+	// The same subject at another provider is another account
+	err = u.LinkOidcIdentity(otherId, "https://other.example", claims, now)
+	if err != nil {
+		t.Errorf("other issuer: expected no error, got %v", err)
+	}
+
+	claims.Email = "new@example.com"
+	gotId, gotName, err := u.OidcLogin(oidcTestIssuer, claims, false, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotId != userId || gotName != "linked" {
+		t.Errorf("expected user %d linked, got %d %s", userId, gotId, gotName)
+	}
+	identities, err := u.OidcIdentities(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0].Email != "new@example.com" || identities[0].LastUsedAt == nil || *identities[0].LastUsedAt != now.Add(time.Hour).Unix() {
+		t.Fatalf("expected the login to update the link, got %+v", identities)
+	}
+
+	err = u.UnlinkOidcIdentity(otherId, identities[0].Id)
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Errorf("unlink someone else's: expected NotFound, got %v", err)
+	}
+	err = u.UnlinkOidcIdentity(userId, identities[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = u.OidcLogin(oidcTestIssuer, claims, false, now)
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Errorf("unlinked again: expected NotFound, got %v", err)
+	}
+}
+
+func TestOidcProvision(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db, PasswordCost: 4}
+	_, err = u.CreateUser("ana", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		claims   oidc.Claims
+		userName string
+	}{
+		{name: "taken username gets a number", claims: oidc.Claims{Subject: "1", PreferredUsername: "ana"}, userName: "ana2"},
+		{name: "next number", claims: oidc.Claims{Subject: "2", PreferredUsername: "ana"}, userName: "ana3"},
+		{name: "from the email", claims: oidc.Claims{Subject: "3", Email: "bo@example.com"}, userName: "bo"},
+		{name: "nothing to go on", claims: oidc.Claims{Subject: "4"}, userName: DEFAULT_OIDC_USERNAME},
+		{
+			name:     "cut to fit",
+			claims:   oidc.Claims{Subject: "5", PreferredUsername: "a-very-long-preferred-username-from-the-provider"},
+			userName: "a-very-long-preferred-username-f",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			userId, userName, err := u.OidcLogin(oidcTestIssuer, &test.claims, true, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if userName != test.userName {
+				t.Errorf("expected username %q, got %q", test.userName, userName)
+			}
+			// The next login finds the same account instead of making another
+			againId, _, err := u.OidcLogin(oidcTestIssuer, &test.claims, true, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if againId != userId {
+				t.Errorf("expected user %d again, got %d", userId, againId)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/app/strutil"
+	"wonk/storage"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// Raising it rehashes each password the next time its user logs in
+	PASSWORD_COST = bcrypt.DefaultCost
+	// How long an emailed reset link works
+	PASSWORD_RESET_DURATION = 30 * time.Minute
+)
+
+// A reset link to email, Token only exists here, the db keeps its hash
+type PasswordResetRequest struct {
+	UserName  string
+	Email     string
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (u *UserLogic) passwordCost() int {
+	if u.PasswordCost == 0 {
+		return PASSWORD_COST
+	}
+	return u.PasswordCost
+}
+
+func (u *UserLogic) hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), u.passwordCost())
+	if err != nil {
+		return "", fmt.Errorf("hashPassword: %w", err)
+	}
+	return string(hash), nil
+}
+
+// The policy every new password goes through
+func checkNewPassword(userName, password string) error {
+	err := strutil.IsPasswordValid(password)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(userName)) {
+		return cuserr.InvalidInput{FieldName: "password", Reason: "it is the same as the username"}
+	}
+	return nil
+}
+
+// Sets a new password after checking the current one. Every other session is logged out,
+// the one making the change stays.
+func (u *UserLogic) ChangePassword(userId int, sessionId, currentPassword, newPassword string, now time.Time) error {
+	if currentPassword == "" {
+		return fmt.Errorf("ChangePassword: %w", cuserr.InvalidInput{FieldName: "current password", Reason: "value is empty"})
+	}
+	curUser, err := u.DB.UserById(userId)
+	if err != nil {
+		return fmt.Errorf("ChangePassword: db: %w", err)
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(curUser.Password), []byte(currentPassword))
+	if err != nil || len(currentPassword) > strutil.PASSWORD_MAX_BYTES {
+		return fmt.Errorf("ChangePassword: %w", cuserr.InvalidCred{Item: "current password", Reason: "it was incorrect"})
+	}
+	err = checkNewPassword(curUser.UserName, newPassword)
+	if err != nil {
+		return fmt.Errorf("ChangePassword: %w", err)
+	}
+	if newPassword == currentPassword {
+		return fmt.Errorf("ChangePassword: %w", cuserr.InvalidInput{FieldName: "password", Reason: "it is the same as the current one"})
+	}
+	hash, err := u.hashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("ChangePassword: %w", err)
+	}
+	_, err = u.DB.UserUpdatePassword(userId, hash)
+	if err != nil {
+		return fmt.Errorf("ChangePassword: db: %w", err)
+	}
+	_, err = u.DB.UserOtherSessionsRevoke(userId, sessionId, now.Unix())
+	if err != nil {
+		return fmt.Errorf("ChangePassword: db: %w", err)
+	}
+	return nil
+}
+
+// Makes a reset link for the username. The link goes to the email in the user's notification
+// settings, NotFound when there's no such user or no email to send it to.
+func (u *UserLogic) RequestPasswordReset(userName string, now time.Time) (*PasswordResetRequest, error) {
+	curUser, err := u.DB.UserByUserName(userName)
+	if err != nil {
+		return nil, fmt.Errorf("RequestPasswordReset: db: %w", err)
+	}
+	pref, err := u.DB.NotificationPreference(curUser.Id)
+	if err != nil {
+		return nil, fmt.Errorf("RequestPasswordReset: db: %w", err)
+	}
+	if pref.Email == "" {
+		return nil, fmt.Errorf("RequestPasswordReset: %w", cuserr.NotFound{Item: "email address"})
+	}
+	token, tokenHash, err := newRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("RequestPasswordReset: %w", err)
+	}
+	expiresAt := now.Add(PASSWORD_RESET_DURATION)
+	err = u.DB.CreatePasswordReset(database.PasswordResetInput{
+		TokenHash: tokenHash,
+		UserId:    curUser.Id,
+		CreatedAt: now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("RequestPasswordReset: db: %w", err)
+	}
+	return &PasswordResetRequest{UserName: curUser.UserName, Email: pref.Email, Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// Checks the link before showing the new password form
+func (u *UserLogic) PasswordResetValid(token string, now time.Time) error {
+	_, err := u.passwordReset(token, now)
+	if err != nil {
+		return fmt.Errorf("PasswordResetValid: %w", err)
+	}
+	return nil
+}
+
+func (u *UserLogic) passwordReset(token string, now time.Time) (*database.PasswordReset, error) {
+	if token == "" {
+		return nil, fmt.Errorf("passwordReset: %w", cuserr.NotFound{Item: "password reset"})
+	}
+	reset, err := u.DB.PasswordResetByTokenHash(hashRefreshToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("passwordReset: db: %w", err)
+	}
+	if reset.UsedAt != nil {
+		return nil, fmt.Errorf("passwordReset: %w", cuserr.Reused{Item: "password reset"})
+	}
+	if now.Unix() >= reset.ExpiresAt {
+		return nil, fmt.Errorf("passwordReset: %w", cuserr.Expired{Item: "password reset"})
+	}
+	return reset, nil
+}
+
+// Sets the password from a reset link and logs the user out everywhere. Returns the user's id.
+func (u *UserLogic) ResetPassword(token, newPassword string, now time.Time) (int, error) {
+	reset, err := u.passwordReset(token, now)
+	if err != nil {
+		return -1, fmt.Errorf("ResetPassword: %w", err)
+	}
+	curUser, err := u.DB.UserById(reset.UserId)
+	if err != nil {
+		return -1, fmt.Errorf("ResetPassword: db: %w", err)
+	}
+	err = checkNewPassword(curUser.UserName, newPassword)
+	if err != nil {
+		return -1, fmt.Errorf("ResetPassword: %w", err)
+	}
+	hash, err := u.hashPassword(newPassword)
+	if err != nil {
+		return -1, fmt.Errorf("ResetPassword: %w", err)
+	}
+	userId, err := u.DB.UsePasswordReset(reset.TokenHash, hash, now.Unix())
+	if err != nil {
+		return -1, fmt.Errorf("ResetPassword: db: %w", err)
+	}
+	// Used by another request since it was looked up
+	if userId == 0 {
+		return -1, fmt.Errorf("ResetPassword: %w", cuserr.Reused{Item: "password reset"})
+	}
+	return userId, nil
+}
+
+// Hashes made with an older cost are replaced while the password is at hand. Failing to
+// is left for the next login rather than failing this one.
+func (u *UserLogic) rehashIfNeeded(curUser *database.User, password string) {
+	cost, err := bcrypt.Cost([]byte(curUser.Password))
+	if err != nil || cost == u.passwordCost() {
+		return
+	}
+	hash, err := u.hashPassword(password)
+	if err != nil {
+		return
+	}
+	u.DB.UserUpdatePassword(curUser.Id, hash)
+}
@@ -0,0 +1,243 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCreateUserPasswordPolicy(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db}
+	tests := []struct {
+		name     string
+		userName string
+		password string
+		valid    bool
+	}{
+		{name: "too short", userName: "short", password: "abc123!", valid: false},
+		{name: "breached", userName: "breached", password: "Password123", valid: false},
+		{name: "same as username", userName: "sameasname", password: "SameAsName", valid: false},
+		{name: "over 72 bytes", userName: "toolong", password: strings.Repeat("é", 37), valid: false},
+		{name: "long passphrase", userName: "passphrase", password: "correct horse battery staple and then some more words", valid: true},
+		{name: "72 bytes", userName: "maxlength", password: strings.Repeat("é", 36), valid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := u.CreateUser(tt.userName, tt.password)
+			if tt.valid && err != nil {
+				t.Fatalf("didn't expected an error but did get one, err: %v", err)
+			}
+			if !tt.valid && !errors.As(err, &cuserr.InvalidInput{}) {
+				t.Fatalf("expected InvalidInput, got %v", err)
+			}
+			if tt.valid {
+				_, err = u.Login(tt.userName, tt.password)
+				if err != nil {
+					t.Errorf("login with the new password: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoginRehash(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := &UserLogic{DB: db, PasswordCost: bcrypt.MinCost}
+	userId, err := old.CreateUser("rehash", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedCost := func() int {
+		curUser, err := db.UserById(userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cost, err := bcrypt.Cost([]byte(curUser.Password))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cost
+	}
+
+	u := &UserLogic{DB: db, PasswordCost: bcrypt.MinCost + 1}
+	_, err = u.Login("rehash", "wrong password")
+	if err == nil || storedCost() != bcrypt.MinCost {
+		t.Fatalf("expected a wrong password to leave the hash alone, got %v", err)
+	}
+	_, err = u.Login("rehash", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedCost() != bcrypt.MinCost+1 {
+		t.Fatalf("expected the hash to be redone at cost %d, got %d", bcrypt.MinCost+1, storedCost())
+	}
+	_, err = u.Login("rehash", "password1!")
+	if err != nil {
+		t.Errorf("login after rehash: %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db, PasswordCost: bcrypt.MinCost}
+	userId, err := u.CreateUser("changer", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	current, _, err := u.StartSession(userId, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := u.StartSession(userId, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		current string
+		new     string
+		err     any
+	}{
+		{name: "wrong current password", current: "password2!", new: "a new passphrase", err: &cuserr.InvalidCred{}},
+		{name: "empty current password", current: "", new: "a new passphrase", err: &cuserr.InvalidInput{}},
+		{name: "breached new password", current: "password1!", new: "iloveyou1", err: &cuserr.InvalidInput{}},
+		{name: "unchanged", current: "password1!", new: "password1!", err: &cuserr.InvalidInput{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := u.ChangePassword(userId, current, tt.current, tt.new, now)
+			if err == nil {
+				t.Fatal("expected an error but didnt get one")
+			}
+			if !errors.As(err, tt.err) {
+				t.Errorf("expected %T, got %v", tt.err, err)
+			}
+		})
+	}
+
+	err = u.ChangePassword(userId, current, "password1!", "a new passphrase", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.Login("changer", "password1!")
+	if !errors.As(err, &cuserr.InvalidCred{}) {
+		t.Errorf("expected the old password to stop working, got %v", err)
+	}
+	_, err = u.Login("changer", "a new passphrase")
+	if err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+	active, err := u.SessionActive(userId, current, now)
+	if err != nil || !active {
+		t.Errorf("expected the session that changed it to stay, got %v %v", active, err)
+	}
+	active, err = u.SessionActive(userId, other, now)
+	if err != nil || active {
+		t.Errorf("expected other sessions to be logged out, got %v %v", active, err)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db, PasswordCost: bcrypt.MinCost}
+	userId, err := u.CreateUser("forgetful", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	_, err = u.RequestPasswordReset("forgetful", now)
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Fatalf("expected NotFound without an email address, got %v", err)
+	}
+	_, err = u.RequestPasswordReset("nobody", now)
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Fatalf("expected NotFound for an unknown user, got %v", err)
+	}
+	err = db.UpsertNotificationPreference(database.NotificationPreferenceInput{UserId: userId, Email: "forgetful@example.com", UpdatedAt: now.Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := u.RequestPasswordReset("forgetful", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Email != "forgetful@example.com" || first.Token == "" || !first.ExpiresAt.Equal(now.Add(PASSWORD_RESET_DURATION)) {
+		t.Fatalf("unexpected reset %+v", first)
+	}
+	var stored string
+	err = db.(*database.SqliteDb).Db.QueryRow("SELECT token_hash FROM password_reset LIMIT 1").Scan(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == first.Token {
+		t.Fatal("expected reset tokens to be hashed at rest")
+	}
+
+	// A newer link replaces the older one
+	second, err := u.RequestPasswordReset("forgetful", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.PasswordResetValid(first.Token, now)
+	if !errors.As(err, &cuserr.Reused{}) {
+		t.Errorf("expected the replaced link to stop working, got %v", err)
+	}
+	err = u.PasswordResetValid(second.Token, now.Add(PASSWORD_RESET_DURATION))
+	if !errors.As(err, &cuserr.Expired{}) {
+		t.Errorf("expected Expired after %v, got %v", PASSWORD_RESET_DURATION, err)
+	}
+	err = u.PasswordResetValid("not a token", now)
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Errorf("expected NotFound for an unknown token, got %v", err)
+	}
+
+	sessionId, _, err := u.StartSession(userId, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.ResetPassword(second.Token, "forgetful", now)
+	if !errors.As(err, &cuserr.InvalidInput{}) {
+		t.Fatalf("expected the policy to apply, got %v", err)
+	}
+	gotId, err := u.ResetPassword(second.Token, "remember this one", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotId != userId {
+		t.Errorf("expected user %d, got %d", userId, gotId)
+	}
+	_, err = u.ResetPassword(second.Token, "another new one", now)
+	if !errors.As(err, &cuserr.Reused{}) {
+		t.Errorf("expected a link to work once, got %v", err)
+	}
+	_, err = u.Login("forgetful", "remember this one")
+	if err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+	active, err := u.SessionActive(userId, sessionId, now)
+	if err != nil || active {
+		t.Errorf("expected a reset to log out every session, got %v %v", active, err)
+	}
+}
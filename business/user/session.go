@@ -0,0 +1,178 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+const (
+	SESSION_ID_BYTES    = 16
+	REFRESH_TOKEN_BYTES = 32
+	// A token used again this soon after it was swapped is two requests refreshing at
+	// once, not a stolen token, so the session is kept
+	REFRESH_REUSE_GRACE = 10 * time.Second
+)
+
+type RefreshedSession struct {
+	SessionId    string
+	UserId       int
+	UserName     string
+	RefreshToken string    // Empty when another request already rotated the token
+	ExpiresAt    time.Time // The absolute timeout
+}
+
+func newRefreshToken() (string, string, error) {
+	b := make([]byte, REFRESH_TOKEN_BYTES)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", fmt.Errorf("newRefreshToken: rand: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Saves a new session for the user that ends after absoluteTimeout no matter how active it is.
+// Returns its id, used as the jwt's jti, and its first refresh token.
+func (u *UserLogic) StartSession(userId int, now time.Time, absoluteTimeout time.Duration) (string, string, error) {
+	b := make([]byte, SESSION_ID_BYTES)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", fmt.Errorf("StartSession: rand: %w", err)
+	}
+	sessionId := hex.EncodeToString(b)
+	refreshToken, refreshHash, err := newRefreshToken()
+	if err != nil {
+		return "", "", fmt.Errorf("StartSession: %w", err)
+	}
+	// Logging in is a good time to clear the user's old sessions
+	_, err = u.DB.UserSessionsDeleteExpired(userId, now.Unix())
+	if err != nil {
+		return "", "", fmt.Errorf("StartSession: db: %w", err)
+	}
+	err = u.DB.CreateSession(database.SessionInput{
+		Id:         sessionId,
+		UserId:     userId,
+		CreatedAt:  now.Unix(),
+		ExpiresAt:  now.Add(absoluteTimeout).Unix(),
+		LastSeenAt: now.Unix(),
+	}, database.RefreshTokenInput{
+		TokenHash: refreshHash,
+		SessionId: sessionId,
+		CreatedAt: now.Unix(),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("StartSession: db: %w", err)
+	}
+	return sessionId, refreshToken, nil
+}
+
+// Swaps the refresh token for a new one. Returns InvalidCred for an unknown token or a
+// logged out session, Expired once the session is past its idle or absolute timeout,
+// and Reused when a token that was already swapped comes back, which also revokes the
+// session since either the user or whoever stole the token is holding a copy.
+func (u *UserLogic) RefreshSession(refreshToken string, now time.Time, idleTimeout time.Duration) (*RefreshedSession, error) {
+	tokenHash := hashRefreshToken(refreshToken)
+	token, err := u.DB.RefreshTokenByHash(tokenHash)
+	if err != nil {
+		if errors.As(err, &cuserr.NotFound{}) {
+			return nil, fmt.Errorf("RefreshSession: %w", cuserr.InvalidCred{Item: "refresh token", Reason: "it doesn't exist"})
+		}
+		return nil, fmt.Errorf("RefreshSession: db: %w", err)
+	}
+	session, err := u.DB.SessionById(token.SessionId)
+	if err != nil {
+		return nil, fmt.Errorf("RefreshSession: db: %w", err)
+	}
+	if session.RevokedAt != nil {
+		return nil, fmt.Errorf("RefreshSession: %w", cuserr.InvalidCred{Item: "session", Reason: "it was logged out"})
+	}
+	if now.Unix() >= session.ExpiresAt {
+		return nil, fmt.Errorf("RefreshSession: absolute: %w", cuserr.Expired{Item: "session"})
+	}
+	curUser, err := u.DB.UserById(session.UserId)
+	if err != nil {
+		return nil, fmt.Errorf("RefreshSession: db: %w", err)
+	}
+	refreshed := RefreshedSession{
+		SessionId: session.Id,
+		UserId:    session.UserId,
+		UserName:  curUser.UserName,
+		ExpiresAt: time.Unix(session.ExpiresAt, 0),
+	}
+
+	if token.UsedAt != nil {
+		if now.Sub(time.Unix(*token.UsedAt, 0)) <= REFRESH_REUSE_GRACE {
+			return &refreshed, nil
+		}
+		_, err = u.DB.SessionRevoke(session.Id, session.UserId, now.Unix())
+		if err != nil {
+			return nil, fmt.Errorf("RefreshSession: db: %w", err)
+		}
+		return nil, fmt.Errorf("RefreshSession: %w", cuserr.Reused{Item: "refresh token"})
+	}
+	if now.Sub(time.Unix(session.LastSeenAt, 0)) > idleTimeout {
+		return nil, fmt.Errorf("RefreshSession: idle: %w", cuserr.Expired{Item: "session"})
+	}
+
+	nextToken, nextHash, err := newRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("RefreshSession: %w", err)
+	}
+	rowsChanged, err := u.DB.RotateRefreshToken(tokenHash, now.Unix(), database.RefreshTokenInput{
+		TokenHash: nextHash,
+		SessionId: session.Id,
+		CreatedAt: now.Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("RefreshSession: db: %w", err)
+	}
+	// Another request rotated it between the read and the update
+	if rowsChanged == 0 {
+		return &refreshed, nil
+	}
+	refreshed.RefreshToken = nextToken
+	return &refreshed, nil
+}
+
+// A session is active when it belongs to the user, hasn't been revoked and hasn't expired
+func (u *UserLogic) SessionActive(userId int, sessionId string, now time.Time) (bool, error) {
+	session, err := u.DB.SessionById(sessionId)
+	if err != nil {
+		if errors.As(err, &cuserr.NotFound{}) {
+			return false, nil
+		}
+		return false, fmt.Errorf("SessionActive: db: %w", err)
+	}
+	active := session.UserId == userId && session.RevokedAt == nil && session.ExpiresAt > now.Unix()
+	return active, nil
+}
+
+// Revoking an already revoked session isn't an error so logging out twice works
+func (u *UserLogic) EndSession(userId int, sessionId string, now time.Time) error {
+	_, err := u.DB.SessionRevoke(sessionId, userId, now.Unix())
+	if err != nil {
+		return fmt.Errorf("EndSession: db: %w", err)
+	}
+	return nil
+}
+
+// Logs the user out on every device
+func (u *UserLogic) EndAllSessions(userId int, now time.Time) error {
+	_, err := u.DB.UserSessionsRevoke(userId, now.Unix())
+	if err != nil {
+		return fmt.Errorf("EndAllSessions: db: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package user
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/storage"
+)
+
+func TestRefreshSession(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db}
+	userId, err := db.CreateUser("refresher", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle := time.Hour
+	absolute := 24 * time.Hour
+	start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	sessionId, first, err := u.StartSession(userId, start, absolute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := start.Add(10 * time.Minute)
+	refreshed, err := u.RefreshSession(first, now, idle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.SessionId != sessionId || refreshed.UserId != userId || refreshed.UserName != "refresher" {
+		t.Errorf("unexpected session %+v", refreshed)
+	}
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == first {
+		t.Fatalf("expected a new refresh token, got %q", refreshed.RefreshToken)
+	}
+	if !refreshed.ExpiresAt.Equal(start.Add(absolute)) {
+		t.Errorf("expires at: expected %v, got %v", start.Add(absolute), refreshed.ExpiresAt)
+	}
+	second := refreshed.RefreshToken
+
+	// Two requests racing with the same token both get through
+	raced, err := u.RefreshSession(first, now.Add(REFRESH_REUSE_GRACE), idle)
+	if err != nil {
+		t.Fatalf("reuse within grace: %v", err)
+	}
+	if raced.RefreshToken != "" {
+		t.Errorf("reuse within grace shouldn't rotate, got %q", raced.RefreshToken)
+	}
+
+	// Activity keeps the session alive past the first idle timeout
+	now = now.Add(50 * time.Minute)
+	refreshed, err = u.RefreshSession(second, now, idle)
+	if err != nil {
+		t.Fatalf("refresh within idle timeout: %v", err)
+	}
+	third := refreshed.RefreshToken
+
+	// Replaying an old token after the grace revokes the session
+	_, err = u.RefreshSession(first, now.Add(time.Minute), idle)
+	if !errors.As(err, &cuserr.Reused{}) {
+		t.Fatalf("expected Reused, got %v", err)
+	}
+	active, err := u.SessionActive(userId, sessionId, now.Add(time.Minute))
+	if err != nil || active {
+		t.Errorf("expected the session to be revoked, got %v %v", active, err)
+	}
+	_, err = u.RefreshSession(third, now.Add(2*time.Minute), idle)
+	if !errors.As(err, &cuserr.InvalidCred{}) {
+		t.Errorf("expected the latest token to stop working, got %v", err)
+	}
+
+	_, err = u.RefreshSession("not a token", now, idle)
+	if !errors.As(err, &cuserr.InvalidCred{}) {
+		t.Errorf("expected InvalidCred for an unknown token, got %v", err)
+	}
+}
+
+func TestRefreshSessionTimeouts(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db}
+	userId, err := db.CreateUser("sleepy", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle := time.Hour
+	absolute := 3 * time.Hour
+	start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	_, token, err := u.StartSession(userId, start, absolute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.RefreshSession(token, start.Add(idle+time.Minute), idle)
+	if !errors.As(err, &cuserr.Expired{}) {
+		t.Errorf("idle: expected Expired, got %v", err)
+	}
+
+	// Refreshing every 50 minutes never goes idle but still hits the absolute timeout
+	_, token, err = u.StartSession(userId, start, absolute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := start
+	for {
+		now = now.Add(50 * time.Minute)
+		refreshed, err := u.RefreshSession(token, now, idle)
+		if now.Sub(start) >= absolute {
+			if !errors.As(err, &cuserr.Expired{}) {
+				t.Errorf("absolute: expected Expired at %v, got %v", now.Sub(start), err)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("refresh at %v: %v", now.Sub(start), err)
+		}
+		token = refreshed.RefreshToken
+	}
+}
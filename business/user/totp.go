@@ -0,0 +1,238 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/app/totp"
+	"wonk/storage"
+)
+
+const (
+	TOTP_ISSUER         = "Wonk"
+	RECOVERY_CODE_COUNT = 10
+	// Bytes of randomness per recovery code, 10 base32 characters
+	RECOVERY_CODE_BYTES = 6
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TotpStatus struct {
+	Enabled           bool
+	Enrollment        *TotpEnrollment // Set when enrollment was started but not confirmed
+	RecoveryCodesLeft int
+}
+
+type TotpEnrollment struct {
+	Secret string // Base32, for typing into an app that can't scan the QR code
+	URI    string // otpauth url to show as a QR code
+}
+
+// Written as two groups of 5 so they're easier to copy, e.g. "abcde-fghij"
+func newRecoveryCode() (string, error) {
+	b := make([]byte, RECOVERY_CODE_BYTES)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("newRecoveryCode: rand: %w", err)
+	}
+	code := strings.ToLower(recoveryEncoding.EncodeToString(b))
+	return code[:5] + "-" + code[5:], nil
+}
+
+// Hashed like refresh tokens, the codes are random enough that a fast hash is fine
+func hashRecoveryCode(code string) string {
+	return hashRefreshToken(normalizeCode(code))
+}
+
+func normalizeCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+func isTotpCode(code string) bool {
+	if len(code) != totp.DIGITS {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *UserLogic) TotpStatus(userId int) (*TotpStatus, error) {
+	t, err := u.DB.TotpByUserId(userId)
+	if err != nil {
+		if errors.As(err, &cuserr.NotFound{}) {
+			return &TotpStatus{}, nil
+		}
+		return nil, fmt.Errorf("TotpStatus: db: %w", err)
+	}
+	if t.EnabledAt == nil {
+		curUser, err := u.DB.UserById(userId)
+		if err != nil {
+			return nil, fmt.Errorf("TotpStatus: db: %w", err)
+		}
+		enrollment, err := newTotpEnrollment(curUser.UserName, t.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("TotpStatus: %w", err)
+		}
+		return &TotpStatus{Enrollment: enrollment}, nil
+	}
+	left, err := u.DB.RecoveryCodesLeft(userId)
+	if err != nil {
+		return nil, fmt.Errorf("TotpStatus: db: %w", err)
+	}
+	return &TotpStatus{Enabled: true, RecoveryCodesLeft: left}, nil
+}
+
+// Whether logging in needs a second step
+func (u *UserLogic) TotpEnabled(userId int) (bool, error) {
+	status, err := u.TotpStatus(userId)
+	if err != nil {
+		return false, fmt.Errorf("TotpEnabled: %w", err)
+	}
+	return status.Enabled, nil
+}
+
+// Makes a new secret for the user, replacing any unconfirmed one. 2FA stays off until
+// ConfirmTotpEnrollment gets a code made from it.
+func (u *UserLogic) BeginTotpEnrollment(userId int, now time.Time) (*TotpEnrollment, error) {
+	curUser, err := u.DB.UserById(userId)
+	if err != nil {
+		return nil, fmt.Errorf("BeginTotpEnrollment: db: %w", err)
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, fmt.Errorf("BeginTotpEnrollment: %w", err)
+	}
+	encodedSecret := totp.EncodeSecret(secret)
+	rows, err := u.DB.UpsertPendingTotp(database.TotpInput{
+		UserId:    userId,
+		Secret:    encodedSecret,
+		CreatedAt: now.Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("BeginTotpEnrollment: db: %w", err)
+	}
+	if rows == 0 {
+		return nil, fmt.Errorf("BeginTotpEnrollment: %w", cuserr.ItemAlreadyExists{ItemName: "two-factor authentication"})
+	}
+	enrollment, err := newTotpEnrollment(curUser.UserName, encodedSecret)
+	if err != nil {
+		return nil, fmt.Errorf("BeginTotpEnrollment: %w", err)
+	}
+	return enrollment, nil
+}
+
+func newTotpEnrollment(userName, encodedSecret string) (*TotpEnrollment, error) {
+	secret, err := totp.DecodeSecret(encodedSecret)
+	if err != nil {
+		return nil, fmt.Errorf("newTotpEnrollment: %w", err)
+	}
+	return &TotpEnrollment{
+		Secret: encodedSecret,
+		URI:    totp.URI(TOTP_ISSUER, userName, secret),
+	}, nil
+}
+
+// Turns 2FA on once the user proves their app has the secret. Returns the recovery codes,
+// they can't be shown again since only hashes are saved.
+func (u *UserLogic) ConfirmTotpEnrollment(userId int, code string, now time.Time) ([]string, error) {
+	t, err := u.DB.TotpByUserId(userId)
+	if err != nil {
+		return nil, fmt.Errorf("ConfirmTotpEnrollment: db: %w", err)
+	}
+	if t.EnabledAt != nil {
+		return nil, fmt.Errorf("ConfirmTotpEnrollment: %w", cuserr.ItemAlreadyExists{ItemName: "two-factor authentication"})
+	}
+	secret, err := totp.DecodeSecret(t.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("ConfirmTotpEnrollment: %w", err)
+	}
+	counter, ok := totp.Validate(secret, normalizeCode(code), now)
+	if !ok {
+		return nil, fmt.Errorf("ConfirmTotpEnrollment: %w", cuserr.InvalidCred{Item: "code", Reason: "it was incorrect"})
+	}
+
+	codes := make([]string, RECOVERY_CODE_COUNT)
+	hashes := make([]string, RECOVERY_CODE_COUNT)
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("ConfirmTotpEnrollment: %w", err)
+		}
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	rows, err := u.DB.EnableTotp(userId, counter, now.Unix(), hashes)
+	if err != nil {
+		return nil, fmt.Errorf("ConfirmTotpEnrollment: db: %w", err)
+	}
+	if rows == 0 {
+		return nil, fmt.Errorf("ConfirmTotpEnrollment: %w", cuserr.ItemAlreadyExists{ItemName: "two-factor authentication"})
+	}
+	return codes, nil
+}
+
+// Checks the second login step. Takes a code from the app, which can't be used twice,
+// or a recovery code, which is then used up.
+func (u *UserLogic) VerifyTotp(userId int, code string, now time.Time) error {
+	t, err := u.DB.TotpByUserId(userId)
+	if err != nil {
+		return fmt.Errorf("VerifyTotp: db: %w", err)
+	}
+	if t.EnabledAt == nil {
+		return fmt.Errorf("VerifyTotp: %w", cuserr.NotFound{Item: "two-factor authentication"})
+	}
+	code = normalizeCode(code)
+	if code == "" {
+		return fmt.Errorf("VerifyTotp: %w", cuserr.InvalidInput{FieldName: "code", Reason: "it was empty"})
+	}
+
+	if !isTotpCode(code) {
+		rows, err := u.DB.UseRecoveryCode(userId, hashRecoveryCode(code), now.Unix())
+		if err != nil {
+			return fmt.Errorf("VerifyTotp: db: %w", err)
+		}
+		if rows == 0 {
+			return fmt.Errorf("VerifyTotp: %w", cuserr.InvalidCred{Item: "recovery code", Reason: "it was incorrect or already used"})
+		}
+		return nil
+	}
+
+	secret, err := totp.DecodeSecret(t.Secret)
+	if err != nil {
+		return fmt.Errorf("VerifyTotp: %w", err)
+	}
+	counter, ok := totp.Validate(secret, code, now)
+	if !ok {
+		return fmt.Errorf("VerifyTotp: %w", cuserr.InvalidCred{Item: "code", Reason: "it was incorrect"})
+	}
+	rows, err := u.DB.UseTotpCounter(userId, counter)
+	if err != nil {
+		return fmt.Errorf("VerifyTotp: db: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("VerifyTotp: %w", cuserr.Reused{Item: "code"})
+	}
+	return nil
+}
+
+// Turns 2FA off, which needs a current code or a recovery code so a stolen session alone can't do it
+func (u *UserLogic) DisableTotp(userId int, code string, now time.Time) error {
+	err := u.VerifyTotp(userId, code, now)
+	if err != nil {
+		return fmt.Errorf("DisableTotp: %w", err)
+	}
+	_, err = u.DB.TotpDelete(userId)
+	if err != nil {
+		return fmt.Errorf("DisableTotp: db: %w", err)
+	}
+	return nil
+}
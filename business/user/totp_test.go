@@ -0,0 +1,191 @@
+package user
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/app/totp"
+	"wonk/storage"
+)
+
+func TestTotpEnrollment(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db}
+	userId, err := u.CreateUser("twofactor", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	enrollment, err := u.BeginTotpEnrollment(userId, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totp.DecodeSecret(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := u.TotpStatus(userId)
+	if err != nil || status.Enabled || status.Enrollment == nil || *status.Enrollment != *enrollment {
+		t.Fatalf("expected the pending enrollment, got %+v %v", status, err)
+	}
+
+	_, err = u.ConfirmTotpEnrollment(userId, "000000", now)
+	if !errors.As(err, &cuserr.InvalidCred{}) {
+		t.Fatalf("expected InvalidCred for a wrong code, got %v", err)
+	}
+	codes, err := u.ConfirmTotpEnrollment(userId, totp.Code(secret, now), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RECOVERY_CODE_COUNT {
+		t.Fatalf("expected %d recovery codes, got %d", RECOVERY_CODE_COUNT, len(codes))
+	}
+	for _, code := range codes {
+		if !regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`).MatchString(code) {
+			t.Errorf("unexpected recovery code format %q", code)
+		}
+	}
+	enabled, err := u.TotpEnabled(userId)
+	if err != nil || !enabled {
+		t.Fatalf("expected 2fa to be enabled, got %v %v", enabled, err)
+	}
+	_, err = u.BeginTotpEnrollment(userId, now)
+	if !errors.As(err, &cuserr.ItemAlreadyExists{}) {
+		t.Errorf("expected ItemAlreadyExists when enrolling twice, got %v", err)
+	}
+
+	// Recovery codes are only kept hashed
+	var stored string
+	err = db.(*database.SqliteDb).Db.QueryRow("SELECT code_hash FROM recovery_code LIMIT 1").Scan(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range codes {
+		if stored == code || stored == normalizeCode(code) {
+			t.Fatal("expected recovery codes to be hashed at rest")
+		}
+	}
+}
+
+func TestVerifyTotp(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db}
+	userId, err := u.CreateUser("verify", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	enrollment, err := u.BeginTotpEnrollment(userId, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := totp.DecodeSecret(enrollment.Secret)
+	codes, err := u.ConfirmTotpEnrollment(userId, totp.Code(secret, now), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := now.Add(2 * totp.PERIOD)
+
+	tests := []struct {
+		name     string
+		code     string
+		at       time.Time
+		expected error
+	}{
+		{name: "code used to confirm", code: totp.Code(secret, now), at: now, expected: cuserr.Reused{}},
+		{name: "wrong code", code: "123456", at: later, expected: cuserr.InvalidCred{}},
+		{name: "empty", code: " ", at: later, expected: cuserr.InvalidInput{}},
+		{name: "current code", code: totp.Code(secret, later), at: later},
+		{name: "current code again", code: totp.Code(secret, later), at: later, expected: cuserr.Reused{}},
+		{name: "older code after a newer one", code: totp.Code(secret, later.Add(-totp.PERIOD)), at: later, expected: cuserr.Reused{}},
+		{name: "recovery code", code: codes[0], at: later},
+		{name: "recovery code again", code: codes[0], at: later, expected: cuserr.InvalidCred{}},
+		{name: "recovery code typed loosely", code: " " + codes[1][:5] + codes[1][6:] + " ", at: later},
+		{name: "wrong recovery code", code: "aaaaa-aaaaa", at: later, expected: cuserr.InvalidCred{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := u.VerifyTotp(userId, tt.code, tt.at)
+			switch expected := tt.expected.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+			case cuserr.Reused:
+				if !errors.As(err, &expected) {
+					t.Errorf("expected Reused, got %v", err)
+				}
+			case cuserr.InvalidCred:
+				if !errors.As(err, &expected) {
+					t.Errorf("expected InvalidCred, got %v", err)
+				}
+			case cuserr.InvalidInput:
+				if !errors.As(err, &expected) {
+					t.Errorf("expected InvalidInput, got %v", err)
+				}
+			}
+		})
+	}
+
+	status, err := u.TotpStatus(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.RecoveryCodesLeft != RECOVERY_CODE_COUNT-2 {
+		t.Errorf("expected %d recovery codes left, got %d", RECOVERY_CODE_COUNT-2, status.RecoveryCodesLeft)
+	}
+}
+
+func TestDisableTotp(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db}
+	userId, err := u.CreateUser("disable", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	err = u.DisableTotp(userId, "123456", now)
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Fatalf("expected NotFound without 2fa, got %v", err)
+	}
+	enrollment, err := u.BeginTotpEnrollment(userId, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := totp.DecodeSecret(enrollment.Secret)
+	_, err = u.ConfirmTotpEnrollment(userId, totp.Code(secret, now), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := now.Add(time.Minute)
+
+	err = u.DisableTotp(userId, "000000", later)
+	if !errors.As(err, &cuserr.InvalidCred{}) {
+		t.Fatalf("expected InvalidCred for a wrong code, got %v", err)
+	}
+	err = u.DisableTotp(userId, totp.Code(secret, later), later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := u.TotpStatus(userId)
+	if err != nil || status.Enabled || status.Enrollment != nil {
+		t.Errorf("expected 2fa to be off, got %+v %v", status, err)
+	}
+	left, err := db.RecoveryCodesLeft(userId)
+	if err != nil || left != 0 {
+		t.Errorf("expected the recovery codes to be deleted, got %d %v", left, err)
+	}
+}
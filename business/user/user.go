@@ -1,10 +1,14 @@
 package user
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 	"wonk/app/cuserr"
+	"wonk/app/oidc"
 	"wonk/app/strutil"
+	"wonk/app/webauthn"
 	"wonk/storage"
 
 	"golang.org/x/crypto/bcrypt"
@@ -13,10 +17,38 @@ import (
 type User interface {
 	Login(string, string) (int, error)
 	CreateUser(string, string) (int, error)
+	StartSession(int, time.Time, time.Duration) (string, string, error)
+	RefreshSession(string, time.Time, time.Duration) (*RefreshedSession, error)
+	SessionActive(int, string, time.Time) (bool, error)
+	EndSession(int, string, time.Time) error
+	EndAllSessions(int, time.Time) error
+	RecordLoginFailure(context.Context, string, string, string, string, time.Time) error
+	TotpStatus(int) (*TotpStatus, error)
+	TotpEnabled(int) (bool, error)
+	BeginTotpEnrollment(int, time.Time) (*TotpEnrollment, error)
+	ConfirmTotpEnrollment(int, string, time.Time) ([]string, error)
+	VerifyTotp(int, string, time.Time) error
+	DisableTotp(int, string, time.Time) error
+	PasskeyUser(int) (*PasskeyUser, error)
+	Passkeys(int) ([]database.WebauthnCredential, error)
+	RegisterPasskey(*webauthn.RelyingParty, int, []byte, *webauthn.RegistrationCredential, string, time.Time) error
+	StartPasskeyChallenge(string, int, time.Time, time.Duration) (string, []byte, error)
+	UsePasskeyChallenge(string, string, int, time.Time) ([]byte, error)
+	PasskeyLogin(*webauthn.RelyingParty, []byte, *webauthn.AssertionCredential, time.Time) (int, string, error)
+	DeletePasskey(int, int) error
+	ChangePassword(int, string, string, string, time.Time) error
+	RequestPasswordReset(string, time.Time) (*PasswordResetRequest, error)
+	PasswordResetValid(string, time.Time) error
+	ResetPassword(string, string, time.Time) (int, error)
+	OidcLogin(string, *oidc.Claims, bool, time.Time) (int, string, error)
+	LinkOidcIdentity(int, string, *oidc.Claims, time.Time) error
+	OidcIdentities(int) ([]database.OidcIdentity, error)
+	UnlinkOidcIdentity(int, int) error
 }
 
 type UserLogic struct {
-	DB database.Database
+	DB           database.Database
+	PasswordCost int // bcrypt cost for new hashes, 0 uses PASSWORD_COST
 }
 
 func InitUserService(db database.Database) User {
@@ -31,14 +63,20 @@ func (u *UserLogic) Login(userName, password string) (int, error) {
 	if err != nil {
 		return -1, fmt.Errorf("Login: username: %w", err)
 	}
-	err = strutil.IsStringValid(password, "password")
-	if err != nil {
-		return -1, fmt.Errorf("Login: password: %w", err)
+	if password == "" {
+		return -1, fmt.Errorf("Login: password: %w", cuserr.InvalidInput{FieldName: "password", Reason: "value is empty"})
+	}
+	// Past what bcrypt reads, so it can't be the password that was set
+	if len(password) > strutil.PASSWORD_MAX_BYTES {
+		return -1, fmt.Errorf("Login: password: %w", cuserr.InvalidCred{Item: "password", Reason: "it was incorrect"})
 	}
 
 	// Get User
 	curUser, err := u.DB.UserByUserName(userName)
 	if err != nil {
+		if errors.As(err, &cuserr.NotFound{}) {
+			bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		}
 		return -1, fmt.Errorf("Login: UserLogic: %w", err)
 	}
 
@@ -47,6 +85,7 @@ func (u *UserLogic) Login(userName, password string) (int, error) {
 	if err != nil {
 		return -1, fmt.Errorf("Login: password: %w", cuserr.InvalidCred{Item: "password", Reason: "it was incorrect"})
 	}
+	u.rehashIfNeeded(curUser, password)
 
 	return curUser.Id, nil
 }
@@ -57,7 +96,7 @@ func (u *UserLogic) CreateUser(userName, password string) (int, error) {
 	if err != nil {
 		return -1, fmt.Errorf("CreateUser: username: %w", err)
 	}
-	err = strutil.IsPasswordValid(password)
+	err = checkNewPassword(userName, password)
 	if err != nil {
 		return -1, fmt.Errorf("CreateUser: password: %w", err)
 	}
@@ -74,13 +113,13 @@ func (u *UserLogic) CreateUser(userName, password string) (int, error) {
 	}
 
 	// Hash Password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := u.hashPassword(password)
 	if err != nil {
 		return -1, fmt.Errorf("CreateUser: %w", err)
 	}
 
 	// Save new User to DB
-	userId, err := u.DB.CreateUser(userName, string(hashedPassword))
+	userId, err := u.DB.CreateUser(userName, hashedPassword)
 	if err != nil {
 		return -1, fmt.Errorf("CreateUser: db: %w", err)
 	}
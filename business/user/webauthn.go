@@ -0,0 +1,184 @@
+package user
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/app/webauthn"
+	"wonk/storage"
+)
+
+const (
+	PASSKEY_NAME_MAX     = 64
+	DEFAULT_PASSKEY_NAME = "Passkey"
+)
+
+// What registration options need about the user
+type PasskeyUser struct {
+	Handle        []byte // user.id in the options, opaque so it holds no personal info
+	UserName      string
+	CredentialIds [][]byte // Already registered, so the same authenticator isn't added twice
+}
+
+// The user id as 8 bytes, authenticators send it back when logging in
+func userHandle(userId int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userId))
+}
+
+func credentialIdString(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+func (u *UserLogic) PasskeyUser(userId int) (*PasskeyUser, error) {
+	curUser, err := u.DB.UserById(userId)
+	if err != nil {
+		return nil, fmt.Errorf("PasskeyUser: db: %w", err)
+	}
+	creds, err := u.DB.WebauthnCredentials(userId)
+	if err != nil {
+		return nil, fmt.Errorf("PasskeyUser: db: %w", err)
+	}
+	ids := make([][]byte, 0, len(creds))
+	for _, c := range creds {
+		id, err := base64.RawURLEncoding.DecodeString(c.CredentialId)
+		if err != nil {
+			return nil, fmt.Errorf("PasskeyUser: credential id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return &PasskeyUser{Handle: userHandle(userId), UserName: curUser.UserName, CredentialIds: ids}, nil
+}
+
+func (u *UserLogic) Passkeys(userId int) ([]database.WebauthnCredential, error) {
+	creds, err := u.DB.WebauthnCredentials(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Passkeys: db: %w", err)
+	}
+	return creds, nil
+}
+
+// Verifies the authenticator's response to the registration options and saves the passkey
+func (u *UserLogic) RegisterPasskey(rp *webauthn.RelyingParty, userId int, challenge []byte, cred *webauthn.RegistrationCredential, name string, now time.Time) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = DEFAULT_PASSKEY_NAME
+	}
+	if len(name) > PASSKEY_NAME_MAX {
+		return fmt.Errorf("RegisterPasskey: %w", cuserr.InvalidInput{FieldName: "name", Reason: fmt.Sprintf("it is longer than %d characters", PASSKEY_NAME_MAX)})
+	}
+	verified, err := rp.VerifyRegistration(challenge, cred)
+	if err != nil {
+		return fmt.Errorf("RegisterPasskey: %w: %w", cuserr.InvalidCred{Item: "passkey", Reason: "it could not be verified"}, err)
+	}
+	credentialId := credentialIdString(verified.Id)
+	_, err = u.DB.WebauthnCredentialByCredentialId(credentialId)
+	if err == nil {
+		return fmt.Errorf("RegisterPasskey: %w", cuserr.ItemAlreadyExists{ItemName: "passkey"})
+	}
+	if !errors.As(err, &cuserr.NotFound{}) {
+		return fmt.Errorf("RegisterPasskey: db: %w", err)
+	}
+	_, err = u.DB.CreateWebauthnCredential(database.WebauthnCredentialInput{
+		UserId:       userId,
+		CredentialId: credentialId,
+		PublicKey:    verified.PublicKey,
+		SignCount:    int64(verified.SignCount),
+		Name:         name,
+		CreatedAt:    now.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("RegisterPasskey: db: %w", err)
+	}
+	return nil
+}
+
+// Makes the challenge for a ceremony's options and saves it. Returns the id for the browser to
+// send back with the response, the db only keeps its hash.
+func (u *UserLogic) StartPasskeyChallenge(ceremony string, userId int, now time.Time, duration time.Duration) (string, []byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", nil, fmt.Errorf("StartPasskeyChallenge: %w", err)
+	}
+	id, idHash, err := newRefreshToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("StartPasskeyChallenge: %w", err)
+	}
+	err = u.DB.CreateWebauthnChallenge(database.WebauthnChallengeInput{
+		IdHash:    idHash,
+		Challenge: challenge,
+		Ceremony:  ceremony,
+		UserId:    userId,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(duration).Unix(),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("StartPasskeyChallenge: db: %w", err)
+	}
+	return id, challenge, nil
+}
+
+// Returns the challenge saved under the id and deletes it, so a captured response can't be
+// sent again. A challenge from another ceremony or user is treated as missing.
+func (u *UserLogic) UsePasskeyChallenge(id, ceremony string, userId int, now time.Time) ([]byte, error) {
+	if id == "" {
+		return nil, fmt.Errorf("UsePasskeyChallenge: %w", cuserr.NotFound{Item: "passkey challenge"})
+	}
+	c, err := u.DB.UseWebauthnChallenge(hashRefreshToken(id))
+	if err != nil {
+		return nil, fmt.Errorf("UsePasskeyChallenge: db: %w", err)
+	}
+	if c.Ceremony != ceremony || c.UserId != userId {
+		return nil, fmt.Errorf("UsePasskeyChallenge: %w", cuserr.NotFound{Item: "passkey challenge"})
+	}
+	if now.Unix() >= c.ExpiresAt {
+		return nil, fmt.Errorf("UsePasskeyChallenge: %w", cuserr.Expired{Item: "passkey challenge"})
+	}
+	return c.Challenge, nil
+}
+
+// Finds the passkey the browser picked and verifies its signature. Returns the user it belongs to.
+func (u *UserLogic) PasskeyLogin(rp *webauthn.RelyingParty, challenge []byte, cred *webauthn.AssertionCredential, now time.Time) (int, string, error) {
+	invalid := cuserr.InvalidCred{Item: "passkey", Reason: "it could not be verified"}
+	stored, err := u.DB.WebauthnCredentialByCredentialId(credentialIdString(cred.RawId))
+	if err != nil {
+		if errors.As(err, &cuserr.NotFound{}) {
+			return -1, "", fmt.Errorf("PasskeyLogin: %w: %w", invalid, err)
+		}
+		return -1, "", fmt.Errorf("PasskeyLogin: db: %w", err)
+	}
+	if len(cred.Response.UserHandle) > 0 && !bytes.Equal(cred.Response.UserHandle, userHandle(stored.UserId)) {
+		return -1, "", fmt.Errorf("PasskeyLogin: %w: user handle does not match", invalid)
+	}
+	signCount, err := rp.VerifyAssertion(challenge, stored.PublicKey, uint32(stored.SignCount), cred)
+	if err != nil {
+		return -1, "", fmt.Errorf("PasskeyLogin: %w: %w", invalid, err)
+	}
+	rows, err := u.DB.WebauthnCredentialUse(stored.Id, stored.SignCount, int64(signCount), now.Unix())
+	if err != nil {
+		return -1, "", fmt.Errorf("PasskeyLogin: db: %w", err)
+	}
+	if rows == 0 {
+		return -1, "", fmt.Errorf("PasskeyLogin: %w", cuserr.Reused{Item: "passkey signature"})
+	}
+	curUser, err := u.DB.UserById(stored.UserId)
+	if err != nil {
+		return -1, "", fmt.Errorf("PasskeyLogin: db: %w", err)
+	}
+	return curUser.Id, curUser.UserName, nil
+}
+
+func (u *UserLogic) DeletePasskey(userId, id int) error {
+	rows, err := u.DB.WebauthnCredentialDelete(id, userId)
+	if err != nil {
+		return fmt.Errorf("DeletePasskey: db: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("DeletePasskey: %w", cuserr.NotFound{Item: "passkey"})
+	}
+	return nil
+}
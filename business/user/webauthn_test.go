@@ -0,0 +1,280 @@
+package user
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"wonk/app/cuserr"
+	"wonk/app/webauthn"
+	"wonk/app/webauthn/webauthntest"
+	"wonk/storage"
+)
+
+const passkeyTestOrigin = "https://wonk.example"
+
+func passkeyTestRelyingParty() *webauthn.RelyingParty {
+	return &webauthn.RelyingParty{ID: "wonk.example", Name: "Wonk", Origins: []string{passkeyTestOrigin}}
+}
+
+func registerTestPasskey(t *testing.T, u *UserLogic, rp *webauthn.RelyingParty, a *webauthntest.Authenticator, userId int, name string, now time.Time) error {
+	t.Helper()
+	passkeyUser, err := u.PasskeyUser(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := a.Create(rp.CreationOptions(challenge, passkeyUser.Handle, passkeyUser.UserName, passkeyUser.CredentialIds))
+	if err != nil {
+		return err
+	}
+	return u.RegisterPasskey(rp, userId, challenge, resp, name, now)
+}
+
+func TestRegisterPasskey(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db}
+	userId, err := u.CreateUser("passkey", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := passkeyTestRelyingParty()
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	err = registerTestPasskey(t, u, rp, webauthntest.New(passkeyTestOrigin), userId, "  ", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := webauthntest.New(passkeyTestOrigin)
+	err = registerTestPasskey(t, u, rp, a, userId, "Laptop", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passkeys, err := u.Passkeys(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passkeys) != 2 || passkeys[0].Name != DEFAULT_PASSKEY_NAME || passkeys[1].Name != "Laptop" {
+		t.Fatalf("expected the two passkeys, got %+v", passkeys)
+	}
+
+	// The options exclude what's registered so the authenticator refuses to make another
+	err = registerTestPasskey(t, u, rp, a, userId, "Again", now)
+	if !errors.Is(err, webauthntest.ErrExcluded) {
+		t.Errorf("expected the authenticator to be excluded, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(challenge []byte, resp *webauthn.RegistrationCredential) ([]byte, string)
+		err    any
+	}{
+		{
+			name: "name too long",
+			modify: func(challenge []byte, resp *webauthn.RegistrationCredential) ([]byte, string) {
+				return challenge, string(make([]byte, PASSKEY_NAME_MAX+1))
+			},
+			err: &cuserr.InvalidInput{},
+		},
+		{
+			name: "wrong challenge",
+			modify: func(challenge []byte, resp *webauthn.RegistrationCredential) ([]byte, string) {
+				other, _ := webauthn.NewChallenge()
+				return other, "Phone"
+			},
+			err: &cuserr.InvalidCred{},
+		},
+		{
+			name: "wrong origin",
+			modify: func(challenge []byte, resp *webauthn.RegistrationCredential) ([]byte, string) {
+				other := webauthntest.New("https://evil.example")
+				opts := passkeyTestRelyingParty().CreationOptions(challenge, userHandle(userId), "passkey", nil)
+				forged, err := other.Create(opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				*resp = *forged
+				return challenge, "Phone"
+			},
+			err: &cuserr.InvalidCred{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge, _ := webauthn.NewChallenge()
+			resp, err := webauthntest.New(passkeyTestOrigin).Create(rp.CreationOptions(challenge, userHandle(userId), "passkey", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			challenge, name := tt.modify(challenge, resp)
+			err = u.RegisterPasskey(rp, userId, challenge, resp, name, now)
+			if err == nil {
+				t.Fatal("expected an error but didnt get one")
+			}
+			if !errors.As(err, tt.err) {
+				t.Errorf("expected %T, got %v", tt.err, err)
+			}
+		})
+	}
+
+	passkeys, err = u.Passkeys(userId)
+	if err != nil || len(passkeys) != 2 {
+		t.Errorf("expected failed registrations to save nothing, got %d %v", len(passkeys), err)
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db}
+	userId, err := u.CreateUser("passkey", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherId, err := u.CreateUser("someoneelse", "password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := passkeyTestRelyingParty()
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	a := webauthntest.New(passkeyTestOrigin)
+	err = registerTestPasskey(t, u, rp, a, userId, "Laptop", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge, _ := webauthn.NewChallenge()
+	resp, err := a.Get(rp.RequestOptions(challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotId, gotName, err := u.PasskeyLogin(rp, challenge, resp, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotId != userId || gotName != "passkey" {
+		t.Errorf("expected user %d passkey, got %d %s", userId, gotId, gotName)
+	}
+	passkeys, err := u.Passkeys(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passkeys[0].SignCount != 1 || passkeys[0].LastUsedAt == nil || *passkeys[0].LastUsedAt != now.Unix() {
+		t.Errorf("expected the sign count and last use to be saved, got %+v", passkeys[0])
+	}
+
+	// Replaying the same signature has a sign count that didn't go up
+	_, _, err = u.PasskeyLogin(rp, challenge, resp, now)
+	if !errors.As(err, &cuserr.InvalidCred{}) {
+		t.Errorf("expected InvalidCred for a replay, got %v", err)
+	}
+
+	challenge, _ = webauthn.NewChallenge()
+	resp, err = a.Get(rp.RequestOptions(challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Response.UserHandle = userHandle(otherId)
+	_, _, err = u.PasskeyLogin(rp, challenge, resp, now)
+	if !errors.As(err, &cuserr.InvalidCred{}) {
+		t.Errorf("expected InvalidCred for another user's handle, got %v", err)
+	}
+
+	unknown := webauthntest.New(passkeyTestOrigin)
+	_, err = unknown.Create(rp.CreationOptions(challenge, userHandle(userId), "passkey", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = unknown.Get(rp.RequestOptions(challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = u.PasskeyLogin(rp, challenge, resp, now)
+	if !errors.As(err, &cuserr.InvalidCred{}) {
+		t.Errorf("expected InvalidCred for an unregistered passkey, got %v", err)
+	}
+
+	err = u.DeletePasskey(otherId, passkeys[0].Id)
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Errorf("expected NotFound deleting another user's passkey, got %v", err)
+	}
+	err = u.DeletePasskey(userId, passkeys[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, _ = webauthn.NewChallenge()
+	resp, err = a.Get(rp.RequestOptions(challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = u.PasskeyLogin(rp, challenge, resp, now)
+	if !errors.As(err, &cuserr.InvalidCred{}) {
+		t.Errorf("expected InvalidCred for a deleted passkey, got %v", err)
+	}
+}
+
+func TestPasskeyChallenge(t *testing.T) {
+	db, err := database.InitDb("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &UserLogic{DB: db}
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	start := func(ceremony string, userId int) string {
+		id, challenge, err := u.StartPasskeyChallenge(ceremony, userId, now, 5*time.Minute)
+		if err != nil || len(challenge) == 0 {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	id := start("login", 0)
+	challenge, err := u.UsePasskeyChallenge(id, "login", 0, now.Add(time.Minute))
+	if err != nil || len(challenge) == 0 {
+		t.Fatalf("first use: expected the challenge, got %v", err)
+	}
+	_, err = u.UsePasskeyChallenge(id, "login", 0, now.Add(time.Minute))
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Errorf("second use: expected NotFound, got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		ceremony string
+		userId   int
+		at       time.Time
+		expired  bool
+	}{
+		{name: "expired", ceremony: "register", userId: 1, at: now.Add(5 * time.Minute), expired: true},
+		{name: "other ceremony", ceremony: "login", userId: 1, at: now},
+		{name: "other user", ceremony: "register", userId: 2, at: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := start("register", 1)
+			_, err := u.UsePasskeyChallenge(id, tt.ceremony, tt.userId, tt.at)
+			if tt.expired && !errors.As(err, &cuserr.Expired{}) {
+				t.Fatalf("expected Expired, got %v", err)
+			}
+			if !tt.expired && !errors.As(err, &cuserr.NotFound{}) {
+				t.Fatalf("expected NotFound, got %v", err)
+			}
+			// A challenge that failed its checks is still used up
+			_, err = u.UsePasskeyChallenge(id, "register", 1, now)
+			if !errors.As(err, &cuserr.NotFound{}) {
+				t.Errorf("after a failed use: expected NotFound, got %v", err)
+			}
+		})
+	}
+	_, err = u.UsePasskeyChallenge("", "login", 0, now)
+	if !errors.As(err, &cuserr.NotFound{}) {
+		t.Errorf("no id: expected NotFound, got %v", err)
+	}
+}
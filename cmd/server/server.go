@@ -11,6 +11,8 @@ import (
 	"sync"
 	"time"
 	"wonk/app/config"
+	"wonk/app/notify"
+	"wonk/app/requestid"
 	"wonk/app/routes"
 	"wonk/app/secret"
 	"wonk/app/service"
@@ -50,6 +52,24 @@ func Run(ctx context.Context, getEnv func(string) string, _ io.Writer, args []st
 		return err
 	}
 
+	// Init Session Timeouts
+	sessionConfig, err := config.InitSession(getEnv)
+	if err != nil {
+		return err
+	}
+
+	// Init Passkey Relying Party
+	webauthnConfig, err := config.InitWebAuthn(getEnv)
+	if err != nil {
+		return err
+	}
+
+	// Init Single Sign-On, nil when not set up
+	oidcConfig, err := config.InitOIDC(getEnv)
+	if err != nil {
+		return err
+	}
+
 	// Init Db
 	db, err := database.InitDb(FILE_NAME, f.EnableTestDb)
 	if err != nil {
@@ -62,8 +82,18 @@ func Run(ctx context.Context, getEnv func(string) string, _ io.Writer, args []st
 		return err
 	}
 
+	// Init Email Sender
+	mail, err := config.InitMail(getEnv)
+	if err != nil {
+		return err
+	}
+	sender, err := notify.NewSender(mail, l)
+	if err != nil {
+		return err
+	}
+
 	// Init App Services
-	appServices, err := application.InitServices(secrets, l, businessService)
+	appServices, err := application.InitServices(secrets, sessionConfig, webauthnConfig, oidcConfig, mail, sender, l, businessService)
 	if err != nil {
 		return err
 	}
@@ -72,6 +102,10 @@ func Run(ctx context.Context, getEnv func(string) string, _ io.Writer, args []st
 	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
 	defer cancel()
 
+	// Start Notification Scheduler
+	scheduler := notify.InitScheduler(l, businessService.Finance, sender)
+	go scheduler.Run(ctx)
+
 	// Create Http Server
 	srv := NewServer(l, db, appServices)
 	httpServer := &http.Server{
@@ -109,5 +143,6 @@ func NewServer(l *slog.Logger, db database.Database, a *application.Service) htt
 	mux := http.NewServeMux()
 	routes.AddRoutes(mux, l, db, a)
 	var handler http.Handler = mux
+	handler = requestid.Middleware(handler)
 	return handler
 }
@@ -0,0 +1,3 @@
+-- Soft delete for buckets and transactions
+ALTER TABLE bucket ADD COLUMN deleted_at INTEGER;
+ALTER TABLE transaction_item ADD COLUMN deleted_at INTEGER;
@@ -0,0 +1,22 @@
+-- Audit Log Table, append-only
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	request_id STRING NOT NULL,
+	entity_type STRING NOT NULL,
+	entity_id INTEGER NOT NULL,
+	action STRING NOT NULL,
+	before_json STRING,
+	after_json STRING,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+CREATE INDEX IF NOT EXISTS audit_log_entity ON audit_log (entity_type, entity_id);
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
@@ -0,0 +1,14 @@
+-- Multi-currency transactions
+ALTER TABLE user ADD COLUMN base_currency STRING NOT NULL DEFAULT 'USD';
+ALTER TABLE transaction_item ADD COLUMN currency STRING NOT NULL DEFAULT 'USD';
+-- Exchange Rate Table, rate is units of base_currency per 1 unit of currency
+CREATE TABLE IF NOT EXISTS exchange_rate (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	currency STRING NOT NULL,
+	base_currency STRING NOT NULL,
+	rate REAL NOT NULL,
+	rate_date STRING NOT NULL,
+	UNIQUE (user_id, currency, base_currency, rate_date),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
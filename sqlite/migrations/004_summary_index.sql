@@ -0,0 +1,2 @@
+-- Index for the grouped bucket totals used by the summaries
+CREATE INDEX IF NOT EXISTS transaction_item_period ON transaction_item (user_id, year, month);
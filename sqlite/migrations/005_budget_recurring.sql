@@ -0,0 +1,25 @@
+-- Budgets and recurring items for the dashboard
+-- Budget Table, amount is the monthly limit of a bucket in the user's base currency
+CREATE TABLE IF NOT EXISTS budget (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	bucket_id INTEGER NOT NULL,
+	amount REAL NOT NULL,
+	UNIQUE (user_id, bucket_id),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+	FOREIGN KEY (bucket_id) REFERENCES bucket (id)
+);
+
+-- Recurring Item Table, repeats every month on day_of_month
+CREATE TABLE IF NOT EXISTS recurring_item (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	bucket_id INTEGER NOT NULL,
+	name STRING NOT NULL,
+	price REAL NOT NULL,
+	is_expense BOOLEAN NOT NULL,
+	currency STRING NOT NULL DEFAULT 'USD',
+	day_of_month INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+	FOREIGN KEY (bucket_id) REFERENCES bucket (id)
+);
@@ -0,0 +1,24 @@
+-- Savings goals
+-- Savings Goal Table, progress comes from bucket_id when set, otherwise from goal_contribution
+CREATE TABLE IF NOT EXISTS savings_goal (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name STRING NOT NULL,
+	target_amount REAL NOT NULL,
+	target_date STRING NOT NULL,
+	bucket_id INTEGER,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+	FOREIGN KEY (bucket_id) REFERENCES bucket (id)
+);
+
+-- Goal Contribution Table, a negative amount is a withdrawal
+CREATE TABLE IF NOT EXISTS goal_contribution (
+	id INTEGER PRIMARY KEY,
+	goal_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	amount REAL NOT NULL,
+	contributed_on STRING NOT NULL,
+	FOREIGN KEY (goal_id) REFERENCES savings_goal (id) ON DELETE CASCADE
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
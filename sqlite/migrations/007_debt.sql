@@ -0,0 +1,23 @@
+-- Debt payoff planner
+-- Debt Table, balance is what was owed when the debt was added
+CREATE TABLE IF NOT EXISTS debt (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name STRING NOT NULL,
+	balance REAL NOT NULL,
+	apr REAL NOT NULL,
+	min_payment REAL NOT NULL,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Debt Payment Table, links an expense to the debt it paid
+CREATE TABLE IF NOT EXISTS debt_payment (
+	id INTEGER PRIMARY KEY,
+	debt_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	transaction_id INTEGER NOT NULL UNIQUE,
+	FOREIGN KEY (debt_id) REFERENCES debt (id) ON DELETE CASCADE
+	FOREIGN KEY (user_id) REFERENCES user (id)
+	FOREIGN KEY (transaction_id) REFERENCES transaction_item (id)
+);
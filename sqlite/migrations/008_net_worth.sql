@@ -0,0 +1,24 @@
+-- Net worth snapshots
+-- Net Worth Account Table, kind is asset or liability and snapshots are in the account's currency
+CREATE TABLE IF NOT EXISTS net_worth_account (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name STRING NOT NULL,
+	kind STRING NOT NULL,
+	category STRING NOT NULL,
+	currency STRING NOT NULL DEFAULT 'USD',
+	UNIQUE (user_id, name),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Net Worth Snapshot Table, the balance of an account on a date
+CREATE TABLE IF NOT EXISTS net_worth_snapshot (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	account_id INTEGER NOT NULL,
+	balance REAL NOT NULL,
+	snapshot_date STRING NOT NULL,
+	UNIQUE (account_id, snapshot_date),
+	FOREIGN KEY (account_id) REFERENCES net_worth_account (id) ON DELETE CASCADE
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
@@ -0,0 +1,45 @@
+-- Investment holdings and price history
+-- Investment Account Table, trades and prices are in the account's currency
+CREATE TABLE IF NOT EXISTS investment_account (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name STRING NOT NULL,
+	currency STRING NOT NULL DEFAULT 'USD',
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Investment Trade Table, kind is buy, sell or dividend. A dividend's price is the total paid.
+CREATE TABLE IF NOT EXISTS investment_trade (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	account_id INTEGER NOT NULL,
+	symbol STRING NOT NULL,
+	kind STRING NOT NULL,
+	quantity REAL NOT NULL,
+	price REAL NOT NULL,
+	fees REAL NOT NULL DEFAULT 0,
+	trade_date STRING NOT NULL,
+	FOREIGN KEY (account_id) REFERENCES investment_account (id) ON DELETE CASCADE
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Security Table, the asset class of a symbol
+CREATE TABLE IF NOT EXISTS security (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	symbol STRING NOT NULL,
+	asset_class STRING NOT NULL,
+	UNIQUE (user_id, symbol),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Security Price Table, stored locally from manual entry or CSV imports
+CREATE TABLE IF NOT EXISTS security_price (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	symbol STRING NOT NULL,
+	price REAL NOT NULL,
+	price_date STRING NOT NULL,
+	UNIQUE (user_id, symbol, price_date),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
@@ -0,0 +1,27 @@
+-- Bills and due-date reminders
+-- Bill Table, an expense due every month on due_day
+CREATE TABLE IF NOT EXISTS bill (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	bucket_id INTEGER NOT NULL,
+	name STRING NOT NULL,
+	amount REAL NOT NULL,
+	currency STRING NOT NULL DEFAULT 'USD',
+	due_day INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+	FOREIGN KEY (bucket_id) REFERENCES bucket (id)
+);
+
+-- Bill Payment Table, a due date marked paid and the transaction created for it
+CREATE TABLE IF NOT EXISTS bill_payment (
+	id INTEGER PRIMARY KEY,
+	bill_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	due_date STRING NOT NULL,
+	transaction_id INTEGER NOT NULL UNIQUE,
+	UNIQUE (bill_id, due_date),
+	FOREIGN KEY (bill_id) REFERENCES bill (id) ON DELETE CASCADE
+	FOREIGN KEY (user_id) REFERENCES user (id)
+	FOREIGN KEY (transaction_id) REFERENCES transaction_item (id)
+);
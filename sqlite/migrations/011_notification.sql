@@ -0,0 +1,24 @@
+-- Email digest and alerts
+-- Notification Preference Table, the emails a user has asked for
+CREATE TABLE IF NOT EXISTS notification_preference (
+	user_id INTEGER PRIMARY KEY,
+	email STRING NOT NULL,
+	weekly_digest BOOLEAN NOT NULL DEFAULT 0,
+	digest_weekday INTEGER NOT NULL DEFAULT 1,
+	budget_alerts BOOLEAN NOT NULL DEFAULT 0,
+	bill_alerts BOOLEAN NOT NULL DEFAULT 0,
+	large_transaction REAL,
+	updated_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Notification Sent Table, digests and alerts already emailed so they aren't sent twice
+CREATE TABLE IF NOT EXISTS notification_sent (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	kind STRING NOT NULL,
+	dedupe_key STRING NOT NULL,
+	sent_at INTEGER NOT NULL,
+	UNIQUE (user_id, kind, dedupe_key),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
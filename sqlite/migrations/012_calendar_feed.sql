@@ -0,0 +1,8 @@
+-- Calendar feed
+-- Calendar Feed Table, the token protecting a user's .ics feed
+CREATE TABLE IF NOT EXISTS calendar_feed (
+	user_id INTEGER PRIMARY KEY,
+	token_hash STRING NOT NULL UNIQUE,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
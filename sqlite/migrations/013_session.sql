@@ -0,0 +1,11 @@
+-- Logout and session revocation
+-- Session Table, one row per login, the id is the jti of the session's jwt
+CREATE TABLE IF NOT EXISTS session (
+	id STRING PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	revoked_at INTEGER,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+CREATE INDEX IF NOT EXISTS session_user ON session (user_id);
@@ -0,0 +1,12 @@
+-- Sliding sessions with refresh tokens
+ALTER TABLE session ADD COLUMN last_seen_at INTEGER NOT NULL DEFAULT 0;
+
+-- Refresh Token Table, used tokens are kept until the session expires to catch reuse
+CREATE TABLE IF NOT EXISTS refresh_token (
+	token_hash STRING PRIMARY KEY,
+	session_id STRING NOT NULL,
+	created_at INTEGER NOT NULL,
+	used_at INTEGER,
+	FOREIGN KEY (session_id) REFERENCES session (id)
+);
+CREATE INDEX IF NOT EXISTS refresh_token_session ON refresh_token (session_id);
@@ -0,0 +1,20 @@
+-- TOTP two-factor authentication
+-- TOTP Table, one per user, enabled_at is set once enrollment is confirmed with a code
+CREATE TABLE IF NOT EXISTS totp (
+	user_id INTEGER PRIMARY KEY,
+	secret STRING NOT NULL,
+	enabled_at INTEGER,
+	last_counter INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Recovery Code Table, single use codes for a lost authenticator, only the hash is kept
+CREATE TABLE IF NOT EXISTS recovery_code (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	code_hash STRING NOT NULL,
+	used_at INTEGER,
+	UNIQUE (user_id, code_hash),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
@@ -0,0 +1,14 @@
+-- Passkey login
+-- Webauthn Credential Table, passkeys a user can log in with instead of a password
+CREATE TABLE IF NOT EXISTS webauthn_credential (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	credential_id STRING NOT NULL UNIQUE,
+	public_key BLOB NOT NULL,
+	sign_count INTEGER NOT NULL DEFAULT 0,
+	name STRING NOT NULL,
+	created_at INTEGER NOT NULL,
+	last_used_at INTEGER,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+CREATE INDEX IF NOT EXISTS webauthn_credential_user ON webauthn_credential (user_id);
@@ -0,0 +1,11 @@
+-- Password reset
+-- Password Reset Table, single use links to set a new password, only the token hash is kept
+CREATE TABLE IF NOT EXISTS password_reset (
+	token_hash STRING PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	used_at INTEGER,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+CREATE INDEX IF NOT EXISTS password_reset_user ON password_reset (user_id);
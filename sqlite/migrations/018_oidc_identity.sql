@@ -0,0 +1,14 @@
+-- OpenID Connect login
+-- OIDC Identity Table, accounts at the OpenID Connect provider linked to a user
+CREATE TABLE IF NOT EXISTS oidc_identity (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	issuer STRING NOT NULL,
+	subject STRING NOT NULL,
+	email STRING NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	last_used_at INTEGER,
+	UNIQUE (issuer, subject),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+CREATE INDEX IF NOT EXISTS oidc_identity_user ON oidc_identity (user_id);
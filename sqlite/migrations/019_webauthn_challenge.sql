@@ -0,0 +1,10 @@
+-- Single use passkey challenges
+-- Webauthn Challenge Table, challenges sent with passkey options, deleted when the response uses them
+CREATE TABLE IF NOT EXISTS webauthn_challenge (
+	id_hash STRING PRIMARY KEY,
+	challenge BLOB NOT NULL,
+	ceremony STRING NOT NULL,
+	user_id INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webauthn_challenge_expires ON webauthn_challenge (expires_at);
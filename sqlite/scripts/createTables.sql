@@ -2,7 +2,8 @@
 CREATE TABLE IF NOT EXISTS user (
 	id INTEGER PRIMARY KEY,
 	username STRING NOT NULL UNIQUE,
-	password STRING NOT NULL
+	password STRING NOT NULL,
+	base_currency STRING NOT NULL DEFAULT 'USD'
 );
 
 -- Bucket Table
@@ -10,6 +11,7 @@ CREATE TABLE IF NOT EXISTS bucket (
 	id INTEGER PRIMARY KEY,
 	name STRING NOT NULL,
 	user_id INTEGER NOT NULL,
+	deleted_at INTEGER,
 	FOREIGN KEY (user_id) REFERENCES users (id)
 );
 
@@ -23,7 +25,336 @@ CREATE TABLE IF NOT EXISTS transaction_item (
 	is_expense BOOLEAN NOT NULL,
 	user_id INTEGER NOT NULL,
 	bucket_id INTEGER NOT NULL,
+	deleted_at INTEGER,
+	currency STRING NOT NULL DEFAULT 'USD',
 	FOREIGN KEY (user_id) REFERENCES user (id)
 	FOREIGN KEY (bucket_id) REFERENCES bucket (id)
 );
 
+
+-- Audit Log Table, append-only
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	request_id STRING NOT NULL,
+	entity_type STRING NOT NULL,
+	entity_id INTEGER NOT NULL,
+	action STRING NOT NULL,
+	before_json STRING,
+	after_json STRING,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+CREATE INDEX IF NOT EXISTS audit_log_entity ON audit_log (entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS transaction_item_period ON transaction_item (user_id, year, month);
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+-- Exchange Rate Table, rate is units of base_currency per 1 unit of currency
+CREATE TABLE IF NOT EXISTS exchange_rate (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	currency STRING NOT NULL,
+	base_currency STRING NOT NULL,
+	rate REAL NOT NULL,
+	rate_date STRING NOT NULL,
+	UNIQUE (user_id, currency, base_currency, rate_date),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Budget Table, amount is the monthly limit of a bucket in the user's base currency
+CREATE TABLE IF NOT EXISTS budget (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	bucket_id INTEGER NOT NULL,
+	amount REAL NOT NULL,
+	UNIQUE (user_id, bucket_id),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+	FOREIGN KEY (bucket_id) REFERENCES bucket (id)
+);
+
+-- Recurring Item Table, repeats every month on day_of_month
+CREATE TABLE IF NOT EXISTS recurring_item (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	bucket_id INTEGER NOT NULL,
+	name STRING NOT NULL,
+	price REAL NOT NULL,
+	is_expense BOOLEAN NOT NULL,
+	currency STRING NOT NULL DEFAULT 'USD',
+	day_of_month INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+	FOREIGN KEY (bucket_id) REFERENCES bucket (id)
+);
+
+-- Savings Goal Table, progress comes from bucket_id when set, otherwise from goal_contribution
+CREATE TABLE IF NOT EXISTS savings_goal (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name STRING NOT NULL,
+	target_amount REAL NOT NULL,
+	target_date STRING NOT NULL,
+	bucket_id INTEGER,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+	FOREIGN KEY (bucket_id) REFERENCES bucket (id)
+);
+
+-- Goal Contribution Table, a negative amount is a withdrawal
+CREATE TABLE IF NOT EXISTS goal_contribution (
+	id INTEGER PRIMARY KEY,
+	goal_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	amount REAL NOT NULL,
+	contributed_on STRING NOT NULL,
+	FOREIGN KEY (goal_id) REFERENCES savings_goal (id) ON DELETE CASCADE
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Debt Table, balance is what was owed when the debt was added
+CREATE TABLE IF NOT EXISTS debt (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name STRING NOT NULL,
+	balance REAL NOT NULL,
+	apr REAL NOT NULL,
+	min_payment REAL NOT NULL,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Debt Payment Table, links an expense to the debt it paid
+CREATE TABLE IF NOT EXISTS debt_payment (
+	id INTEGER PRIMARY KEY,
+	debt_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	transaction_id INTEGER NOT NULL UNIQUE,
+	FOREIGN KEY (debt_id) REFERENCES debt (id) ON DELETE CASCADE
+	FOREIGN KEY (user_id) REFERENCES user (id)
+	FOREIGN KEY (transaction_id) REFERENCES transaction_item (id)
+);
+
+-- Net Worth Account Table, kind is asset or liability and snapshots are in the account's currency
+CREATE TABLE IF NOT EXISTS net_worth_account (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name STRING NOT NULL,
+	kind STRING NOT NULL,
+	category STRING NOT NULL,
+	currency STRING NOT NULL DEFAULT 'USD',
+	UNIQUE (user_id, name),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Net Worth Snapshot Table, the balance of an account on a date
+CREATE TABLE IF NOT EXISTS net_worth_snapshot (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	account_id INTEGER NOT NULL,
+	balance REAL NOT NULL,
+	snapshot_date STRING NOT NULL,
+	UNIQUE (account_id, snapshot_date),
+	FOREIGN KEY (account_id) REFERENCES net_worth_account (id) ON DELETE CASCADE
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Investment Account Table, trades and prices are in the account's currency
+CREATE TABLE IF NOT EXISTS investment_account (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name STRING NOT NULL,
+	currency STRING NOT NULL DEFAULT 'USD',
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Investment Trade Table, kind is buy, sell or dividend. A dividend's price is the total paid.
+CREATE TABLE IF NOT EXISTS investment_trade (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	account_id INTEGER NOT NULL,
+	symbol STRING NOT NULL,
+	kind STRING NOT NULL,
+	quantity REAL NOT NULL,
+	price REAL NOT NULL,
+	fees REAL NOT NULL DEFAULT 0,
+	trade_date STRING NOT NULL,
+	FOREIGN KEY (account_id) REFERENCES investment_account (id) ON DELETE CASCADE
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Security Table, the asset class of a symbol
+CREATE TABLE IF NOT EXISTS security (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	symbol STRING NOT NULL,
+	asset_class STRING NOT NULL,
+	UNIQUE (user_id, symbol),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Security Price Table, stored locally from manual entry or CSV imports
+CREATE TABLE IF NOT EXISTS security_price (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	symbol STRING NOT NULL,
+	price REAL NOT NULL,
+	price_date STRING NOT NULL,
+	UNIQUE (user_id, symbol, price_date),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Bill Table, an expense due every month on due_day
+CREATE TABLE IF NOT EXISTS bill (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	bucket_id INTEGER NOT NULL,
+	name STRING NOT NULL,
+	amount REAL NOT NULL,
+	currency STRING NOT NULL DEFAULT 'USD',
+	due_day INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+	FOREIGN KEY (bucket_id) REFERENCES bucket (id)
+);
+
+-- Bill Payment Table, a due date marked paid and the transaction created for it
+CREATE TABLE IF NOT EXISTS bill_payment (
+	id INTEGER PRIMARY KEY,
+	bill_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	due_date STRING NOT NULL,
+	transaction_id INTEGER NOT NULL UNIQUE,
+	UNIQUE (bill_id, due_date),
+	FOREIGN KEY (bill_id) REFERENCES bill (id) ON DELETE CASCADE
+	FOREIGN KEY (user_id) REFERENCES user (id)
+	FOREIGN KEY (transaction_id) REFERENCES transaction_item (id)
+);
+
+-- Notification Preference Table, the emails a user has asked for
+CREATE TABLE IF NOT EXISTS notification_preference (
+	user_id INTEGER PRIMARY KEY,
+	email STRING NOT NULL,
+	weekly_digest BOOLEAN NOT NULL DEFAULT 0,
+	digest_weekday INTEGER NOT NULL DEFAULT 1,
+	budget_alerts BOOLEAN NOT NULL DEFAULT 0,
+	bill_alerts BOOLEAN NOT NULL DEFAULT 0,
+	large_transaction REAL,
+	updated_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Notification Sent Table, digests and alerts already emailed so they aren't sent twice
+CREATE TABLE IF NOT EXISTS notification_sent (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	kind STRING NOT NULL,
+	dedupe_key STRING NOT NULL,
+	sent_at INTEGER NOT NULL,
+	UNIQUE (user_id, kind, dedupe_key),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Calendar Feed Table, the token protecting a user's .ics feed
+CREATE TABLE IF NOT EXISTS calendar_feed (
+	user_id INTEGER PRIMARY KEY,
+	token_hash STRING NOT NULL UNIQUE,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Session Table, one row per login, the id is the jti of the session's jwt
+CREATE TABLE IF NOT EXISTS session (
+	id STRING PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	revoked_at INTEGER,
+	last_seen_at INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+CREATE INDEX IF NOT EXISTS session_user ON session (user_id);
+
+-- Refresh Token Table, used tokens are kept until the session expires to catch reuse
+CREATE TABLE IF NOT EXISTS refresh_token (
+	token_hash STRING PRIMARY KEY,
+	session_id STRING NOT NULL,
+	created_at INTEGER NOT NULL,
+	used_at INTEGER,
+	FOREIGN KEY (session_id) REFERENCES session (id)
+);
+CREATE INDEX IF NOT EXISTS refresh_token_session ON refresh_token (session_id);
+
+-- TOTP Table, one per user, enabled_at is set once enrollment is confirmed with a code
+CREATE TABLE IF NOT EXISTS totp (
+	user_id INTEGER PRIMARY KEY,
+	secret STRING NOT NULL,
+	enabled_at INTEGER,
+	last_counter INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Recovery Code Table, single use codes for a lost authenticator, only the hash is kept
+CREATE TABLE IF NOT EXISTS recovery_code (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	code_hash STRING NOT NULL,
+	used_at INTEGER,
+	UNIQUE (user_id, code_hash),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+-- Webauthn Credential Table, passkeys a user can log in with instead of a password
+CREATE TABLE IF NOT EXISTS webauthn_credential (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	credential_id STRING NOT NULL UNIQUE,
+	public_key BLOB NOT NULL,
+	sign_count INTEGER NOT NULL DEFAULT 0,
+	name STRING NOT NULL,
+	created_at INTEGER NOT NULL,
+	last_used_at INTEGER,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+CREATE INDEX IF NOT EXISTS webauthn_credential_user ON webauthn_credential (user_id);
+
+-- Webauthn Challenge Table, challenges sent with passkey options, deleted when the response uses them
+CREATE TABLE IF NOT EXISTS webauthn_challenge (
+	id_hash STRING PRIMARY KEY,
+	challenge BLOB NOT NULL,
+	ceremony STRING NOT NULL,
+	user_id INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webauthn_challenge_expires ON webauthn_challenge (expires_at);
+
+-- Password Reset Table, single use links to set a new password, only the token hash is kept
+CREATE TABLE IF NOT EXISTS password_reset (
+	token_hash STRING PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	used_at INTEGER,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+CREATE INDEX IF NOT EXISTS password_reset_user ON password_reset (user_id);
+
+-- OIDC Identity Table, accounts at the OpenID Connect provider linked to a user
+CREATE TABLE IF NOT EXISTS oidc_identity (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	issuer STRING NOT NULL,
+	subject STRING NOT NULL,
+	email STRING NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	last_used_at INTEGER,
+	UNIQUE (issuer, subject),
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+CREATE INDEX IF NOT EXISTS oidc_identity_user ON oidc_identity (user_id);
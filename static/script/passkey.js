@@ -0,0 +1,92 @@
+// Runs the browser side of passkey registration and login. The server sends options as
+// json with bytes in base64url, the signed response goes back in the form's hidden
+// credential input and htmx posts the form on the passkey-ready event.
+
+function passkeyToBytes(value) {
+	const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+	const binary = atob(base64 + '='.repeat((4 - base64.length % 4) % 4));
+	return Uint8Array.from(binary, c => c.charCodeAt(0));
+}
+
+function passkeyFromBytes(buffer) {
+	if (!buffer) {
+		return null;
+	}
+	const binary = String.fromCharCode(...new Uint8Array(buffer));
+	return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+function passkeyShowError(form, msg) {
+	const errDiv = form.querySelector('.passkey-error');
+	if (errDiv) {
+		errDiv.textContent = msg;
+	}
+}
+
+async function passkeyOptions(url, form) {
+	const headers = {};
+	const csrfInput = form.querySelector('input[name="csrf_token"]');
+	if (csrfInput) {
+		headers['X-CSRF-Token'] = csrfInput.value;
+	}
+	const resp = await fetch(url, { method: 'POST', headers: headers, credentials: 'same-origin' });
+	if (!resp.ok) {
+		throw new Error(resp.status === 429 ? 'too many attempts, try again later' : 'could not start, try again');
+	}
+	return resp.json();
+}
+
+function passkeySubmit(form, credential, response) {
+	form.querySelector('input[name="credential"]').value = JSON.stringify({
+		id: credential.id,
+		rawId: passkeyFromBytes(credential.rawId),
+		type: credential.type,
+		response: response,
+	});
+	htmx.trigger(form, 'passkey-ready');
+}
+
+async function passkeyRegister(form) {
+	if (!window.PublicKeyCredential) {
+		passkeyShowError(form, 'this browser does not support passkeys');
+		return;
+	}
+	try {
+		const options = await passkeyOptions('/finance/passkeys/options', form);
+		options.challenge = passkeyToBytes(options.challenge);
+		options.user.id = passkeyToBytes(options.user.id);
+		options.excludeCredentials = (options.excludeCredentials || []).map(c => ({ ...c, id: passkeyToBytes(c.id) }));
+		const credential = await navigator.credentials.create({ publicKey: options });
+		passkeySubmit(form, credential, {
+			clientDataJSON: passkeyFromBytes(credential.response.clientDataJSON),
+			attestationObject: passkeyFromBytes(credential.response.attestationObject),
+		});
+	} catch (err) {
+		if (err.name === 'InvalidStateError') {
+			passkeyShowError(form, 'this device already has a passkey here');
+		} else {
+			passkeyShowError(form, err.name === 'Error' ? err.message : 'passkey was not created');
+		}
+	}
+}
+
+async function passkeyLogin(form) {
+	if (!window.PublicKeyCredential) {
+		passkeyShowError(form, 'this browser does not support passkeys');
+		return;
+	}
+	try {
+		const options = await passkeyOptions('/login/passkey/options', form);
+		options.challenge = passkeyToBytes(options.challenge);
+		options.allowCredentials = (options.allowCredentials || []).map(c => ({ ...c, id: passkeyToBytes(c.id) }));
+		const credential = await navigator.credentials.get({ publicKey: options });
+		passkeySubmit(form, credential, {
+			clientDataJSON: passkeyFromBytes(credential.response.clientDataJSON),
+			authenticatorData: passkeyFromBytes(credential.response.authenticatorData),
+			signature: passkeyFromBytes(credential.response.signature),
+			userHandle: passkeyFromBytes(credential.response.userHandle),
+		});
+	} catch (err) {
+		passkeyShowError(form, err.name === 'Error' ? err.message : 'passkey login was cancelled');
+	}
+}
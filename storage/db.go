@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"wonk/app/cuserr"
 
@@ -9,25 +10,189 @@ import (
 )
 
 const (
-	USER_TABLE_NAME              = "user"
-	BUCKETS_TABLE_NAME           = "bucket"
-	TRANSACTION_ITEMS_TABLE_NAME = "transaction_item"
+	USER_TABLE_NAME                = "user"
+	BUCKETS_TABLE_NAME             = "bucket"
+	TRANSACTION_ITEMS_TABLE_NAME   = "transaction_item"
+	AUDIT_LOG_TABLE_NAME           = "audit_log"
+	EXCHANGE_RATE_TABLE_NAME       = "exchange_rate"
+	BUDGET_TABLE_NAME              = "budget"
+	RECURRING_ITEM_TABLE_NAME      = "recurring_item"
+	SAVINGS_GOAL_TABLE_NAME        = "savings_goal"
+	GOAL_CONTRIBUTION_TABLE_NAME   = "goal_contribution"
+	DEBT_TABLE_NAME                = "debt"
+	DEBT_PAYMENT_TABLE_NAME        = "debt_payment"
+	NET_WORTH_ACCOUNT_TABLE_NAME   = "net_worth_account"
+	SNAPSHOT_TABLE_NAME            = "net_worth_snapshot"
+	INVESTMENT_ACCOUNT_TABLE_NAME  = "investment_account"
+	INVESTMENT_TRADE_TABLE_NAME    = "investment_trade"
+	SECURITY_TABLE_NAME            = "security"
+	SECURITY_PRICE_TABLE_NAME      = "security_price"
+	BILL_TABLE_NAME                = "bill"
+	BILL_PAYMENT_TABLE_NAME        = "bill_payment"
+	NOTIFICATION_PREF_TABLE_NAME   = "notification_preference"
+	NOTIFICATION_SENT_TABLE_NAME   = "notification_sent"
+	CALENDAR_FEED_TABLE_NAME       = "calendar_feed"
+	SESSION_TABLE_NAME             = "session"
+	REFRESH_TOKEN_TABLE_NAME       = "refresh_token"
+	TOTP_TABLE_NAME                = "totp"
+	RECOVERY_CODE_TABLE_NAME       = "recovery_code"
+	WEBAUTHN_CREDENTIAL_TABLE_NAME = "webauthn_credential"
+	WEBAUTHN_CHALLENGE_TABLE_NAME  = "webauthn_challenge"
+	PASSWORD_RESET_TABLE_NAME      = "password_reset"
+	OIDC_IDENTITY_TABLE_NAME       = "oidc_identity"
+)
+
+const (
+	USER_COLUMNS                = "id, username, password, base_currency"
+	BUCKET_COLUMNS              = "id, name, user_id, deleted_at"
+	TRANSACTION_ITEM_COLUMNS    = "id, name, month, year, price, is_expense, user_id, bucket_id, deleted_at, currency"
+	AUDIT_LOG_COLUMNS           = "id, user_id, request_id, entity_type, entity_id, action, before_json, after_json, created_at"
+	EXCHANGE_RATE_COLUMNS       = "id, user_id, currency, base_currency, rate, rate_date"
+	BUDGET_COLUMNS              = "id, user_id, bucket_id, amount"
+	RECURRING_ITEM_COLUMNS      = "id, user_id, bucket_id, name, price, is_expense, currency, day_of_month"
+	SAVINGS_GOAL_COLUMNS        = "id, user_id, name, target_amount, target_date, bucket_id, created_at"
+	GOAL_CONTRIBUTION_COLUMNS   = "id, goal_id, user_id, amount, contributed_on"
+	DEBT_COLUMNS                = "id, user_id, name, balance, apr, min_payment, created_at"
+	NET_WORTH_ACCOUNT_COLUMNS   = "id, user_id, name, kind, category, currency"
+	SNAPSHOT_COLUMNS            = "id, user_id, account_id, balance, snapshot_date"
+	INVESTMENT_ACCOUNT_COLUMNS  = "id, user_id, name, currency"
+	INVESTMENT_TRADE_COLUMNS    = "id, user_id, account_id, symbol, kind, quantity, price, fees, trade_date"
+	SECURITY_COLUMNS            = "id, user_id, symbol, asset_class"
+	SECURITY_PRICE_COLUMNS      = "id, user_id, symbol, price, price_date"
+	BILL_COLUMNS                = "id, user_id, bucket_id, name, amount, currency, due_day, created_at"
+	NOTIFICATION_PREF_COLUMNS   = "user_id, email, weekly_digest, digest_weekday, budget_alerts, bill_alerts, large_transaction, updated_at"
+	NOTIFICATION_SENT_COLUMNS   = "id, user_id, kind, dedupe_key, sent_at"
+	CALENDAR_FEED_COLUMNS       = "user_id, token_hash, created_at"
+	SESSION_COLUMNS             = "id, user_id, created_at, expires_at, revoked_at, last_seen_at"
+	REFRESH_TOKEN_COLUMNS       = "token_hash, session_id, created_at, used_at"
+	TOTP_COLUMNS                = "user_id, secret, enabled_at, last_counter, created_at"
+	RECOVERY_CODE_COLUMNS       = "id, user_id, code_hash, used_at"
+	WEBAUTHN_CREDENTIAL_COLUMNS = "id, user_id, credential_id, public_key, sign_count, name, created_at, last_used_at"
+	PASSWORD_RESET_COLUMNS      = "token_hash, user_id, created_at, expires_at, used_at"
+	OIDC_IDENTITY_COLUMNS       = "id, user_id, issuer, subject, email, created_at, last_used_at"
 )
 
 type Database interface {
 	CreateUser(string, string) (int, error)
-	CreateBucket(int, string) (int, error)
-	CreateItemTransaction(TransactionItemInput) (int, error)
+	CreateBucket(int, string, AuditInfo) (int, error)
+	CreateItemTransaction(TransactionItemInput, AuditInfo) (int, error)
 	UserBuckets(int) ([]Bucket, error)
 	UserByUserName(string) (*User, error)
+	UserById(int) (*User, error)
 	NumBuckets(int) (int, error)
 	TransactionsInBucket(int, int, int) ([]TransactionItem, error)
 	BucketById(int) (*Bucket, error)
-	BucketUpdateName(int, string) (int64, error)
+	BucketUpdateName(int, string, AuditInfo) (int64, error)
 	TransactionsPagination(int, int, string, bool, TransactionFilters) ([]TransactionItem, error)
 	TransactionById(int) (*TransactionItem, error)
-	TransactionUpdate(string, int, int, int, int, float64) (int64, error)
-	TransactionDelete(int) (int64, error)
+	TransactionUpdate(string, int, int, int, int, float64, string, AuditInfo) (int64, error)
+	TransactionDelete(int, int64, AuditInfo) (int64, error)
+	TransactionRestore(int, AuditInfo) (int64, error)
+	TransactionPurge(int, AuditInfo) (int64, error)
+	DeletedTransactionById(int) (*TransactionItem, error)
+	DeletedTransactions(int) ([]TransactionItem, error)
+	BucketDelete(int, int64, AuditInfo) (int64, error)
+	BucketRestore(int, AuditInfo) (int64, error)
+	BucketPurge(int, AuditInfo) (int64, error)
+	DeletedBucketById(int) (*Bucket, error)
+	DeletedBuckets(int) ([]Bucket, error)
+	CreateAuditEntry(AuditEntryInput) (int, error)
+	AuditEntries(int, string, int) ([]AuditEntry, error)
+	AuditEntriesSince(int, string, string, int64) ([]AuditEntry, error)
+	UserBaseCurrency(int) (string, error)
+	UserUpdateBaseCurrency(int, string) (int64, error)
+	UpsertExchangeRate(ExchangeRateInput) error
+	UpsertExchangeRates([]ExchangeRateInput) error
+	ExchangeRates(int, string) ([]ExchangeRate, error)
+	ExchangeRateDelete(int, int) (int64, error)
+	BucketMonthTotals(int, int, int, int, int) ([]BucketMonthTotal, error)
+	BucketMonthTotalsUpTo(int, int, int) ([]BucketMonthTotal, error)
+	UpsertBudget(BudgetInput) error
+	Budgets(int) ([]Budget, error)
+	BudgetDelete(int, int) (int64, error)
+	CreateRecurringItem(RecurringItemInput) (int, error)
+	RecurringItems(int) ([]RecurringItem, error)
+	RecurringItemDelete(int, int) (int64, error)
+	CreateSavingsGoal(SavingsGoalInput) (int, error)
+	SavingsGoals(int) ([]SavingsGoal, error)
+	SavingsGoalById(int, int) (*SavingsGoal, error)
+	SavingsGoalDelete(int, int) (int64, error)
+	CreateGoalContribution(GoalContributionInput) (int, error)
+	GoalContributions(int, int) ([]GoalContribution, error)
+	CreateDebt(DebtInput) (int, error)
+	Debts(int) ([]Debt, error)
+	DebtById(int, int) (*Debt, error)
+	DebtDelete(int, int) (int64, error)
+	CreateDebtPayment(DebtPaymentInput) (int, error)
+	DebtPayments(int) ([]DebtPayment, error)
+	DebtPaymentDelete(int, int) (int64, error)
+	DebtPaymentCandidates(int, int, int) ([]TransactionItem, error)
+	CreateNetWorthAccount(NetWorthAccountInput) (int, error)
+	NetWorthAccounts(int) ([]NetWorthAccount, error)
+	NetWorthAccountDelete(int, int) (int64, error)
+	UpsertSnapshot(SnapshotInput) error
+	UpsertSnapshots([]SnapshotInput) error
+	Snapshots(int) ([]Snapshot, error)
+	SnapshotDelete(int, int) (int64, error)
+	CreateInvestmentAccount(InvestmentAccountInput) (int, error)
+	InvestmentAccounts(int) ([]InvestmentAccount, error)
+	InvestmentAccountDelete(int, int) (int64, error)
+	CreateInvestmentTrade(InvestmentTradeInput) (int, error)
+	InvestmentTrades(int) ([]InvestmentTrade, error)
+	InvestmentTradeDelete(int, int) (int64, error)
+	UpsertSecurity(SecurityInput) error
+	Securities(int) ([]Security, error)
+	UpsertSecurityPrice(SecurityPriceInput) error
+	UpsertSecurityPrices([]SecurityPriceInput) error
+	SecurityPrices(int) ([]SecurityPrice, error)
+	SecurityPriceDelete(int, int) (int64, error)
+	CreateBill(BillInput) (int, error)
+	Bills(int) ([]Bill, error)
+	BillById(int, int) (*Bill, error)
+	BillDelete(int, int) (int64, error)
+	BillPayments(int) ([]BillPayment, error)
+	PayBill(BillPaymentInput, TransactionItemInput, AuditInfo) (int, error)
+	UpsertNotificationPreference(NotificationPreferenceInput) error
+	NotificationPreference(int) (*NotificationPreference, error)
+	NotificationPreferences() ([]NotificationPreference, error)
+	CreateNotificationsSent([]NotificationSentInput) error
+	NotificationsSent(int, int64) ([]NotificationSent, error)
+	UpsertCalendarFeed(CalendarFeedInput) error
+	CalendarFeed(int) (*CalendarFeed, error)
+	CalendarFeedByTokenHash(string) (*CalendarFeed, error)
+	CalendarFeedDelete(int) (int64, error)
+	CreateSession(SessionInput, RefreshTokenInput) error
+	SessionById(string) (*Session, error)
+	RefreshTokenByHash(string) (*RefreshToken, error)
+	RotateRefreshToken(string, int64, RefreshTokenInput) (int64, error)
+	SessionRevoke(string, int, int64) (int64, error)
+	UserSessionsRevoke(int, int64) (int64, error)
+	UserSessionsDeleteExpired(int, int64) (int64, error)
+	UpsertPendingTotp(TotpInput) (int64, error)
+	TotpByUserId(int) (*Totp, error)
+	EnableTotp(int, int64, int64, []string) (int64, error)
+	UseTotpCounter(int, int64) (int64, error)
+	UseRecoveryCode(int, string, int64) (int64, error)
+	RecoveryCodesLeft(int) (int, error)
+	TotpDelete(int) (int64, error)
+	CreateWebauthnCredential(WebauthnCredentialInput) (int, error)
+	WebauthnCredentials(int) ([]WebauthnCredential, error)
+	WebauthnCredentialByCredentialId(string) (*WebauthnCredential, error)
+	WebauthnCredentialUse(int, int64, int64, int64) (int64, error)
+	WebauthnCredentialDelete(int, int) (int64, error)
+	CreateWebauthnChallenge(WebauthnChallengeInput) error
+	UseWebauthnChallenge(string) (*WebauthnChallenge, error)
+	UserUpdatePassword(int, string) (int64, error)
+	UserOtherSessionsRevoke(int, string, int64) (int64, error)
+	CreatePasswordReset(PasswordResetInput) error
+	PasswordResetByTokenHash(string) (*PasswordReset, error)
+	UsePasswordReset(string, string, int64) (int, error)
+	CreateOidcIdentity(OidcIdentityInput) (int, error)
+	CreateOidcUser(string, string, OidcIdentityInput) (int, error)
+	OidcIdentities(int) ([]OidcIdentity, error)
+	OidcIdentityBySubject(string, string) (*OidcIdentity, error)
+	OidcIdentityUse(int, string, int64) (int64, error)
+	OidcIdentityDelete(int, int) (int64, error)
 	InitTablesForTesting() error
 }
 
@@ -48,6 +213,8 @@ func InitDb(serverFileName string, enableTestDb bool) (Database, error) {
 	db := &SqliteDb{Db: sqliteDb}
 
 	if enableTestDb {
+		// Every connection to :memory: is a new database
+		sqliteDb.SetMaxOpenConns(1)
 		err = db.InitTablesForTesting()
 		if err != nil {
 			return nil, fmt.Errorf("InitDb: set up tables: %w", err)
@@ -60,32 +227,147 @@ func InitDb(serverFileName string, enableTestDb bool) (Database, error) {
 // Creates the tables needed for the application
 // This is meant for TESTING purposes ONLY
 func (s *SqliteDb) InitTablesForTesting() error {
-	createUserTableQuery := `CREATE TABLE IF NOT EXISTS user (id INTEGER PRIMARY KEY, username STRING NOT NULL UNIQUE, password STRING NOT NULL);`
+	createUserTableQuery := `CREATE TABLE IF NOT EXISTS user (id INTEGER PRIMARY KEY, username STRING NOT NULL UNIQUE, password STRING NOT NULL, base_currency STRING NOT NULL DEFAULT 'USD');`
 	_, err := s.Db.Exec(createUserTableQuery)
 	if err != nil {
 		return fmt.Errorf("InitTablesForTesting: Exec: user: %w", err)
 	}
 
-	createBucketTableQuery := `CREATE TABLE IF NOT EXISTS bucket (id INTEGER PRIMARY KEY, name STRING NOT NULL, user_id INTEGER NOT NULL, FOREIGN KEY (user_id) REFERENCES users (id));`
+	createBucketTableQuery := `CREATE TABLE IF NOT EXISTS bucket (id INTEGER PRIMARY KEY, name STRING NOT NULL, user_id INTEGER NOT NULL, deleted_at INTEGER, FOREIGN KEY (user_id) REFERENCES users (id));`
 	_, err = s.Db.Exec(createBucketTableQuery)
 	if err != nil {
 		return fmt.Errorf("InitTablesForTesting: Exec: bucket: %w", err)
 	}
 
-	createTransactionTableQuery := `CREATE TABLE IF NOT EXISTS transaction_item (id INTEGER PRIMARY KEY, name STRING NOT NULL, month INTEGER NOT NULL, year INTEGER NOT NULL, price REAL NOT NULL, is_expense BOOLEAN NOT NULL, user_id INTEGER NOT NULL, bucket_id INTEGER NOT NULL, FOREIGN KEY (user_id) REFERENCES user (id) FOREIGN KEY (bucket_id) REFERENCES bucket (id));`
+	createTransactionTableQuery := `CREATE TABLE IF NOT EXISTS transaction_item (id INTEGER PRIMARY KEY, name STRING NOT NULL, month INTEGER NOT NULL, year INTEGER NOT NULL, price REAL NOT NULL, is_expense BOOLEAN NOT NULL, user_id INTEGER NOT NULL, bucket_id INTEGER NOT NULL, deleted_at INTEGER, currency STRING NOT NULL DEFAULT 'USD', FOREIGN KEY (user_id) REFERENCES user (id) FOREIGN KEY (bucket_id) REFERENCES bucket (id));
+	CREATE INDEX IF NOT EXISTS transaction_item_period ON transaction_item (user_id, year, month);`
 	_, err = s.Db.Exec(createTransactionTableQuery)
 	if err != nil {
 		return fmt.Errorf("InitTablesForTesting: Exec: transaction: %w", err)
 	}
+
+	createAuditLogTableQuery := `CREATE TABLE IF NOT EXISTS audit_log (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, request_id STRING NOT NULL, entity_type STRING NOT NULL, entity_id INTEGER NOT NULL, action STRING NOT NULL, before_json STRING, after_json STRING, created_at INTEGER NOT NULL, FOREIGN KEY (user_id) REFERENCES user (id));
+	CREATE INDEX IF NOT EXISTS audit_log_entity ON audit_log (entity_type, entity_id);
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;`
+	_, err = s.Db.Exec(createAuditLogTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: audit log: %w", err)
+	}
+
+	createExchangeRateTableQuery := `CREATE TABLE IF NOT EXISTS exchange_rate (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, currency STRING NOT NULL, base_currency STRING NOT NULL, rate REAL NOT NULL, rate_date STRING NOT NULL, UNIQUE (user_id, currency, base_currency, rate_date), FOREIGN KEY (user_id) REFERENCES user (id));`
+	_, err = s.Db.Exec(createExchangeRateTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: exchange rate: %w", err)
+	}
+
+	createBudgetTableQuery := `CREATE TABLE IF NOT EXISTS budget (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, bucket_id INTEGER NOT NULL, amount REAL NOT NULL, UNIQUE (user_id, bucket_id), FOREIGN KEY (user_id) REFERENCES user (id) FOREIGN KEY (bucket_id) REFERENCES bucket (id));`
+	_, err = s.Db.Exec(createBudgetTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: budget: %w", err)
+	}
+
+	createRecurringItemTableQuery := `CREATE TABLE IF NOT EXISTS recurring_item (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, bucket_id INTEGER NOT NULL, name STRING NOT NULL, price REAL NOT NULL, is_expense BOOLEAN NOT NULL, currency STRING NOT NULL DEFAULT 'USD', day_of_month INTEGER NOT NULL, FOREIGN KEY (user_id) REFERENCES user (id) FOREIGN KEY (bucket_id) REFERENCES bucket (id));`
+	_, err = s.Db.Exec(createRecurringItemTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: recurring item: %w", err)
+	}
+
+	createSavingsGoalTableQuery := `CREATE TABLE IF NOT EXISTS savings_goal (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, name STRING NOT NULL, target_amount REAL NOT NULL, target_date STRING NOT NULL, bucket_id INTEGER, created_at INTEGER NOT NULL, FOREIGN KEY (user_id) REFERENCES user (id) FOREIGN KEY (bucket_id) REFERENCES bucket (id));
+	CREATE TABLE IF NOT EXISTS goal_contribution (id INTEGER PRIMARY KEY, goal_id INTEGER NOT NULL, user_id INTEGER NOT NULL, amount REAL NOT NULL, contributed_on STRING NOT NULL, FOREIGN KEY (goal_id) REFERENCES savings_goal (id) ON DELETE CASCADE FOREIGN KEY (user_id) REFERENCES user (id));`
+	_, err = s.Db.Exec(createSavingsGoalTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: savings goal: %w", err)
+	}
+
+	createDebtTableQuery := `CREATE TABLE IF NOT EXISTS debt (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, name STRING NOT NULL, balance REAL NOT NULL, apr REAL NOT NULL, min_payment REAL NOT NULL, created_at INTEGER NOT NULL, FOREIGN KEY (user_id) REFERENCES user (id));
+	CREATE TABLE IF NOT EXISTS debt_payment (id INTEGER PRIMARY KEY, debt_id INTEGER NOT NULL, user_id INTEGER NOT NULL, transaction_id INTEGER NOT NULL UNIQUE, FOREIGN KEY (debt_id) REFERENCES debt (id) ON DELETE CASCADE FOREIGN KEY (user_id) REFERENCES user (id) FOREIGN KEY (transaction_id) REFERENCES transaction_item (id));`
+	_, err = s.Db.Exec(createDebtTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: debt: %w", err)
+	}
+
+	createNetWorthTableQuery := `CREATE TABLE IF NOT EXISTS net_worth_account (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, name STRING NOT NULL, kind STRING NOT NULL, category STRING NOT NULL, currency STRING NOT NULL DEFAULT 'USD', UNIQUE (user_id, name), FOREIGN KEY (user_id) REFERENCES user (id));
+	CREATE TABLE IF NOT EXISTS net_worth_snapshot (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, account_id INTEGER NOT NULL, balance REAL NOT NULL, snapshot_date STRING NOT NULL, UNIQUE (account_id, snapshot_date), FOREIGN KEY (account_id) REFERENCES net_worth_account (id) ON DELETE CASCADE FOREIGN KEY (user_id) REFERENCES user (id));`
+	_, err = s.Db.Exec(createNetWorthTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: net worth: %w", err)
+	}
+
+	createInvestmentTableQuery := `CREATE TABLE IF NOT EXISTS investment_account (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, name STRING NOT NULL, currency STRING NOT NULL DEFAULT 'USD', FOREIGN KEY (user_id) REFERENCES user (id));
+	CREATE TABLE IF NOT EXISTS investment_trade (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, account_id INTEGER NOT NULL, symbol STRING NOT NULL, kind STRING NOT NULL, quantity REAL NOT NULL, price REAL NOT NULL, fees REAL NOT NULL DEFAULT 0, trade_date STRING NOT NULL, FOREIGN KEY (account_id) REFERENCES investment_account (id) ON DELETE CASCADE FOREIGN KEY (user_id) REFERENCES user (id));
+	CREATE TABLE IF NOT EXISTS security (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, symbol STRING NOT NULL, asset_class STRING NOT NULL, UNIQUE (user_id, symbol), FOREIGN KEY (user_id) REFERENCES user (id));
+	CREATE TABLE IF NOT EXISTS security_price (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, symbol STRING NOT NULL, price REAL NOT NULL, price_date STRING NOT NULL, UNIQUE (user_id, symbol, price_date), FOREIGN KEY (user_id) REFERENCES user (id));`
+	_, err = s.Db.Exec(createInvestmentTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: investment: %w", err)
+	}
+
+	createBillTableQuery := `CREATE TABLE IF NOT EXISTS bill (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, bucket_id INTEGER NOT NULL, name STRING NOT NULL, amount REAL NOT NULL, currency STRING NOT NULL DEFAULT 'USD', due_day INTEGER NOT NULL, created_at INTEGER NOT NULL, FOREIGN KEY (user_id) REFERENCES user (id) FOREIGN KEY (bucket_id) REFERENCES bucket (id));
+	CREATE TABLE IF NOT EXISTS bill_payment (id INTEGER PRIMARY KEY, bill_id INTEGER NOT NULL, user_id INTEGER NOT NULL, due_date STRING NOT NULL, transaction_id INTEGER NOT NULL UNIQUE, UNIQUE (bill_id, due_date), FOREIGN KEY (bill_id) REFERENCES bill (id) ON DELETE CASCADE FOREIGN KEY (user_id) REFERENCES user (id) FOREIGN KEY (transaction_id) REFERENCES transaction_item (id));`
+	_, err = s.Db.Exec(createBillTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: bill: %w", err)
+	}
+
+	createNotificationTableQuery := `CREATE TABLE IF NOT EXISTS notification_preference (user_id INTEGER PRIMARY KEY, email STRING NOT NULL, weekly_digest BOOLEAN NOT NULL DEFAULT 0, digest_weekday INTEGER NOT NULL DEFAULT 1, budget_alerts BOOLEAN NOT NULL DEFAULT 0, bill_alerts BOOLEAN NOT NULL DEFAULT 0, large_transaction REAL, updated_at INTEGER NOT NULL, FOREIGN KEY (user_id) REFERENCES user (id));
+	CREATE TABLE IF NOT EXISTS notification_sent (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, kind STRING NOT NULL, dedupe_key STRING NOT NULL, sent_at INTEGER NOT NULL, UNIQUE (user_id, kind, dedupe_key), FOREIGN KEY (user_id) REFERENCES user (id));`
+	_, err = s.Db.Exec(createNotificationTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: notification: %w", err)
+	}
+	createCalendarFeedTableQuery := `CREATE TABLE IF NOT EXISTS calendar_feed (user_id INTEGER PRIMARY KEY, token_hash STRING NOT NULL UNIQUE, created_at INTEGER NOT NULL, FOREIGN KEY (user_id) REFERENCES user (id));`
+	_, err = s.Db.Exec(createCalendarFeedTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: calendar feed: %w", err)
+	}
+	createSessionTableQuery := `CREATE TABLE IF NOT EXISTS session (id STRING PRIMARY KEY, user_id INTEGER NOT NULL, created_at INTEGER NOT NULL, expires_at INTEGER NOT NULL, revoked_at INTEGER, last_seen_at INTEGER NOT NULL DEFAULT 0, FOREIGN KEY (user_id) REFERENCES user (id));
+	CREATE INDEX IF NOT EXISTS session_user ON session (user_id);
+	CREATE TABLE IF NOT EXISTS refresh_token (token_hash STRING PRIMARY KEY, session_id STRING NOT NULL, created_at INTEGER NOT NULL, used_at INTEGER, FOREIGN KEY (session_id) REFERENCES session (id));
+	CREATE INDEX IF NOT EXISTS refresh_token_session ON refresh_token (session_id);`
+	_, err = s.Db.Exec(createSessionTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: session: %w", err)
+	}
+	createTotpTableQuery := `CREATE TABLE IF NOT EXISTS totp (user_id INTEGER PRIMARY KEY, secret STRING NOT NULL, enabled_at INTEGER, last_counter INTEGER NOT NULL DEFAULT 0, created_at INTEGER NOT NULL, FOREIGN KEY (user_id) REFERENCES user (id));
+	CREATE TABLE IF NOT EXISTS recovery_code (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, code_hash STRING NOT NULL, used_at INTEGER, UNIQUE (user_id, code_hash), FOREIGN KEY (user_id) REFERENCES user (id));`
+	_, err = s.Db.Exec(createTotpTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: totp: %w", err)
+	}
+	createWebauthnTableQuery := `CREATE TABLE IF NOT EXISTS webauthn_credential (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, credential_id STRING NOT NULL UNIQUE, public_key BLOB NOT NULL, sign_count INTEGER NOT NULL DEFAULT 0, name STRING NOT NULL, created_at INTEGER NOT NULL, last_used_at INTEGER, FOREIGN KEY (user_id) REFERENCES user (id));
+	CREATE INDEX IF NOT EXISTS webauthn_credential_user ON webauthn_credential (user_id);`
+	_, err = s.Db.Exec(createWebauthnTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: webauthn credential: %w", err)
+	}
+	createWebauthnChallengeTableQuery := `CREATE TABLE IF NOT EXISTS webauthn_challenge (id_hash STRING PRIMARY KEY, challenge BLOB NOT NULL, ceremony STRING NOT NULL, user_id INTEGER NOT NULL, expires_at INTEGER NOT NULL);
+	CREATE INDEX IF NOT EXISTS webauthn_challenge_expires ON webauthn_challenge (expires_at);`
+	_, err = s.Db.Exec(createWebauthnChallengeTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: webauthn challenge: %w", err)
+	}
+	createPasswordResetTableQuery := `CREATE TABLE IF NOT EXISTS password_reset (token_hash STRING PRIMARY KEY, user_id INTEGER NOT NULL, created_at INTEGER NOT NULL, expires_at INTEGER NOT NULL, used_at INTEGER, FOREIGN KEY (user_id) REFERENCES user (id));
+	CREATE INDEX IF NOT EXISTS password_reset_user ON password_reset (user_id);`
+	_, err = s.Db.Exec(createPasswordResetTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: password reset: %w", err)
+	}
+	createOidcIdentityTableQuery := `CREATE TABLE IF NOT EXISTS oidc_identity (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, issuer STRING NOT NULL, subject STRING NOT NULL, email STRING NOT NULL DEFAULT '', created_at INTEGER NOT NULL, last_used_at INTEGER, UNIQUE (issuer, subject), FOREIGN KEY (user_id) REFERENCES user (id));
+	CREATE INDEX IF NOT EXISTS oidc_identity_user ON oidc_identity (user_id);`
+	_, err = s.Db.Exec(createOidcIdentityTableQuery)
+	if err != nil {
+		return fmt.Errorf("InitTablesForTesting: Exec: oidc identity: %w", err)
+	}
 	return nil
 }
 
 func (s *SqliteDb) UserByUserName(username string) (*User, error) {
 	// User table has a unique constraint on username column
-	query := "SELECT * FROM " + USER_TABLE_NAME + " WHERE username=?"
+	query := "SELECT " + USER_COLUMNS + " FROM " + USER_TABLE_NAME + " WHERE username=?"
 	row := s.Db.QueryRow(query, username)
 	curUser := User{}
-	err := row.Scan(&curUser.Id, &curUser.UserName, &curUser.Password)
+	err := row.Scan(&curUser.Id, &curUser.UserName, &curUser.Password, &curUser.BaseCurrency)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("UserByUserName: no rows: %w", cuserr.NotFound{Item: "username"})
@@ -96,6 +378,20 @@ func (s *SqliteDb) UserByUserName(username string) (*User, error) {
 
 }
 
+func (s *SqliteDb) UserById(userId int) (*User, error) {
+	query := "SELECT " + USER_COLUMNS + " FROM " + USER_TABLE_NAME + " WHERE id=?"
+	row := s.Db.QueryRow(query, userId)
+	curUser := User{}
+	err := row.Scan(&curUser.Id, &curUser.UserName, &curUser.Password, &curUser.BaseCurrency)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("UserById: no rows: %w", cuserr.NotFound{Item: "user"})
+		}
+		return nil, fmt.Errorf("UserById: %w", err)
+	}
+	return &curUser, nil
+}
+
 func (s *SqliteDb) CreateUser(username, hashedPassword string) (int, error) {
 	query := "INSERT INTO " + USER_TABLE_NAME + " (username, password) VALUES (?, ?);"
 	res, err := s.Db.Exec(query, username, hashedPassword)
@@ -109,33 +405,71 @@ func (s *SqliteDb) CreateUser(username, hashedPassword string) (int, error) {
 	return int(id), nil
 }
 
-func (s *SqliteDb) CreateBucket(userId int, bucketName string) (int, error) {
+func (s *SqliteDb) CreateBucket(userId int, bucketName string, audit AuditInfo) (int, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("CreateBucket: begin: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := "INSERT INTO " + BUCKETS_TABLE_NAME + " (name, user_id) VALUES (?, ?);"
-	res, err := s.Db.Exec(query, bucketName, userId)
+	res, err := tx.Exec(query, bucketName, userId)
 	if err != nil {
 		return 0, fmt.Errorf("CreateBucket: Exec: %w", err)
 	}
 	id, err := res.LastInsertId()
 	if err != nil {
-		fmt.Println("CreateBucket: insert Id: %w")
+		return 0, fmt.Errorf("CreateBucket: insert Id: %w", err)
+	}
+	err = auditBucketTx(tx, audit, int(id), AUDIT_ACTION_CREATE, nil)
+	if err != nil {
+		return 0, fmt.Errorf("CreateBucket: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("CreateBucket: commit: %w", err)
 	}
 	return int(id), nil
 }
 
-func (s *SqliteDb) CreateItemTransaction(input TransactionItemInput) (int, error) {
-	query := "INSERT INTO " + TRANSACTION_ITEMS_TABLE_NAME + " (name, month, year, price, is_expense, user_id, bucket_id) VALUES (?, ?, ?, ?, ?, ?, ?);"
-	res, err := s.Db.Exec(query, input.Name, input.Month, input.Year, input.Price, input.IsExpense, input.UserId, input.BucketId)
+func (s *SqliteDb) CreateItemTransaction(input TransactionItemInput, audit AuditInfo) (int, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("CreateItemTransaction: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	id, err := createItemTransactionTx(tx, input, audit)
+	if err != nil {
+		return 0, fmt.Errorf("CreateItemTransaction: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("CreateItemTransaction: commit: %w", err)
+	}
+	return id, nil
+}
+
+func createItemTransactionTx(tx *sql.Tx, input TransactionItemInput, audit AuditInfo) (int, error) {
+	query := "INSERT INTO " + TRANSACTION_ITEMS_TABLE_NAME + " (name, month, year, price, is_expense, user_id, bucket_id, currency) VALUES (?, ?, ?, ?, ?, ?, ?, ?);"
+	res, err := tx.Exec(query, input.Name, input.Month, input.Year, input.Price, input.IsExpense, input.UserId, input.BucketId, input.Currency)
 	if err != nil {
-		return 0, fmt.Errorf("CreateItemTransaction: Exec: %w", err)
+		return 0, fmt.Errorf("createItemTransactionTx: Exec: %w", err)
 	}
 	id, err := res.LastInsertId()
 	if err != nil {
-		fmt.Println("CreateItemTransaction: insert Id: %w")
+		return 0, fmt.Errorf("createItemTransactionTx: insert Id: %w", err)
+	}
+	err = auditTransactionTx(tx, audit, int(id), AUDIT_ACTION_CREATE, nil)
+	if err != nil {
+		return 0, fmt.Errorf("createItemTransactionTx: %w", err)
 	}
 	return int(id), nil
 }
 func (s *SqliteDb) UserBuckets(userId int) ([]Bucket, error) {
-	query := "SELECT * FROM " + BUCKETS_TABLE_NAME + " WHERE user_id=?"
+	query := "SELECT " + BUCKET_COLUMNS + " FROM " + BUCKETS_TABLE_NAME + " WHERE user_id=? AND deleted_at IS NULL"
 	rows, err := s.Db.Query(query, userId)
 	if err != nil {
 		return nil, fmt.Errorf("UserBuckets: Exec: %w", err)
@@ -144,12 +478,11 @@ func (s *SqliteDb) UserBuckets(userId int) ([]Bucket, error) {
 
 	var data []Bucket
 	for rows.Next() {
-		b := Bucket{}
-		err := rows.Scan(&b.Id, &b.Name, &b.UserId)
+		b, err := scanBucket(rows)
 		if err != nil {
 			return nil, fmt.Errorf("UserBuckets: rows next: %w", err)
 		}
-		data = append(data, b)
+		data = append(data, *b)
 	}
 
 	return data, nil
@@ -157,7 +490,7 @@ func (s *SqliteDb) UserBuckets(userId int) ([]Bucket, error) {
 
 func (s *SqliteDb) NumBuckets(userId int) (int, error) {
 	tempColName := "num"
-	lookupQuery := "SELECT COUNT(*) AS " + tempColName + " FROM " + BUCKETS_TABLE_NAME + " WHERE user_id=?"
+	lookupQuery := "SELECT COUNT(*) AS " + tempColName + " FROM " + BUCKETS_TABLE_NAME + " WHERE user_id=? AND deleted_at IS NULL"
 	row := s.Db.QueryRow(lookupQuery, userId)
 	numBuckets := struct{ Num int }{}
 	err := row.Scan(&numBuckets.Num)
@@ -169,7 +502,7 @@ func (s *SqliteDb) NumBuckets(userId int) (int, error) {
 }
 
 func (s *SqliteDb) TransactionsInBucket(bucketId, month, year int) ([]TransactionItem, error) {
-	query := "SELECT * FROM " + TRANSACTION_ITEMS_TABLE_NAME + " WHERE bucket_id=? AND month=? AND year=?"
+	query := "SELECT " + TRANSACTION_ITEM_COLUMNS + " FROM " + TRANSACTION_ITEMS_TABLE_NAME + " WHERE bucket_id=? AND month=? AND year=? AND deleted_at IS NULL"
 	rows, err := s.Db.Query(query, bucketId, month, year)
 	if err != nil {
 		return nil, fmt.Errorf("TransactionsInBucket: Exec: %w", err)
@@ -178,21 +511,19 @@ func (s *SqliteDb) TransactionsInBucket(bucketId, month, year int) ([]Transactio
 
 	var data []TransactionItem
 	for rows.Next() {
-		b := TransactionItem{}
-		err := rows.Scan(&b.Id, &b.Name, &b.Month, &b.Year, &b.Price, &b.IsExpense, &b.UserId, &b.BucketId)
+		t, err := scanTransactionItem(rows)
 		if err != nil {
 			return nil, fmt.Errorf("TransactionsInBucket: rows next: %w", err)
 		}
-		data = append(data, b)
+		data = append(data, *t)
 	}
 
 	return data, nil
 }
 func (s *SqliteDb) BucketById(bucketId int) (*Bucket, error) {
-	query := "SELECT * FROM " + BUCKETS_TABLE_NAME + " WHERE id=?"
+	query := "SELECT " + BUCKET_COLUMNS + " FROM " + BUCKETS_TABLE_NAME + " WHERE id=? AND deleted_at IS NULL"
 	row := s.Db.QueryRow(query, bucketId)
-	curBucket := Bucket{}
-	err := row.Scan(&curBucket.Id, &curBucket.Name, &curBucket.UserId)
+	curBucket, err := scanBucket(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("BucketById: %w", cuserr.NotFound{})
@@ -200,17 +531,45 @@ func (s *SqliteDb) BucketById(bucketId int) (*Bucket, error) {
 		return nil, fmt.Errorf("BucketById: %w", err)
 	}
 
-	return &curBucket, nil
+	return curBucket, nil
 }
 
-func (s *SqliteDb) BucketUpdateName(bucketId int, newName string) (int64, error) {
-	query := "UPDATE " + BUCKETS_TABLE_NAME + " SET name=? WHERE id=?"
-	result, err := s.Db.Exec(query, newName, bucketId)
+func (s *SqliteDb) BucketUpdateName(bucketId int, newName string, audit AuditInfo) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("BucketUpdateName: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := bucketByIdTx(tx, bucketId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("BucketUpdateName: before: %w", err)
+	}
+	query := "UPDATE " + BUCKETS_TABLE_NAME + " SET name=? WHERE id=? AND deleted_at IS NULL"
+	result, err := tx.Exec(query, newName, bucketId)
+	if err != nil {
+		return 0, fmt.Errorf("BucketUpdateName: %w", err)
+	}
+	rowsChanged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("BucketUpdateName: rows: %w", err)
+	}
+	if rowsChanged == 0 {
+		return 0, nil
+	}
+	err = auditBucketTx(tx, audit, bucketId, AUDIT_ACTION_UPDATE, before)
 	if err != nil {
 		return 0, fmt.Errorf("BucketUpdateName: %w", err)
 	}
 
-	return result.RowsAffected()
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("BucketUpdateName: commit: %w", err)
+	}
+	return rowsChanged, nil
 }
 
 func (s *SqliteDb) TransactionsPagination(page, pagesize int, sortBy string, isAscending bool, filters TransactionFilters) ([]TransactionItem, error) {
@@ -234,7 +593,7 @@ func (s *SqliteDb) TransactionsPagination(page, pagesize int, sortBy string, isA
 	// Query
 	queryValues := values
 	queryValues = append(queryValues, pagesize, offset)
-	query := "SELECT * FROM " + TRANSACTION_ITEMS_TABLE_NAME + " " + filter + " " + orderByQuery + " LIMIT ? OFFSET ?"
+	query := "SELECT " + TRANSACTION_ITEM_COLUMNS + " FROM " + TRANSACTION_ITEMS_TABLE_NAME + " " + filter + " " + orderByQuery + " LIMIT ? OFFSET ?"
 	rows, err := s.Db.Query(query, queryValues...)
 	if err != nil {
 		return nil, fmt.Errorf("TransactionsPagination: Exec: %w", err)
@@ -243,22 +602,20 @@ func (s *SqliteDb) TransactionsPagination(page, pagesize int, sortBy string, isA
 
 	var data []TransactionItem
 	for rows.Next() {
-		b := TransactionItem{}
-		err := rows.Scan(&b.Id, &b.Name, &b.Month, &b.Year, &b.Price, &b.IsExpense, &b.UserId, &b.BucketId)
+		t, err := scanTransactionItem(rows)
 		if err != nil {
 			return nil, fmt.Errorf("TransactionsPagination: rows next: %w", err)
 		}
-		data = append(data, b)
+		data = append(data, *t)
 	}
 
 	return data, nil
 }
 
 func (s *SqliteDb) TransactionById(transactionId int) (*TransactionItem, error) {
-	query := "SELECT * FROM " + TRANSACTION_ITEMS_TABLE_NAME + " WHERE id=?"
+	query := "SELECT " + TRANSACTION_ITEM_COLUMNS + " FROM " + TRANSACTION_ITEMS_TABLE_NAME + " WHERE id=? AND deleted_at IS NULL"
 	row := s.Db.QueryRow(query, transactionId)
-	t := TransactionItem{}
-	err := row.Scan(&t.Id, &t.Name, &t.Month, &t.Year, &t.Price, &t.IsExpense, &t.UserId, &t.BucketId)
+	t, err := scanTransactionItem(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("TransactionById: %w", cuserr.NotFound{})
@@ -266,25 +623,2244 @@ func (s *SqliteDb) TransactionById(transactionId int) (*TransactionItem, error)
 		return nil, fmt.Errorf("TransactionById: %w", err)
 	}
 
-	return &t, nil
+	return t, nil
 }
 
-func (s *SqliteDb) TransactionUpdate(name string, transactionId int, bucketId int, month int, year int, price float64) (int64, error) {
-	query := "UPDATE " + TRANSACTION_ITEMS_TABLE_NAME + " SET name=?, month=?, year=?, price=?, bucket_id=? WHERE id=?"
-	result, err := s.Db.Exec(query, name, month, year, price, bucketId, transactionId)
+func (s *SqliteDb) TransactionUpdate(name string, transactionId int, bucketId int, month int, year int, price float64, currency string, audit AuditInfo) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("TransactionUpdate: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := transactionByIdTx(tx, transactionId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("TransactionUpdate: before: %w", err)
+	}
+	query := "UPDATE " + TRANSACTION_ITEMS_TABLE_NAME + " SET name=?, month=?, year=?, price=?, bucket_id=?, currency=? WHERE id=? AND deleted_at IS NULL"
+	result, err := tx.Exec(query, name, month, year, price, bucketId, currency, transactionId)
+	if err != nil {
+		return 0, fmt.Errorf("TransactionUpdate: %w", err)
+	}
+	rowsChanged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("TransactionUpdate: rows: %w", err)
+	}
+	if rowsChanged == 0 {
+		return 0, nil
+	}
+	err = auditTransactionTx(tx, audit, transactionId, AUDIT_ACTION_UPDATE, before)
 	if err != nil {
 		return 0, fmt.Errorf("TransactionUpdate: %w", err)
 	}
 
-	return result.RowsAffected()
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("TransactionUpdate: commit: %w", err)
+	}
+	return rowsChanged, nil
 }
 
-func (s *SqliteDb) TransactionDelete(transactionId int) (int64, error) {
-	query := "DELETE FROM " + TRANSACTION_ITEMS_TABLE_NAME + " WHERE id=?"
-	result, err := s.Db.Exec(query, transactionId)
+// Soft deletes the transaction, the row is kept until it is purged
+func (s *SqliteDb) TransactionDelete(transactionId int, deletedAt int64, audit AuditInfo) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("TransactionDelete: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := transactionByIdTx(tx, transactionId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("TransactionDelete: before: %w", err)
+	}
+	query := "UPDATE " + TRANSACTION_ITEMS_TABLE_NAME + " SET deleted_at=? WHERE id=? AND deleted_at IS NULL"
+	result, err := tx.Exec(query, deletedAt, transactionId)
+	if err != nil {
+		return 0, fmt.Errorf("TransactionDelete: %w", err)
+	}
+	rowsChanged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("TransactionDelete: rows: %w", err)
+	}
+	if rowsChanged == 0 {
+		return 0, nil
+	}
+	err = auditTransactionTx(tx, audit, transactionId, AUDIT_ACTION_DELETE, before)
 	if err != nil {
 		return 0, fmt.Errorf("TransactionDelete: %w", err)
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("TransactionDelete: commit: %w", err)
+	}
+	return rowsChanged, nil
+}
+
+func (s *SqliteDb) TransactionRestore(transactionId int, audit AuditInfo) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("TransactionRestore: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := transactionByIdTx(tx, transactionId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("TransactionRestore: before: %w", err)
+	}
+	query := "UPDATE " + TRANSACTION_ITEMS_TABLE_NAME + " SET deleted_at=NULL WHERE id=? AND deleted_at IS NOT NULL"
+	result, err := tx.Exec(query, transactionId)
+	if err != nil {
+		return 0, fmt.Errorf("TransactionRestore: %w", err)
+	}
+	rowsChanged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("TransactionRestore: rows: %w", err)
+	}
+	if rowsChanged == 0 {
+		return 0, nil
+	}
+	err = auditTransactionTx(tx, audit, transactionId, AUDIT_ACTION_RESTORE, before)
+	if err != nil {
+		return 0, fmt.Errorf("TransactionRestore: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("TransactionRestore: commit: %w", err)
+	}
+	return rowsChanged, nil
+}
+
+// Permanently removes a soft deleted transaction
+func (s *SqliteDb) TransactionPurge(transactionId int, audit AuditInfo) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("TransactionPurge: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := transactionByIdTx(tx, transactionId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("TransactionPurge: before: %w", err)
+	}
+	query := "DELETE FROM " + TRANSACTION_ITEMS_TABLE_NAME + " WHERE id=? AND deleted_at IS NOT NULL"
+	result, err := tx.Exec(query, transactionId)
+	if err != nil {
+		return 0, fmt.Errorf("TransactionPurge: %w", err)
+	}
+	rowsChanged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("TransactionPurge: rows: %w", err)
+	}
+	if rowsChanged == 0 {
+		return 0, nil
+	}
+	err = auditTransactionTx(tx, audit, transactionId, AUDIT_ACTION_PURGE, before)
+	if err != nil {
+		return 0, fmt.Errorf("TransactionPurge: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("TransactionPurge: commit: %w", err)
+	}
+	return rowsChanged, nil
+}
+
+func (s *SqliteDb) DeletedTransactionById(transactionId int) (*TransactionItem, error) {
+	query := "SELECT " + TRANSACTION_ITEM_COLUMNS + " FROM " + TRANSACTION_ITEMS_TABLE_NAME + " WHERE id=? AND deleted_at IS NOT NULL"
+	row := s.Db.QueryRow(query, transactionId)
+	t, err := scanTransactionItem(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("DeletedTransactionById: %w", cuserr.NotFound{})
+		}
+		return nil, fmt.Errorf("DeletedTransactionById: %w", err)
+	}
+
+	return t, nil
+}
+
+func (s *SqliteDb) DeletedTransactions(userId int) ([]TransactionItem, error) {
+	query := "SELECT " + TRANSACTION_ITEM_COLUMNS + " FROM " + TRANSACTION_ITEMS_TABLE_NAME + " WHERE user_id=? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("DeletedTransactions: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []TransactionItem
+	for rows.Next() {
+		t, err := scanTransactionItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("DeletedTransactions: rows next: %w", err)
+		}
+		data = append(data, *t)
+	}
+
+	return data, nil
+}
+
+// Soft deletes the bucket, transactions in the bucket are hidden until the bucket is restored
+func (s *SqliteDb) BucketDelete(bucketId int, deletedAt int64, audit AuditInfo) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("BucketDelete: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := bucketByIdTx(tx, bucketId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("BucketDelete: before: %w", err)
+	}
+	query := "UPDATE " + BUCKETS_TABLE_NAME + " SET deleted_at=? WHERE id=? AND deleted_at IS NULL"
+	result, err := tx.Exec(query, deletedAt, bucketId)
+	if err != nil {
+		return 0, fmt.Errorf("BucketDelete: %w", err)
+	}
+	rowsChanged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("BucketDelete: rows: %w", err)
+	}
+	if rowsChanged == 0 {
+		return 0, nil
+	}
+	err = auditBucketTx(tx, audit, bucketId, AUDIT_ACTION_DELETE, before)
+	if err != nil {
+		return 0, fmt.Errorf("BucketDelete: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("BucketDelete: commit: %w", err)
+	}
+	return rowsChanged, nil
+}
+
+func (s *SqliteDb) BucketRestore(bucketId int, audit AuditInfo) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("BucketRestore: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := bucketByIdTx(tx, bucketId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("BucketRestore: before: %w", err)
+	}
+	query := "UPDATE " + BUCKETS_TABLE_NAME + " SET deleted_at=NULL WHERE id=? AND deleted_at IS NOT NULL"
+	result, err := tx.Exec(query, bucketId)
+	if err != nil {
+		return 0, fmt.Errorf("BucketRestore: %w", err)
+	}
+	rowsChanged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("BucketRestore: rows: %w", err)
+	}
+	if rowsChanged == 0 {
+		return 0, nil
+	}
+	err = auditBucketTx(tx, audit, bucketId, AUDIT_ACTION_RESTORE, before)
+	if err != nil {
+		return 0, fmt.Errorf("BucketRestore: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("BucketRestore: commit: %w", err)
+	}
+	return rowsChanged, nil
+}
+
+// Permanently removes a soft deleted bucket along with every transaction in it, deleted or not.
+// Every purged transaction gets its own audit entry.
+func (s *SqliteDb) BucketPurge(bucketId int, audit AuditInfo) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("BucketPurge: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := bucketByIdTx(tx, bucketId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("BucketPurge: before: %w", err)
+	}
+	bucketQuery := "DELETE FROM " + BUCKETS_TABLE_NAME + " WHERE id=? AND deleted_at IS NOT NULL"
+	result, err := tx.Exec(bucketQuery, bucketId)
+	if err != nil {
+		return 0, fmt.Errorf("BucketPurge: bucket: %w", err)
+	}
+	rowsChanged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("BucketPurge: rows: %w", err)
+	}
+	if rowsChanged == 0 {
+		return 0, nil
+	}
+
+	transactions, err := transactionsInBucketTx(tx, bucketId)
+	if err != nil {
+		return 0, fmt.Errorf("BucketPurge: %w", err)
+	}
+	transactionQuery := "DELETE FROM " + TRANSACTION_ITEMS_TABLE_NAME + " WHERE bucket_id=?"
+	_, err = tx.Exec(transactionQuery, bucketId)
+	if err != nil {
+		return 0, fmt.Errorf("BucketPurge: transactions: %w", err)
+	}
+	// Each transaction keeps a record of what it was, like purging it on its own
+	for _, t := range transactions {
+		err = auditTransactionTx(tx, audit, t.Id, AUDIT_ACTION_PURGE, &t)
+		if err != nil {
+			return 0, fmt.Errorf("BucketPurge: %w", err)
+		}
+	}
+	err = auditBucketTx(tx, audit, bucketId, AUDIT_ACTION_PURGE, before)
+	if err != nil {
+		return 0, fmt.Errorf("BucketPurge: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("BucketPurge: commit: %w", err)
+	}
+	return rowsChanged, nil
+}
+
+func (s *SqliteDb) DeletedBucketById(bucketId int) (*Bucket, error) {
+	query := "SELECT " + BUCKET_COLUMNS + " FROM " + BUCKETS_TABLE_NAME + " WHERE id=? AND deleted_at IS NOT NULL"
+	row := s.Db.QueryRow(query, bucketId)
+	b, err := scanBucket(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("DeletedBucketById: %w", cuserr.NotFound{})
+		}
+		return nil, fmt.Errorf("DeletedBucketById: %w", err)
+	}
+
+	return b, nil
+}
+
+func (s *SqliteDb) DeletedBuckets(userId int) ([]Bucket, error) {
+	query := "SELECT " + BUCKET_COLUMNS + " FROM " + BUCKETS_TABLE_NAME + " WHERE user_id=? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("DeletedBuckets: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []Bucket
+	for rows.Next() {
+		b, err := scanBucket(rows)
+		if err != nil {
+			return nil, fmt.Errorf("DeletedBuckets: rows next: %w", err)
+		}
+		data = append(data, *b)
+	}
+
+	return data, nil
+}
+
+// Audit entries are append only, there is no update or delete
+func (s *SqliteDb) CreateAuditEntry(input AuditEntryInput) (int, error) {
+	query := "INSERT INTO " + AUDIT_LOG_TABLE_NAME + " (user_id, request_id, entity_type, entity_id, action, before_json, after_json, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?);"
+	res, err := s.Db.Exec(query, input.UserId, input.RequestId, input.EntityType, input.EntityId, input.Action, input.BeforeJson, input.AfterJson, input.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("CreateAuditEntry: Exec: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateAuditEntry: insert Id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Reads a bucket whether or not it is deleted, for the audit snapshots of a change
+func bucketByIdTx(tx *sql.Tx, bucketId int) (*Bucket, error) {
+	query := "SELECT " + BUCKET_COLUMNS + " FROM " + BUCKETS_TABLE_NAME + " WHERE id=?"
+	return scanBucket(tx.QueryRow(query, bucketId))
+}
+
+// Reads a transaction whether or not it is deleted, for the audit snapshots of a change
+func transactionByIdTx(tx *sql.Tx, transactionId int) (*TransactionItem, error) {
+	query := "SELECT " + TRANSACTION_ITEM_COLUMNS + " FROM " + TRANSACTION_ITEMS_TABLE_NAME + " WHERE id=?"
+	return scanTransactionItem(tx.QueryRow(query, transactionId))
+}
+
+// Every transaction in the bucket whether or not it is deleted
+func transactionsInBucketTx(tx *sql.Tx, bucketId int) ([]TransactionItem, error) {
+	query := "SELECT " + TRANSACTION_ITEM_COLUMNS + " FROM " + TRANSACTION_ITEMS_TABLE_NAME + " WHERE bucket_id=?"
+	rows, err := tx.Query(query, bucketId)
+	if err != nil {
+		return nil, fmt.Errorf("transactionsInBucketTx: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []TransactionItem
+	for rows.Next() {
+		t, err := scanTransactionItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("transactionsInBucketTx: rows next: %w", err)
+		}
+		data = append(data, *t)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("transactionsInBucketTx: rows: %w", err)
+	}
+	return data, nil
+}
+
+// Saves the audit entry of a bucket change with the bucket as the change left it.
+// before is nil for creates, and after is nil once the bucket has been purged.
+func auditBucketTx(tx *sql.Tx, audit AuditInfo, bucketId int, action string, before *Bucket) error {
+	after, err := bucketByIdTx(tx, bucketId)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("auditBucketTx: after: %w", err)
+	}
+	return createAuditEntryTx(tx, audit, AUDIT_ENTITY_BUCKET, bucketId, action, before, after)
+}
+
+// Saves the audit entry of a transaction change with the transaction as the change left it.
+// before is nil for creates, and after is nil once the transaction has been purged.
+func auditTransactionTx(tx *sql.Tx, audit AuditInfo, transactionId int, action string, before *TransactionItem) error {
+	after, err := transactionByIdTx(tx, transactionId)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("auditTransactionTx: after: %w", err)
+	}
+	return createAuditEntryTx(tx, audit, AUDIT_ENTITY_TRANSACTION, transactionId, action, before, after)
+}
+
+func createAuditEntryTx(tx *sql.Tx, audit AuditInfo, entityType string, entityId int, action string, before, after any) error {
+	beforeJson, err := auditJson(before)
+	if err != nil {
+		return fmt.Errorf("createAuditEntryTx: before: %w", err)
+	}
+	afterJson, err := auditJson(after)
+	if err != nil {
+		return fmt.Errorf("createAuditEntryTx: after: %w", err)
+	}
+	query := "INSERT INTO " + AUDIT_LOG_TABLE_NAME + " (user_id, request_id, entity_type, entity_id, action, before_json, after_json, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?);"
+	_, err = tx.Exec(query, audit.UserId, audit.RequestId, entityType, entityId, action, beforeJson, afterJson, audit.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("createAuditEntryTx: Exec: %w", err)
+	}
+	return nil
+}
+
+func auditJson(v any) (*string, error) {
+	switch val := v.(type) {
+	case nil:
+		return nil, nil
+	case *TransactionItem:
+		if val == nil {
+			return nil, nil
+		}
+	case *Bucket:
+		if val == nil {
+			return nil, nil
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+// Returns the audit entries of an entity that belongs to the user, oldest first
+func (s *SqliteDb) AuditEntries(userId int, entityType string, entityId int) ([]AuditEntry, error) {
+	query := "SELECT " + AUDIT_LOG_COLUMNS + " FROM " + AUDIT_LOG_TABLE_NAME + " WHERE user_id=? AND entity_type=? AND entity_id=? ORDER BY created_at, id"
+	rows, err := s.Db.Query(query, userId, entityType, entityId)
+	if err != nil {
+		return nil, fmt.Errorf("AuditEntries: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []AuditEntry
+	for rows.Next() {
+		a := AuditEntry{}
+		err := rows.Scan(&a.Id, &a.UserId, &a.RequestId, &a.EntityType, &a.EntityId, &a.Action, &a.BeforeJson, &a.AfterJson, &a.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("AuditEntries: rows next: %w", err)
+		}
+		data = append(data, a)
+	}
+
+	return data, nil
+}
+
+// Returns the user's audit entries of a kind of entity and action made at or after since, oldest first
+func (s *SqliteDb) AuditEntriesSince(userId int, entityType, action string, since int64) ([]AuditEntry, error) {
+	query := "SELECT " + AUDIT_LOG_COLUMNS + " FROM " + AUDIT_LOG_TABLE_NAME + " WHERE user_id=? AND entity_type=? AND action=? AND created_at>=? ORDER BY created_at, id"
+	rows, err := s.Db.Query(query, userId, entityType, action, since)
+	if err != nil {
+		return nil, fmt.Errorf("AuditEntriesSince: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []AuditEntry
+	for rows.Next() {
+		a := AuditEntry{}
+		err := rows.Scan(&a.Id, &a.UserId, &a.RequestId, &a.EntityType, &a.EntityId, &a.Action, &a.BeforeJson, &a.AfterJson, &a.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("AuditEntriesSince: rows next: %w", err)
+		}
+		data = append(data, a)
+	}
+
+	return data, nil
+}
+
+func (s *SqliteDb) UserBaseCurrency(userId int) (string, error) {
+	query := "SELECT base_currency FROM " + USER_TABLE_NAME + " WHERE id=?"
+	row := s.Db.QueryRow(query, userId)
+	var currency string
+	err := row.Scan(&currency)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("UserBaseCurrency: %w", cuserr.NotFound{Item: "user"})
+		}
+		return "", fmt.Errorf("UserBaseCurrency: %w", err)
+	}
+	return currency, nil
+}
+
+func (s *SqliteDb) UserUpdatePassword(userId int, hashedPassword string) (int64, error) {
+	query := "UPDATE " + USER_TABLE_NAME + " SET password=? WHERE id=?"
+	result, err := s.Db.Exec(query, hashedPassword, userId)
+	if err != nil {
+		return 0, fmt.Errorf("UserUpdatePassword: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SqliteDb) UserUpdateBaseCurrency(userId int, currency string) (int64, error) {
+	query := "UPDATE " + USER_TABLE_NAME + " SET base_currency=? WHERE id=?"
+	result, err := s.Db.Exec(query, currency, userId)
+	if err != nil {
+		return 0, fmt.Errorf("UserUpdateBaseCurrency: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Inserts the rate, if a rate already exists for the same day it is replaced
+const upsertExchangeRateQuery = "INSERT INTO " + EXCHANGE_RATE_TABLE_NAME + " (user_id, currency, base_currency, rate, rate_date) VALUES (?, ?, ?, ?, ?) ON CONFLICT (user_id, currency, base_currency, rate_date) DO UPDATE SET rate=excluded.rate;"
+
+func (s *SqliteDb) UpsertExchangeRate(input ExchangeRateInput) error {
+	_, err := s.Db.Exec(upsertExchangeRateQuery, input.UserId, input.Currency, input.BaseCurrency, input.Rate, input.RateDate)
+	if err != nil {
+		return fmt.Errorf("UpsertExchangeRate: Exec: %w", err)
+	}
+	return nil
+}
+
+// Saves every rate or none of them
+func (s *SqliteDb) UpsertExchangeRates(inputs []ExchangeRateInput) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return fmt.Errorf("UpsertExchangeRates: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, input := range inputs {
+		_, err := tx.Exec(upsertExchangeRateQuery, input.UserId, input.Currency, input.BaseCurrency, input.Rate, input.RateDate)
+		if err != nil {
+			return fmt.Errorf("UpsertExchangeRates: Exec: %w", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("UpsertExchangeRates: commit: %w", err)
+	}
+	return nil
+}
+
+// Returns all of a user's rates into the base currency ordered by currency then date
+func (s *SqliteDb) ExchangeRates(userId int, baseCurrency string) ([]ExchangeRate, error) {
+	query := "SELECT " + EXCHANGE_RATE_COLUMNS + " FROM " + EXCHANGE_RATE_TABLE_NAME + " WHERE user_id=? AND base_currency=? ORDER BY currency, rate_date"
+	rows, err := s.Db.Query(query, userId, baseCurrency)
+	if err != nil {
+		return nil, fmt.Errorf("ExchangeRates: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []ExchangeRate
+	for rows.Next() {
+		e := ExchangeRate{}
+		err := rows.Scan(&e.Id, &e.UserId, &e.Currency, &e.BaseCurrency, &e.Rate, &e.RateDate)
+		if err != nil {
+			return nil, fmt.Errorf("ExchangeRates: rows next: %w", err)
+		}
+		data = append(data, e)
+	}
+
+	return data, nil
+}
+
+const bucketMonthTotalsSelect = "SELECT t.bucket_id, t.month, t.year, t.currency, SUM(CASE WHEN t.is_expense THEN -t.price ELSE t.price END)" +
+	" FROM " + TRANSACTION_ITEMS_TABLE_NAME + " t JOIN " + BUCKETS_TABLE_NAME + " b ON b.id = t.bucket_id" +
+	" WHERE t.user_id=? AND t.deleted_at IS NULL AND b.deleted_at IS NULL"
+
+const bucketMonthTotalsGroup = " GROUP BY t.bucket_id, t.year, t.month, t.currency ORDER BY t.year, t.month, t.bucket_id"
+
+// Sums the signed transaction prices per bucket, month and currency between the start and end months (inclusive).
+// Expenses are negative. Deleted transactions and transactions in deleted buckets are left out.
+func (s *SqliteDb) BucketMonthTotals(userId, startMonth, startYear, endMonth, endYear int) ([]BucketMonthTotal, error) {
+	query := bucketMonthTotalsSelect +
+		// Compares the columns directly so the (user_id, year, month) index can be used
+		" AND (t.year > ? OR (t.year = ? AND t.month >= ?))" +
+		" AND (t.year < ? OR (t.year = ? AND t.month <= ?))" +
+		bucketMonthTotalsGroup
+	data, err := s.queryBucketMonthTotals(query, userId, startYear, startYear, startMonth, endYear, endYear, endMonth)
+	if err != nil {
+		return nil, fmt.Errorf("BucketMonthTotals: %w", err)
+	}
+	return data, nil
+}
+
+// Same as BucketMonthTotals for every month up to and including the end month
+func (s *SqliteDb) BucketMonthTotalsUpTo(userId, endMonth, endYear int) ([]BucketMonthTotal, error) {
+	query := bucketMonthTotalsSelect +
+		" AND (t.year < ? OR (t.year = ? AND t.month <= ?))" +
+		bucketMonthTotalsGroup
+	data, err := s.queryBucketMonthTotals(query, userId, endYear, endYear, endMonth)
+	if err != nil {
+		return nil, fmt.Errorf("BucketMonthTotalsUpTo: %w", err)
+	}
+	return data, nil
+}
+
+func (s *SqliteDb) queryBucketMonthTotals(query string, args ...any) ([]BucketMonthTotal, error) {
+	rows, err := s.Db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []BucketMonthTotal
+	for rows.Next() {
+		b := BucketMonthTotal{}
+		err := rows.Scan(&b.BucketId, &b.Month, &b.Year, &b.Currency, &b.Total)
+		if err != nil {
+			return nil, fmt.Errorf("rows next: %w", err)
+		}
+		data = append(data, b)
+	}
+
+	return data, nil
+}
+
+func (s *SqliteDb) ExchangeRateDelete(rateId, userId int) (int64, error) {
+	query := "DELETE FROM " + EXCHANGE_RATE_TABLE_NAME + " WHERE id=? AND user_id=?"
+	result, err := s.Db.Exec(query, rateId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("ExchangeRateDelete: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBucket(row rowScanner) (*Bucket, error) {
+	b := Bucket{}
+	err := row.Scan(&b.Id, &b.Name, &b.UserId, &b.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func scanTransactionItem(row rowScanner) (*TransactionItem, error) {
+	t := TransactionItem{}
+	err := row.Scan(&t.Id, &t.Name, &t.Month, &t.Year, &t.Price, &t.IsExpense, &t.UserId, &t.BucketId, &t.DeletedAt, &t.Currency)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Sets the monthly budget of a bucket, replacing the previous amount
+func (s *SqliteDb) UpsertBudget(input BudgetInput) error {
+	query := "INSERT INTO " + BUDGET_TABLE_NAME + " (user_id, bucket_id, amount) VALUES (?, ?, ?) ON CONFLICT (user_id, bucket_id) DO UPDATE SET amount=excluded.amount;"
+	_, err := s.Db.Exec(query, input.UserId, input.BucketId, input.Amount)
+	if err != nil {
+		return fmt.Errorf("UpsertBudget: Exec: %w", err)
+	}
+	return nil
+}
+
+// Returns the user's budgets, budgets of deleted buckets are left out
+func (s *SqliteDb) Budgets(userId int) ([]Budget, error) {
+	query := "SELECT b.id, b.user_id, b.bucket_id, b.amount FROM " + BUDGET_TABLE_NAME + " b JOIN " + BUCKETS_TABLE_NAME + " k ON k.id = b.bucket_id WHERE b.user_id=? AND k.deleted_at IS NULL ORDER BY b.bucket_id"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("Budgets: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []Budget
+	for rows.Next() {
+		b := Budget{}
+		err := rows.Scan(&b.Id, &b.UserId, &b.BucketId, &b.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("Budgets: rows next: %w", err)
+		}
+		data = append(data, b)
+	}
+
+	return data, nil
+}
+
+func (s *SqliteDb) BudgetDelete(budgetId, userId int) (int64, error) {
+	query := "DELETE FROM " + BUDGET_TABLE_NAME + " WHERE id=? AND user_id=?"
+	result, err := s.Db.Exec(query, budgetId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("BudgetDelete: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SqliteDb) CreateRecurringItem(input RecurringItemInput) (int, error) {
+	query := "INSERT INTO " + RECURRING_ITEM_TABLE_NAME + " (user_id, bucket_id, name, price, is_expense, currency, day_of_month) VALUES (?, ?, ?, ?, ?, ?, ?);"
+	res, err := s.Db.Exec(query, input.UserId, input.BucketId, input.Name, input.Price, input.IsExpense, input.Currency, input.DayOfMonth)
+	if err != nil {
+		return 0, fmt.Errorf("CreateRecurringItem: Exec: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateRecurringItem: insert Id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Returns the user's recurring items, items of deleted buckets are left out
+func (s *SqliteDb) RecurringItems(userId int) ([]RecurringItem, error) {
+	query := "SELECT r.id, r.user_id, r.bucket_id, r.name, r.price, r.is_expense, r.currency, r.day_of_month FROM " + RECURRING_ITEM_TABLE_NAME + " r JOIN " + BUCKETS_TABLE_NAME + " k ON k.id = r.bucket_id WHERE r.user_id=? AND k.deleted_at IS NULL ORDER BY r.day_of_month, r.id"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("RecurringItems: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []RecurringItem
+	for rows.Next() {
+		r := RecurringItem{}
+		err := rows.Scan(&r.Id, &r.UserId, &r.BucketId, &r.Name, &r.Price, &r.IsExpense, &r.Currency, &r.DayOfMonth)
+		if err != nil {
+			return nil, fmt.Errorf("RecurringItems: rows next: %w", err)
+		}
+		data = append(data, r)
+	}
+
+	return data, nil
+}
+
+func (s *SqliteDb) RecurringItemDelete(itemId, userId int) (int64, error) {
+	query := "DELETE FROM " + RECURRING_ITEM_TABLE_NAME + " WHERE id=? AND user_id=?"
+	result, err := s.Db.Exec(query, itemId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("RecurringItemDelete: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func scanSavingsGoal(row rowScanner) (*SavingsGoal, error) {
+	g := SavingsGoal{}
+	err := row.Scan(&g.Id, &g.UserId, &g.Name, &g.TargetAmount, &g.TargetDate, &g.BucketId, &g.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func (s *SqliteDb) CreateSavingsGoal(input SavingsGoalInput) (int, error) {
+	query := "INSERT INTO " + SAVINGS_GOAL_TABLE_NAME + " (user_id, name, target_amount, target_date, bucket_id, created_at) VALUES (?, ?, ?, ?, ?, ?);"
+	res, err := s.Db.Exec(query, input.UserId, input.Name, input.TargetAmount, input.TargetDate, input.BucketId, input.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("CreateSavingsGoal: Exec: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateSavingsGoal: insert Id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Returns the user's goals ordered by target date
+func (s *SqliteDb) SavingsGoals(userId int) ([]SavingsGoal, error) {
+	query := "SELECT " + SAVINGS_GOAL_COLUMNS + " FROM " + SAVINGS_GOAL_TABLE_NAME + " WHERE user_id=? ORDER BY target_date, id"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("SavingsGoals: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []SavingsGoal
+	for rows.Next() {
+		g, err := scanSavingsGoal(rows)
+		if err != nil {
+			return nil, fmt.Errorf("SavingsGoals: rows next: %w", err)
+		}
+		data = append(data, *g)
+	}
+
+	return data, nil
+}
+
+func (s *SqliteDb) SavingsGoalById(goalId, userId int) (*SavingsGoal, error) {
+	query := "SELECT " + SAVINGS_GOAL_COLUMNS + " FROM " + SAVINGS_GOAL_TABLE_NAME + " WHERE id=? AND user_id=?"
+	row := s.Db.QueryRow(query, goalId, userId)
+	g, err := scanSavingsGoal(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("SavingsGoalById: %w", cuserr.NotFound{Item: "savings goal"})
+		}
+		return nil, fmt.Errorf("SavingsGoalById: %w", err)
+	}
+	return g, nil
+}
+
+// Deletes the goal and its contributions
+func (s *SqliteDb) SavingsGoalDelete(goalId, userId int) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("SavingsGoalDelete: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM "+GOAL_CONTRIBUTION_TABLE_NAME+" WHERE goal_id=? AND user_id=?", goalId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("SavingsGoalDelete: contributions: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM "+SAVINGS_GOAL_TABLE_NAME+" WHERE id=? AND user_id=?", goalId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("SavingsGoalDelete: goal: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("SavingsGoalDelete: rows: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("SavingsGoalDelete: commit: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+func (s *SqliteDb) CreateGoalContribution(input GoalContributionInput) (int, error) {
+	query := "INSERT INTO " + GOAL_CONTRIBUTION_TABLE_NAME + " (goal_id, user_id, amount, contributed_on) VALUES (?, ?, ?, ?);"
+	res, err := s.Db.Exec(query, input.GoalId, input.UserId, input.Amount, input.ContributedOn)
+	if err != nil {
+		return 0, fmt.Errorf("CreateGoalContribution: Exec: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateGoalContribution: insert Id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Returns the contributions to one of the user's goals ordered by date
+func (s *SqliteDb) GoalContributions(userId, goalId int) ([]GoalContribution, error) {
+	query := "SELECT " + GOAL_CONTRIBUTION_COLUMNS + " FROM " + GOAL_CONTRIBUTION_TABLE_NAME + " WHERE user_id=? AND goal_id=? ORDER BY contributed_on, id"
+	rows, err := s.Db.Query(query, userId, goalId)
+	if err != nil {
+		return nil, fmt.Errorf("GoalContributions: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []GoalContribution
+	for rows.Next() {
+		c := GoalContribution{}
+		err := rows.Scan(&c.Id, &c.GoalId, &c.UserId, &c.Amount, &c.ContributedOn)
+		if err != nil {
+			return nil, fmt.Errorf("GoalContributions: rows next: %w", err)
+		}
+		data = append(data, c)
+	}
+
+	return data, nil
+}
+
+func scanDebt(row rowScanner) (*Debt, error) {
+	d := Debt{}
+	err := row.Scan(&d.Id, &d.UserId, &d.Name, &d.Balance, &d.Apr, &d.MinPayment, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *SqliteDb) CreateDebt(input DebtInput) (int, error) {
+	query := "INSERT INTO " + DEBT_TABLE_NAME + " (user_id, name, balance, apr, min_payment, created_at) VALUES (?, ?, ?, ?, ?, ?);"
+	res, err := s.Db.Exec(query, input.UserId, input.Name, input.Balance, input.Apr, input.MinPayment, input.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("CreateDebt: Exec: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateDebt: insert Id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Returns the user's debts in the order they were added
+func (s *SqliteDb) Debts(userId int) ([]Debt, error) {
+	query := "SELECT " + DEBT_COLUMNS + " FROM " + DEBT_TABLE_NAME + " WHERE user_id=? ORDER BY id"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("Debts: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []Debt
+	for rows.Next() {
+		d, err := scanDebt(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Debts: rows next: %w", err)
+		}
+		data = append(data, *d)
+	}
+
+	return data, nil
+}
+
+func (s *SqliteDb) DebtById(debtId, userId int) (*Debt, error) {
+	query := "SELECT " + DEBT_COLUMNS + " FROM " + DEBT_TABLE_NAME + " WHERE id=? AND user_id=?"
+	row := s.Db.QueryRow(query, debtId, userId)
+	d, err := scanDebt(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("DebtById: %w", cuserr.NotFound{Item: "debt"})
+		}
+		return nil, fmt.Errorf("DebtById: %w", err)
+	}
+	return d, nil
+}
+
+// Deletes the debt and its payment links, the linked transactions are kept
+func (s *SqliteDb) DebtDelete(debtId, userId int) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("DebtDelete: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM "+DEBT_PAYMENT_TABLE_NAME+" WHERE debt_id=? AND user_id=?", debtId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("DebtDelete: payments: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM "+DEBT_TABLE_NAME+" WHERE id=? AND user_id=?", debtId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("DebtDelete: debt: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("DebtDelete: rows: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("DebtDelete: commit: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+func (s *SqliteDb) CreateDebtPayment(input DebtPaymentInput) (int, error) {
+	query := "INSERT INTO " + DEBT_PAYMENT_TABLE_NAME + " (debt_id, user_id, transaction_id) VALUES (?, ?, ?);"
+	res, err := s.Db.Exec(query, input.DebtId, input.UserId, input.TransactionId)
+	if err != nil {
+		return 0, fmt.Errorf("CreateDebtPayment: Exec: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateDebtPayment: insert Id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Returns every payment the user has linked with its transaction, newest first.
+// Links to deleted transactions are left out until the transaction is restored.
+func (s *SqliteDb) DebtPayments(userId int) ([]DebtPayment, error) {
+	query := "SELECT p.id, p.debt_id, p.user_id, t.id, t.name, t.month, t.year, t.price, t.is_expense, t.user_id, t.bucket_id, t.deleted_at, t.currency" +
+		" FROM " + DEBT_PAYMENT_TABLE_NAME + " p JOIN " + TRANSACTION_ITEMS_TABLE_NAME + " t ON t.id = p.transaction_id" +
+		" WHERE p.user_id=? AND t.deleted_at IS NULL ORDER BY t.year DESC, t.month DESC, t.id DESC"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("DebtPayments: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []DebtPayment
+	for rows.Next() {
+		p := DebtPayment{}
+		t := &p.Transaction
+		err := rows.Scan(&p.Id, &p.DebtId, &p.UserId, &t.Id, &t.Name, &t.Month, &t.Year, &t.Price, &t.IsExpense, &t.UserId, &t.BucketId, &t.DeletedAt, &t.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("DebtPayments: rows next: %w", err)
+		}
+		data = append(data, p)
+	}
+
+	return data, nil
+}
+
+func (s *SqliteDb) DebtPaymentDelete(paymentId, userId int) (int64, error) {
+	query := "DELETE FROM " + DEBT_PAYMENT_TABLE_NAME + " WHERE id=? AND user_id=?"
+	result, err := s.Db.Exec(query, paymentId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("DebtPaymentDelete: Exec: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Returns the user's expenses since the given month that aren't linked to a debt yet, newest first
+func (s *SqliteDb) DebtPaymentCandidates(userId, startMonth, startYear int) ([]TransactionItem, error) {
+	query := "SELECT " + TRANSACTION_ITEM_COLUMNS + " FROM " + TRANSACTION_ITEMS_TABLE_NAME +
+		" WHERE user_id=? AND is_expense=1 AND deleted_at IS NULL AND (year*12+month) >= ?" +
+		" AND id NOT IN (SELECT transaction_id FROM " + DEBT_PAYMENT_TABLE_NAME + " WHERE user_id=?)" +
+		" ORDER BY year DESC, month DESC, id DESC"
+	rows, err := s.Db.Query(query, userId, startYear*12+startMonth, userId)
+	if err != nil {
+		return nil, fmt.Errorf("DebtPaymentCandidates: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []TransactionItem
+	for rows.Next() {
+		t, err := scanTransactionItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("DebtPaymentCandidates: rows next: %w", err)
+		}
+		data = append(data, *t)
+	}
+
+	return data, nil
+}
+
+func (s *SqliteDb) CreateNetWorthAccount(input NetWorthAccountInput) (int, error) {
+	query := "INSERT INTO " + NET_WORTH_ACCOUNT_TABLE_NAME + " (user_id, name, kind, category, currency) VALUES (?, ?, ?, ?, ?);"
+	res, err := s.Db.Exec(query, input.UserId, input.Name, input.Kind, input.Category, input.Currency)
+	if err != nil {
+		return 0, fmt.Errorf("CreateNetWorthAccount: Exec: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateNetWorthAccount: insert Id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Returns the user's accounts, assets before liabilities
+func (s *SqliteDb) NetWorthAccounts(userId int) ([]NetWorthAccount, error) {
+	query := "SELECT " + NET_WORTH_ACCOUNT_COLUMNS + " FROM " + NET_WORTH_ACCOUNT_TABLE_NAME + " WHERE user_id=? ORDER BY kind, name"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("NetWorthAccounts: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []NetWorthAccount
+	for rows.Next() {
+		a := NetWorthAccount{}
+		err := rows.Scan(&a.Id, &a.UserId, &a.Name, &a.Kind, &a.Category, &a.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("NetWorthAccounts: rows next: %w", err)
+		}
+		data = append(data, a)
+	}
+
+	return data, nil
+}
+
+// Deletes the account and its snapshots
+func (s *SqliteDb) NetWorthAccountDelete(accountId, userId int) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("NetWorthAccountDelete: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM "+SNAPSHOT_TABLE_NAME+" WHERE account_id=? AND user_id=?", accountId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("NetWorthAccountDelete: snapshots: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM "+NET_WORTH_ACCOUNT_TABLE_NAME+" WHERE id=? AND user_id=?", accountId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("NetWorthAccountDelete: account: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("NetWorthAccountDelete: rows: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("NetWorthAccountDelete: commit: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+// A second snapshot of an account on the same date replaces the balance
+const upsertSnapshotQuery = "INSERT INTO " + SNAPSHOT_TABLE_NAME + " (user_id, account_id, balance, snapshot_date) VALUES (?, ?, ?, ?) ON CONFLICT (account_id, snapshot_date) DO UPDATE SET balance=excluded.balance;"
+
+func (s *SqliteDb) UpsertSnapshot(input SnapshotInput) error {
+	_, err := s.Db.Exec(upsertSnapshotQuery, input.UserId, input.AccountId, input.Balance, input.SnapshotDate)
+	if err != nil {
+		return fmt.Errorf("UpsertSnapshot: Exec: %w", err)
+	}
+	return nil
+}
+
+// Saves every snapshot or none of them
+func (s *SqliteDb) UpsertSnapshots(inputs []SnapshotInput) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return fmt.Errorf("UpsertSnapshots: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, input := range inputs {
+		_, err := tx.Exec(upsertSnapshotQuery, input.UserId, input.AccountId, input.Balance, input.SnapshotDate)
+		if err != nil {
+			return fmt.Errorf("UpsertSnapshots: Exec: %w", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("UpsertSnapshots: commit: %w", err)
+	}
+	return nil
+}
+
+// Returns all of the user's snapshots ordered by date
+func (s *SqliteDb) Snapshots(userId int) ([]Snapshot, error) {
+	query := "SELECT " + SNAPSHOT_COLUMNS + " FROM " + SNAPSHOT_TABLE_NAME + " WHERE user_id=? ORDER BY snapshot_date, id"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("Snapshots: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []Snapshot
+	for rows.Next() {
+		snap := Snapshot{}
+		err := rows.Scan(&snap.Id, &snap.UserId, &snap.AccountId, &snap.Balance, &snap.SnapshotDate)
+		if err != nil {
+			return nil, fmt.Errorf("Snapshots: rows next: %w", err)
+		}
+		data = append(data, snap)
+	}
+
+	return data, nil
+}
+
+func (s *SqliteDb) SnapshotDelete(snapshotId, userId int) (int64, error) {
+	query := "DELETE FROM " + SNAPSHOT_TABLE_NAME + " WHERE id=? AND user_id=?"
+	result, err := s.Db.Exec(query, snapshotId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("SnapshotDelete: Exec: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SqliteDb) CreateInvestmentAccount(input InvestmentAccountInput) (int, error) {
+	query := "INSERT INTO " + INVESTMENT_ACCOUNT_TABLE_NAME + " (user_id, name, currency) VALUES (?, ?, ?);"
+	res, err := s.Db.Exec(query, input.UserId, input.Name, input.Currency)
+	if err != nil {
+		return 0, fmt.Errorf("CreateInvestmentAccount: Exec: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateInvestmentAccount: insert Id: %w", err)
+	}
+	return int(id), nil
+}
+
+func (s *SqliteDb) InvestmentAccounts(userId int) ([]InvestmentAccount, error) {
+	query := "SELECT " + INVESTMENT_ACCOUNT_COLUMNS + " FROM " + INVESTMENT_ACCOUNT_TABLE_NAME + " WHERE user_id=? ORDER BY name, id"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("InvestmentAccounts: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []InvestmentAccount
+	for rows.Next() {
+		a := InvestmentAccount{}
+		err := rows.Scan(&a.Id, &a.UserId, &a.Name, &a.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("InvestmentAccounts: rows next: %w", err)
+		}
+		data = append(data, a)
+	}
+
+	return data, nil
+}
+
+// Deletes the account and its trades
+func (s *SqliteDb) InvestmentAccountDelete(accountId, userId int) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("InvestmentAccountDelete: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM "+INVESTMENT_TRADE_TABLE_NAME+" WHERE account_id=? AND user_id=?", accountId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("InvestmentAccountDelete: trades: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM "+INVESTMENT_ACCOUNT_TABLE_NAME+" WHERE id=? AND user_id=?", accountId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("InvestmentAccountDelete: account: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("InvestmentAccountDelete: rows: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("InvestmentAccountDelete: commit: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+func (s *SqliteDb) CreateInvestmentTrade(input InvestmentTradeInput) (int, error) {
+	query := "INSERT INTO " + INVESTMENT_TRADE_TABLE_NAME + " (user_id, account_id, symbol, kind, quantity, price, fees, trade_date) VALUES (?, ?, ?, ?, ?, ?, ?, ?);"
+	res, err := s.Db.Exec(query, input.UserId, input.AccountId, input.Symbol, input.Kind, input.Quantity, input.Price, input.Fees, input.TradeDate)
+	if err != nil {
+		return 0, fmt.Errorf("CreateInvestmentTrade: Exec: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateInvestmentTrade: insert Id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Returns every trade of the user in the order they happened, lots are matched in this order
+func (s *SqliteDb) InvestmentTrades(userId int) ([]InvestmentTrade, error) {
+	query := "SELECT " + INVESTMENT_TRADE_COLUMNS + " FROM " + INVESTMENT_TRADE_TABLE_NAME + " WHERE user_id=? ORDER BY trade_date, id"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("InvestmentTrades: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []InvestmentTrade
+	for rows.Next() {
+		t := InvestmentTrade{}
+		err := rows.Scan(&t.Id, &t.UserId, &t.AccountId, &t.Symbol, &t.Kind, &t.Quantity, &t.Price, &t.Fees, &t.TradeDate)
+		if err != nil {
+			return nil, fmt.Errorf("InvestmentTrades: rows next: %w", err)
+		}
+		data = append(data, t)
+	}
+
+	return data, nil
+}
+
+func (s *SqliteDb) InvestmentTradeDelete(tradeId, userId int) (int64, error) {
+	query := "DELETE FROM " + INVESTMENT_TRADE_TABLE_NAME + " WHERE id=? AND user_id=?"
+	result, err := s.Db.Exec(query, tradeId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("InvestmentTradeDelete: Exec: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SqliteDb) UpsertSecurity(input SecurityInput) error {
+	query := "INSERT INTO " + SECURITY_TABLE_NAME + " (user_id, symbol, asset_class) VALUES (?, ?, ?) ON CONFLICT (user_id, symbol) DO UPDATE SET asset_class=excluded.asset_class;"
+	_, err := s.Db.Exec(query, input.UserId, input.Symbol, input.AssetClass)
+	if err != nil {
+		return fmt.Errorf("UpsertSecurity: Exec: %w", err)
+	}
+	return nil
+}
+
+func (s *SqliteDb) Securities(userId int) ([]Security, error) {
+	query := "SELECT " + SECURITY_COLUMNS + " FROM " + SECURITY_TABLE_NAME + " WHERE user_id=? ORDER BY symbol"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("Securities: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []Security
+	for rows.Next() {
+		sec := Security{}
+		err := rows.Scan(&sec.Id, &sec.UserId, &sec.Symbol, &sec.AssetClass)
+		if err != nil {
+			return nil, fmt.Errorf("Securities: rows next: %w", err)
+		}
+		data = append(data, sec)
+	}
+
+	return data, nil
+}
+
+// A second price for a symbol on the same date replaces the first
+const upsertSecurityPriceQuery = "INSERT INTO " + SECURITY_PRICE_TABLE_NAME + " (user_id, symbol, price, price_date) VALUES (?, ?, ?, ?) ON CONFLICT (user_id, symbol, price_date) DO UPDATE SET price=excluded.price;"
+
+func (s *SqliteDb) UpsertSecurityPrice(input SecurityPriceInput) error {
+	_, err := s.Db.Exec(upsertSecurityPriceQuery, input.UserId, input.Symbol, input.Price, input.PriceDate)
+	if err != nil {
+		return fmt.Errorf("UpsertSecurityPrice: Exec: %w", err)
+	}
+	return nil
+}
+
+// Saves every price or none of them
+func (s *SqliteDb) UpsertSecurityPrices(inputs []SecurityPriceInput) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return fmt.Errorf("UpsertSecurityPrices: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, input := range inputs {
+		_, err := tx.Exec(upsertSecurityPriceQuery, input.UserId, input.Symbol, input.Price, input.PriceDate)
+		if err != nil {
+			return fmt.Errorf("UpsertSecurityPrices: Exec: %w", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("UpsertSecurityPrices: commit: %w", err)
+	}
+	return nil
+}
+
+// Returns the user's price history ordered by symbol then date
+func (s *SqliteDb) SecurityPrices(userId int) ([]SecurityPrice, error) {
+	query := "SELECT " + SECURITY_PRICE_COLUMNS + " FROM " + SECURITY_PRICE_TABLE_NAME + " WHERE user_id=? ORDER BY symbol, price_date"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("SecurityPrices: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []SecurityPrice
+	for rows.Next() {
+		p := SecurityPrice{}
+		err := rows.Scan(&p.Id, &p.UserId, &p.Symbol, &p.Price, &p.PriceDate)
+		if err != nil {
+			return nil, fmt.Errorf("SecurityPrices: rows next: %w", err)
+		}
+		data = append(data, p)
+	}
+
+	return data, nil
+}
+
+func (s *SqliteDb) SecurityPriceDelete(priceId, userId int) (int64, error) {
+	query := "DELETE FROM " + SECURITY_PRICE_TABLE_NAME + " WHERE id=? AND user_id=?"
+	result, err := s.Db.Exec(query, priceId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("SecurityPriceDelete: Exec: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func scanBill(row rowScanner) (*Bill, error) {
+	b := Bill{}
+	err := row.Scan(&b.Id, &b.UserId, &b.BucketId, &b.Name, &b.Amount, &b.Currency, &b.DueDay, &b.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (s *SqliteDb) CreateBill(input BillInput) (int, error) {
+	query := "INSERT INTO " + BILL_TABLE_NAME + " (user_id, bucket_id, name, amount, currency, due_day, created_at) VALUES (?, ?, ?, ?, ?, ?, ?);"
+	res, err := s.Db.Exec(query, input.UserId, input.BucketId, input.Name, input.Amount, input.Currency, input.DueDay, input.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("CreateBill: Exec: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateBill: insert Id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Returns the user's bills by the day of the month they're due, bills of deleted buckets are left out
+func (s *SqliteDb) Bills(userId int) ([]Bill, error) {
+	query := "SELECT b.id, b.user_id, b.bucket_id, b.name, b.amount, b.currency, b.due_day, b.created_at FROM " + BILL_TABLE_NAME + " b JOIN " + BUCKETS_TABLE_NAME + " k ON k.id = b.bucket_id WHERE b.user_id=? AND k.deleted_at IS NULL ORDER BY b.due_day, b.id"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("Bills: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []Bill
+	for rows.Next() {
+		b, err := scanBill(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Bills: rows next: %w", err)
+		}
+		data = append(data, *b)
+	}
+	return data, nil
+}
+
+func (s *SqliteDb) BillById(billId, userId int) (*Bill, error) {
+	query := "SELECT " + BILL_COLUMNS + " FROM " + BILL_TABLE_NAME + " WHERE id=? AND user_id=?"
+	row := s.Db.QueryRow(query, billId, userId)
+	b, err := scanBill(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("BillById: %w", cuserr.NotFound{Item: "bill"})
+		}
+		return nil, fmt.Errorf("BillById: %w", err)
+	}
+	return b, nil
+}
+
+// Removes the bill and its payment records, the transactions made when paying it are kept
+func (s *SqliteDb) BillDelete(billId, userId int) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("BillDelete: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM "+BILL_PAYMENT_TABLE_NAME+" WHERE bill_id=? AND user_id=?", billId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("BillDelete: payments: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM "+BILL_TABLE_NAME+" WHERE id=? AND user_id=?", billId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("BillDelete: bill: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("BillDelete: rows: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("BillDelete: commit: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+// Returns every due date the user has marked paid, oldest first. A due date whose transaction
+// was deleted counts as unpaid again.
+func (s *SqliteDb) BillPayments(userId int) ([]BillPayment, error) {
+	query := "SELECT p.id, p.bill_id, p.user_id, p.due_date, p.transaction_id FROM " + BILL_PAYMENT_TABLE_NAME + " p JOIN " + TRANSACTION_ITEMS_TABLE_NAME + " t ON t.id = p.transaction_id" +
+		" WHERE p.user_id=? AND t.deleted_at IS NULL ORDER BY p.due_date, p.id"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("BillPayments: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []BillPayment
+	for rows.Next() {
+		p := BillPayment{}
+		err := rows.Scan(&p.Id, &p.BillId, &p.UserId, &p.DueDate, &p.TransactionId)
+		if err != nil {
+			return nil, fmt.Errorf("BillPayments: rows next: %w", err)
+		}
+		data = append(data, p)
+	}
+	return data, nil
+}
+
+// Creates the transaction that paid the bill and records the due date as paid together,
+// returns the new transaction's id
+func (s *SqliteDb) PayBill(payment BillPaymentInput, transaction TransactionItemInput, audit AuditInfo) (int, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("PayBill: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The due date was paid before with a transaction that has since been deleted or purged
+	query := "DELETE FROM " + BILL_PAYMENT_TABLE_NAME + " WHERE bill_id=? AND due_date=?" +
+		" AND transaction_id NOT IN (SELECT id FROM " + TRANSACTION_ITEMS_TABLE_NAME + " WHERE deleted_at IS NULL)"
+	_, err = tx.Exec(query, payment.BillId, payment.DueDate)
+	if err != nil {
+		return 0, fmt.Errorf("PayBill: stale payment: %w", err)
+	}
+	transactionId, err := createItemTransactionTx(tx, transaction, audit)
+	if err != nil {
+		return 0, fmt.Errorf("PayBill: transaction: %w", err)
+	}
+	query = "INSERT INTO " + BILL_PAYMENT_TABLE_NAME + " (bill_id, user_id, due_date, transaction_id) VALUES (?, ?, ?, ?);"
+	_, err = tx.Exec(query, payment.BillId, payment.UserId, payment.DueDate, transactionId)
+	if err != nil {
+		return 0, fmt.Errorf("PayBill: payment: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("PayBill: commit: %w", err)
+	}
+	return transactionId, nil
+}
+
+func scanNotificationPreference(row rowScanner) (*NotificationPreference, error) {
+	p := NotificationPreference{}
+	err := row.Scan(&p.UserId, &p.Email, &p.WeeklyDigest, &p.DigestWeekday, &p.BudgetAlerts, &p.BillAlerts, &p.LargeTransaction, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// A user has at most one set of preferences, saving again replaces it
+func (s *SqliteDb) UpsertNotificationPreference(input NotificationPreferenceInput) error {
+	query := "INSERT INTO " + NOTIFICATION_PREF_TABLE_NAME + " (user_id, email, weekly_digest, digest_weekday, budget_alerts, bill_alerts, large_transaction, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)" +
+		" ON CONFLICT (user_id) DO UPDATE SET email=excluded.email, weekly_digest=excluded.weekly_digest, digest_weekday=excluded.digest_weekday," +
+		" budget_alerts=excluded.budget_alerts, bill_alerts=excluded.bill_alerts, large_transaction=excluded.large_transaction, updated_at=excluded.updated_at;"
+	_, err := s.Db.Exec(query, input.UserId, input.Email, input.WeeklyDigest, input.DigestWeekday, input.BudgetAlerts, input.BillAlerts, input.LargeTransaction, input.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("UpsertNotificationPreference: Exec: %w", err)
+	}
+	return nil
+}
+
+func (s *SqliteDb) NotificationPreference(userId int) (*NotificationPreference, error) {
+	query := "SELECT " + NOTIFICATION_PREF_COLUMNS + " FROM " + NOTIFICATION_PREF_TABLE_NAME + " WHERE user_id=?"
+	row := s.Db.QueryRow(query, userId)
+	p, err := scanNotificationPreference(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("NotificationPreference: %w", cuserr.NotFound{Item: "notification preference"})
+		}
+		return nil, fmt.Errorf("NotificationPreference: %w", err)
+	}
+	return p, nil
+}
+
+// Returns the preferences of every user with an email address and something turned on
+func (s *SqliteDb) NotificationPreferences() ([]NotificationPreference, error) {
+	query := "SELECT " + NOTIFICATION_PREF_COLUMNS + " FROM " + NOTIFICATION_PREF_TABLE_NAME +
+		" WHERE email != '' AND (weekly_digest OR budget_alerts OR bill_alerts OR large_transaction IS NOT NULL) ORDER BY user_id"
+	rows, err := s.Db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("NotificationPreferences: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []NotificationPreference
+	for rows.Next() {
+		p, err := scanNotificationPreference(rows)
+		if err != nil {
+			return nil, fmt.Errorf("NotificationPreferences: rows next: %w", err)
+		}
+		data = append(data, *p)
+	}
+	return data, nil
+}
+
+// Records notifications as sent, ones already recorded are skipped
+func (s *SqliteDb) CreateNotificationsSent(inputs []NotificationSentInput) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return fmt.Errorf("CreateNotificationsSent: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO " + NOTIFICATION_SENT_TABLE_NAME + " (user_id, kind, dedupe_key, sent_at) VALUES (?, ?, ?, ?) ON CONFLICT (user_id, kind, dedupe_key) DO NOTHING;"
+	for _, input := range inputs {
+		_, err := tx.Exec(query, input.UserId, input.Kind, input.DedupeKey, input.SentAt)
+		if err != nil {
+			return fmt.Errorf("CreateNotificationsSent: Exec: %w", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("CreateNotificationsSent: commit: %w", err)
+	}
+	return nil
+}
+
+// Returns the notifications sent to the user at or after since
+func (s *SqliteDb) NotificationsSent(userId int, since int64) ([]NotificationSent, error) {
+	query := "SELECT " + NOTIFICATION_SENT_COLUMNS + " FROM " + NOTIFICATION_SENT_TABLE_NAME + " WHERE user_id=? AND sent_at>=? ORDER BY sent_at, id"
+	rows, err := s.Db.Query(query, userId, since)
+	if err != nil {
+		return nil, fmt.Errorf("NotificationsSent: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []NotificationSent
+	for rows.Next() {
+		n := NotificationSent{}
+		err := rows.Scan(&n.Id, &n.UserId, &n.Kind, &n.DedupeKey, &n.SentAt)
+		if err != nil {
+			return nil, fmt.Errorf("NotificationsSent: rows next: %w", err)
+		}
+		data = append(data, n)
+	}
+	return data, nil
+}
+
+func scanCalendarFeed(row rowScanner) (*CalendarFeed, error) {
+	c := CalendarFeed{}
+	err := row.Scan(&c.UserId, &c.TokenHash, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// A user has at most one feed, saving again replaces the token so the old url stops working
+func (s *SqliteDb) UpsertCalendarFeed(input CalendarFeedInput) error {
+	query := "INSERT INTO " + CALENDAR_FEED_TABLE_NAME + " (user_id, token_hash, created_at) VALUES (?, ?, ?)" +
+		" ON CONFLICT (user_id) DO UPDATE SET token_hash=excluded.token_hash, created_at=excluded.created_at;"
+	_, err := s.Db.Exec(query, input.UserId, input.TokenHash, input.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("UpsertCalendarFeed: Exec: %w", err)
+	}
+	return nil
+}
+
+func (s *SqliteDb) CalendarFeed(userId int) (*CalendarFeed, error) {
+	query := "SELECT " + CALENDAR_FEED_COLUMNS + " FROM " + CALENDAR_FEED_TABLE_NAME + " WHERE user_id=?"
+	row := s.Db.QueryRow(query, userId)
+	c, err := scanCalendarFeed(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("CalendarFeed: %w", cuserr.NotFound{Item: "calendar feed"})
+		}
+		return nil, fmt.Errorf("CalendarFeed: %w", err)
+	}
+	return c, nil
+}
+
+func (s *SqliteDb) CalendarFeedByTokenHash(tokenHash string) (*CalendarFeed, error) {
+	query := "SELECT " + CALENDAR_FEED_COLUMNS + " FROM " + CALENDAR_FEED_TABLE_NAME + " WHERE token_hash=?"
+	row := s.Db.QueryRow(query, tokenHash)
+	c, err := scanCalendarFeed(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("CalendarFeedByTokenHash: %w", cuserr.NotFound{Item: "calendar feed"})
+		}
+		return nil, fmt.Errorf("CalendarFeedByTokenHash: %w", err)
+	}
+	return c, nil
+}
+
+func (s *SqliteDb) CalendarFeedDelete(userId int) (int64, error) {
+	query := "DELETE FROM " + CALENDAR_FEED_TABLE_NAME + " WHERE user_id=?"
+	result, err := s.Db.Exec(query, userId)
+	if err != nil {
+		return 0, fmt.Errorf("CalendarFeedDelete: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Saves the session with its first refresh token
+func (s *SqliteDb) CreateSession(input SessionInput, refresh RefreshTokenInput) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return fmt.Errorf("CreateSession: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO " + SESSION_TABLE_NAME + " (id, user_id, created_at, expires_at, last_seen_at) VALUES (?, ?, ?, ?, ?);"
+	_, err = tx.Exec(query, input.Id, input.UserId, input.CreatedAt, input.ExpiresAt, input.LastSeenAt)
+	if err != nil {
+		return fmt.Errorf("CreateSession: session: %w", err)
+	}
+	query = "INSERT INTO " + REFRESH_TOKEN_TABLE_NAME + " (token_hash, session_id, created_at) VALUES (?, ?, ?);"
+	_, err = tx.Exec(query, refresh.TokenHash, input.Id, refresh.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("CreateSession: refresh token: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("CreateSession: commit: %w", err)
+	}
+	return nil
+}
+
+func (s *SqliteDb) SessionById(sessionId string) (*Session, error) {
+	query := "SELECT " + SESSION_COLUMNS + " FROM " + SESSION_TABLE_NAME + " WHERE id=?"
+	row := s.Db.QueryRow(query, sessionId)
+	session := Session{}
+	err := row.Scan(&session.Id, &session.UserId, &session.CreatedAt, &session.ExpiresAt, &session.RevokedAt, &session.LastSeenAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("SessionById: %w", cuserr.NotFound{Item: "session"})
+		}
+		return nil, fmt.Errorf("SessionById: %w", err)
+	}
+	return &session, nil
+}
+
+// Scoped to the user so other users sessions can't be revoked
+func (s *SqliteDb) SessionRevoke(sessionId string, userId int, revokedAt int64) (int64, error) {
+	query := "UPDATE " + SESSION_TABLE_NAME + " SET revoked_at=? WHERE id=? AND user_id=? AND revoked_at IS NULL"
+	result, err := s.Db.Exec(query, revokedAt, sessionId, userId)
+	if err != nil {
+		return 0, fmt.Errorf("SessionRevoke: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SqliteDb) UserSessionsRevoke(userId int, revokedAt int64) (int64, error) {
+	query := "UPDATE " + SESSION_TABLE_NAME + " SET revoked_at=? WHERE user_id=? AND revoked_at IS NULL"
+	result, err := s.Db.Exec(query, revokedAt, userId)
+	if err != nil {
+		return 0, fmt.Errorf("UserSessionsRevoke: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Revokes every session but the one given, for when the user in it changes their password
+func (s *SqliteDb) UserOtherSessionsRevoke(userId int, keepSessionId string, revokedAt int64) (int64, error) {
+	query := "UPDATE " + SESSION_TABLE_NAME + " SET revoked_at=? WHERE user_id=? AND id<>? AND revoked_at IS NULL"
+	result, err := s.Db.Exec(query, revokedAt, userId, keepSessionId)
+	if err != nil {
+		return 0, fmt.Errorf("UserOtherSessionsRevoke: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Sessions past their expiry can't be used anymore so the rows, and their refresh tokens, are only clutter
+func (s *SqliteDb) UserSessionsDeleteExpired(userId int, now int64) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("UserSessionsDeleteExpired: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "DELETE FROM " + REFRESH_TOKEN_TABLE_NAME + " WHERE session_id IN (SELECT id FROM " + SESSION_TABLE_NAME + " WHERE user_id=? AND expires_at<=?)"
+	_, err = tx.Exec(query, userId, now)
+	if err != nil {
+		return 0, fmt.Errorf("UserSessionsDeleteExpired: refresh tokens: %w", err)
+	}
+	query = "DELETE FROM " + SESSION_TABLE_NAME + " WHERE user_id=? AND expires_at<=?"
+	result, err := tx.Exec(query, userId, now)
+	if err != nil {
+		return 0, fmt.Errorf("UserSessionsDeleteExpired: sessions: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("UserSessionsDeleteExpired: RowsAffected: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("UserSessionsDeleteExpired: commit: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+func (s *SqliteDb) RefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
+	query := "SELECT " + REFRESH_TOKEN_COLUMNS + " FROM " + REFRESH_TOKEN_TABLE_NAME + " WHERE token_hash=?"
+	row := s.Db.QueryRow(query, tokenHash)
+	token := RefreshToken{}
+	err := row.Scan(&token.TokenHash, &token.SessionId, &token.CreatedAt, &token.UsedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("RefreshTokenByHash: %w", cuserr.NotFound{Item: "refresh token"})
+		}
+		return nil, fmt.Errorf("RefreshTokenByHash: %w", err)
+	}
+	return &token, nil
+}
+
+// Marks the old token used, saves its replacement and updates the session's last_seen_at.
+// Returns 0 without changing anything when the old token was already used, so two
+// requests can't both rotate it.
+func (s *SqliteDb) RotateRefreshToken(oldHash string, usedAt int64, next RefreshTokenInput) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("RotateRefreshToken: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE " + REFRESH_TOKEN_TABLE_NAME + " SET used_at=? WHERE token_hash=? AND session_id=? AND used_at IS NULL"
+	result, err := tx.Exec(query, usedAt, oldHash, next.SessionId)
+	if err != nil {
+		return 0, fmt.Errorf("RotateRefreshToken: use: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("RotateRefreshToken: RowsAffected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return 0, nil
+	}
+	query = "INSERT INTO " + REFRESH_TOKEN_TABLE_NAME + " (token_hash, session_id, created_at) VALUES (?, ?, ?);"
+	_, err = tx.Exec(query, next.TokenHash, next.SessionId, next.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("RotateRefreshToken: insert: %w", err)
+	}
+	query = "UPDATE " + SESSION_TABLE_NAME + " SET last_seen_at=? WHERE id=?"
+	_, err = tx.Exec(query, usedAt, next.SessionId)
+	if err != nil {
+		return 0, fmt.Errorf("RotateRefreshToken: session: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("RotateRefreshToken: commit: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+// Starts or restarts an enrollment with a new secret. Does nothing when 2FA is already enabled,
+// it has to be disabled first.
+func (s *SqliteDb) UpsertPendingTotp(input TotpInput) (int64, error) {
+	query := "INSERT INTO " + TOTP_TABLE_NAME + " (user_id, secret, created_at) VALUES (?, ?, ?)" +
+		" ON CONFLICT (user_id) DO UPDATE SET secret=excluded.secret, created_at=excluded.created_at, last_counter=0 WHERE enabled_at IS NULL;"
+	result, err := s.Db.Exec(query, input.UserId, input.Secret, input.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("UpsertPendingTotp: Exec: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SqliteDb) TotpByUserId(userId int) (*Totp, error) {
+	query := "SELECT " + TOTP_COLUMNS + " FROM " + TOTP_TABLE_NAME + " WHERE user_id=?"
+	row := s.Db.QueryRow(query, userId)
+	t := Totp{}
+	err := row.Scan(&t.UserId, &t.Secret, &t.EnabledAt, &t.LastCounter, &t.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("TotpByUserId: %w", cuserr.NotFound{Item: "totp"})
+		}
+		return nil, fmt.Errorf("TotpByUserId: %w", err)
+	}
+	return &t, nil
+}
+
+// Turns on a pending enrollment and replaces any recovery codes. Returns 0 without
+// changing anything when there is no pending enrollment.
+func (s *SqliteDb) EnableTotp(userId int, counter int64, enabledAt int64, recoveryHashes []string) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("EnableTotp: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE " + TOTP_TABLE_NAME + " SET enabled_at=?, last_counter=? WHERE user_id=? AND enabled_at IS NULL"
+	result, err := tx.Exec(query, enabledAt, counter, userId)
+	if err != nil {
+		return 0, fmt.Errorf("EnableTotp: enable: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("EnableTotp: RowsAffected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return 0, nil
+	}
+	query = "DELETE FROM " + RECOVERY_CODE_TABLE_NAME + " WHERE user_id=?"
+	_, err = tx.Exec(query, userId)
+	if err != nil {
+		return 0, fmt.Errorf("EnableTotp: old recovery codes: %w", err)
+	}
+	query = "INSERT INTO " + RECOVERY_CODE_TABLE_NAME + " (user_id, code_hash) VALUES (?, ?);"
+	for _, hash := range recoveryHashes {
+		_, err = tx.Exec(query, userId, hash)
+		if err != nil {
+			return 0, fmt.Errorf("EnableTotp: recovery code: %w", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("EnableTotp: commit: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+// Moves last_counter forward. Returns 0 when the counter was already used, so the same
+// code can't be accepted twice.
+func (s *SqliteDb) UseTotpCounter(userId int, counter int64) (int64, error) {
+	query := "UPDATE " + TOTP_TABLE_NAME + " SET last_counter=? WHERE user_id=? AND enabled_at IS NOT NULL AND last_counter<?"
+	result, err := s.Db.Exec(query, counter, userId, counter)
+	if err != nil {
+		return 0, fmt.Errorf("UseTotpCounter: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Returns 0 when the code doesn't exist or was already used
+func (s *SqliteDb) UseRecoveryCode(userId int, codeHash string, usedAt int64) (int64, error) {
+	query := "UPDATE " + RECOVERY_CODE_TABLE_NAME + " SET used_at=? WHERE user_id=? AND code_hash=? AND used_at IS NULL"
+	result, err := s.Db.Exec(query, usedAt, userId, codeHash)
+	if err != nil {
+		return 0, fmt.Errorf("UseRecoveryCode: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SqliteDb) RecoveryCodesLeft(userId int) (int, error) {
+	query := "SELECT COUNT(*) FROM " + RECOVERY_CODE_TABLE_NAME + " WHERE user_id=? AND used_at IS NULL"
+	var count int
+	err := s.Db.QueryRow(query, userId).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("RecoveryCodesLeft: %w", err)
+	}
+	return count, nil
+}
+
+// Removes the secret and the recovery codes
+func (s *SqliteDb) TotpDelete(userId int) (int64, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("TotpDelete: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "DELETE FROM " + RECOVERY_CODE_TABLE_NAME + " WHERE user_id=?"
+	_, err = tx.Exec(query, userId)
+	if err != nil {
+		return 0, fmt.Errorf("TotpDelete: recovery codes: %w", err)
+	}
+	query = "DELETE FROM " + TOTP_TABLE_NAME + " WHERE user_id=?"
+	result, err := tx.Exec(query, userId)
+	if err != nil {
+		return 0, fmt.Errorf("TotpDelete: totp: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("TotpDelete: RowsAffected: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("TotpDelete: commit: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+func (s *SqliteDb) CreateWebauthnCredential(input WebauthnCredentialInput) (int, error) {
+	query := "INSERT INTO " + WEBAUTHN_CREDENTIAL_TABLE_NAME + " (user_id, credential_id, public_key, sign_count, name, created_at) VALUES (?, ?, ?, ?, ?, ?);"
+	res, err := s.Db.Exec(query, input.UserId, input.CredentialId, input.PublicKey, input.SignCount, input.Name, input.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("CreateWebauthnCredential: Exec: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateWebauthnCredential: insert Id: %w", err)
+	}
+	return int(id), nil
+}
+
+func scanWebauthnCredential(row rowScanner) (*WebauthnCredential, error) {
+	c := WebauthnCredential{}
+	err := row.Scan(&c.Id, &c.UserId, &c.CredentialId, &c.PublicKey, &c.SignCount, &c.Name, &c.CreatedAt, &c.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Returns the user's passkeys, oldest first
+func (s *SqliteDb) WebauthnCredentials(userId int) ([]WebauthnCredential, error) {
+	query := "SELECT " + WEBAUTHN_CREDENTIAL_COLUMNS + " FROM " + WEBAUTHN_CREDENTIAL_TABLE_NAME + " WHERE user_id=? ORDER BY id"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("WebauthnCredentials: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []WebauthnCredential
+	for rows.Next() {
+		c, err := scanWebauthnCredential(rows)
+		if err != nil {
+			return nil, fmt.Errorf("WebauthnCredentials: Scan: %w", err)
+		}
+		data = append(data, *c)
+	}
+	return data, nil
+}
+
+func (s *SqliteDb) WebauthnCredentialByCredentialId(credentialId string) (*WebauthnCredential, error) {
+	query := "SELECT " + WEBAUTHN_CREDENTIAL_COLUMNS + " FROM " + WEBAUTHN_CREDENTIAL_TABLE_NAME + " WHERE credential_id=?"
+	row := s.Db.QueryRow(query, credentialId)
+	c, err := scanWebauthnCredential(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("WebauthnCredentialByCredentialId: %w", cuserr.NotFound{Item: "passkey"})
+		}
+		return nil, fmt.Errorf("WebauthnCredentialByCredentialId: %w", err)
+	}
+	return c, nil
+}
+
+// Saves the new sign count after a login. Returns 0 when the count changed since it was read,
+// so two logins with the same count can't both pass.
+func (s *SqliteDb) WebauthnCredentialUse(id int, oldSignCount, newSignCount, lastUsedAt int64) (int64, error) {
+	query := "UPDATE " + WEBAUTHN_CREDENTIAL_TABLE_NAME + " SET sign_count=?, last_used_at=? WHERE id=? AND sign_count=?"
+	result, err := s.Db.Exec(query, newSignCount, lastUsedAt, id, oldSignCount)
+	if err != nil {
+		return 0, fmt.Errorf("WebauthnCredentialUse: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SqliteDb) WebauthnCredentialDelete(id int, userId int) (int64, error) {
+	query := "DELETE FROM " + WEBAUTHN_CREDENTIAL_TABLE_NAME + " WHERE id=? AND user_id=?"
+	result, err := s.Db.Exec(query, id, userId)
+	if err != nil {
+		return 0, fmt.Errorf("WebauthnCredentialDelete: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Saves the challenge for the options just sent, expired ones nobody answered are dropped
+func (s *SqliteDb) CreateWebauthnChallenge(input WebauthnChallengeInput) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return fmt.Errorf("CreateWebauthnChallenge: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "DELETE FROM " + WEBAUTHN_CHALLENGE_TABLE_NAME + " WHERE expires_at<=?"
+	_, err = tx.Exec(query, input.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("CreateWebauthnChallenge: expired: %w", err)
+	}
+	query = "INSERT INTO " + WEBAUTHN_CHALLENGE_TABLE_NAME + " (id_hash, challenge, ceremony, user_id, expires_at) VALUES (?, ?, ?, ?, ?);"
+	_, err = tx.Exec(query, input.IdHash, input.Challenge, input.Ceremony, input.UserId, input.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("CreateWebauthnChallenge: Exec: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("CreateWebauthnChallenge: commit: %w", err)
+	}
+	return nil
+}
+
+// Deletes the challenge and returns it, so only the first response to it can use it
+func (s *SqliteDb) UseWebauthnChallenge(idHash string) (*WebauthnChallenge, error) {
+	query := "DELETE FROM " + WEBAUTHN_CHALLENGE_TABLE_NAME + " WHERE id_hash=? RETURNING id_hash, challenge, ceremony, user_id, expires_at"
+	c := WebauthnChallenge{}
+	err := s.Db.QueryRow(query, idHash).Scan(&c.IdHash, &c.Challenge, &c.Ceremony, &c.UserId, &c.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("UseWebauthnChallenge: %w", cuserr.NotFound{Item: "passkey challenge"})
+		}
+		return nil, fmt.Errorf("UseWebauthnChallenge: %w", err)
+	}
+	return &c, nil
+}
+
+// A new reset link replaces any the user still had
+func (s *SqliteDb) CreatePasswordReset(input PasswordResetInput) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return fmt.Errorf("CreatePasswordReset: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE " + PASSWORD_RESET_TABLE_NAME + " SET used_at=? WHERE user_id=? AND used_at IS NULL"
+	_, err = tx.Exec(query, input.CreatedAt, input.UserId)
+	if err != nil {
+		return fmt.Errorf("CreatePasswordReset: old resets: %w", err)
+	}
+	query = "INSERT INTO " + PASSWORD_RESET_TABLE_NAME + " (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?);"
+	_, err = tx.Exec(query, input.TokenHash, input.UserId, input.CreatedAt, input.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("CreatePasswordReset: Exec: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("CreatePasswordReset: commit: %w", err)
+	}
+	return nil
+}
+
+func (s *SqliteDb) PasswordResetByTokenHash(tokenHash string) (*PasswordReset, error) {
+	query := "SELECT " + PASSWORD_RESET_COLUMNS + " FROM " + PASSWORD_RESET_TABLE_NAME + " WHERE token_hash=?"
+	row := s.Db.QueryRow(query, tokenHash)
+	r := PasswordReset{}
+	err := row.Scan(&r.TokenHash, &r.UserId, &r.CreatedAt, &r.ExpiresAt, &r.UsedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("PasswordResetByTokenHash: %w", cuserr.NotFound{Item: "password reset"})
+		}
+		return nil, fmt.Errorf("PasswordResetByTokenHash: %w", err)
+	}
+	return &r, nil
+}
+
+// Uses the reset link to set the password and logs out every session. Returns the user's id,
+// or 0 when the link was already used or has expired so a link only works once.
+func (s *SqliteDb) UsePasswordReset(tokenHash, hashedPassword string, usedAt int64) (int, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("UsePasswordReset: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE " + PASSWORD_RESET_TABLE_NAME + " SET used_at=? WHERE token_hash=? AND used_at IS NULL AND expires_at>? RETURNING user_id"
+	userId := 0
+	err = tx.QueryRow(query, usedAt, tokenHash, usedAt).Scan(&userId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("UsePasswordReset: reset: %w", err)
+	}
+	query = "UPDATE " + USER_TABLE_NAME + " SET password=? WHERE id=?"
+	_, err = tx.Exec(query, hashedPassword, userId)
+	if err != nil {
+		return 0, fmt.Errorf("UsePasswordReset: password: %w", err)
+	}
+	query = "UPDATE " + SESSION_TABLE_NAME + " SET revoked_at=? WHERE user_id=? AND revoked_at IS NULL"
+	_, err = tx.Exec(query, usedAt, userId)
+	if err != nil {
+		return 0, fmt.Errorf("UsePasswordReset: sessions: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("UsePasswordReset: commit: %w", err)
+	}
+	return userId, nil
+}
+
+func (s *SqliteDb) CreateOidcIdentity(input OidcIdentityInput) (int, error) {
+	query := "INSERT INTO " + OIDC_IDENTITY_TABLE_NAME + " (user_id, issuer, subject, email, created_at, last_used_at) VALUES (?, ?, ?, ?, ?, ?);"
+	res, err := s.Db.Exec(query, input.UserId, input.Issuer, input.Subject, input.Email, input.CreatedAt, input.LastUsedAt)
+	if err != nil {
+		return 0, fmt.Errorf("CreateOidcIdentity: Exec: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateOidcIdentity: insert Id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Creates a user for someone logging in through the provider for the first time, linked to
+// their provider account in the same transaction. input.UserId is ignored.
+func (s *SqliteDb) CreateOidcUser(username, hashedPassword string, input OidcIdentityInput) (int, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("CreateOidcUser: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO " + USER_TABLE_NAME + " (username, password) VALUES (?, ?);"
+	res, err := tx.Exec(query, username, hashedPassword)
+	if err != nil {
+		return 0, fmt.Errorf("CreateOidcUser: user: %w", err)
+	}
+	userId, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("CreateOidcUser: insert Id: %w", err)
+	}
+	query = "INSERT INTO " + OIDC_IDENTITY_TABLE_NAME + " (user_id, issuer, subject, email, created_at, last_used_at) VALUES (?, ?, ?, ?, ?, ?);"
+	_, err = tx.Exec(query, userId, input.Issuer, input.Subject, input.Email, input.CreatedAt, input.LastUsedAt)
+	if err != nil {
+		return 0, fmt.Errorf("CreateOidcUser: identity: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("CreateOidcUser: commit: %w", err)
+	}
+	return int(userId), nil
+}
+
+func scanOidcIdentity(row rowScanner) (*OidcIdentity, error) {
+	o := OidcIdentity{}
+	err := row.Scan(&o.Id, &o.UserId, &o.Issuer, &o.Subject, &o.Email, &o.CreatedAt, &o.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// Returns the provider accounts linked to the user, oldest first
+func (s *SqliteDb) OidcIdentities(userId int) ([]OidcIdentity, error) {
+	query := "SELECT " + OIDC_IDENTITY_COLUMNS + " FROM " + OIDC_IDENTITY_TABLE_NAME + " WHERE user_id=? ORDER BY id"
+	rows, err := s.Db.Query(query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("OidcIdentities: Exec: %w", err)
+	}
+	defer rows.Close()
+
+	var data []OidcIdentity
+	for rows.Next() {
+		o, err := scanOidcIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("OidcIdentities: Scan: %w", err)
+		}
+		data = append(data, *o)
+	}
+	return data, nil
+}
+
+// Issuer and subject together are unique, a subject is only unique within its issuer
+func (s *SqliteDb) OidcIdentityBySubject(issuer, subject string) (*OidcIdentity, error) {
+	query := "SELECT " + OIDC_IDENTITY_COLUMNS + " FROM " + OIDC_IDENTITY_TABLE_NAME + " WHERE issuer=? AND subject=?"
+	row := s.Db.QueryRow(query, issuer, subject)
+	o, err := scanOidcIdentity(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("OidcIdentityBySubject: %w", cuserr.NotFound{Item: "linked account"})
+		}
+		return nil, fmt.Errorf("OidcIdentityBySubject: %w", err)
+	}
+	return o, nil
+}
+
+// Keeps the email the provider last sent, it's only shown to the user
+func (s *SqliteDb) OidcIdentityUse(id int, email string, lastUsedAt int64) (int64, error) {
+	query := "UPDATE " + OIDC_IDENTITY_TABLE_NAME + " SET email=?, last_used_at=? WHERE id=?"
+	result, err := s.Db.Exec(query, email, lastUsedAt, id)
+	if err != nil {
+		return 0, fmt.Errorf("OidcIdentityUse: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SqliteDb) OidcIdentityDelete(id int, userId int) (int64, error) {
+	query := "DELETE FROM " + OIDC_IDENTITY_TABLE_NAME + " WHERE id=? AND user_id=?"
+	result, err := s.Db.Exec(query, id, userId)
+	if err != nil {
+		return 0, fmt.Errorf("OidcIdentityDelete: %w", err)
+	}
+
 	return result.RowsAffected()
 }
@@ -1,20 +1,36 @@
 package database
 
 import (
+	"net/mail"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
+const (
+	DEFAULT_CURRENCY = "USD"
+	RATE_DATE_FORMAT = time.DateOnly
+)
+
+var currencyCodeRegex = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Ticker symbols like VTI, BRK.B or BTC-USD
+var symbolRegex = regexp.MustCompile(`^[A-Z0-9][A-Z0-9.\-]{0,11}$`)
+
 type User struct {
-	Id       int
-	UserName string
-	Password string
+	Id           int
+	UserName     string
+	Password     string
+	BaseCurrency string
 }
 
 type Bucket struct {
-	Id     int
-	Name   string
-	UserId int
+	Id        int
+	Name      string
+	UserId    int
+	DeletedAt *int64 // Unix seconds, nil when the bucket isn't deleted
 }
 
 type TransactionItem struct {
@@ -26,6 +42,8 @@ type TransactionItem struct {
 	IsExpense bool
 	UserId    int
 	BucketId  int
+	DeletedAt *int64 // Unix seconds, nil when the transaction isn't deleted
+	Currency  string // ISO 4217 code
 }
 
 type TransactionItemInput struct {
@@ -36,6 +54,7 @@ type TransactionItemInput struct {
 	IsExpense bool
 	UserId    int
 	BucketId  int
+	Currency  string
 }
 
 func (t *TransactionItemInput) Valid() map[string]string {
@@ -82,9 +101,22 @@ func (t *TransactionItemInput) Valid() map[string]string {
 		problems["BucketId"] = "Invalid BucketId"
 	}
 
+	if !IsCurrencyCode(t.Currency) {
+		problems["Currency"] = "Invalid Currency"
+	}
+
 	return problems
 }
 
+// Checks the value looks like an ISO 4217 code, ex: USD
+func IsCurrencyCode(c string) bool {
+	return currencyCodeRegex.MatchString(c)
+}
+
+func IsSymbol(s string) bool {
+	return symbolRegex.MatchString(s)
+}
+
 type TransactionFilters struct {
 	Id       int
 	Name     *string
@@ -96,9 +128,12 @@ type TransactionFilters struct {
 
 func (t *TransactionFilters) FilterQueryAndValues() (string, []any) {
 	values := []any{}
-	query := "WHERE user_id=?"
+	query := "WHERE user_id=? AND deleted_at IS NULL"
 	values = append(values, t.Id)
 
+	// Transactions in a deleted bucket stay hidden until the bucket is restored
+	query += " AND bucket_id NOT IN (SELECT id FROM " + BUCKETS_TABLE_NAME + " WHERE deleted_at IS NOT NULL)"
+
 	// NOTE: To use the like operator we need to have the value wrapped with wildcards
 	if t.Name != nil {
 		query += " AND name LIKE ?"
@@ -128,3 +163,795 @@ func (t *TransactionFilters) FilterQueryAndValues() (string, []any) {
 
 	return query, values
 }
+
+const (
+	AUDIT_ENTITY_BUCKET      = "bucket"
+	AUDIT_ENTITY_TRANSACTION = "transaction"
+	AUDIT_ENTITY_USER        = "user"
+
+	AUDIT_ACTION_CREATE  = "create"
+	AUDIT_ACTION_UPDATE  = "update"
+	AUDIT_ACTION_DELETE  = "delete"
+	AUDIT_ACTION_RESTORE = "restore"
+	AUDIT_ACTION_PURGE   = "purge"
+
+	AUDIT_ACTION_LOGIN_FAILED = "login_failed"
+	AUDIT_ACTION_LOGIN_LOCKED = "login_locked"
+)
+
+type AuditEntry struct {
+	Id         int
+	UserId     int
+	RequestId  string
+	EntityType string
+	EntityId   int
+	Action     string
+	BeforeJson *string // nil when the entity didn't exist before the change
+	AfterJson  *string // nil when the entity no longer exists after the change
+	CreatedAt  int64   // Unix seconds
+}
+
+type AuditEntryInput struct {
+	UserId     int
+	RequestId  string
+	EntityType string
+	EntityId   int
+	Action     string
+	BeforeJson *string
+	AfterJson  *string
+	CreatedAt  int64
+}
+
+// Who made a change to a bucket or transaction and when. The storage method that makes
+// the change saves its audit entry in the same db transaction.
+type AuditInfo struct {
+	UserId    int
+	RequestId string
+	CreatedAt int64 // Unix seconds
+}
+
+// Signed total of a bucket's transactions for one month in one currency
+type BucketMonthTotal struct {
+	BucketId int
+	Month    int
+	Year     int
+	Currency string
+	Total    float64
+}
+
+// Rate is how many units of BaseCurrency one unit of Currency is worth on RateDate
+type ExchangeRate struct {
+	Id           int
+	UserId       int
+	Currency     string
+	BaseCurrency string
+	Rate         float64
+	RateDate     string // YYYY-MM-DD
+}
+
+type ExchangeRateInput struct {
+	UserId       int
+	Currency     string
+	BaseCurrency string
+	Rate         float64
+	RateDate     string
+}
+
+func (e *ExchangeRateInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if !IsCurrencyCode(e.Currency) {
+		problems["Currency"] = "Invalid Currency"
+	}
+	if !IsCurrencyCode(e.BaseCurrency) {
+		problems["BaseCurrency"] = "Invalid Base Currency"
+	}
+	if e.Currency == e.BaseCurrency {
+		problems["Currency"] = "Currency must be different from the base currency"
+	}
+	if e.Rate <= 0 {
+		problems["Rate"] = "Rate must be greater than 0"
+	}
+	_, err := time.Parse(RATE_DATE_FORMAT, e.RateDate)
+	if err != nil {
+		problems["RateDate"] = "Date must be YYYY-MM-DD"
+	}
+	return problems
+}
+
+// Monthly spending limit of a bucket in the user's base currency
+type Budget struct {
+	Id       int
+	UserId   int
+	BucketId int
+	Amount   float64
+}
+
+type BudgetInput struct {
+	UserId   int
+	BucketId int
+	Amount   float64
+}
+
+func (b *BudgetInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if b.BucketId < 1 {
+		problems["BucketId"] = "Invalid BucketId"
+	}
+	if b.Amount <= 0 {
+		problems["Amount"] = "Amount must be greater than 0"
+	}
+	return problems
+}
+
+// A transaction that repeats every month on DayOfMonth
+type RecurringItem struct {
+	Id         int
+	UserId     int
+	BucketId   int
+	Name       string
+	Price      float64
+	IsExpense  bool
+	Currency   string
+	DayOfMonth int
+}
+
+type RecurringItemInput struct {
+	UserId     int
+	BucketId   int
+	Name       string
+	Price      float64
+	IsExpense  bool
+	Currency   string
+	DayOfMonth int
+}
+
+func (r *RecurringItemInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if len(r.Name) > 50 {
+		problems["Name"] = "Name length can't be greater than 50"
+	}
+	if len(r.Name) == 0 {
+		problems["Name"] = "Name length can't be 0"
+	}
+	if r.Price <= 0 {
+		problems["Price"] = "Invalid Price"
+	}
+	if r.BucketId < 1 {
+		problems["BucketId"] = "Invalid BucketId"
+	}
+	if !IsCurrencyCode(r.Currency) {
+		problems["Currency"] = "Invalid Currency"
+	}
+	// Days past the end of a short month fall on its last day
+	if r.DayOfMonth < 1 || r.DayOfMonth > 31 {
+		problems["DayOfMonth"] = "Day must be between 1-31"
+	}
+	return problems
+}
+
+// A target amount to save by TargetDate in the user's base currency.
+// Progress comes from the linked bucket when BucketId is set, otherwise from contributions.
+type SavingsGoal struct {
+	Id           int
+	UserId       int
+	Name         string
+	TargetAmount float64
+	TargetDate   string // YYYY-MM-DD
+	BucketId     *int
+	CreatedAt    int64 // Unix seconds
+}
+
+type SavingsGoalInput struct {
+	UserId       int
+	Name         string
+	TargetAmount float64
+	TargetDate   string
+	BucketId     *int
+	CreatedAt    int64
+}
+
+func (g *SavingsGoalInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if len(g.Name) > 50 {
+		problems["Name"] = "Name length can't be greater than 50"
+	}
+	if len(g.Name) == 0 {
+		problems["Name"] = "Name length can't be 0"
+	}
+	if g.TargetAmount <= 0 {
+		problems["TargetAmount"] = "Target must be greater than 0"
+	}
+	_, err := time.Parse(RATE_DATE_FORMAT, g.TargetDate)
+	if err != nil {
+		problems["TargetDate"] = "Date must be YYYY-MM-DD"
+	}
+	if g.BucketId != nil && *g.BucketId < 1 {
+		problems["BucketId"] = "Invalid BucketId"
+	}
+	return problems
+}
+
+type GoalContribution struct {
+	Id            int
+	GoalId        int
+	UserId        int
+	Amount        float64 // Negative for a withdrawal
+	ContributedOn string  // YYYY-MM-DD
+}
+
+type GoalContributionInput struct {
+	GoalId        int
+	UserId        int
+	Amount        float64
+	ContributedOn string
+}
+
+func (c *GoalContributionInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if c.Amount == 0 {
+		problems["Amount"] = "Amount can't be 0"
+	}
+	_, err := time.Parse(RATE_DATE_FORMAT, c.ContributedOn)
+	if err != nil {
+		problems["ContributedOn"] = "Date must be YYYY-MM-DD"
+	}
+	return problems
+}
+
+// An amount owed in the user's base currency. Balance is what was owed when the debt was added,
+// payments linked to it afterwards bring it down.
+type Debt struct {
+	Id         int
+	UserId     int
+	Name       string
+	Balance    float64
+	Apr        float64 // Yearly percentage rate, 19.99 for 19.99%
+	MinPayment float64 // Monthly
+	CreatedAt  int64   // Unix seconds
+}
+
+type DebtInput struct {
+	UserId     int
+	Name       string
+	Balance    float64
+	Apr        float64
+	MinPayment float64
+	CreatedAt  int64
+}
+
+func (d *DebtInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if len(d.Name) > 50 {
+		problems["Name"] = "Name length can't be greater than 50"
+	}
+	if len(d.Name) == 0 {
+		problems["Name"] = "Name length can't be 0"
+	}
+	if d.Balance <= 0 {
+		problems["Balance"] = "Balance must be greater than 0"
+	}
+	if d.Apr < 0 || d.Apr > 100 {
+		problems["Apr"] = "APR must be between 0-100"
+	}
+	if d.MinPayment <= 0 {
+		problems["MinPayment"] = "Minimum payment must be greater than 0"
+	}
+	return problems
+}
+
+// Links an existing expense to the debt it paid, a transaction pays at most one debt
+type DebtPayment struct {
+	Id          int
+	DebtId      int
+	UserId      int
+	Transaction TransactionItem
+}
+
+type DebtPaymentInput struct {
+	DebtId        int
+	UserId        int
+	TransactionId int
+}
+
+const (
+	ACCOUNT_KIND_ASSET     = "asset"
+	ACCOUNT_KIND_LIABILITY = "liability"
+)
+
+// Categories an account of each kind can have
+var AccountCategories = map[string][]string{
+	ACCOUNT_KIND_ASSET:     {"Cash", "Investment", "Property", "Other"},
+	ACCOUNT_KIND_LIABILITY: {"Loan", "Credit Card", "Mortgage", "Other"},
+}
+
+// Something the user owns or owes, its value comes from snapshots
+type NetWorthAccount struct {
+	Id       int
+	UserId   int
+	Name     string
+	Kind     string // ACCOUNT_KIND_ASSET or ACCOUNT_KIND_LIABILITY
+	Category string
+	Currency string // ISO 4217 code the snapshots are in
+}
+
+type NetWorthAccountInput struct {
+	UserId   int
+	Name     string
+	Kind     string
+	Category string
+	Currency string
+}
+
+func (a *NetWorthAccountInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if len(a.Name) > 50 {
+		problems["Name"] = "Name length can't be greater than 50"
+	}
+	if len(a.Name) == 0 {
+		problems["Name"] = "Name length can't be 0"
+	}
+	categories, ok := AccountCategories[a.Kind]
+	if !ok {
+		problems["Kind"] = "Must be asset or liability"
+	} else if !slices.Contains(categories, a.Category) {
+		problems["Category"] = "Not valid for a " + a.Kind
+	}
+	if !IsCurrencyCode(a.Currency) {
+		problems["Currency"] = "Invalid Currency"
+	}
+	return problems
+}
+
+// The balance of an account on a date. Liabilities are stored as the positive amount owed.
+type Snapshot struct {
+	Id           int
+	UserId       int
+	AccountId    int
+	Balance      float64
+	SnapshotDate string // YYYY-MM-DD
+}
+
+type SnapshotInput struct {
+	UserId       int
+	AccountId    int
+	Balance      float64
+	SnapshotDate string
+}
+
+func (s *SnapshotInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if s.AccountId < 1 {
+		problems["AccountId"] = "Invalid AccountId"
+	}
+	if s.Balance < 0 {
+		problems["Balance"] = "Balance can't be negative"
+	}
+	_, err := time.Parse(RATE_DATE_FORMAT, s.SnapshotDate)
+	if err != nil {
+		problems["SnapshotDate"] = "Date must be YYYY-MM-DD"
+	}
+	return problems
+}
+
+type InvestmentAccount struct {
+	Id       int
+	UserId   int
+	Name     string
+	Currency string // ISO 4217 code the account's trades and prices are in
+}
+
+type InvestmentAccountInput struct {
+	UserId   int
+	Name     string
+	Currency string
+}
+
+func (a *InvestmentAccountInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if len(a.Name) > 50 {
+		problems["Name"] = "Name length can't be greater than 50"
+	}
+	if len(a.Name) == 0 {
+		problems["Name"] = "Name length can't be 0"
+	}
+	if !IsCurrencyCode(a.Currency) {
+		problems["Currency"] = "Invalid Currency"
+	}
+	return problems
+}
+
+const (
+	TRADE_KIND_BUY      = "buy"
+	TRADE_KIND_SELL     = "sell"
+	TRADE_KIND_DIVIDEND = "dividend"
+)
+
+// A buy or sell of Quantity units at Price each. A dividend has no quantity and Price is the total paid.
+type InvestmentTrade struct {
+	Id        int
+	UserId    int
+	AccountId int
+	Symbol    string
+	Kind      string
+	Quantity  float64
+	Price     float64
+	Fees      float64
+	TradeDate string // YYYY-MM-DD
+}
+
+type InvestmentTradeInput struct {
+	UserId    int
+	AccountId int
+	Symbol    string
+	Kind      string
+	Quantity  float64
+	Price     float64
+	Fees      float64
+	TradeDate string
+}
+
+func (t *InvestmentTradeInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if t.AccountId < 1 {
+		problems["AccountId"] = "Invalid AccountId"
+	}
+	if !IsSymbol(t.Symbol) {
+		problems["Symbol"] = "Invalid Symbol"
+	}
+	switch t.Kind {
+	case TRADE_KIND_BUY, TRADE_KIND_SELL:
+		if t.Quantity <= 0 {
+			problems["Quantity"] = "Quantity must be greater than 0"
+		}
+		if t.Price < 0 {
+			problems["Price"] = "Price can't be negative"
+		}
+	case TRADE_KIND_DIVIDEND:
+		if t.Quantity != 0 {
+			problems["Quantity"] = "A dividend has no quantity"
+		}
+		if t.Price <= 0 {
+			problems["Price"] = "Dividend must be greater than 0"
+		}
+	default:
+		problems["Kind"] = "Must be buy, sell or dividend"
+	}
+	if t.Fees < 0 {
+		problems["Fees"] = "Fees can't be negative"
+	}
+	_, err := time.Parse(RATE_DATE_FORMAT, t.TradeDate)
+	if err != nil {
+		problems["TradeDate"] = "Date must be YYYY-MM-DD"
+	}
+	return problems
+}
+
+// Asset classes used for allocation, a symbol without one counts as Other
+var AssetClasses = []string{"Stock", "Bond", "Fund", "Cash", "Crypto", "Real Estate", "Other"}
+
+type Security struct {
+	Id         int
+	UserId     int
+	Symbol     string
+	AssetClass string
+}
+
+type SecurityInput struct {
+	UserId     int
+	Symbol     string
+	AssetClass string
+}
+
+func (s *SecurityInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if !IsSymbol(s.Symbol) {
+		problems["Symbol"] = "Invalid Symbol"
+	}
+	if !slices.Contains(AssetClasses, s.AssetClass) {
+		problems["AssetClass"] = "Not valid"
+	}
+	return problems
+}
+
+// Closing price of a symbol on a date in the currency of the accounts holding it
+type SecurityPrice struct {
+	Id        int
+	UserId    int
+	Symbol    string
+	Price     float64
+	PriceDate string // YYYY-MM-DD
+}
+
+type SecurityPriceInput struct {
+	UserId    int
+	Symbol    string
+	Price     float64
+	PriceDate string
+}
+
+func (p *SecurityPriceInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if !IsSymbol(p.Symbol) {
+		problems["Symbol"] = "Invalid Symbol"
+	}
+	if p.Price < 0 {
+		problems["Price"] = "Price can't be negative"
+	}
+	_, err := time.Parse(RATE_DATE_FORMAT, p.PriceDate)
+	if err != nil {
+		problems["PriceDate"] = "Date must be YYYY-MM-DD"
+	}
+	return problems
+}
+
+// An expense due every month on DueDay that is marked paid by hand, unlike a RecurringItem which is only a forecast
+type Bill struct {
+	Id        int
+	UserId    int
+	BucketId  int
+	Name      string
+	Amount    float64
+	Currency  string
+	DueDay    int
+	CreatedAt int64 // Unix seconds, due dates before it aren't reminded about
+}
+
+type BillInput struct {
+	UserId    int
+	BucketId  int
+	Name      string
+	Amount    float64
+	Currency  string
+	DueDay    int
+	CreatedAt int64
+}
+
+func (b *BillInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if len(b.Name) > 50 {
+		problems["Name"] = "Name length can't be greater than 50"
+	}
+	if len(b.Name) == 0 {
+		problems["Name"] = "Name length can't be 0"
+	}
+	if b.Amount <= 0 {
+		problems["Amount"] = "Amount must be greater than 0"
+	}
+	if b.BucketId < 1 {
+		problems["BucketId"] = "Invalid BucketId"
+	}
+	if !IsCurrencyCode(b.Currency) {
+		problems["Currency"] = "Invalid Currency"
+	}
+	// Days past the end of a short month fall on its last day
+	if b.DueDay < 1 || b.DueDay > 31 {
+		problems["DueDay"] = "Day must be between 1-31"
+	}
+	return problems
+}
+
+// Marks one due date of a bill paid by the transaction created for it
+type BillPayment struct {
+	Id            int
+	BillId        int
+	UserId        int
+	DueDate       string // YYYY-MM-DD
+	TransactionId int
+}
+
+type BillPaymentInput struct {
+	BillId  int
+	UserId  int
+	DueDate string
+}
+
+// Emails a user has asked for, every alert is off until turned on
+type NotificationPreference struct {
+	UserId           int
+	Email            string
+	WeeklyDigest     bool
+	DigestWeekday    int      // time.Weekday the digest goes out on
+	BudgetAlerts     bool     // A budget is exceeded
+	BillAlerts       bool     // A bill is due soon or overdue
+	LargeTransaction *float64 // Alert on transactions at least this much in the base currency, nil is off
+	UpdatedAt        int64    // Unix seconds
+}
+
+type NotificationPreferenceInput struct {
+	UserId           int
+	Email            string
+	WeeklyDigest     bool
+	DigestWeekday    int
+	BudgetAlerts     bool
+	BillAlerts       bool
+	LargeTransaction *float64
+	UpdatedAt        int64
+}
+
+func (n *NotificationPreferenceInput) Valid() map[string]string {
+	problems := make(map[string]string)
+	if n.Email != "" {
+		addr, err := mail.ParseAddress(n.Email)
+		if err != nil || addr.Address != n.Email || len(n.Email) > 254 {
+			problems["Email"] = "Invalid email address"
+		}
+	}
+	anyOn := n.WeeklyDigest || n.BudgetAlerts || n.BillAlerts || n.LargeTransaction != nil
+	if n.Email == "" && anyOn {
+		problems["Email"] = "An email address is needed to send notifications"
+	}
+	if n.DigestWeekday < 0 || n.DigestWeekday > 6 {
+		problems["DigestWeekday"] = "Not a day of the week"
+	}
+	if n.LargeTransaction != nil && *n.LargeTransaction <= 0 {
+		problems["LargeTransaction"] = "Must be greater than 0"
+	}
+	return problems
+}
+
+const (
+	NOTIFICATION_KIND_DIGEST            = "digest"
+	NOTIFICATION_KIND_BUDGET            = "budget"
+	NOTIFICATION_KIND_BILL              = "bill"
+	NOTIFICATION_KIND_LARGE_TRANSACTION = "large_transaction"
+)
+
+// Remembers a notification went out so it isn't sent again, DedupeKey is unique per user and kind
+type NotificationSent struct {
+	Id        int
+	UserId    int
+	Kind      string
+	DedupeKey string
+	SentAt    int64 // Unix seconds
+}
+
+type NotificationSentInput struct {
+	UserId    int
+	Kind      string
+	DedupeKey string
+	SentAt    int64
+}
+
+// Only a hash of the feed token is kept, the url is shown once when the token is made
+type CalendarFeed struct {
+	UserId    int
+	TokenHash string // Hex sha256 of the token
+	CreatedAt int64  // Unix seconds
+}
+
+type CalendarFeedInput struct {
+	UserId    int
+	TokenHash string
+	CreatedAt int64
+}
+
+// One per login, Id is the jti of the session's jwts
+type Session struct {
+	Id         string
+	UserId     int
+	CreatedAt  int64  // Unix seconds
+	ExpiresAt  int64  // Unix seconds, the absolute timeout
+	RevokedAt  *int64 // Unix seconds, set when logged out
+	LastSeenAt int64  // Unix seconds, updated on refresh for the idle timeout
+}
+
+type SessionInput struct {
+	Id         string
+	UserId     int
+	CreatedAt  int64
+	ExpiresAt  int64
+	LastSeenAt int64
+}
+
+// Each refresh hands out a new token and marks the old one used, only the hash is kept
+type RefreshToken struct {
+	TokenHash string // Hex sha256 of the token
+	SessionId string
+	CreatedAt int64  // Unix seconds
+	UsedAt    *int64 // Unix seconds, set once it's been swapped for a new token
+}
+
+type RefreshTokenInput struct {
+	TokenHash string
+	SessionId string
+	CreatedAt int64
+}
+
+// A user's TOTP secret, EnabledAt stays nil until the first code is confirmed during enrollment
+type Totp struct {
+	UserId      int
+	Secret      string // Base32 without padding
+	EnabledAt   *int64 // Unix seconds
+	LastCounter int64  // Time step of the last accepted code, older or equal ones are replays
+	CreatedAt   int64  // Unix seconds
+}
+
+type TotpInput struct {
+	UserId    int
+	Secret    string
+	CreatedAt int64
+}
+
+// Single use codes for when the authenticator is lost, only the hash is kept
+type RecoveryCode struct {
+	Id       int
+	UserId   int
+	CodeHash string // Hex sha256 of the code
+	UsedAt   *int64 // Unix seconds
+}
+
+// A passkey, CredentialId is the authenticator's id for it in unpadded base64url
+type WebauthnCredential struct {
+	Id           int
+	UserId       int
+	CredentialId string
+	PublicKey    []byte // COSE_Key
+	SignCount    int64
+	Name         string
+	CreatedAt    int64  // Unix seconds
+	LastUsedAt   *int64 // Unix seconds
+}
+
+type WebauthnCredentialInput struct {
+	UserId       int
+	CredentialId string
+	PublicKey    []byte
+	SignCount    int64
+	Name         string
+	CreatedAt    int64
+}
+
+// The challenge a passkey ceremony's options were made with, kept until the response comes
+// back. Only the hash of the id in the browser's cookie is kept.
+type WebauthnChallenge struct {
+	IdHash    string // Hex sha256 of the id
+	Challenge []byte
+	Ceremony  string
+	UserId    int   // 0 for logins, the browser picks the account
+	ExpiresAt int64 // Unix seconds
+}
+
+type WebauthnChallengeInput struct {
+	IdHash    string
+	Challenge []byte
+	Ceremony  string
+	UserId    int
+	CreatedAt int64
+	ExpiresAt int64
+}
+
+// Emailed link to set a new password, only the hash of the token is kept
+type PasswordReset struct {
+	TokenHash string // Hex sha256 of the token
+	UserId    int
+	CreatedAt int64  // Unix seconds
+	ExpiresAt int64  // Unix seconds
+	UsedAt    *int64 // Unix seconds, also set when a newer link replaced it
+}
+
+type PasswordResetInput struct {
+	TokenHash string
+	UserId    int
+	CreatedAt int64
+	ExpiresAt int64
+}
+
+// An account at the OpenID Connect provider that can log in as the user
+type OidcIdentity struct {
+	Id         int
+	UserId     int
+	Issuer     string
+	Subject    string // The provider's id for the account, never reassigned
+	Email      string // As the provider last sent it, only for display
+	CreatedAt  int64  // Unix seconds
+	LastUsedAt *int64 // Unix seconds
+}
+
+type OidcIdentityInput struct {
+	UserId     int
+	Issuer     string
+	Subject    string
+	Email      string
+	CreatedAt  int64
+	LastUsedAt *int64
+}
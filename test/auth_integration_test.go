@@ -3,14 +3,27 @@ package test_test
 import (
 	"context"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"html"
 	"io"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/mail"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
+	"wonk/app/oidc/oidctest"
+	"wonk/app/totp"
+	"wonk/app/webauthn"
+	"wonk/app/webauthn/webauthntest"
 	"wonk/cmd/server"
 )
 
@@ -25,12 +38,7 @@ func IntegrationTest(t *testing.T) {
 // This test case handles the happy path
 func TestAuthHandlers(t *testing.T) {
 	IntegrationTest(t)
-	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
-	t.Cleanup(cancel)
-	endpoint := "http://localhost:8070"
-	go server.Run(ctx, getTestSecrets, nil, []string{"--exclude-env", "-logfmt=devlog", "--test-db"})
-	waitForReady(ctx, time.Second*5, endpoint+"/health")
+	endpoint := startTestServer(t)
 
 	// Begin Testing workflow
 	mockUsername := "testUser"
@@ -104,6 +112,1179 @@ func TestAuthHandlers(t *testing.T) {
 	}
 }
 
+// Test handles the following flow: User logs in on two devices, logs out on one
+// and that cookie stops working, then logs out all devices from the other
+func TestLogout(t *testing.T) {
+	IntegrationTest(t)
+	endpoint := startTestServer(t)
+	// Don't follow the redirect to /login so it can be checked
+	client := http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	mockUsername := "logoutUser"
+	mockPassword := "mockPassword!"
+	resp, err := client.PostForm(endpoint+"/signup", url.Values{
+		"username": []string{mockUsername},
+		"password": []string{mockPassword},
+	})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("sign up failed:", err)
+	}
+	login := func() *http.Cookie {
+		resp, err := client.PostForm(endpoint+"/login", url.Values{
+			"username": []string{mockUsername},
+			"password": []string{mockPassword},
+		})
+		if err != nil || resp.StatusCode != http.StatusOK || len(resp.Cookies()) < 1 {
+			t.Fatal("login failed:", err)
+		}
+		return resp.Cookies()[0]
+	}
+	do := func(method, path string, cookie *http.Cookie) *http.Response {
+		req, err := http.NewRequest(method, endpoint+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(cookie)
+		if method != http.MethodGet {
+			req.Header.Set("X-CSRF-Token", pageCsrfToken(t, endpoint, cookie))
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	isLoggedIn := func(cookie *http.Cookie) bool {
+		resp := do(http.MethodGet, "/finance", cookie)
+		if resp.StatusCode == http.StatusFound && resp.Header.Get("Location") == "/login" {
+			return false
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("finance: unexpected status %d", resp.StatusCode)
+		}
+		return true
+	}
+
+	laptop := login()
+	phone := login()
+	tablet := login()
+	if !isLoggedIn(laptop) || !isLoggedIn(phone) || !isLoggedIn(tablet) {
+		t.Fatal("expected every device to be logged in")
+	}
+
+	resp = do(http.MethodPost, "/logout", laptop)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("HX-Redirect") != "/login" {
+		t.Fatalf("logout: unexpected status %d", resp.StatusCode)
+	}
+	cleared := false
+	for _, c := range resp.Cookies() {
+		if c.Name == "WonkAuth" && c.MaxAge < 0 {
+			cleared = true
+		}
+	}
+	if !cleared {
+		t.Error("logout: expected the auth cookie to be cleared")
+	}
+	// The old cookie still has a valid jwt but its session is revoked
+	if isLoggedIn(laptop) {
+		t.Error("expected the logged out cookie to be rejected")
+	}
+	if !isLoggedIn(phone) {
+		t.Error("expected the other devices to stay logged in")
+	}
+
+	resp = do(http.MethodPost, "/logout/all", phone)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("logout all: unexpected status %d", resp.StatusCode)
+	}
+	if isLoggedIn(phone) || isLoggedIn(tablet) {
+		t.Error("expected every device to be logged out")
+	}
+	if !isLoggedIn(login()) {
+		t.Error("expected logging in again to work")
+	}
+}
+
+// Test handles the following flow: User logs in, their jwt cookie is gone, the refresh cookie
+// alone gets them back in with new cookies, and logging out clears the refresh cookie too
+func TestRefreshCookie(t *testing.T) {
+	IntegrationTest(t)
+	endpoint := startTestServer(t)
+	client := http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	mockUsername := "refreshUser"
+	mockPassword := "mockPassword!"
+	resp, err := client.PostForm(endpoint+"/signup", url.Values{
+		"username": []string{mockUsername},
+		"password": []string{mockPassword},
+	})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("sign up failed:", err)
+	}
+	resp, err = client.PostForm(endpoint+"/login", url.Values{
+		"username": []string{mockUsername},
+		"password": []string{mockPassword},
+	})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("login failed:", err)
+	}
+	cookies := map[string]*http.Cookie{}
+	for _, c := range resp.Cookies() {
+		cookies[c.Name] = c
+	}
+	refresh, ok := cookies["WonkRefresh"]
+	if !ok || !refresh.HttpOnly || refresh.MaxAge <= 0 {
+		t.Fatalf("login: expected an http only refresh cookie, got %+v", refresh)
+	}
+	do := func(method, path string, cookies ...*http.Cookie) *http.Response {
+		req, err := http.NewRequest(method, endpoint+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp = do(http.MethodGet, "/finance", refresh)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("refresh: unexpected status %d", resp.StatusCode)
+	}
+	reissued := map[string]*http.Cookie{}
+	for _, c := range resp.Cookies() {
+		reissued[c.Name] = c
+	}
+	if reissued["WonkAuth"] == nil || reissued["WonkRefresh"] == nil {
+		t.Fatal("refresh: expected both cookies to be re-issued")
+	}
+	if reissued["WonkRefresh"].Value == refresh.Value {
+		t.Error("refresh: expected the refresh token to rotate")
+	}
+	resp = do(http.MethodGet, "/finance", reissued["WonkAuth"])
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("re-issued jwt: unexpected status %d", resp.StatusCode)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint+"/logout", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(reissued["WonkAuth"])
+	req.AddCookie(reissued["WonkRefresh"])
+	req.Header.Set("X-CSRF-Token", pageCsrfToken(t, endpoint, reissued["WonkAuth"]))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("logout: unexpected status %d", resp.StatusCode)
+	}
+	cleared := false
+	for _, c := range resp.Cookies() {
+		if c.Name == "WonkRefresh" && c.MaxAge < 0 {
+			cleared = true
+		}
+	}
+	if !cleared {
+		t.Error("logout: expected the refresh cookie to be cleared")
+	}
+	resp = do(http.MethodGet, "/finance", reissued["WonkRefresh"])
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/login" {
+		t.Errorf("expected the refresh cookie to stop working after logout, got %d", resp.StatusCode)
+	}
+}
+
+// Test handles the following flow: User logs in and changes data with and without the csrf
+// token from their page, only requests carrying their own session's token get through
+func TestCSRF(t *testing.T) {
+	IntegrationTest(t)
+	endpoint := startTestServer(t)
+	mockUsername := "csrfUser"
+	mockPassword := "mockPassword!"
+	resp, err := http.PostForm(endpoint+"/signup", url.Values{
+		"username": []string{mockUsername},
+		"password": []string{mockPassword},
+	})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("sign up failed:", err)
+	}
+	login := func() *http.Cookie {
+		resp, err := http.PostForm(endpoint+"/login", url.Values{
+			"username": []string{mockUsername},
+			"password": []string{mockPassword},
+		})
+		if err != nil || resp.StatusCode != http.StatusOK || len(resp.Cookies()) < 1 {
+			t.Fatal("login failed:", err)
+		}
+		return resp.Cookies()[0]
+	}
+	cookie := login()
+	otherCookie := login()
+	token := pageCsrfToken(t, endpoint, cookie)
+	otherToken := pageCsrfToken(t, endpoint, otherCookie)
+	if token == otherToken {
+		t.Fatal("expected each session to get its own token")
+	}
+
+	createBucket := func(name, headerToken, formToken string, htmx bool) (*http.Response, string) {
+		form := url.Values{"name": []string{name}}
+		if formToken != "" {
+			form.Set("csrf_token", formToken)
+		}
+		req, err := http.NewRequest(http.MethodPost, endpoint+"/finance/bucket/form", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if htmx {
+			req.Header.Set("hx-request", "true")
+		}
+		if headerToken != "" {
+			req.Header.Set("X-CSRF-Token", headerToken)
+		}
+		req.AddCookie(cookie)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	tests := []struct {
+		name           string
+		headerToken    string
+		formToken      string
+		htmx           bool
+		expectedStatus int
+	}{
+		{name: "token in header", headerToken: token, htmx: true, expectedStatus: http.StatusOK},
+		{name: "token in form", formToken: token, htmx: true, expectedStatus: http.StatusOK},
+		{name: "missing token", htmx: true, expectedStatus: http.StatusForbidden},
+		{name: "wrong token", headerToken: "bm90IHRoZSB0b2tlbg", htmx: true, expectedStatus: http.StatusForbidden},
+		{name: "another session's token", headerToken: otherToken, htmx: true, expectedStatus: http.StatusForbidden},
+		{name: "missing token without htmx", expectedStatus: http.StatusForbidden},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := createBucket(fmt.Sprintf("csrf bucket %d", i), tt.headerToken, tt.formToken, tt.htmx)
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+			if tt.expectedStatus != http.StatusForbidden {
+				return
+			}
+			if tt.htmx && resp.Header.Get("HX-Reswap") != "none" {
+				t.Error("expected htmx not to swap the 403 into the page")
+			}
+			if !tt.htmx && !strings.Contains(body, "403 Forbidden") {
+				t.Error("expected a 403 page")
+			}
+			if !strings.Contains(body, "reload") {
+				t.Errorf("expected the error to say how to fix it, got %q", body)
+			}
+		})
+	}
+}
+
+// Test handles the following flow: User mistypes their password, a wrong password and an
+// unknown username get the same error, retrying right away is rate limited even with the
+// right password, and after the delay logging in works again
+func TestLoginRateLimit(t *testing.T) {
+	IntegrationTest(t)
+	endpoint := startTestServer(t)
+	mockUsername := "limitedUser"
+	mockPassword := "mockPassword!"
+	resp, err := http.PostForm(endpoint+"/signup", url.Values{
+		"username": []string{mockUsername},
+		"password": []string{mockPassword},
+	})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("sign up failed:", err)
+	}
+	login := func(username, password string) (*http.Response, string) {
+		resp, err := http.PostForm(endpoint+"/login", url.Values{
+			"username": []string{username},
+			"password": []string{password},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	resp, wrongPasswordBody := login(mockUsername, "wrongPassword!")
+	if resp.StatusCode != 422 {
+		t.Fatalf("wrong password: expected 422, got %d", resp.StatusCode)
+	}
+	resp, unknownUserBody := login("noSuchUser", "wrongPassword!")
+	if resp.StatusCode != 422 {
+		t.Fatalf("unknown user: expected 422, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(wrongPasswordBody, "invalid username or password") || wrongPasswordBody != unknownUserBody {
+		t.Error("expected the same error for a wrong password and an unknown username")
+	}
+
+	resp, body := login(mockUsername, mockPassword)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("retry: expected 429, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" || !strings.Contains(body, "too many attempts") {
+		t.Error("retry: expected a Retry-After header and a message")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	resp, _ = login(mockUsername, mockPassword)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("after the delay: expected 200, got %d", resp.StatusCode)
+	}
+}
+
+// Turns on 2FA from the settings page, then logs in with a code, a recovery code and a replayed
+// code, and turns it off again with a recovery code
+func TestTwoFactorLogin(t *testing.T) {
+	IntegrationTest(t)
+	endpoint := startTestServer(t)
+	mockUsername := "twoFactorUser"
+	mockPassword := "mockPassword!"
+	resp, err := http.PostForm(endpoint+"/signup", url.Values{
+		"username": []string{mockUsername},
+		"password": []string{mockPassword},
+	})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("sign up failed:", err)
+	}
+	cookieNamed := func(resp *http.Response, name string) *http.Cookie {
+		for _, c := range resp.Cookies() {
+			if c.Name == name && c.MaxAge >= 0 {
+				return c
+			}
+		}
+		return nil
+	}
+	do := func(method, path string, form url.Values, cookies ...*http.Cookie) (*http.Response, string) {
+		req, err := http.NewRequest(method, endpoint+path, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("hx-request", "true")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+	login := func() *http.Response {
+		resp, _ := do(http.MethodPost, "/login", url.Values{
+			"username": []string{mockUsername},
+			"password": []string{mockPassword},
+		})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("login: expected 200, got %d", resp.StatusCode)
+		}
+		return resp
+	}
+
+	// Enroll
+	authCookie := cookieNamed(login(), "WonkAuth")
+	if authCookie == nil {
+		t.Fatal("login without 2fa: missing auth cookie")
+	}
+	csrfForm := url.Values{"csrf_token": []string{pageCsrfToken(t, endpoint, authCookie)}}
+	resp, body := do(http.MethodPost, "/finance/two-factor", csrfForm, authCookie)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "<svg") {
+		t.Fatalf("enroll: expected 200 with a QR code, got %d", resp.StatusCode)
+	}
+	match := regexp.MustCompile(`id="totpSecret"[^>]*>([A-Z2-7]+)<`).FindStringSubmatch(body)
+	if match == nil {
+		t.Fatal("enroll: secret not found in page")
+	}
+	secret, err := totp.DecodeSecret(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmForm := url.Values{"csrf_token": csrfForm["csrf_token"], "code": []string{totp.Code(secret, time.Now())}}
+	resp, body = do(http.MethodPost, "/finance/two-factor/confirm", confirmForm, authCookie)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("confirm: expected 200, got %d", resp.StatusCode)
+	}
+	recoveryCodes := regexp.MustCompile(`<li>([a-z2-7]{5}-[a-z2-7]{5})</li>`).FindAllStringSubmatch(body, -1)
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("confirm: expected 10 recovery codes, got %d", len(recoveryCodes))
+	}
+
+	// The password alone only gets the code form
+	resp = login()
+	if cookieNamed(resp, "WonkAuth") != nil {
+		t.Fatal("login with 2fa: got an auth cookie before the code")
+	}
+	pendingCookie := cookieNamed(resp, "WonkTotp")
+	if pendingCookie == nil {
+		t.Fatal("login with 2fa: missing pending cookie")
+	}
+	resp, _ = do(http.MethodPost, "/login/2fa", url.Values{"code": []string{"000000"}}, pendingCookie)
+	if resp.StatusCode != 422 {
+		t.Errorf("wrong code: expected 422, got %d", resp.StatusCode)
+	}
+	resp, _ = do(http.MethodPost, "/login/2fa", url.Values{"code": []string{"000000"}})
+	if resp.StatusCode != 422 {
+		t.Errorf("no pending login: expected 422, got %d", resp.StatusCode)
+	}
+	// Each failure delays the next attempt
+	time.Sleep(1100 * time.Millisecond)
+	nextCode := totp.Code(secret, time.Now().Add(totp.PERIOD))
+	resp, _ = do(http.MethodPost, "/login/2fa", url.Values{"code": []string{nextCode}}, pendingCookie)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("HX-Redirect") != "/home" || cookieNamed(resp, "WonkAuth") == nil {
+		t.Fatalf("code: expected a session, got %d", resp.StatusCode)
+	}
+
+	// A code can't be used twice
+	pendingCookie = cookieNamed(login(), "WonkTotp")
+	resp, _ = do(http.MethodPost, "/login/2fa", url.Values{"code": []string{nextCode}}, pendingCookie)
+	if resp.StatusCode != 422 {
+		t.Errorf("replayed code: expected 422, got %d", resp.StatusCode)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	resp, _ = do(http.MethodPost, "/login/2fa", url.Values{"code": []string{recoveryCodes[0][1]}}, pendingCookie)
+	authCookie = cookieNamed(resp, "WonkAuth")
+	if resp.StatusCode != http.StatusOK || authCookie == nil {
+		t.Fatalf("recovery code: expected a session, got %d", resp.StatusCode)
+	}
+
+	// Turning it off needs a code
+	csrfForm = url.Values{"csrf_token": []string{pageCsrfToken(t, endpoint, authCookie)}}
+	resp, _ = do(http.MethodPost, "/finance/two-factor/disable", csrfForm, authCookie)
+	if resp.StatusCode != 422 {
+		t.Errorf("disable without a code: expected 422, got %d", resp.StatusCode)
+	}
+	disableForm := url.Values{"csrf_token": csrfForm["csrf_token"], "code": []string{recoveryCodes[1][1]}}
+	resp, body = do(http.MethodPost, "/finance/two-factor/disable", disableForm, authCookie)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "authentication is off") {
+		t.Fatalf("disable: expected 200, got %d", resp.StatusCode)
+	}
+	if cookieNamed(login(), "WonkAuth") == nil {
+		t.Error("login after disabling: missing auth cookie")
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	IntegrationTest(t)
+	endpoint := startTestServer(t)
+	mockUsername := "passkeyUser"
+	mockPassword := "mockPassword!"
+	resp, err := http.PostForm(endpoint+"/signup", url.Values{
+		"username": []string{mockUsername},
+		"password": []string{mockPassword},
+	})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("sign up failed:", err)
+	}
+	cookieNamed := func(resp *http.Response, name string) *http.Cookie {
+		for _, c := range resp.Cookies() {
+			if c.Name == name && c.MaxAge >= 0 {
+				return c
+			}
+		}
+		return nil
+	}
+	do := func(method, path string, form url.Values, header http.Header, cookies ...*http.Cookie) (*http.Response, string) {
+		req, err := http.NewRequest(method, endpoint+path, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("hx-request", "true")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+	credentialJson := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// Register a passkey while logged in with the password
+	resp, _ = do(http.MethodPost, "/login", url.Values{
+		"username": []string{mockUsername},
+		"password": []string{mockPassword},
+	}, nil)
+	authCookie := cookieNamed(resp, "WonkAuth")
+	if authCookie == nil {
+		t.Fatal("password login: missing auth cookie")
+	}
+	csrfToken := pageCsrfToken(t, endpoint, authCookie)
+	resp, _ = do(http.MethodPost, "/finance/passkeys/options", nil, nil, authCookie)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("options without a csrf token: expected 403, got %d", resp.StatusCode)
+	}
+	register := func(a *webauthntest.Authenticator, name string) url.Values {
+		resp, body := do(http.MethodPost, "/finance/passkeys/options", nil, http.Header{"X-Csrf-Token": []string{csrfToken}}, authCookie)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("creation options: expected 200, got %d", resp.StatusCode)
+		}
+		creationOptions := webauthn.CreationOptions{}
+		err := json.Unmarshal([]byte(body), &creationOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		challengeCookie := cookieNamed(resp, "WonkWebauthn")
+		created, err := a.Create(creationOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registerForm := url.Values{
+			"csrf_token": []string{csrfToken},
+			"name":       []string{name},
+			"credential": []string{credentialJson(created)},
+		}
+		resp, body = do(http.MethodPost, "/finance/passkeys", registerForm, nil, authCookie, challengeCookie)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, name) {
+			t.Fatalf("register: expected 200 listing the passkey, got %d", resp.StatusCode)
+		}
+		return registerForm
+	}
+	authenticator := webauthntest.New(endpoint)
+	registerForm := register(authenticator, "Test Key")
+	resp, _ = do(http.MethodPost, "/finance/passkeys", registerForm, nil, authCookie)
+	if resp.StatusCode != 422 {
+		t.Errorf("register without a challenge: expected 422, got %d", resp.StatusCode)
+	}
+
+	// Log in with it, no username or password needed
+	loginWithPasskey := func(authenticator *webauthntest.Authenticator) (*http.Response, *webauthn.AssertionCredential, *http.Cookie) {
+		resp, body := do(http.MethodPost, "/login/passkey/options", nil, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request options: expected 200, got %d", resp.StatusCode)
+		}
+		requestOptions := webauthn.RequestOptions{}
+		err := json.Unmarshal([]byte(body), &requestOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		challengeCookie := cookieNamed(resp, "WonkWebauthn")
+		assertion, err := authenticator.Get(requestOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _ = do(http.MethodPost, "/login/passkey", url.Values{"credential": []string{credentialJson(assertion)}}, nil, challengeCookie)
+		return resp, assertion, challengeCookie
+	}
+	resp, assertion, challengeCookie := loginWithPasskey(authenticator)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("HX-Redirect") != "/home" || cookieNamed(resp, "WonkAuth") == nil {
+		t.Fatalf("passkey login: expected a session, got %d", resp.StatusCode)
+	}
+	resp, _ = do(http.MethodPost, "/login/passkey", url.Values{"credential": []string{credentialJson(assertion)}}, nil, challengeCookie)
+	if resp.StatusCode != 422 || cookieNamed(resp, "WonkAuth") != nil {
+		t.Errorf("replayed assertion: expected 422, got %d", resp.StatusCode)
+	}
+	resp, _ = do(http.MethodPost, "/login/passkey", url.Values{"credential": []string{credentialJson(assertion)}}, nil)
+	if resp.StatusCode != 422 {
+		t.Errorf("no challenge: expected 422, got %d", resp.StatusCode)
+	}
+
+	// Most synced passkeys leave the sign count at 0, so only the used up challenge stops a replay
+	zeroCount := webauthntest.New(endpoint)
+	zeroCount.NoSignCount = true
+	register(zeroCount, "Synced Key")
+	resp, assertion, challengeCookie = loginWithPasskey(zeroCount)
+	if resp.StatusCode != http.StatusOK || cookieNamed(resp, "WonkAuth") == nil {
+		t.Fatalf("zero sign count login: expected a session, got %d", resp.StatusCode)
+	}
+	resp, _ = do(http.MethodPost, "/login/passkey", url.Values{"credential": []string{credentialJson(assertion)}}, nil, challengeCookie)
+	if resp.StatusCode != 422 || cookieNamed(resp, "WonkAuth") != nil {
+		t.Errorf("replayed zero sign count assertion: expected 422, got %d", resp.StatusCode)
+	}
+
+	// Removed passkeys can't log in, the password still can
+	_, body := do(http.MethodGet, "/finance/passkeys", nil, nil, authCookie)
+	match := regexp.MustCompile(`hx-delete="/finance/passkeys/(\d+)"`).FindStringSubmatch(body)
+	if match == nil {
+		t.Fatal("passkey id not found in page")
+	}
+	resp, _ = do(http.MethodDelete, "/finance/passkeys/"+match[1], nil, http.Header{"X-Csrf-Token": []string{csrfToken}}, authCookie)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d", resp.StatusCode)
+	}
+	resp, _, _ = loginWithPasskey(authenticator)
+	if resp.StatusCode != 422 {
+		t.Errorf("removed passkey: expected 422, got %d", resp.StatusCode)
+	}
+	resp, _ = do(http.MethodPost, "/login", url.Values{
+		"username": []string{mockUsername},
+		"password": []string{mockPassword},
+	}, nil)
+	if cookieNamed(resp, "WonkAuth") == nil {
+		t.Error("password login after passkeys: missing auth cookie")
+	}
+}
+
+// Test handles the following flow: User changes their password while logged in, then
+// forgets it and sets a new one from the emailed reset link
+func TestPasswordChangeAndReset(t *testing.T) {
+	IntegrationTest(t)
+	mailDir := t.TempDir()
+	endpoint := startTestServerWithEnv(t, func(s string) string {
+		switch s {
+		case "MAIL_SENDER":
+			return "file"
+		case "MAIL_DIR":
+			return mailDir
+		case "APP_BASE_URL":
+			return "https://wonk.example/"
+		default:
+			return getTestSecrets(s)
+		}
+	})
+	mockUsername := "passwordUser"
+	mockPassword := "mockPassword!"
+	newPassword := "a much longer passphrase than before"
+	resetPassword := "reset to something else entirely"
+	client := http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	do := func(method, path string, form url.Values, cookie *http.Cookie) (*http.Response, string) {
+		req, err := http.NewRequest(method, endpoint+path, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("hx-request", "true")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+	login := func(password string) *http.Cookie {
+		resp, _ := do(http.MethodPost, "/login", url.Values{"username": []string{mockUsername}, "password": []string{password}}, nil)
+		for _, c := range resp.Cookies() {
+			if c.Name == "WonkAuth" && c.MaxAge >= 0 {
+				return c
+			}
+		}
+		return nil
+	}
+	isLoggedIn := func(cookie *http.Cookie) bool {
+		resp, _ := do(http.MethodGet, "/finance", nil, cookie)
+		return resp.StatusCode == http.StatusOK
+	}
+
+	resp, _ := do(http.MethodPost, "/signup", url.Values{"username": []string{mockUsername}, "password": []string{"password123"}}, nil)
+	if resp.StatusCode != 422 {
+		t.Errorf("sign up with a breached password: expected 422, got %d", resp.StatusCode)
+	}
+	resp, _ = do(http.MethodPost, "/signup", url.Values{"username": []string{mockUsername}, "password": []string{mockPassword}}, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("sign up: expected 200, got %d", resp.StatusCode)
+	}
+	laptop := login(mockPassword)
+	phone := login(mockPassword)
+	if laptop == nil || phone == nil {
+		t.Fatal("login: missing auth cookie")
+	}
+	csrfToken := pageCsrfToken(t, endpoint, laptop)
+
+	// Change it while logged in
+	resp, _ = do(http.MethodPost, "/finance/password", url.Values{
+		"csrf_token":       []string{csrfToken},
+		"current_password": []string{"notMyPassword!"},
+		"password":         []string{newPassword},
+		"confirm_password": []string{newPassword},
+	}, laptop)
+	if resp.StatusCode != 422 {
+		t.Errorf("wrong current password: expected 422, got %d", resp.StatusCode)
+	}
+	resp, _ = do(http.MethodPost, "/finance/password", url.Values{
+		"csrf_token":       []string{csrfToken},
+		"current_password": []string{mockPassword},
+		"password":         []string{newPassword},
+		"confirm_password": []string{"something else"},
+	}, laptop)
+	if resp.StatusCode != 422 {
+		t.Errorf("mismatched confirmation: expected 422, got %d", resp.StatusCode)
+	}
+	// Each failure delays the next attempt
+	time.Sleep(1100 * time.Millisecond)
+	resp, body := do(http.MethodPost, "/finance/password", url.Values{
+		"csrf_token":       []string{csrfToken},
+		"current_password": []string{mockPassword},
+		"password":         []string{newPassword},
+		"confirm_password": []string{newPassword},
+	}, laptop)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "passwordChanged") {
+		t.Fatalf("change password: expected 200, got %d", resp.StatusCode)
+	}
+	if !isLoggedIn(laptop) || isLoggedIn(phone) {
+		t.Error("change password: expected only the session that changed it to stay logged in")
+	}
+	if login(mockPassword) != nil {
+		t.Error("expected the old password to stop working")
+	}
+	// The delay doubles with the second failure
+	time.Sleep(2100 * time.Millisecond)
+	if login(newPassword) == nil {
+		t.Fatal("expected the new password to work")
+	}
+
+	// Reset links go to the email in the notification settings
+	resp, _ = do(http.MethodPut, "/finance/notification-settings", url.Values{
+		"csrf_token":    []string{csrfToken},
+		"email":         []string{"password@example.com"},
+		"digestWeekday": []string{"1"},
+	}, laptop)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("notification settings: expected 200, got %d", resp.StatusCode)
+	}
+	resp, body = do(http.MethodPost, "/login/reset", url.Values{"username": []string{"nobodyHere"}}, nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "resetSent") {
+		t.Errorf("reset for an unknown user: expected the same response, got %d", resp.StatusCode)
+	}
+	resp, body = do(http.MethodPost, "/login/reset", url.Values{"username": []string{mockUsername}}, nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "resetSent") {
+		t.Fatalf("reset: expected 200, got %d", resp.StatusCode)
+	}
+	link := readResetLink(t, mailDir)
+	resetUrl, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// From the config, not the Host header of the request
+	if resetUrl.Scheme != "https" || resetUrl.Host != "wonk.example" {
+		t.Errorf("reset link: expected the configured base url, got %s", link)
+	}
+	token := resetUrl.Query().Get("token")
+	resp, body = do(http.MethodGet, resetUrl.RequestURI(), nil, nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `name="token"`) || resp.Header.Get("Referrer-Policy") != "no-referrer" {
+		t.Fatalf("reset link: expected the new password form, got %d", resp.StatusCode)
+	}
+	resp, _ = do(http.MethodPost, "/login/reset/confirm", url.Values{
+		"token":            []string{token},
+		"password":         []string{"qwertyuiop"},
+		"confirm_password": []string{"qwertyuiop"},
+	}, nil)
+	if resp.StatusCode != 422 {
+		t.Errorf("reset to a breached password: expected 422, got %d", resp.StatusCode)
+	}
+	resp, body = do(http.MethodPost, "/login/reset/confirm", url.Values{
+		"token":            []string{token},
+		"password":         []string{resetPassword},
+		"confirm_password": []string{resetPassword},
+	}, nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "resetDone") {
+		t.Fatalf("reset confirm: expected 200, got %d", resp.StatusCode)
+	}
+	resp, body = do(http.MethodPost, "/login/reset/confirm", url.Values{
+		"token":            []string{token},
+		"password":         []string{"yet another passphrase"},
+		"confirm_password": []string{"yet another passphrase"},
+	}, nil)
+	if resp.StatusCode != 422 || !strings.Contains(body, "resetInvalid") {
+		t.Errorf("reused link: expected 422, got %d", resp.StatusCode)
+	}
+	if isLoggedIn(laptop) {
+		t.Error("reset: expected every session to be logged out")
+	}
+	if login(resetPassword) == nil {
+		t.Error("expected the reset password to work")
+	}
+}
+
+func TestOidcLogin(t *testing.T) {
+	IntegrationTest(t)
+	mock, err := oidctest.NewProvider(t, "wonk", "mockClientSecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := startTestServerWithEnv(t, oidcTestEnv(mock, false))
+	mockUsername := "oidcUser"
+	mockPassword := "mockPassword!"
+	do := oidcTestClient(t, endpoint)
+
+	resp, body := do(http.MethodGet, "/login", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `href="/login/oidc"`) {
+		t.Fatalf("login page: expected the single sign-on button, got %d", resp.StatusCode)
+	}
+
+	// Nobody has linked this subject yet
+	resp, body = oidcLogin(t, do, mock)
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(body, "Linked Accounts") {
+		t.Fatalf("unlinked login: expected 403, got %d", resp.StatusCode)
+	}
+	if authCookie(resp) != nil {
+		t.Fatal("unlinked login: expected no auth cookie")
+	}
+
+	// The state in the callback has to match the cookie
+	resp, _ = do(http.MethodGet, "/login/oidc", nil)
+	oidcCookie := oidcRequestCookie(t, resp)
+	callback, err := mock.Authorize(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := callback.Query()
+	query.Set("state", "notTheState")
+	resp, _ = do(http.MethodGet, callback.Path+"?"+query.Encode(), nil, oidcCookie)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("wrong state: expected 400, got %d", resp.StatusCode)
+	}
+
+	// Link it from an account logged in with a password
+	resp, _ = do(http.MethodPost, "/signup", url.Values{"username": []string{mockUsername}, "password": []string{mockPassword}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("sign up: expected 200, got %d", resp.StatusCode)
+	}
+	resp, _ = do(http.MethodPost, "/login", url.Values{"username": []string{mockUsername}, "password": []string{mockPassword}})
+	cookie := authCookie(resp)
+	if cookie == nil {
+		t.Fatal("login: missing auth cookie")
+	}
+	csrfToken := pageCsrfToken(t, endpoint, cookie)
+	resp, _ = do(http.MethodPost, "/finance/oidc/link", url.Values{"csrf_token": []string{csrfToken}}, cookie)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("HX-Redirect") == "" {
+		t.Fatalf("link: expected a redirect to the provider, got %d", resp.StatusCode)
+	}
+	oidcCookie = oidcRequestCookie(t, resp)
+	callback, err = mock.Authorize(resp.Header.Get("HX-Redirect"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = do(http.MethodGet, callback.RequestURI(), nil, oidcCookie, cookie)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/finance/oidc?linked=true" {
+		t.Fatalf("link callback: expected a redirect to linked accounts, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	resp, body = do(http.MethodGet, "/finance/oidc?linked=true", nil, cookie)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "oidcLinked") || !strings.Contains(body, mock.User.Email) {
+		t.Fatalf("linked accounts: expected the new link, got %d", resp.StatusCode)
+	}
+
+	// Now the provider logs in as the account
+	resp, _ = oidcLogin(t, do, mock)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/home" {
+		t.Fatalf("linked login: expected a redirect home, got %d", resp.StatusCode)
+	}
+	oidcAuth := authCookie(resp)
+	if oidcAuth == nil {
+		t.Fatal("linked login: missing auth cookie")
+	}
+	resp, _ = do(http.MethodGet, "/finance", nil, oidcAuth)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("linked login: expected to be logged in, got %d", resp.StatusCode)
+	}
+
+	// Replaying the callback fails since the provider only takes a code once
+	resp, _ = do(http.MethodGet, "/login/oidc", nil)
+	oidcCookie = oidcRequestCookie(t, resp)
+	callback, err = mock.Authorize(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = do(http.MethodGet, callback.RequestURI(), nil, oidcCookie)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login: expected 302, got %d", resp.StatusCode)
+	}
+	resp, _ = do(http.MethodGet, callback.RequestURI(), nil, oidcCookie)
+	if resp.StatusCode == http.StatusFound || authCookie(resp) != nil {
+		t.Errorf("replayed callback: expected no login, got %d", resp.StatusCode)
+	}
+
+	// Unlinking it turns the provider login off again
+	resp, body = do(http.MethodGet, "/finance/oidc", nil, cookie)
+	match := regexp.MustCompile(`hx-delete="/finance/oidc/(\d+)"`).FindStringSubmatch(body)
+	if resp.StatusCode != http.StatusOK || match == nil {
+		t.Fatalf("linked accounts: expected an unlink form, got %d", resp.StatusCode)
+	}
+	resp, _ = do(http.MethodDelete, "/finance/oidc/"+match[1], url.Values{"csrf_token": []string{csrfToken}}, cookie)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unlink: expected 200, got %d", resp.StatusCode)
+	}
+	resp, _ = oidcLogin(t, do, mock)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unlinked login: expected 403, got %d", resp.StatusCode)
+	}
+}
+
+func TestOidcAutoProvision(t *testing.T) {
+	IntegrationTest(t)
+	mock, err := oidctest.NewProvider(t, "wonk", "mockClientSecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.User = oidctest.User{Subject: "provisioned-subject", Email: "provisioned@example.com", EmailVerified: true}
+	endpoint := startTestServerWithEnv(t, oidcTestEnv(mock, true))
+	do := oidcTestClient(t, endpoint)
+
+	resp, _ := oidcLogin(t, do, mock)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/home" {
+		t.Fatalf("first login: expected a redirect home, got %d", resp.StatusCode)
+	}
+	cookie := authCookie(resp)
+	if cookie == nil {
+		t.Fatal("first login: missing auth cookie")
+	}
+	resp, body := do(http.MethodGet, "/finance/oidc", nil, cookie)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, mock.User.Email) {
+		t.Fatalf("linked accounts: expected the provisioned link, got %d", resp.StatusCode)
+	}
+	resp, _ = oidcLogin(t, do, mock)
+	if resp.StatusCode != http.StatusFound || authCookie(resp) == nil {
+		t.Errorf("second login: expected a redirect home, got %d", resp.StatusCode)
+	}
+}
+
+func oidcTestEnv(mock *oidctest.Provider, autoProvision bool) func(string) string {
+	return func(s string) string {
+		switch s {
+		case "OIDC_ISSUER":
+			return mock.URL()
+		case "OIDC_CLIENT_ID":
+			return "wonk"
+		case "OIDC_CLIENT_SECRET":
+			return "mockClientSecret"
+		case "OIDC_AUTO_PROVISION":
+			return strconv.FormatBool(autoProvision)
+		default:
+			return getTestSecrets(s)
+		}
+	}
+}
+
+// Returns a helper that sends htmx requests without following redirects, the cookies
+// are secure so they're added by hand
+func oidcTestClient(t *testing.T, endpoint string) func(method, path string, form url.Values, cookies ...*http.Cookie) (*http.Response, string) {
+	client := http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return func(method, path string, form url.Values, cookies ...*http.Cookie) (*http.Response, string) {
+		req, err := http.NewRequest(method, endpoint+path, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if method != http.MethodGet || strings.HasPrefix(path, "/finance") {
+			req.Header.Set("hx-request", "true")
+		}
+		// Sent as a header like the hx-headers on the page, a DELETE body isn't parsed as a form
+		if token := form.Get("csrf_token"); token != "" {
+			req.Header.Set("X-CSRF-Token", token)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+}
+
+// Goes from the login button through the provider and back to the callback
+func oidcLogin(t *testing.T, do func(string, string, url.Values, ...*http.Cookie) (*http.Response, string), mock *oidctest.Provider) (*http.Response, string) {
+	t.Helper()
+	resp, _ := do(http.MethodGet, "/login/oidc", nil)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login/oidc: expected 302, got %d", resp.StatusCode)
+	}
+	oidcCookie := oidcRequestCookie(t, resp)
+	callback, err := mock.Authorize(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return do(http.MethodGet, callback.RequestURI(), nil, oidcCookie)
+}
+
+func oidcRequestCookie(t *testing.T, resp *http.Response) *http.Cookie {
+	t.Helper()
+	for _, c := range resp.Cookies() {
+		if c.Name == "WonkOidc" && c.MaxAge >= 0 {
+			return c
+		}
+	}
+	t.Fatal("missing oidc request cookie")
+	return nil
+}
+
+func authCookie(resp *http.Response) *http.Cookie {
+	for _, c := range resp.Cookies() {
+		if c.Name == "WonkAuth" && c.MaxAge >= 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// Reads the link out of the text part of the one email in dir, waiting for it since reset
+// emails are sent after the response
+func readResetLink(t *testing.T, dir string) string {
+	t.Helper()
+	var files []string
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		var err error
+		files, err = filepath.Glob(filepath.Join(dir, "*.eml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected one email, got %d", len(files))
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal("no text part with a link:", err)
+		}
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), "text/plain") {
+			continue
+		}
+		text, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		link := regexp.MustCompile(`https?://\S+/login/reset/confirm\?token=\S+`).FindString(string(text))
+		if link == "" {
+			t.Fatal("no link in the email")
+		}
+		return link
+	}
+}
+
+// Reads the csrf token from the hx-headers attribute of the finance page
+func pageCsrfToken(t *testing.T, endpoint string, cookie *http.Cookie) string {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, endpoint+"/finance", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookie)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := regexp.MustCompile(`X-CSRF-Token":"([A-Za-z0-9_-]+)"`).FindStringSubmatch(html.UnescapeString(string(body)))
+	if match == nil {
+		t.Fatal("csrf token not found in page")
+	}
+	return match[1]
+}
+
+// Starts the server on the test db once the previous test's server has let go of the port
+func startTestServer(t *testing.T) string {
+	t.Helper()
+	return startTestServerWithEnv(t, getTestSecrets)
+}
+
+func startTestServerWithEnv(t *testing.T, getEnv func(string) string) string {
+	t.Helper()
+	addr := "localhost:8070"
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		l, err := net.Listen("tcp", addr)
+		if err == nil {
+			l.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("port still in use:", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	endpoint := "http://" + addr
+	go server.Run(ctx, getEnv, nil, []string{"--exclude-env", "-logfmt=devlog", "--test-db"})
+	waitForReady(ctx, time.Second*5, endpoint+"/health")
+	return endpoint
+}
+
 func getTestSecrets(s string) string {
 	switch s {
 	case "COOKIE_SECRET_KEY":